@@ -0,0 +1,291 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+)
+
+// PrefixReplacement describes the replacement of the key prefix of the tables
+// attached by DB.AttachSharedTables: the keys of the tables that have
+// ContentPrefix are exposed with SyntheticPrefix in its place.
+type PrefixReplacement = base.PrefixReplacement
+
+// AttachSharedTables attaches the shared sstables of another store, as
+// produced by ScanInternal in skip-shared iteration mode, to the DB, exposing
+// their keys under a different key prefix. This allows the contents of a key
+// range of another store, eg, of a checkpoint on shared storage, to be
+// restored without reading or rewriting the sstables.
+//
+// The sstables must be the shared sstables visited by a ScanInternal bounded
+// to the content key space of the prefix replacement, ie, the keys with the
+// content prefix; the keys of the sstables outside the content key space are
+// hidden. The point keys and range keys above the shared levels, emitted by
+// the same ScanInternal, should be written to the DB after the attach, with
+// the content prefix replaced.
+//
+// The tables are attached to the levels they were read from, with their
+// original sequence numbers, and the sequence number of the DB is advanced
+// past them. The synthetic key space must be empty in the DB, and the DB must
+// not have open snapshots, as these could observe the attached keys. The
+// attached tables are read from shared storage, and are rewritten into local
+// tables as they are compacted. The comparer must order keys bytewise over
+// the replaced prefixes. The DB must be at format major version
+// FormatPrefixReplacement or later.
+//
+// This is an experimental API.
+func (d *DB) AttachSharedTables(ssts []SharedSSTMeta, p PrefixReplacement) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if v := d.FormatMajorVersion(); v < FormatPrefixReplacement {
+		return errors.Errorf(
+			"pebble: prefix replacements require at least format major version %d (current: %d)",
+			FormatPrefixReplacement, v,
+		)
+	}
+	if len(ssts) == 0 {
+		return nil
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	p = PrefixReplacement{
+		ContentPrefix:   append([]byte(nil), p.ContentPrefix...),
+		SyntheticPrefix: append([]byte(nil), p.SyntheticPrefix...),
+	}
+	if err := attachValidate(d.cmp, ssts, &p); err != nil {
+		return err
+	}
+
+	objs := make([]objstorage.SharedObjectToAttach, len(ssts))
+	for i := range ssts {
+		backing, err := ssts[i].Backing.Get()
+		if err != nil {
+			return err
+		}
+		objs[i] = objstorage.SharedObjectToAttach{FileType: fileTypeTable, Backing: backing}
+	}
+	d.mu.Lock()
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	for i := range objs {
+		objs[i].FileNum = d.mu.versions.getNextFileNum().DiskFileNum()
+	}
+	d.mu.Unlock()
+
+	objMetas, err := d.objProvider.AttachSharedObjects(objs)
+	if err != nil {
+		return err
+	}
+	meta := make([]*fileMetadata, len(ssts))
+	var largestSeqNum uint64
+	for i := range ssts {
+		meta[i] = attachFileMetadata(d.cmp, &ssts[i], &p, objMetas[i])
+		if meta[i].LargestSeqNum > largestSeqNum {
+			largestSeqNum = meta[i].LargestSeqNum
+		}
+	}
+	// Make the attached objects durable before they're referenced by the
+	// MANIFEST.
+	if err := d.objProvider.Sync(); err != nil {
+		d.attachCleanup(meta)
+		return err
+	}
+
+	// Allocate sequence numbers so that the sequence number of the DB advances
+	// past the sequence numbers of the attached tables.
+	count := uint64(1)
+	if next := d.mu.versions.logSeqNum.Load(); largestSeqNum >= next {
+		count = largestSeqNum - next + 1
+	}
+	if count > math.MaxUint32 {
+		d.attachCleanup(meta)
+		return errors.Errorf("pebble: cannot attach tables with sequence number %d", largestSeqNum)
+	}
+
+	lower, upper := p.SyntheticBounds()
+	bounds := (&fileMetadata{}).ExtendPointKeyBounds(
+		d.cmp,
+		base.MakeInternalKey(lower, InternalKeySeqNumMax, InternalKeyKindMax),
+		base.MakeExclusiveSentinelKey(InternalKeyKindRangeDelete, upper),
+	)
+	var mut *memTable
+	prepare := func(seqNum uint64) {
+		// Note that d.commit.mu is held by commitPipeline when calling prepare.
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if !d.mu.snapshots.empty() {
+			err = errors.New("pebble: cannot attach tables while snapshots are open")
+			return
+		}
+		for _, m := range d.mu.mem.queue {
+			iter := m.newIter(nil)
+			rangeDelIter := m.newRangeDelIter(nil)
+			rkeyIter := m.newRangeKeyIter(nil)
+			if overlapWithIterator(iter, &rangeDelIter, rkeyIter, bounds, d.cmp) {
+				err = errors.Errorf("pebble: cannot attach tables: memtable overlaps [%q, %q)", lower, upper)
+			}
+			err = firstError(err, iter.Close())
+			if rangeDelIter != nil {
+				err = firstError(err, rangeDelIter.Close())
+			}
+			if rkeyIter != nil {
+				err = firstError(err, rkeyIter.Close())
+			}
+			if err != nil {
+				return
+			}
+		}
+		// New writes with higher sequence numbers may be concurrently
+		// committed. Prevent the mutable memtable from being flushed until
+		// the manifest lock is acquired, as in ingestion.
+		mut = d.mu.mem.mutable
+		mut.writerRef()
+	}
+	apply := func(seqNum uint64) {
+		if err != nil {
+			return
+		}
+		if seqNum+count-1 < largestSeqNum {
+			mut.writerUnref()
+			err = errors.AssertionFailedf("pebble: allocated sequence number %d precedes attached sequence number %d",
+				errors.Safe(seqNum+count-1), errors.Safe(largestSeqNum))
+			return
+		}
+		err = d.attachApply(jobID, ssts, meta, lower, upper, mut)
+	}
+	d.commit.AllocateSeqNum(int(count), prepare, apply)
+	if err != nil {
+		d.attachCleanup(meta)
+	}
+	return err
+}
+
+// attachCleanup removes the objects attached for a failed attach. The removal
+// is synced, as the file numbers of the objects may otherwise be reused after
+// a restart, before the removal is made durable.
+func (d *DB) attachCleanup(meta []*fileMetadata) {
+	err := ingestCleanup(d.objProvider, meta)
+	if err == nil {
+		err = d.objProvider.Sync()
+	}
+	if err != nil {
+		d.opts.Logger.Infof("attach cleanup failed: %v", err)
+	}
+}
+
+// attachApply adds the attached tables to the LSM, at the levels they were read
+// from, provided the synthetic key space [lower, upper) is empty.
+func (d *DB) attachApply(
+	jobID int, ssts []SharedSSTMeta, meta []*fileMetadata, lower, upper []byte, mut *memTable,
+) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.versions.logLock()
+	mut.writerUnref()
+
+	current := d.mu.versions.currentVersion()
+	for level := 0; level < numLevels; level++ {
+		if overlaps := current.Overlaps(level, d.cmp, lower, upper, true /* exclusiveEnd */); !overlaps.Empty() {
+			d.mu.versions.logUnlock()
+			return errors.Errorf("pebble: cannot attach tables: L%d overlaps [%q, %q)", level, lower, upper)
+		}
+	}
+	ve := &versionEdit{NewFiles: make([]newFileEntry, len(meta))}
+	metrics := make(map[int]*LevelMetrics)
+	for i, m := range meta {
+		level := int(ssts[i].Level)
+		ve.NewFiles[i] = newFileEntry{Level: level, Meta: m}
+		levelMetrics := metrics[level]
+		if levelMetrics == nil {
+			levelMetrics = &LevelMetrics{}
+			metrics[level] = levelMetrics
+		}
+		levelMetrics.NumFiles++
+		levelMetrics.Size += int64(m.Size)
+		levelMetrics.BytesIngested += m.Size
+		levelMetrics.TablesIngested++
+	}
+	if err := d.mu.versions.logAndApply(jobID, ve, metrics, false /* forceRotation */, func() []compactionInfo {
+		return d.getInProgressCompactionInfoLocked(nil)
+	}); err != nil {
+		return err
+	}
+	d.updateReadStateLocked(d.opts.DebugCheck)
+	d.updateTableStatsLocked(ve.NewFiles)
+	d.maybeScheduleCompaction()
+	return nil
+}
+
+// attachValidate verifies that the shared sstables to be attached lie within
+// the content key space of the prefix replacement, and that the sstables of
+// each level don't overlap.
+func attachValidate(cmp Compare, ssts []SharedSSTMeta, p *PrefixReplacement) error {
+	lower, upper := p.ContentBounds()
+	byLevel := make(map[uint8][]*SharedSSTMeta)
+	for i := range ssts {
+		sst := &ssts[i]
+		if int(sst.Level) < sharedLevelsStart || int(sst.Level) >= numLevels {
+			return errors.Errorf("pebble: cannot attach table %s from level %d", sst.fileNum, sst.Level)
+		}
+		if cmp(sst.Smallest.UserKey, lower) < 0 {
+			return errors.Errorf("pebble: table %s smallest key %q precedes content prefix %q",
+				sst.fileNum, sst.Smallest.UserKey, p.ContentPrefix)
+		}
+		if c := cmp(sst.Largest.UserKey, upper); c > 0 || (c == 0 && !sst.Largest.IsExclusiveSentinel()) {
+			return errors.Errorf("pebble: table %s largest key %q follows content prefix %q",
+				sst.fileNum, sst.Largest.UserKey, p.ContentPrefix)
+		}
+		byLevel[sst.Level] = append(byLevel[sst.Level], sst)
+	}
+	for _, files := range byLevel {
+		sort.Slice(files, func(i, j int) bool {
+			return cmp(files[i].Smallest.UserKey, files[j].Smallest.UserKey) < 0
+		})
+		for i := 1; i < len(files); i++ {
+			if sstableKeyCompare(cmp, files[i-1].Largest, files[i].Smallest) >= 0 {
+				return errors.Errorf("pebble: attached tables %s and %s overlap",
+					files[i-1].fileNum, files[i].fileNum)
+			}
+		}
+	}
+	return nil
+}
+
+// attachFileMetadata returns the metadata of the table attached for the given
+// shared sstable, with its bounds translated into the synthetic key space.
+func attachFileMetadata(
+	cmp Compare, sst *SharedSSTMeta, p *PrefixReplacement, objMeta objstorage.ObjectMetadata,
+) *fileMetadata {
+	translate := func(k InternalKey) InternalKey {
+		return InternalKey{UserKey: p.ReplaceResult(nil, k.UserKey), Trailer: k.Trailer}
+	}
+	m := &fileMetadata{
+		FileNum:           objMeta.DiskFileNum.FileNum(),
+		Size:              sst.Size,
+		CreationTime:      time.Now().Unix(),
+		SmallestSeqNum:    sst.SmallestSeqNum,
+		LargestSeqNum:     sst.LargestSeqNum,
+		PrefixReplacement: p,
+	}
+	if len(sst.SmallestPointKey.UserKey) > 0 {
+		m.ExtendPointKeyBounds(cmp, translate(sst.SmallestPointKey), translate(sst.LargestPointKey))
+	}
+	if len(sst.SmallestRangeKey.UserKey) > 0 {
+		m.ExtendRangeKeyBounds(cmp, translate(sst.SmallestRangeKey), translate(sst.LargestRangeKey))
+	}
+	m.InitPhysicalBacking()
+	return m
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachSharedTables(t *testing.T) {
+	sharedStorage := shared.NewInMem()
+	open := func(fs vfs.FS, creatorID uint64) *DB {
+		opts := &Options{
+			FS:                          fs,
+			DisableAutomaticCompactions: true,
+			FormatMajorVersion:          FormatRangeKeys,
+			Logger:                      panicLogger{},
+		}
+		opts.Experimental.SharedStorage = sharedStorage
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		require.NoError(t, d.SetCreatorID(creatorID))
+		return d
+	}
+
+	// Write keys with and without the content prefix to the source DB, and
+	// compact them into shared tables in L6.
+	src := open(vfs.NewMem(), 1)
+	defer func() { require.NoError(t, src.Close()) }()
+	for _, prefix := range []string{"a/", "src/", "z/"} {
+		for i := 0; i < 10; i++ {
+			k := fmt.Sprintf("%s%02d", prefix, i)
+			require.NoError(t, src.Set([]byte(k), []byte("v-"+k), nil))
+		}
+	}
+	require.NoError(t, src.Compact([]byte("a"), []byte("zz"), false))
+
+	var ssts []SharedSSTMeta
+	require.NoError(t, src.ScanInternal(context.Background(), []byte("src/"), []byte("src0"),
+		func(key *InternalKey, value LazyValue) error {
+			t.Fatalf("unexpected point key %s", key)
+			return nil
+		},
+		func(start, end []byte, seqNum uint64) error { return nil },
+		func(start, end []byte, keys []keyspan.Key) error { return nil },
+		func(sst *SharedSSTMeta) error {
+			ssts = append(ssts, *sst)
+			return nil
+		},
+	))
+	require.NotEmpty(t, ssts)
+	defer func() {
+		for i := range ssts {
+			ssts[i].Backing.Close()
+		}
+	}()
+
+	fs := vfs.NewMem()
+	d := open(fs, 2)
+	require.NoError(t, d.Set([]byte("b"), []byte("local"), nil))
+	p := PrefixReplacement{ContentPrefix: []byte("src/"), SyntheticPrefix: []byte("dst/")}
+
+	// Attaching requires FormatPrefixReplacement.
+	require.Error(t, d.AttachSharedTables(ssts, p))
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatPrefixReplacement))
+
+	// Attaching fails while a snapshot is open, and if the tables don't lie
+	// within the content key space.
+	snap := d.NewSnapshot()
+	require.Error(t, d.AttachSharedTables(ssts, p))
+	require.NoError(t, snap.Close())
+	require.Error(t, d.AttachSharedTables(ssts, PrefixReplacement{
+		ContentPrefix: []byte("src/1"), SyntheticPrefix: []byte("dst/"),
+	}))
+
+	require.NoError(t, d.AttachSharedTables(ssts, p))
+	// The synthetic key space is no longer empty.
+	require.Error(t, d.AttachSharedTables(ssts, p))
+
+	expect := func(d *DB) {
+		iter := d.NewIter(nil)
+		var got []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			got = append(got, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		want := []string{"b=local"}
+		for i := 0; i < 10; i++ {
+			want = append(want, fmt.Sprintf("dst/%02d=v-src/%02d", i, i))
+		}
+		require.Equal(t, want, got)
+
+		v, closer, err := d.Get([]byte("dst/05"))
+		require.NoError(t, err)
+		require.Equal(t, "v-src/05", string(v))
+		require.NoError(t, closer.Close())
+		_, _, err = d.Get([]byte("dst0"))
+		require.ErrorIs(t, err, ErrNotFound)
+	}
+	expect(d)
+	// Writes after the attach shadow the attached keys.
+	require.NoError(t, d.Set([]byte("dst/10"), []byte("v-src/10"), nil))
+	require.NoError(t, d.Delete([]byte("dst/10"), nil))
+
+	// The prefix replacement persists across restarts.
+	require.NoError(t, d.Close())
+	d = open(fs, 2)
+	expect(d)
+
+	// Compactions rewrite the attached tables in the synthetic key space.
+	require.NoError(t, d.Compact([]byte("a"), []byte("zz"), false))
+	d.mu.Lock()
+	current := d.mu.versions.currentVersion()
+	for level := range current.Levels {
+		iter := current.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			require.Nil(t, f.PrefixReplacement)
+		}
+	}
+	d.mu.Unlock()
+	expect(d)
+	require.NoError(t, d.Close())
+}
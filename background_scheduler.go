@@ -0,0 +1,139 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"time"
+)
+
+// BackgroundWorkKind identifies a kind of background work that is admitted
+// through a BackgroundWorkScheduler.
+type BackgroundWorkKind int8
+
+const (
+	// BackgroundWorkFlush is the flush of one or more memtables.
+	BackgroundWorkFlush BackgroundWorkKind = iota
+	// BackgroundWorkCompaction is an automatic or manual compaction.
+	BackgroundWorkCompaction
+
+	numBackgroundWorkKinds
+)
+
+// String implements fmt.Stringer.
+func (k BackgroundWorkKind) String() string {
+	switch k {
+	case BackgroundWorkFlush:
+		return "flush"
+	case BackgroundWorkCompaction:
+		return "compaction"
+	default:
+		return "unknown"
+	}
+}
+
+// BackgroundWorkScheduler coordinates the flushes and compactions of one or
+// more DBs. When many DBs share a process or a disk, their background work
+// tends to synchronize (eg, memtables filling up at the same rate), resulting
+// in bursts of I/O. Sharing a single BackgroundWorkScheduler between the DBs
+// allows their work to be staggered.
+//
+// A DB consults the scheduler after it has decided to run a flush or
+// compaction, but before it starts the work. The scheduler may delay the
+// work, but must eventually admit it: a DB cannot complete Close while a flush
+// or compaction is waiting to be admitted.
+type BackgroundWorkScheduler interface {
+	// Admit blocks until the background work of the given kind may begin. The
+	// returned function must be called exactly once, when the work completes.
+	Admit(kind BackgroundWorkKind) (done func())
+}
+
+// Use a default implementation for the scheduler to avoid excessive nil
+// checks in the code.
+type defaultBackgroundWorkScheduler struct{}
+
+func (defaultBackgroundWorkScheduler) Admit(BackgroundWorkKind) func() {
+	return func() {}
+}
+
+// NewStaggeredScheduler returns a BackgroundWorkScheduler that limits the
+// number of concurrent flushes and compactions across all of the DBs sharing
+// it to maxFlushes and maxCompactions respectively, and that spaces the starts
+// of successive pieces of work of the same kind at least minInterval apart. A
+// limit that is not positive is treated as unlimited.
+func NewStaggeredScheduler(
+	maxFlushes, maxCompactions int, minInterval time.Duration,
+) BackgroundWorkScheduler {
+	s := &staggeredScheduler{minInterval: minInterval}
+	s.mu.cond.L = &s.mu.Mutex
+	s.mu.kinds[BackgroundWorkFlush].limit = maxFlushes
+	s.mu.kinds[BackgroundWorkCompaction].limit = maxCompactions
+	return s
+}
+
+type staggeredScheduler struct {
+	minInterval time.Duration
+	// timeNow and sleep may be overridden in tests.
+	timeNow func() time.Time
+	sleep   func(time.Duration)
+
+	mu struct {
+		sync.Mutex
+		cond  sync.Cond
+		kinds [numBackgroundWorkKinds]struct {
+			limit   int
+			running int
+			// nextStart is the earliest time at which the next piece of work
+			// may start.
+			nextStart time.Time
+		}
+	}
+}
+
+var _ BackgroundWorkScheduler = (*staggeredScheduler)(nil)
+
+func (s *staggeredScheduler) now() time.Time {
+	if s.timeNow != nil {
+		return s.timeNow()
+	}
+	return time.Now()
+}
+
+// Admit is part of the BackgroundWorkScheduler interface.
+func (s *staggeredScheduler) Admit(kind BackgroundWorkKind) (done func()) {
+	s.mu.Lock()
+	k := &s.mu.kinds[kind]
+	for k.limit > 0 && k.running >= k.limit {
+		s.mu.cond.Wait()
+	}
+	k.running++
+	// Reserve a start time for this work before dropping the mutex, so that
+	// concurrent callers are spaced out rather than all waking up at once.
+	now := s.now()
+	start := k.nextStart
+	if start.Before(now) {
+		start = now
+	}
+	k.nextStart = start.Add(s.minInterval)
+	s.mu.Unlock()
+
+	if wait := start.Sub(now); wait > 0 {
+		if s.sleep != nil {
+			s.sleep(wait)
+		} else {
+			time.Sleep(wait)
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			k.running--
+			s.mu.cond.Broadcast()
+		})
+	}
+}
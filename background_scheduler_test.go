@@ -0,0 +1,132 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaggeredScheduler(t *testing.T) {
+	s := NewStaggeredScheduler(1, 2, time.Second).(*staggeredScheduler)
+	now := time.Unix(0, 0)
+	var slept []time.Duration
+	s.timeNow = func() time.Time { return now }
+	s.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	// Successive starts of the same kind are spaced by the minimum interval.
+	c1 := s.Admit(BackgroundWorkCompaction)
+	c2 := s.Admit(BackgroundWorkCompaction)
+	require.Equal(t, []time.Duration{time.Second}, slept)
+
+	// Kinds are staggered independently.
+	f1 := s.Admit(BackgroundWorkFlush)
+	require.Equal(t, []time.Duration{time.Second}, slept)
+
+	// The compaction limit is reached, so a third compaction must wait for one
+	// of the others to complete.
+	admitted := make(chan struct{})
+	go func() {
+		s.Admit(BackgroundWorkCompaction)()
+		close(admitted)
+	}()
+	select {
+	case <-admitted:
+		t.Fatal("compaction admitted above limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c1()
+	// Calling done more than once has no effect.
+	c1()
+	<-admitted
+	c2()
+	f1()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.mu.kinds {
+		require.Equal(t, 0, s.mu.kinds[k].running)
+	}
+}
+
+type recordingScheduler struct {
+	mu      sync.Mutex
+	running [numBackgroundWorkKinds]int
+	max     [numBackgroundWorkKinds]int
+	count   [numBackgroundWorkKinds]int
+}
+
+func (s *recordingScheduler) Admit(kind BackgroundWorkKind) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count[kind]++
+	s.running[kind]++
+	if s.running[kind] > s.max[kind] {
+		s.max[kind] = s.running[kind]
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running[kind]--
+	}
+}
+
+func TestBackgroundWorkSchedulerShared(t *testing.T) {
+	rec := &recordingScheduler{}
+	s := NewStaggeredScheduler(1, 1, 0)
+	sched := schedulerFunc(func(kind BackgroundWorkKind) func() {
+		// Admit through the staggered scheduler first so that the recording
+		// scheduler observes the resulting concurrency.
+		done := s.Admit(kind)
+		recDone := rec.Admit(kind)
+		return func() {
+			recDone()
+			done()
+		}
+	})
+
+	var dbs []*DB
+	for i := 0; i < 3; i++ {
+		opts := &Options{FS: vfs.NewMem(), L0CompactionThreshold: 1}
+		opts.Experimental.BackgroundWorkScheduler = sched
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		dbs = append(dbs, d)
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range dbs {
+		wg.Add(1)
+		go func(d *DB) {
+			defer wg.Done()
+			for _, k := range []string{"a", "b", "c"} {
+				require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+				require.NoError(t, d.Flush())
+			}
+			require.NoError(t, d.Compact([]byte("a"), []byte("d"), false))
+		}(d)
+	}
+	wg.Wait()
+	for _, d := range dbs {
+		require.NoError(t, d.Close())
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	require.LessOrEqual(t, 9, rec.count[BackgroundWorkFlush])
+	require.LessOrEqual(t, 3, rec.count[BackgroundWorkCompaction])
+	require.Equal(t, 1, rec.max[BackgroundWorkFlush])
+	require.Equal(t, 1, rec.max[BackgroundWorkCompaction])
+}
+
+type schedulerFunc func(kind BackgroundWorkKind) func()
+
+func (f schedulerFunc) Admit(kind BackgroundWorkKind) func() {
+	return f(kind)
+}
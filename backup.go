@@ -0,0 +1,404 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// backupManifestFilename is the name of the file describing the sstables of a
+// backup written by ExportSnapshot.
+const backupManifestFilename = "BACKUP"
+
+// defaultExportTargetFileSize is the size at which ExportSnapshot starts a new
+// sstable, unless WithExportTargetFileSize is specified.
+const defaultExportTargetFileSize = 64 << 20
+
+// exportOptions hold the optional parameters to construct export snapshots.
+type exportOptions struct {
+	// spans restricts the export to the keys within them.
+	spans []CheckpointSpan
+	// targetFileSize is the size at which a new sstable is started.
+	targetFileSize int64
+}
+
+// ExportOption set optional parameters used by `DB.ExportSnapshot`.
+type ExportOption func(*exportOptions)
+
+// WithExportSpans restricts the export to the keys within the specified spans.
+// The spans may overlap, and are exported in order. Exports of disjoint spans
+// may be restored along with one another.
+func WithExportSpans(spans []CheckpointSpan) ExportOption {
+	return func(opt *exportOptions) {
+		opt.spans = spans
+	}
+}
+
+// WithExportTargetFileSize sets the size at which the export starts a new
+// sstable. The sstables may exceed it, as a range key is never split across
+// sstables.
+func WithExportTargetFileSize(size int64) ExportOption {
+	return func(opt *exportOptions) {
+		opt.targetFileSize = size
+	}
+}
+
+// BackupManifest describes the sstables of a backup written by
+// ExportSnapshot. It's written as JSON to the BACKUP file of the backup.
+type BackupManifest struct {
+	// SeqNum is the sequence number of the exported snapshot. The backup holds
+	// the keys visible at SeqNum, which may be used to determine the writes
+	// that a later, incremental, export of the DB needs to capture.
+	SeqNum uint64 `json:"seq_num"`
+	// Comparer is the name of the Comparer of the exported DB, which must be
+	// the Comparer of the DB restoring the backup.
+	Comparer string `json:"comparer"`
+	// Spans are the exported spans, or nil if the whole key space was
+	// exported.
+	Spans []CheckpointSpan `json:"spans,omitempty"`
+	// Files are the sstables of the backup, sorted by key.
+	Files []BackupFile `json:"files"`
+}
+
+// BackupFile describes an sstable of a backup.
+type BackupFile struct {
+	// Name is the name of the sstable within the backup directory.
+	Name string `json:"name"`
+	// Size is the size of the sstable, in bytes.
+	Size int64 `json:"size"`
+	// Checksum is the CRC-32C of the sstable, verified by RestoreFromBackup.
+	Checksum uint32 `json:"checksum"`
+	// Smallest and Largest are the smallest and largest user keys of the
+	// sstable. Largest is exclusive if it's the end key of a range key.
+	Smallest []byte `json:"smallest"`
+	Largest  []byte `json:"largest"`
+}
+
+// ExportSnapshot writes the keys visible in the snapshot to a set of sstables
+// in destDir, along with a BACKUP file describing them, which may be restored
+// by RestoreFromBackup. Unlike a checkpoint, which links the sstables of the
+// DB as they are, an export contains only the live keys of the snapshot,
+// without the overwritten or deleted keys, and may be restricted to spans of
+// the key space with WithExportSpans. If the snapshot is nil, the current
+// state of the DB is exported.
+//
+// Merge operands are exported as their merged value. The directory must not
+// exist, and is created by ExportSnapshot.
+func (d *DB) ExportSnapshot(
+	s *Snapshot, destDir string, opts ...ExportOption,
+) (_ *BackupManifest, exportErr error) {
+	opt := &exportOptions{targetFileSize: defaultExportTargetFileSize}
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if s == nil {
+		s = d.NewSnapshot()
+		defer s.Close()
+	}
+	if s.closed() {
+		panic(ErrClosed)
+	}
+	if s.db != d {
+		return nil, errors.New("pebble: snapshot of another DB")
+	}
+
+	fs := d.opts.FS
+	if _, err := fs.Stat(destDir); !oserror.IsNotExist(err) {
+		if err == nil {
+			return nil, &os.PathError{
+				Op:   "export",
+				Path: destDir,
+				Err:  oserror.ErrExist,
+			}
+		}
+		return nil, err
+	}
+	dir, err := mkdirAllAndSyncParents(fs, destDir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if dir != nil {
+			_ = dir.Close()
+		}
+		if exportErr != nil {
+			// Attempt to cleanup on error.
+			_ = fs.RemoveAll(destDir)
+		}
+	}()
+
+	m := &BackupManifest{
+		SeqNum:   s.seqNum,
+		Comparer: d.opts.Comparer.Name,
+	}
+	spans := []CheckpointSpan{{}}
+	if opt.spans != nil {
+		m.Spans = normalizeCheckpointSpans(opt.spans, d.cmp)
+		spans = m.Spans
+	}
+	e := &snapshotExporter{
+		d:              d,
+		fs:             fs,
+		destDir:        destDir,
+		writerOpts:     d.opts.MakeWriterOptions(numLevels-1, d.FormatMajorVersion().MaxTableFormat()),
+		targetFileSize: opt.targetFileSize,
+		manifest:       m,
+	}
+	d.stampProvenance(&e.writerOpts)
+	if !d.compressionDictSupported() {
+		e.writerOpts.CompressionDict = nil
+	}
+	defer e.abort()
+	for _, span := range spans {
+		if err := e.exportSpan(s, span); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.finishFile(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(fs, fs.PathJoin(destDir, backupManifestFilename), data); err != nil {
+		return nil, err
+	}
+	if err := dir.Sync(); err != nil {
+		return nil, err
+	}
+	if err := dir.Close(); err != nil {
+		return nil, err
+	}
+	dir = nil
+	return m, nil
+}
+
+// snapshotExporter writes the keys of a snapshot to the sstables of an
+// export.
+type snapshotExporter struct {
+	d              *DB
+	fs             vfs.FS
+	destDir        string
+	writerOpts     sstable.WriterOptions
+	targetFileSize int64
+	manifest       *BackupManifest
+
+	// w is the writer of the current sstable, if any, and crc accumulates its
+	// checksum.
+	w    *sstable.Writer
+	name string
+	crc  crcWriter
+}
+
+// exportSpan writes the keys of the snapshot within the span. An empty span
+// denotes the whole key space.
+func (e *snapshotExporter) exportSpan(s *Snapshot, span CheckpointSpan) (err error) {
+	iter := s.NewIter(&IterOptions{
+		KeyTypes:   IterKeyTypePointsAndRanges,
+		LowerBound: span.Start,
+		UpperBound: span.End,
+	})
+	defer func() { err = firstError(err, iter.Close()) }()
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if e.w == nil {
+			if err := e.startFile(); err != nil {
+				return err
+			}
+		}
+		hasPoint, hasRange := iter.HasPointAndRange()
+		if hasRange && iter.RangeKeyChanged() {
+			start, end := iter.RangeBounds()
+			for _, rk := range iter.RangeKeys() {
+				if err := e.w.RangeKeySet(start, end, rk.Suffix, rk.Value); err != nil {
+					return err
+				}
+			}
+		}
+		if hasPoint {
+			value, err := iter.ValueAndErr()
+			if err != nil {
+				return err
+			}
+			if err := e.w.Set(iter.Key(), value); err != nil {
+				return err
+			}
+		}
+		// A range key is never split across sstables, so that the sstables
+		// don't overlap.
+		if !hasRange && int64(e.w.EstimatedSize()) >= e.targetFileSize {
+			if err := e.finishFile(); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Error()
+}
+
+func (e *snapshotExporter) startFile() error {
+	e.name = fmt.Sprintf("%06d.sst", len(e.manifest.Files)+1)
+	file, err := e.fs.Create(e.fs.PathJoin(e.destDir, e.name))
+	if err != nil {
+		return err
+	}
+	e.crc = crcWriter{}
+	file = vfs.NewSyncingFile(file, vfs.SyncingFileOptions{})
+	e.w = sstable.NewWriter(objstorageprovider.NewFileWritable(&crcFile{File: file, crc: &e.crc}), e.writerOpts)
+	return nil
+}
+
+// finishFile closes the current sstable, if any, and adds it to the manifest.
+func (e *snapshotExporter) finishFile() error {
+	if e.w == nil {
+		return nil
+	}
+	w := e.w
+	e.w = nil
+	if err := w.Close(); err != nil {
+		return err
+	}
+	meta, err := w.Metadata()
+	if err != nil {
+		return err
+	}
+	f := BackupFile{
+		Name:     e.name,
+		Size:     int64(meta.Size),
+		Checksum: uint32(e.crc.crc),
+	}
+	if meta.HasPointKeys {
+		f.Smallest, f.Largest = meta.SmallestPoint.UserKey, meta.LargestPoint.UserKey
+	}
+	if meta.HasRangeKeys {
+		if f.Smallest == nil || e.d.cmp(meta.SmallestRangeKey.UserKey, f.Smallest) < 0 {
+			f.Smallest = meta.SmallestRangeKey.UserKey
+		}
+		if f.Largest == nil || e.d.cmp(meta.LargestRangeKey.UserKey, f.Largest) > 0 {
+			f.Largest = meta.LargestRangeKey.UserKey
+		}
+	}
+	e.manifest.Files = append(e.manifest.Files, f)
+	return nil
+}
+
+// abort closes the current sstable, if any, after an error.
+func (e *snapshotExporter) abort() {
+	if e.w != nil {
+		_ = e.w.Close()
+		e.w = nil
+	}
+}
+
+// crcFile is a vfs.File that computes the CRC-32C of the data written to it.
+type crcFile struct {
+	vfs.File
+	crc *crcWriter
+}
+
+func (f *crcFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	_, _ = f.crc.Write(p[:n])
+	return n, err
+}
+
+// ReadBackupManifest reads the BACKUP file of a backup written by
+// ExportSnapshot.
+func ReadBackupManifest(fs vfs.FS, backupDir string) (*BackupManifest, error) {
+	data, err := readFile(fs, fs.PathJoin(backupDir, backupManifestFilename))
+	if err != nil {
+		return nil, err
+	}
+	m := &BackupManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, base.CorruptionErrorf("pebble: invalid backup manifest in %s: %v",
+			errors.Safe(backupDir), err)
+	}
+	return m, nil
+}
+
+// RestoreFromBackup creates a new DB in dirname holding the keys of the
+// backup in backupDir, written by ExportSnapshot. The sstables of the backup
+// are linked or copied to dirname, and ingested by the new DB once their
+// checksums are verified. The new DB is closed before returning. The backup
+// is left unmodified, and the new DB is opened with the specified options,
+// whose FS holds both directories.
+func RestoreFromBackup(backupDir, dirname string, opts *Options) (err error) {
+	opts = opts.Clone().EnsureDefaults()
+	fs := opts.FS
+	m, err := ReadBackupManifest(fs, backupDir)
+	if err != nil {
+		return err
+	}
+	if m.Comparer != opts.Comparer.Name {
+		return errors.Errorf("pebble: backup comparer %q does not match %q",
+			errors.Safe(m.Comparer), errors.Safe(opts.Comparer.Name))
+	}
+
+	opts.ErrorIfExists = true
+	d, err := Open(dirname, opts)
+	if err != nil {
+		return err
+	}
+	defer func() { err = firstError(err, d.Close()) }()
+	if len(m.Files) == 0 {
+		return nil
+	}
+
+	// Ingestion removes the ingested files, so the sstables of the backup are
+	// staged in dirname.
+	copier := &checkpointCopier{fs: fs, eventListener: opts.EventListener}
+	paths := make([]string, len(m.Files))
+	defer func() {
+		if err != nil {
+			for _, path := range paths {
+				if path != "" {
+					_ = fs.Remove(path)
+				}
+			}
+		}
+	}()
+	for i, f := range m.Files {
+		path := fs.PathJoin(dirname, "restore-"+f.Name)
+		if err := copier.linkOrCopy(fs.PathJoin(backupDir, f.Name), path); err != nil {
+			return err
+		}
+		paths[i] = path
+		if err := verifyBackupFile(fs, path, f); err != nil {
+			return err
+		}
+	}
+	return d.Ingest(paths)
+}
+
+// verifyBackupFile verifies the size and checksum of an sstable of a backup.
+func verifyBackupFile(fs vfs.FS, path string, f BackupFile) error {
+	file, err := fs.Open(path, vfs.SequentialReadsOption)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var w crcWriter
+	n, err := io.Copy(&w, file)
+	if err != nil {
+		return err
+	}
+	if n != f.Size || uint32(w.crc) != f.Checksum {
+		return base.CorruptionErrorf(
+			"pebble: backup file %s does not match: %d bytes with crc %08x, expected %d bytes with crc %08x",
+			errors.Safe(path), n, uint32(w.crc), f.Size, f.Checksum)
+	}
+	return nil
+}
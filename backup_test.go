@@ -0,0 +1,122 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportSnapshot(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{
+		FS:                 fs,
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("k%03d", i))
+		require.NoError(t, d.Set(key, key, nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Delete([]byte("k001"), nil))
+	require.NoError(t, d.Merge([]byte("k002"), []byte("+"), nil))
+	require.NoError(t, d.RangeKeySet([]byte("k010"), []byte("k020"), []byte("@1"), []byte("r"), nil))
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	// Writes after the snapshot aren't exported.
+	require.NoError(t, d.Set([]byte("k003"), []byte("new"), nil))
+	require.NoError(t, d.Set([]byte("z"), []byte("new"), nil))
+
+	// scan returns the keys of the reader, along with their values and the
+	// range keys they're covered by.
+	scan := func(r Reader) string {
+		iter := r.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+		var buf strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			hasPoint, hasRange := iter.HasPointAndRange()
+			if hasRange && iter.RangeKeyChanged() {
+				start, end := iter.RangeBounds()
+				fmt.Fprintf(&buf, "[%s-%s)", start, end)
+				for _, rk := range iter.RangeKeys() {
+					fmt.Fprintf(&buf, "%s=%s", rk.Suffix, rk.Value)
+				}
+				buf.WriteString(" ")
+			}
+			if hasPoint {
+				fmt.Fprintf(&buf, "%s:%s ", iter.Key(), iter.Value())
+			}
+		}
+		require.NoError(t, iter.Error())
+		require.NoError(t, iter.Close())
+		return buf.String()
+	}
+
+	m, err := d.ExportSnapshot(snap, "backup", WithExportTargetFileSize(1))
+	require.NoError(t, err)
+	require.Equal(t, snap.SeqNum(), m.SeqNum)
+	// Each point key is written to its own sstable, except for those covered
+	// by the range key, which are written along with the range key and the
+	// following point key.
+	require.Equal(t, 99-10, len(m.Files))
+	require.Equal(t, "k000", string(m.Files[0].Smallest))
+	require.Equal(t, "k010", string(m.Files[9].Smallest))
+	require.Equal(t, "k020", string(m.Files[9].Largest))
+	_, err = d.ExportSnapshot(snap, "backup")
+	require.Error(t, err)
+
+	require.NoError(t, RestoreFromBackup("backup", "restored", opts))
+	d2, err := Open("restored", opts)
+	require.NoError(t, err)
+	require.Equal(t, scan(snap), scan(d2))
+	require.NoError(t, d2.Close())
+	// The backup is left unmodified, and may be restored again.
+	m2, err := ReadBackupManifest(fs, "backup")
+	require.NoError(t, err)
+	require.Equal(t, m, m2)
+	require.Error(t, RestoreFromBackup("backup", "restored", opts))
+
+	// Exports may be restricted to spans.
+	spans := []CheckpointSpan{
+		{Start: []byte("k015"), End: []byte("k030")},
+		{Start: []byte("k000"), End: []byte("k003")},
+		{Start: []byte("k025"), End: []byte("k035")},
+	}
+	m, err = d.ExportSnapshot(nil, "spans", WithExportSpans(spans))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(m.Files))
+	require.Equal(t, 2, len(m.Spans))
+	require.NoError(t, RestoreFromBackup("spans", "restored-spans", opts))
+	d2, err = Open("restored-spans", opts)
+	require.NoError(t, err)
+	got := scan(d2)
+	require.True(t, strings.HasPrefix(got, "k000:k000 k002:k002+ [k015-k020)@1=r k015:k015 "), got)
+	require.True(t, strings.HasSuffix(got, " k034:k034 "), got)
+	require.NoError(t, d2.Close())
+
+	// Corrupted sstables are detected before they're ingested.
+	f, err := fs.OpenReadWrite("spans/" + m.Files[0].Name)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("corrupt"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	err = RestoreFromBackup("spans", "corrupted", opts)
+	require.True(t, errors.Is(err, base.ErrCorruption), err)
+
+	// A backup may only be restored with the same comparer.
+	err = RestoreFromBackup("backup", "other", &Options{FS: fs})
+	require.Error(t, err)
+}
@@ -283,9 +283,23 @@ type Batch struct {
 	fsyncWait sync.WaitGroup
 
 	commitStats BatchCommitStats
+	// commitClass is the class of the batch in the commit pipeline, set from
+	// WriteOptions.CommitClass when the batch is committed.
+	commitClass CommitClass
 
 	commitErr error
 	applied   atomic.Bool
+
+	// twoPhase is set on the batches writing the records of two-phase commits.
+	// See Batch.Prepare.
+	twoPhase *twoPhaseOp
+
+	// commitCheck, if set, is run by the commit pipeline once every batch
+	// sequenced before seqNum is visible, and rejects the batch if it returns
+	// an error. It's run without holding commitPipeline.mu, and may be run
+	// again with a greater seqNum if batches are sequenced while it runs. See
+	// Txn.Commit.
+	commitCheck func(seqNum uint64) error
 }
 
 // BatchCommitStats exposes stats related to committing a batch.
@@ -512,7 +526,7 @@ func (b *Batch) Get(key []byte) ([]byte, io.Closer, error) {
 	if b.index == nil {
 		return nil, nil, ErrNotIndexed
 	}
-	return b.db.getInternal(key, b, nil /* snapshot */)
+	return b.db.getInternal(context.Background(), key, b, nil /* snapshot */)
 }
 
 func (b *Batch) prepareDeferredKeyValueRecord(keyLen, valueLen int, kind InternalKeyKind) {
@@ -1197,8 +1211,11 @@ func (b *Batch) Reset() {
 	b.commit = sync.WaitGroup{}
 	b.fsyncWait = sync.WaitGroup{}
 	b.commitStats = BatchCommitStats{}
+	b.commitClass = CommitClassInteractive
 	b.commitErr = nil
 	b.applied.Store(false)
+	b.twoPhase = nil
+	b.commitCheck = nil
 	if b.data != nil {
 		if cap(b.data) > batchMaxRetainedSize {
 			// If the capacity of the buffer is larger than our maximum
@@ -0,0 +1,97 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/errors"
+
+// BatchApplyHook observes the batches applied to a DB, and may add derived
+// writes to them, such as the secondary index entries of the records written
+// by a batch. The derived writes are committed atomically with the batch,
+// regardless of whether the batch was applied through Apply, Batch.Commit, or
+// one of the DB's write methods such as Set.
+type BatchApplyHook interface {
+	// OnApply is called by Apply before the batch is committed, with the
+	// batch's operations in the order they were added to the batch. Derived
+	// writes are added to derived, and are committed with the batch once all
+	// the configured hooks have returned, but aren't left in the batch: its
+	// contents, as returned by Repr and Count, are unchanged by Apply. Hooks
+	// don't observe each other's derived writes. If OnApply returns an error, the batch is not applied and Apply
+	// returns the error. The slices referenced by ops must not be retained.
+	//
+	// OnApply may be called concurrently from multiple goroutines. It must not
+	// apply batches to the DB.
+	OnApply(ops []BatchOp, derived Writer) error
+}
+
+// runBatchApplyHooks passes the batch's operations to the configured
+// BatchApplyHooks, and appends their derived writes to the batch so that
+// they're committed with it, in the same WAL record. The returned function
+// removes the derived writes from the batch, and must be called once the
+// batch is committed or fails to be, so that a retry or reuse of the batch
+// doesn't apply them twice.
+func (d *DB) runBatchApplyHooks(b *Batch) (restore func(), err error) {
+	ops := decodeBatchOps(b)
+	derived := newBatch(nil)
+	defer derived.Close()
+	for _, h := range d.opts.Experimental.BatchApplyHooks {
+		if err := h.OnApply(ops, derived); err != nil {
+			return nil, errors.Wrap(err, "pebble: batch apply hook failed")
+		}
+	}
+	if derived.Empty() {
+		return func() {}, nil
+	}
+
+	dataLen, count, memTableSize := len(b.data), b.count, b.memTableSize
+	countRangeDels, countRangeKeys := b.countRangeDels, b.countRangeKeys
+	if len(b.data) == 0 {
+		b.init(batchHeaderLen)
+	}
+	// The derived writes aren't added to the index of an indexed batch, as
+	// they're removed from the batch before it may be read again.
+	b.data = append(b.data, derived.data[batchHeaderLen:]...)
+	b.setCount(uint32(b.count) + derived.Count())
+	for r := derived.Reader(); ; {
+		kind, key, value, ok := r.Next()
+		if !ok {
+			break
+		}
+		switch kind {
+		case InternalKeyKindRangeDelete:
+			b.countRangeDels++
+		case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+			b.countRangeKeys++
+		}
+		b.memTableSize += memTableEntrySize(len(key), len(value))
+	}
+	return func() {
+		// The data of a large batch is released once it's committed, as it's
+		// referenced by the flushable batch.
+		if b.data == nil {
+			return
+		}
+		b.data = b.data[:dataLen]
+		b.count, b.memTableSize = count, memTableSize
+		b.countRangeDels, b.countRangeKeys = countRangeDels, countRangeKeys
+	}, nil
+}
+
+// decodeBatchOps returns the operations of the batch, excluding LogData, in
+// the order they were added to the batch.
+func decodeBatchOps(b *Batch) []BatchOp {
+	ops := make([]BatchOp, 0, b.Count())
+	r := b.Reader()
+	for {
+		kind, ukey, value, ok := r.Next()
+		if !ok {
+			break
+		}
+		if kind == InternalKeyKindLogData {
+			continue
+		}
+		ops = append(ops, BatchOp{Kind: kind, Key: ukey, Value: value})
+	}
+	return ops
+}
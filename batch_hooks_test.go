@@ -0,0 +1,146 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type batchApplyHookFunc func(ops []BatchOp, derived Writer) error
+
+func (f batchApplyHookFunc) OnApply(ops []BatchOp, derived Writer) error {
+	return f(ops, derived)
+}
+
+func TestBatchApplyHooks(t *testing.T) {
+	// The hook indexes the records with keys prefixed by "rec/" by their
+	// value, and rejects empty values.
+	indexHook := batchApplyHookFunc(func(ops []BatchOp, derived Writer) error {
+		for _, op := range ops {
+			if !bytes.HasPrefix(op.Key, []byte("rec/")) || op.Kind != InternalKeyKindSet {
+				continue
+			}
+			if len(op.Value) == 0 {
+				return errors.Newf("empty record %q", op.Key)
+			}
+			idx := append([]byte("idx/"+string(op.Value)+"/"), op.Key[len("rec/"):]...)
+			if err := derived.Set(idx, nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	var counted int
+	countHook := batchApplyHookFunc(func(ops []BatchOp, derived Writer) error {
+		// Hooks don't observe the derived writes of other hooks.
+		counted += len(ops)
+		return nil
+	})
+
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.BatchApplyHooks = []BatchApplyHook{indexHook, countHook}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	keys := func() []string {
+		iter := d.NewIter(nil)
+		defer func() { require.NoError(t, iter.Close()) }()
+		var keys []string
+		for iter.First(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	// Writes through the DB and through batches are observed.
+	require.NoError(t, d.Set([]byte("rec/a"), []byte("x"), nil))
+	b := d.NewIndexedBatch()
+	require.NoError(t, b.Set([]byte("rec/b"), []byte("y"), nil))
+	require.NoError(t, b.Set([]byte("other"), []byte("z"), nil))
+	require.NoError(t, b.Commit(nil))
+	require.Equal(t, []string{"idx/x/a", "idx/y/b", "other", "rec/a", "rec/b"}, keys())
+	require.Equal(t, 3, counted)
+
+	// The derived writes are committed atomically with the batch, but aren't
+	// left in it.
+	require.Equal(t, uint32(2), b.Count())
+	require.Equal(t, []string{"rec/b", "other"}, batchKeys(b))
+	// Reusing the batch's contents doesn't apply the derived writes twice.
+	reused := d.NewBatch()
+	require.NoError(t, reused.SetRepr(append([]byte(nil), b.Repr()...)))
+	require.NoError(t, reused.Commit(nil))
+	require.Equal(t, 5, counted)
+	require.NoError(t, reused.Close())
+	require.NoError(t, b.Close())
+
+	// If a hook fails, the batch is not applied.
+	b = d.NewBatch()
+	require.NoError(t, b.Set([]byte("rec/c"), []byte("w"), nil))
+	require.NoError(t, b.Set([]byte("rec/d"), nil, nil))
+	err = d.Apply(b, nil)
+	require.EqualError(t, err, `pebble: batch apply hook failed: empty record "rec/d"`)
+	require.NoError(t, b.Close())
+	require.Equal(t, []string{"idx/x/a", "idx/y/b", "other", "rec/a", "rec/b"}, keys())
+}
+
+func TestBatchApplyHooksRetry(t *testing.T) {
+	// The hook derives a write per operation, and the validator fails the
+	// first batch it validates, after the hook ran.
+	hook := batchApplyHookFunc(func(ops []BatchOp, derived Writer) error {
+		for _, op := range ops {
+			if err := derived.Merge(append([]byte("derived/"), op.Key...), []byte("1"), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	var validated int
+	validator := batchValidatorFunc(func(cmp Compare, ops []BatchOp) error {
+		if validated++; validated == 1 {
+			return errors.New("injected")
+		}
+		return nil
+	})
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.BatchApplyHooks = []BatchApplyHook{hook}
+	opts.Experimental.BatchValidator = validator
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	b := d.NewIndexedBatch()
+	require.NoError(t, b.Set([]byte("a"), []byte("x"), nil))
+	repr := append([]byte(nil), b.Repr()...)
+	require.Error(t, d.Apply(b, nil))
+	// The failed apply left the batch unchanged, so retrying it derives the
+	// writes once.
+	require.Equal(t, repr, b.Repr())
+	require.NoError(t, d.Apply(b, nil))
+	// The sequence number in the header is set by the commit.
+	require.Equal(t, repr[batchHeaderLen:], b.Repr()[batchHeaderLen:])
+	require.Equal(t, uint32(1), b.Count())
+	require.NoError(t, b.Close())
+
+	v, closer, err := d.Get([]byte("derived/a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+}
+
+// batchKeys returns the keys of the batch's operations, in the order they
+// were added to the batch.
+func batchKeys(b *Batch) []string {
+	var keys []string
+	for _, op := range decodeBatchOps(b) {
+		keys = append(keys, string(op.Key))
+	}
+	return keys
+}
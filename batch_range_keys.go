@@ -0,0 +1,230 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// RangeKeyOpKind is the kind of a RangeKeyOp.
+type RangeKeyOpKind int8
+
+const (
+	// RangeKeyOpDelete is a RangeKeyDelete, removing all range keys within a
+	// span.
+	RangeKeyOpDelete RangeKeyOpKind = iota
+	// RangeKeyOpUnset is a RangeKeyUnset, removing the range keys with a
+	// suffix within a span.
+	RangeKeyOpUnset
+	// RangeKeyOpSet is a RangeKeySet, setting the value of the range keys with
+	// a suffix within a span.
+	RangeKeyOpSet
+)
+
+// String implements fmt.Stringer.
+func (k RangeKeyOpKind) String() string {
+	switch k {
+	case RangeKeyOpDelete:
+		return "RangeKeyDelete"
+	case RangeKeyOpUnset:
+		return "RangeKeyUnset"
+	case RangeKeyOpSet:
+		return "RangeKeySet"
+	default:
+		return "unknown"
+	}
+}
+
+// RangeKeyOp is a range key operation, as planned by
+// Batch.PlanRangeKeyState. Suffix is unused by RangeKeyOpDelete, and Value is
+// only used by RangeKeyOpSet.
+type RangeKeyOp struct {
+	Kind   RangeKeyOpKind
+	Start  []byte
+	End    []byte
+	Suffix []byte
+	Value  []byte
+}
+
+// String implements fmt.Stringer.
+func (op RangeKeyOp) String() string {
+	switch op.Kind {
+	case RangeKeyOpDelete:
+		return fmt.Sprintf("%s [%s, %s)", op.Kind, op.Start, op.End)
+	case RangeKeyOpUnset:
+		return fmt.Sprintf("%s [%s, %s) %s", op.Kind, op.Start, op.End, op.Suffix)
+	default:
+		return fmt.Sprintf("%s [%s, %s) %s=%s", op.Kind, op.Start, op.End, op.Suffix, op.Value)
+	}
+}
+
+// PlanRangeKeyState returns the range key operations which, applied to the
+// batch in order, would make the range keys within [start, end) be exactly the
+// given ones, with the given suffixes and values over the whole span, as seen
+// by the batch: that is, the range keys of the DB along with those written by
+// the batch. The batch must be indexed, and isn't modified.
+//
+// The current range keys are compared fragment by fragment with the desired
+// ones, so that only the fragments which differ are written: a fragment
+// which must lose all of its range keys is deleted with RangeKeyDelete, and
+// otherwise the suffixes it must lose are unset with RangeKeyUnset and the
+// suffixes it lacks or whose value differs are set with RangeKeySet, unless
+// deleting the fragment and setting all the desired range keys takes fewer
+// operations. The operations of the same kind, suffix and value planned for
+// adjacent fragments are coalesced. Deletions are ordered before unsets and
+// sets, so that they don't remove the range keys set by the plan.
+func (b *Batch) PlanRangeKeyState(
+	start, end []byte, keys []RangeKeyData,
+) ([]RangeKeyOp, error) {
+	if b.index == nil {
+		return nil, ErrNotIndexed
+	}
+	if b.cmp(start, end) >= 0 {
+		return nil, errors.Errorf("pebble: invalid range key span [%q, %q)", start, end)
+	}
+	desired := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		if _, ok := desired[string(k.Suffix)]; ok {
+			return nil, errors.Errorf("pebble: duplicate range key suffix %q", k.Suffix)
+		}
+		desired[string(k.Suffix)] = k.Value
+	}
+
+	p := rangeKeyPlanner{keys: keys, desired: desired}
+	iter := b.NewIter(&IterOptions{
+		KeyTypes:   IterKeyTypeRangesOnly,
+		LowerBound: start,
+		UpperBound: end,
+	})
+	pos := start
+	for valid := iter.First(); valid; valid = iter.Next() {
+		fragStart, fragEnd := iter.RangeBounds()
+		if b.cmp(pos, fragStart) < 0 {
+			p.planFragment(pos, fragStart, nil)
+		}
+		p.planFragment(fragStart, fragEnd, iter.RangeKeys())
+		pos = append(pos[:0:0], fragEnd...)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	if b.cmp(pos, end) < 0 {
+		p.planFragment(pos, end, nil)
+	}
+
+	sort.SliceStable(p.ops, func(i, j int) bool {
+		return p.ops[i].Kind < p.ops[j].Kind
+	})
+	return p.ops, nil
+}
+
+// SetRangeKeyState makes the range keys within [start, end) be exactly the
+// given ones, applying to the batch the operations planned by
+// PlanRangeKeyState, which it returns. The batch must be indexed. Like for
+// the other operations on a batch, the new state is only visible outside the
+// batch once it's committed, atomically.
+//
+// It is safe to modify the contents of the arguments after SetRangeKeyState
+// returns.
+func (b *Batch) SetRangeKeyState(
+	start, end []byte, keys []RangeKeyData, opts *WriteOptions,
+) ([]RangeKeyOp, error) {
+	ops, err := b.PlanRangeKeyState(start, end, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		switch op.Kind {
+		case RangeKeyOpDelete:
+			err = b.RangeKeyDelete(op.Start, op.End, opts)
+		case RangeKeyOpUnset:
+			err = b.RangeKeyUnset(op.Start, op.End, op.Suffix, opts)
+		case RangeKeyOpSet:
+			err = b.RangeKeySet(op.Start, op.End, op.Suffix, op.Value, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// rangeKeyPlanner accumulates the operations planned by PlanRangeKeyState
+// for consecutive fragments.
+type rangeKeyPlanner struct {
+	keys    []RangeKeyData
+	desired map[string][]byte
+	ops     []RangeKeyOp
+	// open holds the indexes within ops of the operations planned for the
+	// previous fragment, which may be extended by the operations of the next
+	// one.
+	open     []int
+	nextOpen []int
+}
+
+// planFragment plans the operations of the fragment [start, end), whose
+// current range keys are the given ones.
+func (p *rangeKeyPlanner) planFragment(start, end []byte, current []RangeKeyData) {
+	var unsets, sets []RangeKeyOp
+	currentValues := make(map[string][]byte, len(current))
+	for _, k := range current {
+		currentValues[string(k.Suffix)] = k.Value
+		if _, ok := p.desired[string(k.Suffix)]; !ok {
+			unsets = append(unsets, RangeKeyOp{Kind: RangeKeyOpUnset, Suffix: k.Suffix})
+		}
+	}
+	for _, k := range p.keys {
+		if v, ok := currentValues[string(k.Suffix)]; !ok || !bytes.Equal(v, k.Value) {
+			sets = append(sets, RangeKeyOp{Kind: RangeKeyOpSet, Suffix: k.Suffix, Value: k.Value})
+		}
+	}
+
+	p.nextOpen = p.nextOpen[:0]
+	switch {
+	case len(unsets) == 0 && len(sets) == 0:
+		// The fragment is already in the desired state.
+	case len(p.keys) == 0:
+		p.add(RangeKeyOp{Kind: RangeKeyOpDelete}, start, end)
+	case 1+len(p.keys) < len(unsets)+len(sets):
+		p.add(RangeKeyOp{Kind: RangeKeyOpDelete}, start, end)
+		for _, k := range p.keys {
+			p.add(RangeKeyOp{Kind: RangeKeyOpSet, Suffix: k.Suffix, Value: k.Value}, start, end)
+		}
+	default:
+		for _, op := range unsets {
+			p.add(op, start, end)
+		}
+		for _, op := range sets {
+			p.add(op, start, end)
+		}
+	}
+	p.open, p.nextOpen = p.nextOpen, p.open
+}
+
+// add plans the given operation over [start, end), extending an identical
+// operation planned for the previous fragment if there's one.
+func (p *rangeKeyPlanner) add(op RangeKeyOp, start, end []byte) {
+	for _, i := range p.open {
+		prev := &p.ops[i]
+		if prev.Kind == op.Kind && bytes.Equal(prev.Suffix, op.Suffix) &&
+			bytes.Equal(prev.Value, op.Value) && bytes.Equal(prev.End, start) {
+			prev.End = append([]byte(nil), end...)
+			p.nextOpen = append(p.nextOpen, i)
+			return
+		}
+	}
+	op.Start = append([]byte(nil), start...)
+	op.End = append([]byte(nil), end...)
+	op.Suffix = append([]byte(nil), op.Suffix...)
+	if op.Kind == RangeKeyOpSet {
+		op.Value = append([]byte(nil), op.Value...)
+	}
+	p.nextOpen = append(p.nextOpen, len(p.ops))
+	p.ops = append(p.ops, op)
+}
@@ -0,0 +1,141 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchRangeKeyState(t *testing.T) {
+	var d *DB
+	var b *Batch
+	reset := func() {
+		if b != nil {
+			require.NoError(t, b.Close())
+		}
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+		var err error
+		d, err = Open("", &Options{
+			Comparer:           testkeys.Comparer,
+			FormatMajorVersion: FormatRangeKeys,
+			FS:                 vfs.NewMem(),
+		})
+		require.NoError(t, err)
+		b = d.NewIndexedBatch()
+	}
+	reset()
+	defer func() {
+		require.NoError(t, b.Close())
+		require.NoError(t, d.Close())
+	}()
+
+	parseKeys := func(td *datadriven.TestData) []RangeKeyData {
+		var keys []RangeKeyData
+		for _, line := range strings.Fields(td.Input) {
+			suffix, value, _ := strings.Cut(line, "=")
+			keys = append(keys, RangeKeyData{Suffix: []byte(suffix), Value: []byte(value)})
+		}
+		return keys
+	}
+	printOps := func(ops []RangeKeyOp) string {
+		var buf bytes.Buffer
+		for _, op := range ops {
+			fmt.Fprintf(&buf, "%s\n", op)
+		}
+		if buf.Len() == 0 {
+			return "(none)\n"
+		}
+		return buf.String()
+	}
+
+	datadriven.RunTest(t, "testdata/batch_range_key_state", func(t *testing.T, td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "reset":
+			reset()
+			return ""
+
+		case "apply":
+			// Writes to the DB, outside the batch.
+			wb := d.NewBatch()
+			if err := runBatchDefineCmd(td, wb); err != nil {
+				return err.Error()
+			}
+			if err := wb.Commit(nil); err != nil {
+				return err.Error()
+			}
+			if td.HasArg("flush") {
+				if err := d.Flush(); err != nil {
+					return err.Error()
+				}
+			}
+			return ""
+
+		case "batch":
+			if err := runBatchDefineCmd(td, b); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "plan", "set-state":
+			var start, end string
+			td.ScanArgs(t, "start", &start)
+			td.ScanArgs(t, "end", &end)
+			var ops []RangeKeyOp
+			var err error
+			if td.Cmd == "plan" {
+				ops, err = b.PlanRangeKeyState([]byte(start), []byte(end), parseKeys(td))
+			} else {
+				ops, err = b.SetRangeKeyState([]byte(start), []byte(end), parseKeys(td), nil)
+			}
+			if err != nil {
+				return err.Error()
+			}
+			return printOps(ops)
+
+		case "scan":
+			iter := b.NewIter(&IterOptions{KeyTypes: IterKeyTypeRangesOnly})
+			var buf bytes.Buffer
+			for valid := iter.First(); valid; valid = iter.Next() {
+				start, end := iter.RangeBounds()
+				fmt.Fprintf(&buf, "[%s, %s):", start, end)
+				for _, k := range iter.RangeKeys() {
+					fmt.Fprintf(&buf, " %s=%s", k.Suffix, k.Value)
+				}
+				buf.WriteString("\n")
+			}
+			if err := iter.Close(); err != nil {
+				return err.Error()
+			}
+			if buf.Len() == 0 {
+				return "(none)\n"
+			}
+			return buf.String()
+
+		default:
+			return fmt.Sprintf("unknown command: %s", td.Cmd)
+		}
+	})
+}
+
+func TestBatchRangeKeyStateNotIndexed(t *testing.T) {
+	d, err := Open("", &Options{Comparer: testkeys.Comparer, FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	b := d.NewBatch()
+	defer b.Close()
+	_, err = b.SetRangeKeyState([]byte("a"), []byte("b"), nil, nil)
+	require.ErrorIs(t, err, ErrNotIndexed)
+}
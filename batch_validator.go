@@ -0,0 +1,90 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// BatchOp is a single operation within a batch, as presented to a
+// BatchValidator or BatchApplyHook. For range deletions and range keys, Key is
+// the start key of the span and Value is the encoded value as stored in the
+// batch (for range deletions, the end key).
+type BatchOp struct {
+	Kind  InternalKeyKind
+	Key   []byte
+	Value []byte
+}
+
+// BatchValidator validates the contents of batches before they are applied to
+// a DB. It is a debugging aid intended to catch write-path bugs in tests and
+// canaries by checking application-declared invariants, for example that the
+// preconditions of SingleDelete hold or that the suffixes of the keys sharing
+// a prefix are monotonically increasing.
+//
+// Validation requires decoding and sorting the contents of every batch, and
+// should not be enabled in production deployments where performance matters.
+type BatchValidator interface {
+	// ValidateBatch is called by Apply before the batch is committed, with the
+	// batch's operations ordered by user key according to cmp. Operations on
+	// the same user key are ordered in the order they were added to the batch.
+	// If ValidateBatch returns an error, the batch is not applied and Apply
+	// returns the error. The slices referenced by ops must not be retained.
+	//
+	// ValidateBatch may be called concurrently from multiple goroutines.
+	ValidateBatch(cmp Compare, ops []BatchOp) error
+}
+
+// validateBatch decodes the batch and passes its operations, in key order, to
+// the configured BatchValidator.
+func (d *DB) validateBatch(b *Batch) error {
+	ops := decodeBatchOps(b)
+	sort.SliceStable(ops, func(i, j int) bool {
+		return d.cmp(ops[i].Key, ops[j].Key) < 0
+	})
+	if err := d.opts.Experimental.BatchValidator.ValidateBatch(d.cmp, ops); err != nil {
+		return errors.Wrap(err, "pebble: batch failed validation")
+	}
+	return nil
+}
+
+// SingleDeleteValidator is a BatchValidator that checks, within each batch,
+// that a key which is the target of a SingleDelete was written at most once
+// and was not merged since it was last deleted within the batch. Writes to the
+// key in earlier batches are not taken into account.
+type SingleDeleteValidator struct{}
+
+var _ BatchValidator = SingleDeleteValidator{}
+
+// ValidateBatch implements the BatchValidator interface.
+func (SingleDeleteValidator) ValidateBatch(cmp Compare, ops []BatchOp) error {
+	var writes int
+	var merged bool
+	for i, op := range ops {
+		if i == 0 || cmp(ops[i-1].Key, op.Key) != 0 {
+			writes, merged = 0, false
+		}
+		switch op.Kind {
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+			writes++
+		case InternalKeyKindMerge:
+			writes++
+			merged = true
+		case InternalKeyKindDelete:
+			writes, merged = 0, false
+		case InternalKeyKindSingleDelete:
+			if merged {
+				return errors.Errorf("SingleDelete of merged key %q", op.Key)
+			}
+			if writes > 1 {
+				return errors.Errorf("SingleDelete of key %q written %d times", op.Key, writes)
+			}
+			writes, merged = 0, false
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type batchValidatorFunc func(cmp Compare, ops []BatchOp) error
+
+func (f batchValidatorFunc) ValidateBatch(cmp Compare, ops []BatchOp) error {
+	return f(cmp, ops)
+}
+
+func TestBatchValidator(t *testing.T) {
+	var keys []string
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.BatchValidator = batchValidatorFunc(func(cmp Compare, ops []BatchOp) error {
+		keys = keys[:0]
+		for _, op := range ops {
+			keys = append(keys, string(op.Key))
+		}
+		return SingleDeleteValidator{}.ValidateBatch(cmp, ops)
+	})
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Operations are presented in key order, and within a key in batch order.
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("c"), nil, nil))
+	require.NoError(t, b.Set([]byte("a"), nil, nil))
+	require.NoError(t, b.LogData([]byte("ignored"), nil))
+	require.NoError(t, b.Delete([]byte("b"), nil))
+	require.NoError(t, b.SingleDelete([]byte("a"), nil))
+	require.NoError(t, d.Apply(b, nil))
+	require.Equal(t, []string{"a", "a", "b", "c"}, keys)
+
+	// Writes through the DB are validated too.
+	require.NoError(t, d.Set([]byte("d"), nil, nil))
+	require.Equal(t, []string{"d"}, keys)
+
+	for _, tc := range []struct {
+		name   string
+		fn     func(b *Batch)
+		errMsg string
+	}{
+		{
+			name: "set-twice",
+			fn: func(b *Batch) {
+				_ = b.Set([]byte("k"), []byte("1"), nil)
+				_ = b.Set([]byte("k"), []byte("2"), nil)
+				_ = b.SingleDelete([]byte("k"), nil)
+			},
+			errMsg: `pebble: batch failed validation: SingleDelete of key "k" written 2 times`,
+		},
+		{
+			name: "merge",
+			fn: func(b *Batch) {
+				_ = b.Merge([]byte("k"), []byte("1"), nil)
+				_ = b.SingleDelete([]byte("k"), nil)
+			},
+			errMsg: `pebble: batch failed validation: SingleDelete of merged key "k"`,
+		},
+		{
+			name: "delete-resets",
+			fn: func(b *Batch) {
+				_ = b.Set([]byte("k"), []byte("1"), nil)
+				_ = b.Delete([]byte("k"), nil)
+				_ = b.Set([]byte("k"), []byte("2"), nil)
+				_ = b.SingleDelete([]byte("k"), nil)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := d.NewBatch()
+			tc.fn(b)
+			err := d.Apply(b, nil)
+			if tc.errMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.errMsg)
+			// The batch was not applied.
+			_, closer, err := d.Get([]byte("k"))
+			require.True(t, errors.Is(err, ErrNotFound))
+			require.Nil(t, closer)
+		})
+	}
+}
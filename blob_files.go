@@ -0,0 +1,524 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/blob"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// ValueSeparationOptions configures the separation of large values from the
+// keys of the LSM into blob files. See Options.Experimental.ValueSeparation.
+//
+// Flushes and compactions write the values of SETs that are at least
+// MinimumSize bytes long to a blob file, which is shared by the sstables they
+// output, and the sstables store a handle to the value in its place. The
+// compactions of such sstables copy the handles of the values, rather than
+// the values, so large values are written once rather than once per level of
+// the LSM. Reading a separated value requires an additional read from the
+// blob file.
+//
+// A blob file is deleted once none of the sstables referencing it remain.
+// Since the values of a blob file that are overwritten or deleted remain in
+// the blob file until then, compactions rewrite the values of the blob files
+// whose proportion of unreferenced values exceeds MaxGarbageRatio, allowing
+// the blob file to be deleted once all its live values have been rewritten.
+//
+// Value separation requires sstables of format TableFormatPebblev3 or later,
+// written at FormatBlobFiles or later with value blocks enabled
+// (Options.Experimental.EnableValueBlocks), since the references of the
+// tables to their blob files are recorded in the manifest in a form that
+// previous versions fail to decode. The blob files already written remain
+// readable if value separation is disabled.
+//
+// Workloads writing the same large values under many keys may deduplicate
+// them with DedupMinimumSize: a flush or compaction then writes each distinct
+// value once to its blob file, identified by its SHA-256 hash, and the tables
+// reference the single copy from each of the keys. The values are read
+// transparently. Deduplication is limited to the values written to the blob
+// file of a flush or compaction, but the values shared by several keys remain
+// shared by the compactions which don't rewrite them. The live value size of
+// a blob file counts a shared value once per table referencing it, so that
+// the garbage of blob files with shared values may be underestimated.
+type ValueSeparationOptions struct {
+	// MinimumSize is the minimum length of a value for it to be separated. A
+	// value of zero disables value separation.
+	MinimumSize int
+	// MaxGarbageRatio is the proportion of the bytes of a blob file that may
+	// be occupied by unreferenced values before compactions rewrite its
+	// values. Defaults to 0.5.
+	MaxGarbageRatio float64
+	// DedupMinimumSize is the minimum length of a separated value for it to
+	// be deduplicated with the identical values written to the same blob
+	// file. A value of zero disables deduplication.
+	DedupMinimumSize int
+}
+
+func (o ValueSeparationOptions) maxGarbageRatio() float64 {
+	if o.MaxGarbageRatio <= 0 {
+		return 0.5
+	}
+	return o.MaxGarbageRatio
+}
+
+// blobFileCache caches the readers of the blob files of a DB, and fetches the
+// values stored in them. It is the sstable.ReaderOptions.BlobValueFetcher of
+// the tables of the DB.
+type blobFileCache struct {
+	objProvider objstorage.Provider
+	mu          sync.Mutex
+	readers     map[base.DiskFileNum]*blobReaderRef
+}
+
+var _ base.ValueFetcher = (*blobFileCache)(nil)
+
+// blobReaderRef is a reference counted blob.Reader, which is closed when the
+// last reference is released.
+type blobReaderRef struct {
+	*blob.Reader
+	refs atomic.Int32
+}
+
+func (r *blobReaderRef) unref() {
+	if r.refs.Add(-1) == 0 {
+		_ = r.Close()
+	}
+}
+
+func newBlobFileCache(objProvider objstorage.Provider) *blobFileCache {
+	return &blobFileCache{
+		objProvider: objProvider,
+		readers:     make(map[base.DiskFileNum]*blobReaderRef),
+	}
+}
+
+// Fetch implements base.ValueFetcher.
+func (c *blobFileCache) Fetch(
+	handle []byte, valLen int32, buf []byte,
+) (val []byte, callerOwned bool, err error) {
+	h, err := blob.DecodeHandleWithLen(handle, uint32(valLen))
+	if err != nil {
+		return nil, false, err
+	}
+	r, err := c.get(h.FileNum)
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.unref()
+	val, err = r.ReadValue(context.Background(), h, buf)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "pebble: reading value from blob file %s", h.FileNum)
+	}
+	return val, true, nil
+}
+
+func (c *blobFileCache) get(fileNum base.DiskFileNum) (*blobReaderRef, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.readers[fileNum]; ok {
+		r.refs.Add(1)
+		return r, nil
+	}
+	readable, err := c.objProvider.OpenForReading(
+		context.Background(), fileTypeBlob, fileNum, objstorage.OpenOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "pebble: opening blob file %s", fileNum)
+	}
+	br, err := blob.NewReader(readable, fileNum)
+	if err != nil {
+		return nil, err
+	}
+	// The cache holds one reference, and the caller the other.
+	r := &blobReaderRef{Reader: br}
+	r.refs.Store(2)
+	c.readers[fileNum] = r
+	return r, nil
+}
+
+// evict closes the reader of the given blob file, if it is open. It's called
+// when the blob file is deleted.
+func (c *blobFileCache) evict(fileNum base.DiskFileNum) {
+	c.mu.Lock()
+	r, ok := c.readers[fileNum]
+	delete(c.readers, fileNum)
+	c.mu.Unlock()
+	if ok {
+		r.unref()
+	}
+}
+
+func (c *blobFileCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for fileNum, r := range c.readers {
+		r.unref()
+		delete(c.readers, fileNum)
+	}
+}
+
+// blobFileMetadata describes a blob file referenced by the backing tables of
+// the versions of the versionSet.
+type blobFileMetadata struct {
+	// refs is the number of backing tables referencing the blob file.
+	refs int
+	// size is the size of the blob file.
+	size uint64
+	// liveValueSize is the total length of the values of the blob file that
+	// are referenced by the backing tables.
+	liveValueSize uint64
+}
+
+// garbageRatio returns the proportion of the blob file that is not occupied
+// by referenced values.
+func (m *blobFileMetadata) garbageRatio() float64 {
+	if m.size == 0 || m.liveValueSize >= m.size {
+		return 0
+	}
+	return float64(m.size-m.liveValueSize) / float64(m.size)
+}
+
+// addBlobReferencesLocked records the references of a backing table added to
+// the versionSet to the blob files. DB.mu must be held.
+func (vs *versionSet) addBlobReferencesLocked(b *fileBacking) {
+	for _, ref := range b.BlobReferences {
+		m := vs.blobFiles[ref.FileNum]
+		if m == nil {
+			m = &blobFileMetadata{size: ref.FileSize}
+			vs.blobFiles[ref.FileNum] = m
+		}
+		m.refs++
+		m.liveValueSize += ref.ValueSize
+	}
+}
+
+// removeBlobReferencesLocked releases the references of an obsolete backing
+// table to the blob files. The blob files that are no longer referenced are
+// added to the obsolete blob files. DB.mu must be held.
+func (vs *versionSet) removeBlobReferencesLocked(b *fileBacking) {
+	for _, ref := range b.BlobReferences {
+		m := vs.blobFiles[ref.FileNum]
+		if m == nil {
+			vs.opts.Logger.Fatalf("pebble: table %s references unknown blob file %s", b.DiskFileNum, ref.FileNum)
+			continue
+		}
+		m.refs--
+		m.liveValueSize -= ref.ValueSize
+		if m.refs == 0 {
+			delete(vs.blobFiles, ref.FileNum)
+			vs.obsoleteBlobFiles = append(vs.obsoleteBlobFiles, fileInfo{
+				fileNum:  ref.FileNum,
+				fileSize: m.size,
+			})
+		}
+	}
+}
+
+// addNewBlobReferencesLocked records the references to the blob files of the
+// tables added by the version edit, excluding the tables moved between levels
+// and the virtual tables, whose backing tables are already recorded. DB.mu
+// must be held.
+func (vs *versionSet) addNewBlobReferencesLocked(ve *versionEdit) {
+	for _, nf := range ve.NewFiles {
+		if nf.Meta.Virtual || len(nf.Meta.FileBacking.BlobReferences) == 0 {
+			continue
+		}
+		if isMovedFile(ve, nf.Meta) {
+			continue
+		}
+		vs.addBlobReferencesLocked(nf.Meta.FileBacking)
+	}
+}
+
+// isMovedFile returns true if the version edit deletes the given file from
+// another level, ie, the file is moved rather than added.
+func isMovedFile(ve *versionEdit, m *fileMetadata) bool {
+	for df := range ve.DeletedFiles {
+		if df.FileNum == m.FileNum {
+			return true
+		}
+	}
+	return false
+}
+
+// compactionBlobWriter writes the values of a flush or compaction that are
+// separated into a blob file, and tracks the blob files referenced by each
+// output table.
+type compactionBlobWriter struct {
+	d       *DB
+	jobID   int
+	opts    ValueSeparationOptions
+	split   Split
+	bound   UserKeyPrefixBound
+	extract ShortAttributeExtractor
+	// rewrite holds the blob files whose values are rewritten by the
+	// compaction, rather than referenced by its outputs.
+	rewrite map[base.DiskFileNum]bool
+	// fileSizes holds the sizes of the blob files referenced by the inputs of
+	// the compaction.
+	fileSizes map[base.DiskFileNum]uint64
+	// formatVers is the format major version of the DB. Blob files require
+	// FormatBlobFiles.
+	formatVers FormatMajorVersion
+	// enabled is true if the current output table may reference blob files.
+	enabled bool
+
+	writer *blob.Writer
+	// created is the blob file created by the compaction, if any.
+	created    base.DiskFileNum
+	hasCreated bool
+	// refs are the blob references of the current output table.
+	refs []manifest.BlobReference
+	// referenced holds the handles referenced by the current output table,
+	// whose values are only counted once in refs.
+	referenced map[blob.Handle]struct{}
+	// dedup maps the hashes of the values written to the blob file of the
+	// compaction to their handles, if DedupMinimumSize is set.
+	dedup map[[sha256.Size]byte]blob.Handle
+	// stats are the statistics of the values written to the blob file.
+	stats blobWriteStats
+}
+
+// blobWriteStats are the statistics of the values separated by a flush or
+// compaction, reported in Metrics.BlobFiles.
+type blobWriteStats struct {
+	values       uint64
+	valueBytes   uint64
+	dedupValues  uint64
+	dedupedBytes uint64
+}
+
+// newCompactionBlobWriter returns the blob writer of the given compaction.
+// DB.mu must be held.
+func (d *DB) newCompactionBlobWriter(jobID int, c *compaction) *compactionBlobWriter {
+	w := &compactionBlobWriter{
+		d:         d,
+		jobID:     jobID,
+		opts:      d.opts.Experimental.ValueSeparation,
+		split:     d.opts.Comparer.Split,
+		bound:     d.opts.Experimental.RequiredInPlaceValueBound,
+		extract:   d.opts.Experimental.ShortAttributeExtractor,
+		rewrite:   make(map[base.DiskFileNum]bool),
+		fileSizes: make(map[base.DiskFileNum]uint64),
+
+		formatVers: d.mu.formatVers.vers,
+	}
+	maxGarbageRatio := w.opts.maxGarbageRatio()
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			for _, ref := range f.FileBacking.BlobReferences {
+				w.fileSizes[ref.FileNum] = ref.FileSize
+				if m := d.mu.versions.blobFiles[ref.FileNum]; m != nil && m.garbageRatio() > maxGarbageRatio {
+					w.rewrite[ref.FileNum] = true
+				}
+			}
+		}
+	}
+	return w
+}
+
+// startOutput is called when the compaction starts a new output table. Blob
+// files may only be referenced by local tables of format Pebblev3 or later,
+// written at FormatBlobFiles or later.
+func (w *compactionBlobWriter) startOutput(tableFormat sstable.TableFormat, shared bool) {
+	w.enabled = w.formatVers >= FormatBlobFiles &&
+		tableFormat >= sstable.TableFormatPebblev3 && !shared
+	w.refs = nil
+	w.referenced = nil
+}
+
+// add adds the current key and value of the compaction iterator to the table,
+// separating the value, or referencing the blob file of the value, if
+// possible.
+func (w *compactionBlobWriter) add(tw *sstable.Writer, iter *compactionIter) error {
+	key := iter.Key()
+	value := iter.Value()
+	if lv, ok := iter.blobValue(); ok {
+		attr := lv.Fetcher.Attribute
+		if w.enabled && key.Kind() == InternalKeyKindSet {
+			h, err := blob.DecodeHandleWithLen(lv.ValueOrHandle, uint32(attr.ValueLen))
+			if err != nil {
+				return err
+			}
+			if !w.rewrite[h.FileNum] {
+				w.addRef(h, w.fileSizes[h.FileNum])
+				return tw.AddWithBlobHandle(key, h, attr.ShortAttribute)
+			}
+		}
+		v, _, err := lv.Value(nil)
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+	if !w.enabled || w.opts.MinimumSize <= 0 || len(value) < w.opts.MinimumSize ||
+		key.Kind() != InternalKeyKindSet || w.requiredInPlace(key.UserKey) {
+		return tw.Add(key, value)
+	}
+	if w.writer == nil {
+		if err := w.create(); err != nil {
+			return err
+		}
+	}
+	var attr base.ShortAttribute
+	if w.extract != nil {
+		prefixLen := len(key.UserKey)
+		if w.split != nil {
+			prefixLen = w.split(key.UserKey)
+		}
+		var err error
+		if attr, err = w.extract(key.UserKey, prefixLen, value); err != nil {
+			return err
+		}
+	}
+	h, err := w.addValue(value)
+	if err != nil {
+		return err
+	}
+	w.addRef(h, 0 /* fileSize */)
+	return tw.AddWithBlobHandle(key, h, attr)
+}
+
+// addValue writes the value to the blob file of the compaction, unless it's
+// deduplicated with an identical value already written to it, and returns
+// its handle.
+func (w *compactionBlobWriter) addValue(value []byte) (blob.Handle, error) {
+	var sum [sha256.Size]byte
+	dedup := w.opts.DedupMinimumSize > 0 && len(value) >= w.opts.DedupMinimumSize
+	if dedup {
+		sum = sha256.Sum256(value)
+		if h, ok := w.dedup[sum]; ok {
+			w.stats.dedupValues++
+			w.stats.dedupedBytes += uint64(len(value))
+			return h, nil
+		}
+	}
+	h, err := w.writer.AddValue(value)
+	if err != nil {
+		return blob.Handle{}, err
+	}
+	w.stats.values++
+	w.stats.valueBytes += uint64(len(value))
+	if dedup {
+		if w.dedup == nil {
+			w.dedup = make(map[[sha256.Size]byte]blob.Handle)
+		}
+		w.dedup[sum] = h
+	}
+	return h, nil
+}
+
+// requiredInPlace returns true if the value of the key must be stored with the
+// key, per Options.Experimental.RequiredInPlaceValueBound.
+func (w *compactionBlobWriter) requiredInPlace(userKey []byte) bool {
+	if w.bound.IsEmpty() {
+		return false
+	}
+	prefix := userKey
+	if w.split != nil {
+		prefix = userKey[:w.split(userKey)]
+	}
+	cmp := w.d.cmp
+	return cmp(prefix, w.bound.Lower) >= 0 && cmp(prefix, w.bound.Upper) < 0
+}
+
+func (w *compactionBlobWriter) addRef(h blob.Handle, fileSize uint64) {
+	// A deduplicated value referenced by several keys of the table is only
+	// counted once.
+	if _, ok := w.referenced[h]; ok {
+		return
+	}
+	if w.referenced == nil {
+		w.referenced = make(map[blob.Handle]struct{})
+	}
+	w.referenced[h] = struct{}{}
+	for i := range w.refs {
+		if w.refs[i].FileNum == h.FileNum {
+			w.refs[i].ValueSize += uint64(h.ValueLen)
+			return
+		}
+	}
+	w.refs = append(w.refs, manifest.BlobReference{
+		FileNum:   h.FileNum,
+		FileSize:  fileSize,
+		ValueSize: uint64(h.ValueLen),
+	})
+}
+
+// create creates the blob file of the compaction.
+func (w *compactionBlobWriter) create() error {
+	w.d.mu.Lock()
+	fileNum := w.d.mu.versions.getNextFileNum().DiskFileNum()
+	w.d.mu.Unlock()
+	writable, _, err := w.d.objProvider.Create(
+		context.TODO(), fileTypeBlob, fileNum, objstorage.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	w.writer = blob.NewWriter(writable, fileNum)
+	w.created, w.hasCreated = fileNum, true
+	return nil
+}
+
+// finishOutput returns the blob references of the current output table.
+func (w *compactionBlobWriter) finishOutput() []manifest.BlobReference {
+	refs := w.refs
+	w.refs = nil
+	w.referenced = nil
+	return refs
+}
+
+// finish finishes the blob file of the compaction, if any, and sets its size
+// in the references of the output tables.
+func (w *compactionBlobWriter) finish(ve *versionEdit) error {
+	if w.writer == nil {
+		return nil
+	}
+	writer := w.writer
+	w.writer = nil
+	if err := writer.Finish(); err != nil {
+		return err
+	}
+	for _, nf := range ve.NewFiles {
+		refs := nf.Meta.FileBacking.BlobReferences
+		for i := range refs {
+			if refs[i].FileNum == writer.FileNum() {
+				refs[i].FileSize = writer.Size()
+			}
+		}
+	}
+	return nil
+}
+
+// abort aborts the blob file of the compaction, and removes it if it was
+// created.
+func (w *compactionBlobWriter) abort() {
+	if w.writer != nil {
+		w.writer.Abort()
+		w.writer = nil
+	}
+	if w.hasCreated {
+		_ = w.d.objProvider.Remove(fileTypeBlob, w.created)
+	}
+}
+
+// addBlobWriteStatsLocked adds the statistics of the values separated by a
+// flush or compaction to the metrics. DB.mu must be held.
+func (vs *versionSet) addBlobWriteStatsLocked(s blobWriteStats) {
+	m := &vs.metrics.BlobFiles
+	m.ValuesWritten += s.values
+	m.ValueBytesWritten += s.valueBytes
+	m.DedupValues += s.dedupValues
+	m.DedupBytes += s.dedupedBytes
+}
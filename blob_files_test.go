@@ -0,0 +1,234 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSeparation(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{
+		FS:                          fs,
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+		Logger:                      panicLogger{},
+	}
+	opts.Experimental.EnableValueBlocks = func() bool { return true }
+	opts.Experimental.ValueSeparation = ValueSeparationOptions{
+		MinimumSize:     64,
+		MaxGarbageRatio: 0.25,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const numKeys = 100
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%03d", i)) }
+	// The values of the even keys are large enough to be separated.
+	value := func(i, gen int) []byte {
+		if i%2 == 0 {
+			return bytes.Repeat([]byte(fmt.Sprintf("%03d.%d.", i, gen)), 20)
+		}
+		return []byte(fmt.Sprintf("small-%03d-%d", i, gen))
+	}
+	write := func(gen int, keep func(i int) bool) {
+		for i := 0; i < numKeys; i++ {
+			if keep(i) {
+				require.NoError(t, d.Set(key(i), value(i, gen), nil))
+			}
+		}
+		require.NoError(t, d.Flush())
+	}
+	// check verifies the values of the keys, with gens returning the
+	// generation of the value of each key.
+	check := func(d *DB, gens func(i int) int) {
+		for i := 0; i < numKeys; i++ {
+			v, closer, err := d.Get(key(i))
+			require.NoError(t, err)
+			require.Equal(t, value(i, gens(i)), v)
+			require.NoError(t, closer.Close())
+		}
+		iter := d.NewIter(nil)
+		var n int
+		for valid := iter.First(); valid; valid = iter.Next() {
+			require.Equal(t, key(n), iter.Key())
+			require.Equal(t, value(n, gens(n)), iter.Value())
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, numKeys, n)
+	}
+	blobFiles := func(fs vfs.FS, dir string) []base.DiskFileNum {
+		ls, err := fs.List(dir)
+		require.NoError(t, err)
+		var fileNums []base.DiskFileNum
+		for _, name := range ls {
+			if fileType, fileNum, ok := base.ParseFilename(fs, name); ok && fileType == fileTypeBlob {
+				fileNums = append(fileNums, fileNum)
+			}
+		}
+		sort.Slice(fileNums, func(i, j int) bool { return fileNums[i].FileNum() < fileNums[j].FileNum() })
+		return fileNums
+	}
+	compact := func() {
+		require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false /* parallelize */))
+	}
+
+	// The flush separates the large values into a blob file.
+	write(1, func(int) bool { return true })
+	check(d, func(int) int { return 1 })
+	m := d.Metrics()
+	require.EqualValues(t, 1, m.BlobFiles.Count)
+	require.EqualValues(t, numKeys/2*120, m.BlobFiles.LiveValueSize)
+	first := blobFiles(fs, "")
+	require.Len(t, first, 1)
+
+	// Compactions reference the values of the blob file rather than rewriting
+	// them.
+	compact()
+	check(d, func(int) int { return 1 })
+	require.Equal(t, first, blobFiles(fs, ""))
+	require.EqualValues(t, numKeys/2*120, d.Metrics().BlobFiles.LiveValueSize)
+
+	// The blob files are read after reopening the DB.
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	check(d, func(int) int { return 1 })
+	require.EqualValues(t, 1, d.Metrics().BlobFiles.Count)
+
+	// Overwriting most of the values leaves garbage in the first blob file,
+	// whose remaining values are rewritten by the next compaction of the table
+	// referencing them, after which it's deleted.
+	overwritten := func(i int) bool { return i >= 10 }
+	key1Gen := 1
+	gens := func(i int) int {
+		switch {
+		case overwritten(i):
+			return 2
+		case i == 1:
+			return key1Gen
+		}
+		return 1
+	}
+	write(2, overwritten)
+	compact()
+	check(d, gens)
+	require.Contains(t, blobFiles(fs, ""), first[0])
+	write(3, func(i int) bool { return i == 1 })
+	key1Gen = 3
+	compact()
+	check(d, gens)
+	require.NotContains(t, blobFiles(fs, ""), first[0])
+	m = d.Metrics()
+	require.EqualValues(t, len(blobFiles(fs, "")), m.BlobFiles.Count)
+	require.EqualValues(t, numKeys/2*120, m.BlobFiles.LiveValueSize)
+
+	// Checkpoints include the blob files.
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	require.Equal(t, blobFiles(fs, ""), blobFiles(fs, "checkpoint"))
+	d2, err := Open("checkpoint", opts)
+	require.NoError(t, err)
+	check(d2, gens)
+	require.NoError(t, d2.Close())
+}
+
+func TestValueSeparationFormatMajorVersion(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          FormatBlobFiles - 1,
+		DisableAutomaticCompactions: true,
+		Logger:                      panicLogger{},
+	}
+	opts.Experimental.EnableValueBlocks = func() bool { return true }
+	opts.Experimental.ValueSeparation = ValueSeparationOptions{MinimumSize: 64}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Values aren't separated below FormatBlobFiles.
+	value := bytes.Repeat([]byte("v"), 100)
+	require.NoError(t, d.Set([]byte("a"), value, nil))
+	require.NoError(t, d.Flush())
+	require.EqualValues(t, 0, d.Metrics().BlobFiles.Count)
+
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatBlobFiles))
+	require.NoError(t, d.Set([]byte("b"), value, nil))
+	require.NoError(t, d.Flush())
+	require.EqualValues(t, 1, d.Metrics().BlobFiles.Count)
+	for _, k := range []string{"a", "b"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, value, v)
+		require.NoError(t, closer.Close())
+	}
+}
+
+func TestValueSeparationDedup(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{
+		FS:                          fs,
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+		Logger:                      panicLogger{},
+	}
+	opts.Experimental.EnableValueBlocks = func() bool { return true }
+	opts.Experimental.ValueSeparation = ValueSeparationOptions{
+		MinimumSize:      64,
+		DedupMinimumSize: 256,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Each of the keys holds one of a few artifacts, which are large enough to
+	// be deduplicated, except for the last one.
+	artifacts := [][]byte{
+		bytes.Repeat([]byte("a"), 1000),
+		bytes.Repeat([]byte("b"), 2000),
+		bytes.Repeat([]byte("c"), 100),
+	}
+	const numKeys = 90
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%03d", i)) }
+	for i := 0; i < numKeys; i++ {
+		require.NoError(t, d.Set(key(i), artifacts[i%3], nil))
+	}
+	require.NoError(t, d.Flush())
+	check := func() {
+		for i := 0; i < numKeys; i++ {
+			v, closer, err := d.Get(key(i))
+			require.NoError(t, err)
+			require.Equal(t, artifacts[i%3], v)
+			require.NoError(t, closer.Close())
+		}
+	}
+	check()
+
+	m := d.Metrics()
+	require.EqualValues(t, 1, m.BlobFiles.Count)
+	require.EqualValues(t, 2+numKeys/3, m.BlobFiles.ValuesWritten)
+	require.EqualValues(t, 3000+numKeys/3*100, m.BlobFiles.ValueBytesWritten)
+	require.EqualValues(t, 2*(numKeys/3-1), m.BlobFiles.DedupValues)
+	require.EqualValues(t, 3000*(numKeys/3-1), m.BlobFiles.DedupBytes)
+	require.InDelta(t, float64(3000*29)/float64(3000*30+3000), m.BlobDedupRatio(), 1e-9)
+	// The shared values are counted once by the table referencing them.
+	require.EqualValues(t, 3000+numKeys/3*100, m.BlobFiles.LiveValueSize)
+
+	// The compaction references the shared values rather than rewriting them.
+	require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false /* parallelize */))
+	check()
+	m = d.Metrics()
+	require.EqualValues(t, 1, m.BlobFiles.Count)
+	require.EqualValues(t, 2+numKeys/3, m.BlobFiles.ValuesWritten)
+	require.EqualValues(t, 3000+numKeys/3*100, m.BlobFiles.LiveValueSize)
+}
@@ -21,3 +21,13 @@ type Cache = cache.Cache
 func NewCache(size int64) *cache.Cache {
 	return cache.New(size)
 }
+
+// CacheAdmissionPolicy exports the cache.AdmissionPolicy type, which is set
+// with Cache.SetAdmissionPolicy.
+type CacheAdmissionPolicy = cache.AdmissionPolicy
+
+// Exported CacheAdmissionPolicy constants.
+const (
+	CacheAdmitAll     = cache.AdmitAll
+	CacheAdmitTinyLFU = cache.AdmitTinyLFU
+)
@@ -5,12 +5,25 @@
 package pebble
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
 	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/pebble/vfs/atomicfs"
 )
@@ -24,6 +37,17 @@ type checkpointOptions struct {
 
 	// If set, any SSTs that don't overlap with these spans are excluded from a checkpoint.
 	restrictToSpans []CheckpointSpan
+
+	// If set, SSTs that partially overlap with restrictToSpans are rewritten to
+	// contain only the keys within the spans.
+	trimToSpans bool
+
+	// If set, files are copied instead of hard linked.
+	copyFiles bool
+
+	// If set, the checkpoint is incremental to the checkpoint in this
+	// directory.
+	incrementalBase string
 }
 
 // CheckpointOption set optional parameters used by `DB.Checkpoint`.
@@ -57,6 +81,50 @@ func WithRestrictToSpans(spans []CheckpointSpan) CheckpointOption {
 	}
 }
 
+// WithTrimToSpans, when used along with WithRestrictToSpans, causes SSTs that
+// partially overlap with the spans of interest to be rewritten into the
+// checkpoint, retaining only the keys (and the portions of range deletions and
+// range keys) within the spans. SSTs that are entirely contained within one of
+// the spans are still linked or copied whole. This shrinks the checkpoint of a
+// narrow key range considerably, at the cost of reading and writing the
+// partially overlapping SSTs.
+//
+// Note that the checkpoint can still surface keys outside of the spans of
+// interest from the WAL.
+func WithTrimToSpans() CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.trimToSpans = true
+	}
+}
+
+// WithCopyFiles causes the sstables and the OPTIONS file to be copied into
+// the checkpoint instead of hard linked, eg, so that the checkpoint does not
+// share disk blocks with the DB. The copies are verified as when falling back
+// to copying because hard links are impossible.
+func WithCopyFiles() CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.copyFiles = true
+	}
+}
+
+// WithIncrementalCheckpoint makes the checkpoint incremental to the previous
+// checkpoint in baseDir, on the same filesystem. The sstables and blob files
+// referenced by the MANIFEST of the previous checkpoint aren't linked or
+// copied into the new checkpoint. Instead, the new checkpoint records them as
+// inherited from baseDir in its CHECKPOINT-DELTA file, along with the files
+// added and removed since the previous checkpoint (see ReadCheckpointDelta).
+// The cost of the checkpoint is thus proportional to the files added since
+// the previous checkpoint, which may itself be incremental.
+//
+// An incremental checkpoint can't be opened until it's completed by
+// CompleteIncrementalCheckpoint, which links the inherited files into it. The
+// previous checkpoint must be retained until then.
+func WithIncrementalCheckpoint(baseDir string) CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.incrementalBase = baseDir
+	}
+}
+
 // CheckpointSpan is a key range [Start, End) (inclusive on Start, exclusive on
 // End) of interest for a checkpoint.
 type CheckpointSpan struct {
@@ -81,6 +149,284 @@ func excludeFromCheckpoint(f *fileMetadata, opt *checkpointOptions, cmp Compare)
 	return true
 }
 
+// containedInCheckpointSpans returns true if the SST file is entirely contained
+// within one of the spans of interest (opt.restrictToSpans).
+func containedInCheckpointSpans(f *fileMetadata, opt *checkpointOptions, cmp Compare) bool {
+	for _, s := range opt.restrictToSpans {
+		if cmp(f.Smallest.UserKey, s.Start) < 0 {
+			continue
+		}
+		if c := cmp(f.Largest.UserKey, s.End); c < 0 || (c == 0 && f.Largest.IsExclusiveSentinel()) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeCheckpointSpans returns the spans sorted by start key, with
+// overlapping and abutting spans merged.
+func normalizeCheckpointSpans(spans []CheckpointSpan, cmp Compare) []CheckpointSpan {
+	sorted := append([]CheckpointSpan(nil), spans...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return cmp(sorted[i].Start, sorted[j].Start) < 0
+	})
+	var res []CheckpointSpan
+	for _, s := range sorted {
+		if cmp(s.Start, s.End) >= 0 {
+			continue
+		}
+		if n := len(res); n > 0 && cmp(s.Start, res[n-1].End) <= 0 {
+			if cmp(s.End, res[n-1].End) > 0 {
+				res[n-1].End = s.End
+			}
+			continue
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// trimSSTableForCheckpoint writes a new SST to destDir containing the keys of
+// f within the (normalized) spans. It returns nil if no keys of f fall within
+// the spans.
+func (d *DB) trimSSTableForCheckpoint(
+	fs vfs.FS,
+	destDir string,
+	f *fileMetadata,
+	level int,
+	spans []CheckpointSpan,
+	formatVers FormatMajorVersion,
+) (_ *fileMetadata, err error) {
+	d.mu.Lock()
+	fileNum := d.mu.versions.getNextFileNum()
+	d.mu.Unlock()
+
+	destPath := base.MakeFilepath(fs, destDir, fileTypeTable, fileNum.DiskFileNum())
+	file, err := fs.Create(destPath)
+	if err != nil {
+		return nil, err
+	}
+	writerOpts := d.opts.MakeWriterOptions(level, formatVers.MaxTableFormat())
+	d.stampProvenance(&writerOpts)
+	if !d.compressionDictSupported() {
+		writerOpts.CompressionDict = nil
+	}
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(file), writerOpts)
+	defer func() {
+		if w != nil {
+			_ = w.Close()
+		}
+		if err != nil {
+			_ = fs.Remove(destPath)
+		}
+	}()
+
+	iter, rangeDelIter, err := d.newIters(context.Background(), f, &IterOptions{}, internalIterOpts{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = firstError(err, iter.Close())
+		if rangeDelIter != nil {
+			err = firstError(err, rangeDelIter.Close())
+		}
+	}()
+	var rangeKeyIter keyspan.FragmentIterator
+	if f.HasRangeKeys {
+		if rangeKeyIter, err = d.tableNewRangeKeyIter(f, &keyspan.SpanIterOptions{}); err != nil {
+			return nil, err
+		}
+		defer func() { err = firstError(err, rangeKeyIter.Close()) }()
+	}
+
+	// truncate returns the bounds of the portion of the fragment [start, end)
+	// within the span, if any.
+	truncate := func(start, end []byte, s CheckpointSpan) ([]byte, []byte, bool) {
+		if d.cmp(start, s.Start) < 0 {
+			start = s.Start
+		}
+		if d.cmp(end, s.End) > 0 {
+			end = s.End
+		}
+		return start, end, d.cmp(start, end) < 0
+	}
+	for _, s := range spans {
+		for k, lv := iter.SeekGE(s.Start, base.SeekGEFlagsNone); k != nil && d.cmp(k.UserKey, s.End) < 0; k, lv = iter.Next() {
+			v, _, err := lv.Value(nil)
+			if err != nil {
+				return nil, err
+			}
+			if err := w.Add(*k, v); err != nil {
+				return nil, err
+			}
+		}
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+		if rangeDelIter != nil {
+			for span := rangeDelIter.SeekGE(s.Start); span != nil && d.cmp(span.Start, s.End) < 0; span = rangeDelIter.Next() {
+				start, end, ok := truncate(span.Start, span.End, s)
+				if !ok {
+					continue
+				}
+				for _, k := range span.Keys {
+					if err := w.Add(base.MakeInternalKey(start, k.SeqNum(), k.Kind()), end); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err := rangeDelIter.Error(); err != nil {
+				return nil, err
+			}
+		}
+		if rangeKeyIter != nil {
+			for span := rangeKeyIter.SeekGE(s.Start); span != nil && d.cmp(span.Start, s.End) < 0; span = rangeKeyIter.Next() {
+				start, end, ok := truncate(span.Start, span.End, s)
+				if !ok {
+					continue
+				}
+				truncated := keyspan.Span{Start: start, End: end, Keys: span.Keys}
+				if err := rangekey.Encode(&truncated, w.AddRangeKey); err != nil {
+					return nil, err
+				}
+			}
+			if err := rangeKeyIter.Error(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		w = nil
+		return nil, err
+	}
+	writerMeta, err := w.Metadata()
+	w = nil
+	if err != nil {
+		return nil, err
+	}
+	if !writerMeta.HasPointKeys && !writerMeta.HasRangeDelKeys && !writerMeta.HasRangeKeys {
+		return nil, fs.Remove(destPath)
+	}
+	m := &fileMetadata{
+		FileNum:        fileNum,
+		Size:           writerMeta.Size,
+		CreationTime:   time.Now().Unix(),
+		SmallestSeqNum: writerMeta.SmallestSeqNum,
+		LargestSeqNum:  writerMeta.LargestSeqNum,
+	}
+	if writerMeta.HasPointKeys {
+		m.ExtendPointKeyBounds(d.cmp, writerMeta.SmallestPoint, writerMeta.LargestPoint)
+	}
+	if writerMeta.HasRangeDelKeys {
+		m.ExtendPointKeyBounds(d.cmp, writerMeta.SmallestRangeDel, writerMeta.LargestRangeDel)
+	}
+	if writerMeta.HasRangeKeys {
+		m.ExtendRangeKeyBounds(d.cmp, writerMeta.SmallestRangeKey, writerMeta.LargestRangeKey)
+	}
+	m.InitPhysicalBacking()
+	return m, nil
+}
+
+// checkpointCopier links or copies the files of a checkpoint, and tracks the
+// progress of the copies.
+type checkpointCopier struct {
+	fs            vfs.FS
+	copyFiles     bool
+	eventListener *EventListener
+	filesCopied   int
+	bytesCopied   int64
+}
+
+// linkOrCopy creates destPath as a hard link to srcPath, unless copying was
+// requested with WithCopyFiles. If hard-linking fails, eg, because destPath is
+// on a different filesystem than srcPath, linkOrCopy falls back to a verified
+// copy of the file.
+func (c *checkpointCopier) linkOrCopy(srcPath, destPath string) error {
+	if !c.copyFiles {
+		err := c.fs.Link(srcPath, destPath)
+		if err == nil {
+			return nil
+		}
+		// Copying the file won't fix a missing source or an existing
+		// destination. Other errors, including the permission errors returned
+		// by some filesystems that don't support hard links, fall back to
+		// copying: the copy fails in turn if the error wasn't specific to hard
+		// links.
+		if oserror.IsExist(err) || oserror.IsNotExist(err) {
+			return err
+		}
+	}
+	return c.copy(srcPath, destPath)
+}
+
+// copy copies srcPath to destPath, and verifies the checksum of the copy
+// against the checksum of the data read from srcPath.
+func (c *checkpointCopier) copy(srcPath, destPath string) error {
+	start := time.Now()
+	size, checksum, err := func() (int64, uint32, error) {
+		src, err := c.fs.Open(srcPath, vfs.SequentialReadsOption)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer src.Close()
+		dst, err := c.fs.Create(destPath)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer dst.Close()
+		var w crcWriter
+		n, err := io.Copy(io.MultiWriter(dst, &w), src)
+		if err != nil {
+			return 0, 0, err
+		}
+		return n, uint32(w.crc), dst.Sync()
+	}()
+	if err != nil {
+		return err
+	}
+
+	dst, err := c.fs.Open(destPath, vfs.SequentialReadsOption)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	var w crcWriter
+	n, err := io.Copy(&w, dst)
+	if err != nil {
+		return err
+	}
+	if n != size || uint32(w.crc) != checksum {
+		return base.CorruptionErrorf(
+			"pebble: checkpoint copy of %s to %s does not match: %d bytes with crc %08x, expected %d bytes with crc %08x",
+			errors.Safe(srcPath), errors.Safe(destPath), n, uint32(w.crc), size, checksum)
+	}
+
+	c.filesCopied++
+	c.bytesCopied += size
+	c.eventListener.CheckpointFileCopied(CheckpointCopyInfo{
+		Path:        srcPath,
+		DestPath:    destPath,
+		Size:        size,
+		Checksum:    checksum,
+		FilesCopied: c.filesCopied,
+		BytesCopied: c.bytesCopied,
+		Duration:    time.Since(start),
+	})
+	return nil
+}
+
+// crcWriter is an io.Writer that computes the CRC-32C of the data written to
+// it.
+type crcWriter struct {
+	crc crc.CRC
+}
+
+func (w *crcWriter) Write(p []byte) (int, error) {
+	w.crc = w.crc.Update(p)
+	return len(p), nil
+}
+
 // mkdirAllAndSyncParents creates destDir and any of its missing parents.
 // Those missing parents, as well as the closest existing ancestor, are synced.
 // Returns a handle to the directory created at destDir.
@@ -131,10 +477,17 @@ func mkdirAllAndSyncParents(fs vfs.FS, destDir string) (vfs.File, error) {
 
 // Checkpoint constructs a snapshot of the DB instance in the specified
 // directory. The WAL, MANIFEST, OPTIONS, and sstables will be copied into the
-// snapshot. Hard links will be used when possible. Beware of the significant
-// space overhead for a checkpoint if hard links are disabled. Also beware that
-// even if hard links are used, the space overhead for the checkpoint will
-// increase over time as the DB performs compactions.
+// snapshot. Hard links will be used for the OPTIONS and sstables when
+// possible, unless WithCopyFiles is specified. If hard links are impossible,
+// eg, because the directory is on a different filesystem, the files are
+// copied and the checksums of the copies verified, and the progress of the
+// copies is reported by EventListener.CheckpointFileCopied. Beware of the
+// significant space overhead for a checkpoint if hard links are not used. Also
+// beware that even if hard links are used, the space overhead for the
+// checkpoint will increase over time as the DB performs compactions.
+//
+// The checkpoint may be verified by VerifyCheckpoint, eg, before it's
+// uploaded to a backup.
 //
 // TODO(bananabrick): Test checkpointing of virtual sstables once virtual
 // sstables is running e2e.
@@ -159,6 +512,16 @@ func (d *DB) Checkpoint(
 		return err
 	}
 
+	// The files referenced by the previous checkpoint, if the checkpoint is
+	// incremental.
+	var baseFiles map[string]struct{}
+	if opt.incrementalBase != "" {
+		var err error
+		if baseFiles, err = checkpointFiles(d.opts.FS, opt.incrementalBase); err != nil {
+			return errors.Wrapf(err, "pebble: reading checkpoint %q", opt.incrementalBase)
+		}
+	}
+
 	if opt.flushWAL && !d.opts.DisableWAL {
 		// Write an empty log-data record to flush and sync the WAL.
 		if err := d.LogData(nil /* data */, Sync); err != nil {
@@ -181,8 +544,9 @@ func (d *DB) Checkpoint(
 	// Lock the manifest before getting the current version. We need the
 	// length of the manifest that we read to match the current version that
 	// we read, otherwise we might copy a versionEdit not reflected in the
-	// sstables we copy/link.
-	d.mu.versions.logLock()
+	// sstables we copy/link. Checkpoints are permitted while the LSM is
+	// frozen, which is their point.
+	d.mu.versions.logLockForRead()
 	// Get the unflushed log files, the current version, and the current manifest
 	// file number.
 	memQueue := d.mu.mem.queue
@@ -195,6 +559,8 @@ func (d *DB) Checkpoint(
 	for diskFileNum := range d.mu.versions.fileBackingMap {
 		virtualBackingFiles[diskFileNum] = struct{}{}
 	}
+	// Options.BytesPerSync may be changed by DB.SetOptions.
+	bytesPerSync := d.opts.BytesPerSync
 	// Release the manifest and DB.mu so we don't block other operations on
 	// the database.
 	d.mu.versions.logUnlock()
@@ -204,7 +570,7 @@ func (d *DB) Checkpoint(
 	// vfs.NewSyncingFile.
 	fs := vfs.NewSyncingFS(d.opts.FS, vfs.SyncingFileOptions{
 		NoSyncOnClose: d.opts.NoSyncOnClose,
-		BytesPerSync:  d.opts.BytesPerSync,
+		BytesPerSync:  bytesPerSync,
 	})
 
 	// Create the dir and its parents (if necessary), and sync them.
@@ -217,7 +583,7 @@ func (d *DB) Checkpoint(
 			// Attempt to cleanup on error.
 			paths, _ := fs.List(destDir)
 			for _, path := range paths {
-				_ = fs.Remove(path)
+				_ = fs.Remove(fs.PathJoin(destDir, path))
 			}
 			_ = fs.Remove(destDir)
 		}
@@ -226,17 +592,45 @@ func (d *DB) Checkpoint(
 	if ckErr != nil {
 		return ckErr
 	}
+	copier := &checkpointCopier{
+		fs:            fs,
+		copyFiles:     opt.copyFiles,
+		eventListener: d.opts.EventListener,
+	}
+	var delta *CheckpointDelta
+	if baseFiles != nil {
+		delta = &CheckpointDelta{Base: opt.incrementalBase}
+	}
+	// linkOrCopyFile links or copies the sstable or blob file at srcPath into
+	// the checkpoint, unless it's inherited from the previous checkpoint.
+	linkOrCopyFile := func(srcPath string) error {
+		name := fs.PathBase(srcPath)
+		if delta != nil {
+			if _, ok := baseFiles[name]; ok {
+				delta.Inherited = append(delta.Inherited, name)
+				return nil
+			}
+			delta.Added = append(delta.Added, name)
+		}
+		return copier.linkOrCopy(srcPath, fs.PathJoin(destDir, name))
+	}
 
 	{
 		// Link or copy the OPTIONS.
 		srcPath := base.MakeFilepath(fs, d.dirname, fileTypeOptions, optionsFileNum)
 		destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
-		ckErr = vfs.LinkOrCopy(fs, srcPath, destPath)
+		ckErr = copier.linkOrCopy(srcPath, destPath)
 		if ckErr != nil {
 			return ckErr
 		}
 	}
 
+	// Record the DB from which the checkpoint was created.
+	ckErr = d.writeCheckpointSource(fs, destDir)
+	if ckErr != nil {
+		return ckErr
+	}
+
 	{
 		// Set the format major version in the destination directory.
 		var versionMarker *atomicfs.Marker
@@ -260,21 +654,45 @@ func (d *DB) Checkpoint(
 	}
 
 	var excludedFiles map[deletedFileEntry]*fileMetadata
+	excludeFile := func(l int, f *fileMetadata) {
+		if excludedFiles == nil {
+			excludedFiles = make(map[deletedFileEntry]*fileMetadata)
+		}
+		excludedFiles[deletedFileEntry{
+			Level:   l,
+			FileNum: f.FileNum,
+		}] = f
+	}
+	// Trimmed replacements for SSTs that partially overlap with the spans of
+	// interest.
+	var trimmedFiles []newFileEntry
+	var trimSpans []CheckpointSpan
+	if opt.trimToSpans {
+		trimSpans = normalizeCheckpointSpans(opt.restrictToSpans, d.cmp)
+	}
 	// Set of FileBacking.DiskFileNum which will be required by virtual sstables
 	// in the checkpoint.
 	requiredVirtualBackingFiles := make(map[base.DiskFileNum]struct{})
+	// Set of blob files referenced by the sstables in the checkpoint.
+	requiredBlobFiles := make(map[base.DiskFileNum]struct{})
 	// Link or copy the sstables.
 	for l := range current.Levels {
 		iter := current.Levels[l].Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
 			if excludeFromCheckpoint(f, opt, d.cmp) {
-				if excludedFiles == nil {
-					excludedFiles = make(map[deletedFileEntry]*fileMetadata)
+				excludeFile(l, f)
+				continue
+			}
+			if len(trimSpans) > 0 && !containedInCheckpointSpans(f, opt, d.cmp) {
+				excludeFile(l, f)
+				var m *fileMetadata
+				m, ckErr = d.trimSSTableForCheckpoint(fs, destDir, f, l, trimSpans, formatVers)
+				if ckErr != nil {
+					return ckErr
+				}
+				if m != nil {
+					trimmedFiles = append(trimmedFiles, newFileEntry{Level: l, Meta: m})
 				}
-				excludedFiles[deletedFileEntry{
-					Level:   l,
-					FileNum: f.FileNum,
-				}] = f
 				continue
 			}
 
@@ -286,12 +704,23 @@ func (d *DB) Checkpoint(
 				requiredVirtualBackingFiles[fileBacking.DiskFileNum] = struct{}{}
 			}
 
-			srcPath := base.MakeFilepath(fs, d.dirname, fileTypeTable, fileBacking.DiskFileNum)
-			destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
-			ckErr = vfs.LinkOrCopy(fs, srcPath, destPath)
+			srcPath := base.MakeFilepath(fs, d.tableDirname, fileTypeTable, fileBacking.DiskFileNum)
+			ckErr = linkOrCopyFile(srcPath)
 			if ckErr != nil {
 				return ckErr
 			}
+			for _, ref := range fileBacking.BlobReferences {
+				requiredBlobFiles[ref.FileNum] = struct{}{}
+			}
+		}
+	}
+
+	// Link or copy the blob files.
+	for fileNum := range requiredBlobFiles {
+		srcPath := base.MakeFilepath(fs, d.tableDirname, fileTypeBlob, fileNum)
+		ckErr = linkOrCopyFile(srcPath)
+		if ckErr != nil {
+			return ckErr
 		}
 	}
 
@@ -306,15 +735,34 @@ func (d *DB) Checkpoint(
 
 	ckErr = d.writeCheckpointManifest(
 		fs, formatVers, destDir, dir, manifestFileNum.DiskFileNum(), manifestSize,
-		excludedFiles, removeBackingTables,
+		excludedFiles, removeBackingTables, trimmedFiles,
 	)
 	if ckErr != nil {
 		return ckErr
 	}
 
+	if delta != nil {
+		for _, nf := range trimmedFiles {
+			delta.Added = append(delta.Added, base.MakeFilename(fileTypeTable, nf.Meta.FileBacking.DiskFileNum))
+		}
+		inherited := make(map[string]struct{}, len(delta.Inherited))
+		for _, name := range delta.Inherited {
+			inherited[name] = struct{}{}
+		}
+		for name := range baseFiles {
+			if _, ok := inherited[name]; !ok {
+				delta.Removed = append(delta.Removed, name)
+			}
+		}
+		ckErr = writeCheckpointDelta(fs, destDir, delta)
+		if ckErr != nil {
+			return ckErr
+		}
+	}
+
 	// Copy the WAL files. We copy rather than link because WAL file recycling
 	// will cause the WAL files to be reused which would invalidate the
-	// checkpoint.
+	// checkpoint. Striped WALs are copied unstriped.
 	for i := range memQueue {
 		logNum := memQueue[i].logNum
 		if logNum == 0 {
@@ -322,7 +770,7 @@ func (d *DB) Checkpoint(
 		}
 		srcPath := base.MakeFilepath(fs, d.walDirname, fileTypeLog, logNum.DiskFileNum())
 		destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
-		ckErr = vfs.Copy(fs, srcPath, destPath)
+		ckErr = d.copyWAL(fs, srcPath, logNum, destPath)
 		if ckErr != nil {
 			return ckErr
 		}
@@ -347,6 +795,7 @@ func (d *DB) writeCheckpointManifest(
 	manifestSize int64,
 	excludedFiles map[deletedFileEntry]*fileMetadata,
 	removeBackingTables []base.DiskFileNum,
+	trimmedFiles []newFileEntry,
 ) error {
 	// Copy the MANIFEST, and create a pointer to it. We copy rather
 	// than link because additional version edits added to the
@@ -396,10 +845,20 @@ func (d *DB) writeCheckpointManifest(
 		}
 
 		if len(excludedFiles) > 0 {
-			// Write out an additional VersionEdit that deletes the excluded SST files.
+			// Write out an additional VersionEdit that deletes the excluded SST
+			// files, and adds the trimmed replacements of partially overlapping
+			// SST files.
 			ve := versionEdit{
 				DeletedFiles:         excludedFiles,
 				RemovedBackingTables: removeBackingTables,
+				NewFiles:             trimmedFiles,
+			}
+			// The trimmed files were allocated file numbers after the MANIFEST
+			// was copied, so the next file number must be advanced past them.
+			for _, nf := range trimmedFiles {
+				if nf.Meta.FileNum >= ve.NextFileNum {
+					ve.NextFileNum = nf.Meta.FileNum + 1
+				}
 			}
 
 			rw, err := w.Next()
@@ -433,3 +892,332 @@ func (d *DB) writeCheckpointManifest(
 	}
 	return manifestMarker.Close()
 }
+
+// checkpointDeltaFilename is the name of the file recording the delta of an
+// incremental checkpoint. See ReadCheckpointDelta.
+const checkpointDeltaFilename = "CHECKPOINT-DELTA"
+
+// CheckpointDelta describes an incremental checkpoint relative to the previous
+// checkpoint it was created from. See WithIncrementalCheckpoint.
+type CheckpointDelta struct {
+	// Base is the directory of the previous checkpoint.
+	Base string
+	// Added are the names of the sstables and blob files linked or copied into
+	// the checkpoint, which the previous checkpoint doesn't reference.
+	Added []string
+	// Inherited are the names of the sstables and blob files referenced by
+	// both checkpoints, which are only present in the previous checkpoint (or
+	// in the checkpoints it inherited them from).
+	Inherited []string
+	// Removed are the names of the sstables and blob files referenced by the
+	// previous checkpoint, but not by the checkpoint.
+	Removed []string
+}
+
+// ReadCheckpointDelta returns the delta of the incremental checkpoint in
+// dirname, as recorded by DB.Checkpoint. An error satisfying
+// oserror.IsNotExist is returned if dirname does not contain an incremental
+// checkpoint, or one that was completed by CompleteIncrementalCheckpoint.
+func ReadCheckpointDelta(fs vfs.FS, dirname string) (CheckpointDelta, error) {
+	data, err := readFile(fs, fs.PathJoin(dirname, checkpointDeltaFilename))
+	if err != nil {
+		return CheckpointDelta{}, err
+	}
+	var delta CheckpointDelta
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return CheckpointDelta{}, errors.Errorf("pebble: invalid checkpoint delta line: %q", line)
+		}
+		switch key {
+		case "base":
+			delta.Base = value
+		case "added":
+			delta.Added = append(delta.Added, value)
+		case "inherited":
+			delta.Inherited = append(delta.Inherited, value)
+		case "removed":
+			delta.Removed = append(delta.Removed, value)
+		}
+	}
+	return delta, nil
+}
+
+// writeCheckpointDelta records the delta of an incremental checkpoint in
+// destDir.
+func writeCheckpointDelta(fs vfs.FS, destDir string, delta *CheckpointDelta) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "base=%s\n", delta.Base)
+	for _, names := range []struct {
+		key   string
+		names []string
+	}{{"added", delta.Added}, {"inherited", delta.Inherited}, {"removed", delta.Removed}} {
+		sort.Strings(names.names)
+		for _, name := range names.names {
+			fmt.Fprintf(&buf, "%s=%s\n", names.key, name)
+		}
+	}
+	return writeFile(fs, fs.PathJoin(destDir, checkpointDeltaFilename), buf.Bytes())
+}
+
+// checkpointFiles returns the names of the sstables and blob files referenced
+// by the MANIFEST of the checkpoint in dirname.
+func checkpointFiles(fs vfs.FS, dirname string) (map[string]struct{}, error) {
+	formatVers, versionMarker, err := lookupFormatMajorVersion(fs, dirname)
+	if err != nil {
+		return nil, err
+	}
+	if err := versionMarker.Close(); err != nil {
+		return nil, err
+	}
+	manifestMarker, manifestFileNum, exists, err := findCurrentManifest(formatVers, fs, dirname)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifestMarker.Close(); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, oserror.ErrNotExist
+	}
+	f, err := fs.Open(base.MakeFilepath(fs, dirname, fileTypeManifest, manifestFileNum), vfs.SequentialReadsOption)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The live sstables, and the blob files referenced by the backing sstables
+	// of virtual sstables.
+	live := make(map[base.FileNum]newFileEntry)
+	backingBlobFiles := make(map[base.DiskFileNum][]manifest.BlobReference)
+	rr := record.NewReader(f, 0 /* logNum */)
+	for {
+		r, err := rr.Next()
+		if err == io.EOF || record.IsInvalidRecord(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var ve versionEdit
+		if err := ve.Decode(r); err != nil {
+			if err == io.EOF || record.IsInvalidRecord(err) {
+				break
+			}
+			return nil, err
+		}
+		for df := range ve.DeletedFiles {
+			delete(live, df.FileNum)
+		}
+		for _, b := range ve.CreatedBackingTables {
+			backingBlobFiles[b.DiskFileNum] = b.BlobReferences
+		}
+		for _, nf := range ve.NewFiles {
+			live[nf.Meta.FileNum] = nf
+		}
+	}
+
+	files := make(map[string]struct{})
+	for _, nf := range live {
+		backingFileNum, blobRefs := nf.BackingFileNum, backingBlobFiles[nf.BackingFileNum]
+		if !nf.Meta.Virtual {
+			backingFileNum, blobRefs = nf.Meta.FileBacking.DiskFileNum, nf.Meta.FileBacking.BlobReferences
+		}
+		files[base.MakeFilename(fileTypeTable, backingFileNum)] = struct{}{}
+		for _, ref := range blobRefs {
+			files[base.MakeFilename(fileTypeBlob, ref.FileNum)] = struct{}{}
+		}
+	}
+	return files, nil
+}
+
+// CompleteIncrementalCheckpoint links or copies the files that the incremental
+// checkpoint in dirname inherits from the previous checkpoints into it, so
+// that it may be opened without them. The CHECKPOINT-DELTA file is removed
+// once the checkpoint is complete. See WithIncrementalCheckpoint.
+func CompleteIncrementalCheckpoint(fs vfs.FS, dirname string) error {
+	delta, err := ReadCheckpointDelta(fs, dirname)
+	if err != nil {
+		return err
+	}
+	listener := &EventListener{}
+	listener.EnsureDefaults(DefaultLogger)
+	copier := &checkpointCopier{fs: fs, eventListener: listener}
+	// bases caches the deltas of the previous checkpoints, which are
+	// themselves incremental if they inherit files.
+	bases := map[string]CheckpointDelta{dirname: delta}
+	for _, name := range delta.Inherited {
+		destPath := fs.PathJoin(dirname, name)
+		if _, err := fs.Stat(destPath); err == nil {
+			// Linked by a previous, interrupted, call.
+			continue
+		}
+		// Walk the chain of previous checkpoints up to the one holding the
+		// file.
+		for dir := delta.Base; ; {
+			srcPath := fs.PathJoin(dir, name)
+			if _, err := fs.Stat(srcPath); err == nil {
+				if err := copier.linkOrCopy(srcPath, destPath); err != nil {
+					return err
+				}
+				break
+			} else if !oserror.IsNotExist(err) {
+				return err
+			}
+			baseDelta, ok := bases[dir]
+			if !ok {
+				if baseDelta, err = ReadCheckpointDelta(fs, dir); err != nil {
+					return errors.Wrapf(err, "pebble: locating %s of checkpoint %q", errors.Safe(name), dirname)
+				}
+				bases[dir] = baseDelta
+			}
+			dir = baseDelta.Base
+		}
+	}
+
+	dir, err := fs.OpenDir(dirname)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return err
+	}
+	if err := fs.Remove(fs.PathJoin(dirname, checkpointDeltaFilename)); err != nil {
+		return err
+	}
+	return dir.Sync()
+}
+
+// CheckpointReport describes a checkpoint verified by VerifyCheckpoint.
+type CheckpointReport struct {
+	// FormatMajorVersion is the format major version of the checkpoint.
+	FormatMajorVersion FormatMajorVersion
+	// Tables is the number of sstables referenced by the checkpoint, and
+	// TableSize their total size in bytes. The physical sstables backing
+	// virtual sstables are counted once.
+	Tables    int
+	TableSize uint64
+	// BlobFiles is the number of blob files referenced by the checkpoint, and
+	// BlobFileSize their total size in bytes.
+	BlobFiles    int
+	BlobFileSize uint64
+	// WALs is the number of WAL files replayed when opening the checkpoint.
+	WALs int
+	// Points and Tombstones are the number of point keys and tombstones read
+	// when verifying the level invariants. See CheckLevelsStats.
+	Points     int64
+	Tombstones int
+	// VisibleSeqNum is the sequence number below which the writes of the
+	// checkpoint, including those replayed from the WALs, are visible.
+	VisibleSeqNum uint64
+	// Duration is the duration of the verification.
+	Duration time.Duration
+}
+
+// VerifyCheckpoint opens the checkpoint in the given directory, as created by
+// DB.Checkpoint, and verifies that it's self-consistent, so that it may be
+// certified before it's archived or uploaded. It verifies that:
+//   - the MANIFEST and OPTIONS files may be read, and the WALs replayed;
+//   - the sstables and blob files referenced by the MANIFEST are present, with
+//     the sizes recorded in the MANIFEST;
+//   - the checksums of every block of the sstables are valid;
+//   - the keys of the sstables satisfy the level invariants (see
+//     DB.CheckLevels).
+//
+// The checkpoint is opened read-only, using the given options, if any, and is
+// left unmodified. Verifying a checkpoint reads it entirely, and is
+// proportional to its size. An error is returned if any of the checks fails,
+// along with the report of the checks performed so far.
+func VerifyCheckpoint(dir string, opts *Options) (CheckpointReport, error) {
+	start := time.Now()
+	var report CheckpointReport
+	err := verifyCheckpoint(dir, opts, &report)
+	report.Duration = time.Since(start)
+	return report, err
+}
+
+func verifyCheckpoint(dir string, opts *Options, report *CheckpointReport) (err error) {
+	if opts == nil {
+		opts = &Options{}
+	} else {
+		opts = opts.Clone()
+	}
+	opts.ReadOnly = true
+	// The WALs of the checkpoint are in the checkpoint directory.
+	opts.WALDir = ""
+	opts.Experimental.ConsistencyCheck = ConsistencyCheckFast
+	opts.EnsureDefaults()
+
+	d, err := Open(dir, opts)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: opening checkpoint %q", dir)
+	}
+	defer func() {
+		err = firstError(err, d.Close())
+	}()
+
+	report.FormatMajorVersion = d.FormatMajorVersion()
+	report.VisibleSeqNum = d.mu.versions.visibleSeqNum.Load()
+	ls, err := opts.FS.List(dir)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	v := d.mu.versions.currentVersion()
+	v.Ref()
+	minUnflushedLogNum := d.mu.versions.minUnflushedLogNum
+	blobFiles := make(map[base.DiskFileNum]uint64, len(d.mu.versions.blobFiles))
+	for fileNum, m := range d.mu.versions.blobFiles {
+		blobFiles[fileNum] = m.size
+	}
+	d.mu.Unlock()
+	defer v.Unref()
+	for _, filename := range ls {
+		if ft, fn, ok := base.ParseFilename(opts.FS, filename); ok &&
+			ft == fileTypeLog && fn.FileNum() >= minUnflushedLogNum {
+			report.WALs++
+		}
+	}
+
+	// Open verified that the sstables are present with the expected sizes.
+	backings := uniqueBackings(v)
+	for _, b := range backings {
+		report.Tables++
+		report.TableSize += b.Size
+	}
+	if err := checkTableBlocks(v, d.objProvider, d.opts, d.cacheID, 1 /* rate */); err != nil {
+		return errors.Wrap(err, "pebble: verifying sstable checksums")
+	}
+
+	var buf bytes.Buffer
+	var args []interface{}
+	for fileNum, size := range blobFiles {
+		report.BlobFiles++
+		report.BlobFileSize += size
+		meta, err := d.objProvider.Lookup(fileTypeBlob, fileNum)
+		var diskSize int64
+		if err == nil {
+			diskSize, err = d.objProvider.Size(meta)
+		}
+		if err == nil && diskSize != int64(size) {
+			err = errors.Errorf("object size mismatch: %d (disk) != %d (MANIFEST)",
+				errors.Safe(diskSize), errors.Safe(size))
+		}
+		if err != nil {
+			buf.WriteString("%s: %v\n")
+			args = append(args, errors.Safe(fileNum), err)
+		}
+	}
+	if buf.Len() > 0 {
+		return errors.Errorf("pebble: verifying blob files:\n"+buf.String(), args...)
+	}
+
+	var stats CheckLevelsStats
+	if err := d.CheckLevels(&stats); err != nil {
+		return errors.Wrap(err, "pebble: verifying level invariants")
+	}
+	report.Points = stats.NumPoints
+	report.Tombstones = stats.NumTombstones
+	return nil
+}
@@ -7,6 +7,7 @@ package pebble
 import (
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
 	"sort"
 	"strings"
@@ -14,7 +15,10 @@ import (
 	"testing"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -347,3 +351,351 @@ func TestCheckpointManyFiles(t *testing.T) {
 		require.Equal(t, 10, n)
 	}
 }
+
+func TestCheckpointTrimToSpans(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		Comparer:                    testkeys.Comparer,
+		FS:                          mem,
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+	}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// A single sstable spanning [a, z], with a range deletion and a range key
+	// that both straddle the span of interest.
+	b := d.NewBatch()
+	for c := 'a'; c <= 'z'; c++ {
+		require.NoError(t, b.Set([]byte{byte(c)}, []byte{byte(c)}, nil))
+	}
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.DeleteRange([]byte("b"), []byte("e"), nil))
+	require.NoError(t, d.RangeKeySet([]byte("e"), []byte("y"), nil, []byte("v"), nil))
+	require.NoError(t, d.Flush())
+	// A second sstable contained within the span, which is linked whole.
+	require.NoError(t, d.Set([]byte("g1"), []byte("g1"), nil))
+	require.NoError(t, d.Flush())
+
+	spans := []CheckpointSpan{
+		{Start: []byte("k"), End: []byte("m")},
+		{Start: []byte("c"), End: []byte("h")},
+		{Start: []byte("g"), End: []byte("i")},
+	}
+	require.NoError(t, d.Checkpoint("checkpoint", WithRestrictToSpans(spans), WithTrimToSpans()))
+
+	c, err := Open("checkpoint", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, c.Close()) }()
+
+	iter := c.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+	var buf strings.Builder
+	for valid := iter.First(); valid; valid = iter.Next() {
+		hasPoint, hasRange := iter.HasPointAndRange()
+		if hasPoint {
+			fmt.Fprintf(&buf, "%s ", iter.Key())
+		}
+		if hasRange && iter.RangeKeyChanged() {
+			start, end := iter.RangeBounds()
+			fmt.Fprintf(&buf, "[%s-%s) ", start, end)
+		}
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, "e [e-i) f g g1 h k [k-m) l ", buf.String())
+
+	// The range deletion was truncated to the spans: keys outside of the
+	// spans that were written after the checkpoint are not deleted by it.
+	require.NoError(t, c.Set([]byte("b"), []byte("b"), nil))
+	v, closer, err := c.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, "b", string(v))
+	require.NoError(t, closer.Close())
+
+	// A new sstable written by the checkpoint does not reuse the file number
+	// of the trimmed sstable.
+	require.NoError(t, c.Flush())
+	require.Equal(t, int64(3), c.Metrics().Total().NumFiles)
+	v, closer, err = c.Get([]byte("k"))
+	require.NoError(t, err)
+	require.Equal(t, "k", string(v))
+	require.NoError(t, closer.Close())
+}
+
+// noLinkFS is a vfs.FS that does not support hard links, like a checkpoint
+// destination on a different filesystem. If corruptTables is set, the
+// sstables it creates are corrupted.
+type noLinkFS struct {
+	vfs.FS
+	corruptTables bool
+}
+
+func (fs *noLinkFS) Link(oldname, newname string) error {
+	return errors.Newf("link %s %s: invalid cross-device link", oldname, newname)
+}
+
+func (fs *noLinkFS) Create(name string) (vfs.File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil || !fs.corruptTables || !strings.HasSuffix(name, ".sst") {
+		return f, err
+	}
+	return &corruptingFile{File: f}, nil
+}
+
+// corruptingFile flips the bits of the first byte written to it.
+type corruptingFile struct {
+	vfs.File
+	written bool
+}
+
+func (f *corruptingFile) Write(p []byte) (int, error) {
+	if !f.written && len(p) > 0 {
+		f.written = true
+		p = append([]byte{^p[0]}, p[1:]...)
+	}
+	return f.File.Write(p)
+}
+
+func TestCheckpointCopyFiles(t *testing.T) {
+	fs := &noLinkFS{FS: vfs.NewMem()}
+	var copies []CheckpointCopyInfo
+	opts := &Options{
+		FS:                          fs,
+		DisableAutomaticCompactions: true,
+		EventListener: &EventListener{
+			CheckpointFileCopied: func(info CheckpointCopyInfo) {
+				copies = append(copies, info)
+			},
+		},
+	}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+
+	check := func(dir string) {
+		// The OPTIONS file and both sstables were copied.
+		require.Len(t, copies, 3)
+		var size int64
+		for i, c := range copies {
+			data, err := fs.Open(c.DestPath)
+			require.NoError(t, err)
+			stat, err := data.Stat()
+			require.NoError(t, err)
+			require.NoError(t, data.Close())
+			require.Equal(t, dir, fs.PathDir(c.DestPath))
+			require.Equal(t, stat.Size(), c.Size)
+			size += c.Size
+			require.Equal(t, i+1, c.FilesCopied)
+			require.Equal(t, size, c.BytesCopied)
+		}
+		c, err := Open(dir, &Options{FS: fs})
+		require.NoError(t, err)
+		v, closer, err := c.Get([]byte("b"))
+		require.NoError(t, err)
+		require.Equal(t, "b", string(v))
+		require.NoError(t, closer.Close())
+		require.NoError(t, c.Close())
+	}
+
+	// Hard links are impossible, so the checkpoint falls back to copying.
+	require.NoError(t, d.Checkpoint("checkpoint1"))
+	check("checkpoint1")
+
+	// Copying can be forced even if hard links are possible.
+	copies = nil
+	fs.FS = vfs.NewMem()
+	d2, err := Open("db", &Options{FS: fs.FS, EventListener: opts.EventListener})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d2.Close()) }()
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d2.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d2.Flush())
+	}
+	require.NoError(t, d2.Checkpoint("checkpoint2"))
+	require.Empty(t, copies)
+	require.NoError(t, d2.Checkpoint("checkpoint3", WithCopyFiles()))
+	check("checkpoint3")
+}
+
+func TestCheckpointCopyVerification(t *testing.T) {
+	fs := &noLinkFS{FS: vfs.NewMem()}
+	d, err := Open("db", &Options{FS: fs})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+
+	fs.corruptTables = true
+	err = d.Checkpoint("checkpoint")
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	require.Regexp(t, `checkpoint copy of .* does not match`, err.Error())
+	// The partial checkpoint was removed.
+	_, err = fs.Stat("checkpoint")
+	require.True(t, oserror.IsNotExist(err))
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	fs := vfs.NewMem()
+	d, err := Open("db", &Options{FS: fs, DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	// Unflushed writes are replayed from the WAL of the checkpoint.
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Delete([]byte("a"), nil))
+
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	report, err := VerifyCheckpoint("checkpoint", &Options{FS: fs})
+	require.NoError(t, err)
+	require.Equal(t, d.FormatMajorVersion(), report.FormatMajorVersion)
+	require.Equal(t, 2, report.Tables)
+	require.NotZero(t, report.TableSize)
+	require.Equal(t, 1, report.WALs)
+	require.EqualValues(t, 4, report.Points)
+	require.Equal(t, d.mu.versions.visibleSeqNum.Load(), report.VisibleSeqNum)
+	require.NotZero(t, report.Duration)
+
+	// The checkpoint was opened read-only, and left unmodified.
+	ls, err := fs.List("checkpoint")
+	require.NoError(t, err)
+	report, err = VerifyCheckpoint("checkpoint", &Options{FS: fs})
+	require.NoError(t, err)
+	ls2, err := fs.List("checkpoint")
+	require.NoError(t, err)
+	require.ElementsMatch(t, ls, ls2)
+
+	// A corrupt sstable fails the verification of the checksums.
+	var tables []string
+	for _, filename := range ls {
+		if ft, _, ok := base.ParseFilename(fs, filename); ok && ft == fileTypeTable {
+			tables = append(tables, filename)
+		}
+	}
+	require.Len(t, tables, 2)
+	// The sstables of the checkpoint are hard links to those of the DB, so the
+	// corrupt sstable replaces the link.
+	path := fs.PathJoin("checkpoint", tables[0])
+	f, err := fs.Open(path)
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.Remove(path))
+	copy(data, "corrupt")
+	f, err = fs.Create(path)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	report, err = VerifyCheckpoint("checkpoint", &Options{FS: fs})
+	require.Error(t, err)
+	require.Regexp(t, `verifying sstable checksums: .*checksum mismatch`, err.Error())
+	require.Equal(t, 2, report.Tables)
+
+	// A missing sstable fails the verification when opening the checkpoint.
+	require.NoError(t, fs.Remove(fs.PathJoin("checkpoint", tables[1])))
+	_, err = VerifyCheckpoint("checkpoint", &Options{FS: fs})
+	require.Error(t, err)
+	require.Regexp(t, `opening checkpoint`, err.Error())
+}
+
+func TestCheckpointIncremental(t *testing.T) {
+	fs := vfs.NewMem()
+	d, err := Open("db", &Options{FS: fs, DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	tables := func(dir string) []string {
+		ls, err := fs.List(dir)
+		require.NoError(t, err)
+		var names []string
+		for _, name := range ls {
+			if strings.HasSuffix(name, ".sst") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	get := func(dir, key string) string {
+		c, err := Open(dir, &Options{FS: fs})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, c.Close()) }()
+		v, closer, err := c.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer func() { require.NoError(t, closer.Close()) }()
+		return string(v)
+	}
+
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Checkpoint("checkpoint1"))
+	_, err = ReadCheckpointDelta(fs, "checkpoint1")
+	require.True(t, oserror.IsNotExist(err))
+	baseTables := tables("checkpoint1")
+	require.Len(t, baseTables, 2)
+
+	// Only the sstable added since the previous checkpoint is linked.
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Checkpoint("checkpoint2", WithIncrementalCheckpoint("checkpoint1")))
+	delta, err := ReadCheckpointDelta(fs, "checkpoint2")
+	require.NoError(t, err)
+	require.Equal(t, "checkpoint1", delta.Base)
+	require.Equal(t, tables("checkpoint2"), delta.Added)
+	require.Len(t, delta.Added, 1)
+	require.Equal(t, baseTables, delta.Inherited)
+	require.Empty(t, delta.Removed)
+
+	// A checkpoint may be incremental to an incremental checkpoint. The
+	// compaction replaces all the sstables.
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	require.NoError(t, d.Set([]byte("d"), []byte("d"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Checkpoint("checkpoint3", WithIncrementalCheckpoint("checkpoint2")))
+	delta, err = ReadCheckpointDelta(fs, "checkpoint3")
+	require.NoError(t, err)
+	require.Len(t, delta.Added, 2)
+	require.Empty(t, delta.Inherited)
+	require.Len(t, delta.Removed, 3)
+	require.NoError(t, d.Set([]byte("e"), []byte("e"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Checkpoint("checkpoint4", WithIncrementalCheckpoint("checkpoint3")))
+	delta, err = ReadCheckpointDelta(fs, "checkpoint4")
+	require.NoError(t, err)
+	require.Len(t, delta.Added, 1)
+	require.Len(t, delta.Inherited, 2)
+
+	// Completing the checkpoints links the inherited files, including those
+	// inherited through a chain of checkpoints.
+	require.NoError(t, CompleteIncrementalCheckpoint(fs, "checkpoint4"))
+	require.Len(t, tables("checkpoint4"), 3)
+	_, err = ReadCheckpointDelta(fs, "checkpoint4")
+	require.True(t, oserror.IsNotExist(err))
+	require.Equal(t, "e", get("checkpoint4", "e"))
+	require.Equal(t, "a", get("checkpoint4", "a"))
+	require.NoError(t, CompleteIncrementalCheckpoint(fs, "checkpoint2"))
+	require.Len(t, tables("checkpoint2"), 3)
+	require.Equal(t, "c", get("checkpoint2", "c"))
+	require.Equal(t, "<not found>", get("checkpoint2", "d"))
+
+	// Completing a checkpoint fails if the previous checkpoints are missing.
+	require.NoError(t, d.Set([]byte("f"), []byte("f"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Checkpoint("checkpoint5", WithIncrementalCheckpoint("checkpoint3")))
+	for _, name := range tables("checkpoint3") {
+		require.NoError(t, fs.Remove(fs.PathJoin("checkpoint3", name)))
+	}
+	require.Error(t, CompleteIncrementalCheckpoint(fs, "checkpoint5"))
+}
@@ -53,6 +53,13 @@ type pebbleDB struct {
 func newPebbleDB(dir string) DB {
 	cache := pebble.NewCache(cacheSize)
 	defer cache.Unref()
+	switch cacheAdmission {
+	case pebble.CacheAdmitAll.String():
+	case pebble.CacheAdmitTinyLFU.String():
+		cache.SetAdmissionPolicy(pebble.CacheAdmitTinyLFU)
+	default:
+		log.Fatalf("unknown cache admission policy: %s", cacheAdmission)
+	}
 	opts := &pebble.Options{
 		Cache:                       cache,
 		Comparer:                    mvccComparer,
@@ -16,6 +16,7 @@ import (
 
 var (
 	cacheSize       int64
+	cacheAdmission  string
 	concurrency     int
 	disableWAL      bool
 	duration        time.Duration
@@ -63,6 +64,8 @@ func main() {
 	for _, cmd := range []*cobra.Command{scanCmd, syncCmd, tombstoneCmd, ycsbCmd} {
 		cmd.Flags().Int64Var(
 			&cacheSize, "cache", 1<<30, "cache size")
+		cmd.Flags().StringVar(
+			&cacheAdmission, "cache-admission", "all", "cache admission policy (all or tinylfu)")
 	}
 	for _, cmd := range []*cobra.Command{scanCmd, syncCmd, tombstoneCmd, ycsbCmd, fsBenchCmd, writeBenchCmd} {
 		cmd.Flags().DurationVarP(
@@ -0,0 +1,412 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// ErrColumnFamilyDropped is returned by the operations on a column family
+// that has been dropped by DB.DropColumnFamily.
+var ErrColumnFamilyDropped = errors.New("pebble: column family dropped")
+
+// The keys of the column families are stored under
+// Options.Experimental.ColumnFamilyPrefix, followed by one of these tags:
+//
+//	prefix 0x00 <name>          the ID of the column family with the name
+//	prefix 0x01                 the last column family ID allocated
+//	prefix 0x02 <id> <key>      the key of the column family with the ID
+//
+// IDs are 4-byte big-endian integers, so that the keys of each column family
+// form a contiguous span. IDs are never reused, so that the keys written
+// through the handle of a dropped column family are never visible to another.
+const (
+	columnFamilyTagName   byte = 0x00
+	columnFamilyTagLastID byte = 0x01
+	columnFamilyTagKey    byte = 0x02
+)
+
+// ColumnFamily is a logically separate keyspace of a DB, created by
+// DB.NewColumnFamily. Its keys are written with Batch.SetCF and friends, and
+// read with ColumnFamily.Get and ColumnFamily.NewIter, without being visible
+// to, or colliding with, the keys of other column families.
+//
+// The column families of a DB share its WAL, memtables and LSM: they're
+// stored as the keys of a span of the DB's keyspace under
+// Options.Experimental.ColumnFamilyPrefix. Accordingly, writes to several
+// column families in a batch are atomic, and a column family is dropped by
+// deleting its span, but column families are flushed and compacted together,
+// and the options of the DB, including the LevelOptions, apply to all of
+// them. Options that apply to spans of keys, such as
+// Options.Experimental.DeletionCompactionRanges, may be configured for the
+// span of a column family, which is returned by ColumnFamily.Span.
+type ColumnFamily struct {
+	db   *DB
+	name string
+	// prefix is the prefix of the keys of the column family in the DB's
+	// keyspace.
+	prefix  []byte
+	dropped atomic.Bool
+}
+
+// Name returns the name of the column family.
+func (cf *ColumnFamily) Name() string {
+	return cf.name
+}
+
+// Span returns the span [start, end) of the DB's keyspace holding the keys of
+// the column family.
+func (cf *ColumnFamily) Span() (start, end []byte) {
+	start = append([]byte(nil), cf.prefix...)
+	end = append([]byte(nil), cf.prefix...)
+	// The prefix ends with the tag and the ID, so incrementing the last byte
+	// that isn't 0xff always succeeds.
+	for i := len(end) - 1; ; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return start, end[:i+1]
+		}
+	}
+}
+
+// Get gets the value for the given key of the column family. It returns
+// ErrNotFound if the column family does not contain the key.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The returned
+// slice will remain valid until the returned Closer is closed. On success, the
+// caller MUST call closer.Close() or a memory leak will occur.
+func (cf *ColumnFamily) Get(key []byte) ([]byte, io.Closer, error) {
+	if cf.dropped.Load() {
+		return nil, nil, ErrColumnFamilyDropped
+	}
+	return cf.db.Get(cf.key(nil, key))
+}
+
+// NewIter returns an iterator over the keys of the column family, which is
+// unpositioned. The bounds of the options, if any, are keys of the column
+// family. Only point keys are iterated; the other options are applied to the
+// keys as stored in the DB's keyspace.
+func (cf *ColumnFamily) NewIter(o *IterOptions) (*ColumnFamilyIterator, error) {
+	if cf.dropped.Load() {
+		return nil, ErrColumnFamilyDropped
+	}
+	var opts IterOptions
+	if o != nil {
+		opts = *o
+	}
+	start, end := cf.Span()
+	opts.KeyTypes = IterKeyTypePointsOnly
+	opts.LowerBound = start
+	if o != nil && o.LowerBound != nil {
+		opts.LowerBound = cf.key(nil, o.LowerBound)
+	}
+	opts.UpperBound = end
+	if o != nil && o.UpperBound != nil {
+		opts.UpperBound = cf.key(nil, o.UpperBound)
+	}
+	return &ColumnFamilyIterator{iter: cf.db.NewIter(&opts), prefix: cf.prefix}, nil
+}
+
+// key appends the key of the column family to buf, as stored in the DB's
+// keyspace.
+func (cf *ColumnFamily) key(buf, key []byte) []byte {
+	return append(append(buf, cf.prefix...), key...)
+}
+
+// ColumnFamilyIterator iterates over the keys of a column family. See
+// ColumnFamily.NewIter. Its methods behave like those of Iterator.
+type ColumnFamilyIterator struct {
+	iter   *Iterator
+	prefix []byte
+	buf    []byte
+}
+
+// First moves the iterator to the first key/value pair.
+func (i *ColumnFamilyIterator) First() bool {
+	return i.iter.First()
+}
+
+// Last moves the iterator to the last key/value pair.
+func (i *ColumnFamilyIterator) Last() bool {
+	return i.iter.Last()
+}
+
+// SeekGE moves the iterator to the first key/value pair whose key is greater
+// than or equal to the given key.
+func (i *ColumnFamilyIterator) SeekGE(key []byte) bool {
+	i.buf = append(append(i.buf[:0], i.prefix...), key...)
+	return i.iter.SeekGE(i.buf)
+}
+
+// SeekLT moves the iterator to the last key/value pair whose key is less than
+// the given key.
+func (i *ColumnFamilyIterator) SeekLT(key []byte) bool {
+	i.buf = append(append(i.buf[:0], i.prefix...), key...)
+	return i.iter.SeekLT(i.buf)
+}
+
+// Next moves the iterator to the next key/value pair.
+func (i *ColumnFamilyIterator) Next() bool {
+	return i.iter.Next()
+}
+
+// Prev moves the iterator to the previous key/value pair.
+func (i *ColumnFamilyIterator) Prev() bool {
+	return i.iter.Prev()
+}
+
+// Valid returns true if the iterator is positioned at a valid key/value pair
+// and false otherwise.
+func (i *ColumnFamilyIterator) Valid() bool {
+	return i.iter.Valid()
+}
+
+// Key returns the key of the current key/value pair, without the prefix of
+// the column family in the DB's keyspace. See Iterator.Key.
+func (i *ColumnFamilyIterator) Key() []byte {
+	return i.iter.Key()[len(i.prefix):]
+}
+
+// ValueAndErr returns the value of the current key/value pair. See
+// Iterator.ValueAndErr.
+func (i *ColumnFamilyIterator) ValueAndErr() ([]byte, error) {
+	return i.iter.ValueAndErr()
+}
+
+// Error returns any accumulated error.
+func (i *ColumnFamilyIterator) Error() error {
+	return i.iter.Error()
+}
+
+// Close closes the iterator and returns any accumulated error.
+func (i *ColumnFamilyIterator) Close() error {
+	return i.iter.Close()
+}
+
+// SetCF adds an action to the batch that sets the key of the column family to
+// map to the value. See Batch.Set.
+func (b *Batch) SetCF(cf *ColumnFamily, key, value []byte, _ *WriteOptions) error {
+	if cf.dropped.Load() {
+		return ErrColumnFamilyDropped
+	}
+	deferredOp := b.SetDeferred(len(cf.prefix)+len(key), len(value))
+	copy(deferredOp.Key[copy(deferredOp.Key, cf.prefix):], key)
+	copy(deferredOp.Value, value)
+	return deferredOp.Finish()
+}
+
+// DeleteCF adds an action to the batch that deletes the entry for the key of
+// the column family. See Batch.Delete.
+func (b *Batch) DeleteCF(cf *ColumnFamily, key []byte, _ *WriteOptions) error {
+	if cf.dropped.Load() {
+		return ErrColumnFamilyDropped
+	}
+	deferredOp := b.DeleteDeferred(len(cf.prefix) + len(key))
+	copy(deferredOp.Key[copy(deferredOp.Key, cf.prefix):], key)
+	return deferredOp.Finish()
+}
+
+// DeleteRangeCF adds an action to the batch that deletes the keys of the
+// column family in [start, end). See Batch.DeleteRange.
+func (b *Batch) DeleteRangeCF(cf *ColumnFamily, start, end []byte, opts *WriteOptions) error {
+	if cf.dropped.Load() {
+		return ErrColumnFamilyDropped
+	}
+	return b.DeleteRange(cf.key(nil, start), cf.key(nil, end), opts)
+}
+
+// NewColumnFamily creates a column family with the given name, which must
+// not already exist, and returns its handle. Column families require
+// Options.Experimental.ColumnFamilyPrefix.
+//
+// This is an experimental API.
+func (d *DB) NewColumnFamily(name string) (*ColumnFamily, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if err := d.checkColumnFamilies(name); err != nil {
+		return nil, err
+	}
+	d.columnFamilies.Lock()
+	defer d.columnFamilies.Unlock()
+
+	if _, err := d.columnFamilyIDLocked(name); err == nil {
+		return nil, errors.Errorf("pebble: column family %q already exists", errors.Safe(name))
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	lastIDKey := d.columnFamilyMetaKey(columnFamilyTagLastID, "")
+	var id uint32
+	if v, closer, err := d.Get(lastIDKey); err == nil {
+		if len(v) != 4 {
+			closer.Close()
+			return nil, base.CorruptionErrorf("pebble: invalid last column family ID %x", v)
+		}
+		id = binary.BigEndian.Uint32(v)
+		closer.Close()
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	id++
+	var idBuf [4]byte
+	binary.BigEndian.PutUint32(idBuf[:], id)
+	b := d.NewBatch()
+	defer b.Close()
+	_ = b.Set(lastIDKey, idBuf[:], nil)
+	_ = b.Set(d.columnFamilyMetaKey(columnFamilyTagName, name), idBuf[:], nil)
+	if err := d.Apply(b, Sync); err != nil {
+		return nil, err
+	}
+	return d.columnFamilyHandleLocked(name, id), nil
+}
+
+// ColumnFamily returns the handle of the column family with the given name,
+// which must have been created by NewColumnFamily. It returns ErrNotFound if
+// the column family doesn't exist.
+//
+// This is an experimental API.
+func (d *DB) ColumnFamily(name string) (*ColumnFamily, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if err := d.checkColumnFamilies(name); err != nil {
+		return nil, err
+	}
+	d.columnFamilies.Lock()
+	defer d.columnFamilies.Unlock()
+	if cf, ok := d.columnFamilies.handles[name]; ok {
+		return cf, nil
+	}
+	id, err := d.columnFamilyIDLocked(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.columnFamilyHandleLocked(name, id), nil
+}
+
+// ColumnFamilies returns the names of the column families of the DB, in
+// sorted order.
+//
+// This is an experimental API.
+func (d *DB) ColumnFamilies() ([]string, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if len(d.opts.Experimental.ColumnFamilyPrefix) == 0 {
+		return nil, nil
+	}
+	prefix := d.columnFamilyMetaKey(columnFamilyTagName, "")
+	iter := d.NewIter(&IterOptions{
+		LowerBound: prefix,
+		UpperBound: d.columnFamilyMetaKey(columnFamilyTagName+1, ""),
+	})
+	var names []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		names = append(names, string(iter.Key()[len(prefix):]))
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DropColumnFamily drops the column family with the given name, deleting its
+// keys. The handles of the column family return ErrColumnFamilyDropped once
+// it's dropped, although writes that were added to batches before the drop
+// may still be committed; these are never visible. The space of the keys of
+// the column family is reclaimed by compactions, as with any range deletion.
+//
+// This is an experimental API.
+func (d *DB) DropColumnFamily(name string) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := d.checkColumnFamilies(name); err != nil {
+		return err
+	}
+	d.columnFamilies.Lock()
+	defer d.columnFamilies.Unlock()
+	id, err := d.columnFamilyIDLocked(name)
+	if err != nil {
+		return err
+	}
+	cf := d.columnFamilyHandleLocked(name, id)
+	start, end := cf.Span()
+	b := d.NewBatch()
+	defer b.Close()
+	_ = b.Delete(d.columnFamilyMetaKey(columnFamilyTagName, name), nil)
+	_ = b.DeleteRange(start, end, nil)
+	if err := d.Apply(b, Sync); err != nil {
+		return err
+	}
+	cf.dropped.Store(true)
+	delete(d.columnFamilies.handles, name)
+	return nil
+}
+
+// checkColumnFamilies returns an error if column families aren't enabled, or
+// the name isn't a valid column family name.
+func (d *DB) checkColumnFamilies(name string) error {
+	if len(d.opts.Experimental.ColumnFamilyPrefix) == 0 {
+		return errors.New("pebble: column families require Options.Experimental.ColumnFamilyPrefix")
+	}
+	if name == "" {
+		return errors.New("pebble: column family name must not be empty")
+	}
+	return nil
+}
+
+// columnFamilyMetaKey returns the key of the metadata of the column families
+// with the given tag.
+func (d *DB) columnFamilyMetaKey(tag byte, name string) []byte {
+	prefix := d.opts.Experimental.ColumnFamilyPrefix
+	key := make([]byte, 0, len(prefix)+1+len(name))
+	key = append(append(append(key, prefix...), tag), name...)
+	return key
+}
+
+// columnFamilyIDLocked returns the ID of the column family with the given
+// name, or ErrNotFound if it doesn't exist. d.columnFamilies must be locked.
+func (d *DB) columnFamilyIDLocked(name string) (uint32, error) {
+	v, closer, err := d.Get(d.columnFamilyMetaKey(columnFamilyTagName, name))
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+	if len(v) != 4 {
+		return 0, base.CorruptionErrorf("pebble: invalid ID %x of column family %q", v, errors.Safe(name))
+	}
+	return binary.BigEndian.Uint32(v), nil
+}
+
+// columnFamilyHandleLocked returns the handle of the column family with the
+// given name and ID. d.columnFamilies must be locked.
+func (d *DB) columnFamilyHandleLocked(name string, id uint32) *ColumnFamily {
+	if cf, ok := d.columnFamilies.handles[name]; ok {
+		return cf
+	}
+	prefix := d.columnFamilyMetaKey(columnFamilyTagKey, "")
+	prefix = binary.BigEndian.AppendUint32(prefix, id)
+	cf := &ColumnFamily{db: d, name: name, prefix: prefix}
+	if d.columnFamilies.handles == nil {
+		d.columnFamilies.handles = make(map[string]*ColumnFamily)
+	}
+	d.columnFamilies.handles[name] = cf
+	return cf
+}
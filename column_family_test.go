@@ -0,0 +1,114 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnFamilies(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	// Column families require a prefix.
+	_, err = d.NewColumnFamily("a")
+	require.Error(t, err)
+	require.NoError(t, d.Close())
+
+	opts.Experimental.ColumnFamilyPrefix = []byte("\xffcf")
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	_, err = d.NewColumnFamily("")
+	require.Error(t, err)
+	a, err := d.NewColumnFamily("a")
+	require.NoError(t, err)
+	_, err = d.NewColumnFamily("a")
+	require.Error(t, err)
+	b, err := d.NewColumnFamily("b")
+	require.NoError(t, err)
+	_, err = d.ColumnFamily("c")
+	require.ErrorIs(t, err, ErrNotFound)
+	names, err := d.ColumnFamilies()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, names)
+
+	// The same keys of different column families, and of the DB, are
+	// independent, and written atomically by a batch.
+	batch := d.NewBatch()
+	for _, k := range []string{"k1", "k2", "k3"} {
+		require.NoError(t, batch.SetCF(a, []byte(k), []byte("a"+k), nil))
+		require.NoError(t, batch.SetCF(b, []byte(k), []byte("b"+k), nil))
+	}
+	require.NoError(t, batch.Set([]byte("k1"), []byte("k1"), nil))
+	require.NoError(t, batch.DeleteCF(b, []byte("k2"), nil))
+	require.NoError(t, d.Apply(batch, nil))
+
+	scan := func(cf *ColumnFamily, o *IterOptions) string {
+		iter, err := cf.NewIter(o)
+		require.NoError(t, err)
+		var kvs []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			v, err := iter.ValueAndErr()
+			require.NoError(t, err)
+			kvs = append(kvs, string(iter.Key())+"="+string(v))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(kvs, ",")
+	}
+	require.Equal(t, "k1=ak1,k2=ak2,k3=ak3", scan(a, nil))
+	require.Equal(t, "k1=bk1,k3=bk3", scan(b, nil))
+	require.Equal(t, "k2=ak2", scan(a, &IterOptions{LowerBound: []byte("k2"), UpperBound: []byte("k3")}))
+	iter, err := a.NewIter(nil)
+	require.NoError(t, err)
+	require.True(t, iter.SeekGE([]byte("k2")))
+	require.Equal(t, "k2", string(iter.Key()))
+	require.True(t, iter.SeekLT([]byte("k2")))
+	require.Equal(t, "k1", string(iter.Key()))
+	require.False(t, iter.Prev())
+	require.True(t, iter.Last())
+	require.Equal(t, "k3", string(iter.Key()))
+	require.NoError(t, iter.Close())
+
+	v, closer, err := b.Get([]byte("k1"))
+	require.NoError(t, err)
+	require.Equal(t, "bk1", string(v))
+	require.NoError(t, closer.Close())
+	_, _, err = b.Get([]byte("k2"))
+	require.ErrorIs(t, err, ErrNotFound)
+	v, closer, err = d.Get([]byte("k1"))
+	require.NoError(t, err)
+	require.Equal(t, "k1", string(v))
+	require.NoError(t, closer.Close())
+
+	// Dropping a column family deletes its keys, and invalidates its handle.
+	require.NoError(t, d.DropColumnFamily("a"))
+	_, err = d.ColumnFamily("a")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = a.NewIter(nil)
+	require.ErrorIs(t, err, ErrColumnFamilyDropped)
+	require.ErrorIs(t, d.NewBatch().SetCF(a, []byte("k1"), nil, nil), ErrColumnFamilyDropped)
+	require.Equal(t, "k1=bk1,k3=bk3", scan(b, nil))
+	// A column family created with the name of a dropped one is empty.
+	a, err = d.NewColumnFamily("a")
+	require.NoError(t, err)
+	require.Equal(t, "", scan(a, nil))
+	require.NoError(t, d.Close())
+
+	// Column families persist across restarts.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	names, err = d.ColumnFamilies()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, names)
+	b, err = d.ColumnFamily("b")
+	require.NoError(t, err)
+	require.Equal(t, "k1=bk1,k3=bk3", scan(b, nil))
+	require.NoError(t, d.Close())
+}
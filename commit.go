@@ -11,6 +11,7 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/record"
 )
 
@@ -135,6 +136,14 @@ type commitEnv struct {
 	// the memtable the batch should be applied to. Serial execution enforced by
 	// commitPipeline.mu.
 	write func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error)
+	// Observe the published batch. If non-nil, called in sequence number order
+	// while publishing, after the visible sequence number has been ratcheted
+	// to include the batch. Serial execution enforced by
+	// commitPipeline.observeMu.
+	observe func(b *Batch)
+	// The maximum number of bulk batches in the commit pipeline at once. If
+	// zero, defaults to 1. See CommitClassBulk.
+	bulkCommitConcurrency int
 }
 
 // A commitPipeline manages the stages of committing a set of mutations
@@ -239,9 +248,19 @@ type commitPipeline struct {
 	// logSyncQSem are used for this reservation.
 	commitQueueSem chan struct{}
 	logSyncQSem    chan struct{}
+	// Bulk batches (see CommitClassBulk) additionally reserve a unit of
+	// bulkQueueSem, which limits the share of commitQueueSem that bulk batches
+	// can hold, so that interactive batches don't queue behind bulk loads for
+	// commitQueueSem. Once they hold commitQueueSem, bulk and interactive
+	// batches are treated alike.
+	bulkQueueSem chan struct{}
 	// The mutex to use for synchronizing access to logSeqNum and serializing
 	// calls to commitEnv.write().
 	mu sync.Mutex
+	// The mutex to use for serializing the dequeueing and publishing of
+	// batches when commitEnv.observe is set, so that batches are observed in
+	// sequence number order.
+	observeMu sync.Mutex
 }
 
 func newCommitPipeline(env commitEnv) *commitPipeline {
@@ -264,6 +283,14 @@ func newCommitPipeline(env commitEnv) *commitPipeline {
 		commitQueueSem: make(chan struct{}, record.SyncConcurrency-1),
 		logSyncQSem:    make(chan struct{}, record.SyncConcurrency-1),
 	}
+	bulkConcurrency := env.bulkCommitConcurrency
+	if bulkConcurrency <= 0 {
+		bulkConcurrency = 1
+	}
+	if bulkConcurrency > record.SyncConcurrency-1 {
+		bulkConcurrency = record.SyncConcurrency - 1
+	}
+	p.bulkQueueSem = make(chan struct{}, bulkConcurrency)
 	return p
 }
 
@@ -294,7 +321,12 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool) error {
 	}
 
 	commitStartTime := time.Now()
+	bulk := b.commitClass == CommitClassBulk
 	// Acquire semaphores.
+	if bulk {
+		p.bulkQueueSem <- struct{}{}
+		defer func() { <-p.bulkQueueSem }()
+	}
 	p.commitQueueSem <- struct{}{}
 	if syncWAL {
 		p.logSyncQSem <- struct{}{}
@@ -309,6 +341,14 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool) error {
 	// for reuse. See Batch.release().
 	mem, err := p.prepare(b, syncWAL, noSyncWait)
 	if err != nil {
+		if _, ok := err.(*commitCheckError); ok {
+			// The batch was rejected before being enqueued.
+			if syncWAL {
+				<-p.logSyncQSem
+			}
+			<-p.commitQueueSem
+			return err
+		}
 		b.db = nil // prevent batch reuse on error
 		// NB: we are not doing <-p.commitQueueSem since the batch is still
 		// sitting in the pending queue. We should consider fixing this by also
@@ -419,11 +459,66 @@ func (p *commitPipeline) AllocateSeqNum(
 	<-p.commitQueueSem
 }
 
+// commitCheckError wraps the error returned by the commitCheck of a batch,
+// which rejected the batch before it was sequenced.
+type commitCheckError struct {
+	err error
+}
+
+func (e *commitCheckError) Error() string { return e.err.Error() }
+
+// errCommitCheckContended rejects a batch with a commitCheck when batches
+// keep being sequenced while the check runs, so that the check can't observe
+// them. See commitPipeline.prepare.
+var errCommitCheckContended = errors.New("pebble: commit check contended")
+
+// maxCommitCheckAttempts is the number of times the commit pipeline runs the
+// commitCheck of a batch, before rejecting the batch with
+// errCommitCheckContended.
+const maxCommitCheckAttempts = 16
+
+// commitCheck runs the commitCheck of the batch once the batches sequenced
+// before the current log sequence number are visible, and returns this
+// sequence number. p.mu must not be held.
+func (p *commitPipeline) commitCheck(b *Batch) (uint64, error) {
+	logSeqNum := p.env.logSeqNum.Load()
+	for p.env.visibleSeqNum.Load() < logSeqNum {
+		runtime.Gosched()
+	}
+	return logSeqNum, b.commitCheck(logSeqNum)
+}
+
 func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memTable, error) {
 	n := uint64(b.Count())
 	if n == invalidBatchCount {
 		return nil, ErrInvalidBatch
 	}
+	if b.commitCheck != nil {
+		// Wait for any outstanding writes to the memtable to complete, and run
+		// the check, so that it observes every batch sequenced before this
+		// one. Unlike AllocateSeqNum, this is done without holding p.mu, so
+		// as not to stall the pipeline. Once p.mu is acquired, it only remains
+		// to verify that no batch was sequenced since the check.
+		checkedSeqNum, err := p.commitCheck(b)
+		for i := 1; err == nil; i++ {
+			p.mu.Lock()
+			if p.env.logSeqNum.Load() == checkedSeqNum {
+				break
+			}
+			p.mu.Unlock()
+			if i == maxCommitCheckAttempts {
+				err = errCommitCheckContended
+				break
+			}
+			checkedSeqNum, err = p.commitCheck(b)
+		}
+		if err != nil {
+			return nil, &commitCheckError{err: err}
+		}
+	} else {
+		p.mu.Lock()
+	}
+
 	var syncWG *sync.WaitGroup
 	var syncErr *error
 	switch {
@@ -445,8 +540,6 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memT
 		b.commit.Add(2)
 	}
 
-	p.mu.Lock()
-
 	// Enqueue the batch in the pending queue. Note that while the pending queue
 	// is lock-free, we want the order of batches to be the same as the sequence
 	// number order.
@@ -477,7 +570,7 @@ func (p *commitPipeline) publish(b *Batch) {
 	// batch applies it will go through the same process and publish our batch
 	// for us.
 	for {
-		t := p.pending.dequeue()
+		t := p.dequeueAndPublish()
 		if t == nil {
 			// Wait for another goroutine to publish us. We might also be waiting for
 			// the WAL sync to finish.
@@ -486,27 +579,92 @@ func (p *commitPipeline) publish(b *Batch) {
 			b.commitStats.CommitWaitDuration += time.Since(now)
 			break
 		}
-		if !t.applied.Load() {
-			panic("not reached")
-		}
+		t.commit.Done()
+	}
+}
 
-		// We're responsible for publishing the sequence number for batch t, but
-		// another concurrent goroutine might sneak in and publish the sequence
-		// number for a subsequent batch. That's ok as all we're guaranteeing is
-		// that the sequence number ratchets up.
-		for {
-			curSeqNum := p.env.visibleSeqNum.Load()
-			newSeqNum := t.SeqNum() + uint64(t.Count())
-			if newSeqNum <= curSeqNum {
-				// t's sequence number has already been published.
-				break
-			}
-			if p.env.visibleSeqNum.CompareAndSwap(curSeqNum, newSeqNum) {
-				// We successfully published t's sequence number.
-				break
-			}
+// dequeueAndPublish dequeues an applied batch from the pending queue and
+// publishes its sequence number, returning the batch. It returns nil if the
+// batch at the head of the pending queue has not been applied.
+func (p *commitPipeline) dequeueAndPublish() *Batch {
+	if p.env.observe != nil {
+		// Without observeMu, a goroutine that dequeued a batch could be
+		// preempted by a goroutine that dequeued a subsequent batch, and the
+		// batches would be observed out of order.
+		p.observeMu.Lock()
+		defer p.observeMu.Unlock()
+	}
+	t := p.pending.dequeue()
+	if t == nil {
+		return nil
+	}
+	if !t.applied.Load() {
+		panic("not reached")
+	}
+
+	// We're responsible for publishing the sequence number for batch t, but
+	// another concurrent goroutine might sneak in and publish the sequence
+	// number for a subsequent batch. That's ok as all we're guaranteeing is
+	// that the sequence number ratchets up.
+	for {
+		curSeqNum := p.env.visibleSeqNum.Load()
+		newSeqNum := t.SeqNum() + uint64(t.Count())
+		if newSeqNum <= curSeqNum {
+			// t's sequence number has already been published.
+			break
+		}
+		if p.env.visibleSeqNum.CompareAndSwap(curSeqNum, newSeqNum) {
+			// We successfully published t's sequence number.
+			break
 		}
+	}
 
-		t.commit.Done()
+	if p.env.observe != nil {
+		p.env.observe(t)
 	}
+	return t
+}
+
+// commitMetrics accumulates the BatchCommitStats of committed batches. It is
+// updated without locking, as batches are committed concurrently.
+type commitMetrics struct {
+	count                       atomic.Int64
+	maxDuration                 atomic.Int64
+	totalDuration               atomic.Int64
+	semaphoreWaitDuration       atomic.Int64
+	walQueueWaitDuration        atomic.Int64
+	memTableWriteStallDuration  atomic.Int64
+	l0ReadAmpWriteStallDuration atomic.Int64
+	walRotationDuration         atomic.Int64
+	commitWaitDuration          atomic.Int64
+}
+
+func (m *commitMetrics) record(s *BatchCommitStats) {
+	m.count.Add(1)
+	for {
+		max := m.maxDuration.Load()
+		if int64(s.TotalDuration) <= max || m.maxDuration.CompareAndSwap(max, int64(s.TotalDuration)) {
+			break
+		}
+	}
+	m.totalDuration.Add(int64(s.TotalDuration))
+	m.semaphoreWaitDuration.Add(int64(s.SemaphoreWaitDuration))
+	m.walQueueWaitDuration.Add(int64(s.WALQueueWaitDuration))
+	m.memTableWriteStallDuration.Add(int64(s.MemTableWriteStallDuration))
+	m.l0ReadAmpWriteStallDuration.Add(int64(s.L0ReadAmpWriteStallDuration))
+	m.walRotationDuration.Add(int64(s.WALRotationDuration))
+	m.commitWaitDuration.Add(int64(s.CommitWaitDuration))
+}
+
+func (m *commitMetrics) load(metrics *Metrics) {
+	c := &metrics.Commit
+	c.Count = m.count.Load()
+	c.MaxDuration = time.Duration(m.maxDuration.Load())
+	c.TotalDuration = time.Duration(m.totalDuration.Load())
+	c.SemaphoreWaitDuration = time.Duration(m.semaphoreWaitDuration.Load())
+	c.WALQueueWaitDuration = time.Duration(m.walQueueWaitDuration.Load())
+	c.MemTableWriteStallDuration = time.Duration(m.memTableWriteStallDuration.Load())
+	c.L0ReadAmpWriteStallDuration = time.Duration(m.l0ReadAmpWriteStallDuration.Load())
+	c.WALRotationDuration = time.Duration(m.walRotationDuration.Load())
+	c.CommitWaitDuration = time.Duration(m.commitWaitDuration.Load())
 }
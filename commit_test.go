@@ -13,9 +13,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/arenaskl"
 	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
 	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
@@ -207,6 +210,225 @@ func TestCommitPipelineAllocateSeqNum(t *testing.T) {
 	}
 }
 
+func TestCommitPipelineCommitCheck(t *testing.T) {
+	var e testCommitEnv
+	p := newCommitPipeline(e.env())
+
+	// A batch rejected by its check isn't sequenced.
+	var b Batch
+	_ = b.Set([]byte("a"), nil, nil)
+	b.commitCheck = func(uint64) error { return errors.New("rejected") }
+	require.EqualError(t, p.Commit(&b, false, false), "rejected")
+	require.Equal(t, uint64(0), e.logSeqNum.Load())
+	require.Equal(t, uint64(0), e.writeCount.Load())
+
+	// The check observes the batches sequenced before the batch, once they're
+	// applied. It runs without holding the pipeline's mutex.
+	e.logSeqNum.Store(5)
+	go func() {
+		time.Sleep(time.Millisecond)
+		e.visibleSeqNum.Store(5)
+	}()
+	var observed []uint64
+	b = Batch{}
+	_ = b.Set([]byte("a"), nil, nil)
+	b.commitCheck = func(seqNum uint64) error {
+		require.True(t, p.mu.TryLock())
+		p.mu.Unlock()
+		require.Equal(t, seqNum, e.visibleSeqNum.Load())
+		observed = append(observed, seqNum)
+		return nil
+	}
+	require.NoError(t, p.Commit(&b, false, false))
+	require.Equal(t, []uint64{5}, observed)
+	require.Equal(t, uint64(5), b.SeqNum())
+
+	// If a batch is sequenced while the check runs, the check is run again to
+	// observe it.
+	observed = nil
+	b = Batch{}
+	_ = b.Set([]byte("a"), nil, nil)
+	b.commitCheck = func(seqNum uint64) error {
+		observed = append(observed, seqNum)
+		if len(observed) == 1 {
+			e.logSeqNum.Add(1)
+			e.visibleSeqNum.Add(1)
+		}
+		return nil
+	}
+	require.NoError(t, p.Commit(&b, false, false))
+	require.Equal(t, []uint64{6, 7}, observed)
+	require.Equal(t, uint64(7), b.SeqNum())
+
+	// The batch is rejected if batches keep being sequenced before it is
+	// applied, leaving some unapplied.
+	e.logSeqNum.Add(1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				e.logSeqNum.Add(1)
+				e.visibleSeqNum.Add(1)
+			}
+		}
+	}()
+	b = Batch{}
+	_ = b.Set([]byte("a"), nil, nil)
+	b.commitCheck = func(uint64) error { return nil }
+	require.EqualError(t, p.Commit(&b, false, false), errCommitCheckContended.Error())
+	close(done)
+	<-stopped
+}
+
+func TestCommitPipelineObserve(t *testing.T) {
+	var e testCommitEnv
+	var observed []CommittedBatch
+	env := e.env()
+	env.observe = func(b *Batch) {
+		observed = append(observed, CommittedBatch{SeqNum: b.SeqNum(), Count: b.Count()})
+	}
+	p := newCommitPipeline(env)
+
+	n := 1000
+	if invariants.RaceEnabled {
+		n = 100
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			if i%10 == 0 {
+				p.AllocateSeqNum(2, func(uint64) {}, func(uint64) {})
+				return
+			}
+			var b Batch
+			for j := 0; j <= i%3; j++ {
+				require.NoError(t, b.Set([]byte(fmt.Sprint(i)), nil, nil))
+			}
+			require.NoError(t, p.Commit(&b, false, false))
+		}(i)
+	}
+	wg.Wait()
+
+	// The batches are observed in sequence number order, and their sequence
+	// numbers are contiguous.
+	require.Len(t, observed, n)
+	next := observed[0].SeqNum
+	for _, c := range observed {
+		require.Equal(t, next, c.SeqNum)
+		next += uint64(c.Count)
+	}
+	require.Equal(t, e.visibleSeqNum.Load(), next)
+}
+
+func TestCommitPipelineBulkClass(t *testing.T) {
+	var e testCommitEnv
+	release := make(chan struct{})
+	var applying atomic.Int32
+	env := e.env()
+	env.apply = func(b *Batch, mem *memTable) error {
+		if b.commitClass == CommitClassBulk {
+			applying.Add(1)
+			<-release
+		}
+		return e.apply(b, mem)
+	}
+	p := newCommitPipeline(env)
+
+	commit := func(class CommitClass) chan error {
+		ch := make(chan error, 1)
+		go func() {
+			var b Batch
+			if err := b.Set([]byte("k"), nil, nil); err != nil {
+				ch <- err
+				return
+			}
+			b.commitClass = class
+			ch <- p.Commit(&b, false, false)
+		}()
+		return ch
+	}
+	waitFor := func(cond func() bool) {
+		for !cond() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The first bulk batch is written to the WAL, and blocks while it's
+	// applied to the memtable.
+	bulk1 := commit(CommitClassBulk)
+	waitFor(func() bool { return applying.Load() == 1 })
+	// The second bulk batch waits for the first, as the bulk concurrency
+	// defaults to 1, while an interactive batch is written to the WAL.
+	bulk2 := commit(CommitClassBulk)
+	interactive := commit(CommitClassInteractive)
+	waitFor(func() bool { return e.writeCount.Load() == 2 })
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, uint64(2), e.writeCount.Load())
+	require.Equal(t, int32(1), applying.Load())
+
+	close(release)
+	for _, ch := range []chan error{bulk1, bulk2, interactive} {
+		require.NoError(t, <-ch)
+	}
+	require.Equal(t, uint64(3), e.writeCount.Load())
+	require.Equal(t, uint64(3), e.visibleSeqNum.Load())
+}
+
+func TestApplyCommitted(t *testing.T) {
+	var observed []string
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.ApplyCommitted = func(c CommittedBatch) {
+		s := fmt.Sprintf("%d+%d:", c.SeqNum, c.Count)
+		if c.Repr == nil {
+			s += " <nil>"
+		}
+		r, _ := ReadBatch(c.Repr)
+		for {
+			kind, ukey, _, ok := r.Next()
+			if !ok {
+				break
+			}
+			s += fmt.Sprintf(" %s:%s", kind, ukey)
+		}
+		observed = append(observed, s)
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	b := d.NewBatch()
+	require.NoError(t, b.LogData([]byte("log"), nil))
+	require.NoError(t, d.Apply(b, nil))
+	b = d.NewBatch()
+	require.NoError(t, b.Set([]byte("b"), nil, nil))
+	require.NoError(t, b.DeleteRange([]byte("c"), []byte("d"), nil))
+	require.NoError(t, d.Apply(b, nil))
+
+	f, err := opts.FS.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+	require.NoError(t, w.Set([]byte("e"), nil))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+	require.NoError(t, d.Delete([]byte("a"), nil))
+
+	require.Equal(t, []string{
+		"10+1: SET:a",
+		"11+0: LOGDATA:log",
+		"11+2: SET:b RANGEDEL:c",
+		"13+1: <nil>",
+		"14+1: DEL:a",
+	}, observed)
+}
+
 type syncDelayFile struct {
 	vfs.File
 	done chan struct{}
@@ -13,6 +13,7 @@ import (
 	"runtime/pprof"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -427,12 +428,13 @@ func (u *userKeyChangeSplitter) onNewOutput(key []byte) []byte {
 
 // compactionWritable is a objstorage.Writable wrapper that, on every write,
 // updates a metric in `versions` on bytes written by in-progress compactions so
-// far. It also increments a per-compaction `written` int.
+// far. It also increments a per-compaction `written` int. versions is nil for
+// flushes, which aren't accounted for in the metric.
 type compactionWritable struct {
 	objstorage.Writable
 
 	versions *versionSet
-	written  *int64
+	written  *atomic.Int64
 }
 
 // Write is part of the objstorage.Writable interface.
@@ -441,8 +443,10 @@ func (c *compactionWritable) Write(p []byte) error {
 		return err
 	}
 
-	*c.written += int64(len(p))
-	c.versions.incrementCompactionBytes(int64(len(p)))
+	c.written.Add(int64(len(p)))
+	if c.versions != nil {
+		c.versions.incrementCompactionBytes(int64(len(p)))
+	}
 	return nil
 }
 
@@ -457,6 +461,7 @@ const (
 	compactionKindRead
 	compactionKindRewrite
 	compactionKindIngestedFlushable
+	compactionKindSmallFile
 )
 
 func (k compactionKind) String() string {
@@ -477,6 +482,8 @@ func (k compactionKind) String() string {
 		return "rewrite"
 	case compactionKindIngestedFlushable:
 		return "ingested-flushable"
+	case compactionKindSmallFile:
+		return "small-file"
 	}
 	return "?"
 }
@@ -595,8 +602,30 @@ type compaction struct {
 	flushing flushableList
 	// bytesIterated contains the number of bytes that have been flushed/compacted.
 	bytesIterated uint64
-	// bytesWritten contains the number of bytes that have been written to outputs.
-	bytesWritten int64
+	// bytesWritten contains the number of bytes that have been written to
+	// outputs. It's updated by the goroutine writing the outputs, which may
+	// differ from the compaction goroutine if the sstable Writer uses
+	// parallelism.
+	bytesWritten atomic.Int64
+	// bytesRead mirrors bytesIterated, which is only accessed by the
+	// compaction goroutine, for DB.InProgressCompactions.
+	bytesRead atomic.Uint64
+	// jobID is the ID of the compaction job, or 0 if it hasn't started. It's
+	// protected by DB.mu.
+	jobID int
+	// manualPacer paces the compaction, reports its progress and cancels it if
+	// it is a manual compaction performed by DB.CompactWithOptions or
+	// DB.CompactAsync.
+	manualPacer *manualCompactionPacer
+	// manualBytesReported contains the number of bytes of bytesIterated
+	// accounted for by manualPacer.
+	manualBytesReported uint64
+	// shortLivedExpiredBytes is the size of the sstables of short-lived spans
+	// deleted by a delete-only compaction because they expired.
+	shortLivedExpiredBytes uint64
+	// queueDeletedBytes is the size of the sstables deleted by a delete-only
+	// compaction because their keys were all deleted by point tombstones.
+	queueDeletedBytes uint64
 
 	// The boundaries of the input data.
 	smallest InternalKey
@@ -632,6 +661,19 @@ type compaction struct {
 	// L0Sublevels. If nil, flushes aren't split.
 	l0Limits [][]byte
 
+	// flushRangeDels, if non-nil, are the fragmented range deletions of the
+	// flushing memtables, read by the input iterator of a flush in place of
+	// the range deletions of the memtables. The runs of abutting fragments
+	// that were coalesced are also stored in coalescedRangeDels, and are
+	// written to dedicated sstables. See coalesceFlushRangeDels.
+	flushRangeDels             []keyspan.Span
+	coalescedRangeDels         []keyspan.Span
+	coalescedRangeDelFragments int
+
+	// shortLivedSpans are the short-lived spans of a flush, at whose bounds
+	// the output of the flush is split. See ShortLivedSpan.
+	shortLivedSpans []ShortLivedSpan
+
 	// L0 sublevel info is used for compactions out of L0. It is nil for all
 	// other compactions.
 	l0SublevelInfo []sublevelInfo
@@ -723,11 +765,14 @@ func newCompaction(pc *pickedCompaction, opts *Options, beganAt time.Time) *comp
 
 	c.kind = pc.kind
 	if c.kind == compactionKindDefault && c.outputLevel.files.Empty() && !c.hasExtraLevelData() &&
-		c.startLevel.files.Len() == 1 && c.grandparents.SizeSum() <= c.maxOverlapBytes {
+		c.startLevel.files.Len() == 1 && c.grandparents.SizeSum() <= c.maxOverlapBytes &&
+		opts.Level(c.startLevel.level).sameFilters(opts.Level(c.outputLevel.level)) {
 		// This compaction can be converted into a trivial move from one level
 		// to the next. We avoid such a move if there is lots of overlapping
 		// grandparent data. Otherwise, the move could create a parent file
-		// that will require a very expensive merge later on.
+		// that will require a very expensive merge later on. We also avoid
+		// such a move if the levels have different filter policies, so that
+		// the table is rewritten with the filter of the output level.
 		c.kind = compactionKindMove
 	}
 	return c
@@ -840,6 +885,7 @@ func newFlush(
 		maxOutputFileSize: math.MaxUint64,
 		maxOverlapBytes:   math.MaxUint64,
 		flushing:          flushing,
+		shortLivedSpans:   opts.Experimental.ShortLivedSpans,
 	}
 	c.startLevel = &c.inputs[0]
 	c.outputLevel = &c.inputs[1]
@@ -1229,7 +1275,10 @@ func (c *compaction) newInputIter(
 		if len(c.flushing) == 1 {
 			f := c.flushing[0]
 			iter := f.newFlushIter(nil, &c.bytesIterated)
-			if rangeDelIter := f.newRangeDelIter(nil); rangeDelIter != nil {
+			if c.flushRangeDels != nil {
+				c.rangeDelIter.Init(c.cmp, keyspan.NewIter(c.cmp, c.flushRangeDels))
+				iter = newMergingIter(c.logger, &c.stats, c.cmp, nil, iter, &c.rangeDelIter)
+			} else if rangeDelIter := f.newRangeDelIter(nil); rangeDelIter != nil {
 				c.rangeDelIter.Init(c.cmp, rangeDelIter)
 				iter = newMergingIter(c.logger, &c.stats, c.cmp, nil, iter, &c.rangeDelIter)
 			}
@@ -1247,14 +1296,18 @@ func (c *compaction) newInputIter(
 		for i := range c.flushing {
 			f := c.flushing[i]
 			iters = append(iters, f.newFlushIter(nil, &c.bytesIterated))
-			rangeDelIter := f.newRangeDelIter(nil)
-			if rangeDelIter != nil {
-				rangeDelIters = append(rangeDelIters, rangeDelIter)
+			if c.flushRangeDels == nil {
+				if rangeDelIter := f.newRangeDelIter(nil); rangeDelIter != nil {
+					rangeDelIters = append(rangeDelIters, rangeDelIter)
+				}
 			}
 			if rangeKeyIter := f.newRangeKeyIter(nil); rangeKeyIter != nil {
 				rangeKeyIters = append(rangeKeyIters, rangeKeyIter)
 			}
 		}
+		if c.flushRangeDels != nil {
+			rangeDelIters = append(rangeDelIters, keyspan.NewIter(c.cmp, c.flushRangeDels))
+		}
 		if len(rangeDelIters) > 0 {
 			c.rangeDelIter.Init(c.cmp, rangeDelIters...)
 			iters = append(iters, &c.rangeDelIter)
@@ -1572,6 +1625,7 @@ type manualCompaction struct {
 	start       []byte
 	end         []byte
 	split       bool
+	pacer       *manualCompactionPacer
 }
 
 type readCompaction struct {
@@ -1679,7 +1733,7 @@ func (d *DB) clearCompactingState(c *compaction, rollback bool) {
 }
 
 func (d *DB) calculateDiskAvailableBytes() uint64 {
-	if space, err := d.opts.FS.GetDiskUsage(d.dirname); err == nil {
+	if space, err := d.opts.FS.GetDiskUsage(d.tableDirname); err == nil {
 		d.diskAvailBytes.Store(space.AvailBytes)
 		return space.AvailBytes
 	} else if !errors.Is(err, vfs.ErrUnsupported) {
@@ -1807,6 +1861,8 @@ func (d *DB) maybeScheduleDelayedFlush(tbl *memTable, dur time.Duration) {
 
 func (d *DB) flush() {
 	pprof.Do(context.Background(), flushLabels, func(context.Context) {
+		done := d.opts.Experimental.BackgroundWorkScheduler.Admit(BackgroundWorkFlush)
+		defer done()
 		flushingWorkStart := time.Now()
 		d.mu.Lock()
 		defer d.mu.Unlock()
@@ -1969,11 +2025,14 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 
 	jobID := d.mu.nextJobID
 	d.mu.nextJobID++
-	d.opts.EventListener.FlushBegin(FlushInfo{
+	c.jobID = jobID
+	beginInfo := FlushInfo{
 		JobID:  jobID,
 		Input:  inputs,
 		Ingest: ingest,
-	})
+	}
+	d.opts.EventListener.FlushBegin(beginInfo)
+	span := d.startFlushSpan(beginInfo)
 	startTime := d.timeNow()
 
 	var ve *manifest.VersionEdit
@@ -2023,6 +2082,14 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 		// want to bump the minimum unflushed log number to the log number of the
 		// oldest unflushed memtable.
 		ve.MinUnflushedLogNum = minUnflushedLogNum
+		// Persist the application metadata attached to the most recent of the
+		// flushed flushables, if any, atomically with the flush.
+		for i := n - 1; i >= 0; i-- {
+			if md := d.mu.mem.queue[i].applicationMetadata; md != nil {
+				ve.ApplicationMetadata = md
+				break
+			}
+		}
 		if c.kind != compactionKindIngestedFlushable {
 			metrics := c.metrics[0]
 			if d.opts.DisableWAL {
@@ -2056,6 +2123,8 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 				)
 			}
 			d.mu.versions.updateObsoleteTableMetricsLocked()
+		} else {
+			d.maybeValidateWrittenSSTablesLocked(c, ve.NewFiles)
 		}
 	} else {
 		// We won't be performing the logAndApply step because of the error,
@@ -2070,7 +2139,7 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 	d.maybeUpdateDeleteCompactionHints(c)
 	d.clearCompactingState(c, err != nil)
 	delete(d.mu.compact.inProgress, c)
-	d.mu.versions.incrementCompactions(c.kind, c.extraLevels)
+	d.mu.versions.incrementCompactions(c)
 
 	var flushed flushableList
 	if err == nil {
@@ -2078,12 +2147,42 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 		d.mu.mem.queue = d.mu.mem.queue[n:]
 		d.updateReadStateLocked(d.opts.DebugCheck)
 		d.updateTableStatsLocked(ve.NewFiles)
+		d.mu.versions.metrics.Flush.CoalescedRangeDelSpans += stats.coalescedRangeDelSpans
+		d.mu.versions.metrics.Flush.CoalescedRangeDelFragments += stats.coalescedRangeDelFragments
+		d.mu.versions.metrics.Flush.CoalescedRangeDelTables += stats.coalescedRangeDelTables
+		d.mu.versions.addBlobWriteStatsLocked(stats.blobs)
 		if ingest {
 			d.mu.versions.metrics.Flush.AsIngestCount++
 			for _, l := range c.metrics {
 				d.mu.versions.metrics.Flush.AsIngestBytes += l.BytesIngested
 				d.mu.versions.metrics.Flush.AsIngestTableCount += l.TablesIngested
 			}
+			d.addIngestCompactionHintsLocked(ve.NewFiles)
+		}
+		var tables []FlushedTable
+		for i := range flushed {
+			o := flushed[i].outcome
+			if o == nil {
+				continue
+			}
+			if tables == nil {
+				tables = make([]FlushedTable, len(ve.NewFiles))
+				for j := range ve.NewFiles {
+					tables[j] = FlushedTable{
+						TableInfo: ve.NewFiles[j].Meta.TableInfo(),
+						Level:     ve.NewFiles[j].Level,
+					}
+				}
+			}
+			o.jobID, o.tables, o.err = jobID, tables, nil
+		}
+	} else {
+		// The flush is retried, so the error only records the most recent
+		// failed attempt.
+		for i := 0; i < n; i++ {
+			if o := d.mu.mem.queue[i].outcome; o != nil {
+				o.err = err
+			}
 		}
 	}
 	// Signal FlushEnd after installing the new readState. This helps for unit
@@ -2091,6 +2190,7 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 	// IterOptions.OnlyReadGuaranteedDurable.
 	info.TotalDuration = d.timeNow().Sub(startTime)
 	d.opts.EventListener.FlushEnd(info)
+	endFlushSpan(span, info)
 
 	// The order of these operations matters here for ease of testing.
 	// Removing the reader reference first allows tests to be guaranteed that
@@ -2189,38 +2289,37 @@ func (d *DB) maybeScheduleCompactionPicker(
 		!d.opts.DisableAutomaticCompactions {
 		v := d.mu.versions.currentVersion()
 		snapshots := d.mu.snapshots.toSlice()
-		inputs, unresolvedHints := checkDeleteCompactionHints(d.cmp, v, d.mu.compact.deletionHints, snapshots)
+		inputs, queueBytes, unresolvedHints := checkDeleteCompactionHints(d.cmp, v, d.mu.compact.deletionHints, snapshots)
 		d.mu.compact.deletionHints = unresolvedHints
 
 		if len(inputs) > 0 {
 			c := newDeleteOnlyCompaction(d.opts, v, inputs, d.timeNow())
+			c.queueDeletedBytes = queueBytes
 			d.mu.compact.compactingCount++
 			d.addInProgressCompaction(c)
 			go d.compact(c, nil)
 		}
 	}
 
-	for len(d.mu.compact.manual) > 0 && d.mu.compact.compactingCount < maxConcurrentCompactions {
-		manual := d.mu.compact.manual[0]
-		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
-		pc, retryLater := d.mu.versions.picker.pickManual(env, manual)
-		if pc != nil {
-			c := newCompaction(pc, d.opts, d.timeNow())
-			d.mu.compact.manual = d.mu.compact.manual[1:]
+	// Drop the expired sstables of short-lived spans.
+	if !d.opts.private.disableDeleteOnlyCompactions &&
+		len(d.opts.Experimental.ShortLivedSpans) > 0 &&
+		d.mu.compact.compactingCount < maxConcurrentCompactions &&
+		!d.opts.DisableAutomaticCompactions {
+		v := d.mu.versions.currentVersion()
+		inputs, size := d.pickExpiredShortLivedFiles(v, d.mu.snapshots.toSlice())
+		if len(inputs) > 0 {
+			c := newDeleteOnlyCompaction(d.opts, v, inputs, d.timeNow())
+			c.shortLivedExpiredBytes = size
 			d.mu.compact.compactingCount++
 			d.addInProgressCompaction(c)
-			go d.compact(c, manual.done)
-		} else if !retryLater {
-			// Noop
-			d.mu.compact.manual = d.mu.compact.manual[1:]
-			manual.done <- nil
-		} else {
-			// Inability to run head blocks later manual compactions.
-			manual.retries++
-			break
+			go d.compact(c, nil)
 		}
 	}
 
+	d.mu.compact.manual = d.scheduleManualCompactionsLocked(
+		d.mu.compact.manual, env, maxConcurrentCompactions)
+
 	for !d.opts.DisableAutomaticCompactions && d.mu.compact.compactingCount < maxConcurrentCompactions {
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		env.readCompactionEnv = readCompactionEnv{
@@ -2237,6 +2336,41 @@ func (d *DB) maybeScheduleCompactionPicker(
 		d.addInProgressCompaction(c)
 		go d.compact(c, nil)
 	}
+
+	// The background manual compactions use the compaction slots left over by
+	// the automatic compactions.
+	d.mu.compact.backgroundManual = d.scheduleManualCompactionsLocked(
+		d.mu.compact.backgroundManual, env, maxConcurrentCompactions)
+}
+
+// scheduleManualCompactionsLocked schedules the manual compactions of queue,
+// in order, while compaction slots are available, and returns the remaining
+// ones. d.mu must be held when calling this.
+func (d *DB) scheduleManualCompactionsLocked(
+	queue []*manualCompaction, env compactionEnv, maxConcurrentCompactions int,
+) []*manualCompaction {
+	for len(queue) > 0 && d.mu.compact.compactingCount < maxConcurrentCompactions {
+		manual := queue[0]
+		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
+		pc, retryLater := d.mu.versions.picker.pickManual(env, manual)
+		if pc != nil {
+			c := newCompaction(pc, d.opts, d.timeNow())
+			c.manualPacer = manual.pacer
+			queue = queue[1:]
+			d.mu.compact.compactingCount++
+			d.addInProgressCompaction(c)
+			go d.compact(c, manual.done)
+		} else if !retryLater {
+			// Noop
+			queue = queue[1:]
+			manual.done <- nil
+		} else {
+			// Inability to run head blocks later manual compactions.
+			manual.retries++
+			break
+		}
+	}
+	return queue
 }
 
 // deleteCompactionHintType indicates whether the deleteCompactionHint was
@@ -2320,15 +2454,24 @@ type deleteCompactionHint struct {
 	// notably no longer contain the sstable that contained the key at this
 	// sequence number.
 	fileSmallestSeqNum uint64
+	// file, if set, is the only sstable the hint may delete. Such hints are
+	// created for sstables whose keys were all found to be deleted by the
+	// point tombstones of tombstoneFile (see DB.loadQueueDeletionHints), in
+	// which case [start, end] are the inclusive bounds of the sstable.
+	file *fileMetadata
 }
 
 func (h deleteCompactionHint) String() string {
-	return fmt.Sprintf(
+	s := fmt.Sprintf(
 		"L%d.%s %s-%s seqnums(tombstone=%d-%d, file-smallest=%d, type=%s)",
 		h.tombstoneLevel, h.tombstoneFile.FileNum, h.start, h.end,
 		h.tombstoneSmallestSeqNum, h.tombstoneLargestSeqNum, h.fileSmallestSeqNum,
 		h.hintType,
 	)
+	if h.file != nil {
+		s += fmt.Sprintf(" file=%s", h.file.FileNum)
+	}
+	return s
 }
 
 func (h *deleteCompactionHint) canDelete(cmp Compare, m *fileMetadata, snapshots []uint64) bool {
@@ -2370,6 +2513,10 @@ func (h *deleteCompactionHint) canDelete(cmp Compare, m *fileMetadata, snapshots
 		panic(fmt.Sprintf("pebble: unknown delete compaction hint type: %d", h.hintType))
 	}
 
+	if h.file != nil {
+		return m == h.file
+	}
+
 	// The file's keys must be completely contained within the hint range.
 	return cmp(h.start, m.Smallest.UserKey) <= 0 && cmp(m.Largest.UserKey, h.end) < 0
 }
@@ -2425,7 +2572,7 @@ func (d *DB) maybeUpdateDeleteCompactionHints(c *compaction) {
 
 func checkDeleteCompactionHints(
 	cmp Compare, v *version, hints []deleteCompactionHint, snapshots []uint64,
-) ([]compactionLevel, []deleteCompactionHint) {
+) (_ []compactionLevel, queueBytes uint64, _ []deleteCompactionHint) {
 	var files map[*fileMetadata]bool
 	var byLevel [numLevels][]*fileMetadata
 
@@ -2482,7 +2629,7 @@ func checkDeleteCompactionHints(
 		// The hint h will be resolved and dropped, regardless of whether
 		// there are any tables that can be deleted.
 		for l := h.tombstoneLevel + 1; l < numLevels; l++ {
-			overlaps := v.Overlaps(l, cmp, h.start, h.end, true /* exclusiveEnd */)
+			overlaps := v.Overlaps(l, cmp, h.start, h.end, h.file == nil /* exclusiveEnd */)
 			iter := overlaps.Iter()
 			for m := iter.First(); m != nil; m = iter.Next() {
 				if m.IsCompacting() || !h.canDelete(cmp, m, snapshots) || files[m] {
@@ -2495,6 +2642,9 @@ func checkDeleteCompactionHints(
 				}
 				files[m] = true
 				byLevel[l] = append(byLevel[l], m)
+				if h.file != nil {
+					queueBytes += m.Size
+				}
 			}
 		}
 	}
@@ -2509,15 +2659,19 @@ func checkDeleteCompactionHints(
 			files: manifest.NewLevelSliceKeySorted(cmp, files),
 		})
 	}
-	return compactLevels, unresolvedHints
+	return compactLevels, queueBytes, unresolvedHints
 }
 
 // compact runs one compaction and maybe schedules another call to compact.
 func (d *DB) compact(c *compaction, errChannel chan error) {
 	pprof.Do(context.Background(), compactLabels, func(context.Context) {
+		done := d.opts.Experimental.BackgroundWorkScheduler.Admit(BackgroundWorkCompaction)
+		defer done()
 		d.mu.Lock()
 		defer d.mu.Unlock()
-		if err := d.compact1(c, errChannel); err != nil {
+		if err := d.compact1(c, errChannel); errors.Is(err, ErrCompactionAborted) {
+			d.mu.compact.abortedCount++
+		} else if err != nil && !errors.Is(err, ErrCompactionCancelled) {
 			// TODO(peter): count consecutive compaction errors and backoff.
 			d.opts.EventListener.BackgroundError(err)
 		}
@@ -2549,11 +2703,16 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 
 	jobID := d.mu.nextJobID
 	d.mu.nextJobID++
+	c.jobID = jobID
 	info := c.makeInfo(jobID)
 	d.opts.EventListener.CompactionBegin(info)
+	span := d.startCompactionSpan(info)
 	startTime := d.timeNow()
 
 	ve, pendingOutputs, stats, err := d.runCompaction(jobID, c)
+	if c.manualPacer != nil && err == nil {
+		c.manualPacer.compactionDone(c)
+	}
 
 	info.Duration = d.timeNow().Sub(startTime)
 	if err == nil {
@@ -2576,6 +2735,8 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 				)
 			}
 			d.mu.versions.updateObsoleteTableMetricsLocked()
+		} else {
+			d.maybeValidateWrittenSSTablesLocked(c, ve.NewFiles)
 		}
 	}
 
@@ -2594,11 +2755,12 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	// NB: clearing compacting state must occur before updating the read state;
 	// L0Sublevels initialization depends on it.
 	d.clearCompactingState(c, err != nil)
-	d.mu.versions.incrementCompactions(c.kind, c.extraLevels)
-	d.mu.versions.incrementCompactionBytes(-c.bytesWritten)
+	d.mu.versions.incrementCompactions(c)
+	d.mu.versions.incrementCompactionBytes(-c.bytesWritten.Load())
 
 	info.TotalDuration = d.timeNow().Sub(c.beganAt)
 	d.opts.EventListener.CompactionEnd(info)
+	endCompactionSpan(span, info)
 
 	// Update the read state before deleting obsolete files because the
 	// read-state update will cause the previous version to be unref'd and if
@@ -2607,6 +2769,7 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	if err == nil {
 		d.updateReadStateLocked(d.opts.DebugCheck)
 		d.updateTableStatsLocked(ve.NewFiles)
+		d.mu.versions.addBlobWriteStatsLocked(stats.blobs)
 	}
 	d.deleteObsoleteFiles(jobID, true /* waitForOngoing */)
 
@@ -2616,6 +2779,13 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 type compactStats struct {
 	cumulativePinnedKeys uint64
 	cumulativePinnedSize uint64
+	// The range deletions coalesced by a flush, the fragments they replaced,
+	// and the sstables they were written to.
+	coalescedRangeDelSpans     uint64
+	coalescedRangeDelFragments uint64
+	coalescedRangeDelTables    uint64
+	// The values separated into a blob file.
+	blobs blobWriteStats
 }
 
 // runCompactions runs a compaction that produces new on-disk tables from
@@ -2704,22 +2874,43 @@ func (d *DB) runCompaction(
 		}
 	}()
 
-	snapshots := d.mu.snapshots.toSlice()
+	// Bounded snapshots that don't overlap the compaction don't pin any of the
+	// versions of the keys it compacts.
+	snapshots := d.mu.snapshots.toSliceOverlapping(d.cmp, c.smallest.UserKey, c.largest.UserKey)
 	formatVers := d.mu.formatVers.vers
+	blobs := d.newCompactionBlobWriter(jobID, c)
 
 	// Release the d.mu lock while doing I/O.
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
+	if n := d.opts.Experimental.FlushRangeDelCoalesceThreshold; n > 0 && c.kind == compactionKindFlush {
+		if err := c.coalesceFlushRangeDels(n, snapshots); err != nil {
+			return nil, pendingOutputs, stats, err
+		}
+		stats.coalescedRangeDelSpans = uint64(len(c.coalescedRangeDels))
+		stats.coalescedRangeDelFragments = uint64(c.coalescedRangeDelFragments)
+	}
+
 	iiter, err := c.newInputIter(d.newIters, d.tableNewRangeKeyIter, snapshots)
 	if err != nil {
 		return nil, pendingOutputs, stats, err
 	}
+	// Expired keys are rewritten as point tombstones, which are elided along
+	// with the data they delete when possible.
+	iiter = d.opts.Experimental.TTL.newTTLIter(iiter)
+	// Versions collected as of the GC watermark are rewritten as point
+	// tombstones too.
+	iiter = d.newTimestampGCIter(iiter, c.outputLevel.level, snapshots)
+	iiter = newCompactionFilterIter(iiter, d.opts.Experimental.CompactionFilter, c.outputLevel.level)
 	c.allowedZeroSeqNum = c.allowZeroSeqNum()
 	iter := newCompactionIter(c.cmp, c.equal, c.formatKey, d.merge, iiter, snapshots,
 		&c.rangeDelFrag, &c.rangeKeyFrag, c.allowedZeroSeqNum, c.elideTombstone,
 		c.elideRangeTombstone, d.FormatMajorVersion())
+	if d.opts.Experimental.VerifyCompactions {
+		iter.verifier = &compactionVerifier{}
+	}
 
 	var (
 		createdFiles    []base.DiskFileNum
@@ -2739,6 +2930,7 @@ func (d *DB) runCompaction(
 			for _, fileNum := range createdFiles {
 				_ = d.objProvider.Remove(fileTypeTable, fileNum)
 			}
+			blobs.abort()
 		}
 		for _, closer := range c.closers {
 			retErr = firstError(retErr, closer.Close())
@@ -2782,6 +2974,8 @@ func (d *DB) runCompaction(
 	}
 
 	writerOpts := d.opts.MakeWriterOptions(c.outputLevel.level, tableFormat)
+	d.stampProvenance(&writerOpts)
+	d.trainCompressionDict(&writerOpts, c.outputLevel.level)
 	if formatVers < FormatBlockPropertyCollector {
 		// Cannot yet write block properties.
 		writerOpts.BlockPropertyCollectors = nil
@@ -2849,13 +3043,14 @@ func (d *DB) runCompaction(
 			Path:    d.objProvider.Path(objMeta),
 			FileNum: fileNum,
 		})
+		cw := &compactionWritable{
+			Writable: writable,
+			written:  &c.bytesWritten,
+		}
 		if c.kind != compactionKindFlush {
-			writable = &compactionWritable{
-				Writable: writable,
-				versions: d.mu.versions,
-				written:  &c.bytesWritten,
-			}
+			cw.versions = d.mu.versions
 		}
+		writable = cw
 		createdFiles = append(createdFiles, fileNum.DiskFileNum())
 		cacheOpts := private.SSTableCacheOpts(d.cacheID, fileNum.DiskFileNum()).(sstable.WriterOption)
 
@@ -2868,6 +3063,7 @@ func (d *DB) runCompaction(
 				(cpuWorkHandle.Permitted() || d.opts.Experimental.ForceWriterParallelism)
 
 		tw = sstable.NewWriter(writable, writerOpts, cacheOpts, &prevPointKey)
+		blobs.startOutput(writerOpts.TableFormat, objMeta.IsShared())
 
 		fileMeta.CreationTime = time.Now().Unix()
 		ve.NewFiles = append(ve.NewFiles, newFileEntry{
@@ -2999,6 +3195,7 @@ func (d *DB) runCompaction(
 		meta.SmallestSeqNum = writerMeta.SmallestSeqNum
 		meta.LargestSeqNum = writerMeta.LargestSeqNum
 		meta.InitPhysicalBacking()
+		meta.FileBacking.BlobReferences = blobs.finishOutput()
 
 		// If the file didn't contain any range deletions, we can fill its
 		// table stats now, avoiding unnecessarily loading the table later.
@@ -3055,6 +3252,11 @@ func (d *DB) runCompaction(
 			)
 		}
 
+		if !writerMeta.HasPointKeys && !writerMeta.HasRangeKeys && writerMeta.HasRangeDelKeys &&
+			c.isCoalescedRangeDel(writerMeta.SmallestRangeDel.UserKey) {
+			meta.CoalescedRangeDels = true
+			stats.coalescedRangeDelTables++
+		}
 		if writerMeta.HasPointKeys {
 			meta.ExtendPointKeyBounds(d.cmp, writerMeta.SmallestPoint, writerMeta.LargestPoint)
 		}
@@ -3064,6 +3266,10 @@ func (d *DB) runCompaction(
 		if writerMeta.HasRangeKeys {
 			meta.ExtendRangeKeyBounds(d.cmp, writerMeta.SmallestRangeKey, writerMeta.LargestRangeKey)
 		}
+		if c.kind == compactionKindFlush &&
+			findShortLivedSpan(d.cmp, c.shortLivedSpans, meta.Smallest, meta.Largest) != nil {
+			meta.ShortLived = true
+		}
 
 		// Verify that the sstable bounds fall within the compaction input
 		// bounds. This is a sanity check that we don't have a logic error
@@ -3133,6 +3339,12 @@ func (d *DB) runCompaction(
 	if splitL0Outputs {
 		outputSplitters = append(outputSplitters, newLimitFuncSplitter(&iter.frontiers, c.findL0Limit))
 	}
+	if len(c.shortLivedSpans) > 0 && c.kind == compactionKindFlush {
+		outputSplitters = append(outputSplitters, newLimitFuncSplitter(&iter.frontiers, c.findShortLivedLimit))
+	}
+	if len(c.coalescedRangeDels) > 0 {
+		outputSplitters = append(outputSplitters, newLimitFuncSplitter(&iter.frontiers, c.findCoalescedRangeDelLimit))
+	}
 	splitter := &splitterGroup{cmp: c.cmp, splitters: outputSplitters}
 
 	// Each outer loop iteration produces one output file. An iteration that
@@ -3172,6 +3384,17 @@ func (d *DB) runCompaction(
 			if split := splitter.shouldSplitBefore(key, tw); split == splitNow {
 				break
 			}
+			c.bytesRead.Store(c.bytesIterated)
+			if c.manualPacer != nil {
+				if err := c.manualPacer.maybeThrottle(c, key.UserKey); err != nil {
+					return nil, pendingOutputs, stats, err
+				}
+			}
+			// Compactions are aborted by DB.CloseContext once its context is
+			// done. Their outputs are discarded.
+			if c.kind != compactionKindFlush && d.closeAborted.Load() {
+				return nil, pendingOutputs, stats, ErrCompactionAborted
+			}
 
 			switch key.Kind() {
 			case InternalKeyKindRangeDelete:
@@ -3233,16 +3456,23 @@ func (d *DB) runCompaction(
 					return nil, pendingOutputs, stats, err
 				}
 			}
-			if err := tw.Add(*key, val); err != nil {
+			if err := blobs.add(tw, iter); err != nil {
 				return nil, pendingOutputs, stats, err
 			}
+			if iter.verifier != nil {
+				iter.verifier.keyWritten(key.UserKey, iter.keyTrailer)
+			}
 			if iter.snapshotPinned {
 				// The kv pair we just added to the sstable was only surfaced by
 				// the compaction iterator because an open snapshot prevented
 				// its elision. Increment the stats.
 				pinnedCount++
 				pinnedKeySize += uint64(len(key.UserKey)) + base.InternalTrailerLen
-				pinnedValueSize += uint64(len(val))
+				if lv, ok := iter.blobValue(); ok {
+					pinnedValueSize += uint64(lv.Len())
+				} else {
+					pinnedValueSize += uint64(len(val))
+				}
 			}
 		}
 
@@ -3274,6 +3504,15 @@ func (d *DB) runCompaction(
 		}
 	}
 
+	// Before the inputs are deleted, reconcile the keys read with the keys
+	// written and dropped, unless the iteration failed, in which case the
+	// error is returned when closing the iterator.
+	if iter.verifier != nil && iter.Error() == nil {
+		if err := iter.verifier.verify(jobID, c.kind.String(), d.opts.EventListener.CompactionVerificationFailed); err != nil {
+			return nil, pendingOutputs, stats, err
+		}
+	}
+
 	for _, cl := range c.inputs {
 		iter := cl.files.Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
@@ -3286,6 +3525,11 @@ func (d *DB) runCompaction(
 		}
 	}
 
+	if err := blobs.finish(ve); err != nil {
+		return nil, pendingOutputs, stats, err
+	}
+	stats.blobs = blobs.stats
+
 	if err := d.objProvider.Sync(); err != nil {
 		return nil, pendingOutputs, stats, err
 	}
@@ -3365,6 +3609,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 
 	var obsoleteLogs []fileInfo
 	var obsoleteTables []fileInfo
+	var obsoleteBlobFiles []fileInfo
 	var obsoleteManifests []fileInfo
 	var obsoleteOptions []fileInfo
 
@@ -3423,6 +3668,18 @@ func (d *DB) scanObsoleteFiles(list []string) {
 			}
 			obsoleteTables = append(obsoleteTables, fileInfo)
 
+		case fileTypeBlob:
+			if _, ok := d.mu.versions.blobFiles[obj.DiskFileNum]; ok {
+				continue
+			}
+			fileInfo := fileInfo{
+				fileNum: obj.DiskFileNum,
+			}
+			if size, err := d.objProvider.Size(obj); err == nil {
+				fileInfo.fileSize = uint64(size)
+			}
+			obsoleteBlobFiles = append(obsoleteBlobFiles, fileInfo)
+
 		default:
 			// Ignore object types we don't know about.
 		}
@@ -3432,6 +3689,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 	d.mu.versions.metrics.WAL.Files = int64(len(d.mu.log.queue))
 	d.mu.versions.obsoleteTables = mergeFileInfo(d.mu.versions.obsoleteTables, obsoleteTables)
 	d.mu.versions.updateObsoleteTableMetricsLocked()
+	d.mu.versions.obsoleteBlobFiles = mergeFileInfo(d.mu.versions.obsoleteBlobFiles, obsoleteBlobFiles)
 	d.mu.versions.obsoleteManifests = merge(d.mu.versions.obsoleteManifests, obsoleteManifests)
 	d.mu.versions.obsoleteOptions = merge(d.mu.versions.obsoleteOptions, obsoleteOptions)
 }
@@ -3548,6 +3806,7 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 
 	obsoleteTables = append(obsoleteTables, d.mu.versions.obsoleteTables...)
 	d.mu.versions.obsoleteTables = nil
+	obsoleteTables = d.holdObsoleteTablesLocked(obsoleteTables)
 
 	// Sort the manifests cause we want to delete some contiguous prefix
 	// of the older manifests.
@@ -3569,17 +3828,21 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	obsoleteOptions := d.mu.versions.obsoleteOptions
 	d.mu.versions.obsoleteOptions = nil
 
+	obsoleteBlobFiles := d.mu.versions.obsoleteBlobFiles
+	d.mu.versions.obsoleteBlobFiles = nil
+
 	// Release d.mu while doing I/O
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
-	files := [4]struct {
+	files := [5]struct {
 		fileType fileType
 		obsolete []fileInfo
 	}{
 		{fileTypeLog, obsoleteLogs},
 		{fileTypeTable, obsoleteTables},
+		{fileTypeBlob, obsoleteBlobFiles},
 		{fileTypeManifest, obsoleteManifests},
 		{fileTypeOptions, obsoleteOptions},
 	}
@@ -3595,12 +3858,24 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 			dir := d.dirname
 			switch f.fileType {
 			case fileTypeLog:
+				// Delete the stripes of the log before the log, so that they're
+				// deleted on a restart if a crash interrupts the deletion. Only
+				// the log itself is recycled.
+				for _, stripeDir := range d.opts.Experimental.WALStripeDirs {
+					filesToDelete = append(filesToDelete, obsoleteFile{
+						dir:      stripeDir,
+						fileNum:  fi.fileNum,
+						fileType: f.fileType,
+					})
+				}
 				if !noRecycle && d.logRecycler.add(fi) {
 					continue
 				}
 				dir = d.walDirname
 			case fileTypeTable:
 				d.tableCache.evict(fi.fileNum)
+			case fileTypeBlob:
+				d.blobFiles.evict(fi.fileNum)
 			}
 
 			filesToDelete = append(filesToDelete, obsoleteFile{
@@ -3612,9 +3887,15 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 		}
 	}
 	if len(filesToDelete) > 0 {
+		var size uint64
+		for _, of := range filesToDelete {
+			size += of.fileSize
+		}
+		d.deletionBacklog.count.Add(int64(len(filesToDelete)))
+		d.deletionBacklog.size.Add(size)
 		d.deleters.Add(1)
 		// Delete asynchronously if that could get held up in the pacer.
-		if d.opts.Experimental.MinDeletionRate > 0 {
+		if d.deletionLimiter != nil || d.deletionFileLimiter != nil {
 			go d.paceAndDeleteObsoleteFiles(jobID, filesToDelete)
 		} else {
 			d.paceAndDeleteObsoleteFiles(jobID, filesToDelete)
@@ -3627,11 +3908,17 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 func (d *DB) paceAndDeleteObsoleteFiles(jobID int, files []obsoleteFile) {
 	defer d.deleters.Done()
 	pacer := (pacer)(nilPacer)
-	if d.opts.Experimental.MinDeletionRate > 0 {
-		pacer = newDeletionPacer(d.deletionLimiter, d.getDeletionPacerInfo)
+	var deletionPacer *deletionPacer
+	if d.deletionLimiter != nil || d.deletionFileLimiter != nil {
+		deletionPacer = newDeletionPacer(d.deletionLimiter, d.deletionFileLimiter, d.getDeletionPacerInfo)
+		pacer = deletionPacer
 	}
 
 	for _, of := range files {
+		if d.closeAborted.Load() {
+			// DB.CloseContext no longer waits for the deletions to be paced.
+			pacer = nilPacer
+		}
 		path := base.MakeFilepath(d.opts.FS, of.dir, of.fileType, of.fileNum)
 		if of.fileType == fileTypeTable {
 			_ = pacer.maybeThrottle(of.fileSize)
@@ -3640,9 +3927,21 @@ func (d *DB) paceAndDeleteObsoleteFiles(jobID int, files []obsoleteFile) {
 			d.mu.versions.metrics.Table.ObsoleteSize -= of.fileSize
 			d.mu.Unlock()
 			d.deleteObsoleteObject(fileTypeTable, jobID, of.fileNum)
+		} else if of.fileType == fileTypeBlob {
+			_ = pacer.maybeThrottle(of.fileSize)
+			d.deleteObsoleteObject(fileTypeBlob, jobID, of.fileNum)
 		} else {
+			if of.fileType == fileTypeLog {
+				_ = pacer.maybeThrottle(of.fileSize)
+			}
 			d.deleteObsoleteFile(of.fileType, jobID, path, of.fileNum)
 		}
+		d.deletionBacklog.count.Add(-1)
+		d.deletionBacklog.size.Add(-of.fileSize)
+		if deletionPacer != nil {
+			d.deletionBacklog.throttled.Add(int64(deletionPacer.throttled))
+			deletionPacer.throttled = 0
+		}
 	}
 }
 
@@ -3650,7 +3949,7 @@ func (d *DB) maybeScheduleObsoleteTableDeletion() {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if len(d.mu.versions.obsoleteTables) == 0 {
+	if len(d.mu.versions.obsoleteTables) == 0 && len(d.mu.versions.obsoleteBlobFiles) == 0 {
 		return
 	}
 	if !d.acquireCleaningTurn(false) {
@@ -3671,7 +3970,7 @@ func (d *DB) maybeScheduleObsoleteTableDeletion() {
 }
 
 func (d *DB) deleteObsoleteObject(fileType fileType, jobID int, fileNum base.DiskFileNum) {
-	if fileType != fileTypeTable {
+	if fileType != fileTypeTable && fileType != fileTypeBlob {
 		panic("not an object")
 	}
 
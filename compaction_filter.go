@@ -0,0 +1,168 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// CompactionFilterDecision is the decision returned by a CompactionFilter for
+// a key.
+type CompactionFilterDecision int8
+
+const (
+	// CompactionFilterKeep keeps the key unmodified.
+	CompactionFilterKeep CompactionFilterDecision = iota
+	// CompactionFilterRemove removes the key, as if it had been deleted with
+	// a point tombstone at the same sequence number. The tombstone is elided
+	// along with the data it deletes when possible.
+	CompactionFilterRemove
+	// CompactionFilterChangeValue replaces the value of the key with the value
+	// returned by the filter.
+	CompactionFilterChangeValue
+)
+
+// String implements fmt.Stringer.
+func (d CompactionFilterDecision) String() string {
+	switch d {
+	case CompactionFilterKeep:
+		return "keep"
+	case CompactionFilterRemove:
+		return "remove"
+	case CompactionFilterChangeValue:
+		return "change-value"
+	default:
+		return fmt.Sprintf("CompactionFilterDecision(%d)", int8(d))
+	}
+}
+
+// CompactionFilter is invoked by flushes and compactions for each point key
+// written with Set that they read, including the versions of a key shadowed by
+// newer versions, to decide whether the key is kept, removed or rewritten with
+// a new value. The level is the level the flush or compaction writes to. It
+// may be used to lazily purge obsolete data or to migrate the encoding of
+// values without rewriting the DB offline.
+//
+// The filter must not retain or modify the key and value, and the returned
+// value must remain valid until the filter is invoked again. The filter is
+// invoked concurrently by concurrent compactions.
+//
+// The filter is applied regardless of the open snapshots, so the keys it
+// removes or rewrites are affected as seen from the snapshots that can read
+// them. Merge operands, and the results of merges, are not filtered.
+type CompactionFilter func(level int, key, value []byte) (decision CompactionFilterDecision, newValue []byte)
+
+// newCompactionFilterIter wraps the given internal iterator of a compaction
+// writing to the given level so that the keys are filtered by the filter. It
+// returns the iterator unmodified if the filter is nil.
+func newCompactionFilterIter(
+	iter internalIterator, filter CompactionFilter, level int,
+) internalIterator {
+	if filter == nil {
+		return iter
+	}
+	return &compactionFilterIter{internalIterator: iter, filter: filter, level: level}
+}
+
+// compactionFilterIter is an internal iterator that applies a CompactionFilter
+// to the SET and SETWITHDEL keys of the wrapped iterator. Removed keys are
+// transformed into DEL keys with the same sequence numbers, which shadow the
+// older versions of the keys that the filter doesn't remove.
+type compactionFilterIter struct {
+	internalIterator
+	filter CompactionFilter
+	level  int
+	key    InternalKey
+	err    error
+}
+
+var _ internalIterator = (*compactionFilterIter)(nil)
+
+func (i *compactionFilterIter) apply(
+	k *InternalKey, v base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	if k == nil {
+		return nil, base.LazyValue{}
+	}
+	if kind := k.Kind(); kind != InternalKeyKindSet && kind != InternalKeyKindSetWithDelete {
+		return k, v
+	}
+	value, _, err := v.Value(nil)
+	if err != nil {
+		i.err = err
+		return nil, base.LazyValue{}
+	}
+	switch decision, newValue := i.filter(i.level, k.UserKey, value); decision {
+	case CompactionFilterKeep:
+		return k, v
+	case CompactionFilterRemove:
+		i.key = base.MakeInternalKey(k.UserKey, k.SeqNum(), InternalKeyKindDelete)
+		return &i.key, base.LazyValue{}
+	case CompactionFilterChangeValue:
+		return k, base.MakeInPlaceValue(newValue)
+	default:
+		i.err = errors.AssertionFailedf("pebble: unknown compaction filter decision %s", decision)
+		return nil, base.LazyValue{}
+	}
+}
+
+// SeekGE implements base.InternalIterator.
+func (i *compactionFilterIter) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.apply(i.internalIterator.SeekGE(key, flags))
+}
+
+// SeekPrefixGE implements base.InternalIterator.
+func (i *compactionFilterIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.apply(i.internalIterator.SeekPrefixGE(prefix, key, flags))
+}
+
+// SeekLT implements base.InternalIterator.
+func (i *compactionFilterIter) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.apply(i.internalIterator.SeekLT(key, flags))
+}
+
+// First implements base.InternalIterator.
+func (i *compactionFilterIter) First() (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.apply(i.internalIterator.First())
+}
+
+// Last implements base.InternalIterator.
+func (i *compactionFilterIter) Last() (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.apply(i.internalIterator.Last())
+}
+
+// Next implements base.InternalIterator.
+func (i *compactionFilterIter) Next() (*InternalKey, base.LazyValue) {
+	return i.apply(i.internalIterator.Next())
+}
+
+// NextPrefix implements base.InternalIterator.
+func (i *compactionFilterIter) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	return i.apply(i.internalIterator.NextPrefix(succKey))
+}
+
+// Prev implements base.InternalIterator.
+func (i *compactionFilterIter) Prev() (*InternalKey, base.LazyValue) {
+	return i.apply(i.internalIterator.Prev())
+}
+
+// Error implements base.InternalIterator.
+func (i *compactionFilterIter) Error() error {
+	return firstError(i.err, i.internalIterator.Error())
+}
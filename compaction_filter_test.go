@@ -0,0 +1,78 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionFilter(t *testing.T) {
+	var mu sync.Mutex
+	levels := map[int]bool{}
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Logger:                      panicLogger{},
+	}
+	// Values prefixed with "soft-deleted" are removed, and values with the
+	// legacy "v1:" encoding are rewritten with the "v2:" encoding.
+	opts.Experimental.CompactionFilter = func(
+		level int, key, value []byte,
+	) (CompactionFilterDecision, []byte) {
+		mu.Lock()
+		levels[level] = true
+		mu.Unlock()
+		switch {
+		case bytes.HasPrefix(value, []byte("soft-deleted")):
+			return CompactionFilterRemove, nil
+		case bytes.HasPrefix(value, []byte("v1:")):
+			return CompactionFilterChangeValue, append([]byte("v2:"), value[3:]...)
+		default:
+			return CompactionFilterKeep, nil
+		}
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	scan := func() string {
+		iter := d.NewIter(nil)
+		var buf strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s=%s ", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return strings.TrimSpace(buf.String())
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("v1:a"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("v2:b"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("v2:c"), nil))
+	require.NoError(t, d.Merge([]byte("m"), []byte("soft-deleted"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	require.Equal(t, "a=v2:a b=v2:b c=v2:c m=soft-deleted", scan())
+
+	// The removal of the newer version of c shadows the older version, which
+	// the filter keeps.
+	require.NoError(t, d.Set([]byte("b"), []byte("soft-deleted"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("soft-deleted"), nil))
+	require.Equal(t, "a=v2:a b=soft-deleted c=soft-deleted m=soft-deleted", scan())
+	require.NoError(t, d.Flush())
+	require.Equal(t, "a=v2:a m=soft-deleted", scan())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	require.Equal(t, "a=v2:a m=soft-deleted", scan())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.True(t, levels[0])
+	require.True(t, levels[numLevels-1])
+}
@@ -174,11 +174,24 @@ type compactionIter struct {
 	// unsafe, i.iter-owned slice that could be altered when the iterator is
 	// advanced.
 	valueBuf []byte
+	// valueIsBlob is true if the current value is a SET value stored in a blob
+	// file that has not been fetched, in which case value is nil and valueBlob
+	// and valueBlobHandle hold the handle of the value (see blobValue).
+	valueIsBlob     bool
+	valueBlob       base.LazyFetcher
+	valueBlobHandle []byte
 	// Is the current entry valid?
 	valid            bool
 	iterKey          *InternalKey
 	iterValue        []byte
 	iterStripeChange stripeChangeType
+	// iterValueIsBlob is true if iterValue is a SET value stored in a blob
+	// file, which is only fetched if needed, ie, if it's merged. The blob file
+	// values that are not fetched can be written to the compaction's outputs
+	// by reference.
+	iterValueIsBlob bool
+	iterBlob        base.LazyFetcher
+	iterBlobHandle  []byte
 	// `skip` indicates whether the remaining skippable entries in the current
 	// snapshot stripe should be skipped or processed. An example of a non-
 	// skippable entry is a range tombstone as we need to return it from the
@@ -240,6 +253,14 @@ type compactionIter struct {
 	// The on-disk format major version. This informs the types of keys that
 	// may be written to disk during a compaction.
 	formatVersion FormatMajorVersion
+	// verifier, if non-nil, accounts for the keys read and dropped (see
+	// compactionVerifier). iterKeySaved is true if the key at the current
+	// iterator position was saved by saveKey, in which case it's accounted
+	// for when it's returned or explicitly dropped. Otherwise, it's dropped for
+	// dropReason when the iterator advances.
+	verifier     *compactionVerifier
+	iterKeySaved bool
+	dropReason   CompactionDropReason
 }
 
 func newCompactionIter(
@@ -284,10 +305,11 @@ func (i *compactionIter) First() (*InternalKey, []byte) {
 	}
 	var iterValue LazyValue
 	i.iterKey, iterValue = i.iter.First()
-	i.iterValue, _, i.err = iterValue.Value(nil)
+	i.setIterValue(iterValue)
 	if i.err != nil {
 		return nil, nil
 	}
+	i.verifyIterKeyRead()
 	if i.iterKey != nil {
 		i.curSnapshotIdx, i.curSnapshotSeqNum = snapshotIndex(i.iterKey.SeqNum(), i.snapshots)
 	}
@@ -325,6 +347,7 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 
 	i.pos = iterPosCurForward
 	i.valid = false
+	i.valueIsBlob = false
 
 	for i.iterKey != nil {
 		// If we entered a new snapshot stripe with the same key, any key we
@@ -377,6 +400,7 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 		if cover := i.rangeDelFrag.Covers(*i.iterKey, i.curSnapshotSeqNum); cover == keyspan.CoversVisibly {
 			// A pending range deletion deletes this key. Skip it.
 			i.saveKey()
+			i.verifySavedKeyDropped(CompactionDropDeletedByRangeDel)
 			i.skipInStripe()
 			continue
 		} else if cover == keyspan.CoversInvisibly {
@@ -392,6 +416,7 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 					// If we're at the last snapshot stripe and the tombstone
 					// can be elided skip skippable keys in the same stripe.
 					i.saveKey()
+					i.verifySavedKeyDropped(CompactionDropElidedTombstone)
 					i.skipInStripe()
 					continue
 				} else {
@@ -445,6 +470,7 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 			}
 			if i.err == nil {
 				if needDelete {
+					i.verifySavedKeyDropped(CompactionDropMergeDeleted)
 					i.valid = false
 					if i.closeValueCloser() != nil {
 						return nil, nil
@@ -523,15 +549,71 @@ func (i *compactionIter) skipInStripe() {
 }
 
 func (i *compactionIter) iterNext() bool {
+	if i.verifier != nil && i.iterKey != nil && !i.iterKeySaved {
+		i.verifier.keyDropped(i.iterKey.UserKey, i.iterKey.Trailer, i.dropReason)
+	}
 	var iterValue LazyValue
 	i.iterKey, iterValue = i.iter.Next()
-	i.iterValue, _, i.err = iterValue.Value(nil)
+	i.setIterValue(iterValue)
 	if i.err != nil {
 		i.iterKey = nil
 	}
+	i.verifyIterKeyRead()
 	return i.iterKey != nil
 }
 
+// verifyIterKeyRead accounts for the key at the new iterator position as read.
+// Unless it's saved, it's dropped as shadowed when the iterator advances,
+// unless a different reason is set.
+func (i *compactionIter) verifyIterKeyRead() {
+	if i.verifier == nil || i.iterKey == nil {
+		return
+	}
+	i.verifier.keyRead(i.iterKey.UserKey, i.iterKey.Trailer)
+	i.iterKeySaved = false
+	i.dropReason = CompactionDropShadowed
+}
+
+// verifySavedKeyDropped accounts for the saved key as dropped for the given
+// reason, rather than returned.
+func (i *compactionIter) verifySavedKeyDropped(reason CompactionDropReason) {
+	if i.verifier != nil {
+		i.verifier.keyDropped(i.key.UserKey, i.keyTrailer, reason)
+	}
+}
+
+// setIterValue sets the value of the current iterator position. The SET
+// values stored in blob files are not fetched.
+func (i *compactionIter) setIterValue(v LazyValue) {
+	if i.iterKey != nil && i.iterKey.Kind() == InternalKeyKindSet && v.Fetcher != nil {
+		if _, ok := v.Fetcher.Fetcher.(*blobFileCache); ok {
+			i.iterValue = nil
+			i.iterValueIsBlob = true
+			i.iterBlob = base.LazyFetcher{Fetcher: v.Fetcher.Fetcher, Attribute: v.Fetcher.Attribute}
+			i.iterBlobHandle = v.ValueOrHandle
+			return
+		}
+	}
+	i.iterValueIsBlob = false
+	i.iterValue, _, i.err = v.Value(nil)
+}
+
+// fetchIterValue fetches the value of the current iterator position if it's
+// stored in a blob file.
+func (i *compactionIter) fetchIterValue() error {
+	if !i.iterValueIsBlob {
+		return nil
+	}
+	lv := base.LazyValue{ValueOrHandle: i.iterBlobHandle, Fetcher: &i.iterBlob}
+	v, _, err := lv.Value(nil)
+	if err != nil {
+		return err
+	}
+	i.iterValue = v
+	i.iterValueIsBlob = false
+	return nil
+}
+
 // stripeChangeType indicates how the snapshot stripe changed relative to the
 // previous key. If no change, it also indicates whether the current entry is
 // skippable. If the snapshot stripe changed, it also indicates whether the new
@@ -622,6 +704,13 @@ func (i *compactionIter) setNext() {
 	i.value = i.iterValue
 	i.valid = true
 	i.maybeZeroSeqnum(i.curSnapshotIdx)
+	if i.iterValueIsBlob {
+		// Save the handle of the value, which is written to the output
+		// unfetched.
+		i.valueIsBlob = true
+		i.valueBlob = i.iterBlob
+		i.valueBlobHandle = append(i.valueBlobHandle[:0], i.iterBlobHandle...)
+	}
 
 	// There are two cases where we can early return and skip the remaining
 	// records in the stripe:
@@ -738,6 +827,7 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 
 		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
 			if i.rangeDelFrag.Covers(*key, i.curSnapshotSeqNum) == keyspan.CoversVisibly {
+				i.dropReason = CompactionDropDeletedByRangeDel
 				// We change the kind of the result key to a Set so that it shadows
 				// keys in lower levels. That is, MERGE+RANGEDEL -> SET. This isn't
 				// strictly necessary, but provides consistency with the behavior of
@@ -751,7 +841,10 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 			// value and return. We change the kind of the resulting key to a
 			// Set so that it shadows keys in lower levels. That is:
 			// MERGE + (SET*) -> SET.
-			i.err = valueMerger.MergeOlder(i.iterValue)
+			if i.err = i.fetchIterValue(); i.err == nil {
+				i.err = valueMerger.MergeOlder(i.iterValue)
+			}
+			i.dropReason = CompactionDropMerged
 			if i.err != nil {
 				i.valid = false
 				return sameStripeSkippable
@@ -762,6 +855,7 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 
 		case InternalKeyKindMerge:
 			if i.rangeDelFrag.Covers(*key, i.curSnapshotSeqNum) == keyspan.CoversVisibly {
+				i.dropReason = CompactionDropDeletedByRangeDel
 				// We change the kind of the result key to a Set so that it shadows
 				// keys in lower levels. That is, MERGE+RANGEDEL -> SET. This isn't
 				// strictly necessary, but provides consistency with the behavior of
@@ -774,6 +868,7 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 			// We've hit another Merge value. Merge with the existing value and
 			// continue looping.
 			i.err = valueMerger.MergeOlder(i.iterValue)
+			i.dropReason = CompactionDropMerged
 			if i.err != nil {
 				i.valid = false
 				return sameStripeSkippable
@@ -810,6 +905,9 @@ func (i *compactionIter) singleDeleteNext() bool {
 			return true
 
 		case InternalKeyKindSet:
+			// The SINGLEDEL and the SET it deletes are both dropped.
+			i.verifySavedKeyDropped(CompactionDropSingleDeleted)
+			i.dropReason = CompactionDropSingleDeleted
 			i.nextInStripe()
 			i.valid = false
 			return false
@@ -830,6 +928,7 @@ func (i *compactionIter) saveKey() {
 	i.key.UserKey = i.keyBuf
 	i.key.Trailer = i.iterKey.Trailer
 	i.keyTrailer = i.iterKey.Trailer
+	i.iterKeySaved = true
 	i.frontiers.Advance(i.key.UserKey)
 }
 
@@ -846,6 +945,16 @@ func (i *compactionIter) Value() []byte {
 	return i.value
 }
 
+// blobValue returns the current value if it's a SET value stored in a blob
+// file, in which case Value returns nil. The value is fetched by the returned
+// LazyValue, which is valid until the iterator is advanced.
+func (i *compactionIter) blobValue() (LazyValue, bool) {
+	if !i.valueIsBlob {
+		return LazyValue{}, false
+	}
+	return LazyValue{ValueOrHandle: i.valueBlobHandle, Fetcher: &i.valueBlob}, true
+}
+
 func (i *compactionIter) Valid() bool {
 	return i.valid
 }
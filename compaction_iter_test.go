@@ -199,6 +199,7 @@ func TestCompactionIter(t *testing.T) {
 				})
 
 				iter := newIter(formatVersion)
+				iter.verifier = &compactionVerifier{}
 				var b bytes.Buffer
 				for _, line := range strings.Split(d.Input, "\n") {
 					parts := strings.Fields(line)
@@ -244,6 +245,7 @@ func TestCompactionIter(t *testing.T) {
 							}
 						}
 						fmt.Fprintf(&b, "%s:%s%s\n", iter.Key(), iter.Value(), snapshotPinned)
+						iter.verifier.keyWritten(iter.Key().UserKey, iter.keyTrailer)
 						if iter.Key().Kind() == InternalKeyKindRangeDelete {
 							iter.rangeDelFrag.Add(keyspan.Span{
 								Start: append([]byte{}, iter.Key().UserKey...),
@@ -262,6 +264,13 @@ func TestCompactionIter(t *testing.T) {
 						fmt.Fprintf(&b, ".\n")
 					}
 				}
+				// Once exhausted, the keys returned and dropped by the iterator
+				// reconcile with the keys it read.
+				if !iter.Valid() && iter.Error() == nil {
+					_ = iter.verifier.verify(0, "test", func(info CompactionVerificationInfo) {
+						fmt.Fprintf(&b, "%s\n", info)
+					})
+				}
 				return b.String()
 
 			default:
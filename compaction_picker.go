@@ -766,6 +766,11 @@ type compactionPickerByScore struct {
 	// Compactions and flushes are longer, slower operations and provide
 	// a much looser bound when available bytes is decreasing.
 	diskAvailBytes func() uint64
+
+	// prevL0Score is the smoothed L0 score of the previous version's picker
+	// at the time this picker's version was installed. It's only used if
+	// Options.Experimental.L0ScoreSmoothing is non-zero.
+	prevL0Score float64
 }
 
 var _ compactionPicker = &compactionPickerByScore{}
@@ -1026,7 +1031,8 @@ func (p *compactionPickerByScore) calculateL0Score(
 	// If L0Sublevels are present, use the sublevel count to calculate the
 	// score. The base vs intra-L0 compaction determination happens in pickAuto,
 	// not here.
-	info.score = float64(2*p.vers.L0Sublevels.MaxDepthAfterOngoingCompactions()) /
+	info.score = p.opts.Experimental.L0SublevelScoreWeight *
+		float64(p.vers.L0Sublevels.MaxDepthAfterOngoingCompactions()) /
 		float64(p.opts.L0CompactionThreshold)
 
 	// Also calculate a score based on the file count but use it only if it
@@ -1038,6 +1044,15 @@ func (p *compactionPickerByScore) calculateL0Score(
 	// 2) constructing L0 sublevels has a runtime that grows superlinearly with
 	//    the number of files in L0 and must be done while holding D.mu.
 	noncompactingFiles := p.vers.Levels[0].Len()
+	if len(p.opts.Experimental.ShortLivedSpans) > 0 {
+		// The files of short-lived spans aren't compacted.
+		iter := p.vers.Levels[0].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.ShortLived && !f.IsCompacting() {
+				noncompactingFiles--
+			}
+		}
+	}
 	for _, c := range inProgressCompactions {
 		for _, cl := range c.inputs {
 			if cl.level == 0 {
@@ -1049,6 +1064,10 @@ func (p *compactionPickerByScore) calculateL0Score(
 	if info.score < fileScore {
 		info.score = fileScore
 	}
+	// Smooth the score across versions to avoid reacting to short bursts.
+	if alpha := p.opts.Experimental.L0ScoreSmoothing; alpha > 0 {
+		info.score = alpha*p.prevL0Score + (1-alpha)*info.score
+	}
 	return info
 }
 
@@ -1314,6 +1333,13 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 		}
 	}
 
+	// Look for runs of small files that may be merged to reduce the number of
+	// files. These don't help us keep up with writes either, but reduce the
+	// pressure on the table cache and the size of the manifest.
+	if pc := p.pickSmallFileCompaction(env); pc != nil {
+		return pc
+	}
+
 	return nil
 }
 
@@ -1324,7 +1350,11 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 // lowest LargestSeqNum. The lowest LargestSeqNum file will be the first
 // eligible for an elision-only compaction once snapshots less than or equal
 // to its LargestSeqNum are closed.
-type elisionOnlyAnnotator struct{}
+type elisionOnlyAnnotator struct {
+	// opts provides the deletion thresholds of files. It must be a pointer, as
+	// annotators are compared to find their cached annotations.
+	opts *Options
+}
 
 var _ manifest.Annotator = elisionOnlyAnnotator{}
 
@@ -1342,7 +1372,9 @@ func (a elisionOnlyAnnotator) Accumulate(f *fileMetadata, dst interface{}) (inte
 	// Bottommost files are large and not worthwhile to compact just
 	// to remove a few tombstones. Consider a file ineligible if its
 	// own range deletions delete less than 10% of its data and its
-	// deletion tombstones make up less than 10% of its entries.
+	// deletion tombstones make up less than 10% of its entries. The
+	// threshold may be lowered for particular key ranges through
+	// Options.Experimental.DeletionCompactionRanges.
 	//
 	// TODO(jackson): This does not account for duplicate user keys
 	// which may be collapsed. Ideally, we would have 'obsolete keys'
@@ -1353,8 +1385,9 @@ func (a elisionOnlyAnnotator) Accumulate(f *fileMetadata, dst interface{}) (inte
 	// `NumEntries` and `RangeDeletionsBytesEstimate` are both zero) are excluded
 	// from elision-only compactions.
 	// TODO(travers): Consider an alternative heuristic for elision of range-keys.
-	if f.Stats.RangeDeletionsBytesEstimate*10 < f.Size &&
-		f.Stats.NumDeletions*10 <= f.Stats.NumEntries {
+	threshold := uint64(a.opts.deletionThresholdPercent(f.Smallest.UserKey, f.Largest.UserKey))
+	if f.Stats.RangeDeletionsBytesEstimate*100 < f.Size*threshold &&
+		f.Stats.NumDeletions*100 <= f.Stats.NumEntries*threshold {
 		return dst, true
 	}
 	if dst == nil {
@@ -1430,7 +1463,7 @@ func (p *compactionPickerByScore) pickElisionOnlyCompaction(
 	if p.opts.private.disableElisionOnlyCompactions {
 		return nil
 	}
-	v := p.vers.Levels[numLevels-1].Annotation(elisionOnlyAnnotator{})
+	v := p.vers.Levels[numLevels-1].Annotation(elisionOnlyAnnotator{opts: p.opts})
 	if v == nil {
 		return nil
 	}
@@ -1523,6 +1556,123 @@ func (p *compactionPickerByScore) pickRewriteCompaction(env compactionEnv) (pc *
 	return nil
 }
 
+// smallFilesAnnotator implements manifest.Annotator, annotating B-Tree nodes
+// with the count of files smaller than threshold. Its annotation type is a
+// *uint64.
+type smallFilesAnnotator struct {
+	threshold uint64
+}
+
+var _ manifest.Annotator = smallFilesAnnotator{}
+
+func (a smallFilesAnnotator) Zero(dst interface{}) interface{} {
+	if dst == nil {
+		return new(uint64)
+	}
+	v := dst.(*uint64)
+	*v = 0
+	return v
+}
+
+func (a smallFilesAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (v interface{}, cacheOK bool) {
+	vptr := dst.(*uint64)
+	if f.Size < a.threshold {
+		*vptr = *vptr + 1
+	}
+	return vptr, true
+}
+
+func (a smallFilesAnnotator) Merge(src interface{}, dst interface{}) interface{} {
+	srcV := src.(*uint64)
+	dstV := dst.(*uint64)
+	*dstV = *dstV + *srcV
+	return dstV
+}
+
+// pickSmallFileCompaction attempts to construct a compaction that merges a
+// run of at least SmallFileCompactionOptions.MinFiles adjacent small files in
+// a level. Like a rewrite compaction, a small-file compaction outputs files
+// to the same level as the input level, which are split at the level's
+// target file size. L0 is excluded, as the L0 compactions merge its files.
+func (p *compactionPickerByScore) pickSmallFileCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	minFiles := p.opts.Experimental.SmallFileCompaction.MinFiles
+	if minFiles <= 0 {
+		return nil
+	}
+	for l := numLevels - 1; l >= p.baseLevel && l > 0; l-- {
+		if p.vers.Levels[l].Len() < minFiles {
+			continue
+		}
+		// The target file size of a level is determined by its position
+		// relative to the base level. See newPickedCompaction.
+		threshold := p.opts.smallFileThreshold(1 + l - p.baseLevel)
+		a := smallFilesAnnotator{threshold: threshold}
+		if *p.vers.Levels[l].Annotation(a).(*uint64) < uint64(minFiles) {
+			continue
+		}
+		maxSize := expandedCompactionByteSizeLimit(p.opts, l, p.diskAvailBytes())
+
+		// Find a run of adjacent small files that aren't compacting, whose
+		// total size doesn't exceed maxSize.
+		var runStart, runEnd manifest.LevelIterator
+		var runLen int
+		var runSize uint64
+		iter := p.vers.Levels[l].Iter()
+		for f := iter.First(); ; f = iter.Next() {
+			small := f != nil && f.Size < threshold && !f.IsCompacting()
+			if small && runLen > 0 && runSize+f.Size <= maxSize {
+				runEnd = iter.Clone()
+				runLen++
+				runSize += f.Size
+				continue
+			}
+			if runLen >= minFiles {
+				if pc := p.newSmallFileCompaction(env, l, runStart, runEnd); pc != nil {
+					return pc
+				}
+			}
+			if f == nil {
+				break
+			}
+			runLen, runSize = 0, 0
+			if small {
+				runStart, runEnd = iter.Clone(), iter.Clone()
+				runLen, runSize = 1, f.Size
+			}
+		}
+	}
+	return nil
+}
+
+// newSmallFileCompaction constructs a small-file compaction of the files of
+// level between the iterators start and end, inclusive, expanded to their
+// atomic compaction unit.
+func (p *compactionPickerByScore) newSmallFileCompaction(
+	env compactionEnv, level int, start, end manifest.LevelIterator,
+) *pickedCompaction {
+	inputs := p.vers.Levels[level].Slice().Reslice(func(s, e *manifest.LevelIterator) {
+		*s, *e = start, end
+	})
+	inputs, isCompacting := expandToAtomicUnit(
+		p.opts.Comparer.Compare, inputs, false /* disableIsCompacting */)
+	if isCompacting {
+		return nil
+	}
+	pc := newPickedCompaction(p.opts, p.vers, level, level, p.baseLevel)
+	pc.kind = compactionKindSmallFile
+	pc.startLevel.files = inputs
+	pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, pc.startLevel.files.Iter())
+	// Fail-safe to protect against compacting the same sstable concurrently.
+	if inputRangeAlreadyCompacting(env, pc) {
+		return nil
+	}
+	return pc
+}
+
 // pickAutoLPositive picks an automatic compaction for the candidate
 // file in a positive-numbered level. This function must not be used for
 // L0.
@@ -1746,9 +1896,12 @@ func pickReadTriggeredCompactionHelper(
 	iter := overlapSlice.Iter()
 	var fileMatches bool
 	for f := iter.First(); f != nil; f = iter.Next() {
+		if f.ShortLived {
+			// The files of short-lived spans are only dropped on expiry.
+			return nil
+		}
 		if f.FileNum == rc.fileNum {
 			fileMatches = true
-			break
 		}
 	}
 	if !fileMatches {
@@ -489,6 +489,19 @@ func TestCompactionPickerL0(t *testing.T) {
 		case "pick-auto":
 			td.MaybeScanArgs(t, "l0_compaction_threshold", &opts.L0CompactionThreshold)
 			td.MaybeScanArgs(t, "l0_compaction_file_threshold", &opts.L0CompactionFileThreshold)
+			scanFloat := func(key string, defaultValue float64) float64 {
+				if !td.HasArg(key) {
+					return defaultValue
+				}
+				var s string
+				td.ScanArgs(t, key, &s)
+				v, err := strconv.ParseFloat(s, 64)
+				require.NoError(t, err)
+				return v
+			}
+			opts.Experimental.L0SublevelScoreWeight = scanFloat("l0_sublevel_score_weight", defaultL0SublevelScoreWeight)
+			opts.Experimental.L0ScoreSmoothing = scanFloat("l0_score_smoothing", 0)
+			picker.prevL0Score = scanFloat("prev_l0_score", 0)
 
 			pc = picker.pickAuto(compactionEnv{
 				earliestUnflushedSeqNum: math.MaxUint64,
@@ -26,6 +26,7 @@ import (
 	"github.com/cockroachdb/datadriven"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/errorfs"
 	"github.com/cockroachdb/pebble/internal/keyspan"
@@ -1613,6 +1614,292 @@ func TestManualCompaction(t *testing.T) {
 	}
 }
 
+func TestCompactWithOptions(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write incompressible data into overlapping sstables, one in L6, one in
+	// L0 and one in the memtable.
+	value := make([]byte, 1024)
+	for i := 0; i < 150; i++ {
+		_, _ = crand.Read(value)
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%03d", i*7%150)), value, nil))
+		if i == 49 {
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact([]byte("k"), []byte("l"), false))
+		} else if i == 99 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	var progress []CompactionProgress
+	var keys int
+	const bytesPerSecond = 256 << 10
+	start := time.Now()
+	require.NoError(t, d.CompactWithOptions([]byte("k"), []byte("l"), CompactOptions{
+		BytesPerSecond: bytesPerSecond,
+		OnProgress: func(p CompactionProgress) {
+			if p.CurrentKey != nil {
+				keys++
+			}
+			p.CurrentKey = nil
+			progress = append(progress, p)
+		},
+		ProgressInterval: time.Nanosecond,
+	}))
+	elapsed := time.Since(start)
+
+	require.Less(t, 1, len(progress))
+	require.Less(t, 0, keys)
+	for i := 1; i < len(progress); i++ {
+		require.LessOrEqual(t, progress[i-1].BytesCompacted, progress[i].BytesCompacted)
+		require.LessOrEqual(t, progress[i-1].Elapsed, progress[i].Elapsed)
+	}
+	final := progress[len(progress)-1]
+	require.Equal(t, final.BytesTotal, final.BytesCompacted)
+	require.Zero(t, final.Remaining)
+	// The unflushed data is flushed before the total is estimated, and the
+	// three sstables are read by the compaction from L0 to L6.
+	require.Less(t, uint64(150<<10), final.BytesCompacted)
+	require.InEpsilon(t, progress[0].BytesTotal, final.BytesCompacted, 0.1)
+
+	// The compaction was paced. Not all of the bytes are paced, as the bytes
+	// of an sstable that aren't read through the compaction iterator (eg, its
+	// index block) are only accounted for once the compaction completes.
+	minElapsed := time.Duration(float64(final.BytesCompacted/2) /
+		bytesPerSecond * float64(time.Second))
+	require.Less(t, minElapsed, elapsed)
+}
+
+func TestCompactAsync(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		MaxConcurrentCompactions:    func() int { return 1 },
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write overlapping sstables in two disjoint ranges, "a" and "b".
+	value := make([]byte, 1024)
+	for _, prefix := range []string{"a", "b"} {
+		for i := 0; i < 100; i++ {
+			_, _ = crand.Read(value)
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%03d", prefix, i*7%100)), value, nil))
+			if i == 49 {
+				require.NoError(t, d.Flush())
+			}
+		}
+		require.NoError(t, d.Flush())
+	}
+	tables := func() string {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.mu.versions.currentVersion().String()
+	}
+	before := tables()
+
+	// A slow compaction of "a" occupies the only compaction slot.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ha, err := d.CompactAsync(ctx, []byte("a"), []byte("b"), CompactOptions{
+		BytesPerSecond: 16 << 10,
+	})
+	require.NoError(t, err)
+	for ha.Progress().BytesCompacted == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The compaction of "b" is queued, and is dropped once cancelled.
+	hb, err := d.CompactAsync(context.Background(), []byte("b"), []byte("c"), CompactOptions{
+		Priority: ManualCompactionBackground,
+	})
+	require.NoError(t, err)
+	hb.Cancel()
+	require.ErrorIs(t, hb.Wait(context.Background()), ErrCompactionCancelled)
+	select {
+	case <-ha.Done():
+		t.Fatal("compaction of a completed")
+	default:
+	}
+
+	// The running compaction is cancelled once its context is done, and its
+	// outputs are discarded.
+	cancel()
+	require.ErrorIs(t, ha.Wait(context.Background()), ErrCompactionCancelled)
+	require.Equal(t, before, tables())
+
+	// Background compactions run when no automatic compaction is pending.
+	hb, err = d.CompactAsync(context.Background(), []byte("a"), []byte("c"), CompactOptions{
+		Priority: ManualCompactionBackground,
+	})
+	require.NoError(t, err)
+	require.NoError(t, hb.Wait(context.Background()))
+	p := hb.Progress()
+	require.Less(t, uint64(200<<10), p.BytesCompacted)
+	require.Equal(t, p.BytesTotal, p.BytesCompacted)
+	require.Zero(t, p.Remaining)
+	m := d.Metrics()
+	require.Zero(t, m.Levels[0].NumFiles)
+	require.Less(t, int64(0), m.Levels[numLevels-1].NumFiles)
+}
+
+func TestInProgressCompactions(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write overlapping sstables into L6 and L0, so that the compaction isn't
+	// a move.
+	value := make([]byte, 1024)
+	for i := 0; i < 100; i++ {
+		_, _ = crand.Read(value)
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%03d", i*7%100)), value, nil))
+		if i == 49 {
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact([]byte("k"), []byte("l"), false))
+		}
+	}
+	require.NoError(t, d.Flush())
+	require.Empty(t, d.InProgressCompactions())
+
+	// A slow compaction reports its progress while it runs.
+	h, err := d.CompactAsync(context.Background(), []byte("k"), []byte("l"), CompactOptions{
+		BytesPerSecond: 16 << 10,
+	})
+	require.NoError(t, err)
+	var c InProgressCompaction
+	for {
+		compactions := d.InProgressCompactions()
+		if len(compactions) == 1 && compactions[0].Progress.BytesCompacted > 0 {
+			c = compactions[0]
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	require.NotZero(t, c.JobID)
+	require.Equal(t, 0, c.StartLevel)
+	require.Equal(t, numLevels-1, c.OutputLevel)
+	require.Less(t, uint64(100<<10), c.Progress.BytesTotal)
+	require.Less(t, c.Progress.BytesCompacted, c.Progress.BytesTotal)
+	require.Less(t, time.Duration(0), c.Progress.Remaining)
+
+	h.Cancel()
+	require.ErrorIs(t, h.Wait(context.Background()), ErrCompactionCancelled)
+	require.Empty(t, d.InProgressCompactions())
+}
+
+func TestSmallFileCompaction(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{FS: fs}
+	opts.Experimental.SmallFileCompaction.MinFiles = 4
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Ingest small non-overlapping sstables, which are ingested into L6.
+	// Automatic compactions are disabled while ingesting, so that the sstables
+	// accumulate.
+	d.mu.Lock()
+	d.opts.DisableAutomaticCompactions = true
+	d.mu.Unlock()
+	const n = 10
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("ext%d", i)
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("k%02d", i)), []byte("v")))
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{name}))
+	}
+	require.Equal(t, int64(n), d.Metrics().Levels[numLevels-1].NumFiles)
+
+	d.mu.Lock()
+	d.opts.DisableAutomaticCompactions = false
+	d.maybeScheduleCompaction()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Levels[numLevels-1].NumFiles)
+	require.Equal(t, int64(1), m.Compact.SmallFileCount)
+	require.Equal(t, int64(n), m.Compact.SmallFileInputCount)
+	for i := 0; i < n; i++ {
+		v, closer, err := d.Get([]byte(fmt.Sprintf("k%02d", i)))
+		require.NoError(t, err)
+		require.Equal(t, "v", string(v))
+		require.NoError(t, closer.Close())
+	}
+}
+
+func TestQueueDeletionDetection(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{FS: fs}
+	opts.Experimental.QueueDeletionDetection = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Ingest two sstables holding consecutive runs of queue entries, which
+	// are ingested into L6.
+	var firstSize uint64
+	for i := 0; i < 2; i++ {
+		name := fmt.Sprintf("ext%d", i)
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+		for j := 0; j < 10; j++ {
+			require.NoError(t, w.Set([]byte(fmt.Sprintf("q%02d", 10*i+j)), []byte("v")))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{name}))
+		if i == 0 {
+			firstSize = uint64(d.Metrics().Levels[numLevels-1].Size)
+		}
+	}
+	require.Equal(t, int64(2), d.Metrics().Levels[numLevels-1].NumFiles)
+
+	// Dequeue the entries of the first sstable, and all but one entry of the
+	// second, while enqueueing a new entry. The flushed sstable's bounds
+	// contain both sstables, but only the first sstable can be dropped.
+	for i := 0; i < 19; i++ {
+		require.NoError(t, d.Delete([]byte(fmt.Sprintf("q%02d", i)), nil))
+	}
+	require.NoError(t, d.Set([]byte("q20"), []byte("v"), nil))
+	require.NoError(t, d.Flush())
+
+	d.mu.Lock()
+	d.waitTableStats()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Compact.DeleteOnlyCount)
+	require.Equal(t, firstSize, m.Compact.QueueDeletedBytes)
+	require.Equal(t, int64(1), m.Levels[numLevels-1].NumFiles)
+
+	iter := d.NewIter(nil)
+	var keys []string
+	for iter.First(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"q19", "q20"}, keys)
+}
+
 func TestCompactionFindGrandparentLimit(t *testing.T) {
 	cmp := DefaultComparer.Compare
 	var grandparents []*fileMetadata
@@ -3303,13 +3590,13 @@ func TestCompactFlushQueuedLargeBatch(t *testing.T) {
 	// size in order to more easily create a situation where a large batch is
 	// queued but not automatically flushed.
 	d.mu.Lock()
-	d.largeBatchThreshold = d.opts.MemTableSize / 8
+	d.largeBatchThreshold.Store(int64(d.opts.MemTableSize / 8))
 	require.Equal(t, 1, len(d.mu.mem.queue))
 	d.mu.Unlock()
 
 	// Set a record with a large value. This will be transformed into a large
 	// batch and placed in the flushable queue.
-	require.NoError(t, d.Set([]byte("a"), bytes.Repeat([]byte("v"), d.largeBatchThreshold), nil))
+	require.NoError(t, d.Set([]byte("a"), bytes.Repeat([]byte("v"), int(d.largeBatchThreshold.Load())), nil))
 	d.mu.Lock()
 	require.Greater(t, len(d.mu.mem.queue), 1)
 	d.mu.Unlock()
@@ -3907,3 +4194,55 @@ func TestCompaction_LogAndApplyFails(t *testing.T) {
 		})
 	}
 }
+
+func TestCompactionFilterPolicyByLevel(t *testing.T) {
+	require.True(t, LevelOptions{}.sameFilters(LevelOptions{}))
+	require.True(t, LevelOptions{FilterPolicy: bloom.FilterPolicy(10)}.sameFilters(
+		LevelOptions{FilterPolicy: bloom.FilterPolicy(10)}))
+	require.False(t, LevelOptions{FilterPolicy: bloom.FilterPolicy(10)}.sameFilters(
+		LevelOptions{FilterPolicy: bloom.FilterPolicy(12)}))
+	require.False(t, LevelOptions{}.sameFilters(LevelOptions{FilterPolicy: bloom.FilterPolicy(10)}))
+
+	// L0 has no filters, and L6 uses more bits per key than the other levels.
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Levels:                      make([]LevelOptions, numLevels),
+	}
+	for i := 1; i < numLevels; i++ {
+		opts.Levels[i].FilterPolicy = bloom.FilterPolicy(10)
+	}
+	opts.Levels[numLevels-1].FilterPolicy = bloom.FilterPolicy(12)
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%03d", i)), nil, nil))
+	}
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Levels[0].NumFiles)
+	require.Zero(t, m.Levels[0].Additional.FilterSize)
+
+	// The flushed table can't be moved to L6, as L6 has a different filter
+	// policy; it's rewritten with a filter instead.
+	require.NoError(t, d.Compact([]byte("key"), []byte("key\xff"), false))
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	m = d.Metrics()
+	require.Equal(t, int64(1), m.Levels[numLevels-1].NumFiles)
+	require.Zero(t, m.Levels[numLevels-1].TablesMoved)
+	require.Equal(t, uint64(1), m.Levels[numLevels-1].TablesCompacted)
+	require.NotZero(t, m.Levels[numLevels-1].Additional.FilterSize)
+	require.Equal(t, m.Levels[numLevels-1].Additional.FilterSize, m.Total().Additional.FilterSize)
+
+	v, closer, err := d.Get([]byte("key050"))
+	require.NoError(t, err)
+	require.Empty(t, v)
+	require.NoError(t, closer.Close())
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// CompactionDropReason is the reason for which a flush or compaction dropped
+// a point key, rather than writing it to its outputs.
+type CompactionDropReason int8
+
+const (
+	// CompactionDropShadowed is the reason for dropping a key shadowed by a
+	// newer key with the same user key in the same snapshot stripe.
+	CompactionDropShadowed CompactionDropReason = iota
+	// CompactionDropDeletedByRangeDel is the reason for dropping a key deleted
+	// by a range deletion in the same snapshot stripe.
+	CompactionDropDeletedByRangeDel
+	// CompactionDropElidedTombstone is the reason for dropping a point
+	// tombstone that doesn't delete any key in the levels below the output
+	// level.
+	CompactionDropElidedTombstone
+	// CompactionDropMerged is the reason for dropping a key whose value was
+	// merged into the value of a newer MERGE key.
+	CompactionDropMerged
+	// CompactionDropMergeDeleted is the reason for dropping a MERGE key whose
+	// merged value the Merger requested to delete.
+	CompactionDropMergeDeleted
+	// CompactionDropSingleDeleted is the reason for dropping a SINGLEDEL key
+	// and the SET key it deletes.
+	CompactionDropSingleDeleted
+	numCompactionDropReasons
+)
+
+var compactionDropReasonStrings = [numCompactionDropReasons]string{
+	CompactionDropShadowed:          "shadowed",
+	CompactionDropDeletedByRangeDel: "deleted-by-rangedel",
+	CompactionDropElidedTombstone:   "elided-tombstone",
+	CompactionDropMerged:            "merged",
+	CompactionDropMergeDeleted:      "merge-deleted",
+	CompactionDropSingleDeleted:     "single-deleted",
+}
+
+// String implements fmt.Stringer.
+func (r CompactionDropReason) String() string {
+	if r < 0 || r >= numCompactionDropReasons {
+		return "unknown"
+	}
+	return compactionDropReasonStrings[r]
+}
+
+// compactionKeyTally is the count and the checksum of a set of point keys of
+// one kind.
+type compactionKeyTally struct {
+	count uint64
+	sum   uint64
+}
+
+// compactionVerifier reconciles the point keys read by a flush or compaction
+// with the keys it writes and drops (see
+// Options.Experimental.VerifyCompactions). Each key read by the compaction
+// iterator is either written to an output, possibly with its sequence number
+// zeroed or its kind changed, or dropped for a reason, so that for each kind,
+// the keys read must be the union of the keys written and dropped. Keys are
+// accounted for by their user key and their original trailer, and the
+// checksum of a set of keys is the sum of their hashes, which doesn't depend
+// on the order in which the keys are accounted for.
+//
+// Range deletions and range keys are fragmented and truncated by compactions,
+// and aren't reconciled.
+type compactionVerifier struct {
+	read    [InternalKeyKindMax + 1]compactionKeyTally
+	written [InternalKeyKindMax + 1]compactionKeyTally
+	dropped [InternalKeyKindMax + 1][numCompactionDropReasons]compactionKeyTally
+	buf     []byte
+}
+
+// isVerifiedKind returns true if the keys of the given kind are reconciled.
+func isVerifiedKind(kind InternalKeyKind) bool {
+	switch kind {
+	case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindDelete,
+		InternalKeyKindSingleDelete, InternalKeyKindMerge:
+		return true
+	}
+	return false
+}
+
+func (v *compactionVerifier) hash(userKey []byte, trailer uint64) uint64 {
+	v.buf = append(v.buf[:0], userKey...)
+	v.buf = binary.LittleEndian.AppendUint64(v.buf, trailer)
+	return xxhash.Sum64(v.buf)
+}
+
+func (v *compactionVerifier) tally(
+	t *[InternalKeyKindMax + 1]compactionKeyTally, userKey []byte, trailer uint64,
+) {
+	kind := base.TrailerKind(trailer)
+	if !isVerifiedKind(kind) {
+		return
+	}
+	t[kind].count++
+	t[kind].sum += v.hash(userKey, trailer)
+}
+
+// keyRead accounts for a key read by the compaction iterator.
+func (v *compactionVerifier) keyRead(userKey []byte, trailer uint64) {
+	v.tally(&v.read, userKey, trailer)
+}
+
+// keyWritten accounts for a key written to an output, with the trailer it had
+// when it was read.
+func (v *compactionVerifier) keyWritten(userKey []byte, trailer uint64) {
+	v.tally(&v.written, userKey, trailer)
+}
+
+// keyDropped accounts for a key dropped for the given reason.
+func (v *compactionVerifier) keyDropped(
+	userKey []byte, trailer uint64, reason CompactionDropReason,
+) {
+	kind := base.TrailerKind(trailer)
+	if !isVerifiedKind(kind) {
+		return
+	}
+	v.dropped[kind][reason].count++
+	v.dropped[kind][reason].sum += v.hash(userKey, trailer)
+}
+
+// verify reconciles the keys read with the keys written and dropped, invoking
+// the given function for each kind of keys that doesn't reconcile. It returns
+// a corruption error if any kind doesn't reconcile.
+func (v *compactionVerifier) verify(
+	jobID int, reason string, failed func(CompactionVerificationInfo),
+) error {
+	var err error
+	for kind := range v.read {
+		info := CompactionVerificationInfo{
+			JobID:           jobID,
+			Reason:          reason,
+			Kind:            InternalKeyKind(kind),
+			Read:            v.read[kind].count,
+			Written:         v.written[kind].count,
+			ReadChecksum:    v.read[kind].sum,
+			WrittenChecksum: v.written[kind].sum,
+		}
+		var dropped uint64
+		for r, t := range v.dropped[kind] {
+			if t.count > 0 {
+				if info.Dropped == nil {
+					info.Dropped = make(map[CompactionDropReason]uint64)
+				}
+				info.Dropped[CompactionDropReason(r)] = t.count
+			}
+			dropped += t.count
+			info.DroppedChecksum += t.sum
+		}
+		if info.Read == info.Written+dropped &&
+			info.ReadChecksum == info.WrittenChecksum+info.DroppedChecksum {
+			continue
+		}
+		failed(info)
+		if err == nil {
+			err = base.CorruptionErrorf("pebble: %s", errors.Safe(info.String()))
+		}
+	}
+	return err
+}
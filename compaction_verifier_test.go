@@ -0,0 +1,136 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionVerifier(t *testing.T) {
+	trailer := func(seqNum uint64, kind InternalKeyKind) uint64 {
+		return base.MakeTrailer(seqNum, kind)
+	}
+	var failures []CompactionVerificationInfo
+	verify := func(v *compactionVerifier) error {
+		failures = failures[:0]
+		return v.verify(7, "default", func(info CompactionVerificationInfo) {
+			failures = append(failures, info)
+		})
+	}
+
+	// Keys written with a different kind or dropped for any reason reconcile
+	// with the keys read.
+	var v compactionVerifier
+	v.keyRead([]byte("a"), trailer(3, InternalKeyKindSet))
+	v.keyRead([]byte("a"), trailer(2, InternalKeyKindSet))
+	v.keyRead([]byte("b"), trailer(4, InternalKeyKindDelete))
+	v.keyRead([]byte("c"), trailer(5, InternalKeyKindRangeDelete))
+	v.keyWritten([]byte("a"), trailer(3, InternalKeyKindSet))
+	v.keyDropped([]byte("a"), trailer(2, InternalKeyKindSet), CompactionDropShadowed)
+	v.keyDropped([]byte("b"), trailer(4, InternalKeyKindDelete), CompactionDropElidedTombstone)
+	require.NoError(t, verify(&v))
+	require.Empty(t, failures)
+
+	// A key that's neither written nor dropped doesn't reconcile.
+	v.keyRead([]byte("d"), trailer(6, InternalKeyKindMerge))
+	err := verify(&v)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	require.Len(t, failures, 1)
+	require.Equal(t, InternalKeyKindMerge, failures[0].Kind)
+	require.EqualValues(t, 1, failures[0].Read)
+	require.EqualValues(t, 0, failures[0].Written)
+
+	// Neither does a key written with a different user key or sequence
+	// number, even though the counts match.
+	v.keyWritten([]byte("e"), trailer(6, InternalKeyKindMerge))
+	require.Error(t, verify(&v))
+	require.Len(t, failures, 1)
+	require.EqualValues(t, 1, failures[0].Written)
+	require.Equal(t,
+		"[JOB 7] compaction(default) verification failed: MERGE keys: 1 read, 1 written, 0 dropped (); "+
+			fmt.Sprintf("checksums: %016x read, %016x written, 0000000000000000 dropped",
+				failures[0].ReadChecksum, failures[0].WrittenChecksum),
+		failures[0].String())
+}
+
+func TestVerifyCompactions(t *testing.T) {
+	seed := rand.Int63()
+	t.Logf("seed: %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	var failures []CompactionVerificationInfo
+	opts := &Options{
+		FS: vfs.NewMem(),
+		EventListener: &EventListener{
+			CompactionVerificationFailed: func(info CompactionVerificationInfo) {
+				failures = append(failures, info)
+			},
+		},
+		FormatMajorVersion: FormatNewest,
+	}
+	opts.Experimental.VerifyCompactions = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write keys of every kind, with snapshots pinning some of their versions,
+	// so that flushes and compactions drop keys for every reason.
+	var snapshots []*Snapshot
+	singleDeletable := map[string]bool{}
+	for i := 0; i < 2000; i++ {
+		k := []byte(fmt.Sprintf("k%03d", rng.Intn(200)))
+		switch n := rng.Intn(100); {
+		case n < 40:
+			require.NoError(t, d.Set(k, []byte(fmt.Sprint(i)), nil))
+			singleDeletable[string(k)] = false
+		case n < 55:
+			require.NoError(t, d.Merge(k, []byte(fmt.Sprint(i)), nil))
+			singleDeletable[string(k)] = false
+		case n < 70:
+			require.NoError(t, d.Delete(k, nil))
+			// A key that's set once after a deletion may be single deleted.
+			require.NoError(t, d.Set(k, []byte(fmt.Sprint(i)), nil))
+			singleDeletable[string(k)] = true
+		case n < 80:
+			if singleDeletable[string(k)] {
+				require.NoError(t, d.SingleDelete(k, nil))
+				singleDeletable[string(k)] = false
+			}
+		case n < 85:
+			end := []byte(fmt.Sprintf("k%03d", rng.Intn(200)))
+			if d.cmp(k, end) < 0 {
+				require.NoError(t, d.DeleteRange(k, end, nil))
+				for key := range singleDeletable {
+					if d.cmp([]byte(key), k) >= 0 && d.cmp([]byte(key), end) < 0 {
+						singleDeletable[key] = false
+					}
+				}
+			}
+		case n < 90:
+			snapshots = append(snapshots, d.NewSnapshot())
+		case n < 95:
+			if len(snapshots) > 0 {
+				j := rng.Intn(len(snapshots))
+				require.NoError(t, snapshots[j].Close())
+				snapshots = append(snapshots[:j], snapshots[j+1:]...)
+			}
+		default:
+			require.NoError(t, d.Flush())
+		}
+	}
+	require.NoError(t, d.Compact([]byte("k"), []byte("l"), true /* parallelize */))
+	for _, s := range snapshots {
+		require.NoError(t, s.Close())
+	}
+	require.NoError(t, d.Compact([]byte("k"), []byte("l"), true /* parallelize */))
+	require.Empty(t, failures)
+}
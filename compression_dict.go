@@ -0,0 +1,43 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/pebble/sstable"
+
+// initCompressionDictTrainers creates the trainers of the levels whose
+// compression dictionaries are trained.
+func (d *DB) initCompressionDictTrainers() {
+	for level := range d.compressionDictTrainers {
+		l := d.opts.Level(level)
+		if l.Compression == ZstdCompression && l.CompressionDict == nil && l.CompressionDictSize > 0 {
+			d.compressionDictTrainers[level] = sstable.NewDictionaryTrainer(l.CompressionDictSize)
+		}
+	}
+}
+
+// compressionDictSupported returns true if the sstables written by the DB may
+// be compressed with dictionaries, which previous versions of Pebble can't
+// read.
+func (d *DB) compressionDictSupported() bool {
+	return d.FormatMajorVersion() >= FormatTableFeatures
+}
+
+// trainCompressionDict configures o such that the sstables written with it
+// into the level are compressed with the dictionary trained so far, if any,
+// and train the dictionaries of subsequent sstables. Below
+// FormatTableFeatures, the sstables aren't compressed with dictionaries, and
+// no dictionaries are trained.
+func (d *DB) trainCompressionDict(o *sstable.WriterOptions, level int) {
+	if !d.compressionDictSupported() {
+		o.CompressionDict = nil
+		return
+	}
+	t := d.compressionDictTrainers[level]
+	if t == nil {
+		return
+	}
+	o.CompressionDict = t.Dictionary()
+	o.CompressionDictTrainer = t
+}
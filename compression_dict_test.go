@@ -0,0 +1,70 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressionDictTraining(t *testing.T) {
+	// Dictionaries are only used at FormatTableFeatures or later.
+	testCompressionDictTraining(t, FormatTableFeatures-1, []bool{false, false})
+	testCompressionDictTraining(t, FormatTableFeatures, []bool{false, true})
+}
+
+func testCompressionDictTraining(t *testing.T, fmv FormatMajorVersion, want []bool) {
+	fs := vfs.NewMem()
+	opts := &Options{FS: fs, FormatMajorVersion: fmv, Levels: make([]LevelOptions, 1)}
+	opts.Levels[0].BlockSize = 512
+	opts.Levels[0].Compression = ZstdCompression
+	opts.Levels[0].CompressionDictSize = 4 << 10
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// hasDict returns whether the flushed table was compressed with a
+	// dictionary, and verifies its contents.
+	hasDict := func(fileNum base.FileNum) bool {
+		f, err := fs.Open(base.MakeFilepath(fs, "", fileTypeTable, fileNum.DiskFileNum()))
+		require.NoError(t, err)
+		readable, err := sstable.NewSimpleReadable(f)
+		require.NoError(t, err)
+		r, err := sstable.NewReader(readable, sstable.ReaderOptions{})
+		require.NoError(t, err)
+		defer r.Close()
+		l, err := r.Layout()
+		require.NoError(t, err)
+		require.NoError(t, r.ValidateBlockChecksums())
+		require.Equal(t, l.ZstdDict.Length != 0, r.Features()&sstable.TableFeatureCompressionDict != 0)
+		return l.ZstdDict.Length != 0
+	}
+
+	// The first flush trains the dictionary with which the second is
+	// compressed, if dictionaries are supported.
+	var dicts []bool
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 1000; j++ {
+			key := []byte(fmt.Sprintf("key%d-%04d", i, j))
+			value := []byte(fmt.Sprintf("status=active,region=us-east-%d,payload=%08d", j%3, j))
+			require.NoError(t, d.Set(key, value, nil))
+		}
+		require.NoError(t, d.Flush())
+		tables, err := d.SSTables()
+		require.NoError(t, err)
+		dicts = append(dicts, hasDict(tables[0][len(tables[0])-1].FileNum))
+	}
+	require.Equal(t, want, dicts)
+
+	v, closer, err := d.Get([]byte("key1-0042"))
+	require.NoError(t, err)
+	require.Equal(t, "status=active,region=us-east-0,payload=00000042", string(v))
+	require.NoError(t, closer.Close())
+}
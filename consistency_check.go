@@ -0,0 +1,159 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// ConsistencyCheckLevel configures the validation of the sstables of the LSM
+// performed by Open. See Options.Experimental.ConsistencyCheck.
+type ConsistencyCheckLevel int8
+
+const (
+	// ConsistencyCheckFast verifies that the sstables referenced by the
+	// MANIFEST exist and have the sizes recorded in the MANIFEST. It is the
+	// zero value, so that Open performs it by default.
+	ConsistencyCheckFast ConsistencyCheckLevel = iota
+	// ConsistencyCheckNone skips the validation of the sstables. A missing or
+	// corrupt sstable is only detected once it's read.
+	ConsistencyCheckNone
+	// ConsistencyCheckThorough performs the checks of ConsistencyCheckFast,
+	// and then opens every sstable, reading its footer and verifying the
+	// checksums of its index, filter and meta blocks, along with those of a
+	// sample of its data blocks (see
+	// Options.Experimental.ConsistencyCheckSampleRate). Its cost is
+	// proportional to the size of the LSM, and it may significantly slow down
+	// Open.
+	ConsistencyCheckThorough
+)
+
+// defaultConsistencyCheckSampleRate is the fraction of data blocks verified
+// by ConsistencyCheckThorough when
+// Options.Experimental.ConsistencyCheckSampleRate is zero.
+const defaultConsistencyCheckSampleRate = 0.1
+
+// String implements fmt.Stringer.
+func (l ConsistencyCheckLevel) String() string {
+	switch l {
+	case ConsistencyCheckFast:
+		return "fast"
+	case ConsistencyCheckNone:
+		return "none"
+	case ConsistencyCheckThorough:
+		return "thorough"
+	default:
+		return "unknown"
+	}
+}
+
+func parseConsistencyCheckLevel(s string) (ConsistencyCheckLevel, error) {
+	switch s {
+	case "fast":
+		return ConsistencyCheckFast, nil
+	case "none":
+		return ConsistencyCheckNone, nil
+	case "thorough":
+		return ConsistencyCheckThorough, nil
+	default:
+		return 0, errors.Errorf("pebble: unknown consistency check level %q", errors.Safe(s))
+	}
+}
+
+// runConsistencyCheck validates the sstables of the given version according
+// to opts.Experimental.ConsistencyCheck, and reports the outcome to the event
+// listener.
+func runConsistencyCheck(
+	v *manifest.Version,
+	dirname string,
+	objProvider objstorage.Provider,
+	opts *Options,
+	cacheID uint64,
+) error {
+	level := opts.Experimental.ConsistencyCheck
+	if level == ConsistencyCheckNone {
+		return nil
+	}
+	start := time.Now()
+	info := ConsistencyCheckInfo{Level: level}
+	info.Err = checkConsistency(v, dirname, objProvider)
+	if info.Err == nil && level == ConsistencyCheckThorough {
+		rate := opts.Experimental.ConsistencyCheckSampleRate
+		if rate == 0 {
+			rate = defaultConsistencyCheckSampleRate
+		}
+		info.Err = checkTableBlocks(v, objProvider, opts, cacheID, rate)
+	}
+	info.Tables = len(uniqueBackings(v))
+	info.Duration = time.Since(start)
+	opts.EventListener.ConsistencyChecked(info)
+	return info.Err
+}
+
+// uniqueBackings returns the backings of the sstables of the given version,
+// shared by the virtual sstables of a backing.
+func uniqueBackings(v *manifest.Version) []*manifest.FileBacking {
+	var backings []*manifest.FileBacking
+	dedup := make(map[base.DiskFileNum]struct{})
+	for _, files := range v.Levels {
+		iter := files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if _, ok := dedup[f.FileBacking.DiskFileNum]; ok {
+				continue
+			}
+			dedup[f.FileBacking.DiskFileNum] = struct{}{}
+			backings = append(backings, f.FileBacking)
+		}
+	}
+	return backings
+}
+
+// checkTableBlocks opens the sstables of the given version, and verifies the
+// checksums of their blocks, sampling the data blocks with the given rate.
+func checkTableBlocks(
+	v *manifest.Version, objProvider objstorage.Provider, opts *Options, cacheID uint64, rate float64,
+) error {
+	var buf bytes.Buffer
+	var args []interface{}
+	for _, backing := range uniqueBackings(v) {
+		if err := checkTableBlocks1(objProvider, opts, cacheID, backing.DiskFileNum, rate); err != nil {
+			buf.WriteString("%s: %v\n")
+			args = append(args, errors.Safe(backing.DiskFileNum), err)
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return errors.Errorf(buf.String(), args...)
+}
+
+func checkTableBlocks1(
+	objProvider objstorage.Provider,
+	opts *Options,
+	cacheID uint64,
+	fileNum base.DiskFileNum,
+	rate float64,
+) error {
+	readable, err := objProvider.OpenForReading(
+		context.Background(), base.FileTypeTable, fileNum, objstorage.OpenOptions{})
+	if err != nil {
+		return err
+	}
+	cacheOpts := private.SSTableCacheOpts(cacheID, fileNum).(sstable.ReaderOption)
+	r, err := sstable.NewReader(readable, opts.MakeReaderOptions(), cacheOpts)
+	if err != nil {
+		return err
+	}
+	return firstError(r.ValidateSampledBlockChecksums(rate), r.Close())
+}
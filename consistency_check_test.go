@@ -0,0 +1,100 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsistencyCheck(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 100; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%d-%03d", i, j)), []byte("value"), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Close())
+
+	open := func(level ConsistencyCheckLevel) (ConsistencyCheckInfo, error) {
+		var info ConsistencyCheckInfo
+		var checked bool
+		opts := &Options{FS: mem}
+		opts.Experimental.ConsistencyCheck = level
+		opts.Experimental.ConsistencyCheckSampleRate = 1
+		opts.EventListener = &EventListener{
+			ConsistencyChecked: func(i ConsistencyCheckInfo) {
+				info = i
+				checked = true
+			},
+		}
+		d, err := Open("", opts)
+		if err == nil {
+			require.NoError(t, d.Close())
+		}
+		require.Equal(t, level != ConsistencyCheckNone, checked)
+		return info, err
+	}
+
+	for _, level := range []ConsistencyCheckLevel{
+		ConsistencyCheckNone, ConsistencyCheckFast, ConsistencyCheckThorough,
+	} {
+		info, err := open(level)
+		require.NoError(t, err)
+		if level != ConsistencyCheckNone {
+			require.Equal(t, level, info.Level)
+			require.Equal(t, 3, info.Tables)
+			require.NoError(t, info.Err)
+		}
+	}
+
+	// Corrupt a data block of one of the sstables, which only the thorough
+	// check detects.
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	var table string
+	for _, name := range ls {
+		if ft, _, ok := base.ParseFilename(mem, name); ok && ft == base.FileTypeTable {
+			table = name
+			break
+		}
+	}
+	f, err := mem.Open(table)
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	data[10] ^= 0xff
+	f, err = mem.Create(table)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = open(ConsistencyCheckFast)
+	require.NoError(t, err)
+	info, err := open(ConsistencyCheckThorough)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "checksum mismatch")
+	require.Equal(t, err, info.Err)
+
+	// Truncate the sstable, which the fast check detects.
+	f, err = mem.Create(table)
+	require.NoError(t, err)
+	_, err = f.Write(data[:len(data)/2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = open(ConsistencyCheckFast)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "size mismatch")
+}
@@ -689,7 +689,7 @@ func runCompactCmd(td *datadriven.TestData, d *DB) error {
 		if err != nil {
 			return err
 		}
-		return d.manualCompact(iStart.UserKey, iEnd.UserKey, level, parallelize)
+		return d.manualCompact(iStart.UserKey, iEnd.UserKey, level, parallelize, ManualCompactionForeground, nil /* pacer */)
 	}
 	return d.Compact([]byte(parts[0]), []byte(parts[1]), parallelize)
 }
@@ -808,6 +808,20 @@ func runDBDefineCmd(td *datadriven.TestData, opts *Options) (*DB, error) {
 				return nil, errors.Errorf("%s: could not parse %q as float: %s", td.Cmd, arg.Vals[0], err)
 			}
 			opts.Experimental.PointTombstoneWeight = w
+		case "deletion-compaction-range":
+			if len(arg.Vals) != 3 {
+				return nil, errors.Errorf("%s: deletion-compaction-range requires start, end and percent", td.Cmd)
+			}
+			percent, err := strconv.Atoi(arg.Vals[2])
+			if err != nil {
+				return nil, err
+			}
+			opts.Experimental.DeletionCompactionRanges = append(opts.Experimental.DeletionCompactionRanges,
+				DeletionCompactionRange{
+					Start:            []byte(arg.Vals[0]),
+					End:              []byte(arg.Vals[1]),
+					ThresholdPercent: percent,
+				})
 		}
 	}
 	d, err := Open("", opts)
@@ -1189,7 +1203,7 @@ func runForceIngestCmd(td *datadriven.TestData, d *DB) error {
 		*fileMetadata,
 	) (int, error) {
 		return level, nil
-	})
+	}, nil /* applicationMetadata */)
 	return err
 }
 
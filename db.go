@@ -9,7 +9,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -48,6 +50,18 @@ var (
 	// ErrReadOnly is returned when a write operation is performed on a read-only
 	// database.
 	ErrReadOnly = errors.New("pebble: read-only")
+	// ErrReadAmpLimitExceeded is returned by a get operation that did not find
+	// the requested key within the number of sstables permitted by
+	// Options.Experimental.MaxTablesPerGet.
+	ErrReadAmpLimitExceeded = errors.New("pebble: read amplification limit exceeded")
+	// ErrCompactionAborted is returned by a manual compaction aborted by
+	// DB.CloseContext. Automatic compactions in flight when the context is done
+	// are aborted the same way, and are counted in
+	// CloseReport.AbortedCompactions along with manual ones.
+	ErrCompactionAborted = errors.New("pebble: compaction aborted")
+	// ErrCompactionCancelled is returned by a manual compaction cancelled
+	// through its CompactionHandle or the context of DB.CompactAsync.
+	ErrCompactionCancelled = errors.New("pebble: compaction cancelled")
 	// errNoSplit indicates that the user is trying to perform a range key
 	// operation but the configured Comparer does not provide a Split
 	// implementation.
@@ -247,6 +261,7 @@ type DB struct {
 	cacheID        uint64
 	dirname        string
 	walDirname     string
+	tableDirname   string
 	opts           *Options
 	cmp            Compare
 	equal          Equal
@@ -254,12 +269,22 @@ type DB struct {
 	split          Split
 	abbreviatedKey AbbreviatedKey
 	// The threshold for determining when a batch is "large" and will skip being
-	// inserted into a memtable.
-	largeBatchThreshold int
+	// inserted into a memtable. It's derived from Options.MemTableSize, and may
+	// change with DB.SetOptions.
+	largeBatchThreshold atomic.Int64
+	// maxConcurrentCompactions overrides Options.MaxConcurrentCompactions if
+	// non-zero. See DB.SetOptions.
+	maxConcurrentCompactions atomic.Int32
 	// The current OPTIONS file number.
 	optionsFileNum base.DiskFileNum
 	// The on-disk size of the current OPTIONS file.
 	optionsFileSize uint64
+	// The unique ID of the DB. See DB.ID.
+	id string
+	// compressionDictTrainers are the trainers of the compression dictionaries
+	// of the levels, for the levels whose dictionaries are trained. See
+	// LevelOptions.CompressionDictSize.
+	compressionDictTrainers [numLevels]*sstable.DictionaryTrainer
 
 	// objProvider is used to access and manage SSTs.
 	objProvider objstorage.Provider
@@ -267,13 +292,41 @@ type DB struct {
 	fileLock *Lock
 	dataDir  vfs.File
 	walDir   vfs.File
+	// walStripeDirs holds the open Options.Experimental.WALStripeDirs.
+	walStripeDirs []vfs.File
 
 	tableCache           *tableCacheContainer
 	newIters             tableNewIters
 	tableNewRangeKeyIter keyspan.TableNewSpanIter
+	// blobFiles reads the values of the tables that are stored in blob files.
+	blobFiles *blobFileCache
 
 	commit *commitPipeline
 
+	// hotRanges samples committed batches to track write contention per key
+	// range. It is nil unless Options.Experimental.HotRangeSampling enables
+	// sampling.
+	hotRanges *hotRangeTracker
+	// hotKeys samples reads and committed batches to track the most frequently
+	// accessed keys. It is nil unless Options.Experimental.HotKeySampling
+	// enables sampling.
+	hotKeys *hotKeyTracker
+	// lookupCache caches the results of recent point lookups. It is nil
+	// unless Options.Experimental.LookupCacheSize is positive.
+	lookupCache *lookupCache
+	// columnFamilies holds the handles of the column families, by name, and
+	// serializes their creation and drops. See DB.NewColumnFamily.
+	columnFamilies struct {
+		sync.Mutex
+		handles map[string]*ColumnFamily
+	}
+
+	// commitMetrics accumulates the commit stats of the committed batches.
+	commitMetrics commitMetrics
+	// rangeKeyMetrics accumulates the range keys written by the committed
+	// batches, and the points masked by range keys in iterators.
+	rangeKeyMetrics rangeKeyMetrics
+
 	// readState provides access to the state needed for reading without needing
 	// to acquire DB.mu.
 	readState struct {
@@ -289,7 +342,24 @@ type DB struct {
 	closed   *atomic.Value
 	closedCh chan struct{}
 
-	deletionLimiter limiter
+	// deletionLimiter and deletionFileLimiter pace the deletions of obsolete
+	// files (see Options.Experimental.MinDeletionRate and
+	// MinDeletionFileRate). They're nil if the respective pacing is disabled.
+	deletionLimiter     limiter
+	deletionFileLimiter limiter
+	// deletionBacklog tracks the obsolete files awaiting deletion, and the
+	// cumulative time their deletion was delayed by the pacing, in
+	// nanoseconds. See Metrics.DeletionPacing.
+	deletionBacklog struct {
+		count     atomic.Int64
+		size      atomic.Uint64
+		throttled atomic.Int64
+	}
+
+	// closeAborted is set once the context of CloseContext is done. In-flight
+	// compactions then abort at their next key, and the deletions of obsolete
+	// files are no longer paced.
+	closeAborted atomic.Bool
 
 	// Async deletion jobs spawned by cleaners increment this WaitGroup, and
 	// call Done when completed. Once `d.mu.cleaning` is false, the db.Close()
@@ -393,12 +463,19 @@ type DB struct {
 			flushing bool
 			// The number of ongoing compactions.
 			compactingCount int
+			// The number of compactions, manual and automatic, aborted by
+			// CloseContext.
+			abortedCount int
 			// The list of deletion hints, suggesting ranges for delete-only
 			// compactions.
 			deletionHints []deleteCompactionHint
 			// The list of manual compactions. The next manual compaction to perform
 			// is at the start of the list. New entries are added to the end.
 			manual []*manualCompaction
+			// backgroundManual is the list of manual compactions with the
+			// ManualCompactionBackground priority, which are only scheduled once
+			// no automatic compaction can be picked.
+			backgroundManual []*manualCompaction
 			// inProgress is the set of in-progress flushes and compactions.
 			// It's used in the calculation of some metrics and to initialize L0
 			// sublevels' state. Some of the compactions contained within this
@@ -413,6 +490,9 @@ type DB struct {
 			// readCompactions is a readCompactionQueue which keeps track of the
 			// compactions which we might have to perform.
 			readCompactions readCompactionQueue
+			// readCompactionsRequested is the number of read compactions requested
+			// by sampled iterator reads. See Metrics.Compact.ReadRequestedCount.
+			readCompactionsRequested int64
 
 			// The cumulative duration of all completed compactions since Open.
 			// Does not include flushes.
@@ -467,6 +547,14 @@ type DB struct {
 			pending []manifest.NewFileEntry
 		}
 
+		// writeStallCount and writeStallDuration are the cumulative number and
+		// duration of write stalls.
+		writeStallCount    int64
+		writeStallDuration time.Duration
+		// metricsRates retains samples of the metrics from which
+		// Metrics.Rates is computed.
+		metricsRates metricsRates
+
 		tableValidation struct {
 			// cond is a condition variable used to signal the completion of a
 			// job to validate one or more sstables.
@@ -475,9 +563,39 @@ type DB struct {
 			// validated. Only physical sstables should be added to the pending
 			// queue.
 			pending []newFileEntry
+			// written is a slice of metadata for sstables written by flushes
+			// and compactions waiting to be validated, when
+			// Options.Experimental.ValidateOnWriteSampleRate is positive.
+			written []newFileEntry
+			// writtenSeq is incremented each time a flush or compaction adds
+			// tables to written, and validatedSeq is the value of writtenSeq
+			// as of the last completed validation of the written tables.
+			writtenSeq, validatedSeq uint64
+			// held is the list of obsolete tables whose deletion is deferred
+			// until the tables written before they became obsolete have been
+			// validated.
+			held []heldTable
 			// validating is set to true when validation is running.
 			validating bool
 		}
+
+		ingestOrder struct {
+			// cond is a condition variable used to signal the sequencing of an
+			// ingestion, or the loading of its bounds, when
+			// Options.Experimental.StrictIngestOrder is enabled.
+			cond sync.Cond
+			// pending holds the ingestions that have not yet been assigned a
+			// sequence number, in the order the ingestions were called.
+			pending []*ingestTicket
+		}
+
+		// prepared holds the batches prepared by Batch.Prepare that have been
+		// neither committed nor rolled back, by transaction ID. preparedSeq
+		// orders them, and preparing holds the transaction IDs of the batches
+		// being prepared. See two_phase.go.
+		prepared    map[string]*preparedBatch
+		preparedSeq uint64
+		preparing   map[string]struct{}
 	}
 
 	// Normally equal to time.Now() but may be overridden in tests.
@@ -500,14 +618,24 @@ func (d *DB) TestOnlyWaitForCleaning() {
 }
 
 // Get gets the value for the given key. It returns ErrNotFound if the DB does
-// not contain the key.
+// not contain the key, and ErrReadAmpLimitExceeded if the key could not be
+// found within the number of sstables permitted by
+// Options.Experimental.MaxTablesPerGet.
 //
 // The caller should not modify the contents of the returned slice, but it is
 // safe to modify the contents of the argument after Get returns. The returned
 // slice will remain valid until the returned Closer is closed. On success, the
 // caller MUST call closer.Close() or a memory leak will occur.
 func (d *DB) Get(key []byte) ([]byte, io.Closer, error) {
-	return d.getInternal(key, nil /* batch */, nil /* snapshot */)
+	return d.getInternal(context.Background(), key, nil /* batch */, nil /* snapshot */)
+}
+
+// GetWithContext is like Get, but the Get and the blocks it reads are traced
+// as children of the span of the given context (see Options.Tracer), and the
+// memtables, sstables and blocks it touches are recorded in the GetTrace of
+// the context, if any (see WithGetTrace).
+func (d *DB) GetWithContext(ctx context.Context, key []byte) ([]byte, io.Closer, error) {
+	return d.getInternal(ctx, key, nil /* batch */, nil /* snapshot */)
 }
 
 type getIterAlloc struct {
@@ -522,10 +650,48 @@ var getIterAllocPool = sync.Pool{
 	},
 }
 
-func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer, error) {
+func (d *DB) getInternal(
+	ctx context.Context, key []byte, b *Batch, s *Snapshot,
+) (_ []byte, _ io.Closer, retErr error) {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
+	if d.opts.Tracer.IsTracingEnabled(ctx) {
+		var span Span
+		ctx, span = d.opts.Tracer.StartSpan(ctx, GetSpan)
+		defer func() {
+			span.SetAttribute(FoundAttribute, retErr == nil)
+			if retErr != nil && retErr != ErrNotFound {
+				span.RecordError(retErr)
+			}
+			span.End()
+		}()
+	}
+	if d.hotKeys != nil {
+		d.hotKeys.sampleRead(key)
+	}
+
+	// Serve the lookup from the lookup cache if possible. Otherwise, the
+	// epoch of the cache must be loaded before the readState, so that the
+	// result isn't cached if the cache is invalidated by a newer readState.
+	lookupCache := d.lookupCache
+	if lookupCache != nil && (b != nil || getTraceFromContext(ctx) != nil) {
+		lookupCache = nil
+	}
+	var lookupCacheEpoch uint64
+	if lookupCache != nil {
+		cacheSeqNum := uint64(math.MaxUint64)
+		if s != nil {
+			cacheSeqNum = s.seqNum
+		}
+		if value, found, ok := lookupCache.get(key, cacheSeqNum); ok {
+			if !found {
+				return nil, nil, ErrNotFound
+			}
+			return value, noopCloser{}, nil
+		}
+		lookupCacheEpoch = lookupCache.epoch.Load()
+	}
 
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
@@ -545,6 +711,7 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 
 	get := &buf.get
 	*get = getIter{
+		ctx:      ctx,
 		logger:   d.opts.Logger,
 		cmp:      d.cmp,
 		equal:    d.equal,
@@ -556,6 +723,19 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		l0:       readState.current.L0SublevelFiles,
 		version:  readState.current,
 	}
+	if n := d.opts.Experimental.MaxTablesPerGet; n > 0 {
+		get.maxTables = n
+		get.tableNewIters = d.newIters
+		get.newIters = get.limitNewIters
+	}
+	if trace := getTraceFromContext(ctx); trace != nil {
+		trace.begin()
+		defer trace.end()
+		get.ctx = sstable.WithBlockReadObserver(ctx, trace.observeBlockRead)
+		get.trace = trace
+		get.tracedNewIters = get.newIters
+		get.newIters = get.traceNewIters
+	}
 
 	// Strip off memtables which cannot possibly contain the seqNum being read
 	// at.
@@ -568,9 +748,9 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 	}
 
 	i := &buf.dbi
-	pointIter := get
+	pointIter := d.opts.Experimental.TTL.newTTLIter(get)
 	*i = Iterator{
-		ctx:          context.Background(),
+		ctx:          ctx,
 		getIterAlloc: buf,
 		iter:         pointIter,
 		pointIter:    pointIter,
@@ -585,9 +765,17 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		if err != nil {
 			return nil, nil, err
 		}
+		// Only the results of the lookups of the latest state are cached.
+		if lookupCache != nil && s == nil {
+			lookupCache.add(key, nil /* value */, false /* found */, seqNum, lookupCacheEpoch)
+		}
 		return nil, nil, ErrNotFound
 	}
-	return i.Value(), i, nil
+	value := i.Value()
+	if lookupCache != nil && s == nil && i.Error() == nil {
+		lookupCache.add(key, value, true /* found */, seqNum, lookupCacheEpoch)
+	}
+	return value, i, nil
 }
 
 // Set sets the value for the given key. It overwrites any previous value
@@ -786,6 +974,30 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 		return errors.New("pebble: WAL disabled")
 	}
 
+	// The prepare and rollback records of two-phase commits hold no writes.
+	// The prepared batches are checked when committed.
+	checkBatch := batch.twoPhase == nil || batch.twoPhase.kind == preparedRecordCommit
+
+	if len(d.opts.Experimental.BatchApplyHooks) > 0 && checkBatch {
+		restore, err := d.runBatchApplyHooks(batch)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	if d.opts.Experimental.BatchValidator != nil && checkBatch {
+		if err := d.validateBatch(batch); err != nil {
+			return err
+		}
+	}
+
+	if d.opts.Experimental.KeySchema.enabled() && checkBatch {
+		if err := d.validateBatchKeys(batch); err != nil {
+			return err
+		}
+	}
+
 	if batch.countRangeKeys > 0 {
 		if d.split == nil {
 			return errNoSplit
@@ -803,14 +1015,34 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 	if batch.db == nil {
 		batch.refreshMemTableSize()
 	}
-	if int(batch.memTableSize) >= d.largeBatchThreshold {
+	if int64(batch.memTableSize) >= d.largeBatchThreshold.Load() {
 		batch.flushable = newFlushableBatch(batch, d.opts.Comparer)
 	}
+	if d.hotKeys != nil {
+		d.hotKeys.sampleBatch(batch)
+	}
+	var hotRangeWrites map[string]hotRangeWrites
+	var commitStart time.Time
+	if d.hotRanges != nil {
+		if hotRangeWrites = d.hotRanges.sample(batch); hotRangeWrites != nil {
+			commitStart = d.hotRanges.timeNow()
+		}
+	}
+	batch.commitClass = opts.GetCommitClass()
 	if err := d.commit.Commit(batch, sync, noSyncWait); err != nil {
+		if checkErr, ok := err.(*commitCheckError); ok {
+			batch.flushable = nil
+			return checkErr.err
+		}
 		// There isn't much we can do on an error here. The commit pipeline will be
 		// horked at this point.
 		d.opts.Logger.Fatalf("pebble: fatal commit error: %v", err)
 	}
+	if hotRangeWrites != nil {
+		d.hotRanges.record(hotRangeWrites, d.hotRanges.timeNow().Sub(commitStart))
+	}
+	d.commitMetrics.record(&batch.commitStats)
+	d.rangeKeyMetrics.recordBatch(batch)
 	// If this is a large batch, we need to clear the batch contents as the
 	// flushable batch may still be present in the flushables queue.
 	//
@@ -824,7 +1056,37 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 	return nil
 }
 
+// CommittedBatch describes a batch committed to the DB, as passed to
+// Options.Experimental.ApplyCommitted.
+type CommittedBatch struct {
+	// SeqNum is the sequence number of the first mutation of the batch. The
+	// batch occupies the sequence numbers [SeqNum, SeqNum+Count).
+	SeqNum uint64
+	// Count is the number of sequence numbers occupied by the batch. It is
+	// zero for batches that only contain LogData.
+	Count uint32
+	// Repr is the batch representation (see Batch.Repr). It is nil if the
+	// sequence numbers were allocated by an sstable ingestion or excise rather
+	// than a batch. Repr must not be modified or retained after
+	// ApplyCommitted returns.
+	Repr []byte
+}
+
+// observeCommit passes the published batch to the ApplyCommitted callback.
+func (d *DB) observeCommit(b *Batch) {
+	c := CommittedBatch{SeqNum: b.SeqNum(), Count: b.Count()}
+	// The batches used by commitPipeline.AllocateSeqNum consist of only a
+	// header.
+	if len(b.data) > batchHeaderLen {
+		c.Repr = b.data
+	}
+	d.opts.Experimental.ApplyCommitted(c)
+}
+
 func (d *DB) commitApply(b *Batch, mem *memTable) error {
+	if d.lookupCache != nil {
+		d.lookupCache.invalidateBatch(b)
+	}
 	if b.flushable != nil {
 		// This is a large batch which was already added to the immutable queue.
 		return nil
@@ -863,6 +1125,11 @@ func (d *DB) commitApply(b *Batch, mem *memTable) error {
 func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*memTable, error) {
 	var size int64
 	repr := b.Repr()
+	if b.twoPhase != nil && b.twoPhase.kind == preparedRecordCommit {
+		// The WAL holds the commit record of a prepared batch in place of the
+		// batch.
+		repr = b.twoPhase.commitRecord(b)
+	}
 
 	if b.flushable != nil {
 		// We have a large batch. Such batches are special in that they don't get
@@ -898,6 +1165,9 @@ func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*mem
 	if err == nil && !d.opts.DisableWAL {
 		d.mu.log.bytesIn += uint64(len(repr))
 	}
+	if err == nil && b.twoPhase != nil {
+		d.applyTwoPhaseLocked(b.twoPhase)
+	}
 
 	// Grab a reference to the memtable while holding DB.mu. Note that for
 	// non-flushable batches (b.flushable == nil) makeRoomForWrite() added a
@@ -930,6 +1200,7 @@ type iterAlloc struct {
 	keyBuf              []byte
 	boundsBuf           [2][]byte
 	prefixOrFullSeekKey []byte
+	seekKeyBuf          []byte
 	merging             mergingIter
 	mlevels             [3 + numLevels]mergingIterLevel
 	levels              [3 + numLevels]levelIter
@@ -948,6 +1219,9 @@ func (d *DB) newIter(ctx context.Context, batch *Batch, s *Snapshot, o *IterOpti
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
+	if o != nil && o.ReadTimestamp != nil && d.opts.Comparer.CompareTimestamps == nil {
+		panic(errReadTimestampUnsupported)
+	}
 	if o.rangeKeys() {
 		if d.FormatMajorVersion() < FormatRangeKeys {
 			panic(fmt.Sprintf(
@@ -959,6 +1233,9 @@ func (d *DB) newIter(ctx context.Context, batch *Batch, s *Snapshot, o *IterOpti
 	if o != nil && o.RangeKeyMasking.Suffix != nil && o.KeyTypes != IterKeyTypePointsAndRanges {
 		panic("pebble: range key masking requires IterKeyTypePointsAndRanges")
 	}
+	if o != nil && o.IncludeDeletions && o.rangeKeys() {
+		panic("pebble: IncludeDeletions is not supported with range keys")
+	}
 	if (batch != nil || s != nil) && (o != nil && o.OnlyReadGuaranteedDurable) {
 		// We could add support for OnlyReadGuaranteedDurable on snapshots if
 		// there was a need: this would require checking that the sequence number
@@ -992,17 +1269,26 @@ func (d *DB) newIter(ctx context.Context, batch *Batch, s *Snapshot, o *IterOpti
 		readState:           readState,
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
+		seekHistory:         seekHistory{lastKey: buf.seekKeyBuf},
 		boundsBuf:           buf.boundsBuf,
 		batch:               batch,
 		newIters:            d.newIters,
 		newIterRangeKey:     d.tableNewRangeKeyIter,
+		ttl:                 &d.opts.Experimental.TTL,
 		seqNum:              seqNum,
+		snapshot:            s != nil,
+	}
+	if s != nil {
+		dbi.snapshotLower, dbi.snapshotUpper = s.lower, s.upper
 	}
 	if o != nil {
 		dbi.opts = *o
-		dbi.processBounds(o.LowerBound, o.UpperBound)
 	}
+	dbi.processBounds(dbi.opts.LowerBound, dbi.opts.UpperBound)
 	dbi.opts.logger = d.opts.Logger
+	if d.opts.Experimental.PrecheckTableFilters {
+		dbi.prefixFilter = d.tableCache.mayContainPrefix
+	}
 	if d.opts.private.disableLazyCombinedIteration {
 		dbi.opts.disableLazyCombinedIteration = true
 	}
@@ -1019,19 +1305,7 @@ func (d *DB) newIter(ctx context.Context, batch *Batch, s *Snapshot, o *IterOpti
 func finishInitializingIter(ctx context.Context, buf *iterAlloc) *Iterator {
 	// Short-hand.
 	dbi := &buf.dbi
-	memtables := dbi.readState.memtables
-	if dbi.opts.OnlyReadGuaranteedDurable {
-		memtables = nil
-	} else {
-		// We only need to read from memtables which contain sequence numbers older
-		// than seqNum. Trim off newer memtables.
-		for i := len(memtables) - 1; i >= 0; i-- {
-			if logSeqNum := memtables[i].logSeqNum; logSeqNum < dbi.seqNum {
-				break
-			}
-			memtables = memtables[:i]
-		}
-	}
+	memtables := dbi.visibleMemtables()
 
 	if dbi.opts.pointKeys() {
 		// Construct the point iterator, initializing dbi.pointIter to point to
@@ -1234,6 +1508,24 @@ func finishInitializingInternalIter(buf *iterAlloc, i *scanInternalIterator) *sc
 	return i
 }
 
+// visibleMemtables returns the memtables of the Iterator's readState that the
+// Iterator reads from.
+func (i *Iterator) visibleMemtables() flushableList {
+	if i.opts.OnlyReadGuaranteedDurable {
+		return nil
+	}
+	// We only need to read from memtables which contain sequence numbers older
+	// than seqNum. Trim off newer memtables.
+	memtables := i.readState.memtables
+	for j := len(memtables) - 1; j >= 0; j-- {
+		if logSeqNum := memtables[j].logSeqNum; logSeqNum < i.seqNum {
+			break
+		}
+		memtables = memtables[:j]
+	}
+	return memtables
+}
+
 func (i *Iterator) constructPointIter(
 	ctx context.Context, memtables flushableList, buf *iterAlloc,
 ) {
@@ -1325,6 +1617,7 @@ func (i *Iterator) constructPointIter(
 
 		li.init(
 			ctx, i.opts, i.comparer.Compare, i.comparer.Split, i.newIters, files, level, internalOpts)
+		li.initPrefixFilter(i.prefixFilter)
 		li.initRangeDel(&mlevels[mlevelsIndex].rangeDelIter)
 		li.initBoundaryContext(&mlevels[mlevelsIndex].levelIterBoundaryContext)
 		li.initCombinedIterState(&i.lazyCombinedIter.combinedIterState)
@@ -1354,8 +1647,12 @@ func (i *Iterator) constructPointIter(
 	buf.merging.snapshot = i.seqNum
 	buf.merging.batchSnapshot = i.batchSeqNum
 	buf.merging.combinedIterState = &i.lazyCombinedIter.combinedIterState
-	i.pointIter = &buf.merging
+	i.pointIter = i.ttl.newTTLIter(
+		newReadTimestampIter(&buf.merging, &i.comparer, i.opts.ReadTimestamp))
 	i.merging = &buf.merging
+	if i.opts.IncludeDeletions {
+		i.constructDeletionsIter(ctx, memtables)
+	}
 }
 
 // NewBatch returns a new empty write-only batch. Any reads on the batch will
@@ -1420,6 +1717,38 @@ func (d *DB) NewSnapshot() *Snapshot {
 // or to call Close concurrently with any other DB method. It is not valid
 // to call any of a DB's methods after the DB has been closed.
 func (d *DB) Close() error {
+	_, err := d.CloseContext(context.Background())
+	return err
+}
+
+// CloseReport describes the work skipped by DB.CloseContext once its context
+// was done.
+type CloseReport struct {
+	// Aborted is set if the context was done before the DB was closed.
+	Aborted bool
+	// AbortedCompactions is the number of in-flight compactions, manual and
+	// automatic, aborted. Their outputs are discarded, and their inputs remain
+	// in the LSM.
+	AbortedCompactions int
+	// SkippedTableValidations is the number of sstables written by flushes and
+	// compactions whose validation was skipped (see
+	// Options.Experimental.ValidateOnWriteSampleRate).
+	SkippedTableValidations int
+	// SkippedObsoleteFiles is the number of obsolete files that weren't
+	// deleted. They're deleted once the DB is reopened.
+	SkippedObsoleteFiles int
+}
+
+// CloseContext closes the DB like Close, bounding the time spent closing it
+// by the context. Once the context is done, the in-flight compactions abort
+// at their next key, the validation of the sstables written by flushes and
+// compactions and the deletion of obsolete files are skipped, and the
+// deletions in progress are no longer paced. The skipped work is described by
+// the returned report. Flushes are never aborted, so that the DB doesn't
+// lose writes when the WAL is disabled.
+//
+// The same restrictions as for Close apply.
+func (d *DB) CloseContext(ctx context.Context) (CloseReport, error) {
 	// Lock the commit pipeline for the duration of Close. This prevents a race
 	// with makeRoomForWrite. Rotating the WAL in makeRoomForWrite requires
 	// dropping d.mu several times for I/O. If Close only holds d.mu, an
@@ -1450,15 +1779,48 @@ func (d *DB) Close() error {
 
 	defer d.opts.Cache.Unref()
 
+	var report CloseReport
+	// Once the context is done, abort the in-flight compactions and skip the
+	// remaining optional work.
+	stopWatching := func() {}
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				d.closeAborted.Store(true)
+				d.mu.Lock()
+				d.mu.compact.cond.Broadcast()
+				d.mu.Unlock()
+			case <-stop:
+			}
+		}()
+		// NB: d.mu must not be held.
+		stopWatching = func() {
+			close(stop)
+			wg.Wait()
+		}
+	}
+
 	for d.mu.compact.compactingCount > 0 || d.mu.compact.flushing {
 		d.mu.compact.cond.Wait()
 	}
+	report.AbortedCompactions = d.mu.compact.abortedCount
 	for d.mu.tableStats.loading {
 		d.mu.tableStats.cond.Wait()
 	}
 	for d.mu.tableValidation.validating {
 		d.mu.tableValidation.cond.Wait()
 	}
+	// Validate the remaining tables written by flushes and compactions, so
+	// that the obsolete tables held back for them are deleted.
+	for len(d.mu.tableValidation.written) > 0 && !d.closeAborted.Load() {
+		d.validateSSTablesLocked()
+	}
+	report.SkippedTableValidations = len(d.mu.tableValidation.written)
 
 	var err error
 	if n := len(d.mu.compact.inProgress); n > 0 {
@@ -1466,6 +1828,7 @@ func (d *DB) Close() error {
 	}
 	err = firstError(err, d.mu.formatVers.marker.Close())
 	err = firstError(err, d.tableCache.close())
+	d.blobFiles.close()
 	if !d.opts.ReadOnly {
 		err = firstError(err, d.mu.log.Close())
 	} else if d.mu.log.LogWriter != nil {
@@ -1481,6 +1844,9 @@ func (d *DB) Close() error {
 	if d.dataDir != d.walDir {
 		err = firstError(err, d.walDir.Close())
 	}
+	for _, dir := range d.walStripeDirs {
+		err = firstError(err, dir.Close())
+	}
 
 	d.readState.val.unrefLocked()
 
@@ -1517,12 +1883,19 @@ func (d *DB) Close() error {
 	// prevented a new cleaning job when a readState was unrefed. If needed,
 	// synchronously delete obsolete files.
 	if len(d.mu.versions.obsoleteTables) > 0 {
-		d.deleteObsoleteFiles(d.mu.nextJobID, true /* waitForOngoing */)
+		if d.closeAborted.Load() {
+			report.SkippedObsoleteFiles = len(d.mu.versions.obsoleteTables)
+		} else {
+			d.deleteObsoleteFiles(d.mu.nextJobID, true /* waitForOngoing */)
+		}
 	}
+	report.SkippedObsoleteFiles += len(d.mu.tableValidation.held)
 	// Wait for all the deletion goroutines spawned by cleaning jobs to finish.
 	d.mu.Unlock()
 	d.deleters.Wait()
 	d.compactionSchedulers.Wait()
+	stopWatching()
+	report.Aborted = d.closeAborted.Load()
 
 	// Sanity check metrics.
 	if invariants.Enabled {
@@ -1553,11 +1926,271 @@ func (d *DB) Close() error {
 		err = firstError(err, errors.Errorf("leaked snapshots: %d open snapshots on DB %p", v, d))
 	}
 
-	return err
+	return report, err
 }
 
 // Compact the specified range of keys in the database.
 func (d *DB) Compact(start, end []byte, parallelize bool) error {
+	return d.CompactWithOptions(start, end, CompactOptions{Parallelize: parallelize})
+}
+
+// CompactOptions configures a manual compaction performed by
+// DB.CompactWithOptions.
+type CompactOptions struct {
+	// Parallelize splits the compaction of each level into compactions of
+	// non-overlapping key ranges that may run concurrently.
+	Parallelize bool
+	// BytesPerSecond, if positive, paces the compactions such that they read
+	// their input at no more than BytesPerSecond, so that a long maintenance
+	// compaction can run without saturating the disk. The pace is shared by
+	// parallel compactions.
+	BytesPerSecond int64
+	// OnProgress, if set, is called periodically with the progress of the
+	// compactions, and once more when they complete successfully. Calls are
+	// serialized.
+	OnProgress func(CompactionProgress)
+	// ProgressInterval is the minimum interval between calls to OnProgress.
+	// Defaults to 1 second.
+	ProgressInterval time.Duration
+	// Priority determines how the compactions are scheduled relative to the
+	// automatic compactions. Defaults to ManualCompactionForeground.
+	Priority ManualCompactionPriority
+}
+
+// ManualCompactionPriority determines how a manual compaction is scheduled
+// relative to the automatic compactions.
+type ManualCompactionPriority int8
+
+const (
+	// ManualCompactionForeground schedules the compactions ahead of the
+	// automatic compactions, so that they complete as soon as possible.
+	ManualCompactionForeground ManualCompactionPriority = iota
+	// ManualCompactionBackground schedules the compactions only when no
+	// automatic compaction is pending and a compaction slot is free, so that
+	// they don't delay the compactions which keep the LSM in shape. They may
+	// be delayed indefinitely under a sustained write load.
+	ManualCompactionBackground
+)
+
+// String implements fmt.Stringer.
+func (p ManualCompactionPriority) String() string {
+	switch p {
+	case ManualCompactionForeground:
+		return "foreground"
+	case ManualCompactionBackground:
+		return "background"
+	default:
+		return fmt.Sprintf("ManualCompactionPriority(%d)", int8(p))
+	}
+}
+
+// CompactionProgress describes the progress of a manual compaction performed
+// by DB.CompactWithOptions or DB.CompactAsync, or of a compaction reported by
+// DB.InProgressCompactions.
+type CompactionProgress struct {
+	// BytesCompacted is the number of input bytes compacted so far.
+	BytesCompacted uint64
+	// BytesTotal is the estimated number of input bytes to compact, which
+	// includes the bytes rewritten as data moves through each level. The
+	// estimate does not account for data elided by the compactions, and is
+	// revised upwards if exceeded.
+	BytesTotal uint64
+	// CurrentKey is the user key being compacted, or nil if unknown. It is
+	// only valid for the duration of the OnProgress call.
+	CurrentKey []byte
+	// Elapsed is the time since the start of the compaction.
+	Elapsed time.Duration
+	// Remaining is the estimated time remaining, extrapolated from the rate
+	// at which bytes have been compacted so far.
+	Remaining time.Duration
+}
+
+// estimateRemaining sets Remaining, extrapolating from the rate at which bytes
+// have been compacted so far. BytesTotal is raised to BytesCompacted if it was
+// exceeded, or if the compaction is final.
+func (p *CompactionProgress) estimateRemaining(final bool) {
+	// The total is an estimate, which may be exceeded.
+	if final || p.BytesTotal < p.BytesCompacted {
+		p.BytesTotal = p.BytesCompacted
+	}
+	if p.BytesCompacted > 0 {
+		remaining := float64(p.BytesTotal - p.BytesCompacted)
+		p.Remaining = time.Duration(float64(p.Elapsed) * remaining / float64(p.BytesCompacted))
+	}
+}
+
+// CompactWithOptions compacts the specified range of keys in the database,
+// like Compact, optionally pacing the compaction and reporting its progress.
+func (d *DB) CompactWithOptions(start, end []byte, opts CompactOptions) error {
+	if err := d.checkManualCompaction(start, end); err != nil {
+		return err
+	}
+	var pacer *manualCompactionPacer
+	if opts.BytesPerSecond > 0 || opts.OnProgress != nil {
+		pacer = newManualCompactionPacer(opts, d.timeNow)
+	}
+	return d.compactWithOptions(start, end, opts, pacer)
+}
+
+// InProgressCompaction describes a flush or compaction in progress, as
+// reported by DB.InProgressCompactions.
+type InProgressCompaction struct {
+	// JobID is the ID of the job, as reported by FlushInfo.JobID or
+	// CompactionInfo.JobID, or 0 if the job hasn't started yet.
+	JobID int
+	// Reason is the kind of the compaction, as reported by
+	// CompactionInfo.Reason, or "flush".
+	Reason string
+	// StartLevel is the level of the inputs, or -1 for a flush.
+	StartLevel int
+	// OutputLevel is the level of the outputs.
+	OutputLevel int
+	// BytesWritten is the number of bytes written to the outputs so far.
+	BytesWritten uint64
+	// Progress is the progress of the job. BytesCompacted is the number of
+	// input bytes read so far, and BytesTotal is the size of the inputs. The
+	// estimated remaining time is extrapolated from the rate at which the
+	// inputs have been read. CurrentKey isn't set.
+	Progress CompactionProgress
+}
+
+// InProgressCompactions returns the flushes and compactions in progress,
+// along with their progress, so that the operator of a long-running
+// compaction may tell how far along it is.
+func (d *DB) InProgressCompactions() []InProgressCompaction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.timeNow()
+	var res []InProgressCompaction
+	for c := range d.mu.compact.inProgress {
+		ipc := InProgressCompaction{
+			JobID:        c.jobID,
+			Reason:       c.kind.String(),
+			StartLevel:   c.startLevel.level,
+			OutputLevel:  numLevels - 1,
+			BytesWritten: uint64(c.bytesWritten.Load()),
+			Progress: CompactionProgress{
+				BytesCompacted: c.bytesRead.Load(),
+				Elapsed:        now.Sub(c.beganAt),
+			},
+		}
+		if c.outputLevel != nil {
+			ipc.OutputLevel = c.outputLevel.level
+		}
+		if c.kind == compactionKindFlush {
+			for _, f := range c.flushing {
+				ipc.Progress.BytesTotal += f.inuseBytes()
+			}
+		} else {
+			for _, cl := range c.inputs {
+				ipc.Progress.BytesTotal += cl.files.SizeSum()
+			}
+		}
+		ipc.Progress.estimateRemaining(false /* final */)
+		res = append(res, ipc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].JobID < res[j].JobID
+	})
+	return res
+}
+
+// CompactionHandle tracks a manual compaction started by DB.CompactAsync.
+type CompactionHandle struct {
+	d     *DB
+	pacer *manualCompactionPacer
+	done  chan struct{}
+	// err is set before done is closed.
+	err error
+}
+
+// Done returns a channel which is closed when the compaction completes,
+// successfully or not.
+func (h *CompactionHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait waits for the compaction to complete and returns its error, which is
+// ErrCompactionCancelled if it was cancelled. It returns ctx.Err() if the
+// context is done first, without cancelling the compaction.
+func (h *CompactionHandle) Wait(ctx context.Context) error {
+	select {
+	case <-h.done:
+		return h.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel cancels the compaction, if it hasn't completed yet. The queued
+// compactions are dropped, and the running ones stop at their next key,
+// discarding their outputs; the compactions which already completed remain.
+// Cancel doesn't wait for the compaction to stop; see Wait.
+func (h *CompactionHandle) Cancel() {
+	if h.pacer.cancelled.Swap(true) {
+		return
+	}
+	d := h.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.compact.manual = d.dropManualCompactionsLocked(d.mu.compact.manual, h.pacer)
+	d.mu.compact.backgroundManual = d.dropManualCompactionsLocked(d.mu.compact.backgroundManual, h.pacer)
+}
+
+// dropManualCompactionsLocked removes the queued manual compactions paced by
+// pacer, completing them with ErrCompactionCancelled, and returns the
+// remaining ones. d.mu must be held when calling this.
+func (d *DB) dropManualCompactionsLocked(
+	queue []*manualCompaction, pacer *manualCompactionPacer,
+) []*manualCompaction {
+	remaining := queue[:0]
+	for _, m := range queue {
+		if m.pacer == pacer {
+			m.done <- ErrCompactionCancelled
+			continue
+		}
+		remaining = append(remaining, m)
+	}
+	return remaining
+}
+
+// Progress returns the progress of the compaction so far. Its CurrentKey is
+// not set.
+func (h *CompactionHandle) Progress() CompactionProgress {
+	return h.pacer.progress()
+}
+
+// CompactAsync starts compacting the specified range of keys in the database,
+// like CompactWithOptions, and returns a handle which may be used to wait for
+// the compaction, query its progress or cancel it. The compaction is
+// cancelled once ctx is done. Options.OnProgress must not call
+// CompactionHandle.Progress.
+func (d *DB) CompactAsync(
+	ctx context.Context, start, end []byte, opts CompactOptions,
+) (*CompactionHandle, error) {
+	if err := d.checkManualCompaction(start, end); err != nil {
+		return nil, err
+	}
+	h := &CompactionHandle{
+		d:     d,
+		pacer: newManualCompactionPacer(opts, d.timeNow),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(h.done)
+		h.err = d.compactWithOptions(start, end, opts, h.pacer)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.Cancel()
+		case <-h.done:
+		}
+	}()
+	return h, nil
+}
+
+func (d *DB) checkManualCompaction(start, end []byte) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
@@ -1568,6 +2201,15 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 		return errors.Errorf("Compact start %s is not less than end %s",
 			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
 	}
+	return nil
+}
+
+// compactWithOptions performs the manual compaction of CompactWithOptions
+// and CompactAsync. The pacer, if non-nil, paces the compaction, reports its
+// progress and cancels it.
+func (d *DB) compactWithOptions(
+	start, end []byte, opts CompactOptions, pacer *manualCompactionPacer,
+) error {
 	iStart := base.MakeInternalKey(start, InternalKeySeqNumMax, InternalKeyKindMax)
 	iEnd := base.MakeInternalKey(end, 0, 0)
 	m := (&fileMetadata{}).ExtendPointKeyBounds(d.cmp, iStart, iEnd)
@@ -1624,9 +2266,19 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 		<-mem.flushed
 	}
 
+	if pacer != nil {
+		d.mu.Lock()
+		total := d.estimateManualCompactionBytes(start, end, maxLevelWithFiles)
+		d.mu.Unlock()
+		pacer.start(total)
+	}
+
 	for level := 0; level < maxLevelWithFiles; {
+		if pacer != nil && pacer.cancelled.Load() {
+			return ErrCompactionCancelled
+		}
 		if err := d.manualCompact(
-			iStart.UserKey, iEnd.UserKey, level, parallelize); err != nil {
+			iStart.UserKey, iEnd.UserKey, level, opts.Parallelize, opts.Priority, pacer); err != nil {
 			return err
 		}
 		level++
@@ -1636,10 +2288,48 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 			break
 		}
 	}
+	if pacer != nil {
+		pacer.finish()
+	}
 	return nil
 }
 
-func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error {
+// estimateManualCompactionBytes estimates the number of input bytes read by
+// the manual compactions of [start, end] performed by
+// DB.CompactWithOptions, assuming no data is elided. Each level's data is
+// read by the compaction into the next level, along with the data already in
+// that level. d.mu must be held when calling this.
+func (d *DB) estimateManualCompactionBytes(start, end []byte, maxLevelWithFiles int) uint64 {
+	cur := d.mu.versions.currentVersion()
+	var sizes [numLevels]uint64
+	for level := range sizes {
+		overlaps := cur.Overlaps(level, d.cmp, start, end, false)
+		sizes[level] = overlaps.SizeSum()
+	}
+	baseLevel := d.mu.versions.picker.getBaseLevel()
+	var total uint64
+	for level := 0; level < maxLevelWithFiles && level < numLevels-1; level++ {
+		if sizes[level] == 0 {
+			continue
+		}
+		outputLevel := level + 1
+		if level == 0 {
+			outputLevel = baseLevel
+		}
+		sizes[outputLevel] += sizes[level]
+		sizes[level] = 0
+		total += sizes[outputLevel]
+	}
+	return total
+}
+
+func (d *DB) manualCompact(
+	start, end []byte,
+	level int,
+	parallelize bool,
+	priority ManualCompactionPriority,
+	pacer *manualCompactionPacer,
+) error {
 	d.mu.Lock()
 	curr := d.mu.versions.currentVersion()
 	files := curr.Overlaps(level, d.cmp, start, end, false)
@@ -1650,16 +2340,26 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 
 	var compactions []*manualCompaction
 	if parallelize {
-		compactions = append(compactions, d.splitManualCompaction(start, end, level)...)
+		compactions = append(compactions, d.splitManualCompaction(start, end, level, pacer)...)
 	} else {
 		compactions = append(compactions, &manualCompaction{
 			level: level,
 			done:  make(chan error, 1),
 			start: start,
 			end:   end,
+			pacer: pacer,
 		})
 	}
-	d.mu.compact.manual = append(d.mu.compact.manual, compactions...)
+	if pacer != nil && pacer.cancelled.Load() {
+		// The queued compactions were dropped by CompactionHandle.Cancel.
+		d.mu.Unlock()
+		return ErrCompactionCancelled
+	}
+	if priority == ManualCompactionBackground {
+		d.mu.compact.backgroundManual = append(d.mu.compact.backgroundManual, compactions...)
+	} else {
+		d.mu.compact.manual = append(d.mu.compact.manual, compactions...)
+	}
 	d.maybeScheduleCompaction()
 	d.mu.Unlock()
 
@@ -1681,7 +2381,7 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 // splitManualCompaction splits a manual compaction over [start,end] on level
 // such that the resulting compactions have no key overlap.
 func (d *DB) splitManualCompaction(
-	start, end []byte, level int,
+	start, end []byte, level int, pacer *manualCompactionPacer,
 ) (splitCompactions []*manualCompaction) {
 	curr := d.mu.versions.currentVersion()
 	endLevel := level + 1
@@ -1697,6 +2397,7 @@ func (d *DB) splitManualCompaction(
 			start: keyRange.Start,
 			end:   keyRange.End,
 			split: true,
+			pacer: pacer,
 		})
 	}
 	return splitCompactions
@@ -1736,6 +2437,181 @@ func (d *DB) AsyncFlush() (<-chan struct{}, error) {
 	return flushed, nil
 }
 
+// FlushedTable describes an sstable added to the LSM by a flush.
+type FlushedTable struct {
+	TableInfo
+	// Level is the level of the table. Flushed memtables are written to L0,
+	// while the sstables of an ingestion which overlapped the memtable (see
+	// IngestOperationStats.MemtableOverlappingFiles) may be flushed to lower
+	// levels.
+	Level int
+}
+
+// FlushResult describes the flushes which completed a FlushHandle.
+type FlushResult struct {
+	// JobIDs are the IDs of the flush jobs, as reported by FlushInfo.JobID,
+	// in the order they completed. A single handle may be completed by
+	// several flush jobs, eg, if the memtable was queued behind an ingestion.
+	JobIDs []int
+	// Tables are the sstables added to the LSM by the flush jobs, in the order
+	// of JobIDs. They're empty if the flushed memtables were empty.
+	Tables []FlushedTable
+}
+
+// flushOutcome records the outcome of the flush of a flushableEntry, for
+// FlushHandle. Protected by DB.mu.
+type flushOutcome struct {
+	// jobID and tables are set once the flushable is flushed.
+	jobID  int
+	tables []FlushedTable
+	// err is the error of the most recent failed attempt to flush the
+	// flushable, which is cleared once the flushable is flushed.
+	err error
+}
+
+// FlushHandle tracks a flush started by DB.AsyncFlushWithHandle.
+type FlushHandle struct {
+	d        *DB
+	outcomes []*flushOutcome
+	done     <-chan struct{}
+}
+
+// Done returns a channel which is closed when the flush completes.
+func (h *FlushHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err returns the error of the most recent failed attempt to flush, or nil.
+// Failed flushes are retried, so an error doesn't mean that the flush won't
+// complete; it's reported so that the caller may give up waiting.
+func (h *FlushHandle) Err() error {
+	h.d.mu.Lock()
+	defer h.d.mu.Unlock()
+	for i := len(h.outcomes) - 1; i >= 0; i-- {
+		if err := h.outcomes[i].err; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait waits for the flush to complete and returns the sstables it added to
+// the LSM. It returns ctx.Err() if the context is done first, along with the
+// most recent flush error, if any.
+func (h *FlushHandle) Wait(ctx context.Context) (FlushResult, error) {
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+		if err := h.Err(); err != nil {
+			return FlushResult{}, errors.CombineErrors(ctx.Err(), err)
+		}
+		return FlushResult{}, ctx.Err()
+	}
+	h.d.mu.Lock()
+	defer h.d.mu.Unlock()
+	var r FlushResult
+	for _, o := range h.outcomes {
+		if n := len(r.JobIDs); n > 0 && r.JobIDs[n-1] == o.jobID {
+			// Flushed by the same job as the previous flushable.
+			continue
+		}
+		r.JobIDs = append(r.JobIDs, o.jobID)
+		r.Tables = append(r.Tables, o.tables...)
+	}
+	return r, nil
+}
+
+// AsyncFlushWithHandle asynchronously flushes the memtable to stable storage,
+// as AsyncFlush does, and returns a handle which reports the sstables written
+// by the flush once it completes. This allows the caller to tie the flushed
+// state to its own checkpoints, eg, a replication layer may record which
+// sstables contain the entries of its log.
+//
+// The handle covers all the memtables queued for flushing when it's created,
+// not just the mutable memtable, as they're flushed first.
+func (d *DB) AsyncFlushWithHandle() (*FlushHandle, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	d.commit.mu.Lock()
+	defer d.commit.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h := &FlushHandle{
+		d:        d,
+		outcomes: make([]*flushOutcome, len(d.mu.mem.queue)),
+		done:     d.mu.mem.queue[len(d.mu.mem.queue)-1].flushed,
+	}
+	for i, e := range d.mu.mem.queue {
+		if e.outcome == nil {
+			e.outcome = &flushOutcome{}
+		}
+		h.outcomes[i] = e.outcome
+	}
+	if err := d.makeRoomForWrite(nil); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// FlushWithApplicationMetadata flushes the memtable to stable storage, as
+// Flush does, and persists the given opaque application metadata in the
+// MANIFEST atomically with the flush. The metadata may be used to record
+// application state that describes the flushed writes, eg, the index of the
+// last applied entry of a replicated log. It's retrieved on Open through
+// DB.ApplicationMetadata.
+//
+// The metadata is small and must be non-nil. It's persisted in the MANIFEST
+// along with every MANIFEST snapshot, and requires the DB to be at format
+// major version FormatApplicationMetadata or later.
+func (d *DB) FlushWithApplicationMetadata(applicationMetadata []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if applicationMetadata == nil {
+		return errors.New("pebble: nil application metadata")
+	}
+	if v := d.FormatMajorVersion(); v < FormatApplicationMetadata {
+		return errors.Errorf(
+			"pebble: application metadata requires at least format major version %d (current: %d)",
+			FormatApplicationMetadata, v,
+		)
+	}
+
+	d.commit.mu.Lock()
+	d.mu.Lock()
+	mem := d.mu.mem.queue[len(d.mu.mem.queue)-1]
+	mem.applicationMetadata = append([]byte{}, applicationMetadata...)
+	err := d.makeRoomForWrite(nil)
+	d.mu.Unlock()
+	d.commit.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	<-mem.flushed
+	return nil
+}
+
+// ApplicationMetadata returns the most recent application metadata persisted
+// in the MANIFEST by FlushWithApplicationMetadata or
+// IngestWithApplicationMetadata, or nil if there is none. The returned slice
+// is owned by the caller.
+func (d *DB) ApplicationMetadata() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mu.versions.applicationMetadata == nil {
+		return nil
+	}
+	return append([]byte{}, d.mu.versions.applicationMetadata...)
+}
+
 // Metrics returns metrics about the database.
 func (d *DB) Metrics() *Metrics {
 	metrics := &Metrics{}
@@ -1748,6 +2624,7 @@ func (d *DB) Metrics() *Metrics {
 	metrics.Compact.InProgressBytes = d.mu.versions.atomicInProgressBytes.Load()
 	metrics.Compact.NumInProgress = int64(d.mu.compact.compactingCount)
 	metrics.Compact.MarkedFiles = vers.Stats.MarkedForCompaction
+	metrics.Compact.ReadRequestedCount = d.mu.compact.readCompactionsRequested
 	metrics.Compact.Duration = d.mu.compact.duration
 	for c := range d.mu.compact.inProgress {
 		if c.kind != compactionKindFlush {
@@ -1764,6 +2641,8 @@ func (d *DB) Metrics() *Metrics {
 	}
 	metrics.Snapshots.PinnedKeys = d.mu.snapshots.cumulativePinnedCount
 	metrics.Snapshots.PinnedSize = d.mu.snapshots.cumulativePinnedSize
+	metrics.TableValidation.HeldCount = int64(len(d.mu.tableValidation.held))
+	metrics.Freeze.Frozen = d.mu.versions.frozen
 	metrics.MemTable.Count = int64(len(d.mu.mem.queue))
 	metrics.MemTable.ZombieCount = d.memTableCount.Load() - metrics.MemTable.Count
 	metrics.MemTable.ZombieSize = uint64(d.memTableReserved.Load()) - metrics.MemTable.Size
@@ -1794,20 +2673,37 @@ func (d *DB) Metrics() *Metrics {
 			metrics.Levels[level].Score = score
 		}
 	}
+	metrics.DeletionPacing.BacklogCount = d.deletionBacklog.count.Load()
+	metrics.DeletionPacing.BacklogSize = d.deletionBacklog.size.Load()
+	metrics.DeletionPacing.ThrottledDuration = time.Duration(d.deletionBacklog.throttled.Load())
 	metrics.Table.ZombieCount = int64(len(d.mu.versions.zombieTables))
 	for _, size := range d.mu.versions.zombieTables {
 		metrics.Table.ZombieSize += size
 	}
+	metrics.BlobFiles.Count = int64(len(d.mu.versions.blobFiles))
+	for _, m := range d.mu.versions.blobFiles {
+		metrics.BlobFiles.Size += m.size
+		metrics.BlobFiles.LiveValueSize += m.liveValueSize
+	}
 	metrics.private.optionsFileSize = d.optionsFileSize
+	writeStallDuration := d.mu.writeStallDuration
+	metrics.WriteStall.Count = d.mu.writeStallCount
+	metrics.WriteStall.Duration = writeStallDuration
 
 	// TODO(jackson): Consider making these metrics optional.
 	metrics.Keys.RangeKeySetsCount = countRangeKeySetFragments(vers)
 	metrics.Keys.TombstoneCount = countTombstones(vers)
 
-	d.mu.versions.logLock()
+	d.mu.versions.logLockForRead()
 	metrics.private.manifestFileSize = uint64(d.mu.versions.manifest.Size())
 	d.mu.versions.logUnlock()
 
+	if d.mu.log.LogWriter != nil {
+		metrics.Commit.PendingSyncs = int64(d.mu.log.PendingSyncs())
+		metrics.Commit.UnsyncedBytes = uint64(d.mu.log.UnsyncedBytes())
+	}
+	d.commitMetrics.load(metrics)
+	d.rangeKeyMetrics.load(metrics)
 	metrics.LogWriter.FsyncLatency = d.mu.log.metrics.fsyncLatency
 	if err := metrics.LogWriter.Merge(&d.mu.log.metrics.LogWriterMetrics); err != nil {
 		d.opts.Logger.Infof("metrics error: %s", err)
@@ -1818,14 +2714,31 @@ func (d *DB) Metrics() *Metrics {
 	}
 	for i := 0; i < numLevels; i++ {
 		metrics.Levels[i].Additional.ValueBlocksSize = valueBlocksSizeForLevel(vers, i)
+		metrics.Levels[i].Additional.FilterSize = filterSizeForLevel(vers, i)
+		metrics.Levels[i].Additional.RangeKeySetsCount = countRangeKeySetFragmentsForLevel(vers, i)
 	}
 
 	d.mu.Unlock()
 
 	metrics.BlockCache = d.opts.Cache.Metrics()
+	if c := d.tableCache.dbOpts.bulkScanCache; c != nil {
+		metrics.BulkScanCache = c.Metrics()
+	}
+	metrics.SecondaryCache = d.objProvider.Metrics().SecondaryCache
+	if d.lookupCache != nil {
+		metrics.LookupCache = d.lookupCache.metrics()
+	}
 	metrics.TableCache, metrics.Filter = d.tableCache.metrics()
+	metrics.RangeKeys.BlocksRead = d.tableCache.dbOpts.rangeKeyBlocksRead.Load()
 	metrics.TableIters = int64(d.tableCache.iterCount())
 	metrics.Uptime = d.timeNow().Sub(d.openedAt)
+
+	cur := makeMetricsSample(d.timeNow(), metrics, writeStallDuration)
+	d.mu.Lock()
+	metrics.Rates.OneMinute = d.mu.metricsRates.rates(cur, time.Minute)
+	metrics.Rates.TenMinutes = d.mu.metricsRates.rates(cur, 10*time.Minute)
+	metrics.Rates.OneHour = d.mu.metricsRates.rates(cur, time.Hour)
+	d.mu.Unlock()
 	return metrics
 }
 
@@ -1838,7 +2751,9 @@ type sstablesOptions struct {
 // SSTablesOption set optional parameter used by `DB.SSTables`.
 type SSTablesOption func(*sstablesOptions)
 
-// WithProperties enable return sstable properties in each TableInfo.
+// WithProperties enable return sstable properties in each TableInfo, along
+// with the summaries of their block properties and compression derived from
+// them.
 //
 // NOTE: if most of the sstable properties need to be read from disk,
 // this options may make method `SSTables` quite slow.
@@ -1848,6 +2763,9 @@ func WithProperties() SSTablesOption {
 	}
 }
 
+// TableStats exports the manifest.TableStats type.
+type TableStats = manifest.TableStats
+
 // SSTableInfo export manifest.TableInfo with sstable.Properties
 type SSTableInfo struct {
 	manifest.TableInfo
@@ -1857,10 +2775,56 @@ type SSTableInfo struct {
 	// backs the sstable associated with this SSTableInfo. If Virtual is false,
 	// then BackingSSTNum == FileNum.
 	BackingSSTNum base.FileNum
+	// Shared indicates whether the backing sstable is on shared storage.
+	Shared bool
+	// Foreign indicates whether the backing sstable is on shared storage and
+	// was created by another DB instance.
+	Foreign bool
+
+	// Stats is the table stats of this table, which include the number of
+	// entries and deletions, and estimates of the disk space reclaimable by
+	// compacting the deletions. It is nil if the stats have not been loaded
+	// yet; see EventListener.TableStatsLoaded.
+	Stats *TableStats
 
 	// Properties is the sstable properties of this table. If Virtual is true,
 	// then the Properties are associated with the backing sst.
 	Properties *sstable.Properties
+
+	// BlockProperties holds the table-level properties recorded by the block
+	// property collectors of Options.BlockPropertyCollectors which were used
+	// to write the table. Like Properties, it's only populated by
+	// WithProperties.
+	BlockProperties []BlockPropertySummary
+
+	// Compression summarizes the compression of the table. Like Properties,
+	// it's only populated by WithProperties.
+	Compression *SSTableCompression
+}
+
+// BlockPropertySummary exports the sstable.BlockPropertySummary type.
+type BlockPropertySummary = sstable.BlockPropertySummary
+
+// SSTableCompression summarizes the compression of an sstable, as recorded in
+// its properties.
+type SSTableCompression struct {
+	// Algorithm is the name of the compression algorithm of the table.
+	Algorithm string
+	// RawSize is the size of the internal keys and values of the table, before
+	// compression and excluding the overhead of the block format.
+	RawSize uint64
+	// StoredSize is the size of the data and value blocks of the table, as
+	// stored.
+	StoredSize uint64
+}
+
+// Ratio returns the compression ratio of the table, RawSize/StoredSize, or
+// zero if the table stores no data.
+func (c *SSTableCompression) Ratio() float64 {
+	if c.StoredSize == 0 {
+		return 0
+	}
+	return float64(c.RawSize) / float64(c.StoredSize)
 }
 
 // SSTables retrieves the current sstables. The returned slice is indexed by
@@ -1902,9 +2866,29 @@ func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
 					return nil, err
 				}
 				destTables[j].Properties = p
+				destTables[j].BlockProperties, err = sstable.SummarizeBlockProperties(
+					p, d.opts.BlockPropertyCollectors)
+				if err != nil {
+					return nil, err
+				}
+				destTables[j].Compression = &SSTableCompression{
+					Algorithm:  p.CompressionName,
+					RawSize:    p.RawKeySize + p.RawValueSize,
+					StoredSize: p.DataSize + p.ValueBlocksSize,
+				}
 			}
 			destTables[j].Virtual = m.Virtual
 			destTables[j].BackingSSTNum = m.FileBacking.DiskFileNum.FileNum()
+			meta, err := d.objProvider.Lookup(fileTypeTable, m.FileBacking.DiskFileNum)
+			if err != nil {
+				return nil, err
+			}
+			destTables[j].Shared = meta.IsShared()
+			destTables[j].Foreign = d.objProvider.IsForeign(meta)
+			if m.StatsValid() {
+				stats := m.Stats
+				destTables[j].Stats = &stats
+			}
 			j++
 		}
 		destLevels[i] = destTables[:j]
@@ -1927,11 +2911,42 @@ func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
 //   - There may also exist WAL entries for unflushed keys in this range. This
 //     estimation currently excludes space used for the range in the WAL.
 func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
+	usage, err := d.EstimateDiskUsageByBackingType(start, end)
+	if err != nil {
+		return 0, err
+	}
+	return usage.Total(), nil
+}
+
+// DiskUsageByBackingType is an estimate of the space used by a key range,
+// broken down by the kind of storage backing the sstables.
+type DiskUsageByBackingType struct {
+	// LocalSize is the space used by sstables stored on the local filesystem.
+	LocalSize uint64
+	// SharedSize is the space used by sstables stored on shared storage.
+	SharedSize uint64
+	// Levels breaks down the space used by the key range per level of the LSM.
+	Levels [numLevels]struct {
+		LocalSize  uint64
+		SharedSize uint64
+	}
+}
+
+// Total returns the total space used by the key range.
+func (u *DiskUsageByBackingType) Total() uint64 {
+	return u.LocalSize + u.SharedSize
+}
+
+// EstimateDiskUsageByBackingType is like EstimateDiskUsage, but breaks down the
+// estimated space used for storing the range `[start, end]` by level and by
+// whether the sstables are stored locally or on shared storage.
+func (d *DB) EstimateDiskUsageByBackingType(start, end []byte) (DiskUsageByBackingType, error) {
+	var usage DiskUsageByBackingType
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
 	if d.opts.Comparer.Compare(start, end) > 0 {
-		return 0, errors.New("invalid key-range specified (start > end)")
+		return usage, errors.New("invalid key-range specified (start > end)")
 	}
 
 	// Grab and reference the current readState. This prevents the underlying
@@ -1940,7 +2955,6 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 	readState := d.loadReadState()
 	defer readState.unref()
 
-	var totalSize uint64
 	for level, files := range readState.current.Levels {
 		iter := files.Iter()
 		if level > 0 {
@@ -1951,14 +2965,14 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 			iter = overlaps.Iter()
 		}
 		for file := iter.First(); file != nil; file = iter.Next() {
+			var size uint64
 			if d.opts.Comparer.Compare(start, file.Smallest.UserKey) <= 0 &&
 				d.opts.Comparer.Compare(file.Largest.UserKey, end) <= 0 {
 				// The range fully contains the file, so skip looking it up in
 				// table cache/looking at its indexes, and add the full file size.
-				totalSize += file.Size
+				size = file.Size
 			} else if d.opts.Comparer.Compare(file.Smallest.UserKey, end) <= 0 &&
 				d.opts.Comparer.Compare(start, file.Largest.UserKey) <= 0 {
-				var size uint64
 				var err error
 				if file.Virtual {
 					err = d.tableCache.withVirtualReader(
@@ -1978,13 +2992,147 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 					)
 				}
 				if err != nil {
-					return 0, err
+					return DiskUsageByBackingType{}, err
 				}
-				totalSize += size
+			} else {
+				continue
+			}
+			meta, err := d.objProvider.Lookup(fileTypeTable, file.FileBacking.DiskFileNum)
+			if err != nil {
+				return DiskUsageByBackingType{}, err
+			}
+			if meta.IsShared() {
+				usage.SharedSize += size
+				usage.Levels[level].SharedSize += size
+			} else {
+				usage.LocalSize += size
+				usage.Levels[level].LocalSize += size
 			}
 		}
 	}
-	return totalSize, nil
+	return usage, nil
+}
+
+// DeleteRangeImpact is an estimate of the data that a DeleteRange of a key
+// range would make obsolete. See DB.EstimateDeleteRangeImpact.
+type DeleteRangeImpact struct {
+	// Keys is the approximate number of keys within the key range, including
+	// the older versions of keys and the tombstones that have not yet been
+	// compacted away.
+	Keys uint64
+	// Bytes is the approximate space used by the keys within the key range.
+	Bytes uint64
+	// ContainedTables is the number of sstables entirely within the key range,
+	// which can be dropped without being rewritten, and ContainedBytes their
+	// total size.
+	ContainedTables int
+	ContainedBytes  uint64
+	// PartialTables is the number of sstables that overlap the key range
+	// without being contained in it, whose keys within the key range can only
+	// be dropped by rewriting them. An Excise of the key range instead splits
+	// them into virtual sstables, which avoids rewriting them.
+	PartialTables int
+}
+
+// EstimateDeleteRangeImpact returns an estimate of the keys and bytes that a
+// DeleteRange of the key range [start, end) would make obsolete, to help
+// decide between a DeleteRange and an Excise of the key range.
+//
+// The estimate only accounts for the sstables of the current version, not
+// the memtables. The bytes of the sstables that partially overlap the key
+// range are estimated from the data blocks overlapping it, as
+// EstimateDiskUsage does, and their keys are extrapolated from the number of
+// entries in the sstable properties in proportion to those bytes.
+func (d *DB) EstimateDeleteRangeImpact(start, end []byte) (DeleteRangeImpact, error) {
+	var impact DeleteRangeImpact
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	cmp := d.opts.Comparer.Compare
+	if cmp(start, end) >= 0 {
+		return impact, errors.New("invalid key-range specified (start >= end)")
+	}
+
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	for level, files := range readState.current.Levels {
+		iter := files.Iter()
+		if level > 0 {
+			overlaps := readState.current.Overlaps(level, cmp, start, end, true /* exclusiveEnd */)
+			iter = overlaps.Iter()
+		}
+		for file := iter.First(); file != nil; file = iter.Next() {
+			if cmp(file.Smallest.UserKey, end) >= 0 || cmp(start, file.Largest.UserKey) > 0 {
+				continue
+			}
+			entries, err := d.estimateTableEntries(file)
+			if err != nil {
+				return DeleteRangeImpact{}, err
+			}
+			if cmp(start, file.Smallest.UserKey) <= 0 &&
+				(cmp(file.Largest.UserKey, end) < 0 ||
+					(cmp(file.Largest.UserKey, end) == 0 && file.Largest.IsExclusiveSentinel())) {
+				impact.Keys += entries
+				impact.Bytes += file.Size
+				impact.ContainedTables++
+				impact.ContainedBytes += file.Size
+				continue
+			}
+			var size uint64
+			if file.Virtual {
+				err = d.tableCache.withVirtualReader(
+					file.VirtualMeta(),
+					func(r sstable.VirtualReader) (err error) {
+						size, err = r.EstimateDiskUsage(start, end)
+						return err
+					},
+				)
+			} else {
+				err = d.tableCache.withReader(
+					file.PhysicalMeta(),
+					func(r *sstable.Reader) (err error) {
+						size, err = r.EstimateDiskUsage(start, end)
+						return err
+					},
+				)
+			}
+			if err != nil {
+				return DeleteRangeImpact{}, err
+			}
+			if size > file.Size {
+				size = file.Size
+			}
+			if file.Size > 0 {
+				impact.Keys += uint64(float64(entries) * float64(size) / float64(file.Size))
+			}
+			impact.Bytes += size
+			impact.PartialTables++
+		}
+	}
+	return impact, nil
+}
+
+// estimateTableEntries returns the number of entries in the given table, from
+// its table stats if they're loaded, or else from its properties.
+func (d *DB) estimateTableEntries(file *fileMetadata) (uint64, error) {
+	if file.StatsValid() {
+		return file.Stats.NumEntries, nil
+	}
+	var entries uint64
+	var err error
+	if file.Virtual {
+		err = d.tableCache.withVirtualReader(file.VirtualMeta(), func(r sstable.VirtualReader) error {
+			entries = r.Properties.NumEntries
+			return nil
+		})
+	} else {
+		err = d.tableCache.withReader(file.PhysicalMeta(), func(r *sstable.Reader) error {
+			entries = r.Properties.NumEntries
+			return nil
+		})
+	}
+	return entries, err
 }
 
 func (d *DB) walPreallocateSize() int {
@@ -2001,6 +3149,17 @@ func (d *DB) walPreallocateSize() int {
 	return size
 }
 
+// walSyncingFileOptions returns the options with which WAL files are synced.
+// DB.mu must be held, as DB.SetOptions may change the options they're derived
+// from.
+func (d *DB) walSyncingFileOptions() vfs.SyncingFileOptions {
+	return vfs.SyncingFileOptions{
+		NoSyncOnClose:   d.opts.NoSyncOnClose,
+		BytesPerSync:    d.opts.WALBytesPerSync,
+		PreallocateSize: d.walPreallocateSize(),
+	}
+}
+
 func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushableEntry) {
 	size := d.mu.mem.nextSize
 	if d.mu.mem.nextSize < d.opts.MemTableSize {
@@ -2061,19 +3220,64 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 	}
 
 	force := b == nil || b.flushable != nil
+	// stalled is set while writes are stalled, by stallCause since
+	// stallStart.
 	stalled := false
+	var stallCause WriteStallCause
+	var stallStart time.Time
+	endStall := func() {
+		stalled = false
+		d.opts.EventListener.WriteStallEnd(WriteStallEndInfo{
+			Cause:    stallCause,
+			Duration: time.Since(stallStart),
+		})
+	}
+	// stall waits for the cause of the write stall to be resolved. A write
+	// stall is reported as beginning, unless it's ongoing with the same cause.
+	stall := func(cause WriteStallCause) {
+		if stalled && stallCause != cause {
+			endStall()
+		}
+		if !stalled {
+			stalled, stallCause, stallStart = true, cause, time.Now()
+			d.mu.writeStallCount++
+			info := WriteStallBeginInfo{
+				Reason:         cause.String(),
+				Cause:          cause,
+				MemTableCount:  len(d.mu.mem.queue),
+				L0FileCount:    d.mu.versions.currentVersion().Levels[0].Len(),
+				L0Sublevels:    d.mu.versions.currentVersion().L0Sublevels.ReadAmplification(),
+				CompactionDebt: d.mu.versions.picker.estimatedCompactionDebt(0),
+			}
+			for i := range d.mu.mem.queue {
+				info.MemTableSize += d.mu.mem.queue[i].totalBytes()
+			}
+			d.opts.EventListener.WriteStallBegin(info)
+		}
+		now := time.Now()
+		d.mu.compact.cond.Wait()
+		d.mu.writeStallDuration += time.Since(now)
+		if b != nil {
+			switch cause {
+			case WriteStallMemTableCount:
+				b.commitStats.MemTableWriteStallDuration += time.Since(now)
+			case WriteStallL0Sublevels:
+				b.commitStats.L0ReadAmpWriteStallDuration += time.Since(now)
+			}
+		}
+	}
 	for {
 		if b != nil && b.flushable == nil {
 			err := d.mu.mem.mutable.prepare(b)
 			if err != arenaskl.ErrArenaFull {
 				if stalled {
-					d.opts.EventListener.WriteStallEnd()
+					endStall()
 				}
 				return err
 			}
 		} else if !force {
 			if stalled {
-				d.opts.EventListener.WriteStallEnd()
+				endStall()
 			}
 			return nil
 		}
@@ -2086,36 +3290,19 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			if size >= uint64(d.opts.MemTableStopWritesThreshold)*uint64(d.opts.MemTableSize) {
 				// We have filled up the current memtable, but already queued memtables
 				// are still flushing, so we wait.
-				if !stalled {
-					stalled = true
-					d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
-						Reason: "memtable count limit reached",
-					})
-				}
-				now := time.Now()
-				d.mu.compact.cond.Wait()
-				if b != nil {
-					b.commitStats.MemTableWriteStallDuration += time.Since(now)
-				}
+				stall(WriteStallMemTableCount)
 				continue
 			}
 		}
 		l0ReadAmp := d.mu.versions.currentVersion().L0Sublevels.ReadAmplification()
 		if l0ReadAmp >= d.opts.L0StopWritesThreshold {
 			// There are too many level-0 files, so we wait.
-			if !stalled {
-				stalled = true
-				d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
-					Reason: "L0 file count limit exceeded",
-				})
-			}
-			now := time.Now()
-			d.mu.compact.cond.Wait()
-			if b != nil {
-				b.commitStats.L0ReadAmpWriteStallDuration += time.Since(now)
-			}
+			stall(WriteStallL0Sublevels)
 			continue
 		}
+		if stalled {
+			endStall()
+		}
 
 		var newLogNum base.FileNum
 		var prevLogSize uint64
@@ -2218,6 +3405,9 @@ func (d *DB) recycleWAL() (newLogNum FileNum, prevLogSize uint64) {
 	if d.mu.log.queue[len(d.mu.log.queue)-1].fileSize < prevLogSize {
 		d.mu.log.queue[len(d.mu.log.queue)-1].fileSize = prevLogSize
 	}
+	// Options.WALBytesPerSync and Options.MemTableSize may be changed by
+	// DB.SetOptions while DB.mu isn't held.
+	walOpts := d.walSyncingFileOptions()
 	d.mu.Unlock()
 
 	var err error
@@ -2280,14 +3470,15 @@ func (d *DB) recycleWAL() (newLogNum FileNum, prevLogSize uint64) {
 		err = d.walDir.Sync()
 	}
 
+	var newLogWriter io.Writer
 	if err != nil && newLogFile != nil {
 		newLogFile.Close()
 	} else if err == nil {
-		newLogFile = vfs.NewSyncingFile(newLogFile, vfs.SyncingFileOptions{
-			NoSyncOnClose:   d.opts.NoSyncOnClose,
-			BytesPerSync:    d.opts.WALBytesPerSync,
-			PreallocateSize: d.walPreallocateSize(),
-		})
+		newLogFile = vfs.NewSyncingFile(newLogFile, walOpts)
+		newLogWriter, err = d.createWALStripes(newLogFile, newLogNum.DiskFileNum(), walOpts)
+		if err != nil {
+			newLogFile.Close()
+		}
 	}
 
 	if recycleOK {
@@ -2316,11 +3507,17 @@ func (d *DB) recycleWAL() (newLogNum FileNum, prevLogSize uint64) {
 	}
 
 	d.mu.log.queue = append(d.mu.log.queue, fileInfo{fileNum: newLogNum.DiskFileNum(), fileSize: newLogSize})
-	d.mu.log.LogWriter = record.NewLogWriter(newLogFile, newLogNum, record.LogWriterConfig{
+	d.mu.log.LogWriter = record.NewLogWriter(newLogWriter, newLogNum, record.LogWriterConfig{
 		WALFsyncLatency:    d.mu.log.metrics.fsyncLatency,
 		WALMinSyncInterval: d.opts.WALMinSyncInterval,
 		QueueSemChan:       d.commit.logSyncQSem,
 	})
+	if err := d.writeWALStripeHeader(); err != nil {
+		panic(err)
+	}
+	if err := d.relogPreparedLocked(); err != nil {
+		panic(err)
+	}
 	if d.mu.log.registerLogWriterForTesting != nil {
 		d.mu.log.registerLogWriterForTesting(d.mu.log.LogWriter)
 	}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -21,7 +22,9 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/objstorage/shared"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -1254,9 +1257,63 @@ func TestCloseCleanerRace(t *testing.T) {
 	}
 }
 
+func TestCloseContext(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, DisableAutomaticCompactions: true}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), value, nil))
+		if i%100 == 99 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	// The manual compaction is paced to take ~10s.
+	started := make(chan struct{})
+	var once sync.Once
+	compactErr := make(chan error, 1)
+	go func() {
+		compactErr <- d.CompactWithOptions([]byte("0"), []byte("9"), CompactOptions{
+			BytesPerSecond:   100 << 10,
+			ProgressInterval: time.Millisecond,
+			OnProgress: func(CompactionProgress) {
+				once.Do(func() { close(started) })
+			},
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	report, err := d.CloseContext(ctx)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.True(t, report.Aborted)
+	require.Equal(t, 1, report.AbortedCompactions)
+	require.True(t, errors.Is(<-compactErr, ErrCompactionAborted))
+
+	// The aborted compaction's inputs remain in the LSM.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	iter := d.NewIter(nil)
+	n := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 1000, n)
+	report, err = d.CloseContext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, CloseReport{}, report)
+}
+
 func TestSSTables(t *testing.T) {
 	d, err := Open("", &Options{
-		FS: vfs.NewMem(),
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
 	})
 	require.NoError(t, err)
 	defer func() {
@@ -1288,6 +1345,73 @@ func TestSSTables(t *testing.T) {
 			require.NotNil(t, info.Properties)
 		}
 	}
+
+	// Once loaded, the table stats are returned.
+	require.NoError(t, d.Delete([]byte("hello"), nil))
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	tableInfos, err = d.SSTables()
+	require.NoError(t, err)
+	var numDeletions uint64
+	for _, levelTables := range tableInfos {
+		for _, info := range levelTables {
+			require.NotNil(t, info.Stats)
+			require.Equal(t, uint64(1), info.Stats.NumEntries)
+			numDeletions += info.Stats.NumDeletions
+			require.False(t, info.Shared)
+			require.False(t, info.Foreign)
+		}
+	}
+	require.Equal(t, uint64(1), numDeletions)
+}
+
+func TestSSTablesBlockPropertiesAndCompression(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer:           testkeys.Comparer,
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatNewest,
+		BlockPropertyCollectors: []func() BlockPropertyCollector{
+			sstable.NewTestKeysBlockPropertyCollector,
+		},
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	value := bytes.Repeat([]byte("v"), 1000)
+	require.NoError(t, d.Set([]byte("a@3"), value, nil))
+	require.NoError(t, d.Set([]byte("b@7"), value, nil))
+	require.NoError(t, d.Flush())
+
+	// Without WithProperties, neither the block properties nor the
+	// compression are populated.
+	tableInfos, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tableInfos[0], 1)
+	require.Nil(t, tableInfos[0][0].BlockProperties)
+	require.Nil(t, tableInfos[0][0].Compression)
+
+	tableInfos, err = d.SSTables(WithProperties())
+	require.NoError(t, err)
+	require.Len(t, tableInfos[0], 1)
+	info := tableInfos[0][0]
+	require.Len(t, info.BlockProperties, 1)
+	bp := info.BlockProperties[0]
+	require.Equal(t, "pebble.internal.testkeys.suffixes", bp.Name)
+	require.True(t, bp.Interval)
+	require.Equal(t, uint64(3), bp.Lower)
+	require.Equal(t, uint64(8), bp.Upper)
+
+	c := info.Compression
+	require.NotNil(t, c)
+	require.Equal(t, "Snappy", c.Algorithm)
+	// The raw size of the keys includes their 8-byte trailers.
+	require.Equal(t, uint64(2*(len("a@3")+8)+2*len(value)), c.RawSize)
+	// The repetitive values compress well.
+	require.Less(t, c.StoredSize, c.RawSize)
+	require.Greater(t, c.Ratio(), 1.0)
 }
 
 type testTracer struct {
@@ -1337,7 +1461,7 @@ func TestTracing(t *testing.T) {
 	_, closer, err := d.Get([]byte("hello"))
 	require.NoError(t, err)
 	closer.Close()
-	readerInitTraceString := "reading 37 bytes took 5ms\nreading 628 bytes took 5ms\n"
+	readerInitTraceString := "reading 37 bytes took 5ms\nreading 715 bytes took 5ms\n"
 	iterTraceString := "reading 27 bytes took 5ms\nreading 29 bytes took 5ms\n"
 	require.Equal(t, readerInitTraceString+iterTraceString, tracer.buf.String())
 
@@ -1849,3 +1973,271 @@ func verifyGetNotFound(t *testing.T, r Reader, key []byte) {
 		t.Fatalf("expected nil, but got %s", val)
 	}
 }
+
+type testEncryptionKeyManager struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (m *testEncryptionKeyManager) NewDataKey() (string, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := fmt.Sprintf("key-%d", len(m.keys))
+	key := make([]byte, 16)
+	rand.Read(key)
+	m.keys[id] = key
+	return id, key, nil
+}
+
+func (m *testEncryptionKeyManager) DataKey(id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key, ok := m.keys[id]; ok {
+		return key, nil
+	}
+	return nil, errors.Newf("unknown key %q", id)
+}
+
+func TestSSTableEncryption(t *testing.T) {
+	mem := vfs.NewMem()
+	km := &testEncryptionKeyManager{keys: map[string][]byte{}}
+	// Encryption requires FormatTableFeatures.
+	opts := &Options{FS: mem, FormatMajorVersion: FormatTableFeatures - 1}
+	opts.Experimental.EncryptionKeyManager = km
+	_, err := Open("", opts)
+	require.Error(t, err)
+
+	opts.FormatMajorVersion = FormatTableFeatures
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("secret-key"), []byte("secret-value"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+	require.Len(t, km.keys, 1)
+
+	// The sstable does not contain the plaintext key or value.
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	var tables int
+	for _, name := range ls {
+		if ft, _, ok := base.ParseFilename(mem, name); !ok || ft != base.FileTypeTable {
+			continue
+		}
+		tables++
+		f, err := mem.Open(name)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.False(t, bytes.Contains(data, []byte("secret")))
+	}
+	require.Equal(t, 1, tables)
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	v, closer, err := d.Get([]byte("secret-key"))
+	require.NoError(t, err)
+	require.Equal(t, "secret-value", string(v))
+	require.NoError(t, closer.Close())
+}
+
+func TestSSTableValueChecksums(t *testing.T) {
+	// Value checksums require FormatTableFeatures.
+	opts := &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatTableFeatures - 1}
+	opts.Experimental.ValueChecksums = true
+	_, err := Open("", opts)
+	require.Error(t, err)
+
+	opts.FormatMajorVersion = FormatNewest
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a1"), nil))
+	require.NoError(t, d.Merge([]byte("b"), []byte("b1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Merge([]byte("b"), []byte("b2"), nil))
+	require.NoError(t, d.Delete([]byte("c"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	var n int
+	for _, level := range tables {
+		for _, table := range level {
+			require.True(t, table.Properties.ValueChecksums)
+			n++
+		}
+	}
+	require.Equal(t, 1, n)
+
+	for k, want := range map[string]string{"a": "a1", "b": "b1b2"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, want, string(v))
+		require.NoError(t, closer.Close())
+	}
+}
+
+func TestEstimateDiskUsageByBackingType(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		FormatMajorVersion:          FormatRangeKeys,
+		Logger:                      panicLogger{},
+	}
+	// Write a table to L6 before configuring shared storage, so that it's
+	// stored locally, and then a table to L0 stored on shared storage.
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false))
+	require.NoError(t, d.Close())
+
+	opts.Experimental.SharedStorage = shared.NewInMem()
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+
+	usage, err := d.EstimateDiskUsageByBackingType([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	m := d.Metrics()
+	require.Equal(t, uint64(m.Levels[6].Size), usage.LocalSize)
+	require.Equal(t, uint64(m.Levels[0].Size), usage.SharedSize)
+	require.Equal(t, usage.LocalSize, usage.Levels[6].LocalSize)
+	require.Equal(t, usage.SharedSize, usage.Levels[0].SharedSize)
+	require.Zero(t, usage.Levels[6].SharedSize)
+	require.Zero(t, usage.Levels[0].LocalSize)
+	total, err := d.EstimateDiskUsage([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	require.Equal(t, usage.Total(), total)
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.True(t, tables[0][0].Shared)
+	require.False(t, tables[0][0].Foreign)
+	require.False(t, tables[6][0].Shared)
+
+	// Only the L6 table overlaps the range.
+	usage, err = d.EstimateDiskUsageByBackingType([]byte("c"), []byte("z"))
+	require.NoError(t, err)
+	require.NotZero(t, usage.LocalSize)
+	require.Zero(t, usage.SharedSize)
+
+	_, err = d.EstimateDiskUsageByBackingType([]byte("z"), []byte("a"))
+	require.Error(t, err)
+}
+
+func TestSecondaryCache(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{
+		FS:                          fs,
+		DisableAutomaticCompactions: true,
+		FormatMajorVersion:          FormatRangeKeys,
+		Levels:                      []LevelOptions{{BlockSize: 512}},
+		Logger:                      panicLogger{},
+	}
+	opts.Experimental.SharedStorage = shared.NewInMem()
+	opts.Experimental.SecondaryCacheSizeBytes = int64(2*runtime.GOMAXPROCS(0)) << 20
+	opts.Experimental.SecondaryCacheDir = "secondary-cache"
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.SetCreatorID(1))
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%04d", i)), bytes.Repeat([]byte("v"), 100), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	scan := func() {
+		iter := d.NewIter(nil)
+		n := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, 1000, n)
+	}
+
+	// The blocks read from shared storage are written to the secondary cache.
+	scan()
+	m := d.Metrics().SecondaryCache
+	require.Less(t, int64(0), m.Misses)
+	require.Less(t, int64(0), m.Count)
+	require.NoError(t, d.Close())
+
+	// The secondary cache persists across restarts, so the blocks missing from
+	// the new block cache are read from the secondary cache.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	scan()
+	m = d.Metrics().SecondaryCache
+	require.Less(t, int64(0), m.Hits)
+	require.Zero(t, m.Misses)
+	ls, err := fs.List("secondary-cache")
+	require.NoError(t, err)
+	require.Len(t, ls, 2*runtime.GOMAXPROCS(0))
+}
+
+func TestEstimateDeleteRangeImpact(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Levels:                      []LevelOptions{{BlockSize: 512}},
+		Logger:                      panicLogger{},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write a table with 1000 keys to L6, and a table with 100 keys to L0.
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%04d", i)), value, nil))
+	}
+	require.NoError(t, d.Compact([]byte("k"), []byte("l"), false /* parallelize */))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("x%04d", i)), value, nil))
+	}
+	require.NoError(t, d.Flush())
+	m := d.Metrics()
+
+	// Both tables are contained in the range.
+	impact, err := d.EstimateDeleteRangeImpact([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	require.Equal(t, DeleteRangeImpact{
+		Keys:            1100,
+		Bytes:           uint64(m.Levels[0].Size + m.Levels[6].Size),
+		ContainedTables: 2,
+		ContainedBytes:  uint64(m.Levels[0].Size + m.Levels[6].Size),
+	}, impact)
+
+	// The range ends at the exclusive upper bound of the L0 table, which is
+	// therefore contained, and doesn't overlap the L6 table.
+	impact, err = d.EstimateDeleteRangeImpact([]byte("x"), []byte("x0099\x00"))
+	require.NoError(t, err)
+	require.Equal(t, 1, impact.ContainedTables)
+	require.Zero(t, impact.PartialTables)
+	require.EqualValues(t, 100, impact.Keys)
+
+	// The range covers half of the L6 table.
+	impact, err = d.EstimateDeleteRangeImpact([]byte("k0000"), []byte("k0500"))
+	require.NoError(t, err)
+	require.Zero(t, impact.ContainedTables)
+	require.Equal(t, 1, impact.PartialTables)
+	require.InDelta(t, 500, float64(impact.Keys), 100)
+	require.InDelta(t, m.Levels[6].Size/2, float64(impact.Bytes), float64(m.Levels[6].Size)/5)
+
+	// Nothing overlaps the range.
+	impact, err = d.EstimateDeleteRangeImpact([]byte("l"), []byte("m"))
+	require.NoError(t, err)
+	require.Equal(t, DeleteRangeImpact{}, impact)
+
+	_, err = d.EstimateDeleteRangeImpact([]byte("z"), []byte("a"))
+	require.Error(t, err)
+}
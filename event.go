@@ -55,6 +55,37 @@ func (i LevelInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(humanize.Uint64(tablesTotalSize(i.Tables))))
 }
 
+// CheckpointCopyInfo contains the info for a checkpoint file copy event,
+// which reports the progress of a checkpoint that copies files because hard
+// links are impossible or because WithCopyFiles was specified.
+type CheckpointCopyInfo struct {
+	// Path is the path of the copied file, and DestPath the path of the copy.
+	Path     string
+	DestPath string
+	// Size is the size of the copied file.
+	Size int64
+	// Checksum is the CRC-32C of the file, which was verified against the
+	// copy.
+	Checksum uint32
+	// FilesCopied and BytesCopied are the number of files, and the number of
+	// bytes, copied by the checkpoint so far, including this file.
+	FilesCopied int
+	BytesCopied int64
+	// Duration is the time taken to copy and verify the file.
+	Duration time.Duration
+}
+
+func (i CheckpointCopyInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i CheckpointCopyInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("checkpoint copied %s to %s (%s, crc %08x) in %.1fs; %d files (%s) copied",
+		i.Path, i.DestPath, humanize.IEC.Int64(i.Size), redact.Safe(i.Checksum),
+		redact.Safe(i.Duration.Seconds()), redact.Safe(i.FilesCopied), humanize.IEC.Int64(i.BytesCopied))
+}
+
 // CompactionInfo contains the info for a compaction event.
 type CompactionInfo struct {
 	// JobID is the ID of the compaction job.
@@ -117,10 +148,89 @@ func (i levelInfos) SafeFormat(w redact.SafePrinter, _ rune) {
 	}
 }
 
+// CompactionVerificationInfo contains the info for a compaction verification
+// failure event, which reports a kind of point keys whose count or checksum
+// differs between the keys read by a flush or compaction and the keys it
+// wrote and dropped (see Options.Experimental.VerifyCompactions).
+type CompactionVerificationInfo struct {
+	// JobID is the ID of the flush or compaction job.
+	JobID int
+	// Reason is the reason for the flush or compaction.
+	Reason string
+	// Kind is the kind of the keys, as read by the flush or compaction. Keys
+	// whose kind is changed, eg, a SET written as a SETWITHDEL, are accounted
+	// for by their original kind.
+	Kind InternalKeyKind
+	// Read, Written and Dropped are the numbers of keys read, written and
+	// dropped by the flush or compaction, with Dropped broken down by reason.
+	Read    uint64
+	Written uint64
+	Dropped map[CompactionDropReason]uint64
+	// ReadChecksum, WrittenChecksum and DroppedChecksum are the
+	// order-independent checksums of the keys read, written and dropped. The
+	// checksum of the keys read is the sum of the two others.
+	ReadChecksum    uint64
+	WrittenChecksum uint64
+	DroppedChecksum uint64
+}
+
+func (i CompactionVerificationInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i CompactionVerificationInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	var dropped uint64
+	for _, n := range i.Dropped {
+		dropped += n
+	}
+	w.Printf("[JOB %d] compaction(%s) verification failed: %s keys: %d read, %d written, %d dropped (",
+		redact.Safe(i.JobID), redact.SafeString(i.Reason), redact.Safe(i.Kind),
+		redact.Safe(i.Read), redact.Safe(i.Written), redact.Safe(dropped))
+	sep := ""
+	for r := CompactionDropReason(0); r < numCompactionDropReasons; r++ {
+		if n := i.Dropped[r]; n > 0 {
+			w.Printf("%s%s: %d", redact.SafeString(sep), redact.Safe(r), redact.Safe(n))
+			sep = ", "
+		}
+	}
+	w.Printf("); checksums: %016x read, %016x written, %016x dropped",
+		redact.Safe(i.ReadChecksum), redact.Safe(i.WrittenChecksum), redact.Safe(i.DroppedChecksum))
+}
+
 // DiskSlowInfo contains the info for a disk slowness event when writing to a
 // file.
 type DiskSlowInfo = vfs.DiskSlowInfo
 
+// ConsistencyCheckInfo contains information on the result of the validation
+// of the sstables of the LSM performed by Open. See
+// Options.Experimental.ConsistencyCheck.
+type ConsistencyCheckInfo struct {
+	Level ConsistencyCheckLevel
+	// Tables is the number of sstables validated.
+	Tables int
+	// Duration is the time spent validating the sstables.
+	Duration time.Duration
+	// Err is the error encountered while validating the sstables, if any,
+	// which is also returned by Open.
+	Err error
+}
+
+func (i ConsistencyCheckInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i ConsistencyCheckInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	if i.Err != nil {
+		w.Printf("%s consistency check of %d tables failed in %.1fs: %s",
+			redact.Safe(i.Level), redact.Safe(i.Tables), redact.Safe(i.Duration.Seconds()), i.Err)
+		return
+	}
+	w.Printf("%s consistency check of %d tables completed in %.1fs",
+		redact.Safe(i.Level), redact.Safe(i.Tables), redact.Safe(i.Duration.Seconds()))
+}
+
 // FlushInfo contains the info for a flush event.
 type FlushInfo struct {
 	// JobID is the ID of the flush job.
@@ -363,6 +473,10 @@ func (i TableStatsInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 type TableValidatedInfo struct {
 	JobID int
 	Meta  *fileMetadata
+	// Err is the error encountered while validating the table, if any. Only
+	// the validation of tables written by flushes and compactions reports
+	// errors; see Options.Experimental.ValidateOnWriteSampleRate.
+	Err error
 }
 
 func (i TableValidatedInfo) String() string {
@@ -371,6 +485,10 @@ func (i TableValidatedInfo) String() string {
 
 // SafeFormat implements redact.SafeFormatter.
 func (i TableValidatedInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	if i.Err != nil {
+		w.Printf("[JOB %d] table validation error %s: %s", redact.Safe(i.JobID), i.Meta, i.Err)
+		return
+	}
 	w.Printf("[JOB %d] validated table: %s", redact.Safe(i.JobID), i.Meta)
 }
 
@@ -429,9 +547,83 @@ func (i WALDeleteInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] WAL deleted %s", redact.Safe(i.JobID), redact.Safe(i.FileNum))
 }
 
+// WALSalvageInfo contains the info for a WAL salvage event, which reports a
+// corruption skipped while replaying a WAL because
+// Options.Experimental.SalvageWAL is set.
+type WALSalvageInfo struct {
+	// JobID is the ID of the job replaying the WAL.
+	JobID   int
+	Path    string
+	FileNum FileNum
+	// Offset is the offset in the WAL of the first corrupt chunk, and Err the
+	// error reading it.
+	Offset int64
+	Err    error
+	// StartSeqNum and EndSeqNum bound the sequence numbers of the batches
+	// lost to the corruption, [StartSeqNum, EndSeqNum). StartSeqNum is zero if
+	// the corruption precedes the first intact batch of the first WAL
+	// replayed, as the sequence numbers preceding EndSeqNum are then unknown.
+	StartSeqNum, EndSeqNum uint64
+}
+
+func (i WALSalvageInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i WALSalvageInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("[JOB %d] WAL %s salvaged past corruption at offset %d: lost seqnums [%d,%d): %s",
+		redact.Safe(i.JobID), redact.Safe(i.FileNum), redact.Safe(i.Offset),
+		redact.Safe(i.StartSeqNum), redact.Safe(i.EndSeqNum), i.Err)
+}
+
+// WriteStallCause is the condition causing a write stall.
+type WriteStallCause int8
+
+const (
+	// WriteStallMemTableCount is the cause of the write stalls while the
+	// queued memtables reach Options.MemTableStopWritesThreshold.
+	WriteStallMemTableCount WriteStallCause = iota
+	// WriteStallL0Sublevels is the cause of the write stalls while the read
+	// amplification of L0, ie, the number of its sublevels, reaches
+	// Options.L0StopWritesThreshold.
+	WriteStallL0Sublevels
+)
+
+// String implements fmt.Stringer.
+func (c WriteStallCause) String() string {
+	switch c {
+	case WriteStallMemTableCount:
+		return "memtable count limit reached"
+	case WriteStallL0Sublevels:
+		return "L0 file count limit exceeded"
+	default:
+		return fmt.Sprintf("unknown write stall cause %d", int8(c))
+	}
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (c WriteStallCause) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Print(redact.SafeString(c.String()))
+}
+
 // WriteStallBeginInfo contains the info for a write stall begin event.
 type WriteStallBeginInfo struct {
+	// Reason is the description of the Cause.
 	Reason string
+	// Cause is the condition causing the write stall.
+	Cause WriteStallCause
+	// MemTableCount is the number of memtables, including the mutable
+	// memtable, and MemTableSize their total size.
+	MemTableCount int
+	MemTableSize  uint64
+	// L0FileCount is the number of files in L0, and L0Sublevels the number of
+	// its sublevels.
+	L0FileCount int
+	L0Sublevels int
+	// CompactionDebt is the estimated number of bytes to compact for the LSM
+	// to reach a stable state.
+	CompactionDebt uint64
 }
 
 func (i WriteStallBeginInfo) String() string {
@@ -440,7 +632,26 @@ func (i WriteStallBeginInfo) String() string {
 
 // SafeFormat implements redact.SafeFormatter.
 func (i WriteStallBeginInfo) SafeFormat(w redact.SafePrinter, _ rune) {
-	w.Printf("write stall beginning: %s", redact.Safe(i.Reason))
+	w.Printf("write stall beginning: %s (memtables: %d, %s; L0: %d files, %d sublevels; compaction debt: %s)",
+		redact.Safe(i.Reason), redact.Safe(i.MemTableCount), humanize.IEC.Uint64(i.MemTableSize),
+		redact.Safe(i.L0FileCount), redact.Safe(i.L0Sublevels), humanize.IEC.Uint64(i.CompactionDebt))
+}
+
+// WriteStallEndInfo contains the info for a write stall end event.
+type WriteStallEndInfo struct {
+	// Cause is the condition that caused the write stall.
+	Cause WriteStallCause
+	// Duration is the duration of the write stall.
+	Duration time.Duration
+}
+
+func (i WriteStallEndInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i WriteStallEndInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("write stall ending: %s after %.1fs", i.Cause, redact.Safe(i.Duration.Seconds()))
 }
 
 // EventListener contains a set of functions that will be invoked when various
@@ -453,6 +664,10 @@ type EventListener struct {
 	// operation such as flush or compaction.
 	BackgroundError func(error)
 
+	// CheckpointFileCopied is invoked after DB.Checkpoint copied a file to the
+	// checkpoint and verified the copy, in place of a hard link.
+	CheckpointFileCopied func(CheckpointCopyInfo)
+
 	// CompactionBegin is invoked after the inputs to a compaction have been
 	// determined, but before the compaction has produced any output.
 	CompactionBegin func(CompactionInfo)
@@ -461,6 +676,17 @@ type EventListener struct {
 	// has been installed.
 	CompactionEnd func(CompactionInfo)
 
+	// CompactionVerificationFailed is invoked when the keys read by a flush
+	// or compaction don't reconcile with the keys it wrote and dropped (see
+	// Options.Experimental.VerifyCompactions). It's invoked once for each key
+	// kind that doesn't reconcile, before the flush or compaction fails.
+	CompactionVerificationFailed func(CompactionVerificationInfo)
+
+	// ConsistencyChecked is invoked by Open after validating the sstables of
+	// the LSM, unless Options.Experimental.ConsistencyCheck is
+	// ConsistencyCheckNone or the DB is being created.
+	ConsistencyChecked func(ConsistencyCheckInfo)
+
 	// DiskSlow is invoked after a disk write operation on a file created with a
 	// disk health checking vfs.FS (see vfs.DefaultWithDiskHealthChecks) is
 	// observed to exceed the specified disk slowness threshold duration. DiskSlow
@@ -512,11 +738,17 @@ type EventListener struct {
 	// WALDeleted is invoked after a WAL has been deleted.
 	WALDeleted func(WALDeleteInfo)
 
+	// WALSalvaged is invoked by Open for each corruption skipped while
+	// replaying a WAL, after replaying the first intact batch following it.
+	// See Options.Experimental.SalvageWAL.
+	WALSalvaged func(WALSalvageInfo)
+
 	// WriteStallBegin is invoked when writes are intentionally delayed.
 	WriteStallBegin func(WriteStallBeginInfo)
 
-	// WriteStallEnd is invoked when delayed writes are released.
-	WriteStallEnd func()
+	// WriteStallEnd is invoked when delayed writes are released. A write stall
+	// whose cause changes ends, and a new write stall begins.
+	WriteStallEnd func(WriteStallEndInfo)
 }
 
 // EnsureDefaults ensures that background error events are logged to the
@@ -533,12 +765,21 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 			l.BackgroundError = func(error) {}
 		}
 	}
+	if l.CheckpointFileCopied == nil {
+		l.CheckpointFileCopied = func(info CheckpointCopyInfo) {}
+	}
 	if l.CompactionBegin == nil {
 		l.CompactionBegin = func(info CompactionInfo) {}
 	}
 	if l.CompactionEnd == nil {
 		l.CompactionEnd = func(info CompactionInfo) {}
 	}
+	if l.CompactionVerificationFailed == nil {
+		l.CompactionVerificationFailed = func(info CompactionVerificationInfo) {}
+	}
+	if l.ConsistencyChecked == nil {
+		l.ConsistencyChecked = func(info ConsistencyCheckInfo) {}
+	}
 	if l.DiskSlow == nil {
 		l.DiskSlow = func(info DiskSlowInfo) {}
 	}
@@ -578,11 +819,14 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.WALDeleted == nil {
 		l.WALDeleted = func(info WALDeleteInfo) {}
 	}
+	if l.WALSalvaged == nil {
+		l.WALSalvaged = func(info WALSalvageInfo) {}
+	}
 	if l.WriteStallBegin == nil {
 		l.WriteStallBegin = func(info WriteStallBeginInfo) {}
 	}
 	if l.WriteStallEnd == nil {
-		l.WriteStallEnd = func() {}
+		l.WriteStallEnd = func(info WriteStallEndInfo) {}
 	}
 }
 
@@ -597,12 +841,21 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		BackgroundError: func(err error) {
 			logger.Infof("background error: %s", err)
 		},
+		CheckpointFileCopied: func(info CheckpointCopyInfo) {
+			logger.Infof("%s", info)
+		},
 		CompactionBegin: func(info CompactionInfo) {
 			logger.Infof("%s", info)
 		},
 		CompactionEnd: func(info CompactionInfo) {
 			logger.Infof("%s", info)
 		},
+		CompactionVerificationFailed: func(info CompactionVerificationInfo) {
+			logger.Infof("%s", info)
+		},
+		ConsistencyChecked: func(info ConsistencyCheckInfo) {
+			logger.Infof("%s", info)
+		},
 		DiskSlow: func(info DiskSlowInfo) {
 			logger.Infof("%s", info)
 		},
@@ -642,11 +895,14 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		WALDeleted: func(info WALDeleteInfo) {
 			logger.Infof("%s", info)
 		},
+		WALSalvaged: func(info WALSalvageInfo) {
+			logger.Infof("%s", info)
+		},
 		WriteStallBegin: func(info WriteStallBeginInfo) {
 			logger.Infof("%s", info)
 		},
-		WriteStallEnd: func() {
-			logger.Infof("write stall ending")
+		WriteStallEnd: func(info WriteStallEndInfo) {
+			logger.Infof("%s", info)
 		},
 	}
 }
@@ -660,6 +916,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.BackgroundError(err)
 			b.BackgroundError(err)
 		},
+		CheckpointFileCopied: func(info CheckpointCopyInfo) {
+			a.CheckpointFileCopied(info)
+			b.CheckpointFileCopied(info)
+		},
 		CompactionBegin: func(info CompactionInfo) {
 			a.CompactionBegin(info)
 			b.CompactionBegin(info)
@@ -668,6 +928,14 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.CompactionEnd(info)
 			b.CompactionEnd(info)
 		},
+		CompactionVerificationFailed: func(info CompactionVerificationInfo) {
+			a.CompactionVerificationFailed(info)
+			b.CompactionVerificationFailed(info)
+		},
+		ConsistencyChecked: func(info ConsistencyCheckInfo) {
+			a.ConsistencyChecked(info)
+			b.ConsistencyChecked(info)
+		},
 		DiskSlow: func(info DiskSlowInfo) {
 			a.DiskSlow(info)
 			b.DiskSlow(info)
@@ -720,13 +988,17 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.WALDeleted(info)
 			b.WALDeleted(info)
 		},
+		WALSalvaged: func(info WALSalvageInfo) {
+			a.WALSalvaged(info)
+			b.WALSalvaged(info)
+		},
 		WriteStallBegin: func(info WriteStallBeginInfo) {
 			a.WriteStallBegin(info)
 			b.WriteStallBegin(info)
 		},
-		WriteStallEnd: func() {
-			a.WriteStallEnd()
-			b.WriteStallEnd()
+		WriteStallEnd: func(info WriteStallEndInfo) {
+			a.WriteStallEnd(info)
+			b.WriteStallEnd(info)
 		},
 	}
 }
@@ -223,9 +223,10 @@ func TestWriteStallEvents(t *testing.T) {
 	testCases := []struct {
 		delayFlush bool
 		expected   string
+		cause      WriteStallCause
 	}{
-		{true, "memtable count limit reached"},
-		{false, "L0 file count limit exceeded"},
+		{true, "memtable count limit reached", WriteStallMemTableCount},
+		{false, "L0 file count limit exceeded", WriteStallL0Sublevels},
 	}
 
 	for _, c := range testCases {
@@ -244,10 +245,19 @@ func TestWriteStallEvents(t *testing.T) {
 				},
 				WriteStallBegin: func(info WriteStallBeginInfo) {
 					log.Infof("%s", info.String())
+					require.Equal(t, c.cause, info.Cause)
+					require.Equal(t, c.expected, info.Reason)
+					if c.delayFlush {
+						require.Greater(t, info.MemTableCount, 1)
+					} else {
+						require.GreaterOrEqual(t, info.L0Sublevels, 2)
+						require.GreaterOrEqual(t, info.L0FileCount, info.L0Sublevels)
+					}
 					createReleased <- struct{}{}
 				},
-				WriteStallEnd: func() {
-					log.Infof("%s", writeStallEnd)
+				WriteStallEnd: func(info WriteStallEndInfo) {
+					log.Infof("%s", info.String())
+					require.Equal(t, c.cause, info.Cause)
 					select {
 					case stallEnded <- struct{}{}:
 					default:
@@ -289,6 +299,9 @@ func TestWriteStallEvents(t *testing.T) {
 			events := log.String()
 			require.Contains(t, events, c.expected)
 			require.Contains(t, events, writeStallEnd)
+			m := d.Metrics()
+			require.GreaterOrEqual(t, m.WriteStall.Count, int64(1))
+			require.Greater(t, m.WriteStall.Duration, time.Duration(0))
 			if testing.Verbose() {
 				t.Logf("\n%s", events)
 			}
@@ -0,0 +1,448 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// KeyRange encodes a key range in user key space. A KeyRange's Start is
+// inclusive while its End is exclusive.
+type KeyRange struct {
+	Start, End []byte
+}
+
+// Excise atomically removes all the keys within the given key ranges, without
+// writing range tombstones. The ranges may be passed in any order, and may
+// overlap.
+//
+// The memtables are flushed if they contain keys within the ranges, so that
+// the keys to remove are all in sstables. The sstables contained within the
+// ranges are then dropped, and those partially overlapping the ranges are
+// replaced by virtual sstables sharing their storage and retaining their keys
+// outside of the ranges, in a single version edit. The keys within the ranges
+// are thus removed without being rewritten, and without leaving tombstones
+// that reads would have to skip over until compactions drop them. The storage
+// of the virtualized sstables is reclaimed once their virtual sstables are
+// compacted.
+//
+// Unlike a DeleteRange, an Excise removes the keys within the ranges from any
+// open snapshot, so the DB must not have open snapshots. Writes to the ranges
+// that are concurrent with the Excise may or may not be removed. An sstable
+// read with a prefix replacement (see RenamePrefix) can only be excised if
+// it's contained within the ranges. The DB must be at format major version
+// FormatVirtualSSTables or later.
+func (d *DB) Excise(spans []KeyRange) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if v := d.FormatMajorVersion(); v < FormatVirtualSSTables {
+		return errors.Errorf(
+			"pebble: excise requires at least format major version %d (current: %d)",
+			FormatVirtualSSTables, v,
+		)
+	}
+	spans, err := d.exciseNormalizeSpans(spans)
+	if err != nil || len(spans) == 0 {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	files, err := d.excisePrepareLocked(spans)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	d.mu.Unlock()
+	for i := 0; i < len(files) && err == nil; i++ {
+		err = d.exciseTable(&files[i])
+	}
+	d.mu.Lock()
+	if err == nil {
+		err = d.exciseApplyLocked(jobID, files)
+	}
+	for _, f := range files {
+		if err == nil {
+			f.meta.SetCompactionState(manifest.CompactionStateCompacted)
+		} else {
+			f.meta.SetCompactionState(manifest.CompactionStateNotCompacting)
+		}
+	}
+	d.mu.versions.currentVersion().L0Sublevels.InitCompactingFileInfo(
+		inProgressL0Compactions(d.getInProgressCompactionInfoLocked(nil)))
+	d.mu.compact.cond.Broadcast()
+	d.maybeScheduleCompaction()
+	return err
+}
+
+// excisedFile describes an sstable overlapping the ranges of an Excise.
+type excisedFile struct {
+	level int
+	meta  *fileMetadata
+	// spans are the excised ranges overlapping the sstable, sorted and
+	// non-overlapping.
+	spans []KeyRange
+	// virtual are the virtual sstables replacing the sstable, which retain its
+	// keys outside of the spans.
+	virtual []*fileMetadata
+}
+
+// exciseNormalizeSpans returns a copy of the ranges of an Excise, sorted,
+// with the overlapping and adjacent ranges merged.
+func (d *DB) exciseNormalizeSpans(spans []KeyRange) ([]KeyRange, error) {
+	sorted := make([]KeyRange, 0, len(spans))
+	for _, s := range spans {
+		if d.cmp(s.Start, s.End) >= 0 {
+			return nil, errors.Errorf("pebble: invalid excise range [%s, %s)",
+				d.opts.Comparer.FormatKey(s.Start), d.opts.Comparer.FormatKey(s.End))
+		}
+		sorted = append(sorted, KeyRange{
+			Start: append([]byte(nil), s.Start...),
+			End:   append([]byte(nil), s.End...),
+		})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return d.cmp(sorted[i].Start, sorted[j].Start) < 0
+	})
+	merged := sorted[:0]
+	for _, s := range sorted {
+		if n := len(merged); n > 0 && d.cmp(s.Start, merged[n-1].End) <= 0 {
+			if d.cmp(s.End, merged[n-1].End) > 0 {
+				merged[n-1].End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged, nil
+}
+
+// excisePrepareLocked flushes the memtables if they contain keys within the
+// ranges, waits for the compactions of the sstables overlapping the ranges,
+// and returns these sstables, marked as compacting so that they're not
+// compacted until the excise completes. Requires DB.mu is held.
+func (d *DB) excisePrepareLocked(spans []KeyRange) ([]excisedFile, error) {
+	for _, s := range spans {
+		if d.memtablesOverlapLocked(s.Start, s.End) {
+			// The flush includes all the keys written before the Excise. The
+			// memtables are not checked again, as they may only contain
+			// concurrent writes from now on.
+			d.mu.Unlock()
+			err := d.Flush()
+			d.mu.Lock()
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	for {
+		files := d.exciseFilesLocked(spans)
+		compacting := false
+		for _, f := range files {
+			compacting = compacting || f.meta.IsCompacting()
+		}
+		if compacting {
+			d.mu.compact.cond.Wait()
+			continue
+		}
+		for _, f := range files {
+			f.meta.SetCompactionState(manifest.CompactionStateCompacting)
+		}
+		d.mu.versions.currentVersion().L0Sublevels.InitCompactingFileInfo(
+			inProgressL0Compactions(d.getInProgressCompactionInfoLocked(nil)))
+		return files, nil
+	}
+}
+
+// exciseFilesLocked returns the sstables of the current version overlapping
+// the ranges, along with the ranges overlapping each of them. Requires DB.mu
+// is held.
+func (d *DB) exciseFilesLocked(spans []KeyRange) []excisedFile {
+	current := d.mu.versions.currentVersion()
+	var files []excisedFile
+	for level := 0; level < numLevels; level++ {
+		levelFiles := make(map[*fileMetadata]int)
+		for _, s := range spans {
+			overlaps := current.Overlaps(level, d.cmp, s.Start, s.End, true /* exclusiveEnd */)
+			iter := overlaps.Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				// The overlaps of L0 are expanded to the sstables overlapping
+				// the overlapping sstables.
+				if !d.exciseOverlaps(f, s.Start, s.End) {
+					continue
+				}
+				i, ok := levelFiles[f]
+				if !ok {
+					i = len(files)
+					levelFiles[f] = i
+					files = append(files, excisedFile{level: level, meta: f})
+				}
+				files[i].spans = append(files[i].spans, s)
+			}
+		}
+	}
+	return files
+}
+
+// exciseOverlaps returns true if the bounds of the sstable overlap the key
+// range [start, end). A nil start or end leaves the key range unbounded.
+func (d *DB) exciseOverlaps(f *fileMetadata, start, end []byte) bool {
+	if end != nil && d.cmp(f.Smallest.UserKey, end) >= 0 {
+		return false
+	}
+	if start != nil {
+		c := d.cmp(f.Largest.UserKey, start)
+		return c > 0 || (c == 0 && !f.Largest.IsExclusiveSentinel())
+	}
+	return true
+}
+
+// exciseTable sets the virtual sstables replacing an excised sstable, one for
+// each of the gaps between the excised ranges containing keys of the
+// sstable.
+func (d *DB) exciseTable(f *excisedFile) error {
+	type gap struct{ start, end []byte }
+	var gaps []gap
+	var start []byte
+	for _, s := range f.spans {
+		if d.exciseOverlaps(f.meta, start, s.Start) {
+			gaps = append(gaps, gap{start: start, end: s.Start})
+		}
+		start = s.End
+	}
+	if d.exciseOverlaps(f.meta, start, nil) {
+		gaps = append(gaps, gap{start: start})
+	}
+	if len(gaps) == 0 {
+		// The sstable is contained within the ranges.
+		return nil
+	}
+	if r := f.meta.PrefixReplacement; r != nil {
+		return errors.Errorf("pebble: cannot excise: table %s is read with prefix replacement %s",
+			f.meta.FileNum, r)
+	}
+
+	iter, rangeDelIter, err := d.newIters(context.TODO(), f.meta,
+		&IterOptions{level: manifest.Level(f.level)}, internalIterOpts{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rangeDelIter != nil {
+			_ = rangeDelIter.Close()
+		}
+	}()
+	defer iter.Close()
+	var rangeKeyIter keyspan.FragmentIterator
+	if f.meta.HasRangeKeys {
+		if rangeKeyIter, err = d.tableNewRangeKeyIter(f.meta, &keyspan.SpanIterOptions{}); err != nil {
+			return err
+		}
+		defer rangeKeyIter.Close()
+	}
+
+	for _, g := range gaps {
+		m := &fileMetadata{
+			Virtual:             true,
+			FileBacking:         f.meta.FileBacking,
+			CreationTime:        f.meta.CreationTime,
+			SmallestSeqNum:      f.meta.SmallestSeqNum,
+			LargestSeqNum:       f.meta.LargestSeqNum,
+			MarkedForCompaction: f.meta.MarkedForCompaction,
+		}
+		if smallest, largest := d.exciseGapPointBounds(iter, g.start, g.end); smallest != nil {
+			m.ExtendPointKeyBounds(d.cmp, *smallest, *largest)
+		}
+		if smallest, largest := d.exciseGapSpanBounds(rangeDelIter, g.start, g.end); smallest != nil {
+			m.ExtendPointKeyBounds(d.cmp, *smallest, *largest)
+		}
+		if smallest, largest := d.exciseGapSpanBounds(rangeKeyIter, g.start, g.end); smallest != nil {
+			m.ExtendRangeKeyBounds(d.cmp, *smallest, *largest)
+		}
+		if err := firstError(iter.Error(), firstError(iterError(rangeDelIter), iterError(rangeKeyIter))); err != nil {
+			return err
+		}
+		if !m.HasPointKeys && !m.HasRangeKeys {
+			continue
+		}
+		if m.Size, err = d.exciseEstimateSize(f.meta, m.Smallest.UserKey, m.Largest.UserKey); err != nil {
+			return err
+		}
+		m.ValidateVirtual(f.meta)
+		f.virtual = append(f.virtual, m)
+	}
+	return nil
+}
+
+// iterError returns the error of the span iterator, which may be nil.
+func iterError(iter keyspan.FragmentIterator) error {
+	if iter == nil {
+		return nil
+	}
+	return iter.Error()
+}
+
+// exciseGapPointBounds returns the smallest and largest point keys within the
+// key range [start, end) of the point iterator of an sstable, or nil if there
+// are none. A nil start or end leaves the key range unbounded.
+func (d *DB) exciseGapPointBounds(
+	iter internalIterator, start, end []byte,
+) (smallest, largest *InternalKey) {
+	var k *InternalKey
+	if start == nil {
+		k, _ = iter.First()
+	} else {
+		k, _ = iter.SeekGE(start, base.SeekGEFlagsNone)
+	}
+	if k == nil || (end != nil && d.cmp(k.UserKey, end) >= 0) {
+		return nil, nil
+	}
+	s := k.Clone()
+	if end == nil {
+		k, _ = iter.Last()
+	} else {
+		k, _ = iter.SeekLT(end, base.SeekLTFlagsNone)
+	}
+	if k == nil {
+		return nil, nil
+	}
+	l := k.Clone()
+	return &s, &l
+}
+
+// exciseGapSpanBounds returns the smallest and largest keys of the spans of
+// the range deletion or range key iterator of an sstable, truncated to the key
+// range [start, end), or nil if there are none. A nil start or end leaves the
+// key range unbounded.
+func (d *DB) exciseGapSpanBounds(
+	iter keyspan.FragmentIterator, start, end []byte,
+) (smallest, largest *InternalKey) {
+	if iter == nil {
+		return nil, nil
+	}
+	var first *keyspan.Span
+	if start == nil {
+		first = iter.First()
+	} else {
+		first = iter.SeekGE(start)
+	}
+	for first != nil && len(first.Keys) == 0 {
+		first = iter.Next()
+	}
+	if first == nil || (end != nil && d.cmp(first.Start, end) >= 0) {
+		return nil, nil
+	}
+	s := first.SmallestKey()
+	if start != nil && d.cmp(s.UserKey, start) < 0 {
+		s.UserKey = start
+	}
+	s = s.Clone()
+
+	var last *keyspan.Span
+	if end == nil {
+		last = iter.Last()
+	} else {
+		last = iter.SeekLT(end)
+	}
+	for last != nil && len(last.Keys) == 0 {
+		last = iter.Prev()
+	}
+	if last == nil {
+		return nil, nil
+	}
+	l := last.LargestKey()
+	if end != nil && d.cmp(l.UserKey, end) > 0 {
+		l.UserKey = end
+	}
+	l = l.Clone()
+	return &s, &l
+}
+
+// exciseEstimateSize estimates the size of the data of an sstable within the
+// key range [start, end], which is at least 1 as virtual sstables must have a
+// non-zero size.
+func (d *DB) exciseEstimateSize(f *fileMetadata, start, end []byte) (uint64, error) {
+	var size uint64
+	var err error
+	if f.Virtual {
+		err = d.tableCache.withVirtualReader(
+			f.VirtualMeta(),
+			func(r sstable.VirtualReader) (err error) {
+				size, err = r.EstimateDiskUsage(start, end)
+				return err
+			},
+		)
+	} else {
+		err = d.tableCache.withReader(
+			f.PhysicalMeta(),
+			func(r *sstable.Reader) (err error) {
+				size, err = r.EstimateDiskUsage(start, end)
+				return err
+			},
+		)
+	}
+	if size == 0 {
+		size = 1
+	}
+	return size, err
+}
+
+// exciseApplyLocked replaces the excised sstables by their virtual sstables in
+// the LSM. Requires DB.mu is held.
+func (d *DB) exciseApplyLocked(jobID int, files []excisedFile) error {
+	d.mu.versions.logLock()
+	if !d.mu.snapshots.empty() {
+		d.mu.versions.logUnlock()
+		return errors.New("pebble: cannot excise while snapshots are open")
+	}
+
+	ve := &versionEdit{
+		DeletedFiles: make(map[deletedFileEntry]*fileMetadata, len(files)),
+	}
+	metrics := make(map[int]*LevelMetrics)
+	for _, f := range files {
+		ve.DeletedFiles[deletedFileEntry{Level: f.level, FileNum: f.meta.FileNum}] = f.meta
+		lm := metrics[f.level]
+		if lm == nil {
+			lm = &LevelMetrics{}
+			metrics[f.level] = lm
+		}
+		lm.NumFiles--
+		lm.Size -= int64(f.meta.Size)
+		for _, m := range f.virtual {
+			m.FileNum = d.mu.versions.getNextFileNum()
+			ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: f.level, Meta: m})
+			lm.NumFiles++
+			lm.Size += int64(m.Size)
+		}
+		if !f.meta.Virtual && len(f.virtual) > 0 {
+			ve.CreatedBackingTables = append(ve.CreatedBackingTables, f.meta.FileBacking)
+		}
+	}
+	if err := d.mu.versions.logAndApply(jobID, ve, metrics, false /* forceRotation */, func() []compactionInfo {
+		return d.getInProgressCompactionInfoLocked(nil)
+	}); err != nil {
+		return err
+	}
+	d.updateReadStateLocked(d.opts.DebugCheck)
+	d.updateTableStatsLocked(ve.NewFiles)
+	d.addIngestCompactionHintsLocked(ve.NewFiles)
+	return nil
+}
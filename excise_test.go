@@ -0,0 +1,126 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExcise(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		Comparer:                    testkeys.Comparer,
+		FS:                          mem,
+		FormatMajorVersion:          FormatVirtualSSTables - 1,
+		DisableAutomaticCompactions: true,
+		// Rotate the manifest on every edit, so that the virtual sstables are
+		// also written to manifest snapshots.
+		MaxManifestFileSize: 1,
+		Logger:              panicLogger{},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%03d", i)) }
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set(key(i), key(i), nil))
+	}
+	require.NoError(t, d.RangeKeySet(key(10), key(90), nil, []byte("v"), nil))
+	require.NoError(t, d.Compact(key(0), key(100), false /* parallelize */))
+	// The memtable contains keys within the ranges too.
+	require.NoError(t, d.Set(key(25), []byte("new"), nil))
+	require.NoError(t, d.Set(key(200), nil, nil))
+
+	// Excising requires FormatVirtualSSTables.
+	require.Error(t, d.Excise([]KeyRange{{Start: key(20), End: key(30)}}))
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatVirtualSSTables))
+
+	excised := func(i int) bool { return (i >= 20 && i < 30) || (i >= 50 && i < 65) }
+	require.NoError(t, d.Excise([]KeyRange{
+		{Start: key(55), End: key(65)},
+		{Start: key(20), End: key(30)},
+		{Start: key(50), End: key(56)},
+	}))
+
+	check := func(rangeKeySpans ...string) {
+		iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsOnly, UpperBound: key(100)})
+		var n int
+		for valid := iter.First(); valid; valid = iter.Next() {
+			for excised(n) {
+				n++
+			}
+			require.Equal(t, key(n), iter.Key())
+			require.Equal(t, key(n), iter.Value())
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, 100, n)
+
+		iter = d.NewIter(&IterOptions{KeyTypes: IterKeyTypeRangesOnly})
+		var spans []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			start, end := iter.RangeBounds()
+			spans = append(spans, fmt.Sprintf("[%s,%s)", start, end))
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, rangeKeySpans, spans)
+	}
+	check("[k010,k020)", "[k030,k050)", "[k065,k090)")
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[6], 3)
+	backing := tables[6][0].BackingSSTNum
+	for _, table := range tables[6] {
+		require.True(t, table.Virtual)
+		require.Equal(t, backing, table.BackingSSTNum)
+	}
+	require.EqualValues(t, 3, d.Metrics().Levels[6].NumFiles)
+
+	// The virtual sstables are recovered from the manifest.
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	check("[k010,k020)", "[k030,k050)", "[k065,k090)")
+
+	// Excising a virtual sstable further virtualizes it, and excising an
+	// sstable contained within the range drops it.
+	require.NoError(t, d.Excise([]KeyRange{{Start: key(40), End: key(45)}, {Start: key(150), End: key(250)}}))
+	excised = func(i int) bool {
+		return (i >= 20 && i < 30) || (i >= 40 && i < 45) || (i >= 50 && i < 65)
+	}
+	check("[k010,k020)", "[k030,k040)", "[k045,k050)", "[k065,k090)")
+	iter := d.NewIter(nil)
+	require.False(t, iter.SeekGE(key(100)))
+	require.NoError(t, iter.Close())
+
+	// Compacting the virtual sstables deletes their backing sstable. The L6
+	// sstables are only compacted along with overlapping sstables.
+	require.NoError(t, d.Set(key(0), key(0), nil))
+	require.NoError(t, d.Set(key(99), key(99), nil))
+	require.NoError(t, d.Compact(key(0), key(100), false /* parallelize */))
+	tables, err = d.SSTables()
+	require.NoError(t, err)
+	for _, table := range tables[6] {
+		require.False(t, table.Virtual)
+	}
+	check("[k010,k020)", "[k030,k040)", "[k045,k050)", "[k065,k090)")
+	d.TestOnlyWaitForCleaning()
+	_, err = mem.Stat(base.MakeFilepath(mem, "", fileTypeTable, backing.DiskFileNum()))
+	require.True(t, oserror.IsNotExist(err), "%v", err)
+
+	require.Error(t, d.Excise([]KeyRange{{Start: key(2), End: key(1)}}))
+	snap := d.NewSnapshot()
+	require.Error(t, d.Excise([]KeyRange{{Start: key(0), End: key(5)}}))
+	require.NoError(t, snap.Close())
+	require.NoError(t, d.Excise([]KeyRange{{Start: key(0), End: key(5)}}))
+}
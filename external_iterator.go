@@ -139,6 +139,7 @@ func NewExternalIterWithContext(
 		readState:           nil,
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
+		seekHistory:         seekHistory{lastKey: buf.seekKeyBuf},
 		boundsBuf:           buf.boundsBuf,
 		batch:               nil,
 		// Add the readers to the Iterator so that Close closes them, and
@@ -180,6 +181,10 @@ func validateExternalIterOpts(iterOpts *IterOptions) error {
 		return errors.Errorf("pebble: external iterator: OnlyReadGuaranteedDurable unsupported")
 	case iterOpts.UseL6Filters:
 		return errors.Errorf("pebble: external iterator: UseL6Filters unsupported")
+	case iterOpts.IncludeDeletions:
+		return errors.Errorf("pebble: external iterator: IncludeDeletions unsupported")
+	case iterOpts.ReadTimestamp != nil:
+		return errors.Errorf("pebble: external iterator: ReadTimestamp unsupported")
 	}
 	return nil
 }
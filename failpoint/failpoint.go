@@ -0,0 +1,29 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package failpoint provides named hooks at the junctures of Pebble where a
+// crash or an error is hardest to handle, so that tests can deterministically
+// exercise them: a hook can return an error, block until the test is ready,
+// or simulate a crash (e.g. with vfs.NewStrictMem). The hooks are only
+// compiled in with the pebble_failpoints build tag; in regular builds, Inject
+// is a no-op and Enable panics.
+package failpoint
+
+// Name identifies a failpoint.
+type Name string
+
+// The failpoints of Pebble.
+const (
+	// WALBeforeSync is hit by a WAL writer after writing records to the WAL and
+	// before syncing them. An error fails the pending syncs of the records.
+	WALBeforeSync Name = "wal-before-sync"
+	// ManifestBeforeSync is hit after writing a version edit to the MANIFEST
+	// and before syncing it and installing the new version. An error is
+	// fatal, as are the other errors writing the MANIFEST.
+	ManifestBeforeSync Name = "manifest-before-sync"
+	// IngestBeforeApply is hit by an ingestion after linking the ingested
+	// sstables into the DB directory and before sequencing them and updating
+	// the MANIFEST. An error fails the ingestion, whose links are removed.
+	IngestBeforeApply Name = "ingest-before-apply"
+)
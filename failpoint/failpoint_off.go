@@ -0,0 +1,26 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build !pebble_failpoints
+// +build !pebble_failpoints
+
+package failpoint
+
+// Enabled is true if we were built with the "pebble_failpoints" build tag.
+const Enabled = false
+
+// Enable makes the failpoint with the given name call fn each time it's hit.
+// It panics unless we were built with the "pebble_failpoints" build tag.
+func Enable(name Name, fn func() error) {
+	panic("pebble: failpoints require the pebble_failpoints build tag")
+}
+
+// Disable removes the hook of the failpoint with the given name, if any.
+func Disable(name Name) {}
+
+// Inject is called by Pebble when hitting the failpoint with the given name.
+// It's a no-op unless we were built with the "pebble_failpoints" build tag.
+func Inject(name Name) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build pebble_failpoints
+// +build pebble_failpoints
+
+package failpoint
+
+import "sync"
+
+// Enabled is true if we were built with the "pebble_failpoints" build tag.
+const Enabled = true
+
+var registry struct {
+	sync.RWMutex
+	hooks map[Name]func() error
+}
+
+// Enable makes the failpoint with the given name call fn each time it's hit,
+// replacing the previous hook, if any. fn is called synchronously by the
+// goroutine hitting the failpoint, and its error is returned by Inject.
+func Enable(name Name, fn func() error) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.hooks == nil {
+		registry.hooks = make(map[Name]func() error)
+	}
+	registry.hooks[name] = fn
+}
+
+// Disable removes the hook of the failpoint with the given name, if any.
+func Disable(name Name) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.hooks, name)
+}
+
+// Inject is called by Pebble when hitting the failpoint with the given name.
+// It returns the error of its hook, or nil if it has none.
+func Inject(name Name) error {
+	registry.RLock()
+	fn := registry.hooks[name]
+	registry.RUnlock()
+	if fn == nil {
+		return nil
+	}
+	return fn()
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/failpoint"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailpoints(t *testing.T) {
+	if !failpoint.Enabled {
+		t.Skip("requires the pebble_failpoints build tag")
+	}
+
+	t.Run("wal-before-sync", func(t *testing.T) {
+		mem := vfs.NewStrictMem()
+		d, err := Open("", &Options{FS: mem})
+		require.NoError(t, err)
+		require.NoError(t, d.Set([]byte("a"), nil, Sync))
+
+		// Simulate a crash after the record of b is written to the WAL, but
+		// before it's synced.
+		failpoint.Enable(failpoint.WALBeforeSync, func() error {
+			mem.SetIgnoreSyncs(true)
+			return nil
+		})
+		defer failpoint.Disable(failpoint.WALBeforeSync)
+		require.NoError(t, d.Set([]byte("b"), nil, Sync))
+		require.NoError(t, d.Close())
+		failpoint.Disable(failpoint.WALBeforeSync)
+		mem.ResetToSyncedState()
+		mem.SetIgnoreSyncs(false)
+
+		d, err = Open("", &Options{FS: mem})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+		_, closer, err := d.Get([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+		_, _, err = d.Get([]byte("b"))
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("manifest-before-sync", func(t *testing.T) {
+		mem := vfs.NewStrictMem()
+		d, err := Open("", &Options{FS: mem})
+		require.NoError(t, err)
+		require.NoError(t, d.Set([]byte("a"), nil, Sync))
+
+		// Simulate a crash after the version edit of the flush is written to
+		// the MANIFEST, but before it's synced. The flushed sstable is lost,
+		// and a is recovered from the WAL instead.
+		failpoint.Enable(failpoint.ManifestBeforeSync, func() error {
+			mem.SetIgnoreSyncs(true)
+			return nil
+		})
+		defer failpoint.Disable(failpoint.ManifestBeforeSync)
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Close())
+		failpoint.Disable(failpoint.ManifestBeforeSync)
+		mem.ResetToSyncedState()
+		mem.SetIgnoreSyncs(false)
+
+		// Open read-only, so that the recovered WAL isn't flushed.
+		d, err = Open("", &Options{FS: mem, ReadOnly: true})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+		require.Zero(t, d.Metrics().Levels[0].NumFiles)
+		_, closer, err := d.Get([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	})
+
+	t.Run("ingest-before-apply", func(t *testing.T) {
+		mem := vfs.NewMem()
+		d, err := Open("db", &Options{FS: mem})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+
+		f, err := mem.Create("ext")
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+		require.NoError(t, w.Set([]byte("a"), nil))
+		require.NoError(t, w.Close())
+		before, err := mem.List("db")
+		require.NoError(t, err)
+
+		// The ingestion fails after linking the sstable, which is removed.
+		injected := errors.New("injected")
+		failpoint.Enable(failpoint.IngestBeforeApply, func() error { return injected })
+		defer failpoint.Disable(failpoint.IngestBeforeApply)
+		require.ErrorIs(t, d.Ingest([]string{"ext"}), injected)
+		after, err := mem.List("db")
+		require.NoError(t, err)
+		require.ElementsMatch(t, before, after)
+		_, _, err = d.Get([]byte("a"))
+		require.ErrorIs(t, err, ErrNotFound)
+
+		failpoint.Disable(failpoint.IngestBeforeApply)
+		require.NoError(t, d.Ingest([]string{"ext"}))
+		_, closer, err := d.Get([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	})
+}
@@ -17,14 +17,17 @@ type fileType = base.FileType
 type FileNum = base.FileNum
 
 const (
-	fileTypeLog      = base.FileTypeLog
-	fileTypeLock     = base.FileTypeLock
-	fileTypeTable    = base.FileTypeTable
-	fileTypeManifest = base.FileTypeManifest
-	fileTypeCurrent  = base.FileTypeCurrent
-	fileTypeOptions  = base.FileTypeOptions
-	fileTypeTemp     = base.FileTypeTemp
-	fileTypeOldTemp  = base.FileTypeOldTemp
+	fileTypeLog       = base.FileTypeLog
+	fileTypeLock      = base.FileTypeLock
+	fileTypeTable     = base.FileTypeTable
+	fileTypeManifest  = base.FileTypeManifest
+	fileTypeCurrent   = base.FileTypeCurrent
+	fileTypeOptions   = base.FileTypeOptions
+	fileTypeTemp      = base.FileTypeTemp
+	fileTypeOldTemp   = base.FileTypeOldTemp
+	fileTypeBlob      = base.FileTypeBlob
+	fileTypeIdentity  = base.FileTypeIdentity
+	fileTypeLockOwner = base.FileTypeLockOwner
 )
 
 // setCurrentFile sets the CURRENT file to point to the manifest with
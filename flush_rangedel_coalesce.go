@@ -0,0 +1,175 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+)
+
+// coalesceFlushRangeDels fragments the range deletions of the flushing
+// memtables, and coalesces the runs of at least threshold abutting fragments
+// into single range deletions (see
+// Options.Experimental.FlushRangeDelCoalesceThreshold). The resulting range
+// deletions are stored in c.flushRangeDels, to be read by the input iterator
+// of the flush in place of the range deletions of the memtables.
+//
+// A run of fragments whose sequence numbers span [minSeq, maxSeq] is coalesced
+// into a range deletion at maxSeq, which deletes the keys deleted by the run
+// as long as it doesn't delete any other key, nor change the keys visible to
+// a snapshot:
+//
+//   - No snapshot may fall in (minSeq, maxSeq]. Such a snapshot would see some
+//     of the fragments but not the coalesced range deletion.
+//   - No point key of the memtables within the run may have a sequence number
+//     in (minSeq, maxSeq). Such a key may be newer than the fragment it falls
+//     in, but would be deleted by the coalesced range deletion. The same holds
+//     for the keys of the sstables, which are checked through their largest
+//     sequence number.
+func (c *compaction) coalesceFlushRangeDels(threshold int, snapshots []uint64) error {
+	var spans []keyspan.Span
+	for _, f := range c.flushing {
+		iter := f.newRangeDelIter(nil)
+		if iter == nil {
+			continue
+		}
+		for s := iter.First(); s != nil; s = iter.Next() {
+			spans = append(spans, keyspan.Span{
+				Start: append([]byte(nil), s.Start...),
+				End:   append([]byte(nil), s.End...),
+				Keys:  append([]keyspan.Key(nil), s.Keys...),
+			})
+		}
+		if err := firstError(iter.Error(), iter.Close()); err != nil {
+			return err
+		}
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	keyspan.Sort(c.cmp, spans)
+	var fragments []keyspan.Span
+	frag := keyspan.Fragmenter{
+		Cmp:    c.cmp,
+		Format: c.formatKey,
+		Emit: func(s keyspan.Span) {
+			fragments = append(fragments, s)
+		},
+	}
+	for _, s := range spans {
+		frag.Add(s)
+	}
+	frag.Finish()
+
+	rangeDels := make([]keyspan.Span, 0, len(fragments))
+	for i := 0; i < len(fragments); {
+		j := i + 1
+		for j < len(fragments) && c.cmp(fragments[j-1].End, fragments[j].Start) == 0 {
+			j++
+		}
+		if j-i >= threshold {
+			s, ok, err := c.coalesceRangeDelRun(fragments[i:j], snapshots)
+			if err != nil {
+				return err
+			}
+			if ok {
+				rangeDels = append(rangeDels, s)
+				c.coalescedRangeDels = append(c.coalescedRangeDels, s)
+				c.coalescedRangeDelFragments += j - i
+				i = j
+				continue
+			}
+		}
+		rangeDels = append(rangeDels, fragments[i:j]...)
+		i = j
+	}
+	c.flushRangeDels = rangeDels
+	return nil
+}
+
+// coalesceRangeDelRun returns the range deletion coalescing the given run of
+// abutting fragments, if it may be coalesced (see coalesceFlushRangeDels).
+func (c *compaction) coalesceRangeDelRun(
+	run []keyspan.Span, snapshots []uint64,
+) (_ keyspan.Span, ok bool, _ error) {
+	minSeq, maxSeq := uint64(math.MaxUint64), uint64(0)
+	for _, s := range run {
+		for _, k := range s.Keys {
+			seq := k.SeqNum()
+			if seq < minSeq {
+				minSeq = seq
+			}
+			if seq > maxSeq {
+				maxSeq = seq
+			}
+		}
+	}
+	if i := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i] > minSeq
+	}); i < len(snapshots) && snapshots[i] <= maxSeq {
+		return keyspan.Span{}, false, nil
+	}
+
+	start, end := run[0].Start, run[len(run)-1].End
+	for _, f := range c.flushing {
+		iter := f.newIter(nil)
+		key, _ := iter.SeekGE(start, base.SeekGEFlagsNone)
+		for ; key != nil && c.cmp(key.UserKey, end) < 0; key, _ = iter.Next() {
+			if seq := key.SeqNum(); seq > minSeq && seq < maxSeq {
+				break
+			}
+		}
+		inRun := key != nil && c.cmp(key.UserKey, end) < 0
+		if err := firstError(iter.Error(), iter.Close()); err != nil {
+			return keyspan.Span{}, false, err
+		}
+		if inRun {
+			return keyspan.Span{}, false, nil
+		}
+	}
+	for level := range c.version.Levels {
+		overlaps := c.version.Overlaps(level, c.cmp, start, end, true /* exclusiveEnd */)
+		iter := overlaps.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.LargestSeqNum > minSeq {
+				return keyspan.Span{}, false, nil
+			}
+		}
+	}
+	return keyspan.Span{
+		Start: start,
+		End:   end,
+		Keys:  []keyspan.Key{{Trailer: base.MakeTrailer(maxSeq, InternalKeyKindRangeDelete)}},
+	}, true, nil
+}
+
+// findCoalescedRangeDelLimit returns the next bound of a coalesced range
+// deletion after the given key, so that the range deletions coalesced by a
+// flush are written to dedicated sstables.
+func (c *compaction) findCoalescedRangeDelLimit(start []byte) []byte {
+	i := sort.Search(len(c.coalescedRangeDels), func(i int) bool {
+		return c.cmp(c.coalescedRangeDels[i].End, start) > 0
+	})
+	if i == len(c.coalescedRangeDels) {
+		return nil
+	}
+	if s := c.coalescedRangeDels[i]; c.cmp(s.Start, start) > 0 {
+		return s.Start
+	}
+	return c.coalescedRangeDels[i].End
+}
+
+// isCoalescedRangeDel returns true if the given key falls within a range
+// deletion coalesced by the flush.
+func (c *compaction) isCoalescedRangeDel(key []byte) bool {
+	i := sort.Search(len(c.coalescedRangeDels), func(i int) bool {
+		return c.cmp(c.coalescedRangeDels[i].End, key) > 0
+	})
+	return i < len(c.coalescedRangeDels) && c.cmp(c.coalescedRangeDels[i].Start, key) <= 0
+}
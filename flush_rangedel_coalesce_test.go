@@ -0,0 +1,136 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushRangeDelCoalescing(t *testing.T) {
+	const n = 20
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%03d", i)) }
+
+	open := func(t *testing.T, threshold int) *DB {
+		opts := &Options{
+			FS:                          vfs.NewMem(),
+			DisableAutomaticCompactions: true,
+		}
+		opts.Experimental.FlushRangeDelCoalesceThreshold = threshold
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		for i := 0; i < n; i++ {
+			require.NoError(t, d.Set(key(i), []byte("v"), nil))
+		}
+		require.NoError(t, d.Flush())
+		return d
+	}
+	deleteRanges := func(t *testing.T, d *DB, from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, d.DeleteRange(key(i), key(i+1), nil))
+		}
+	}
+	l0Files := func(d *DB) []*manifest.FileMetadata {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var files []*manifest.FileMetadata
+		iter := d.mu.versions.currentVersion().Levels[0].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			files = append(files, f)
+		}
+		return files
+	}
+	requireKeys := func(t *testing.T, d *DB, expected ...string) {
+		iter := d.NewIter(nil)
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, expected, keys)
+	}
+
+	t.Run("coalesced", func(t *testing.T) {
+		d := open(t, 4)
+		defer func() { require.NoError(t, d.Close()) }()
+		deleteRanges(t, d, 0, n-1)
+		require.NoError(t, d.Set([]byte("z"), []byte("v"), nil))
+		require.NoError(t, d.Flush())
+
+		// The flush writes the coalesced range deletion to its own sstable.
+		var coalesced []*manifest.FileMetadata
+		for _, f := range l0Files(d) {
+			if f.CoalescedRangeDels {
+				coalesced = append(coalesced, f)
+			}
+		}
+		require.Len(t, coalesced, 1)
+		require.Equal(t, "k000", string(coalesced[0].Smallest.UserKey))
+		require.Equal(t, fmt.Sprintf("k%03d", n-1), string(coalesced[0].Largest.UserKey))
+
+		m := d.Metrics()
+		require.EqualValues(t, 1, m.Flush.CoalescedRangeDelSpans)
+		require.EqualValues(t, n-1, m.Flush.CoalescedRangeDelFragments)
+		require.EqualValues(t, 1, m.Flush.CoalescedRangeDelTables)
+		requireKeys(t, d, fmt.Sprintf("k%03d", n-1), "z")
+	})
+
+	t.Run("below-threshold", func(t *testing.T) {
+		d := open(t, n)
+		defer func() { require.NoError(t, d.Close()) }()
+		deleteRanges(t, d, 0, n-1)
+		require.NoError(t, d.Flush())
+		for _, f := range l0Files(d) {
+			require.False(t, f.CoalescedRangeDels)
+		}
+		require.Zero(t, d.Metrics().Flush.CoalescedRangeDelSpans)
+		requireKeys(t, d, fmt.Sprintf("k%03d", n-1))
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		d := open(t, 4)
+		defer func() { require.NoError(t, d.Close()) }()
+		deleteRanges(t, d, 0, n/2)
+		s := d.NewSnapshot()
+		defer func() { require.NoError(t, s.Close()) }()
+		deleteRanges(t, d, n/2, n-1)
+		require.NoError(t, d.Flush())
+
+		// The snapshot falls within the sequence numbers of the run, which
+		// isn't coalesced.
+		require.Zero(t, d.Metrics().Flush.CoalescedRangeDelSpans)
+		requireKeys(t, d, fmt.Sprintf("k%03d", n-1))
+
+		iter := s.NewIter(nil)
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		var expected []string
+		for i := n / 2; i < n; i++ {
+			expected = append(expected, string(key(i)))
+		}
+		require.Equal(t, expected, keys)
+	})
+
+	t.Run("interleaved-point-key", func(t *testing.T) {
+		d := open(t, 4)
+		defer func() { require.NoError(t, d.Close()) }()
+		deleteRanges(t, d, 0, n/2)
+		// A key set after the range deletion covering it, but before the later
+		// range deletions of the run, must not be deleted.
+		require.NoError(t, d.Set(key(1), []byte("v2"), nil))
+		deleteRanges(t, d, n/2, n-1)
+		require.NoError(t, d.Flush())
+
+		require.Zero(t, d.Metrics().Flush.CoalescedRangeDelSpans)
+		requireKeys(t, d, "k001", fmt.Sprintf("k%03d", n-1))
+	})
+}
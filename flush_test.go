@@ -5,12 +5,15 @@
 package pebble
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/datadriven"
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -127,3 +130,142 @@ func TestFlushEmptyKey(t *testing.T) {
 	require.NoError(t, closer.Close())
 	require.NoError(t, d.Close())
 }
+
+func TestFlushWithApplicationMetadata(t *testing.T) {
+	for _, maxManifestFileSize := range []int64{1, 128 << 20} {
+		t.Run(fmt.Sprint(maxManifestFileSize), func(t *testing.T) {
+			mem := vfs.NewMem()
+			opts := &Options{
+				FS:                          mem,
+				DisableAutomaticCompactions: true,
+				MaxManifestFileSize:         maxManifestFileSize,
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			require.Nil(t, d.ApplicationMetadata())
+			require.Error(t, d.FlushWithApplicationMetadata([]byte("applied=0")))
+			require.NoError(t, d.RatchetFormatMajorVersion(FormatApplicationMetadata))
+
+			require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+			require.NoError(t, d.FlushWithApplicationMetadata([]byte("applied=1")))
+			require.Equal(t, []byte("applied=1"), d.ApplicationMetadata())
+
+			// Flushes without metadata, and compactions, preserve the latest
+			// metadata.
+			require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+			require.Equal(t, []byte("applied=1"), d.ApplicationMetadata())
+
+			// An empty memtable may be flushed to persist new metadata.
+			require.NoError(t, d.FlushWithApplicationMetadata([]byte("applied=2")))
+			require.Equal(t, []byte("applied=2"), d.ApplicationMetadata())
+
+			f, err := mem.Create("ext")
+			require.NoError(t, err)
+			w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+				TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+			})
+			require.NoError(t, w.Set([]byte("c"), []byte("3")))
+			require.NoError(t, w.Close())
+			require.NoError(t, d.IngestWithApplicationMetadata([]string{"ext"}, []byte("applied=3")))
+			require.Equal(t, []byte("applied=3"), d.ApplicationMetadata())
+
+			// The latest persisted metadata is recovered on Open, regardless of
+			// the unflushed writes replayed from the WAL.
+			require.NoError(t, d.Set([]byte("d"), []byte("4"), nil))
+			require.NoError(t, d.Close())
+			d, err = Open("", opts)
+			require.NoError(t, err)
+			require.Equal(t, []byte("applied=3"), d.ApplicationMetadata())
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+func TestAsyncFlushWithHandle(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	lsmTables := func() map[FileNum]int {
+		m := make(map[FileNum]int)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for level, files := range d.mu.versions.currentVersion().Levels {
+			iter := files.Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				m[f.FileNum] = level
+			}
+		}
+		return m
+	}
+	checkResult := func(r FlushResult) {
+		tables := lsmTables()
+		for _, table := range r.Tables {
+			level, ok := tables[table.FileNum]
+			require.True(t, ok, "table %s isn't in the LSM", table.FileNum)
+			require.Equal(t, level, table.Level)
+			require.NotZero(t, table.Size)
+		}
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	h, err := d.AsyncFlushWithHandle()
+	require.NoError(t, err)
+	r, err := h.Wait(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, h.Err())
+	require.Len(t, r.JobIDs, 1)
+	require.Len(t, r.Tables, 1)
+	require.Equal(t, 0, r.Tables[0].Level)
+	require.Equal(t, "a", string(r.Tables[0].Smallest.UserKey))
+	checkResult(r)
+	select {
+	case <-h.Done():
+	default:
+		t.Fatal("flush isn't done")
+	}
+
+	// An empty memtable is flushed without producing any table.
+	h, err = d.AsyncFlushWithHandle()
+	require.NoError(t, err)
+	r, err = h.Wait(context.Background())
+	require.NoError(t, err)
+	require.Len(t, r.JobIDs, 1)
+	require.Empty(t, r.Tables)
+
+	// An ingestion which overlaps the memtable queues the ingested table
+	// behind it. The handle reports the tables of every flush up to the
+	// mutable memtable, which may include the ingested table if it hasn't
+	// been flushed yet.
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+		TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+	})
+	require.NoError(t, w.Set([]byte("b"), []byte("3")))
+	require.NoError(t, w.Close())
+	stats, err := d.IngestWithStats([]string{"ext"})
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.MemtableOverlappingFiles)
+	require.NoError(t, d.Set([]byte("c"), []byte("4"), nil))
+	h, err = d.AsyncFlushWithHandle()
+	require.NoError(t, err)
+	r, err = h.Wait(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, r.JobIDs)
+	require.Equal(t, "c", string(r.Tables[len(r.Tables)-1].Smallest.UserKey))
+	for i := 1; i < len(r.JobIDs); i++ {
+		require.Less(t, r.JobIDs[i-1], r.JobIDs[i])
+	}
+	checkResult(r)
+
+	// A canceled context returns without waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = (&FlushHandle{d: d, done: make(chan struct{})}).Wait(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
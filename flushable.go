@@ -52,6 +52,14 @@ type flushableEntry struct {
 	// The current logSeqNum at the time the memtable was created. This is
 	// guaranteed to be less than or equal to any seqnum stored in the memtable.
 	logSeqNum uint64
+	// applicationMetadata, if not nil, is persisted in the version edit that
+	// flushes the receiver. See DB.FlushWithApplicationMetadata. Protected by
+	// DB.mu.
+	applicationMetadata []byte
+	// outcome, if not nil, records the outcome of the flush of the receiver
+	// for the FlushHandles which wait on it. See DB.AsyncFlushWithHandle.
+	// Protected by DB.mu.
+	outcome *flushOutcome
 	// readerRefs tracks the read references on the flushable. The two sources of
 	// reader references are DB.mu.mem.queue and readState.memtables. The memory
 	// reserved by the flushable in the cache is released when the reader refs
@@ -151,6 +151,59 @@ const (
 	// compactions for files marked for compaction are complete.
 	FormatPrePebblev1MarkedCompacted
 
+	// FormatPrefixReplacement is a format major version that adds support for
+	// sstables read with a prefix replacement (see DB.AttachSharedTables and
+	// DB.RenamePrefix). The prefix replacement of an sstable is recorded in
+	// the manifest, in a custom tag of its new-file entry which previous
+	// versions fail to decode.
+	FormatPrefixReplacement
+
+	// FormatApplicationMetadata is a format major version that adds support
+	// for persisting opaque application metadata in the manifest (see
+	// DB.FlushWithApplicationMetadata). The metadata is recorded in a new
+	// version edit tag which previous versions fail to decode.
+	FormatApplicationMetadata
+
+	// FormatBlobFiles is a format major version that adds support for
+	// separating large values into blob files (see ValueSeparationOptions).
+	// The blob files referenced by an sstable are recorded in a custom tag of
+	// its new-file entry in the manifest, which previous versions fail to
+	// decode. Flushes and compactions only separate values at this format
+	// major version or later.
+	FormatBlobFiles
+
+	// FormatVirtualSSTables is a format major version that adds support for
+	// virtual sstables, which expose a subset of the keys of a physical
+	// sstable they share with other virtual sstables (see DB.Excise). The
+	// backing sstables of virtual sstables are recorded in the manifest under
+	// new version edit tags, which previous versions fail to decode.
+	FormatVirtualSSTables
+
+	// FormatStripedWAL is a format major version that adds support for
+	// striping the WAL across multiple directories (see
+	// Options.Experimental.WALStripeDirs). Striped WALs begin with a header
+	// record which previous versions don't recognize, and can't be replayed
+	// without their stripes.
+	FormatStripedWAL
+
+	// FormatTwoPhaseCommit is a format major version that adds support for
+	// two-phase commits (see Batch.Prepare). Prepared batches are persisted in
+	// WAL records which previous versions ignore, silently dropping the
+	// prepared batches and the commits of them.
+	FormatTwoPhaseCommit
+
+	// FormatTableFeatures is a format major version that adds support for
+	// sstables declaring the features they use in their footer (see
+	// sstable.TableFeatures), which previous versions fail to read. Ingested
+	// sstables of table formats newer than the maximum table format are
+	// accepted at this version if they declare their features, and only use
+	// features known to this version. Writing sstables with value checksums
+	// or encrypted blocks (see Options.Experimental.ValueChecksums and
+	// EncryptionKeyManager) requires this version, and sstables are only
+	// compressed with dictionaries (see LevelOptions.CompressionDict) at this
+	// version or later.
+	FormatTableFeatures
+
 	// FormatNewest always contains the most recent format major version.
 	FormatNewest FormatMajorVersion = iota - 1
 )
@@ -168,7 +221,9 @@ func (v FormatMajorVersion) MaxTableFormat() sstable.TableFormat {
 	case FormatRangeKeys, FormatMinTableFormatPebblev1, FormatPrePebblev1Marked,
 		FormatUnusedPrePebblev1MarkedCompacted:
 		return sstable.TableFormatPebblev2
-	case FormatSSTableValueBlocks, FormatFlushableIngest, FormatPrePebblev1MarkedCompacted:
+	case FormatSSTableValueBlocks, FormatFlushableIngest, FormatPrePebblev1MarkedCompacted,
+		FormatPrefixReplacement, FormatApplicationMetadata, FormatBlobFiles,
+		FormatVirtualSSTables, FormatStripedWAL, FormatTwoPhaseCommit, FormatTableFeatures:
 		return sstable.TableFormatPebblev3
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -186,7 +241,9 @@ func (v FormatMajorVersion) MinTableFormat() sstable.TableFormat {
 		return sstable.TableFormatLevelDB
 	case FormatMinTableFormatPebblev1, FormatPrePebblev1Marked,
 		FormatUnusedPrePebblev1MarkedCompacted, FormatSSTableValueBlocks,
-		FormatFlushableIngest, FormatPrePebblev1MarkedCompacted:
+		FormatFlushableIngest, FormatPrePebblev1MarkedCompacted, FormatPrefixReplacement,
+		FormatApplicationMetadata, FormatBlobFiles, FormatVirtualSSTables, FormatStripedWAL,
+		FormatTwoPhaseCommit, FormatTableFeatures:
 		return sstable.TableFormatPebblev1
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -319,6 +376,27 @@ var formatMajorVersionMigrations = map[FormatMajorVersion]func(*DB) error{
 		}
 		return d.finalizeFormatVersUpgrade(FormatPrePebblev1MarkedCompacted)
 	},
+	FormatPrefixReplacement: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatPrefixReplacement)
+	},
+	FormatApplicationMetadata: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatApplicationMetadata)
+	},
+	FormatBlobFiles: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatBlobFiles)
+	},
+	FormatVirtualSSTables: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatVirtualSSTables)
+	},
+	FormatStripedWAL: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatStripedWAL)
+	},
+	FormatTwoPhaseCommit: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatTwoPhaseCommit)
+	},
+	FormatTableFeatures: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatTableFeatures)
+	},
 }
 
 const formatVersionMarkerName = `format-version`
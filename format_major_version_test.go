@@ -62,6 +62,20 @@ func TestRatchetFormat(t *testing.T) {
 	require.Equal(t, FormatFlushableIngest, d.FormatMajorVersion())
 	require.NoError(t, d.RatchetFormatMajorVersion(FormatPrePebblev1MarkedCompacted))
 	require.Equal(t, FormatPrePebblev1MarkedCompacted, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatPrefixReplacement))
+	require.Equal(t, FormatPrefixReplacement, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatApplicationMetadata))
+	require.Equal(t, FormatApplicationMetadata, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatBlobFiles))
+	require.Equal(t, FormatBlobFiles, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatVirtualSSTables))
+	require.Equal(t, FormatVirtualSSTables, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatStripedWAL))
+	require.Equal(t, FormatStripedWAL, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatTwoPhaseCommit))
+	require.Equal(t, FormatTwoPhaseCommit, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatTableFeatures))
+	require.Equal(t, FormatTableFeatures, d.FormatMajorVersion())
 
 	require.NoError(t, d.Close())
 
@@ -225,6 +239,13 @@ func TestFormatMajorVersions_TableFormat(t *testing.T) {
 		FormatSSTableValueBlocks:               {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
 		FormatFlushableIngest:                  {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
 		FormatPrePebblev1MarkedCompacted:       {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
+		FormatPrefixReplacement:                {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
+		FormatApplicationMetadata:              {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
+		FormatBlobFiles:                        {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
+		FormatVirtualSSTables:                  {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
+		FormatStripedWAL:                       {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
+		FormatTwoPhaseCommit:                   {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
+		FormatTableFeatures:                    {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
 	}
 
 	// Valid versions.
@@ -0,0 +1,179 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+var (
+	// ErrLSMFreezeTimeout is returned by FreezeLSMs when the LSMs couldn't be
+	// frozen within LSMFreezeOptions.AcquireTimeout.
+	ErrLSMFreezeTimeout = errors.New("pebble: timed out freezing the LSM")
+
+	// ErrLSMFreezeExpired is returned by LSMFreeze.Thaw when the freeze was
+	// released automatically, once LSMFreezeOptions.MaxDuration elapsed. The
+	// state captured during the freeze may then be inconsistent.
+	ErrLSMFreezeExpired = errors.New("pebble: LSM freeze expired")
+
+	errLSMAlreadyFrozen = errors.New("pebble: LSM already frozen")
+)
+
+const (
+	defaultLSMFreezeAcquireTimeout = time.Second
+	defaultLSMFreezeMaxDuration    = 10 * time.Second
+)
+
+// LSMFreezeOptions configure FreezeLSMs.
+type LSMFreezeOptions struct {
+	// AcquireTimeout bounds the time spent freezing the LSMs, waiting for the
+	// version changes in progress to complete. The default is 1s.
+	AcquireTimeout time.Duration
+
+	// MaxDuration bounds the duration of the freeze, after which it's released
+	// automatically, in case the caller fails to thaw the LSMs. The default is
+	// 10s.
+	MaxDuration time.Duration
+}
+
+// LSMFreeze is a freeze of the LSMs of one or more DBs, returned by
+// FreezeLSMs.
+type LSMFreeze struct {
+	dbs   []*DB
+	timer *time.Timer
+
+	mu       sync.Mutex
+	released bool
+	expired  bool
+}
+
+// FreezeLSMs freezes the LSMs of the given DBs: until the returned freeze is
+// thawed, the versions of the DBs don't change, so that an orchestrator can
+// capture mutually consistent checkpoints of the DBs, eg, with
+// DB.Checkpoint. Flushes, compactions and ingestions wait to apply their
+// version edits while the LSMs are frozen, but writes to the memtables
+// proceed, subject to the usual write stalls, and must be excluded by the
+// caller if they would make the checkpoints inconsistent, eg by synchronizing
+// the checkpoints with a sequence number recorded in each DB.
+//
+// The DBs are frozen in order, each waiting for its version change in
+// progress to complete. If that takes longer than opts.AcquireTimeout, the
+// DBs frozen so far are thawed and ErrLSMFreezeTimeout is returned. The
+// freeze is released automatically once opts.MaxDuration elapsed, which Thaw
+// reports. A DB may only be frozen by a single freeze at once, and must not
+// be closed while frozen.
+func FreezeLSMs(opts LSMFreezeOptions, dbs ...*DB) (*LSMFreeze, error) {
+	if opts.AcquireTimeout <= 0 {
+		opts.AcquireTimeout = defaultLSMFreezeAcquireTimeout
+	}
+	if opts.MaxDuration <= 0 {
+		opts.MaxDuration = defaultLSMFreezeMaxDuration
+	}
+	deadline := time.Now().Add(opts.AcquireTimeout)
+	f := &LSMFreeze{dbs: make([]*DB, 0, len(dbs))}
+	for _, d := range dbs {
+		if err := d.freezeLSM(deadline); err != nil {
+			for _, frozen := range f.dbs {
+				frozen.thawLSM(false /* expired */)
+			}
+			return nil, err
+		}
+		f.dbs = append(f.dbs, d)
+	}
+	f.timer = time.AfterFunc(opts.MaxDuration, func() {
+		f.release(true /* expired */)
+	})
+	return f, nil
+}
+
+// FreezeLSM freezes the LSM of the DB. See FreezeLSMs.
+func (d *DB) FreezeLSM(opts LSMFreezeOptions) (*LSMFreeze, error) {
+	return FreezeLSMs(opts, d)
+}
+
+// Thaw releases the freeze, allowing the versions of the DBs to change
+// again. It returns ErrLSMFreezeExpired if the freeze was released
+// automatically before Thaw was called.
+func (f *LSMFreeze) Thaw() error {
+	f.timer.Stop()
+	if f.release(false /* expired */) {
+		return ErrLSMFreezeExpired
+	}
+	return nil
+}
+
+// release thaws the DBs, unless the freeze was already released, and returns
+// whether the freeze expired.
+func (f *LSMFreeze) release(expired bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.released {
+		f.released, f.expired = true, expired
+		for _, d := range f.dbs {
+			d.thawLSM(expired)
+		}
+	}
+	return f.expired
+}
+
+// freezeLSM freezes the version changes of the DB, once the version change in
+// progress, if any, completes, before the given deadline.
+func (d *DB) freezeLSM(deadline time.Time) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	vs := d.mu.versions
+	if vs.frozen {
+		return errLSMAlreadyFrozen
+	}
+	// Freeze first, so that no version change begins while waiting.
+	vs.frozen = true
+	if vs.writing {
+		// Wake up the wait below at the deadline.
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			vs.writerCond.Broadcast()
+		})
+		defer timer.Stop()
+		for vs.writing {
+			if !time.Now().Before(deadline) {
+				vs.frozen = false
+				vs.metrics.Freeze.TimeoutCount++
+				vs.writerCond.Broadcast()
+				return ErrLSMFreezeTimeout
+			}
+			vs.writerCond.Wait()
+		}
+	}
+	vs.frozenAt = d.timeNow()
+	vs.metrics.Freeze.Count++
+	return nil
+}
+
+// thawLSM releases the freeze of the DB, and wakes up the version changes
+// waiting for it.
+func (d *DB) thawLSM(expired bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	vs := d.mu.versions
+	vs.frozen = false
+	if dur := d.timeNow().Sub(vs.frozenAt); !vs.frozenAt.IsZero() {
+		vs.metrics.Freeze.TotalDuration += dur
+		if dur > vs.metrics.Freeze.MaxDuration {
+			vs.metrics.Freeze.MaxDuration = dur
+		}
+	}
+	vs.frozenAt = time.Time{}
+	if expired {
+		vs.metrics.Freeze.ExpiredCount++
+	}
+	vs.writerCond.Broadcast()
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreezeLSMs(t *testing.T) {
+	open := func() *DB {
+		d, err := Open("", &Options{FS: vfs.NewMem()})
+		require.NoError(t, err)
+		return d
+	}
+	d1, d2 := open(), open()
+	defer func() {
+		require.NoError(t, d1.Close())
+		require.NoError(t, d2.Close())
+	}()
+
+	f, err := FreezeLSMs(LSMFreezeOptions{MaxDuration: time.Minute}, d1, d2)
+	require.NoError(t, err)
+	require.True(t, d1.Metrics().Freeze.Frozen)
+	require.True(t, d2.Metrics().Freeze.Frozen)
+
+	// A DB can't be frozen twice at once.
+	_, err = d2.FreezeLSM(LSMFreezeOptions{})
+	require.ErrorIs(t, err, errLSMAlreadyFrozen)
+
+	// Flushes can't apply their version edits while frozen, but checkpoints
+	// proceed.
+	require.NoError(t, d1.Set([]byte("a"), nil, nil))
+	flushed, err := d1.AsyncFlush()
+	require.NoError(t, err)
+	select {
+	case <-flushed:
+		t.Fatal("flush completed while the LSM was frozen")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, d1.Checkpoint("checkpoint"))
+	require.Equal(t, int64(0), d1.Metrics().Levels[0].NumFiles)
+
+	require.NoError(t, f.Thaw())
+	<-flushed
+	require.Equal(t, int64(1), d1.Metrics().Levels[0].NumFiles)
+	// Thawing again is a no-op.
+	require.NoError(t, f.Thaw())
+
+	m := d1.Metrics().Freeze
+	require.False(t, m.Frozen)
+	require.Equal(t, int64(1), m.Count)
+	require.Equal(t, int64(0), m.ExpiredCount)
+	require.Greater(t, m.TotalDuration, time.Duration(0))
+	require.Equal(t, m.TotalDuration, m.MaxDuration)
+}
+
+func TestFreezeLSMExpired(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	f, err := d.FreezeLSM(LSMFreezeOptions{MaxDuration: 10 * time.Millisecond})
+	require.NoError(t, err)
+	// The flush completes once the freeze expires.
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.ErrorIs(t, f.Thaw(), ErrLSMFreezeExpired)
+
+	m := d.Metrics().Freeze
+	require.False(t, m.Frozen)
+	require.Equal(t, int64(1), m.Count)
+	require.Equal(t, int64(1), m.ExpiredCount)
+}
+
+func TestFreezeLSMTimeout(t *testing.T) {
+	open := func() *DB {
+		d, err := Open("", &Options{FS: vfs.NewMem()})
+		require.NoError(t, err)
+		return d
+	}
+	d1, d2 := open(), open()
+	defer func() {
+		require.NoError(t, d1.Close())
+		require.NoError(t, d2.Close())
+	}()
+
+	// Simulate a version change in progress in d2, which doesn't complete
+	// within the timeout.
+	d2.mu.Lock()
+	d2.mu.versions.logLock()
+	d2.mu.Unlock()
+
+	_, err := FreezeLSMs(LSMFreezeOptions{AcquireTimeout: 10 * time.Millisecond}, d1, d2)
+	require.ErrorIs(t, err, ErrLSMFreezeTimeout)
+	// The DBs frozen before the timeout are thawed.
+	require.False(t, d1.Metrics().Freeze.Frozen)
+	require.Equal(t, int64(1), d1.Metrics().Freeze.Count)
+
+	d2.mu.Lock()
+	d2.mu.versions.logUnlock()
+	d2.mu.Unlock()
+	require.Equal(t, int64(1), d2.Metrics().Freeze.TimeoutCount)
+	f, err := FreezeLSMs(LSMFreezeOptions{}, d1, d2)
+	require.NoError(t, err)
+	require.NoError(t, f.Thaw())
+}
@@ -7,7 +7,9 @@ package pebble
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
@@ -18,6 +20,7 @@ import (
 // internalIterator, but specialized for Get operations so that it loads data
 // lazily.
 type getIter struct {
+	ctx          context.Context
 	logger       Logger
 	cmp          Compare
 	equal        Equal
@@ -36,6 +39,20 @@ type getIter struct {
 	iterKey      *InternalKey
 	iterValue    base.LazyValue
 	err          error
+
+	// maxTables, if positive, is the number of sstables the getIter may open
+	// before failing with ErrReadAmpLimitExceeded. When set, newIters is
+	// limitNewIters, which counts the opened sstables in tables and opens them
+	// with tableNewIters.
+	maxTables     int
+	tables        int
+	tableNewIters tableNewIters
+
+	// trace, if non-nil, records the memtables, sstables and blocks touched
+	// (see WithGetTrace). When set, newIters is traceNewIters, which opens
+	// the sstables with tracedNewIters.
+	trace          *GetTrace
+	tracedNewIters tableNewIters
 }
 
 // TODO(sumeer): CockroachDB code doesn't use getIter, but, for completeness,
@@ -146,10 +163,17 @@ func (g *getIter) Next() (*InternalKey, base.LazyValue) {
 		// Create iterators from memtables from newest to oldest.
 		if n := len(g.mem); n > 0 {
 			m := g.mem[n-1]
+			var start time.Time
+			if g.trace != nil {
+				start = time.Now()
+			}
 			g.iter = m.newIter(nil)
 			g.rangeDelIter = m.newRangeDelIter(nil)
 			g.mem = g.mem[:n-1]
 			g.iterKey, g.iterValue = g.iter.SeekGE(g.key, base.SeekGEFlagsNone)
+			if g.trace != nil {
+				g.trace.record(GetTraceEvent{Kind: GetTraceMemtable, Level: -1}, start)
+			}
 			continue
 		}
 
@@ -159,7 +183,7 @@ func (g *getIter) Next() (*InternalKey, base.LazyValue) {
 				files := g.l0[n-1].Iter()
 				g.l0 = g.l0[:n-1]
 				iterOpts := IterOptions{logger: g.logger}
-				g.levelIter.init(context.Background(), iterOpts, g.cmp, nil /* split */, g.newIters,
+				g.levelIter.init(g.ctx, iterOpts, g.cmp, nil /* split */, g.newIters,
 					files, manifest.L0Sublevel(n), internalIterOpts{})
 				g.levelIter.initRangeDel(&g.rangeDelIter)
 				g.iter = &g.levelIter
@@ -178,7 +202,7 @@ func (g *getIter) Next() (*InternalKey, base.LazyValue) {
 		}
 
 		iterOpts := IterOptions{logger: g.logger}
-		g.levelIter.init(context.Background(), iterOpts, g.cmp, nil /* split */, g.newIters,
+		g.levelIter.init(g.ctx, iterOpts, g.cmp, nil /* split */, g.newIters,
 			g.version.Levels[g.level].Iter(), manifest.Level(g.level), internalIterOpts{})
 		g.levelIter.initRangeDel(&g.rangeDelIter)
 		g.level++
@@ -187,6 +211,23 @@ func (g *getIter) Next() (*InternalKey, base.LazyValue) {
 	}
 }
 
+// limitNewIters opens the iterators of an sstable, unless the getIter has
+// already opened maxTables sstables whose bounds contain the key.
+func (g *getIter) limitNewIters(
+	ctx context.Context, file *manifest.FileMetadata, opts *IterOptions, internalOpts internalIterOpts,
+) (internalIterator, keyspan.FragmentIterator, error) {
+	// The levelIter may open an sstable that begins after the key, which is
+	// not counted.
+	if g.cmp(file.Smallest.UserKey, g.key) <= 0 {
+		if g.tables >= g.maxTables {
+			return nil, nil, errors.Wrapf(ErrReadAmpLimitExceeded,
+				"key not found in the first %d sstables containing it", g.maxTables)
+		}
+		g.tables++
+	}
+	return g.tableNewIters(ctx, file, opts, internalOpts)
+}
+
 func (g *getIter) Prev() (*InternalKey, base.LazyValue) {
 	panic("pebble: Prev unimplemented")
 }
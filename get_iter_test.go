@@ -14,6 +14,8 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetIter(t *testing.T) {
@@ -576,3 +578,51 @@ func TestGetIter(t *testing.T) {
 		}
 	}
 }
+
+func TestMaxTablesPerGet(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true}
+	opts.Experimental.MaxTablesPerGet = 2
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write three overlapping sstables to L0. Only the oldest contains "m".
+	for i, keys := range []string{"amz", "az", "az"} {
+		for _, k := range keys {
+			require.NoError(t, d.Set([]byte{byte(k)}, []byte{byte('0' + i)}, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Set([]byte("b"), []byte("mem"), nil))
+
+	get := func(key string) (string, error) {
+		v, closer, err := d.Get([]byte(key))
+		if err != nil {
+			return "", err
+		}
+		defer closer.Close()
+		return string(v), nil
+	}
+	v, err := get("a")
+	require.NoError(t, err)
+	require.Equal(t, "2", v)
+	v, err = get("b")
+	require.NoError(t, err)
+	require.Equal(t, "mem", v)
+	// No sstable's bounds contain "0".
+	_, err = get("0")
+	require.Equal(t, ErrNotFound, err)
+
+	// Finding "m", or determining that "n" does not exist, requires consulting
+	// all three sstables.
+	for _, key := range []string{"m", "n"} {
+		_, err = get(key)
+		require.True(t, errors.Is(err, ErrReadAmpLimitExceeded), "%v", err)
+	}
+
+	// Iterators are not subject to the limit.
+	iter := d.NewIter(nil)
+	require.True(t, iter.SeekGE([]byte("m")))
+	require.Equal(t, "0", string(iter.Value()))
+	require.NoError(t, iter.Close())
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// GetTraceEventKind is the kind of a GetTraceEvent.
+type GetTraceEventKind int8
+
+const (
+	// GetTraceMemtable is a memtable, or a flushable ingested sstable, that
+	// was searched for the key.
+	GetTraceMemtable GetTraceEventKind = iota
+	// GetTraceTable is an sstable whose iterators were opened. Its Duration
+	// is the time spent opening them, which may include loading the sstable
+	// into the table cache.
+	GetTraceTable
+	// GetTraceBlock is a block read from an sstable, from the block cache or
+	// from storage.
+	GetTraceBlock
+)
+
+func (k GetTraceEventKind) String() string {
+	switch k {
+	case GetTraceMemtable:
+		return "memtable"
+	case GetTraceTable:
+		return "table"
+	case GetTraceBlock:
+		return "block"
+	default:
+		return fmt.Sprintf("GetTraceEventKind(%d)", int8(k))
+	}
+}
+
+// GetTraceEvent is a memtable, sstable or block touched by a Get.
+type GetTraceEvent struct {
+	Kind GetTraceEventKind
+	// Level is the LSM level of the sstable of a GetTraceTable or
+	// GetTraceBlock event, and -1 for a GetTraceMemtable event.
+	Level int
+	// FileNum is the file number of the sstable of a GetTraceTable or
+	// GetTraceBlock event.
+	FileNum FileNum
+	// BlockOffset and BlockLength are the offset and the length of the block
+	// of a GetTraceBlock event.
+	BlockOffset, BlockLength uint64
+	// CacheHit is true if the block of a GetTraceBlock event was found in the
+	// block cache.
+	CacheHit bool
+	// Start is the time at which the event began, relative to the start of the
+	// Get.
+	Start time.Duration
+	// Duration is the time spent searching the memtable, opening the sstable,
+	// or reading the block. It's zero for cache hits.
+	Duration time.Duration
+}
+
+// GetTrace records the memtables, sstables and blocks touched by a Get, in
+// the order they were done with, to explain individual slow reads. The blocks
+// read while opening an sstable, such as its index block, precede the
+// sstable. A Get records its trace if its context carries one (see
+// WithGetTrace and DB.GetWithContext). The trace must not be read until the
+// Get returns, and must not be shared by concurrent Gets.
+type GetTrace struct {
+	Events []GetTraceEvent
+	// Duration is the duration of the Get.
+	Duration time.Duration
+
+	start time.Time
+	// level is the level of the sstable whose iterators were opened last, to
+	// which the blocks read are attributed.
+	level int
+}
+
+type getTraceKey struct{}
+
+// WithGetTrace returns a context that makes the Gets using it record their
+// trace in t. The events of a Get are appended to those already in t.
+func WithGetTrace(ctx context.Context, t *GetTrace) context.Context {
+	return context.WithValue(ctx, getTraceKey{}, t)
+}
+
+// getTraceFromContext returns the trace set by WithGetTrace, if any.
+func getTraceFromContext(ctx context.Context) *GetTrace {
+	t, _ := ctx.Value(getTraceKey{}).(*GetTrace)
+	return t
+}
+
+// String implements fmt.Stringer, listing an event per line.
+func (t *GetTrace) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "get: %s\n", t.Duration)
+	for _, e := range t.Events {
+		fmt.Fprintf(&buf, "  +%s %s", e.Start, e.Kind)
+		switch e.Kind {
+		case GetTraceTable:
+			fmt.Fprintf(&buf, " L%d %s", e.Level, e.FileNum)
+		case GetTraceBlock:
+			fmt.Fprintf(&buf, " L%d %s [%d,%d)", e.Level, e.FileNum,
+				e.BlockOffset, e.BlockOffset+e.BlockLength)
+			if e.CacheHit {
+				buf.WriteString(" cached")
+			}
+		}
+		fmt.Fprintf(&buf, ": %s\n", e.Duration)
+	}
+	return buf.String()
+}
+
+func (t *GetTrace) begin() {
+	t.start = time.Now()
+	t.level = -1
+}
+
+func (t *GetTrace) end() {
+	t.Duration = time.Since(t.start)
+}
+
+// record appends an event that began at start and lasted until now.
+func (t *GetTrace) record(e GetTraceEvent, start time.Time) {
+	now := time.Now()
+	e.Start = start.Sub(t.start)
+	e.Duration = now.Sub(start)
+	t.Events = append(t.Events, e)
+}
+
+// observeBlockRead is passed to sstable.WithBlockReadObserver.
+func (t *GetTrace) observeBlockRead(b sstable.BlockRead) {
+	t.Events = append(t.Events, GetTraceEvent{
+		Kind:        GetTraceBlock,
+		Level:       t.level,
+		FileNum:     b.FileNum,
+		BlockOffset: b.Offset,
+		BlockLength: b.Length,
+		CacheHit:    b.CacheHit,
+		Start:       time.Since(t.start) - b.Duration,
+		Duration:    b.Duration,
+	})
+}
+
+// traceNewIters opens the iterators of an sstable, recording it in the trace
+// of the getIter.
+func (g *getIter) traceNewIters(
+	ctx context.Context, file *manifest.FileMetadata, opts *IterOptions, internalOpts internalIterOpts,
+) (internalIterator, keyspan.FragmentIterator, error) {
+	start := time.Now()
+	g.trace.level = manifest.LevelToInt(opts.level)
+	iter, rangeDelIter, err := g.tracedNewIters(ctx, file, opts, internalOpts)
+	g.trace.record(GetTraceEvent{
+		Kind:    GetTraceTable,
+		Level:   g.trace.level,
+		FileNum: file.FileNum,
+	}, start)
+	return iter, rangeDelIter, err
+}
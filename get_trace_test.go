@@ -0,0 +1,70 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTrace(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+
+	kinds := func(trace *GetTrace) []GetTraceEventKind {
+		var res []GetTraceEventKind
+		for _, e := range trace.Events {
+			res = append(res, e.Kind)
+		}
+		return res
+	}
+
+	// The key is found in the memtable.
+	var trace GetTrace
+	v, closer, err := d.GetWithContext(WithGetTrace(context.Background(), &trace), []byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, "2", string(v))
+	require.NoError(t, closer.Close())
+	require.Equal(t, []GetTraceEventKind{GetTraceMemtable}, kinds(&trace))
+	require.Equal(t, -1, trace.Events[0].Level)
+
+	// The key is found in the sstable flushed to L0, whose index and data
+	// blocks are read from storage the first time, and from the block cache
+	// the second time.
+	for i, cacheHit := range []bool{false, true} {
+		trace = GetTrace{}
+		v, closer, err = d.GetWithContext(WithGetTrace(context.Background(), &trace), []byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, "1", string(v))
+		require.NoError(t, closer.Close())
+		require.Equal(t, []GetTraceEventKind{
+			GetTraceMemtable, GetTraceBlock, GetTraceTable, GetTraceBlock,
+		}, kinds(&trace), "get %d:\n%s", i, &trace)
+		index, table, data := trace.Events[1], trace.Events[2], trace.Events[3]
+		for _, e := range []GetTraceEvent{index, table, data} {
+			require.Equal(t, 0, e.Level)
+			require.Equal(t, table.FileNum, e.FileNum)
+		}
+		require.Equal(t, cacheHit, index.CacheHit)
+		require.Equal(t, cacheHit, data.CacheHit)
+		require.LessOrEqual(t, table.Start, index.Start)
+		require.LessOrEqual(t, data.Start+data.Duration, trace.Duration)
+	}
+
+	// Gets without a trace in their context aren't traced.
+	trace = GetTrace{}
+	_, closer, err = d.GetWithContext(context.Background(), []byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+	require.Empty(t, trace.Events)
+}
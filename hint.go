@@ -0,0 +1,113 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/errors"
+
+// Hint informs the DB that the key range [start, end) is expected to become
+// read-hot soon. The DB uses the hint in two ways:
+//
+//   - Files overlapping the range in levels that are themselves overlapped by
+//     data in lower levels are queued for read-triggered compaction, reducing
+//     the read amplification of the range. The compactions are picked with
+//     the same (low) priority as compactions triggered by read sampling.
+//   - The blocks of the range are read through an iterator, warming the block
+//     cache.
+//
+// Hint blocks until the cache has been warmed, which requires reading the
+// entire range. Callers that do not wish to wait should invoke Hint from a
+// separate goroutine. Hints are advisory: a hint may be dropped if the queue
+// of pending read compactions is full, and cache warming is subject to
+// eviction like any other cached block.
+func (d *DB) Hint(start, end []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.cmp(start, end) >= 0 {
+		return errors.Errorf("pebble: hint start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+	if !d.opts.ReadOnly && d.opts.Experimental.ReadSamplingMultiplier >= 0 {
+		d.mu.Lock()
+		d.addHintReadCompactionsLocked(start, end, true /* exclusiveEnd */)
+		d.maybeScheduleCompaction()
+		d.mu.Unlock()
+	}
+	return d.warmCache(start, end)
+}
+
+// addHintReadCompactionsLocked queues read compactions for files overlapping
+// [start, end) that have overlapping data beneath them. If exclusiveEnd is
+// false, the range is [start, end].
+//
+// d.mu must be held when calling this.
+func (d *DB) addHintReadCompactionsLocked(start, end []byte, exclusiveEnd bool) {
+	v := d.mu.versions.currentVersion()
+	// Walk the levels bottom-up so that we know, for each level, whether any
+	// deeper level overlaps the hinted range. Files in the deepest overlapping
+	// level have nothing to be compacted into and are skipped.
+	var pending []*readCompaction
+	deeperOverlap := false
+	for level := numLevels - 1; level >= 0; level-- {
+		overlaps := v.Overlaps(level, d.cmp, start, end, exclusiveEnd)
+		if overlaps.Empty() {
+			continue
+		}
+		if deeperOverlap {
+			iter := overlaps.Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				pending = append(pending, &readCompaction{
+					level:   level,
+					start:   f.Smallest.UserKey,
+					end:     f.Largest.UserKey,
+					fileNum: f.FileNum,
+				})
+			}
+		}
+		deeperOverlap = true
+	}
+	// Add the compactions for the highest levels last, so that they're the
+	// least likely to be evicted from the bounded queue.
+	for _, rc := range pending {
+		d.mu.compact.readCompactions.add(rc, d.cmp)
+	}
+}
+
+// addIngestCompactionHintsLocked queues read compactions for the regions of
+// the LSM covered by newly ingested tables, or by the virtual tables created
+// by an excise, if enabled by Options.Experimental.EnableIngestCompactionHints.
+// Ingested tables are often small, and are placed above lower levels that they
+// overlap at their boundaries, fragmenting the LSM around the ingested regions
+// until the regions are next compacted. Similarly, excises leave small virtual
+// tables at the boundaries of the excised ranges. The hints compact these
+// tables, and the tables that overlap them above lower levels with data, into
+// the lower levels promptly.
+//
+// d.mu must be held when calling this.
+func (d *DB) addIngestCompactionHintsLocked(newFiles []newFileEntry) {
+	if !d.opts.Experimental.EnableIngestCompactionHints ||
+		d.opts.Experimental.ReadSamplingMultiplier < 0 {
+		return
+	}
+	for _, e := range newFiles {
+		d.addHintReadCompactionsLocked(
+			e.Meta.Smallest.UserKey, e.Meta.Largest.UserKey, e.Meta.Largest.IsExclusiveSentinel())
+	}
+}
+
+// warmCache reads all of the keys within [start, end), loading the blocks
+// containing them into the block cache.
+func (d *DB) warmCache(start, end []byte) error {
+	iter := d.NewIter(&IterOptions{
+		LowerBound: start,
+		UpperBound: end,
+	})
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if _, err := iter.ValueAndErr(); err != nil {
+			return firstError(err, iter.Close())
+		}
+	}
+	return iter.Close()
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHint(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	// Write two overlapping generations of keys so that the range has data in
+	// more than one level.
+	for gen := 0; gen < 2; gen++ {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("%03d", i))
+			require.NoError(t, d.Set(key, []byte(fmt.Sprint(gen)), nil))
+		}
+		require.NoError(t, d.Flush())
+		if gen == 0 {
+			require.NoError(t, d.Compact([]byte("000"), []byte("100"), false))
+		}
+	}
+	require.NoError(t, d.Close())
+
+	// Reopen with an empty cache.
+	c := NewCache(1 << 20)
+	defer c.Unref()
+	opts.Cache = c
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.Error(t, d.Hint([]byte("b"), []byte("a")))
+
+	d.mu.Lock()
+	require.Equal(t, 0, d.mu.compact.readCompactions.size)
+	d.mu.Unlock()
+
+	before := c.Size()
+	require.NoError(t, d.Hint([]byte("010"), []byte("020")))
+	require.Greater(t, c.Size(), before)
+
+	// The L0 file overlaps the compacted data beneath it, and should have been
+	// queued for a read compaction. Automatic compactions are disabled, so the
+	// queued compaction remains.
+	d.mu.Lock()
+	require.Equal(t, 1, d.mu.compact.readCompactions.size)
+	require.Equal(t, 0, d.mu.compact.readCompactions.queue[0].level)
+	d.mu.Unlock()
+}
+
+func TestIngestCompactionHints(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			mem := vfs.NewMem()
+			opts := &Options{
+				FS:                          mem,
+				DisableAutomaticCompactions: true,
+			}
+			opts.Experimental.EnableIngestCompactionHints = enabled
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			for i := 0; i < 100; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), nil, nil))
+			}
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact([]byte("000"), []byte("100"), false))
+
+			// Ingest a table that overlaps the compacted data. It's placed in
+			// L0, above the data it overlaps.
+			f, err := mem.Create("ext")
+			require.NoError(t, err)
+			w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+			require.NoError(t, w.Set([]byte("010"), nil))
+			require.NoError(t, w.Set([]byte("050"), nil))
+			require.NoError(t, w.Close())
+			require.NoError(t, d.Ingest([]string{"ext"}))
+
+			// Automatic compactions are disabled, so the queued compaction
+			// remains.
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			require.Equal(t, 1, d.mu.versions.currentVersion().Levels[0].Len())
+			if !enabled {
+				require.Equal(t, 0, d.mu.compact.readCompactions.size)
+				return
+			}
+			require.Equal(t, 1, d.mu.compact.readCompactions.size)
+			rc := d.mu.compact.readCompactions.queue[0]
+			require.Equal(t, 0, rc.level)
+			require.Equal(t, "010", string(rc.start))
+			require.Equal(t, "050", string(rc.end))
+		})
+	}
+}
+
+func TestExciseCompactionHints(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          FormatVirtualSSTables,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.EnableIngestCompactionHints = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	write := func() {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), nil, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	write()
+	require.NoError(t, d.Compact([]byte("000"), []byte("100"), false))
+	write()
+
+	// The excise leaves virtual tables in L0 on either side of the excised
+	// range, above the data in L6.
+	require.NoError(t, d.Excise([]KeyRange{{Start: []byte("040"), End: []byte("060")}}))
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	require.Equal(t, 2, d.mu.compact.readCompactions.size)
+	var regions []string
+	for _, rc := range d.mu.compact.readCompactions.queue[:2] {
+		require.Equal(t, 0, rc.level)
+		regions = append(regions, fmt.Sprintf("%s-%s", rc.start, rc.end))
+	}
+	sort.Strings(regions)
+	require.Equal(t, []string{"000-039", "060-099"}, regions)
+}
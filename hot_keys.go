@@ -0,0 +1,211 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// HotKeySamplingOptions configures the sampling of reads and writes used to
+// identify the most frequently accessed keys. See DB.HotKeys.
+type HotKeySamplingOptions struct {
+	// SampleEvery enables sampling when positive, in which case one out of
+	// every SampleEvery reads and one out of every SampleEvery committed
+	// batches are sampled. Reads are point lookups and iterator seeks.
+	SampleEvery int
+	// Prefix maps a sampled key to the identifier under which its accesses
+	// are counted, eg, the prefix of the key, or the start key of the range
+	// containing it. The returned slice must not be modified. Defaults to the
+	// prefix of the key returned by Comparer.Split, or the key itself if the
+	// Comparer has no Split function.
+	Prefix func(key []byte) []byte
+	// Capacity is the number of keys tracked for reads and for writes. Keys
+	// accessed more often than 1/Capacity of the sampled accesses are
+	// guaranteed to be tracked. Defaults to 100.
+	Capacity int
+}
+
+// HotKey describes the sampled accesses of a key, as identified by
+// HotKeySamplingOptions.Prefix. The counts are extrapolated from the sampled
+// accesses.
+type HotKey struct {
+	// Key is the identifier of the key.
+	Key []byte
+	// Count is the estimated number of accesses to the key. It may
+	// overestimate the number of accesses by up to Error.
+	Count uint64
+	// Error bounds the overestimation of Count: the key was accessed at least
+	// Count-Error times.
+	Error uint64
+}
+
+// HotKeys returns the n keys most frequently read and written since the DB
+// was opened, in decreasing order of Count. If n is negative, all tracked keys
+// are returned. It returns nil slices if sampling was not enabled through
+// Options.Experimental.HotKeySampling. The counts are maintained with a
+// space-saving sketch, which bounds the memory used regardless of the number
+// of distinct keys accessed, and can inform load management and cache sizing
+// decisions.
+func (d *DB) HotKeys(n int) (reads, writes []HotKey) {
+	if d.hotKeys == nil {
+		return nil, nil
+	}
+	return d.hotKeys.reads.hottest(n), d.hotKeys.writes.hottest(n)
+}
+
+// hotKeyTracker samples reads and committed batches and maintains the top
+// accessed keys of each.
+type hotKeyTracker struct {
+	opts          HotKeySamplingOptions
+	readCounter   atomic.Uint64
+	writeCounter  atomic.Uint64
+	reads, writes spaceSaving
+}
+
+func newHotKeyTracker(opts HotKeySamplingOptions, comparer *Comparer) *hotKeyTracker {
+	if opts.Prefix == nil {
+		if split := comparer.Split; split != nil {
+			opts.Prefix = func(key []byte) []byte { return key[:split(key)] }
+		} else {
+			opts.Prefix = func(key []byte) []byte { return key }
+		}
+	}
+	if opts.Capacity <= 0 {
+		opts.Capacity = 100
+	}
+	t := &hotKeyTracker{opts: opts}
+	t.reads.init(opts.Capacity)
+	t.writes.init(opts.Capacity)
+	return t
+}
+
+// sampleRead samples a point lookup or iterator seek of the given key.
+func (t *hotKeyTracker) sampleRead(key []byte) {
+	if t.readCounter.Add(1)%uint64(t.opts.SampleEvery) != 0 {
+		return
+	}
+	t.reads.add(t.opts.Prefix(key), uint64(t.opts.SampleEvery))
+}
+
+// sampleBatch samples the keys written by a batch about to be committed. It
+// must be called before the batch is committed, as the contents of large
+// batches are released by the commit.
+func (t *hotKeyTracker) sampleBatch(b *Batch) {
+	if t.writeCounter.Add(1)%uint64(t.opts.SampleEvery) != 0 {
+		return
+	}
+	r := b.Reader()
+	for {
+		kind, ukey, _, ok := r.Next()
+		if !ok {
+			break
+		}
+		if kind == InternalKeyKindLogData {
+			continue
+		}
+		t.writes.add(t.opts.Prefix(ukey), uint64(t.opts.SampleEvery))
+	}
+}
+
+// spaceSaving implements the space-saving sketch of Metwally et al., which
+// approximates the most frequent items of a stream in bounded space. At most
+// capacity items are tracked; an untracked item replaces the tracked item
+// with the lowest count, and inherits its count as overestimation error.
+type spaceSaving struct {
+	capacity int
+
+	mu struct {
+		sync.Mutex
+		items map[string]*spaceSavingItem
+		// heap orders the tracked items by count, with the lowest first.
+		heap spaceSavingHeap
+	}
+}
+
+type spaceSavingItem struct {
+	key                 string
+	count, overestimate uint64
+	index               int
+}
+
+func (s *spaceSaving) init(capacity int) {
+	s.capacity = capacity
+	s.mu.items = make(map[string]*spaceSavingItem, capacity)
+	s.mu.heap = make(spaceSavingHeap, 0, capacity)
+}
+
+// add records n accesses of the key.
+func (s *spaceSaving) add(key []byte, n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.mu.items[string(key)]; ok {
+		item.count += n
+		heap.Fix(&s.mu.heap, item.index)
+		return
+	}
+	if len(s.mu.heap) < s.capacity {
+		item := &spaceSavingItem{key: string(key), count: n}
+		s.mu.items[item.key] = item
+		heap.Push(&s.mu.heap, item)
+		return
+	}
+	// Replace the item with the lowest count.
+	item := s.mu.heap[0]
+	delete(s.mu.items, item.key)
+	item.key = string(key)
+	item.overestimate = item.count
+	item.count += n
+	s.mu.items[item.key] = item
+	heap.Fix(&s.mu.heap, 0)
+}
+
+func (s *spaceSaving) hottest(n int) []HotKey {
+	s.mu.Lock()
+	keys := make([]HotKey, 0, len(s.mu.heap))
+	for _, item := range s.mu.heap {
+		keys = append(keys, HotKey{Key: []byte(item.key), Count: item.count, Error: item.overestimate})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return string(keys[i].Key) < string(keys[j].Key)
+	})
+	if n >= 0 && n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// spaceSavingHeap implements heap.Interface, ordering items by increasing
+// count.
+type spaceSavingHeap []*spaceSavingItem
+
+func (h spaceSavingHeap) Len() int           { return len(h) }
+func (h spaceSavingHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h spaceSavingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *spaceSavingHeap) Push(x interface{}) {
+	item := x.(*spaceSavingItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *spaceSavingHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	var s spaceSaving
+	s.init(2)
+	add := func(keys ...string) {
+		for _, k := range keys {
+			s.add([]byte(k), 1)
+		}
+	}
+	format := func(keys []HotKey) []string {
+		var res []string
+		for _, k := range keys {
+			res = append(res, string(k.Key))
+		}
+		return res
+	}
+
+	add("a", "a", "a", "b")
+	require.Equal(t, []HotKey{
+		{Key: []byte("a"), Count: 3},
+		{Key: []byte("b"), Count: 1},
+	}, s.hottest(-1))
+
+	// An untracked key replaces the key with the lowest count, and inherits
+	// its count as error.
+	add("c")
+	require.Equal(t, []HotKey{
+		{Key: []byte("a"), Count: 3},
+		{Key: []byte("c"), Count: 2, Error: 1},
+	}, s.hottest(-1))
+
+	// A frequent key is tracked regardless of the keys accessed in between.
+	add("d", "e", "f", "a", "g", "a")
+	// The count of the key overestimates its 5 accesses by at most the error.
+	hot := s.hottest(1)
+	require.Equal(t, []string{"a"}, format(hot))
+	require.GreaterOrEqual(t, hot[0].Count, uint64(5))
+	require.LessOrEqual(t, hot[0].Count-hot[0].Error, uint64(5))
+}
+
+func TestHotKeys(t *testing.T) {
+	opts := &Options{Comparer: testkeys.Comparer, FS: vfs.NewMem()}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	reads, writes := d.HotKeys(10)
+	require.Nil(t, reads)
+	require.Nil(t, writes)
+	require.NoError(t, d.Close())
+
+	opts = &Options{Comparer: testkeys.Comparer, FS: vfs.NewMem()}
+	opts.Experimental.HotKeySampling = HotKeySamplingOptions{SampleEvery: 2, Capacity: 2}
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, d.Set([]byte("hot@1"), []byte("v"), nil))
+		require.NoError(t, d.Set([]byte("hot@2"), []byte("v"), nil))
+	}
+	require.NoError(t, d.Set([]byte("unsampled@1"), []byte("v"), nil))
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("cold@1"), []byte("v"), nil))
+	require.NoError(t, b.LogData([]byte("ignored"), nil))
+	require.NoError(t, d.Apply(b, nil))
+
+	for i := 0; i < 10; i++ {
+		_, closer, err := d.Get([]byte("hot@2"))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	}
+	iter := d.NewIter(nil)
+	for i := 0; i < 4; i++ {
+		iter.SeekGE([]byte("warm"))
+		iter.SeekPrefixGE([]byte("warm"))
+		iter.SeekLT([]byte("warm"))
+	}
+	require.NoError(t, iter.Close())
+
+	// Counts are extrapolated from one out of every two accesses, and grouped
+	// by prefix.
+	reads, writes = d.HotKeys(-1)
+	require.Equal(t, []HotKey{
+		{Key: []byte("warm"), Count: 12},
+		{Key: []byte("hot"), Count: 10},
+	}, reads)
+	require.Equal(t, []HotKey{
+		{Key: []byte("hot"), Count: 20},
+		{Key: []byte("cold"), Count: 2},
+	}, writes)
+	reads, writes = d.HotKeys(1)
+	require.Len(t, reads, 1)
+	require.Len(t, writes, 1)
+}
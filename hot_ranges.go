@@ -0,0 +1,214 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HotRangeSamplingOptions configures the sampling of writes used to identify
+// the key ranges with the most write contention. See DB.HotRanges.
+type HotRangeSamplingOptions struct {
+	// SampleEvery enables sampling when positive, in which case one out of
+	// every SampleEvery committed batches is sampled.
+	SampleEvery int
+	// RangeKey maps a key written by a sampled batch to the identifier of the
+	// range containing it, eg, the start key of the range. Statistics are
+	// aggregated per range identifier. The returned slice must not be
+	// modified. Defaults to the prefix of the key returned by Comparer.Split,
+	// or the key itself if the Comparer has no Split function.
+	RangeKey func(key []byte) []byte
+	// HalfLife is the half-life of the exponential decay applied to the
+	// statistics of each range, so that they reflect recent activity. Defaults
+	// to 1 minute.
+	HalfLife time.Duration
+	// MaxRanges bounds the number of ranges tracked. When a previously
+	// untracked range is written and the bound has been reached, the range
+	// with the least contention is forgotten. Defaults to 1024.
+	MaxRanges int
+}
+
+// HotRange describes the sampled write activity of a key range, as identified
+// by HotRangeSamplingOptions.RangeKey. The statistics decay exponentially with
+// HotRangeSamplingOptions.HalfLife, and are extrapolated from the sampled
+// batches.
+type HotRange struct {
+	// Key is the identifier of the range.
+	Key []byte
+	// Writes is the estimated number of keys written to the range.
+	Writes float64
+	// Bytes is the estimated number of bytes written to the range, including
+	// keys and values.
+	Bytes float64
+	// Batches is the estimated number of batches that wrote to the range.
+	Batches float64
+	// CommitWait is the estimated total time spent in the commit pipeline by
+	// batches that wrote to the range. Time spent waiting for the commit of
+	// concurrent batches, for room in the memtable, or for the WAL to sync is
+	// included, making CommitWait a measure of write contention.
+	CommitWait time.Duration
+}
+
+// HotRanges returns the n ranges with the largest CommitWait, in decreasing
+// order of CommitWait. If n is negative, all tracked ranges are returned. It
+// returns nil if sampling was not enabled through
+// Options.Experimental.HotRangeSampling. The statistics can inform load-based
+// splitting of ranges by applications built above Pebble.
+func (d *DB) HotRanges(n int) []HotRange {
+	if d.hotRanges == nil {
+		return nil
+	}
+	return d.hotRanges.hottest(n)
+}
+
+// hotRangeStats holds the decayed statistics of a single range. The
+// statistics are as of the time last.
+type hotRangeStats struct {
+	writes, bytes, batches, wait float64
+	last                         time.Time
+}
+
+func (s *hotRangeStats) decay(now time.Time, halfLife time.Duration) {
+	if elapsed := now.Sub(s.last); elapsed > 0 {
+		f := math.Exp2(-float64(elapsed) / float64(halfLife))
+		s.writes *= f
+		s.bytes *= f
+		s.batches *= f
+		s.wait *= f
+		s.last = now
+	}
+}
+
+// hotRangeTracker samples committed batches and maintains per-range write
+// statistics.
+type hotRangeTracker struct {
+	opts    HotRangeSamplingOptions
+	counter atomic.Uint64
+	timeNow func() time.Time
+
+	mu struct {
+		sync.Mutex
+		ranges map[string]*hotRangeStats
+	}
+}
+
+func newHotRangeTracker(opts HotRangeSamplingOptions, comparer *Comparer) *hotRangeTracker {
+	if opts.RangeKey == nil {
+		if split := comparer.Split; split != nil {
+			opts.RangeKey = func(key []byte) []byte { return key[:split(key)] }
+		} else {
+			opts.RangeKey = func(key []byte) []byte { return key }
+		}
+	}
+	if opts.HalfLife <= 0 {
+		opts.HalfLife = time.Minute
+	}
+	if opts.MaxRanges <= 0 {
+		opts.MaxRanges = 1024
+	}
+	t := &hotRangeTracker{opts: opts, timeNow: time.Now}
+	t.mu.ranges = make(map[string]*hotRangeStats)
+	return t
+}
+
+// hotRangeWrites holds the writes of a sampled batch to a single range.
+type hotRangeWrites struct {
+	writes, bytes int
+}
+
+// sample decides whether the batch should be sampled and, if so, returns the
+// batch's writes grouped by range. It must be called before the batch is
+// committed, as the contents of large batches are released by the commit.
+func (t *hotRangeTracker) sample(b *Batch) map[string]hotRangeWrites {
+	if t.counter.Add(1)%uint64(t.opts.SampleEvery) != 0 {
+		return nil
+	}
+	writes := make(map[string]hotRangeWrites)
+	r := b.Reader()
+	for {
+		kind, ukey, value, ok := r.Next()
+		if !ok {
+			break
+		}
+		if kind == InternalKeyKindLogData {
+			continue
+		}
+		rangeKey := string(t.opts.RangeKey(ukey))
+		w := writes[rangeKey]
+		w.writes++
+		w.bytes += len(ukey) + len(value)
+		writes[rangeKey] = w
+	}
+	return writes
+}
+
+// record records the writes of a sampled batch, which spent wait in the
+// commit pipeline.
+func (t *hotRangeTracker) record(writes map[string]hotRangeWrites, wait time.Duration) {
+	scale := float64(t.opts.SampleEvery)
+	now := t.timeNow()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for rangeKey, w := range writes {
+		s, ok := t.mu.ranges[rangeKey]
+		if !ok {
+			if len(t.mu.ranges) >= t.opts.MaxRanges {
+				t.evictLocked(now)
+			}
+			s = &hotRangeStats{last: now}
+			t.mu.ranges[rangeKey] = s
+		}
+		s.decay(now, t.opts.HalfLife)
+		s.writes += scale * float64(w.writes)
+		s.bytes += scale * float64(w.bytes)
+		s.batches += scale
+		s.wait += scale * float64(wait)
+	}
+}
+
+// evictLocked forgets the range with the least contention. t.mu must be held.
+func (t *hotRangeTracker) evictLocked(now time.Time) {
+	var coldest string
+	minWait := math.Inf(1)
+	for rangeKey, s := range t.mu.ranges {
+		s.decay(now, t.opts.HalfLife)
+		if s.wait < minWait {
+			coldest, minWait = rangeKey, s.wait
+		}
+	}
+	delete(t.mu.ranges, coldest)
+}
+
+func (t *hotRangeTracker) hottest(n int) []HotRange {
+	now := t.timeNow()
+	t.mu.Lock()
+	ranges := make([]HotRange, 0, len(t.mu.ranges))
+	for rangeKey, s := range t.mu.ranges {
+		s.decay(now, t.opts.HalfLife)
+		ranges = append(ranges, HotRange{
+			Key:        []byte(rangeKey),
+			Writes:     s.writes,
+			Bytes:      s.bytes,
+			Batches:    s.batches,
+			CommitWait: time.Duration(s.wait),
+		})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].CommitWait != ranges[j].CommitWait {
+			return ranges[i].CommitWait > ranges[j].CommitWait
+		}
+		return string(ranges[i].Key) < string(ranges[j].Key)
+	})
+	if n >= 0 && n < len(ranges) {
+		ranges = ranges[:n]
+	}
+	return ranges
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotRangeTracker(t *testing.T) {
+	tr := newHotRangeTracker(HotRangeSamplingOptions{
+		SampleEvery: 2,
+		HalfLife:    time.Second,
+		MaxRanges:   2,
+	}, testkeys.Comparer)
+	now := time.Unix(0, 0)
+	tr.timeNow = func() time.Time { return now }
+
+	newBatch := func(keys ...string) *Batch {
+		b := newBatch(nil)
+		for _, k := range keys {
+			require.NoError(t, b.Set([]byte(k), []byte("v"), nil))
+		}
+		return b
+	}
+
+	// Only every second batch is sampled.
+	require.Nil(t, tr.sample(newBatch("a@1")))
+	writes := tr.sample(newBatch("a@1", "a@2", "b@1"))
+	require.Equal(t, map[string]hotRangeWrites{
+		"a": {writes: 2, bytes: 8},
+		"b": {writes: 1, bytes: 4},
+	}, writes)
+	tr.record(writes, 10*time.Millisecond)
+
+	hot := tr.hottest(-1)
+	require.Len(t, hot, 2)
+	require.Equal(t, "a", string(hot[0].Key))
+	require.Equal(t, 4.0, hot[0].Writes)
+	require.Equal(t, 16.0, hot[0].Bytes)
+	require.Equal(t, 2.0, hot[0].Batches)
+	require.Equal(t, 20*time.Millisecond, hot[0].CommitWait)
+	require.Equal(t, "b", string(hot[1].Key))
+
+	// Statistics decay with the half-life.
+	now = now.Add(time.Second)
+	hot = tr.hottest(1)
+	require.Len(t, hot, 1)
+	require.Equal(t, 2.0, hot[0].Writes)
+	require.Equal(t, 10*time.Millisecond, hot[0].CommitWait)
+
+	// Once MaxRanges is reached, the range with the least contention is
+	// forgotten to make room for a new range.
+	require.Nil(t, tr.sample(newBatch("a@1")))
+	writes = tr.sample(newBatch("a@3"))
+	tr.record(writes, 100*time.Millisecond)
+	require.Nil(t, tr.sample(newBatch("c@1")))
+	writes = tr.sample(newBatch("c@1"))
+	tr.record(writes, time.Millisecond)
+	hot = tr.hottest(-1)
+	require.Len(t, hot, 2)
+	require.Equal(t, "a", string(hot[0].Key))
+	require.Equal(t, "c", string(hot[1].Key))
+}
+
+func TestHotRanges(t *testing.T) {
+	opts := &Options{Comparer: testkeys.Comparer, FS: vfs.NewMem()}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.Nil(t, d.HotRanges(10))
+	require.NoError(t, d.Close())
+
+	opts = &Options{Comparer: testkeys.Comparer, FS: vfs.NewMem()}
+	opts.Experimental.HotRangeSampling = HotRangeSamplingOptions{SampleEvery: 1}
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, d.Set([]byte("hot@1"), []byte("v"), Sync))
+	}
+	require.NoError(t, d.Set([]byte("cold@1"), []byte("v"), Sync))
+	b := d.NewBatch()
+	require.NoError(t, b.LogData([]byte("ignored"), nil))
+	require.NoError(t, d.Apply(b, Sync))
+
+	// The ranges are ordered by commit wait, which depends on timing, so
+	// index them by key.
+	hot := d.HotRanges(-1)
+	require.Len(t, hot, 2)
+	writes := map[string]float64{}
+	for _, r := range hot {
+		writes[string(r.Key)] = r.Writes
+	}
+	require.InDelta(t, 10, writes["hot"], 0.1)
+	require.InDelta(t, 1, writes["cold"], 0.1)
+	require.Len(t, d.HotRanges(1), 1)
+}
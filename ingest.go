@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/failpoint"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
@@ -70,12 +71,38 @@ func ingestLoad1(
 	if err != nil {
 		return nil, err
 	}
-	if tf < fmv.MinTableFormat() || tf > fmv.MaxTableFormat() {
+	maxTableFormat := fmv.MaxTableFormat()
+	if features := r.Features(); features != 0 {
+		// Previous versions fail to read tables declaring their features.
+		if fmv < FormatTableFeatures {
+			return nil, errors.Newf(
+				"pebble: table declaring features %s requires at least format major version %d (current: %d)",
+				features, FormatTableFeatures, fmv,
+			)
+		}
+		// Tables of newer formats that declare their features, which the
+		// reader verified are known, are read as tables of the maximum
+		// format (see sstable.TableFeatures).
+		if features&sstable.TableFeaturesDeclared != 0 {
+			maxTableFormat = sstable.TableFormatMax
+		}
+	}
+	if tf < fmv.MinTableFormat() || tf > maxTableFormat {
 		return nil, errors.Newf(
 			"pebble: table format %s is not within range supported at DB format major version %d, (%s,%s)",
-			tf, fmv, fmv.MinTableFormat(), fmv.MaxTableFormat(),
+			tf, fmv, fmv.MinTableFormat(), maxTableFormat,
 		)
 	}
+	// The values of a table stored in blob files are only available to the DB
+	// that wrote them.
+	if r.Properties.NumBlobValues > 0 {
+		return nil, errors.Newf("pebble: cannot ingest table with values stored in blob files")
+	}
+	if opts.Experimental.KeySchema.enabled() {
+		if err := ingestValidateSchemaKeys(opts, r); err != nil {
+			return nil, err
+		}
+	}
 
 	meta := &fileMetadata{}
 	meta.FileNum = fileNum.FileNum()
@@ -654,7 +681,34 @@ func (d *DB) Ingest(paths []string) error {
 	if d.opts.ReadOnly {
 		return ErrReadOnly
 	}
-	_, err := d.ingest(paths, ingestTargetLevel)
+	_, err := d.ingest(paths, ingestTargetLevel, nil /* applicationMetadata */)
+	return err
+}
+
+// IngestWithApplicationMetadata does the same as Ingest, and additionally
+// persists the given opaque application metadata in the MANIFEST atomically
+// with the ingested sstables. See DB.ApplicationMetadata. The ingested
+// sstables are never queued behind the memtables as a flushable, so that the
+// metadata is persisted by the time IngestWithApplicationMetadata returns.
+//
+// The metadata must be non-nil.
+func (d *DB) IngestWithApplicationMetadata(paths []string, applicationMetadata []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if applicationMetadata == nil {
+		return errors.New("pebble: nil application metadata")
+	}
+	if v := d.FormatMajorVersion(); v < FormatApplicationMetadata {
+		return errors.Errorf(
+			"pebble: application metadata requires at least format major version %d (current: %d)",
+			FormatApplicationMetadata, v,
+		)
+	}
+	_, err := d.ingest(paths, ingestTargetLevel, append([]byte{}, applicationMetadata...))
 	return err
 }
 
@@ -683,7 +737,7 @@ func (d *DB) IngestWithStats(paths []string) (IngestOperationStats, error) {
 	if d.opts.ReadOnly {
 		return IngestOperationStats{}, ErrReadOnly
 	}
-	return d.ingest(paths, ingestTargetLevel)
+	return d.ingest(paths, ingestTargetLevel, nil /* applicationMetadata */)
 }
 
 // Both DB.mu and commitPipeline.mu must be held while this is called.
@@ -794,7 +848,7 @@ func (d *DB) handleIngestAsFlushable(meta []*fileMetadata, seqNum uint64) error
 }
 
 func (d *DB) ingest(
-	paths []string, targetLevelFunc ingestTargetLevelFunc,
+	paths []string, targetLevelFunc ingestTargetLevelFunc, applicationMetadata []byte,
 ) (IngestOperationStats, error) {
 	// Allocate file numbers for all of the files being ingested and mark them as
 	// pending in order to prevent them from being deleted. Note that this causes
@@ -808,7 +862,18 @@ func (d *DB) ingest(
 	}
 	jobID := d.mu.nextJobID
 	d.mu.nextJobID++
+	// Queue behind the ingestions called before this one, if they must be
+	// sequenced in order. The ticket is released once the ingestion is assigned
+	// a sequence number, or if it fails before then.
+	ticket := d.ingestOrderEnterLocked()
 	d.mu.Unlock()
+	if ticket != nil {
+		defer func() {
+			d.mu.Lock()
+			d.ingestOrderDoneLocked(ticket)
+			d.mu.Unlock()
+		}()
+	}
 
 	// Load the metadata for all of the files being ingested. This step detects
 	// and elides empty sstables.
@@ -840,6 +905,16 @@ func (d *DB) ingest(
 	if err := d.objProvider.Sync(); err != nil {
 		return IngestOperationStats{}, err
 	}
+	if err := failpoint.Inject(failpoint.IngestBeforeApply); err != nil {
+		if err2 := ingestCleanup(d.objProvider, meta); err2 != nil {
+			d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
+		}
+		return IngestOperationStats{}, err
+	}
+
+	// Wait for the overlapping ingestions called before this one to be
+	// sequenced.
+	d.ingestOrderWait(ticket, meta)
 
 	// metaFlushableOverlaps is a slice parallel to meta indicating which of the
 	// ingested sstables overlap some table in the flushable queue. It's used to
@@ -854,6 +929,7 @@ func (d *DB) ingest(
 
 		d.mu.Lock()
 		defer d.mu.Unlock()
+		d.ingestOrderDoneLocked(ticket)
 
 		// Check to see if any files overlap with any of the memtables. The queue
 		// is ordered from oldest to newest with the mutable memtable being the
@@ -908,8 +984,11 @@ func (d *DB) ingest(
 			return
 		}
 		// The ingestion overlaps with some entry in the flushable queue.
+		// Application metadata must be persisted before the ingestion
+		// completes, so it's incompatible with ingesting as a flushable.
 		if d.mu.formatVers.vers < FormatFlushableIngest ||
 			d.opts.Experimental.DisableIngestAsFlushable() ||
+			applicationMetadata != nil ||
 			(len(d.mu.mem.queue) > d.opts.MemTableStopWritesThreshold-1) {
 			// We're not able to ingest as a flushable,
 			// so we must synchronously flush.
@@ -966,7 +1045,7 @@ func (d *DB) ingest(
 
 		// Assign the sstables to the correct level in the LSM and apply the
 		// version edit.
-		ve, err = d.ingestApply(jobID, meta, targetLevelFunc, mut)
+		ve, err = d.ingestApply(jobID, meta, targetLevelFunc, mut, applicationMetadata)
 	}
 
 	d.commit.AllocateSeqNum(len(meta), prepare, apply)
@@ -1049,13 +1128,18 @@ type ingestTargetLevelFunc func(
 ) (int, error)
 
 func (d *DB) ingestApply(
-	jobID int, meta []*fileMetadata, findTargetLevel ingestTargetLevelFunc, mut *memTable,
+	jobID int,
+	meta []*fileMetadata,
+	findTargetLevel ingestTargetLevelFunc,
+	mut *memTable,
+	applicationMetadata []byte,
 ) (*versionEdit, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	ve := &versionEdit{
-		NewFiles: make([]newFileEntry, len(meta)),
+		NewFiles:            make([]newFileEntry, len(meta)),
+		ApplicationMetadata: applicationMetadata,
 	}
 	metrics := make(map[int]*LevelMetrics)
 
@@ -1108,6 +1192,7 @@ func (d *DB) ingestApply(
 	}
 	d.updateReadStateLocked(d.opts.DebugCheck)
 	d.updateTableStatsLocked(ve.NewFiles)
+	d.addIngestCompactionHintsLocked(ve.NewFiles)
 	// The ingestion may have pushed a level over the threshold for compaction,
 	// so check to see if one is necessary and schedule it.
 	d.maybeScheduleCompaction()
@@ -1144,21 +1229,34 @@ func (d *DB) maybeValidateSSTablesLocked(newFiles []newFileEntry) {
 func (d *DB) shouldValidateSSTablesLocked() bool {
 	return !d.mu.tableValidation.validating &&
 		d.closed.Load() == nil &&
-		d.opts.Experimental.ValidateOnIngest &&
-		len(d.mu.tableValidation.pending) > 0
+		(len(d.mu.tableValidation.pending) > 0 || len(d.mu.tableValidation.written) > 0)
 }
 
 // validateSSTables runs a round of validation on the tables in the pending
-// queue.
+// queues.
 func (d *DB) validateSSTables() {
 	d.mu.Lock()
+	defer d.mu.Unlock()
 	if !d.shouldValidateSSTablesLocked() {
-		d.mu.Unlock()
 		return
 	}
+	d.validateSSTablesLocked()
+	if d.shouldValidateSSTablesLocked() {
+		go d.validateSSTables()
+	}
+}
 
+// validateSSTablesLocked runs a round of validation on the tables in the
+// pending queues.
+//
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired during the course of this method.
+func (d *DB) validateSSTablesLocked() {
 	pending := d.mu.tableValidation.pending
 	d.mu.tableValidation.pending = nil
+	written := d.mu.tableValidation.written
+	d.mu.tableValidation.written = nil
+	writtenSeq := d.mu.tableValidation.writtenSeq
 	d.mu.tableValidation.validating = true
 	jobID := d.mu.nextJobID
 	d.mu.nextJobID++
@@ -1209,11 +1307,36 @@ func (d *DB) validateSSTables() {
 	}
 	rs.unref()
 
+	// Validate the tables written by flushes and compactions. Unlike ingested
+	// tables, these are validated even if they have since been compacted away:
+	// the deletion of a written table that became obsolete is deferred until
+	// it has been validated.
+	var validated, failed int64
+	for _, f := range written {
+		err := d.tableCache.withReader(
+			f.Meta.PhysicalMeta(), func(r *sstable.Reader) error {
+				return r.ValidateSampledBlockChecksums(d.opts.Experimental.ValidateOnWriteSampleRate)
+			})
+		validated++
+		if err != nil {
+			failed++
+		}
+		d.opts.EventListener.TableValidated(TableValidatedInfo{
+			JobID: jobID,
+			Meta:  f.Meta,
+			Err:   err,
+		})
+	}
+
 	d.mu.Lock()
-	defer d.mu.Unlock()
+	if len(written) > 0 {
+		d.mu.versions.metrics.TableValidation.Count += validated
+		d.mu.versions.metrics.TableValidation.FailedCount += failed
+		// Release the tables held for the validated tables, even if some
+		// failed validation: the failures have been reported above.
+		d.mu.tableValidation.validatedSeq = writtenSeq
+		d.deleteObsoleteFiles(jobID, true /* waitForOngoing */)
+	}
 	d.mu.tableValidation.validating = false
 	d.mu.tableValidation.cond.Broadcast()
-	if d.shouldValidateSSTablesLocked() {
-		go d.validateSSTables()
-	}
 }
@@ -0,0 +1,427 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/bytealloc"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// IngestFromIteratorOptions configures DB.IngestFromIterator.
+type IngestFromIteratorOptions struct {
+	// TargetFileSize is the approximate size of the sstables constructed for
+	// ingestion, measured as the uncompressed size of the keys and values
+	// written to them. Defaults to the target file size of the bottommost
+	// level.
+	TargetFileSize int64
+	// Parallelism is the maximum number of sstables constructed concurrently.
+	// The keys and values of every sstable under construction, plus those of
+	// the sstable currently being filled by the producer, are buffered in
+	// memory. Defaults to Options.MaxConcurrentCompactions.
+	Parallelism int
+	// TempDir is the directory in which the sstables are constructed before
+	// they are ingested. It must reside on the DB's filesystem. Defaults to
+	// the DB's sstable directory (see Options.TableDir).
+	TempDir string
+}
+
+func (o *IngestFromIteratorOptions) ensureDefaults(d *DB) {
+	if o.TargetFileSize <= 0 {
+		o.TargetFileSize = d.opts.Level(numLevels - 1).TargetFileSize
+	}
+	if o.Parallelism <= 0 {
+		o.Parallelism = d.opts.MaxConcurrentCompactions()
+	}
+	if o.TempDir == "" {
+		o.TempDir = d.tableDirname
+	}
+}
+
+// IngestFromIterator constructs sstables from the keys emitted by produce and
+// ingests them into the DB. It is a convenience for bulk loading data that
+// does not already reside in sstables, such as the output of an arbitrary
+// iterator. The emitted keys are split into sstables of approximately
+// IngestFromIteratorOptions.TargetFileSize, which are written concurrently
+// while produce continues to emit keys, using the DB's options for the
+// bottommost level.
+// Once produce returns, the sstables are ingested atomically, with the
+// semantics of DB.Ingest.
+//
+// Keys must be emitted in increasing order of their (start) user key. Point
+// keys must be strictly increasing. Range deletions and range keys may
+// overlap one another; they are truncated at sstable boundaries as necessary.
+// Since all of the emitted keys are ingested at the same sequence number,
+// range deletions do not delete point keys emitted by produce.
+//
+// If produce returns an error, or constructing an sstable fails, nothing is
+// ingested and the error is returned.
+func (d *DB) IngestFromIterator(
+	opts IngestFromIteratorOptions, produce func(w *IngestIteratorWriter) error,
+) (IngestOperationStats, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return IngestOperationStats{}, ErrReadOnly
+	}
+	opts.ensureDefaults(d)
+
+	d.mu.Lock()
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	d.mu.Unlock()
+
+	writerOpts := d.opts.MakeWriterOptions(numLevels-1, d.FormatMajorVersion().MaxTableFormat())
+	d.stampProvenance(&writerOpts)
+	if !d.compressionDictSupported() {
+		writerOpts.CompressionDict = nil
+	}
+	w := &IngestIteratorWriter{
+		d:          d,
+		opts:       opts,
+		jobID:      jobID,
+		sem:        make(chan struct{}, opts.Parallelism),
+		cur:        &ingestChunk{},
+		writerOpts: writerOpts,
+	}
+	err := produce(w)
+	if err == nil {
+		err = w.finish()
+	}
+	w.wg.Wait()
+	if err == nil {
+		err = w.workerErr()
+	}
+
+	var paths []string
+	for _, c := range w.chunks {
+		if c.written {
+			paths = append(paths, c.path)
+		}
+	}
+	if err != nil {
+		for _, path := range paths {
+			if err2 := d.opts.FS.Remove(path); err2 != nil {
+				d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
+			}
+		}
+		return IngestOperationStats{}, err
+	}
+	if len(paths) == 0 {
+		return IngestOperationStats{}, nil
+	}
+	return d.IngestWithStats(paths)
+}
+
+// IngestIteratorWriter receives the keys emitted by the producer passed to
+// DB.IngestFromIterator. The byte slices passed to its methods are copied and
+// may be reused by the caller once the method returns.
+type IngestIteratorWriter struct {
+	d          *DB
+	opts       IngestFromIteratorOptions
+	writerOpts sstable.WriterOptions
+	jobID      int
+
+	// cur is the chunk currently being filled.
+	cur *ingestChunk
+	// lastKey is the start key of the most recently emitted key, and lastPoint
+	// the most recently emitted point key.
+	lastKey   []byte
+	lastPoint []byte
+	// rangeDels and rangeKeys hold the range deletions and range keys that
+	// have been emitted but not yet assigned to a chunk, because they may
+	// extend beyond the current chunk's end. rangeDels are coalesced so that
+	// they never overlap.
+	rangeDels []ingestSpan
+	rangeKeys []ingestSpan
+
+	chunks []*ingestChunk
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	mu     struct {
+		sync.Mutex
+		err error
+	}
+}
+
+// ingestSpan is a range deletion or range key pending assignment to a chunk.
+type ingestSpan struct {
+	kind          InternalKeyKind
+	start, end    []byte
+	suffix, value []byte
+}
+
+// ingestPoint is a point key buffered within a chunk.
+type ingestPoint struct {
+	kind       InternalKeyKind
+	key, value []byte
+}
+
+// ingestChunk buffers the keys of a single sstable under construction.
+type ingestChunk struct {
+	alloc     bytealloc.A
+	points    []ingestPoint
+	rangeDels []ingestSpan
+	rangeKeys []ingestSpan
+	size      int64
+
+	path    string
+	written bool
+}
+
+func (c *ingestChunk) empty() bool {
+	return len(c.points) == 0 && len(c.rangeDels) == 0 && len(c.rangeKeys) == 0
+}
+
+// Set emits a point key setting key to value.
+func (w *IngestIteratorWriter) Set(key, value []byte) error {
+	return w.addPoint(InternalKeyKindSet, key, value)
+}
+
+// Delete emits a point tombstone for key.
+func (w *IngestIteratorWriter) Delete(key []byte) error {
+	return w.addPoint(InternalKeyKindDelete, key, nil)
+}
+
+// Merge emits a merge operand for key.
+func (w *IngestIteratorWriter) Merge(key, value []byte) error {
+	return w.addPoint(InternalKeyKindMerge, key, value)
+}
+
+// DeleteRange emits a range deletion of [start, end).
+func (w *IngestIteratorWriter) DeleteRange(start, end []byte) error {
+	return w.addSpan(ingestSpan{kind: InternalKeyKindRangeDelete, start: start, end: end})
+}
+
+// RangeKeySet emits a range key setting suffix to value over [start, end).
+func (w *IngestIteratorWriter) RangeKeySet(start, end, suffix, value []byte) error {
+	return w.addSpan(ingestSpan{
+		kind: InternalKeyKindRangeKeySet, start: start, end: end, suffix: suffix, value: value,
+	})
+}
+
+// RangeKeyUnset emits a range key unsetting suffix over [start, end).
+func (w *IngestIteratorWriter) RangeKeyUnset(start, end, suffix []byte) error {
+	return w.addSpan(ingestSpan{
+		kind: InternalKeyKindRangeKeyUnset, start: start, end: end, suffix: suffix,
+	})
+}
+
+// RangeKeyDelete emits a range key deletion of [start, end).
+func (w *IngestIteratorWriter) RangeKeyDelete(start, end []byte) error {
+	return w.addSpan(ingestSpan{kind: InternalKeyKindRangeKeyDelete, start: start, end: end})
+}
+
+func (w *IngestIteratorWriter) addPoint(kind InternalKeyKind, key, value []byte) error {
+	if err := w.workerErr(); err != nil {
+		return err
+	}
+	if w.lastPoint != nil && w.d.cmp(key, w.lastPoint) <= 0 {
+		return errors.Errorf("pebble: keys must be added in strictly increasing order: %s, %s",
+			w.d.opts.Comparer.FormatKey(w.lastPoint), w.d.opts.Comparer.FormatKey(key))
+	}
+	if err := w.checkStart(key); err != nil {
+		return err
+	}
+	w.maybeCut(key)
+	c := w.cur
+	var p ingestPoint
+	p.kind = kind
+	c.alloc, p.key = c.alloc.Copy(key)
+	if value != nil {
+		c.alloc, p.value = c.alloc.Copy(value)
+	}
+	c.points = append(c.points, p)
+	c.size += int64(len(key)+len(value)) + base.InternalTrailerLen
+	w.lastPoint = p.key
+	w.lastKey = p.key
+	return nil
+}
+
+func (w *IngestIteratorWriter) addSpan(s ingestSpan) error {
+	if err := w.workerErr(); err != nil {
+		return err
+	}
+	if w.d.cmp(s.start, s.end) >= 0 {
+		return errors.Errorf("pebble: empty span [%s, %s)",
+			w.d.opts.Comparer.FormatKey(s.start), w.d.opts.Comparer.FormatKey(s.end))
+	}
+	if s.kind != InternalKeyKindRangeDelete && w.d.opts.Comparer.Split == nil {
+		return errors.New("pebble: range keys require a Comparer with a Split function")
+	}
+	if err := w.checkStart(s.start); err != nil {
+		return err
+	}
+	w.maybeCut(s.start)
+
+	// The pending spans outlive the chunk they were emitted into, so they are
+	// not allocated from the chunk's buffer.
+	s.start = append([]byte(nil), s.start...)
+	s.end = append([]byte(nil), s.end...)
+	s.suffix = append([]byte(nil), s.suffix...)
+	s.value = append([]byte(nil), s.value...)
+	w.cur.size += int64(len(s.start) + len(s.end) + len(s.suffix) + len(s.value))
+	w.lastKey = s.start
+	if s.kind == InternalKeyKindRangeDelete {
+		// Range deletions within an sstable must be fragmented. All of the
+		// emitted range deletions share a sequence number, so overlapping
+		// range deletions are coalesced.
+		if n := len(w.rangeDels); n > 0 && w.d.cmp(s.start, w.rangeDels[n-1].end) <= 0 {
+			if w.d.cmp(s.end, w.rangeDels[n-1].end) > 0 {
+				w.rangeDels[n-1].end = s.end
+			}
+			return nil
+		}
+		w.rangeDels = append(w.rangeDels, s)
+		return nil
+	}
+	w.rangeKeys = append(w.rangeKeys, s)
+	return nil
+}
+
+func (w *IngestIteratorWriter) checkStart(key []byte) error {
+	if w.lastKey != nil && w.d.cmp(key, w.lastKey) < 0 {
+		return errors.Errorf("pebble: keys must be added in increasing order of start key: %s, %s",
+			w.d.opts.Comparer.FormatKey(w.lastKey), w.d.opts.Comparer.FormatKey(key))
+	}
+	return nil
+}
+
+// maybeCut finishes the current chunk if it has reached the target file size
+// and may end before key, which is the start key of the next emitted key.
+func (w *IngestIteratorWriter) maybeCut(key []byte) {
+	if w.cur.size < w.opts.TargetFileSize {
+		return
+	}
+	// A point key with the same user key as key would overlap the next chunk.
+	if w.lastPoint != nil && w.d.cmp(w.lastPoint, key) >= 0 {
+		return
+	}
+	w.assignSpans(key)
+	w.schedule(w.cur)
+	w.cur = &ingestChunk{}
+}
+
+// assignSpans moves the portions of the pending spans before end (or all of
+// them, if end is nil) into the current chunk. The portions of the spans at
+// or after end remain pending.
+func (w *IngestIteratorWriter) assignSpans(end []byte) {
+	split := func(pending []ingestSpan, dst *[]ingestSpan) []ingestSpan {
+		remaining := pending[:0]
+		for _, s := range pending {
+			if end == nil || w.d.cmp(s.end, end) <= 0 {
+				*dst = append(*dst, s)
+				continue
+			}
+			if w.d.cmp(s.start, end) < 0 {
+				head := s
+				head.end = end
+				*dst = append(*dst, head)
+				s.start = end
+			}
+			remaining = append(remaining, s)
+		}
+		return remaining
+	}
+	w.rangeDels = split(w.rangeDels, &w.cur.rangeDels)
+	w.rangeKeys = split(w.rangeKeys, &w.cur.rangeKeys)
+}
+
+// finish assigns the remaining spans to the current chunk and schedules it.
+func (w *IngestIteratorWriter) finish() error {
+	if err := w.workerErr(); err != nil {
+		return err
+	}
+	w.assignSpans(nil)
+	if !w.cur.empty() {
+		w.schedule(w.cur)
+	}
+	w.cur = nil
+	return nil
+}
+
+// schedule constructs the sstable for chunk c asynchronously, blocking while
+// Parallelism sstables are already under construction.
+func (w *IngestIteratorWriter) schedule(c *ingestChunk) {
+	c.path = w.d.opts.FS.PathJoin(w.opts.TempDir,
+		fmt.Sprintf("ingest-%d-%06d.sst", w.jobID, len(w.chunks)))
+	w.chunks = append(w.chunks, c)
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-w.sem }()
+		if err := w.writeChunk(c); err != nil {
+			w.mu.Lock()
+			w.mu.err = firstError(w.mu.err, err)
+			w.mu.Unlock()
+		}
+	}()
+}
+
+func (w *IngestIteratorWriter) writeChunk(c *ingestChunk) error {
+	f, err := w.d.opts.FS.Create(c.path)
+	if err != nil {
+		return err
+	}
+	// Once the file is created, it is removed if the ingestion fails, even if
+	// the sstable is incomplete.
+	c.written = true
+	sw := sstable.NewWriter(objstorageprovider.NewFileWritable(f), w.writerOpts)
+	if err := writeIngestChunk(sw, c); err != nil {
+		_ = sw.Close()
+		return err
+	}
+	return sw.Close()
+}
+
+func writeIngestChunk(w *sstable.Writer, c *ingestChunk) error {
+	for _, p := range c.points {
+		var err error
+		switch p.kind {
+		case InternalKeyKindSet:
+			err = w.Set(p.key, p.value)
+		case InternalKeyKindDelete:
+			err = w.Delete(p.key)
+		case InternalKeyKindMerge:
+			err = w.Merge(p.key, p.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range c.rangeDels {
+		if err := w.DeleteRange(s.start, s.end); err != nil {
+			return err
+		}
+	}
+	for _, s := range c.rangeKeys {
+		var err error
+		switch s.kind {
+		case InternalKeyKindRangeKeySet:
+			err = w.RangeKeySet(s.start, s.end, s.suffix, s.value)
+		case InternalKeyKindRangeKeyUnset:
+			err = w.RangeKeyUnset(s.start, s.end, s.suffix)
+		case InternalKeyKindRangeKeyDelete:
+			err = w.RangeKeyDelete(s.start, s.end)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *IngestIteratorWriter) workerErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.mu.err
+}
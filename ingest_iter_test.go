@@ -0,0 +1,140 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestFromIterator(t *testing.T) {
+	fs := vfs.NewMem()
+	d, err := Open("", &Options{
+		Comparer:           testkeys.Comparer,
+		FS:                 fs,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Existing keys, some of which are deleted by the ingested range deletion.
+	for _, k := range []string{"a", "k050x", "k150x", "z"} {
+		require.NoError(t, d.Set([]byte(k), []byte("old"), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	const n = 200
+	stats, err := d.IngestFromIterator(IngestFromIteratorOptions{
+		TargetFileSize: 256,
+		Parallelism:    3,
+	}, func(w *IngestIteratorWriter) error {
+		for i := 0; i < n; i++ {
+			k := []byte(fmt.Sprintf("k%03d", i))
+			if i == 10 {
+				// The range deletion and range key span many sstables.
+				if err := w.DeleteRange(k, []byte("k180")); err != nil {
+					return err
+				}
+				if err := w.RangeKeySet(k, []byte("k190"), []byte("@5"), []byte("rk")); err != nil {
+					return err
+				}
+			}
+			if err := w.Set(k, []byte(fmt.Sprintf("v%03d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Less(t, uint64(0), stats.Bytes)
+	var files int64
+	for _, l := range d.Metrics().Levels {
+		files += l.NumFiles
+	}
+	require.Less(t, int64(10), files)
+
+	// The temporary sstables were moved into the DB.
+	ls, err := fs.List("")
+	require.NoError(t, err)
+	for _, name := range ls {
+		require.NotContains(t, name, "ingest-")
+	}
+
+	iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+	var points []string
+	var rangeKeySpans int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if hasPoint, _ := iter.HasPointAndRange(); hasPoint {
+			points = append(points, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		if iter.RangeKeyChanged() {
+			start, end := iter.RangeBounds()
+			if len(iter.RangeKeys()) > 0 {
+				// The range key fragments are defragmented by the iterator.
+				require.Equal(t, "k010", string(start))
+				require.Equal(t, "k190", string(end))
+				rangeKeySpans++
+			}
+		}
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 1, rangeKeySpans)
+
+	// The ingested range deletion deleted k050x and k150x, but not the
+	// ingested keys, which share its sequence number.
+	expected := []string{"a=old"}
+	for i := 0; i < n; i++ {
+		expected = append(expected, fmt.Sprintf("k%03d=v%03d", i, i))
+	}
+	expected = append(expected, "z=old")
+	require.Equal(t, expected, points)
+}
+
+func TestIngestFromIteratorErrors(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer:           testkeys.Comparer,
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	_, err = d.IngestFromIterator(IngestFromIteratorOptions{}, func(w *IngestIteratorWriter) error {
+		require.NoError(t, w.Set([]byte("b"), nil))
+		return w.Set([]byte("a"), nil)
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "strictly increasing order")
+
+	_, err = d.IngestFromIterator(IngestFromIteratorOptions{}, func(w *IngestIteratorWriter) error {
+		require.NoError(t, w.DeleteRange([]byte("b"), []byte("c")))
+		return w.DeleteRange([]byte("a"), []byte("c"))
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "increasing order of start key")
+
+	// An error returned by the producer aborts the ingestion, and the
+	// sstables constructed so far are removed.
+	_, err = d.IngestFromIterator(IngestFromIteratorOptions{TargetFileSize: 1}, func(w *IngestIteratorWriter) error {
+		for _, k := range []string{"a", "b", "c"} {
+			require.NoError(t, w.Set([]byte(k), []byte(k)))
+		}
+		return errors.New("boom")
+	})
+	require.EqualError(t, err, "boom")
+	ls, err := d.opts.FS.List("")
+	require.NoError(t, err)
+	for _, name := range ls {
+		require.NotContains(t, name, "ingest-")
+	}
+	_, closer, err := d.Get([]byte("a"))
+	require.True(t, errors.Is(err, ErrNotFound))
+	require.Nil(t, closer)
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// ingestTicket tracks an ingestion that has not yet been assigned a sequence
+// number when Options.Experimental.StrictIngestOrder is enabled. See
+// DB.ingestOrderWait.
+type ingestTicket struct {
+	// loaded is set once the bounds of the ingested sstables are known.
+	loaded bool
+	// smallest and largest are the smallest and largest user keys of the
+	// ingested sstables. The largest key is treated as inclusive, even if it's
+	// an exclusive sentinel.
+	smallest, largest []byte
+	// sequenced is set once the ingestion has been assigned a sequence number,
+	// or has failed.
+	sequenced bool
+}
+
+// ingestOrderEnterLocked returns the ticket of an ingestion that was just
+// called, or nil if Options.Experimental.StrictIngestOrder is disabled. The
+// ticket must be released with ingestOrderDoneLocked. DB.mu must be held.
+func (d *DB) ingestOrderEnterLocked() *ingestTicket {
+	if !d.opts.Experimental.StrictIngestOrder {
+		return nil
+	}
+	t := &ingestTicket{}
+	d.mu.ingestOrder.pending = append(d.mu.ingestOrder.pending, t)
+	return t
+}
+
+// ingestOrderWait records the bounds of the given ingested sstables, which
+// are sorted and non-overlapping, and waits until all the ingestions called
+// before the ingestion of the ticket that overlap it have been assigned
+// sequence numbers. The ingestions whose bounds aren't yet known are assumed
+// to overlap. DB.mu must not be held.
+func (d *DB) ingestOrderWait(t *ingestTicket, meta []*fileMetadata) {
+	if t == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t.smallest = meta[0].Smallest.UserKey
+	t.largest = meta[0].Largest.UserKey
+	for _, m := range meta[1:] {
+		if d.cmp(m.Largest.UserKey, t.largest) > 0 {
+			t.largest = m.Largest.UserKey
+		}
+	}
+	t.loaded = true
+	d.mu.ingestOrder.cond.Broadcast()
+
+	for d.ingestOrderBlockedLocked(t) {
+		d.mu.ingestOrder.cond.Wait()
+	}
+}
+
+// ingestOrderBlockedLocked returns true if an ingestion called before the
+// ingestion of the given ticket may overlap it and hasn't been sequenced.
+// DB.mu must be held.
+func (d *DB) ingestOrderBlockedLocked(t *ingestTicket) bool {
+	for _, p := range d.mu.ingestOrder.pending {
+		if p == t {
+			return false
+		}
+		if !p.loaded ||
+			(d.cmp(p.smallest, t.largest) <= 0 && d.cmp(t.smallest, p.largest) <= 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// ingestOrderDoneLocked releases the ticket of an ingestion once it has been
+// assigned a sequence number or has failed, unblocking the ingestions called
+// after it. It's a no-op if the ticket was already released, or is nil.
+// DB.mu must be held.
+func (d *DB) ingestOrderDoneLocked(t *ingestTicket) {
+	if t == nil || t.sequenced {
+		return
+	}
+	t.sequenced = true
+	pending := d.mu.ingestOrder.pending
+	for i := range pending {
+		if pending[i] == t {
+			d.mu.ingestOrder.pending = append(pending[:i:i], pending[i+1:]...)
+			break
+		}
+	}
+	d.mu.ingestOrder.cond.Broadcast()
+}
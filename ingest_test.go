@@ -7,6 +7,7 @@ package pebble
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
@@ -237,6 +238,49 @@ func TestIngestLoadInvalid(t *testing.T) {
 	}
 }
 
+func TestIngestNewerTableFormat(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+		TableFormat: sstable.TableFormatPebblev3,
+	})
+	require.NoError(t, w.Set([]byte("a"), []byte("1")))
+	require.NoError(t, w.Close())
+
+	// Rewrite the version of the footer, which precedes the 8-byte magic
+	// number, as if the table had been written by a newer version declaring
+	// that it only uses known features.
+	data, err := mem.Open("ext")
+	require.NoError(t, err)
+	buf, err := io.ReadAll(data)
+	require.NoError(t, err)
+	require.NoError(t, data.Close())
+	_, maxVersion := sstable.TableFormatMax.AsTuple()
+	binary.LittleEndian.PutUint32(buf[len(buf)-12:],
+		(maxVersion+1)|uint32(sstable.TableFeaturesDeclared)<<16)
+
+	ingest := func(fmv FormatMajorVersion) error {
+		d, err := Open("", &Options{FS: vfs.NewMem(), FormatMajorVersion: fmv})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+		f, err := d.opts.FS.Create("ext")
+		require.NoError(t, err)
+		_, err = f.Write(buf)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		if err := d.Ingest([]string{"ext"}); err != nil {
+			return err
+		}
+		v, closer, err := d.Get([]byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, "1", string(v))
+		return closer.Close()
+	}
+	require.Error(t, ingest(FormatTableFeatures-1))
+	require.NoError(t, ingest(FormatTableFeatures))
+}
+
 func TestIngestSortAndVerify(t *testing.T) {
 	comparers := map[string]Compare{
 		"default": DefaultComparer.Compare,
@@ -1008,6 +1052,96 @@ func TestIngestIdempotence(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+// blockingOpenFS is a vfs.FS whose Open of the given path blocks until the
+// channel is closed.
+type blockingOpenFS struct {
+	vfs.FS
+	path    string
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (fs *blockingOpenFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	if name == fs.path {
+		close(fs.blocked)
+		<-fs.release
+	}
+	return fs.FS.Open(name, opts...)
+}
+
+func TestIngestStrictOrder(t *testing.T) {
+	mem := vfs.NewMem()
+	fs := &blockingOpenFS{
+		FS:      mem,
+		path:    "a",
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	writeSST := func(path, key, value string) {
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+		require.NoError(t, w.Set([]byte(key), []byte(value)))
+		require.NoError(t, w.Close())
+	}
+	writeSST("a", "k", "a")
+	writeSST("b", "k", "b")
+	writeSST("c", "z", "c")
+
+	opts := &Options{FS: fs, Logger: panicLogger{}}
+	opts.Experimental.StrictIngestOrder = true
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// The ingestion of a is called first, but is held up while loading a.
+	errA := make(chan error, 1)
+	go func() { errA <- d.Ingest([]string{"a"}) }()
+	<-fs.blocked
+
+	// The ingestion of b overlaps the ingestion of a, so it must wait for it
+	// to be sequenced.
+	errB := make(chan error, 1)
+	go func() { errB <- d.Ingest([]string{"b"}) }()
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		pending := d.mu.ingestOrder.pending
+		return len(pending) == 2 && pending[1].loaded
+	}, 10*time.Second, time.Millisecond)
+	select {
+	case err := <-errB:
+		t.Fatalf("ingestion of b completed before the ingestion of a: %v", err)
+	default:
+	}
+
+	// The ingestion of c doesn't overlap the ingestion of b, but the bounds of
+	// the ingestion of a aren't known, so it also waits.
+	errC := make(chan error, 1)
+	go func() { errC <- d.Ingest([]string{"c"}) }()
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		pending := d.mu.ingestOrder.pending
+		return len(pending) == 3 && pending[2].loaded
+	}, 10*time.Second, time.Millisecond)
+
+	close(fs.release)
+	require.NoError(t, <-errA)
+	require.NoError(t, <-errB)
+	require.NoError(t, <-errC)
+
+	// The value ingested by the later call shadows the earlier one.
+	v, closer, err := d.Get([]byte("k"))
+	require.NoError(t, err)
+	require.Equal(t, "b", string(v))
+	require.NoError(t, closer.Close())
+
+	d.mu.Lock()
+	require.Empty(t, d.mu.ingestOrder.pending)
+	d.mu.Unlock()
+}
+
 func TestIngestCompact(t *testing.T) {
 	mem := vfs.NewMem()
 	lel := MakeLoggingEventListener(&base.InMemLogger{})
@@ -1316,12 +1450,12 @@ func TestIngestFlushQueuedLargeBatch(t *testing.T) {
 	// size in order to more easily create a situation where a large batch is
 	// queued but not automatically flushed.
 	d.mu.Lock()
-	d.largeBatchThreshold = d.opts.MemTableSize / 8
+	d.largeBatchThreshold.Store(int64(d.opts.MemTableSize / 8))
 	d.mu.Unlock()
 
 	// Set a record with a large value. This will be transformed into a large
 	// batch and placed in the flushable queue.
-	require.NoError(t, d.Set([]byte("a"), bytes.Repeat([]byte("v"), d.largeBatchThreshold), nil))
+	require.NoError(t, d.Set([]byte("a"), bytes.Repeat([]byte("v"), int(d.largeBatchThreshold.Load())), nil))
 
 	ingest := func(keys ...string) {
 		t.Helper()
@@ -130,6 +130,16 @@ type Comparer struct {
 	Successor          Successor
 	ImmediateSuccessor ImmediateSuccessor
 
+	// CompareTimestamps, if set, declares that the non-empty suffixes of the
+	// keys (see Split) are timestamps, enabling reads at a timestamp and the
+	// garbage collection of old versions (see IterOptions.ReadTimestamp and
+	// Options.Experimental.Timestamps in package pebble). It returns -1, 0 or
+	// +1 if the timestamp a is older than, as old as, or newer than b.
+	// Compare must order the versions of a key, with the same prefix, from
+	// newest to oldest. The keys without a suffix aren't versioned, and are
+	// unaffected by timestamps.
+	CompareTimestamps func(a, b []byte) int
+
 	// Name is the name of the comparer.
 	//
 	// The Level-DB on-disk format stores the comparer name, and opening a
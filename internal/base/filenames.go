@@ -58,6 +58,9 @@ const (
 	FileTypeOptions
 	FileTypeOldTemp
 	FileTypeTemp
+	FileTypeBlob
+	FileTypeIdentity
+	FileTypeLockOwner
 )
 
 // MakeFilename builds a filename from components.
@@ -79,6 +82,12 @@ func MakeFilename(fileType FileType, dfn DiskFileNum) string {
 		return fmt.Sprintf("CURRENT.%s.dbtmp", dfn)
 	case FileTypeTemp:
 		return fmt.Sprintf("temporary.%s.dbtmp", dfn)
+	case FileTypeBlob:
+		return fmt.Sprintf("%s.blob", dfn)
+	case FileTypeIdentity:
+		return "IDENTITY"
+	case FileTypeLockOwner:
+		return "LOCK-OWNER"
 	}
 	panic("unreachable")
 }
@@ -96,6 +105,10 @@ func ParseFilename(fs vfs.FS, filename string) (fileType FileType, dfn DiskFileN
 		return FileTypeCurrent, DiskFileNum{0}, true
 	case filename == "LOCK":
 		return FileTypeLock, DiskFileNum{0}, true
+	case filename == "IDENTITY":
+		return FileTypeIdentity, DiskFileNum{0}, true
+	case filename == "LOCK-OWNER":
+		return FileTypeLockOwner, DiskFileNum{0}, true
 	case strings.HasPrefix(filename, "MANIFEST-"):
 		dfn, ok = parseDiskFileNum(filename[len("MANIFEST-"):])
 		if !ok {
@@ -136,6 +149,8 @@ func ParseFilename(fs vfs.FS, filename string) (fileType FileType, dfn DiskFileN
 			return FileTypeTable, dfn, true
 		case "log":
 			return FileTypeLog, dfn, true
+		case "blob":
+			return FileTypeBlob, dfn, true
 		}
 	}
 	return 0, dfn, false
@@ -23,11 +23,16 @@ func TestParseFilename(t *testing.T) {
 		"abcdef.log":             false,
 		"000001ldb":              false,
 		"000001.sst":             true,
+		"000001.blob":            true,
 		"CURRENT":                true,
 		"CURRaNT":                false,
 		"LOCK":                   true,
 		"xLOCK":                  false,
 		"x.LOCK":                 false,
+		"IDENTITY":               true,
+		"IDENTITY.dbtmp":         false,
+		"LOCK-OWNER":             true,
+		"LOCK-OWNER.dbtmp":       false,
 		"MANIFEST":               false,
 		"MANIFEST123456":         false,
 		"MANIFEST-":              false,
@@ -54,9 +59,11 @@ func TestParseFilename(t *testing.T) {
 
 func TestFilenameRoundTrip(t *testing.T) {
 	testCases := map[FileType]bool{
-		// CURRENT and LOCK files aren't numbered.
-		FileTypeCurrent: false,
-		FileTypeLock:    false,
+		// CURRENT, LOCK, IDENTITY and LOCK-OWNER files aren't numbered.
+		FileTypeCurrent:   false,
+		FileTypeLock:      false,
+		FileTypeIdentity:  false,
+		FileTypeLockOwner: false,
 		// The remaining file types are numbered.
 		FileTypeLog:      true,
 		FileTypeManifest: true,
@@ -64,6 +71,7 @@ func TestFilenameRoundTrip(t *testing.T) {
 		FileTypeOptions:  true,
 		FileTypeOldTemp:  true,
 		FileTypeTemp:     true,
+		FileTypeBlob:     true,
 	}
 	fs := vfs.NewMem()
 	for fileType, numbered := range testCases {
@@ -103,5 +111,5 @@ func TestMustExist(t *testing.T) {
 	MustExist(fs, filename, &buf, err)
 	require.Equal(t, `000000.sst:
 file does not exist
-directory contains 10 files, 3 unknown, 1 tables, 1 logs, 1 manifests`, buf.buf.String())
+directory contains 10 files, 2 unknown, 1 tables, 1 logs, 1 manifests`, buf.buf.String())
 }
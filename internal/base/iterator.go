@@ -356,6 +356,18 @@ type InternalIteratorStats struct {
 	// TODO(sumeer): this currently excludes the time spent in Reader creation,
 	// and in reading the rangedel and rangekey blocks. Fix that.
 	BlockReadDuration time.Duration
+	// Readahead describes the readahead issued while fetching blocks due to
+	// block cache misses. The readahead size is chosen adaptively by each
+	// sstable iterator based on how sequential its reads are, ramping up for
+	// scans and down to no readahead for point seeks.
+	Readahead struct {
+		// Count is the number of block reads that issued a readahead.
+		Count uint64
+		// Bytes is the total size of the readaheads.
+		Bytes uint64
+		// MaxSize is the largest readahead size chosen.
+		MaxSize uint64
+	}
 	// The following can repeatedly count the same points if they are iterated
 	// over multiple times. Additionally, they may count a point twice when
 	// switching directions. The latter could be improved if needed.
@@ -373,6 +385,17 @@ type InternalIteratorStats struct {
 	// https://github.com/cockroachdb/pebble/issues/1070.
 	PointsCoveredByRangeTombstones uint64
 
+	// Filter describes the use of the sstables' filters, such as bloom
+	// filters, by prefix seeks.
+	Filter struct {
+		// Checks is the number of times an sstable's filter was consulted.
+		Checks uint64
+		// Negatives is the subset of Checks which determined that the
+		// sstable doesn't contain the sought prefix, avoiding a seek within
+		// the sstable.
+		Negatives uint64
+	}
+
 	// Stats related to points in value blocks encountered during iteration.
 	// These are useful to understand outliers, since typical user facing
 	// iteration should tend to only look at the latest point, and hence have
@@ -399,10 +422,17 @@ func (s *InternalIteratorStats) Merge(from InternalIteratorStats) {
 	s.BlockBytes += from.BlockBytes
 	s.BlockBytesInCache += from.BlockBytesInCache
 	s.BlockReadDuration += from.BlockReadDuration
+	s.Readahead.Count += from.Readahead.Count
+	s.Readahead.Bytes += from.Readahead.Bytes
+	if s.Readahead.MaxSize < from.Readahead.MaxSize {
+		s.Readahead.MaxSize = from.Readahead.MaxSize
+	}
 	s.KeyBytes += from.KeyBytes
 	s.ValueBytes += from.ValueBytes
 	s.PointCount += from.PointCount
 	s.PointsCoveredByRangeTombstones += from.PointsCoveredByRangeTombstones
+	s.Filter.Checks += from.Filter.Checks
+	s.Filter.Negatives += from.Filter.Negatives
 	s.SeparatedPointValue.Count += from.SeparatedPointValue.Count
 	s.SeparatedPointValue.ValueBytes += from.SeparatedPointValue.ValueBytes
 	s.SeparatedPointValue.ValueBytesFetched += from.SeparatedPointValue.ValueBytesFetched
@@ -0,0 +1,95 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/errors"
+)
+
+// PrefixReplacement describes a read-time replacement of a key prefix: the
+// keys of a table that are stored with ContentPrefix are exposed to readers
+// with SyntheticPrefix in its place, and the keys of the table that don't have
+// ContentPrefix are hidden. This allows the tables of another store to be read
+// under a different key prefix without rewriting them.
+//
+// The replacement must preserve the ordering of keys, which requires the
+// comparer to order keys bytewise over the replaced prefixes.
+type PrefixReplacement struct {
+	ContentPrefix   []byte
+	SyntheticPrefix []byte
+}
+
+// Validate returns an error if the prefixes can't be used for a replacement.
+// Both prefixes must be non-empty, and have a successor, ie, not consist
+// solely of 0xff bytes.
+func (p *PrefixReplacement) Validate() error {
+	if prefixSuccessor(nil, p.ContentPrefix) == nil {
+		return errors.Errorf("pebble: invalid content prefix %q", p.ContentPrefix)
+	}
+	if prefixSuccessor(nil, p.SyntheticPrefix) == nil {
+		return errors.Errorf("pebble: invalid synthetic prefix %q", p.SyntheticPrefix)
+	}
+	return nil
+}
+
+// ContentBounds returns the bounds [lower, upper) of the keys that have the
+// content prefix.
+func (p *PrefixReplacement) ContentBounds() (lower, upper []byte) {
+	return p.ContentPrefix, prefixSuccessor(nil, p.ContentPrefix)
+}
+
+// SyntheticBounds returns the bounds [lower, upper) of the keys that have the
+// synthetic prefix.
+func (p *PrefixReplacement) SyntheticBounds() (lower, upper []byte) {
+	return p.SyntheticPrefix, prefixSuccessor(nil, p.SyntheticPrefix)
+}
+
+// ReplaceArg translates a key passed to a reader, such as a seek key or an
+// iterator bound, from the synthetic key space into the content key space,
+// appending the result to dst. Keys that don't have the synthetic prefix are
+// mapped to the nearest bound of the content key space, so that the ordering
+// of the key relative to the keys of the table is preserved.
+func (p *PrefixReplacement) ReplaceArg(dst, key []byte) []byte {
+	return replacePrefix(dst, key, p.SyntheticPrefix, p.ContentPrefix)
+}
+
+// ReplaceResult translates a key read from a table from the content key space
+// into the synthetic key space, appending the result to dst. Keys that don't
+// have the content prefix, such as the exclusive upper bound of a span, are
+// mapped to the nearest bound of the synthetic key space.
+func (p *PrefixReplacement) ReplaceResult(dst, key []byte) []byte {
+	return replacePrefix(dst, key, p.ContentPrefix, p.SyntheticPrefix)
+}
+
+// String implements fmt.Stringer.
+func (p *PrefixReplacement) String() string {
+	return string(p.ContentPrefix) + "->" + string(p.SyntheticPrefix)
+}
+
+func replacePrefix(dst, key, from, to []byte) []byte {
+	if bytes.HasPrefix(key, from) {
+		dst = append(dst, to...)
+		return append(dst, key[len(from):]...)
+	}
+	if bytes.Compare(key, from) < 0 {
+		return append(dst, to...)
+	}
+	return prefixSuccessor(dst, to)
+}
+
+// prefixSuccessor appends to dst the smallest key that is greater than all
+// the keys with the given prefix, returning nil if there is no such key.
+func prefixSuccessor(dst, prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			dst = append(dst, prefix[:i+1]...)
+			dst[len(dst)-1]++
+			return dst
+		}
+	}
+	return nil
+}
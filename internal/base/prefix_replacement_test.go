@@ -0,0 +1,41 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixReplacement(t *testing.T) {
+	p := &PrefixReplacement{ContentPrefix: []byte("src/"), SyntheticPrefix: []byte("dst/")}
+	require.NoError(t, p.Validate())
+
+	testCases := []struct {
+		key, arg, result string
+	}{
+		{"dst/a", "src/a", "dst/"},
+		{"src/a", "src0", "dst/a"},
+		{"dst/", "src/", "dst/"},
+		{"a", "src/", "dst/"},
+		{"dst0", "src0", "dst/"},
+		{"z", "src0", "dst0"},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.arg, string(p.ReplaceArg(nil, []byte(tc.key))), "arg %q", tc.key)
+		require.Equal(t, tc.result, string(p.ReplaceResult(nil, []byte(tc.key))), "result %q", tc.key)
+	}
+
+	lower, upper := p.ContentBounds()
+	require.Equal(t, "src/", string(lower))
+	require.Equal(t, "src0", string(upper))
+	lower, upper = p.SyntheticBounds()
+	require.Equal(t, "dst/", string(lower))
+	require.Equal(t, "dst0", string(upper))
+
+	require.Error(t, (&PrefixReplacement{SyntheticPrefix: []byte("a")}).Validate())
+	require.Error(t, (&PrefixReplacement{ContentPrefix: []byte("a"), SyntheticPrefix: []byte("\xff")}).Validate())
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"context"
+
+	"github.com/cockroachdb/pebble/internal/invariants"
+)
+
+// Tracer defines an interface for tracing operations with spans. It's
+// designed to be implemented by adapting a tracing library, such as
+// OpenTelemetry, whose spans are propagated through a context.Context.
+type Tracer interface {
+	// StartSpan starts a span for the named operation, as a child of the span
+	// of the given context, if any. It returns a context carrying the new span,
+	// in which the spans of the suboperations are started.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+	// IsTracingEnabled returns true if the spans started in the given context
+	// are recorded. It's used as an optimization to avoid starting spans, and
+	// the overhead of boxing their attributes, on the hot paths of reads.
+	IsTracingEnabled(ctx context.Context) bool
+}
+
+// Span is a traced operation, started by Tracer.StartSpan.
+type Span interface {
+	// SetAttribute sets an attribute of the span, such as the level or the
+	// file number of the sstable read by the operation.
+	SetAttribute(key string, value interface{})
+	// RecordError records the error returned by the operation.
+	RecordError(err error)
+	// End ends the span. The span must not be used after End.
+	End()
+}
+
+// NoopTracer does no tracing. Remember that struct{} is special cased in Go
+// and does not incur an allocation when it backs the interface Tracer.
+type NoopTracer struct{}
+
+var _ Tracer = NoopTracer{}
+
+// StartSpan implements Tracer.
+func (NoopTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	if invariants.Enabled && ctx == nil {
+		panic("StartSpan context is nil")
+	}
+	return ctx, NoopSpan{}
+}
+
+// IsTracingEnabled implements Tracer.
+func (NoopTracer) IsTracingEnabled(ctx context.Context) bool {
+	if invariants.Enabled && ctx == nil {
+		panic("IsTracingEnabled ctx is nil")
+	}
+	return false
+}
+
+// NoopSpan is the Span of NoopTracer.
+type NoopSpan struct{}
+
+var _ Span = NoopSpan{}
+
+// SetAttribute implements Span.
+func (NoopSpan) SetAttribute(key string, value interface{}) {}
+
+// RecordError implements Span.
+func (NoopSpan) RecordError(err error) {}
+
+// End implements Span.
+func (NoopSpan) End() {}
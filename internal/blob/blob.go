@@ -0,0 +1,245 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package blob implements the blob files that hold values separated from the
+// sstables of a DB.
+//
+// Values at or above a configured size are written to blob files by flushes
+// and compactions, and the sstables store a Handle in place of the value.
+// Separating large values from the keys keeps them out of the LSM: a
+// compaction that rewrites an sstable copies the handles of its values rather
+// than the values themselves.
+//
+// A blob file is a sequence of values, each followed by a 4-byte CRC-32C of
+// the value, encoded in little-endian order, and terminated by a fixed-size
+// footer:
+//
+//	+---------+---------+-----+---------+---------+---------------------+
+//	| value 0 | crc32c  | ... | value n | crc32c  | footer (16 bytes)   |
+//	+---------+---------+-----+---------+---------+---------------------+
+//
+// The footer holds the number of values in the file and a magic number, each
+// encoded as 8 little-endian bytes. Blob files are immutable, and a value is
+// only ever read through the handle returned when it was written, so a blob
+// file has no index.
+package blob
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/objstorage"
+)
+
+const (
+	checksumLen = 4
+	footerLen   = 16
+	magic       = 0xf09fab9bb10bf11e
+)
+
+// MaxHandleLen is the maximum length of an encoded Handle.
+const MaxHandleLen = 5 + 2*binary.MaxVarintLen64
+
+// Handle identifies a value stored in a blob file.
+type Handle struct {
+	FileNum  base.DiskFileNum
+	Offset   uint64
+	ValueLen uint32
+}
+
+// Encode encodes the handle into dst, returning the number of bytes written.
+// The value length is encoded first, so that it can be decoded without
+// decoding the rest of the handle. dst must be at least MaxHandleLen bytes.
+func (h Handle) Encode(dst []byte) int {
+	n := binary.PutUvarint(dst, uint64(h.ValueLen))
+	n += binary.PutUvarint(dst[n:], uint64(h.FileNum.FileNum()))
+	n += binary.PutUvarint(dst[n:], h.Offset)
+	return n
+}
+
+// DecodeHandle decodes a handle encoded by Handle.Encode.
+func DecodeHandle(src []byte) (Handle, error) {
+	valueLen, n := binary.Uvarint(src)
+	if n <= 0 || valueLen > uint64(^uint32(0)) {
+		return Handle{}, base.CorruptionErrorf("pebble/blob: malformed handle")
+	}
+	return DecodeHandleWithLen(src[n:], uint32(valueLen))
+}
+
+// DecodeHandleWithLen decodes the remainder of a handle encoded by
+// Handle.Encode, whose value length has already been decoded.
+func DecodeHandleWithLen(src []byte, valueLen uint32) (Handle, error) {
+	fileNum, n := binary.Uvarint(src)
+	if n <= 0 {
+		return Handle{}, base.CorruptionErrorf("pebble/blob: malformed handle")
+	}
+	offset, m := binary.Uvarint(src[n:])
+	if m <= 0 {
+		return Handle{}, base.CorruptionErrorf("pebble/blob: malformed handle")
+	}
+	return Handle{
+		FileNum:  base.FileNum(fileNum).DiskFileNum(),
+		Offset:   offset,
+		ValueLen: valueLen,
+	}, nil
+}
+
+// Writer writes a blob file.
+type Writer struct {
+	fileNum   base.DiskFileNum
+	w         objstorage.Writable
+	buf       []byte
+	offset    uint64
+	count     uint64
+	valueSize uint64
+	err       error
+}
+
+// NewWriter returns a Writer that writes the blob file with the given file
+// number to w. Either Finish or Abort must be called.
+func NewWriter(w objstorage.Writable, fileNum base.DiskFileNum) *Writer {
+	return &Writer{fileNum: fileNum, w: w}
+}
+
+// FileNum returns the file number of the blob file being written.
+func (w *Writer) FileNum() base.DiskFileNum {
+	return w.fileNum
+}
+
+// AddValue adds a value to the blob file, returning its handle.
+func (w *Writer) AddValue(value []byte) (Handle, error) {
+	if w.err != nil {
+		return Handle{}, w.err
+	}
+	if uint64(len(value)) > uint64(^uint32(0)) {
+		w.err = errors.Errorf("pebble/blob: value of length %d is too large", len(value))
+		return Handle{}, w.err
+	}
+	// NB: objstorage.Writable.Write is allowed to modify the slice passed to
+	// it, so the value is copied into a buffer owned by the Writer.
+	w.buf = append(w.buf[:0], value...)
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, crc.New(value).Value())
+	if w.err = w.w.Write(w.buf); w.err != nil {
+		return Handle{}, w.err
+	}
+	h := Handle{FileNum: w.fileNum, Offset: w.offset, ValueLen: uint32(len(value))}
+	w.offset += uint64(len(value) + checksumLen)
+	w.count++
+	w.valueSize += uint64(len(value))
+	return h, nil
+}
+
+// Count returns the number of values added to the blob file.
+func (w *Writer) Count() uint64 {
+	return w.count
+}
+
+// ValueSize returns the total length of the values added to the blob file.
+func (w *Writer) ValueSize() uint64 {
+	return w.valueSize
+}
+
+// Size returns the size of the blob file once it is finished.
+func (w *Writer) Size() uint64 {
+	return w.offset + footerLen
+}
+
+// Finish writes the footer of the blob file and makes it durable.
+func (w *Writer) Finish() error {
+	if w.err != nil {
+		w.w.Abort()
+		return w.err
+	}
+	w.buf = binary.LittleEndian.AppendUint64(w.buf[:0], w.count)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, magic)
+	if w.err = w.w.Write(w.buf); w.err != nil {
+		w.w.Abort()
+		return w.err
+	}
+	w.err = w.w.Finish()
+	if w.err == nil {
+		w.err = errors.New("pebble/blob: writer is finished")
+		return nil
+	}
+	return w.err
+}
+
+// Abort gives up on writing the blob file.
+func (w *Writer) Abort() {
+	if w.err == nil {
+		w.err = errors.New("pebble/blob: writer is aborted")
+	}
+	w.w.Abort()
+}
+
+// Reader reads values from a blob file. A Reader is safe for concurrent use.
+type Reader struct {
+	fileNum  base.DiskFileNum
+	readable objstorage.Readable
+	count    uint64
+}
+
+// NewReader returns a Reader for the blob file with the given file number,
+// validating its footer. The Reader takes ownership of r, which is closed by
+// Reader.Close, or on error.
+func NewReader(r objstorage.Readable, fileNum base.DiskFileNum) (*Reader, error) {
+	if r.Size() < footerLen {
+		_ = r.Close()
+		return nil, base.CorruptionErrorf("pebble/blob: file %s is too small", fileNum)
+	}
+	var footer [footerLen]byte
+	if err := r.ReadAt(context.Background(), footer[:], r.Size()-footerLen); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(footer[8:]) != magic {
+		_ = r.Close()
+		return nil, base.CorruptionErrorf("pebble/blob: file %s has an invalid footer", fileNum)
+	}
+	return &Reader{
+		fileNum:  fileNum,
+		readable: r,
+		count:    binary.LittleEndian.Uint64(footer[:8]),
+	}, nil
+}
+
+// Count returns the number of values in the blob file.
+func (r *Reader) Count() uint64 {
+	return r.count
+}
+
+// ReadValue reads the value with the given handle, verifying its checksum.
+// The value is read into buf if it has sufficient capacity.
+func (r *Reader) ReadValue(ctx context.Context, h Handle, buf []byte) ([]byte, error) {
+	if h.FileNum != r.fileNum {
+		return nil, errors.AssertionFailedf("pebble/blob: handle for file %s read from file %s",
+			h.FileNum, r.fileNum)
+	}
+	n := int(h.ValueLen) + checksumLen
+	if int64(h.Offset)+int64(n) > r.readable.Size()-footerLen {
+		return nil, base.CorruptionErrorf("pebble/blob: handle [%d, %d) out of bounds of file %s",
+			h.Offset, h.Offset+uint64(n), r.fileNum)
+	}
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if err := r.readable.ReadAt(ctx, buf, int64(h.Offset)); err != nil {
+		return nil, err
+	}
+	v := buf[:h.ValueLen]
+	if binary.LittleEndian.Uint32(buf[h.ValueLen:]) != crc.New(v).Value() {
+		return nil, base.CorruptionErrorf("pebble/blob: checksum mismatch in file %s at offset %d",
+			r.fileNum, h.Offset)
+	}
+	return v, nil
+}
+
+// Close closes the Reader.
+func (r *Reader) Close() error {
+	return r.readable.Close()
+}
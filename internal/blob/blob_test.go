@@ -0,0 +1,93 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleRoundTrip(t *testing.T) {
+	for _, h := range []Handle{
+		{},
+		{FileNum: base.FileNum(1).DiskFileNum(), Offset: 0, ValueLen: 1},
+		{FileNum: base.FileNum(1 << 40).DiskFileNum(), Offset: 1 << 50, ValueLen: 1<<32 - 1},
+	} {
+		var buf [MaxHandleLen]byte
+		n := h.Encode(buf[:])
+		got, err := DecodeHandle(buf[:n])
+		require.NoError(t, err)
+		require.Equal(t, h, got)
+	}
+	_, err := DecodeHandle([]byte{0x80})
+	require.Error(t, err)
+}
+
+func TestWriterReader(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	provider, err := objstorageprovider.Open(objstorageprovider.DefaultSettings(fs, ""))
+	require.NoError(t, err)
+	defer provider.Close()
+
+	fileNum := base.FileNum(7).DiskFileNum()
+	writable, _, err := provider.Create(ctx, base.FileTypeBlob, fileNum, objstorage.CreateOptions{})
+	require.NoError(t, err)
+	w := NewWriter(writable, fileNum)
+	var values [][]byte
+	var handles []Handle
+	for i := 0; i < 100; i++ {
+		v := bytes.Repeat([]byte(fmt.Sprint(i)), i)
+		h, err := w.AddValue(v)
+		require.NoError(t, err)
+		values = append(values, v)
+		handles = append(handles, h)
+	}
+	size := w.Size()
+	require.EqualValues(t, 100, w.Count())
+	require.NoError(t, w.Finish())
+
+	readable, err := provider.OpenForReading(ctx, base.FileTypeBlob, fileNum, objstorage.OpenOptions{})
+	require.NoError(t, err)
+	require.EqualValues(t, size, readable.Size())
+	r, err := NewReader(readable, fileNum)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, r.Count())
+	for i := len(handles) - 1; i >= 0; i-- {
+		v, err := r.ReadValue(ctx, handles[i], nil)
+		require.NoError(t, err)
+		require.Equal(t, values[i], v)
+	}
+
+	// A handle that extends past the values is rejected.
+	h := handles[len(handles)-1]
+	h.ValueLen += 10
+	_, err = r.ReadValue(ctx, h, nil)
+	require.Error(t, err)
+	require.NoError(t, r.Close())
+
+	// Corrupt a value and check that the checksum mismatch is detected.
+	f, err := fs.OpenReadWrite(base.MakeFilename(base.FileTypeBlob, fileNum))
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("x"), int64(handles[50].Offset))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	readable, err = provider.OpenForReading(ctx, base.FileTypeBlob, fileNum, objstorage.OpenOptions{})
+	require.NoError(t, err)
+	r, err = NewReader(readable, fileNum)
+	require.NoError(t, err)
+	_, err = r.ReadValue(ctx, handles[50], nil)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	require.NoError(t, r.Close())
+}
@@ -0,0 +1,214 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package cache
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// AdmissionPolicy determines which of the blocks that are not cached are
+// added to the cache by Cache.Set.
+type AdmissionPolicy int32
+
+const (
+	// AdmitAll adds every block to the cache, leaving it to the Clock-PRO
+	// replacement algorithm to evict the blocks which aren't reused. It's the
+	// default policy.
+	AdmitAll AdmissionPolicy = iota
+	// AdmitTinyLFU only adds a block to a full cache if the block was
+	// accessed more frequently than the block it would evict, per the TinyLFU
+	// policy (https://arxiv.org/abs/1512.00727). The frequencies of the blocks
+	// are approximated by a count-min sketch of the recent calls to
+	// Cache.Get, preceded by a "doorkeeper" bloom filter which absorbs the
+	// first access to each block. A large scan, whose blocks are accessed
+	// once, then doesn't evict the blocks which are frequently reused. The
+	// blocks recently evicted, which Clock-PRO still tracks as test pages,
+	// are always admitted.
+	AdmitTinyLFU
+)
+
+// String implements fmt.Stringer.
+func (p AdmissionPolicy) String() string {
+	switch p {
+	case AdmitAll:
+		return "all"
+	case AdmitTinyLFU:
+		return "tinylfu"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// admissionBlockSize is the block size assumed to size the frequency
+	// sketch of a shard according to the number of blocks it may hold.
+	admissionBlockSize = 4 << 10
+	// minSketchWidth and maxSketchWidth bound the number of counters of the
+	// frequency sketch of a shard.
+	minSketchWidth = 1 << 10
+	maxSketchWidth = 1 << 24
+	// maxFrequency is the saturation value of the counters of the sketch.
+	maxFrequency = 15
+	// sketchDepth is the number of counters incremented per access.
+	sketchDepth = 4
+	// sampleFactor is the number of accesses, relative to the width of the
+	// sketch, after which the counters are halved, so that the sketch tracks
+	// the recent frequencies.
+	sampleFactor = 10
+	// agingChunk is the number of counters halved by each access while the
+	// sketch ages, which bounds the work added to Cache.Get. It covers one
+	// word of the doorkeeper.
+	agingChunk = 64
+)
+
+// frequencySketch approximates the frequency of the recent accesses to the
+// blocks of a shard, for the AdmitTinyLFU policy. It's safe for concurrent
+// use: the counters are updated atomically, and the aging of the counters is
+// serialized by mu. The counters are halved incrementally, a chunk per access,
+// which makes the estimates approximate until all of them are halved.
+type frequencySketch struct {
+	mask uint64
+	// counters holds the count-min sketch of the accesses, as a single table
+	// indexed by sketchDepth hashes of the key.
+	counters []uint32
+	// doorkeeper is a bloom filter of the keys accessed since the last reset,
+	// which are only counted by the sketch from their second access.
+	doorkeeper []uint64
+	// accesses is the number of accesses since the sketch last started aging.
+	accesses   atomic.Int64
+	sampleSize int64
+	// agingPos is the index of the next counter to halve, or len(counters) if
+	// the sketch isn't aging.
+	agingPos atomic.Int64
+	mu       sync.Mutex
+}
+
+func newFrequencySketch(maxSize int64) *frequencySketch {
+	width := maxSize / admissionBlockSize
+	if width < minSketchWidth {
+		width = minSketchWidth
+	} else if width > maxSketchWidth {
+		width = maxSketchWidth
+	}
+	// Round the width up to a power of 2.
+	width = 1 << bits.Len64(uint64(width-1))
+	s := &frequencySketch{
+		mask:       uint64(width - 1),
+		counters:   make([]uint32, width),
+		doorkeeper: make([]uint64, width/64),
+		sampleSize: sampleFactor * width,
+	}
+	s.agingPos.Store(width)
+	return s
+}
+
+// admissionHash returns the hash of a block key used by the frequency sketch.
+func admissionHash(id uint64, fileNum base.DiskFileNum, offset uint64) uint64 {
+	h := id*0x9e3779b97f4a7c15 ^ uint64(fileNum.FileNum())
+	h = (h ^ (h >> 31)) * 0xbf58476d1ce4e5b9
+	h ^= offset
+	h = (h ^ (h >> 30)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
+
+// index returns the i-th counter index of the hash, by double hashing.
+func (s *frequencySketch) index(h uint64, i int) uint64 {
+	return (h + uint64(i)*((h>>32)|1)) & s.mask
+}
+
+// record records an access to the block with the given hash.
+func (s *frequencySketch) record(h uint64) {
+	if s.accesses.Add(1) >= s.sampleSize || s.agingPos.Load() < int64(len(s.counters)) {
+		s.age()
+	}
+	// The first access is recorded by the doorkeeper.
+	word, bit := (h&s.mask)/64, uint64(1)<<(h%64)
+	for {
+		old := atomic.LoadUint64(&s.doorkeeper[word])
+		if old&bit != 0 {
+			break
+		}
+		if atomic.CompareAndSwapUint64(&s.doorkeeper[word], old, old|bit) {
+			return
+		}
+	}
+	for i := 0; i < sketchDepth; i++ {
+		c := &s.counters[s.index(h, i)]
+		for {
+			v := atomic.LoadUint32(c)
+			if v >= maxFrequency || atomic.CompareAndSwapUint32(c, v, v+1) {
+				break
+			}
+		}
+	}
+}
+
+// estimate returns the estimated frequency of the accesses to the block with
+// the given hash.
+func (s *frequencySketch) estimate(h uint64) uint32 {
+	f := uint32(maxFrequency)
+	for i := 0; i < sketchDepth; i++ {
+		if v := atomic.LoadUint32(&s.counters[s.index(h, i)]); v < f {
+			f = v
+		}
+	}
+	word, bit := (h&s.mask)/64, uint64(1)<<(h%64)
+	if atomic.LoadUint64(&s.doorkeeper[word])&bit != 0 {
+		f++
+	}
+	return f
+}
+
+// age halves the next chunk of counters and clears the corresponding word of
+// the doorkeeper, starting over once the sample size is reached, so that the
+// sketch forgets the accesses which are no longer recent.
+func (s *frequencySketch) age() {
+	if !s.mu.TryLock() {
+		// Another access is aging the sketch.
+		return
+	}
+	defer s.mu.Unlock()
+	pos := s.agingPos.Load()
+	if pos == int64(len(s.counters)) {
+		if s.accesses.Load() < s.sampleSize {
+			return
+		}
+		s.accesses.Store(0)
+		pos = 0
+	}
+	for i := pos; i < pos+agingChunk; i++ {
+		atomic.StoreUint32(&s.counters[i], atomic.LoadUint32(&s.counters[i])/2)
+	}
+	atomic.StoreUint64(&s.doorkeeper[pos/64], 0)
+	s.agingPos.Store(pos + agingChunk)
+}
+
+// maxVictimScan bounds the number of entries examined to find the cold entry
+// which a new block would evict.
+const maxVictimScan = 8
+
+// admit returns true if a new block of the given size and hash should be
+// added to the shard, per the AdmitTinyLFU policy. The block is always
+// admitted if it fits without evicting another block, and otherwise if it
+// was accessed more frequently than the next cold block to evict. c.mu must
+// be held.
+func (c *shard) admit(s *frequencySketch, h uint64, size int64) bool {
+	if c.sizeHot+c.sizeCold+size < c.targetSize() {
+		return true
+	}
+	e := c.handCold
+	for i := 0; e != nil && i < maxVictimScan; i++ {
+		if e.ptype == etCold {
+			victim := admissionHash(e.key.id, e.key.fileNum, e.key.offset)
+			return s.estimate(h) > s.estimate(victim)
+		}
+		e = e.next()
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrequencySketch(t *testing.T) {
+	s := newFrequencySketch(0)
+	h := admissionHash(1, base.FileNum(2).DiskFileNum(), 3)
+	require.Equal(t, uint32(0), s.estimate(h))
+	// The first access is recorded by the doorkeeper.
+	s.record(h)
+	require.Equal(t, uint32(1), s.estimate(h))
+	for i := 0; i < 100; i++ {
+		s.record(h)
+	}
+	require.Equal(t, uint32(maxFrequency+1), s.estimate(h))
+
+	// Once the sample size is reached, the frequencies are halved, a chunk of
+	// counters per access.
+	other := admissionHash(1, base.FileNum(2).DiskFileNum(), 4)
+	for s.accesses.Load() != 0 {
+		s.record(other)
+	}
+	require.Equal(t, int64(agingChunk), s.agingPos.Load())
+	for s.agingPos.Load() < int64(len(s.counters)) {
+		s.record(other)
+	}
+	require.Equal(t, uint32(maxFrequency/2), s.estimate(h))
+}
+
+func TestAdmissionPolicy(t *testing.T) {
+	// hits runs a workload which repeatedly reads a small set of hot blocks,
+	// interleaved with a scan of blocks which are read once, and returns the
+	// number of reads of the hot blocks which hit the cache.
+	hits := func(policy AdmissionPolicy) (int, Metrics) {
+		c := newShards(100, 1)
+		defer c.Unref()
+		c.SetAdmissionPolicy(policy)
+		require.Equal(t, policy, c.AdmissionPolicy())
+
+		read := func(fileNum uint64) bool {
+			h := c.Get(1, base.FileNum(fileNum).DiskFileNum(), 0)
+			defer h.Release()
+			if h.Get() != nil {
+				return true
+			}
+			c.Set(1, base.FileNum(fileNum).DiskFileNum(), 0, testValue(c, "a", 10)).Release()
+			return false
+		}
+		var n int
+		scan := uint64(1000)
+		for round := 0; round < 50; round++ {
+			for hot := uint64(1); hot <= 5; hot++ {
+				if read(hot) && round > 0 {
+					n++
+				}
+			}
+			for i := 0; i < 10; i++ {
+				read(scan)
+				scan++
+			}
+		}
+		return n, c.Metrics()
+	}
+
+	allHits, m := hits(AdmitAll)
+	require.Zero(t, m.Rejected)
+	require.Equal(t, m.Misses, m.Admitted)
+
+	lfuHits, m := hits(AdmitTinyLFU)
+	// Once the hot blocks are cached, they're read from the cache for the
+	// rest of the workload, and the scanned blocks are rejected.
+	require.Greater(t, lfuHits, allHits)
+	require.GreaterOrEqual(t, lfuHits, 45*5)
+	require.Greater(t, m.Rejected, int64(0))
+	require.Equal(t, m.Misses, m.Admitted+m.Rejected)
+}
@@ -79,6 +79,13 @@ func (h Handle) Release() {
 type shard struct {
 	hits   int64
 	misses int64
+	// admitted and rejected count the new blocks added to the shard, and
+	// those rejected by the admission policy.
+	admitted int64
+	rejected int64
+	// sketch is the frequency sketch of the AdmitTinyLFU admission policy,
+	// or nil if every block is admitted.
+	sketch atomic.Pointer[frequencySketch]
 
 	mu sync.RWMutex
 
@@ -113,16 +120,19 @@ type shard struct {
 	countTest int64
 }
 
-func (c *shard) Get(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
+func (c *shard) Get(id uint64, fileNum base.DiskFileNum, offset uint64, peek bool) Handle {
 	c.mu.RLock()
 	var value *Value
 	if e := c.blocks.Get(key{fileKey{id, fileNum}, offset}); e != nil {
 		value = e.acquireValue()
-		if value != nil {
+		if value != nil && !peek {
 			atomic.StoreInt32(&e.referenced, 1)
 		}
 	}
 	c.mu.RUnlock()
+	if s := c.sketch.Load(); s != nil && !peek {
+		s.record(admissionHash(id, fileNum, offset))
+	}
 	if value == nil {
 		atomic.AddInt64(&c.misses, 1)
 		return Handle{}
@@ -131,7 +141,9 @@ func (c *shard) Get(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
 	return Handle{value: value}
 }
 
-func (c *shard) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *Value) Handle {
+func (c *shard) Set(
+	id uint64, fileNum base.DiskFileNum, offset uint64, value *Value, p Priority,
+) Handle {
 	if n := value.refs(); n != 1 {
 		panic(fmt.Sprintf("pebble: Value has already been added to the cache: refs=%d", n))
 	}
@@ -144,9 +156,22 @@ func (c *shard) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *V
 
 	switch {
 	case e == nil:
-		// no cache entry? add it
+		// no cache entry? add it, unless the admission policy rejects it
+		if s := c.sketch.Load(); s != nil && p != HighPriority &&
+			!c.admit(s, admissionHash(id, fileNum, offset), int64(len(value.buf))) {
+			value.ref.trace("reject")
+			atomic.AddInt64(&c.rejected, 1)
+			break
+		}
+		atomic.AddInt64(&c.admitted, 1)
 		e = newEntry(c, k, int64(len(value.buf)))
 		e.setValue(value)
+		switch p {
+		case LowPriority:
+			e.lowPriority = true
+		case HighPriority:
+			atomic.StoreInt32(&e.referenced, 1)
+		}
 		if c.metaAdd(k, e) {
 			value.ref.trace("add-cold")
 			c.sizeCold += e.size
@@ -160,7 +185,9 @@ func (c *shard) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *V
 	case e.peekValue() != nil:
 		// cache entry was a hot or cold page
 		e.setValue(value)
-		atomic.StoreInt32(&e.referenced, 1)
+		if p != LowPriority {
+			atomic.StoreInt32(&e.referenced, 1)
+		}
 		delta := int64(len(value.buf)) - e.size
 		e.size = int64(len(value.buf))
 		if e.ptype == etHot {
@@ -174,6 +201,7 @@ func (c *shard) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *V
 
 	default:
 		// cache entry was a test page
+		atomic.AddInt64(&c.admitted, 1)
 		c.sizeTest -= e.size
 		c.countTest--
 		c.metaDel(e)
@@ -282,6 +310,25 @@ func (c *shard) Free() {
 	c.files.free()
 }
 
+func (c *shard) setMaxSize(maxSize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = maxSize
+	if c.sketch.Load() != nil {
+		c.sketch.Store(newFrequencySketch(maxSize))
+	}
+
+	// Keep coldTarget within [0, targetSize] if targetSize decreased. See
+	// Reserve.
+	targetSize := c.targetSize()
+	if c.coldTarget > targetSize {
+		c.coldTarget = targetSize
+	}
+
+	c.evict()
+	c.checkConsistency()
+}
+
 func (c *shard) Reserve(n int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -494,10 +541,28 @@ func (c *shard) runHandCold(countColdDebug, sizeColdDebug int64) {
 		if atomic.LoadInt32(&e.referenced) == 1 {
 			atomic.StoreInt32(&e.referenced, 0)
 			e.ptype = etHot
+			e.lowPriority = false
 			c.sizeCold -= e.size
 			c.countCold--
 			c.sizeHot += e.size
 			c.countHot++
+		} else if e.lowPriority {
+			// Low priority entries are evicted without becoming test entries,
+			// as if their test period had expired without a hit. This avoids
+			// retaining test entries for blocks that aren't expected to be
+			// read again, which would otherwise evict hot entries.
+			c.sizeCold -= e.size
+			c.countCold--
+			c.coldTarget -= e.size
+			if c.coldTarget < 0 {
+				c.coldTarget = 0
+			}
+			c.metaDel(e)
+			c.metaCheck(e)
+			e.free()
+			if c.handCold == nil {
+				return
+			}
 		} else {
 			e.setValue(nil)
 			e.ptype = etTest
@@ -582,6 +647,10 @@ type Metrics struct {
 	Hits int64
 	// The number of cache misses.
 	Misses int64
+	// The number of blocks added to the cache, and of the blocks which the
+	// admission policy rejected (see Cache.SetAdmissionPolicy).
+	Admitted int64
+	Rejected int64
 }
 
 // Cache implements Pebble's sharded block cache. The Clock-PRO algorithm is
@@ -625,6 +694,8 @@ type Cache struct {
 	maxSize int64
 	idAlloc uint64
 	shards  []shard
+	// admissionPolicy is the AdmissionPolicy of the cache.
+	admissionPolicy int32
 
 	// Traces recorded by Cache.trace. Used for debugging.
 	tr struct {
@@ -740,7 +811,13 @@ func (c *Cache) Unref() {
 // Get retrieves the cache value for the specified file and offset, returning
 // nil if no value is present.
 func (c *Cache) Get(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
-	return c.getShard(id, fileNum, offset).Get(id, fileNum, offset)
+	return c.getShard(id, fileNum, offset).Get(id, fileNum, offset, false /* peek */)
+}
+
+// Peek is like Get, but the access doesn't affect the eviction of the value,
+// nor the frequencies recorded by the admission policy.
+func (c *Cache) Peek(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
+	return c.getShard(id, fileNum, offset).Get(id, fileNum, offset, true /* peek */)
 }
 
 // Set sets the cache value for the specified file and offset, overwriting an
@@ -748,7 +825,40 @@ func (c *Cache) Get(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
 // retrieval of the cached value than Get (lock-free and avoidance of the map
 // lookup). The value must have been allocated by Cache.Alloc.
 func (c *Cache) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *Value) Handle {
-	return c.getShard(id, fileNum, offset).Set(id, fileNum, offset, value)
+	return c.getShard(id, fileNum, offset).Set(id, fileNum, offset, value, NormalPriority)
+}
+
+// Priority is the priority of a value added to the cache by SetWithPriority.
+type Priority int8
+
+const (
+	// NormalPriority values are added as by Set.
+	NormalPriority Priority = iota
+	// LowPriority values are the first evicted, unless they're accessed by Get
+	// before the clock hand reaches them. Unlike other values, their eviction
+	// doesn't adapt the cache to retain similar values.
+	LowPriority
+	// HighPriority values are added regardless of the admission policy, and
+	// are retained as if they had been accessed since they were added, so
+	// that they're preferred to other recently added values.
+	HighPriority
+)
+
+// SetWithPriority is like Set, with the specified priority for the value
+// if it's not already present.
+func (c *Cache) SetWithPriority(
+	id uint64, fileNum base.DiskFileNum, offset uint64, value *Value, p Priority,
+) Handle {
+	return c.getShard(id, fileNum, offset).Set(id, fileNum, offset, value, p)
+}
+
+// UncachedHandle returns a Handle for a value allocated by Cache.Alloc that
+// isn't added to the cache. Releasing the Handle frees the value.
+func UncachedHandle(value *Value) Handle {
+	if n := value.refs(); n != 1 {
+		panic(fmt.Sprintf("pebble: Value has already been added to the cache: refs=%d", n))
+	}
+	return Handle{value: value}
 }
 
 // Delete deletes the cached value for the specified file and offset.
@@ -766,9 +876,44 @@ func (c *Cache) EvictFile(id uint64, fileNum base.DiskFileNum) {
 	}
 }
 
+// SetAdmissionPolicy sets the policy which determines the blocks added to the
+// cache. It may be changed while the cache is in use, eg, to compare the hit
+// rates of the policies, in which case the frequencies recorded by
+// AdmitTinyLFU are reset.
+func (c *Cache) SetAdmissionPolicy(p AdmissionPolicy) {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		switch p {
+		case AdmitTinyLFU:
+			if s.sketch.Load() == nil {
+				s.sketch.Store(newFrequencySketch(s.maxSize))
+			}
+		default:
+			s.sketch.Store(nil)
+		}
+		s.mu.Unlock()
+	}
+	atomic.StoreInt32(&c.admissionPolicy, int32(p))
+}
+
+// AdmissionPolicy returns the admission policy of the cache.
+func (c *Cache) AdmissionPolicy() AdmissionPolicy {
+	return AdmissionPolicy(atomic.LoadInt32(&c.admissionPolicy))
+}
+
 // MaxSize returns the max size of the cache.
 func (c *Cache) MaxSize() int64 {
-	return c.maxSize
+	return atomic.LoadInt64(&c.maxSize)
+}
+
+// SetMaxSize changes the max size of the cache. If the cache shrinks, entries
+// are evicted until it fits within the new size.
+func (c *Cache) SetMaxSize(size int64) {
+	atomic.StoreInt64(&c.maxSize, size)
+	for i := range c.shards {
+		c.shards[i].setMaxSize(size / int64(len(c.shards)))
+	}
 }
 
 // Size returns the current space used by the cache.
@@ -831,6 +976,8 @@ func (c *Cache) Metrics() Metrics {
 		s.mu.RUnlock()
 		m.Hits += atomic.LoadInt64(&s.hits)
 		m.Misses += atomic.LoadInt64(&s.misses)
+		m.Admitted += atomic.LoadInt64(&s.admitted)
+		m.Rejected += atomic.LoadInt64(&s.rejected)
 	}
 	return m
 }
@@ -185,6 +185,29 @@ func TestReserve(t *testing.T) {
 	require.EqualValues(t, 4, cache.Size())
 }
 
+func TestSetMaxSize(t *testing.T) {
+	cache := newShards(8, 2)
+	defer cache.Unref()
+
+	for i := uint64(1); i <= 8; i++ {
+		cache.Set(i, base.FileNum(0).DiskFileNum(), 0, testValue(cache, "a", 1)).Release()
+	}
+	require.EqualValues(t, 8, cache.Size())
+	cache.SetMaxSize(4)
+	require.EqualValues(t, 4, cache.MaxSize())
+	require.LessOrEqual(t, cache.Size(), int64(4))
+	for i := uint64(1); i <= 8; i++ {
+		cache.Set(i, base.FileNum(0).DiskFileNum(), 0, testValue(cache, "a", 1)).Release()
+	}
+	require.LessOrEqual(t, cache.Size(), int64(4))
+	cache.SetMaxSize(8)
+	for i := uint64(1); i <= 8; i++ {
+		cache.Set(i, base.FileNum(0).DiskFileNum(), 0, testValue(cache, "a", 1)).Release()
+	}
+	require.EqualValues(t, 8, cache.MaxSize())
+	require.Greater(t, cache.Size(), int64(4))
+}
+
 func TestReserveDoubleRelease(t *testing.T) {
 	cache := newShards(100, 1)
 	defer cache.Unref()
@@ -277,3 +300,68 @@ func TestReserveColdTarget(t *testing.T) {
 		t.Fatalf("expected positive cache size %d, but found %d", 48, cache.Size())
 	}
 }
+
+func TestCachePriority(t *testing.T) {
+	// hits runs a workload which repeatedly reads a small set of hot blocks,
+	// interleaved with a scan of blocks which are read once with the given
+	// priority, and returns the number of reads of the hot blocks which hit
+	// the cache.
+	hits := func(p Priority) int {
+		c := newShards(100, 1)
+		defer c.Unref()
+
+		var n int
+		scan := uint64(1000)
+		for round := 0; round < 50; round++ {
+			for hot := uint64(1); hot <= 5; hot++ {
+				h := c.Get(1, base.FileNum(hot).DiskFileNum(), 0)
+				if h.Get() != nil {
+					if round > 0 {
+						n++
+					}
+				} else {
+					c.Set(1, base.FileNum(hot).DiskFileNum(), 0, testValue(c, "a", 10)).Release()
+				}
+				h.Release()
+			}
+			for i := 0; i < 20; i++ {
+				h := c.Peek(1, base.FileNum(scan).DiskFileNum(), 0)
+				if h.Get() == nil {
+					c.SetWithPriority(1, base.FileNum(scan).DiskFileNum(), 0, testValue(c, "a", 10), p).Release()
+				}
+				h.Release()
+				scan++
+			}
+		}
+		return n
+	}
+	normalHits := hits(NormalPriority)
+	lowHits := hits(LowPriority)
+	// The scan is too large for the hot blocks to be retained, unless the
+	// scanned blocks are added with LowPriority, which evicts them first.
+	require.Greater(t, lowHits, normalHits)
+	require.GreaterOrEqual(t, lowHits, 45*5)
+
+	c := newShards(100, 1)
+	defer c.Unref()
+	c.SetAdmissionPolicy(AdmitTinyLFU)
+	for i := 0; i < 9; i++ {
+		c.Set(1, base.FileNum(uint64(i)).DiskFileNum(), 0, testValue(c, "a", 10)).Release()
+	}
+	// The cache is full, so a block which was never read before is rejected
+	// by the admission policy, unless it's added with HighPriority.
+	c.Set(1, base.FileNum(100).DiskFileNum(), 0, testValue(c, "a", 10)).Release()
+	require.Equal(t, int64(1), c.Metrics().Rejected)
+	c.SetWithPriority(1, base.FileNum(101).DiskFileNum(), 0, testValue(c, "a", 10), HighPriority).Release()
+	require.Equal(t, int64(1), c.Metrics().Rejected)
+	h := c.Peek(1, base.FileNum(101).DiskFileNum(), 0)
+	require.NotNil(t, h.Get())
+	h.Release()
+
+	// Values with an UncachedHandle aren't added to the cache.
+	size := c.Size()
+	h = UncachedHandle(testValue(c, "b", 10))
+	require.Equal(t, []byte("bbbbbbbbbb"), h.Get())
+	h.Release()
+	require.Equal(t, size, c.Size())
+}
@@ -57,7 +57,10 @@ type entry struct {
 	// referenced is atomically set to indicate that this entry has been accessed
 	// since the last time one of the clock hands swept it.
 	referenced int32
-	shard      *shard
+	// lowPriority is set for the entries added with LowPriority, until they're
+	// promoted to hot entries.
+	lowPriority bool
+	shard       *shard
 	// Reference count for the entry. The entry is freed when the reference count
 	// drops to zero.
 	ref refcnt
@@ -732,6 +732,15 @@ func (s *L0Sublevels) InitCompactingFileInfo(inProgress []L0Compaction) {
 					s.formatKey(s.orderedIntervals[f.maxIntervalIndex+1].startKey.key), s.formatKey(f.Smallest.UserKey)))
 			}
 		}
+		if f.ShortLived && !f.IsCompacting() {
+			// Short-lived files are excluded from L0 compactions, so they're
+			// accounted for as compacting files without blocking base
+			// compactions.
+			for i := f.minIntervalIndex; i <= f.maxIntervalIndex; i++ {
+				s.orderedIntervals[i].compactingFileCount++
+			}
+			continue
+		}
 		if !f.IsCompacting() {
 			continue
 		}
@@ -1358,6 +1367,12 @@ func (is intervalSorterByDecreasingScore) Swap(i, j int) {
 //    Lbase a---------i    m---------w
 //
 
+// excludedFromL0Compaction returns true if the file may not be picked by an
+// L0 compaction, because it's already compacting or it's short-lived.
+func excludedFromL0Compaction(f *FileMetadata) bool {
+	return f.IsCompacting() || f.ShortLived
+}
+
 // PickBaseCompaction picks a base compaction based on the above specified
 // heuristics, for the specified Lbase files and a minimum depth of overlapping
 // files that can be selected for compaction. Returns nil if no compaction is
@@ -1416,6 +1431,11 @@ func (s *L0Sublevels) PickBaseCompaction(
 			return nil, errors.New("no seed file found in sublevel intervals")
 		}
 		consideredIntervals.markBits(f.minIntervalIndex, f.maxIntervalIndex+1)
+		if f.ShortLived {
+			// The files of the interval may not be compacted until the
+			// short-lived seed file is dropped.
+			continue
+		}
 		if f.IsCompacting() {
 			if f.IsIntraL0Compacting {
 				// If we're picking a base compaction and we came across a seed
@@ -1566,7 +1586,7 @@ func (s *L0Sublevels) extendFiles(
 		if f.minIntervalIndex > cFiles.maxIntervalIndex {
 			break
 		}
-		if f.IsCompacting() {
+		if excludedFromL0Compaction(f) {
 			return false
 		}
 		// Skip over files that are newer than earliestUnflushedSeqNum. This is
@@ -1616,7 +1636,7 @@ func (s *L0Sublevels) PickIntraL0Compaction(
 		stackDepthReduction := scoredInterval.score
 		for i := len(interval.files) - 1; i >= 0; i-- {
 			f = interval.files[i]
-			if f.IsCompacting() {
+			if excludedFromL0Compaction(f) {
 				break
 			}
 			consideredIntervals.markBits(f.minIntervalIndex, f.maxIntervalIndex+1)
@@ -1639,7 +1659,7 @@ func (s *L0Sublevels) PickIntraL0Compaction(
 		if f == nil {
 			return nil, errors.New("no seed file found in sublevel intervals")
 		}
-		if f.IsCompacting() {
+		if excludedFromL0Compaction(f) {
 			// This file could be in a concurrent intra-L0 or base compaction.
 			// Try another interval.
 			continue
@@ -1691,7 +1711,7 @@ func (s *L0Sublevels) intraL0CompactionUsingSeed(
 	for ; slIndex >= 0; slIndex-- {
 		f2 := interval.files[slIndex]
 		sl := f2.SubLevel
-		if f2.IsCompacting() {
+		if excludedFromL0Compaction(f2) {
 			break
 		}
 		c.seedIntervalStackDepthReduction++
@@ -1963,7 +1983,7 @@ func (s *L0Sublevels) extendCandidateToRectangle(
 		candidateHasAlreadyPickedFiles := false
 		for index = firstIndex; index <= lastIndex; index++ {
 			f := files[index]
-			if f.IsCompacting() {
+			if excludedFromL0Compaction(f) {
 				if nonCompactingFirst != -1 {
 					last := index - 1
 					// Prioritize runs of consecutive non-compacting files that
@@ -2015,7 +2035,7 @@ func (s *L0Sublevels) extendCandidateToRectangle(
 		}
 		for index := candidateNonCompactingFirst; index <= candidateNonCompactingLast; index++ {
 			f := files[index]
-			if f.IsCompacting() {
+			if excludedFromL0Compaction(f) {
 				// TODO(bilal): Do a logger.Fatalf instead of a panic, for
 				// cleaner unwinding and error messages.
 				panic(fmt.Sprintf("expected %s to not be compacting", f.FileNum))
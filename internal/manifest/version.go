@@ -68,6 +68,8 @@ type TableStats struct {
 	RangeDeletionsBytesEstimate uint64
 	// Total size of value blocks and value index block.
 	ValueBlocksSize uint64
+	// Size of the filter block.
+	FilterSize uint64
 }
 
 // boundType represents the type of key (point or range) present as the smallest
@@ -275,6 +277,20 @@ type FileMetadata struct {
 	boundTypeSmallest, boundTypeLargest boundType
 	// Virtual is true if the FileMetadata belongs to a virtual sstable.
 	Virtual bool
+	// CoalescedRangeDels is true if the table was written by a flush to hold
+	// only range deletions coalesced from runs of abutting range deletions.
+	// The stats of such tables are loaded before those of other new tables,
+	// so that the delete-only compactions of the tables they cover are
+	// scheduled promptly. It isn't persisted in the manifest.
+	CoalescedRangeDels bool
+	// ShortLived is true if the table is an L0 table flushed within a
+	// short-lived span, which is excluded from L0 compactions until it's
+	// dropped on expiry. It isn't persisted in the manifest.
+	ShortLived bool
+	// PrefixReplacement is non-nil if the keys of the table are read with a
+	// replaced prefix, as is the case for the tables attached from another
+	// store. The bounds of the table are in the synthetic key space.
+	PrefixReplacement *base.PrefixReplacement
 }
 
 // PhysicalFileMeta is used by functions which want a guarantee that their input
@@ -352,6 +368,21 @@ type FileBacking struct {
 	VirtualizedSize atomic.Uint64
 	DiskFileNum     base.DiskFileNum
 	Size            uint64
+	// BlobReferences are the blob files holding values of the backing sst,
+	// which must not be deleted while the backing sst exists. See the
+	// internal/blob package.
+	BlobReferences []BlobReference
+}
+
+// BlobReference is a reference from a backing sst to a blob file holding some
+// of its values.
+type BlobReference struct {
+	FileNum base.DiskFileNum
+	// FileSize is the size of the blob file.
+	FileSize uint64
+	// ValueSize is the total length of the values in the blob file that are
+	// referenced by the backing sst.
+	ValueSize uint64
 }
 
 // InitPhysicalBacking allocates and sets the FileBacking which is required by a
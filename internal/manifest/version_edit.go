@@ -51,13 +51,19 @@ const (
 	tagMaxColumnFamily  = 203
 
 	// Pebble tags.
-	tagNewFile5 = 104 // Range keys.
+	tagNewFile5            = 104 // Range keys.
+	tagCreatedBackingTable = 105
+	tagRemovedBackingTable = 106
+	tagApplicationMetadata = 110
 
 	// The custom tags sub-format used by tagNewFile4 and above.
 	customTagTerminate         = 1
 	customTagNeedsCompaction   = 2
 	customTagCreationTime      = 6
 	customTagPathID            = 65
+	customTagPrefixReplacement = 66
+	customTagBlobReferences    = 67
+	customTagVirtual           = 68
 	customTagNonSafeIgnoreMask = 1 << 6
 )
 
@@ -73,6 +79,11 @@ type DeletedFileEntry struct {
 type NewFileEntry struct {
 	Level int
 	Meta  *FileMetadata
+	// BackingFileNum is the file number of the backing sstable of a virtual
+	// sstable decoded from a MANIFEST. The FileBacking of such a virtual
+	// sstable is only set once the edit is accumulated into a
+	// BulkVersionEdit, along with the edit creating the FileBacking.
+	BackingFileNum base.DiskFileNum
 }
 
 // VersionEdit holds the state for an edit to a Version along with other
@@ -139,11 +150,21 @@ type VersionEdit struct {
 	// and RemovedBackingTables. A file must be present in RemovedBackingTables
 	// in exactly one version edit.
 	RemovedBackingTables []base.DiskFileNum
+
+	// ApplicationMetadata is an opaque payload provided by the application,
+	// persisted atomically with the rest of the edit. The latest payload
+	// written to the manifest supersedes any earlier ones.
+	//
+	// This is an optional field, and nil represents it is not set. Manifests
+	// containing it cannot be read by versions of Pebble that don't support
+	// it.
+	ApplicationMetadata []byte
 }
 
 // Decode decodes an edit from the specified reader.
 //
-// TODO(bananabrick): Support decoding of virtual sstable state.
+// The FileBacking of the virtual sstables in NewFiles is left unset, see
+// NewFileEntry.BackingFileNum.
 func (v *VersionEdit) Decode(r io.Reader) error {
 	br, ok := r.(byteReader)
 	if !ok {
@@ -196,6 +217,36 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			// NB: RocksDB does not use compaction pointers anymore.
 
+		case tagCreatedBackingTable:
+			fileNum, err := d.readFileNum()
+			if err != nil {
+				return err
+			}
+			size, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			field, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			blobReferences, err := decodeBlobReferences(field)
+			if err != nil {
+				return err
+			}
+			v.CreatedBackingTables = append(v.CreatedBackingTables, &FileBacking{
+				DiskFileNum:    fileNum.DiskFileNum(),
+				Size:           size,
+				BlobReferences: blobReferences,
+			})
+
+		case tagRemovedBackingTable:
+			fileNum, err := d.readFileNum()
+			if err != nil {
+				return err
+			}
+			v.RemovedBackingTables = append(v.RemovedBackingTables, fileNum.DiskFileNum())
+
 		case tagDeletedFile:
 			level, err := d.readLevel()
 			if err != nil {
@@ -301,6 +352,10 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			var markedForCompaction bool
 			var creationTime uint64
+			var prefixReplacement *base.PrefixReplacement
+			var blobReferences []BlobReference
+			var virtual bool
+			var backingFileNum base.DiskFileNum
 			if tag == tagNewFile4 || tag == tagNewFile5 {
 				for {
 					customTag, err := d.readUvarint()
@@ -331,6 +386,30 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 					case customTagPathID:
 						return base.CorruptionErrorf("new-file4: path-id field not supported")
 
+					case customTagPrefixReplacement:
+						n, m := binary.Uvarint(field)
+						if m <= 0 || uint64(len(field)-m) < n {
+							return base.CorruptionErrorf("new-file4: invalid prefix replacement")
+						}
+						field = field[m:]
+						prefixReplacement = &base.PrefixReplacement{
+							ContentPrefix:   field[:n],
+							SyntheticPrefix: field[n:],
+						}
+
+					case customTagBlobReferences:
+						if blobReferences, err = decodeBlobReferences(field); err != nil {
+							return err
+						}
+
+					case customTagVirtual:
+						n, m := binary.Uvarint(field)
+						if m <= 0 || m != len(field) {
+							return base.CorruptionErrorf("new-file4: invalid virtual sstable backing")
+						}
+						virtual = true
+						backingFileNum = base.FileNum(n).DiskFileNum()
+
 					default:
 						if (customTag & customTagNonSafeIgnoreMask) != 0 {
 							return base.CorruptionErrorf("new-file4: custom field not supported: %d", customTag)
@@ -345,6 +424,7 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 				SmallestSeqNum:      smallestSeqNum,
 				LargestSeqNum:       largestSeqNum,
 				MarkedForCompaction: markedForCompaction,
+				PrefixReplacement:   prefixReplacement,
 			}
 			if tag != tagNewFile5 { // no range keys present
 				m.SmallestPointKey = base.DecodeInternalKey(smallestPointKey)
@@ -376,10 +456,16 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 				}
 			}
 			m.boundsSet = true
-			m.InitPhysicalBacking()
+			if virtual {
+				m.Virtual = true
+			} else {
+				m.InitPhysicalBacking()
+				m.FileBacking.BlobReferences = blobReferences
+			}
 			v.NewFiles = append(v.NewFiles, NewFileEntry{
-				Level: level,
-				Meta:  m,
+				Level:          level,
+				Meta:           m,
+				BackingFileNum: backingFileNum,
 			})
 
 		case tagPrevLogNumber:
@@ -389,6 +475,13 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			v.ObsoletePrevLogNum = n
 
+		case tagApplicationMetadata:
+			md, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			v.ApplicationMetadata = md
+
 		case tagColumnFamily, tagColumnFamilyAdd, tagColumnFamilyDrop, tagMaxColumnFamily:
 			return base.CorruptionErrorf("column families are not supported")
 
@@ -417,6 +510,9 @@ func (v *VersionEdit) String() string {
 	if v.LastSeqNum != 0 {
 		fmt.Fprintf(&buf, "  last-seq-num:  %d\n", v.LastSeqNum)
 	}
+	if v.ApplicationMetadata != nil {
+		fmt.Fprintf(&buf, "  app-metadata:  %d bytes\n", len(v.ApplicationMetadata))
+	}
 	entries := make([]DeletedFileEntry, 0, len(v.DeletedFiles))
 	for df := range v.DeletedFiles {
 		entries = append(entries, df)
@@ -436,14 +532,25 @@ func (v *VersionEdit) String() string {
 			fmt.Fprintf(&buf, " (%s)",
 				time.Unix(nf.Meta.CreationTime, 0).UTC().Format(time.RFC3339))
 		}
+		if nf.Meta.Virtual {
+			backingFileNum := nf.BackingFileNum
+			if nf.Meta.FileBacking != nil {
+				backingFileNum = nf.Meta.FileBacking.DiskFileNum
+			}
+			fmt.Fprintf(&buf, " (virtual, backing %s)", backingFileNum)
+		}
 		fmt.Fprintln(&buf)
 	}
+	for _, b := range v.CreatedBackingTables {
+		fmt.Fprintf(&buf, "  add-backing:   %s\n", b.DiskFileNum)
+	}
+	for _, n := range v.RemovedBackingTables {
+		fmt.Fprintf(&buf, "  del-backing:   %s\n", n)
+	}
 	return buf.String()
 }
 
 // Encode encodes an edit to the specified writer.
-//
-// TODO(bananabrick): Support encoding of virtual sstable state.
 func (v *VersionEdit) Encode(w io.Writer) error {
 	e := versionEditEncoder{new(bytes.Buffer)}
 
@@ -470,13 +577,32 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(tagLastSequence)
 		e.writeUvarint(v.LastSeqNum)
 	}
+	if v.ApplicationMetadata != nil {
+		e.writeUvarint(tagApplicationMetadata)
+		e.writeBytes(v.ApplicationMetadata)
+	}
+	for _, x := range v.CreatedBackingTables {
+		e.writeUvarint(tagCreatedBackingTable)
+		e.writeUvarint(uint64(x.DiskFileNum.FileNum()))
+		e.writeUvarint(x.Size)
+		e.writeBytes(encodeBlobReferences(x.BlobReferences))
+	}
+	for _, x := range v.RemovedBackingTables {
+		e.writeUvarint(tagRemovedBackingTable)
+		e.writeUvarint(uint64(x.FileNum()))
+	}
 	for x := range v.DeletedFiles {
 		e.writeUvarint(tagDeletedFile)
 		e.writeUvarint(uint64(x.Level))
 		e.writeUvarint(uint64(x.FileNum))
 	}
 	for _, x := range v.NewFiles {
-		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0
+		var blobReferences []BlobReference
+		if !x.Meta.Virtual && x.Meta.FileBacking != nil {
+			blobReferences = x.Meta.FileBacking.BlobReferences
+		}
+		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 ||
+			x.Meta.PrefixReplacement != nil || len(blobReferences) > 0 || x.Meta.Virtual
 		var tag uint64
 		switch {
 		case x.Meta.HasRangeKeys:
@@ -529,6 +655,24 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 				e.writeUvarint(customTagNeedsCompaction)
 				e.writeBytes([]byte{1})
 			}
+			if p := x.Meta.PrefixReplacement; p != nil {
+				e.writeUvarint(customTagPrefixReplacement)
+				buf := binary.AppendUvarint(nil, uint64(len(p.ContentPrefix)))
+				buf = append(buf, p.ContentPrefix...)
+				e.writeBytes(append(buf, p.SyntheticPrefix...))
+			}
+			if len(blobReferences) > 0 {
+				e.writeUvarint(customTagBlobReferences)
+				e.writeBytes(encodeBlobReferences(blobReferences))
+			}
+			if x.Meta.Virtual {
+				backingFileNum := x.BackingFileNum
+				if x.Meta.FileBacking != nil {
+					backingFileNum = x.Meta.FileBacking.DiskFileNum
+				}
+				e.writeUvarint(customTagVirtual)
+				e.writeBytes(binary.AppendUvarint(nil, uint64(backingFileNum.FileNum())))
+			}
 			e.writeUvarint(customTagTerminate)
 		}
 	}
@@ -536,6 +680,53 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 	return err
 }
 
+func encodeBlobReferences(refs []BlobReference) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(refs)))
+	for _, ref := range refs {
+		buf = binary.AppendUvarint(buf, uint64(ref.FileNum.FileNum()))
+		buf = binary.AppendUvarint(buf, ref.FileSize)
+		buf = binary.AppendUvarint(buf, ref.ValueSize)
+	}
+	return buf
+}
+
+func decodeBlobReferences(field []byte) ([]BlobReference, error) {
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(field)
+		if n <= 0 {
+			return 0, base.CorruptionErrorf("new-file4: invalid blob references")
+		}
+		field = field[n:]
+		return v, nil
+	}
+	count, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	// Each reference is encoded in at least 3 bytes.
+	if count > uint64(len(field))/3 {
+		return nil, base.CorruptionErrorf("new-file4: invalid blob references")
+	}
+	refs := make([]BlobReference, count)
+	for i := range refs {
+		fileNum, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		refs[i].FileNum = base.FileNum(fileNum).DiskFileNum()
+		if refs[i].FileSize, err = readUvarint(); err != nil {
+			return nil, err
+		}
+		if refs[i].ValueSize, err = readUvarint(); err != nil {
+			return nil, err
+		}
+	}
+	if len(field) != 0 {
+		return nil, base.CorruptionErrorf("new-file4: invalid blob references")
+	}
+	return refs, nil
+}
+
 type versionEditDecoder struct {
 	byteReader
 }
@@ -637,6 +828,11 @@ type BulkVersionEdit struct {
 	AddedFileBacking   []*FileBacking
 	RemovedFileBacking []base.DiskFileNum
 
+	// addedFileBackingByNum maps the file numbers of the backing sstables of
+	// AddedFileBacking to their FileBacking, to set the FileBacking of the
+	// virtual sstables decoded from a MANIFEST.
+	addedFileBackingByNum map[base.DiskFileNum]*FileBacking
+
 	// AddedByFileNum maps file number to file metadata for all added files
 	// from accumulated version edits. AddedByFileNum is only populated if set
 	// to non-nil by a caller. It must be set to non-nil when replaying
@@ -697,7 +893,25 @@ func (b *BulkVersionEdit) Accumulate(ve *VersionEdit) error {
 		}
 	}
 
+	for _, s := range ve.CreatedBackingTables {
+		if b.addedFileBackingByNum == nil {
+			b.addedFileBackingByNum = make(map[base.DiskFileNum]*FileBacking)
+		}
+		b.addedFileBackingByNum[s.DiskFileNum] = s
+	}
+
 	for _, nf := range ve.NewFiles {
+		if nf.Meta.Virtual && nf.Meta.FileBacking == nil {
+			// The virtual sstable was decoded from a MANIFEST, in which its
+			// backing sstable must have been created by this or a preceding
+			// VersionEdit.
+			backing, ok := b.addedFileBackingByNum[nf.BackingFileNum]
+			if !ok {
+				return base.CorruptionErrorf("pebble: virtual sstable L%d.%s has unknown backing sstable %s",
+					nf.Level, nf.Meta.FileNum, nf.BackingFileNum)
+			}
+			nf.Meta.FileBacking = backing
+		}
 		// A new file should not have been deleted in this or a preceding
 		// VersionEdit at the same level (though files can move across levels).
 		if dmap := b.Deleted[nf.Level]; dmap != nil {
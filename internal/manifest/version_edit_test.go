@@ -58,12 +58,20 @@ func TestVersionEditRoundTrip(t *testing.T) {
 		SmallestSeqNum:      3,
 		LargestSeqNum:       5,
 		MarkedForCompaction: true,
+		PrefixReplacement: &base.PrefixReplacement{
+			ContentPrefix:   []byte("src"),
+			SyntheticPrefix: []byte("A"),
+		},
 	}).ExtendPointKeyBounds(
 		cmp,
 		base.DecodeInternalKey([]byte("A\x00\x01\x02\x03\x04\x05\x06\x07")),
 		base.DecodeInternalKey([]byte("Z\x01\xff\xfe\xfd\xfc\xfb\xfa\xf9")),
 	)
 	m2.InitPhysicalBacking()
+	m2.FileBacking.BlobReferences = []BlobReference{
+		{FileNum: base.FileNum(801).DiskFileNum(), FileSize: 1 << 20, ValueSize: 1000},
+		{FileNum: base.FileNum(802).DiskFileNum(), FileSize: 300, ValueSize: 200},
+	}
 
 	m3 := (&FileMetadata{
 		FileNum:      807,
@@ -93,6 +101,19 @@ func TestVersionEditRoundTrip(t *testing.T) {
 	)
 	m4.InitPhysicalBacking()
 
+	// The FileBacking of a virtual sstable isn't decoded, only its file number.
+	m5 := (&FileMetadata{
+		FileNum:        810,
+		Size:           400,
+		SmallestSeqNum: 3,
+		LargestSeqNum:  5,
+		Virtual:        true,
+	}).ExtendPointKeyBounds(
+		cmp,
+		base.MakeInternalKey([]byte("a"), 4, base.InternalKeyKindSet),
+		base.MakeExclusiveSentinelKey(base.InternalKeyKindRangeDelete, []byte("c")),
+	)
+
 	testCases := []VersionEdit{
 		// An empty version edit.
 		{},
@@ -131,6 +152,36 @@ func TestVersionEditRoundTrip(t *testing.T) {
 					Meta:  m4,
 				},
 			},
+			ApplicationMetadata: []byte("applied-index=66"),
+		},
+		// A version edit with empty application metadata.
+		{
+			ApplicationMetadata: []byte{},
+		},
+		// A version edit virtualizing a physical sstable.
+		{
+			DeletedFiles: map[DeletedFileEntry]*FileMetadata{
+				{Level: 6, FileNum: 808}: nil,
+			},
+			NewFiles: []NewFileEntry{
+				{
+					Level:          6,
+					Meta:           m5,
+					BackingFileNum: base.FileNum(808).DiskFileNum(),
+				},
+			},
+			CreatedBackingTables: []*FileBacking{
+				{
+					DiskFileNum: base.FileNum(808).DiskFileNum(),
+					Size:        8080,
+					BlobReferences: []BlobReference{
+						{FileNum: base.FileNum(801).DiskFileNum(), FileSize: 1 << 20, ValueSize: 1000},
+					},
+				},
+			},
+			RemovedBackingTables: []base.DiskFileNum{
+				base.FileNum(800).DiskFileNum(),
+			},
 		},
 	}
 	for _, tc := range testCases {
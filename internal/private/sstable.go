@@ -27,3 +27,8 @@ var SSTableWriterDisableKeyOrderChecks func(interface{})
 // sstable writers are not permitted to edit. It's an untyped interface{} to
 // avoid a cyclic dependency.
 var SSTableInternalProperties interface{}
+
+// SSTablePrefixReplacementOpt is a hook for specifying the prefix replacement
+// applied to the keys of a table to sstable.NewReader. It is a
+// func(*base.PrefixReplacement) sstable.ReaderOption.
+var SSTablePrefixReplacementOpt func(p *base.PrefixReplacement) interface{}
@@ -86,7 +86,11 @@ var Comparer *base.Comparer = &base.Comparer{
 		return append(append(dst, a...), 0x00)
 	},
 	Split: split,
-	Name:  "pebble.internal.testkeys",
+	CompareTimestamps: func(a, b []byte) int {
+		// Newer versions sort first.
+		return -compareTimestamps(a, b)
+	},
+	Name: "pebble.internal.testkeys",
 }
 
 func compare(a, b []byte) int {
@@ -120,6 +120,9 @@ type IteratorStats struct {
 	ReverseStepCount [NumStatsKind]int
 	InternalStats    InternalIteratorStats
 	RangeKeyStats    RangeKeyIteratorStats
+	// Seeks describes the pattern of the calls to the Iterator's
+	// positioning methods.
+	Seeks IteratorSeekStats
 }
 
 var _ redact.SafeFormatter = &IteratorStats{}
@@ -224,21 +227,35 @@ type Iterator struct {
 	prefixOrFullSeekKey []byte
 	readSampling        readSampling
 	stats               IteratorStats
+	seekHistory         seekHistory
 	externalReaders     [][]*sstable.Reader
 
+	// prefixSuccBuf holds the seek key constructed by SeekPrefixLast.
+	prefixSuccBuf []byte
+
 	// Following fields used when constructing an iterator stack, eg, in Clone
 	// and SetOptions or when re-fragmenting a batch's range keys/range dels.
 	// Non-nil if this Iterator includes a Batch.
 	batch            *Batch
 	newIters         tableNewIters
+	prefixFilter     tablePrefixFilter
 	newIterRangeKey  keyspan.TableNewSpanIter
 	lazyCombinedIter lazyCombinedIter
 	seqNum           uint64
+	// snapshot is true if the Iterator reads through a Snapshot, in which case
+	// its seqNum is fixed and it cannot be refreshed.
+	snapshot bool
+	// snapshotLower and snapshotUpper are the bounds of the bounded Snapshot
+	// the Iterator reads through, to which its own bounds are constrained.
+	snapshotLower, snapshotUpper []byte
 	// batchSeqNum is used by Iterators over indexed batches to detect when the
 	// underlying batch has been mutated. The batch beneath an indexed batch may
 	// be mutated while the Iterator is open, but new keys are not surfaced
 	// until the next call to SetOptions.
 	batchSeqNum uint64
+	// ttl configures the expiration of the point keys read by the point
+	// iterator, if any.
+	ttl *TTLOptions
 	// batch{PointIter,RangeDelIter,RangeKeyIter} are used when the Iterator is
 	// configured to read through an indexed batch. If a batch is set, these
 	// iterators will be included within the iterator stack regardless of
@@ -253,6 +270,10 @@ type Iterator struct {
 	// During SetOptions on an iterator over an indexed batch, this field is
 	// used to update the merging iterator's batch snapshot.
 	merging *mergingIter
+	// deletions holds the state of an iterator configured with
+	// IncludeDeletions. It's non-nil iff the point iterator stack surfaces
+	// range deletions.
+	deletions *iteratorDeletionsState
 
 	// Keeping the bools here after all the 8 byte aligned fields shrinks the
 	// sizeof this struct by 24 bytes.
@@ -274,6 +295,14 @@ type Iterator struct {
 	pos iterPos
 	// Relates to the prefixOrFullSeekKey field above.
 	hasPrefix bool
+	// reversePrefix is set by SeekPrefixLT and SeekPrefixLast, in which case
+	// the iterator is constrained to the keys with the prefix stored in
+	// prefixOrFullSeekKey. Unlike with hasPrefix, the internal iterators are
+	// not in prefix iteration mode.
+	reversePrefix bool
+	// The kind of the current entry. Always IterEntryValue unless the
+	// iterator is configured with IncludeDeletions.
+	entryKind IterEntryKind
 	// Used for deriving the value of SeekPrefixGE(..., trySeekUsingNext),
 	// and SeekGE/SeekLT optimizations
 	lastPositioningOp lastPositioningOpKind
@@ -518,6 +547,7 @@ type readSampling struct {
 func (i *Iterator) findNextEntry(limit []byte) {
 	i.iterValidityState = IterExhausted
 	i.pos = iterPosCurForward
+	i.entryKind = IterEntryValue
 	if i.opts.rangeKeys() && i.rangeKey != nil {
 		i.rangeKey.rangeKeyOnly = false
 	}
@@ -530,7 +560,7 @@ func (i *Iterator) findNextEntry(limit []byte) {
 	for i.iterKey != nil {
 		key := *i.iterKey
 
-		if i.hasPrefix {
+		if i.hasPrefix || i.reversePrefix {
 			if n := i.split(key.UserKey); !i.equal(i.prefixOrFullSeekKey, key.UserKey[:n]) {
 				return
 			}
@@ -569,9 +599,32 @@ func (i *Iterator) findNextEntry(limit []byte) {
 			return
 
 		case InternalKeyKindDelete, InternalKeyKindSingleDelete:
+			if i.opts.IncludeDeletions {
+				i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+				i.key = i.keyBuf
+				i.value = LazyValue{}
+				i.entryKind = IterEntryDelete
+				i.iterValidityState = IterValid
+				return
+			}
 			i.nextUserKey()
 			continue
 
+		case InternalKeyKindRangeDelete:
+			// Range deletions are only interleaved with the point keys if the
+			// iterator is configured with IncludeDeletions.
+			if i.deletions == nil {
+				i.err = base.CorruptionErrorf("pebble: unexpected range deletion %s", key.Pretty(i.comparer.FormatKey))
+				return
+			}
+			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+			i.key = i.keyBuf
+			i.value = LazyValue{}
+			i.entryKind = IterEntryRangeDelete
+			i.deletions.saveSpan()
+			i.iterValidityState = IterValid
+			return
+
 		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
@@ -704,6 +757,13 @@ func (i *Iterator) nextUserKey() {
 		i.keyBuf = append(i.keyBuf[:0], i.iterKey.UserKey...)
 		i.key = i.keyBuf
 	}
+	if i.iterKey.Kind() == InternalKeyKindRangeDelete {
+		// A range deletion surfaced by IncludeDeletions is interleaved before
+		// the point keys with the same user key, which follow it.
+		i.iterKey, i.iterValue = i.iter.Next()
+		i.stats.ForwardStepCount[InternalIterCall]++
+		return
+	}
 	for {
 		i.iterKey, i.iterValue = i.iter.Next()
 		i.stats.ForwardStepCount[InternalIterCall]++
@@ -740,7 +800,7 @@ func (i *Iterator) maybeSampleRead() {
 	if i.iterValidityState != IterValid {
 		return
 	}
-	if i.readState == nil {
+	if i.readState == nil || i.opts.DisableReadSampling {
 		return
 	}
 	if i.readSampling.forceReadSampling {
@@ -772,7 +832,7 @@ func (i *Iterator) maybeSampleRead() {
 func (i *Iterator) sampleRead() {
 	var topFile *manifest.FileMetadata
 	topLevel, numOverlappingLevels := numLevels, 0
-	if mi, ok := i.iter.(*mergingIter); ok {
+	if mi, ok := unwrapReadTimestampIter(unwrapTTLIter(i.iter)).(*mergingIter); ok {
 		if len(mi.levels) > 1 {
 			mi.ForEachLevelIter(func(li *levelIter) bool {
 				l := manifest.LevelToInt(li.level)
@@ -833,6 +893,7 @@ func (i *Iterator) sampleRead() {
 func (i *Iterator) findPrevEntry(limit []byte) {
 	i.iterValidityState = IterExhausted
 	i.pos = iterPosCurReverse
+	i.entryKind = IterEntryValue
 	if i.opts.rangeKeys() && i.rangeKey != nil {
 		i.rangeKey.rangeKeyOnly = false
 	}
@@ -870,7 +931,11 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 		firstLoopIter = false
 
 		if i.iterValidityState == IterValid {
-			if !i.equal(key.UserKey, i.key) {
+			// A range deletion surfaced by IncludeDeletions precedes the point
+			// keys with the same user key, so the point key found must be
+			// returned before it.
+			if !i.equal(key.UserKey, i.key) ||
+				(key.Kind() == InternalKeyKindRangeDelete && i.entryKind != IterEntryRangeDelete) {
 				// We've iterated to the previous user key.
 				i.pos = iterPosPrev
 				if valueMerger != nil {
@@ -900,6 +965,13 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 				return
 			}
 		}
+		if i.reversePrefix {
+			if n := i.split(key.UserKey); !i.equal(i.prefixOrFullSeekKey, key.UserKey[:n]) {
+				// The iterator is left positioned at the preceding key, which
+				// a subsequent Next steps past.
+				return
+			}
+		}
 
 		switch key.Kind() {
 		case InternalKeyKindRangeKeySet:
@@ -933,8 +1005,17 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			i.value = LazyValue{}
 			i.iterValidityState = IterExhausted
 			valueMerger = nil
+			if i.opts.IncludeDeletions {
+				i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+				i.key = i.keyBuf
+				i.entryKind = IterEntryDelete
+				i.iterValidityState = IterValid
+			}
 			i.iterKey, i.iterValue = i.iter.Prev()
 			i.stats.ReverseStepCount[InternalIterCall]++
+			if i.iterValidityState == IterValid {
+				continue
+			}
 			// Compare with the limit. We could optimize by only checking when
 			// we step to the previous user key, but detecting that requires a
 			// comparison too. Note that this position may already passed a
@@ -951,7 +1032,29 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			}
 			continue
 
+		case InternalKeyKindRangeDelete:
+			// Range deletions are only interleaved with the point keys if the
+			// iterator is configured with IncludeDeletions. As they're
+			// interleaved at the maximum sequence number, this is the final
+			// entry at this user key.
+			if i.deletions == nil {
+				i.err = base.CorruptionErrorf("pebble: unexpected range deletion %s", key.Pretty(i.comparer.FormatKey))
+				i.iterValidityState = IterExhausted
+				return
+			}
+			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+			i.key = i.keyBuf
+			i.value = LazyValue{}
+			i.entryKind = IterEntryRangeDelete
+			i.deletions.saveSpan()
+			i.iterValidityState = IterValid
+			i.iterKey, i.iterValue = i.iter.Prev()
+			i.stats.ReverseStepCount[InternalIterCall]++
+			valueMerger = nil
+			continue
+
 		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+			i.entryKind = IterEntryValue
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
 			// iterValue is owned by i.iter and could change after the Prev()
@@ -967,7 +1070,10 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			continue
 
 		case InternalKeyKindMerge:
-			if i.iterValidityState == IterExhausted {
+			if i.iterValidityState == IterExhausted || i.entryKind == IterEntryDelete {
+				// NB: A point tombstone surfaced by IncludeDeletions is
+				// shadowed by the newer merge, which has no base value.
+				i.entryKind = IterEntryValue
 				i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 				i.key = i.keyBuf
 				i.saveRangeKey()
@@ -1060,6 +1166,9 @@ func (i *Iterator) prevUserKey() {
 		i.keyBuf = append(i.keyBuf[:0], i.iterKey.UserKey...)
 		i.key = i.keyBuf
 	}
+	// A range deletion surfaced by IncludeDeletions is interleaved before the
+	// point keys with the same user key, so it precedes them.
+	fromPointKey := i.iterKey.Kind() != InternalKeyKindRangeDelete
 	for {
 		i.iterKey, i.iterValue = i.iter.Prev()
 		i.stats.ReverseStepCount[InternalIterCall]++
@@ -1069,6 +1178,9 @@ func (i *Iterator) prevUserKey() {
 		if !i.equal(i.key, i.iterKey.UserKey) {
 			break
 		}
+		if fromPointKey && i.iterKey.Kind() == InternalKeyKindRangeDelete {
+			break
+		}
 	}
 }
 
@@ -1133,6 +1245,14 @@ func (i *Iterator) mergeNext(key InternalKey, valueMerger ValueMerger) {
 	}
 }
 
+// sampleHotKey samples the seek of the iterator to the given key, if the DB
+// tracks hot keys.
+func (i *Iterator) sampleHotKey(key []byte) {
+	if i.readState != nil && i.readState.db.hotKeys != nil {
+		i.readState.db.hotKeys.sampleRead(key)
+	}
+}
+
 // SeekGE moves the iterator to the first key/value pair whose key is greater
 // than or equal to the given key. Returns true if the iterator is pointing at
 // a valid entry and false otherwise.
@@ -1152,6 +1272,7 @@ func (i *Iterator) SeekGE(key []byte) bool {
 // guarantees it will surface any range keys with bounds overlapping the
 // keyspace [key, limit).
 func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
+	i.sampleHotKey(key)
 	if i.rangeKey != nil {
 		// NB: Check Valid() before clearing requiresReposition.
 		i.rangeKey.prevPosHadRangeKey = i.rangeKey.hasRangeKey && i.Valid()
@@ -1177,7 +1298,9 @@ func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
 	i.requiresReposition = false
 	i.err = nil // clear cached iteration error
 	i.hasPrefix = false
+	i.reversePrefix = false
 	i.stats.ForwardSeekCount[InterfaceCall]++
+	i.recordSeek(seekGEOp, key)
 	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
 		key = lowerBound
 	} else if upperBound := i.opts.GetUpperBound(); upperBound != nil && i.cmp(key, upperBound) > 0 {
@@ -1316,6 +1439,7 @@ func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
 // ImmediateSuccessor method. For example, a SeekPrefixGE("a@9") call with the
 // prefix "a" will truncate range key bounds to [a,ImmediateSuccessor(a)].
 func (i *Iterator) SeekPrefixGE(key []byte) bool {
+	i.sampleHotKey(key)
 	if i.rangeKey != nil {
 		// NB: Check Valid() before clearing requiresReposition.
 		i.rangeKey.prevPosHadRangeKey = i.rangeKey.hasRangeKey && i.Valid()
@@ -1340,6 +1464,7 @@ func (i *Iterator) SeekPrefixGE(key []byte) bool {
 	i.requiresReposition = false
 	i.err = nil // clear cached iteration error
 	i.stats.ForwardSeekCount[InterfaceCall]++
+	i.recordSeek(seekPrefixGEOp, key)
 	if i.comparer.Split == nil {
 		panic("pebble: split must be provided for SeekPrefixGE")
 	}
@@ -1391,6 +1516,7 @@ func (i *Iterator) SeekPrefixGE(key []byte) bool {
 		i.prefixOrFullSeekKey = i.prefixOrFullSeekKey[:prefixLen]
 	}
 	i.hasPrefix = true
+	i.reversePrefix = false
 	copy(i.prefixOrFullSeekKey, keyPrefix)
 
 	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
@@ -1446,6 +1572,7 @@ func (i *Iterator) SeekLT(key []byte) bool {
 // guarantees it will surface any range keys with bounds overlapping the
 // keyspace up to limit.
 func (i *Iterator) SeekLTWithLimit(key []byte, limit []byte) IterValidityState {
+	i.sampleHotKey(key)
 	if i.rangeKey != nil {
 		// NB: Check Valid() before clearing requiresReposition.
 		i.rangeKey.prevPosHadRangeKey = i.rangeKey.hasRangeKey && i.Valid()
@@ -1471,12 +1598,14 @@ func (i *Iterator) SeekLTWithLimit(key []byte, limit []byte) IterValidityState {
 	i.requiresReposition = false
 	i.err = nil // clear cached iteration error
 	i.stats.ReverseSeekCount[InterfaceCall]++
+	i.recordSeek(seekLTOp, key)
 	if upperBound := i.opts.GetUpperBound(); upperBound != nil && i.cmp(key, upperBound) > 0 {
 		key = upperBound
 	} else if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
 		key = lowerBound
 	}
 	i.hasPrefix = false
+	i.reversePrefix = false
 	seekInternalIter := true
 	// The following noop optimization only applies when i.batch == nil, since
 	// an iterator over a batch is iterating over mutable data, that may have
@@ -1520,6 +1649,67 @@ func (i *Iterator) SeekLTWithLimit(key []byte, limit []byte) IterValidityState {
 	return i.iterValidityState
 }
 
+// SeekPrefixLT moves the iterator to the last key/value pair whose key is less
+// than the given key and has the same prefix as the given key, as determined
+// by the Comparer's Split function. Returns true if the iterator is pointing at
+// a valid entry and false otherwise.
+//
+// Subsequent calls to Prev and Next are constrained to the keys with the same
+// prefix, and the iterator is exhausted when it reaches a key with a
+// different prefix. Unlike SeekPrefixGE, SeekPrefixLT doesn't consult bloom
+// filters, so iterating in either direction is permitted.
+//
+// SeekPrefixLT is not supported with range keys.
+func (i *Iterator) SeekPrefixLT(key []byte) bool {
+	if i.comparer.Split == nil {
+		panic("pebble: split must be provided for SeekPrefixLT")
+	}
+	return i.seekPrefixLT(key[:i.split(key)], key)
+}
+
+// SeekPrefixLast moves the iterator to the last key/value pair with the given
+// prefix, eg, the latest version of a key whose prefix excludes its version
+// suffix. Returns true if the iterator is pointing at a valid entry and false
+// otherwise. It's equivalent to SeekPrefixLT with a key that sorts after all
+// the keys with the prefix, and requires the Comparer's Split and
+// ImmediateSuccessor functions.
+//
+// SeekPrefixLast is not supported with range keys.
+func (i *Iterator) SeekPrefixLast(prefix []byte) bool {
+	if i.comparer.Split == nil || i.comparer.ImmediateSuccessor == nil {
+		panic("pebble: split and ImmediateSuccessor must be provided for SeekPrefixLast")
+	}
+	// The immediate successor of the prefix sorts after all the keys with the
+	// prefix.
+	i.prefixSuccBuf = i.comparer.ImmediateSuccessor(i.prefixSuccBuf[:0], prefix)
+	return i.seekPrefixLT(prefix, i.prefixSuccBuf)
+}
+
+func (i *Iterator) seekPrefixLT(prefix, key []byte) bool {
+	if i.opts.rangeKeys() {
+		panic("pebble: reverse prefix iteration is not supported with range keys")
+	}
+	i.lastPositioningOp = unknownLastPositionOp
+	i.batchJustRefreshed = false
+	i.requiresReposition = false
+	i.err = nil // clear cached iteration error
+	i.stats.ReverseSeekCount[InterfaceCall]++
+	i.recordSeek(seekLTOp, key)
+	if upperBound := i.opts.GetUpperBound(); upperBound != nil && i.cmp(key, upperBound) > 0 {
+		key = upperBound
+	} else if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
+		key = lowerBound
+	}
+	i.hasPrefix = false
+	i.reversePrefix = true
+	i.prefixOrFullSeekKey = append(i.prefixOrFullSeekKey[:0], prefix...)
+	i.iterKey, i.iterValue = i.iter.SeekLT(key, base.SeekLTFlagsNone)
+	i.stats.ReverseSeekCount[InternalIterCall]++
+	i.findPrevEntry(nil)
+	i.maybeSampleRead()
+	return i.iterValidityState == IterValid
+}
+
 // First moves the iterator the the first key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) First() bool {
@@ -1541,10 +1731,12 @@ func (i *Iterator) First() bool {
 	}
 	i.err = nil // clear cached iteration error
 	i.hasPrefix = false
+	i.reversePrefix = false
 	i.batchJustRefreshed = false
 	i.lastPositioningOp = unknownLastPositionOp
 	i.requiresReposition = false
 	i.stats.ForwardSeekCount[InterfaceCall]++
+	i.recordSeek(firstOp, nil)
 
 	i.iterFirstWithinBounds()
 	i.findNextEntry(nil)
@@ -1573,10 +1765,12 @@ func (i *Iterator) Last() bool {
 	}
 	i.err = nil // clear cached iteration error
 	i.hasPrefix = false
+	i.reversePrefix = false
 	i.batchJustRefreshed = false
 	i.lastPositioningOp = unknownLastPositionOp
 	i.requiresReposition = false
 	i.stats.ReverseSeekCount[InterfaceCall]++
+	i.recordSeek(lastOp, nil)
 
 	i.iterLastWithinBounds()
 	i.findPrevEntry(nil)
@@ -1624,7 +1818,7 @@ func (i *Iterator) NextPrefix() bool {
 		i.iterValidityState = IterExhausted
 		return false
 	}
-	if i.hasPrefix {
+	if i.hasPrefix || i.reversePrefix {
 		i.iterValidityState = IterExhausted
 		return false
 	}
@@ -1724,6 +1918,7 @@ func (i *Iterator) nextPrefix() IterValidityState {
 	}
 
 	i.stats.ForwardStepCount[InterfaceCall]++
+	i.seekHistory.steps++
 	i.findNextEntry(nil /* limit */)
 	i.maybeSampleRead()
 	return i.iterValidityState
@@ -1757,6 +1952,7 @@ func (i *Iterator) internalNextPrefix(currKeyPrefixLen int) {
 
 func (i *Iterator) nextWithLimit(limit []byte) IterValidityState {
 	i.stats.ForwardStepCount[InterfaceCall]++
+	i.seekHistory.steps++
 	if i.hasPrefix {
 		if limit != nil {
 			i.err = errors.New("cannot use limit with prefix iteration")
@@ -1772,6 +1968,11 @@ func (i *Iterator) nextWithLimit(limit []byte) IterValidityState {
 			return i.iterValidityState
 		}
 	}
+	if i.reversePrefix && i.pos == iterPosCurForward && i.iterValidityState == IterExhausted {
+		// No-op, the iterator already stepped past the keys with the prefix.
+		// Stepping further would leave the iterator unable to return to them.
+		return i.iterValidityState
+	}
 	if i.err != nil {
 		return i.iterValidityState
 	}
@@ -1801,15 +2002,20 @@ func (i *Iterator) nextWithLimit(limit []byte) IterValidityState {
 	case iterPosCurReverse:
 		// Switching directions.
 		// Unless the iterator was exhausted, reverse iteration needs to
-		// position the iterator at iterPosPrev.
-		if i.iterKey != nil {
+		// position the iterator at iterPosPrev. Reverse prefix iteration may
+		// also be exhausted at the key preceding the prefix.
+		if i.iterKey != nil && !i.reversePrefix {
 			i.err = errors.New("switching from reverse to forward but iter is not at prev")
 			i.iterValidityState = IterExhausted
 			return i.iterValidityState
 		}
-		// We're positioned before the first key. Need to reposition to point to
-		// the first key.
-		i.iterFirstWithinBounds()
+		if i.iterKey == nil {
+			// We're positioned before the first key. Need to reposition to
+			// point to the first key.
+			i.iterFirstWithinBounds()
+		} else {
+			i.nextUserKey()
+		}
 	case iterPosCurReversePaused:
 		// Switching directions.
 		// The iterator must not be exhausted since it paused.
@@ -1860,6 +2066,7 @@ func (i *Iterator) Prev() bool {
 // keyspace up to limit.
 func (i *Iterator) PrevWithLimit(limit []byte) IterValidityState {
 	i.stats.ReverseStepCount[InterfaceCall]++
+	i.seekHistory.steps++
 	if i.err != nil {
 		return i.iterValidityState
 	}
@@ -1886,6 +2093,11 @@ func (i *Iterator) PrevWithLimit(limit []byte) IterValidityState {
 		i.iterValidityState = IterExhausted
 		return i.iterValidityState
 	}
+	if i.reversePrefix && i.pos == iterPosCurReverse && i.iterValidityState == IterExhausted {
+		// No-op, the iterator already stepped past the keys with the prefix.
+		// Stepping further would leave the iterator unable to return to them.
+		return i.iterValidityState
+	}
 	switch i.pos {
 	case iterPosCurForward:
 		// Switching directions, and will handle this below.
@@ -2193,9 +2405,11 @@ func (i *Iterator) Close() error {
 	err := i.err
 
 	if i.readState != nil {
+		i.readState.db.rangeKeyMetrics.recordIterator(&i.stats.RangeKeyStats)
 		if i.readSampling.pendingCompactions.size > 0 {
 			// Copy pending read compactions using db.mu.Lock()
 			i.readState.db.mu.Lock()
+			i.readState.db.mu.compact.readCompactionsRequested += int64(i.readSampling.pendingCompactions.size)
 			i.readState.db.mu.compact.readCompactions.combine(&i.readSampling.pendingCompactions, i.cmp)
 			reschedule := i.readState.db.mu.compact.rescheduleReadCompaction
 			i.readState.db.mu.compact.rescheduleReadCompaction = false
@@ -2248,6 +2462,11 @@ func (i *Iterator) Close() error {
 		} else {
 			alloc.prefixOrFullSeekKey = i.prefixOrFullSeekKey
 		}
+		if cap(i.seekHistory.lastKey) >= maxKeyBufCacheSize {
+			alloc.seekKeyBuf = nil
+		} else {
+			alloc.seekKeyBuf = i.seekHistory.lastKey
+		}
 		for j := range i.boundsBuf {
 			if cap(i.boundsBuf[j]) >= maxKeyBufCacheSize {
 				alloc.boundsBuf[j] = nil
@@ -2327,6 +2546,7 @@ func (i *Iterator) processBounds(lower, upper []byte) {
 	// Copy the user-provided bounds into an Iterator-owned buffer. We can't
 	// overwrite the current bounds, because some internal iterators compare old
 	// and new bounds for optimizations.
+	lower, upper = constrainBounds(i.cmp, lower, upper, i.snapshotLower, i.snapshotUpper)
 
 	buf := i.boundsBuf[i.boundsBufIdx][:0]
 	if lower != nil {
@@ -2378,6 +2598,9 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 			panic(err)
 		}
 	}
+	if o.IncludeDeletions && o.rangeKeys() {
+		panic("pebble: IncludeDeletions is not supported with range keys")
+	}
 
 	// Ensure that the Iterator appears exhausted, regardless of whether we
 	// actually have to invalidate the internal iterator. Optimizations that
@@ -2405,10 +2628,19 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 
 	// If either options specify block property filters for an iterator stack,
 	// reconstruct it.
+	// If IncludeDeletions changed, the point iterator stack must add or remove
+	// the interleaved range deletions. If ReadTimestamp changed, the point
+	// iterator stack must hide different versions. If CacheMode changed, the
+	// level iterators must read blocks with the new mode.
 	if i.pointIter != nil && (closeBoth || len(o.PointKeyFilters) > 0 || len(i.opts.PointKeyFilters) > 0 ||
-		o.RangeKeyMasking.Filter != nil || i.opts.RangeKeyMasking.Filter != nil) {
+		o.RangeKeyMasking.Filter != nil || i.opts.RangeKeyMasking.Filter != nil ||
+		o.IncludeDeletions != i.opts.IncludeDeletions ||
+		!bytes.Equal(o.ReadTimestamp, i.opts.ReadTimestamp) ||
+		o.skipFilesBelowSeqNum != i.opts.skipFilesBelowSeqNum ||
+		o.CacheMode != i.opts.CacheMode) {
 		i.err = firstError(i.err, i.pointIter.Close())
 		i.pointIter = nil
+		i.deletions = nil
 	}
 	if i.rangeKey != nil {
 		if closeBoth || len(o.RangeKeyFilters) > 0 || len(i.opts.RangeKeyFilters) > 0 {
@@ -2440,15 +2672,18 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 			// incorrect after the inclusion of new batch mutations.
 			i.batchJustRefreshed = true
 			if i.pointIter != nil && i.batch.countRangeDels > 0 {
-				if i.batchRangeDelIter.Count() == 0 {
+				if i.batchRangeDelIter.Count() == 0 || i.deletions != nil {
 					// When we constructed this iterator, there were no
 					// rangedels in the batch. Iterator construction will
 					// have excluded the batch rangedel iterator from the
 					// point iterator stack. We need to reconstruct the
 					// point iterator to add i.batchRangeDelIter into the
-					// iterator stack.
+					// iterator stack. The point iterator is also
+					// reconstructed if it surfaces range deletions, so as to
+					// refresh the batch's interleaved range deletions.
 					i.err = firstError(i.err, i.pointIter.Close())
 					i.pointIter = nil
+					i.deletions = nil
 				} else {
 					// There are range deletions in the batch and we already
 					// have a batch rangedel iterator. We can update the
@@ -2506,7 +2741,8 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 		(i.pointIter != nil || !i.opts.pointKeys()) &&
 		(i.rangeKey != nil || !i.opts.rangeKeys() || i.opts.KeyTypes == IterKeyTypePointsAndRanges) &&
 		i.equal(o.RangeKeyMasking.Suffix, i.opts.RangeKeyMasking.Suffix) &&
-		o.UseL6Filters == i.opts.UseL6Filters {
+		o.UseL6Filters == i.opts.UseL6Filters && o.BulkScan == i.opts.BulkScan &&
+		o.CacheMode == i.opts.CacheMode {
 		// The options are identical, so we can likely use the fast path. In
 		// addition to all the above constraints, we cannot use the fast path if
 		// configured to perform lazy combined iteration but an indexed batch
@@ -2557,9 +2793,92 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 	finishInitializingIter(i.ctx, i.alloc)
 }
 
+// Refresh updates the Iterator's view of the DB to include the data committed
+// since the Iterator was created or last refreshed, as if the Iterator had been
+// recreated with the same options. It's intended for consumers tailing the DB:
+// once the Iterator is exhausted, it may be refreshed and repositioned past the
+// last key it returned to observe newly committed keys. The view of an indexed
+// batch the Iterator reads through is unchanged (see SetOptions).
+//
+// If the LSM hasn't changed since the Iterator's view was last established,
+// only the memtable iterators are reconstructed: the iterators over the
+// sstables, along with their loaded blocks, are reused, making the next seek
+// cheaper than recreating the Iterator. Otherwise, the Iterator is
+// reconstructed in place.
+//
+// Like SetOptions, Refresh leaves the Iterator unpositioned: the caller must
+// call an absolute positioning method. Refresh panics if the Iterator reads
+// through a Snapshot or external sstables.
+func (i *Iterator) Refresh() {
+	if i.readState == nil {
+		panic(ErrClosed)
+	}
+	if i.snapshot || i.externalReaders != nil {
+		panic("pebble: Refresh is not supported for Iterators over snapshots or external sstables")
+	}
+	i.requiresReposition = true
+	i.invalidate()
+
+	prevReadState := i.readState
+	prevMemtables := i.visibleMemtables()
+	d := prevReadState.db
+	i.readState = d.loadReadState()
+	i.seqNum = d.mu.versions.visibleSeqNum.Load()
+	memtables := i.visibleMemtables()
+
+	// The range key iterator stack reflects the range keys of the memtables as
+	// of its construction, so it must be reconstructed.
+	if i.rangeKey != nil {
+		i.err = firstError(i.err, i.rangeKey.rangeKeyIter.Close())
+		i.rangeKey = nil
+	}
+
+	// The point iterator stack may be reused if it reads from the same
+	// version and memtables. The memtable iterators are reconstructed, since
+	// the range deletions of a memtable are fragmented when its range deletion
+	// iterator is constructed.
+	reuse := i.err == nil && i.pointIter != nil && i.deletions == nil &&
+		!i.opts.rangeKeys() && i.readState.current == prevReadState.current &&
+		len(memtables) == len(prevMemtables)
+	for j := 0; reuse && j < len(memtables); j++ {
+		reuse = memtables[j] == prevMemtables[j]
+	}
+	if reuse {
+		// The memtable levels follow the batch level, if any, from newest to
+		// oldest.
+		levels := i.merging.levels
+		if i.batch != nil {
+			levels = levels[1:]
+		}
+		for j := range memtables {
+			l := &levels[len(memtables)-1-j]
+			i.err = firstError(i.err, l.iter.Close())
+			if l.rangeDelIter != nil {
+				i.err = firstError(i.err, l.rangeDelIter.Close())
+			}
+			l.iter = memtables[j].newIter(&i.opts)
+			l.rangeDelIter = memtables[j].newRangeDelIter(&i.opts)
+		}
+		i.merging.snapshot = i.seqNum
+		if t, ok := i.pointIter.(*ttlIter); ok {
+			t.now = i.ttl.Now()
+		}
+	} else if i.pointIter != nil {
+		i.err = firstError(i.err, i.pointIter.Close())
+		i.pointIter = nil
+		i.deletions = nil
+	}
+	prevReadState.unref()
+
+	if !reuse {
+		finishInitializingIter(i.ctx, i.alloc)
+	}
+}
+
 func (i *Iterator) invalidate() {
 	i.lastPositioningOp = invalidatedLastPositionOp
 	i.hasPrefix = false
+	i.reversePrefix = false
 	i.iterKey = nil
 	i.iterValue = LazyValue{}
 	i.err = nil
@@ -2585,7 +2904,7 @@ func (i *Iterator) Metrics() IteratorMetrics {
 	m := IteratorMetrics{
 		ReadAmp: 1,
 	}
-	if mi, ok := i.iter.(*mergingIter); ok {
+	if mi, ok := unwrapReadTimestampIter(unwrapTTLIter(i.iter)).(*mergingIter); ok {
 		m.ReadAmp = len(mi.levels)
 	}
 	return m
@@ -2593,12 +2912,21 @@ func (i *Iterator) Metrics() IteratorMetrics {
 
 // ResetStats resets the stats to 0.
 func (i *Iterator) ResetStats() {
+	if i.readState != nil {
+		i.readState.db.rangeKeyMetrics.recordIterator(&i.stats.RangeKeyStats)
+	}
 	i.stats = IteratorStats{}
+	i.seekHistory = seekHistory{lastKey: i.seekHistory.lastKey[:0]}
 }
 
 // Stats returns the current stats.
 func (i *Iterator) Stats() IteratorStats {
-	return i.stats
+	stats := i.stats
+	// Account for the steps following the last seek.
+	if i.seekHistory.seeked {
+		stats.Seeks.StepsBetweenSeeks[seekStepBucket(i.seekHistory.steps)]++
+	}
+	return stats
 }
 
 // CloneOptions configures an iterator constructed through Iterator.Clone.
@@ -2663,12 +2991,18 @@ func (i *Iterator) CloneWithContext(ctx context.Context, opts CloneOptions) (*It
 		readState:           readState,
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
+		seekHistory:         seekHistory{lastKey: buf.seekKeyBuf},
 		boundsBuf:           buf.boundsBuf,
 		batch:               i.batch,
 		batchSeqNum:         i.batchSeqNum,
 		newIters:            i.newIters,
+		prefixFilter:        i.prefixFilter,
 		newIterRangeKey:     i.newIterRangeKey,
+		ttl:                 i.ttl,
 		seqNum:              i.seqNum,
+		snapshot:            i.snapshot,
+		snapshotLower:       i.snapshotLower,
+		snapshotUpper:       i.snapshotUpper,
 	}
 	dbi.processBounds(dbi.opts.LowerBound, dbi.opts.UpperBound)
 
@@ -2692,6 +3026,7 @@ func (stats *IteratorStats) Merge(o IteratorStats) {
 	}
 	stats.InternalStats.Merge(o.InternalStats)
 	stats.RangeKeyStats.Merge(o.RangeKeyStats)
+	stats.Seeks.Merge(o.Seeks)
 }
 
 func (stats *IteratorStats) String() string {
@@ -2723,6 +3058,17 @@ func (stats *IteratorStats) SafeFormat(s redact.SafePrinter, verb rune) {
 			humanize.SI.Uint64(stats.InternalStats.ValueBytes),
 			humanize.SI.Uint64(stats.InternalStats.PointsCoveredByRangeTombstones),
 		)
+		if stats.InternalStats.Readahead.Count != 0 {
+			s.Printf(", (readahead: (count %s, bytes %s, max %s))",
+				humanize.SI.Uint64(stats.InternalStats.Readahead.Count),
+				humanize.IEC.Uint64(stats.InternalStats.Readahead.Bytes),
+				humanize.IEC.Uint64(stats.InternalStats.Readahead.MaxSize))
+		}
+		if stats.InternalStats.Filter.Checks != 0 {
+			s.Printf(", (filter: (checks %s, negatives %s))",
+				humanize.SI.Uint64(stats.InternalStats.Filter.Checks),
+				humanize.SI.Uint64(stats.InternalStats.Filter.Negatives))
+		}
 		if stats.InternalStats.SeparatedPointValue.Count != 0 {
 			s.Printf(", (separated: (count %s, bytes %s, fetched %s)))",
 				humanize.SI.Uint64(stats.InternalStats.SeparatedPointValue.Count),
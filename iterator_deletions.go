@@ -0,0 +1,128 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// IterEntryKind describes the kind of the entry at the current position of an
+// Iterator. Iterators only surface entries other than IterEntryValue if they
+// are configured with IterOptions.IncludeDeletions.
+type IterEntryKind int8
+
+const (
+	// IterEntryValue is a live key and its value.
+	IterEntryValue IterEntryKind = iota
+	// IterEntryDelete is a point tombstone, ie, a DEL or SINGLEDEL, that is
+	// the newest visible version of its key. Its value is empty.
+	IterEntryDelete
+	// IterEntryRangeDelete is the start of a fragment of a range deletion. The
+	// bounds of the fragment are returned by Iterator.RangeDeleteBounds. Its
+	// value is empty.
+	IterEntryRangeDelete
+)
+
+// String implements fmt.Stringer.
+func (k IterEntryKind) String() string {
+	switch k {
+	case IterEntryValue:
+		return "value"
+	case IterEntryDelete:
+		return "delete"
+	case IterEntryRangeDelete:
+		return "range-delete"
+	default:
+		return "unknown"
+	}
+}
+
+// iteratorDeletionsState holds the state of an Iterator configured with
+// IncludeDeletions. The visible range deletions of the iterator's view of the
+// LSM are interleaved with its point keys, at their start keys.
+type iteratorDeletionsState struct {
+	iiter             keyspan.InterleavingIter
+	rangeDelMiter     keyspan.MergingIter
+	batchRangeDelIter keyspan.Iter
+	// start and end hold the bounds of the range deletion at the current
+	// position, and seqNum its largest visible sequence number.
+	start, end []byte
+	seqNum     uint64
+}
+
+// saveSpan saves the bounds of the range deletion at the current position of
+// the interleaving iterator.
+func (s *iteratorDeletionsState) saveSpan() {
+	span := s.iiter.Span()
+	s.start = append(s.start[:0], span.Start...)
+	s.end = append(s.end[:0], span.End...)
+	s.seqNum = 0
+	for _, k := range span.Keys {
+		if k.SeqNum() > s.seqNum {
+			s.seqNum = k.SeqNum()
+		}
+	}
+}
+
+// constructDeletionsIter wraps the point iterator with an interleaving iterator
+// that interleaves the range deletions of the batch, memtables and levels read
+// by the point iterator.
+func (i *Iterator) constructDeletionsIter(ctx context.Context, memtables flushableList) {
+	i.deletions = &iteratorDeletionsState{}
+	var rangeDelIters []keyspan.FragmentIterator
+	if i.batch != nil && i.batch.index != nil {
+		i.batch.initRangeDelIter(&i.opts, &i.deletions.batchRangeDelIter, i.batchSeqNum)
+		rangeDelIters = append(rangeDelIters, &i.deletions.batchRangeDelIter)
+	}
+	for j := len(memtables) - 1; j >= 0; j-- {
+		if rdi := memtables[j].newRangeDelIter(&i.opts); rdi != nil {
+			rangeDelIters = append(rangeDelIters, rdi)
+		}
+	}
+	addLevelIterForFiles := func(files manifest.LevelIterator, level manifest.Level) {
+		li := &keyspan.LevelIter{}
+		li.Init(keyspan.SpanIterOptions{}, i.comparer.Compare, tableNewRangeDelIter(ctx, i.newIters),
+			files, level, manifest.KeyTypePoint)
+		rangeDelIters = append(rangeDelIters, li)
+	}
+	current := i.readState.current
+	for j := len(current.L0SublevelFiles) - 1; j >= 0; j-- {
+		addLevelIterForFiles(current.L0SublevelFiles[j].Iter(), manifest.L0Sublevel(j))
+	}
+	for level := 1; level < len(current.Levels); level++ {
+		if current.Levels[level].Empty() {
+			continue
+		}
+		addLevelIterForFiles(current.Levels[level].Iter(), manifest.Level(level))
+	}
+
+	i.deletions.rangeDelMiter.Init(i.comparer.Compare, keyspan.VisibleTransform(i.seqNum),
+		new(keyspan.MergingBuffers), rangeDelIters...)
+	i.deletions.iiter.Init(&i.comparer, i.pointIter, &i.deletions.rangeDelMiter,
+		nil /* mask */, i.opts.LowerBound, i.opts.UpperBound)
+	i.pointIter = &i.deletions.iiter
+}
+
+// EntryKind returns the kind of the entry at the current position. It's always
+// IterEntryValue unless the iterator is configured with
+// IterOptions.IncludeDeletions.
+func (i *Iterator) EntryKind() IterEntryKind {
+	return i.entryKind
+}
+
+// RangeDeleteBounds returns the bounds of the range deletion fragment at the
+// current position, if EntryKind() is IterEntryRangeDelete. The fragment is
+// truncated to the iterator bounds. Its start precedes Key() if the iterator
+// was positioned within the fragment by a seek. The returned slices are only
+// valid until the next positioning operation.
+func (i *Iterator) RangeDeleteBounds() (start, end []byte) {
+	if i.entryKind != IterEntryRangeDelete || i.deletions == nil {
+		return nil, nil
+	}
+	return i.deletions.start, i.deletions.end
+}
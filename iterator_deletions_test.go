@@ -0,0 +1,125 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIteratorIncludeDeletions(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Keys in the levels, the memtable and an indexed batch.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("e"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	require.NoError(t, d.Delete([]byte("b"), nil))
+	require.NoError(t, d.DeleteRange([]byte("c"), []byte("f"), nil))
+	require.NoError(t, d.Set([]byte("d"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.SingleDelete([]byte("g"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("2"), nil))
+
+	entry := func(iter *Iterator) string {
+		switch iter.EntryKind() {
+		case IterEntryValue:
+			return fmt.Sprintf("%s=%s", iter.Key(), iter.Value())
+		case IterEntryRangeDelete:
+			start, end := iter.RangeDeleteBounds()
+			return fmt.Sprintf("%s:%s-%s", iter.EntryKind(), start, end)
+		default:
+			return fmt.Sprintf("%s:%s", iter.EntryKind(), iter.Key())
+		}
+	}
+	scan := func(iter *Iterator) (forward, reverse string) {
+		var entries []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			entries = append(entries, entry(iter))
+		}
+		require.NoError(t, iter.Error())
+		forward = strings.Join(entries, " ")
+		entries = entries[:0]
+		for valid := iter.Last(); valid; valid = iter.Prev() {
+			entries = append(entries, entry(iter))
+		}
+		require.NoError(t, iter.Error())
+		return forward, strings.Join(entries, " ")
+	}
+
+	iter := d.NewIter(&IterOptions{IncludeDeletions: true})
+	forward, reverse := scan(iter)
+	require.Equal(t, "a=1 delete:b range-delete:c-f c=2 d=2 delete:g", forward)
+	require.Equal(t, "delete:g d=2 c=2 range-delete:c-f delete:b a=1", reverse)
+
+	// Changing directions surfaces the entries in the same order.
+	require.True(t, iter.SeekGE([]byte("c")))
+	require.Equal(t, "range-delete:c-f", entry(iter))
+	require.True(t, iter.Next())
+	require.Equal(t, "c=2", entry(iter))
+	require.True(t, iter.Prev())
+	require.Equal(t, "range-delete:c-f", entry(iter))
+	require.True(t, iter.Prev())
+	require.Equal(t, "delete:b", entry(iter))
+	require.True(t, iter.Next())
+	require.Equal(t, "range-delete:c-f", entry(iter))
+	require.True(t, iter.SeekLT([]byte("d")))
+	require.Equal(t, "c=2", entry(iter))
+	require.True(t, iter.Next())
+	require.Equal(t, "d=2", entry(iter))
+
+	// Seeks within a range deletion surface it at the seek key.
+	require.True(t, iter.SeekGE([]byte("cc")))
+	require.Equal(t, "cc", string(iter.Key()))
+	require.Equal(t, "range-delete:c-f", entry(iter))
+	require.True(t, iter.Next())
+	require.Equal(t, "d=2", entry(iter))
+
+	// Disabling IncludeDeletions through SetOptions hides the deletions.
+	iter.SetOptions(&IterOptions{})
+	forward, _ = scan(iter)
+	require.Equal(t, "a=1 c=2 d=2", forward)
+	iter.SetOptions(&IterOptions{IncludeDeletions: true, LowerBound: []byte("d")})
+	forward, reverse = scan(iter)
+	require.Equal(t, "range-delete:d-f d=2 delete:g", forward)
+	require.Equal(t, "delete:g d=2 range-delete:d-f", reverse)
+	require.NoError(t, iter.Close())
+
+	// Deletions newer than a snapshot aren't visible to it.
+	iter = snap.NewIter(&IterOptions{IncludeDeletions: true})
+	forward, _ = scan(iter)
+	require.Equal(t, "a=1 b=1 c=1 e=1", forward)
+	require.NoError(t, iter.Close())
+
+	// The deletions of an indexed batch are surfaced, including those written
+	// after the iterator is created, once SetOptions refreshes the batch.
+	b := d.NewIndexedBatch()
+	require.NoError(t, b.Delete([]byte("a"), nil))
+	iter = b.NewIter(&IterOptions{IncludeDeletions: true})
+	forward, _ = scan(iter)
+	require.Equal(t, "delete:a delete:b range-delete:c-f c=2 d=2 delete:g", forward)
+	require.NoError(t, b.DeleteRange([]byte("a"), []byte("d"), nil))
+	iter.SetOptions(&IterOptions{IncludeDeletions: true})
+	forward, reverse = scan(iter)
+	require.Equal(t, "range-delete:a-c range-delete:c-d range-delete:d-f d=2 delete:g", forward)
+	require.Equal(t, "delete:g d=2 range-delete:d-f range-delete:c-d range-delete:a-c", reverse)
+	require.NoError(t, iter.Close())
+	require.NoError(t, b.Close())
+
+	require.Panics(t, func() {
+		d.NewIter(&IterOptions{IncludeDeletions: true, KeyTypes: IterKeyTypePointsAndRanges})
+	})
+}
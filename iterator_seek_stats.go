@@ -0,0 +1,130 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "math/bits"
+
+// NumSeekStepBuckets is the number of buckets of
+// IteratorSeekStats.StepsBetweenSeeks.
+const NumSeekStepBuckets = 8
+
+// IteratorSeekStats describes the pattern of the positioning calls made on an
+// Iterator, so that callers such as query planners can cost their access
+// paths based on the observed behavior of the storage engine. Along with
+// IteratorStats.InternalStats.Filter, it tells how selective seeks are, how
+// far iteration proceeds after seeking, and whether seeks are issued in key
+// order.
+type IteratorSeekStats struct {
+	// SeekGECount is the number of calls to SeekGE and SeekGEWithLimit.
+	SeekGECount int
+	// SeekPrefixGECount is the number of calls to SeekPrefixGE.
+	SeekPrefixGECount int
+	// SeekLTCount is the number of calls to SeekLT, SeekLTWithLimit,
+	// SeekPrefixLT and SeekPrefixLast.
+	SeekLTCount int
+	// FirstCount and LastCount are the numbers of calls to First and Last.
+	FirstCount int
+	LastCount  int
+	// StepsBetweenSeeks is a histogram of the number of steps (calls to Next,
+	// NextPrefix, Prev and their variants) following each seek, up to the
+	// next seek or until the stats are retrieved. Bucket 0 counts the seeks
+	// followed by no step, and bucket i > 0 the seeks followed by [2^(i-1),
+	// 2^i) steps, except for the last bucket which is unbounded.
+	StepsBetweenSeeks [NumSeekStepBuckets]int
+	// AscendingSeeks, DescendingSeeks and RepeatedSeeks count the seeks with
+	// a key (that is, other than First and Last) whose key is respectively
+	// greater than, less than, and equal to the key of the previous seek with
+	// a key.
+	AscendingSeeks  int
+	DescendingSeeks int
+	RepeatedSeeks   int
+}
+
+// Merge adds all of the argument's statistics to the receiver.
+func (s *IteratorSeekStats) Merge(o IteratorSeekStats) {
+	s.SeekGECount += o.SeekGECount
+	s.SeekPrefixGECount += o.SeekPrefixGECount
+	s.SeekLTCount += o.SeekLTCount
+	s.FirstCount += o.FirstCount
+	s.LastCount += o.LastCount
+	for i := range s.StepsBetweenSeeks {
+		s.StepsBetweenSeeks[i] += o.StepsBetweenSeeks[i]
+	}
+	s.AscendingSeeks += o.AscendingSeeks
+	s.DescendingSeeks += o.DescendingSeeks
+	s.RepeatedSeeks += o.RepeatedSeeks
+}
+
+// seekStepBucket returns the bucket of StepsBetweenSeeks counting the given
+// number of steps.
+func seekStepBucket(steps int) int {
+	b := bits.Len(uint(steps))
+	if b >= NumSeekStepBuckets {
+		b = NumSeekStepBuckets - 1
+	}
+	return b
+}
+
+// seekHistory tracks the state of an Iterator needed to compute its
+// IteratorSeekStats.
+type seekHistory struct {
+	// seeked is true once the iterator was positioned by a seek.
+	seeked bool
+	// steps is the number of steps since the last seek.
+	steps int
+	// lastKey is the key of the last seek with a key, if hasLastKey.
+	lastKey    []byte
+	hasLastKey bool
+}
+
+// seekOp identifies the seeks recorded in IteratorSeekStats.
+type seekOp int8
+
+const (
+	seekGEOp seekOp = iota
+	seekPrefixGEOp
+	seekLTOp
+	firstOp
+	lastOp
+)
+
+// recordSeek records a call to the given seek operation, with the given key
+// unless the operation is First or Last.
+func (i *Iterator) recordSeek(op seekOp, key []byte) {
+	s := &i.stats.Seeks
+	h := &i.seekHistory
+	switch op {
+	case seekGEOp:
+		s.SeekGECount++
+	case seekPrefixGEOp:
+		s.SeekPrefixGECount++
+	case seekLTOp:
+		s.SeekLTCount++
+	case firstOp:
+		s.FirstCount++
+	case lastOp:
+		s.LastCount++
+	}
+	if h.seeked {
+		s.StepsBetweenSeeks[seekStepBucket(h.steps)]++
+	}
+	h.seeked = true
+	h.steps = 0
+	if op == firstOp || op == lastOp {
+		return
+	}
+	if h.hasLastKey {
+		switch c := i.cmp(key, h.lastKey); {
+		case c > 0:
+			s.AscendingSeeks++
+		case c < 0:
+			s.DescendingSeeks++
+		default:
+			s.RepeatedSeeks++
+		}
+	}
+	h.lastKey = append(h.lastKey[:0], key...)
+	h.hasLastKey = true
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeekStepBucket(t *testing.T) {
+	for _, tc := range []struct {
+		steps, bucket int
+	}{
+		{0, 0}, {1, 1}, {2, 2}, {3, 2}, {4, 3}, {63, 6}, {64, 7}, {1 << 20, 7},
+	} {
+		require.Equal(t, tc.bucket, seekStepBucket(tc.steps), "steps=%d", tc.steps)
+	}
+}
+
+func TestIteratorSeekStats(t *testing.T) {
+	opts := &Options{Comparer: testkeys.Comparer, FS: vfs.NewMem()}
+	opts.Levels = []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%d", i)), nil, nil))
+	}
+	require.NoError(t, d.Flush())
+
+	iter := d.NewIter(nil)
+	require.True(t, iter.First())
+	for i := 0; i < 3; i++ {
+		require.True(t, iter.Next())
+	}
+	require.True(t, iter.SeekGE([]byte("k2")))
+	require.True(t, iter.SeekGE([]byte("k5")))
+	require.True(t, iter.Next())
+	require.True(t, iter.SeekGE([]byte("k5")))
+	require.True(t, iter.SeekLT([]byte("k3")))
+	require.True(t, iter.Prev())
+	require.True(t, iter.SeekPrefixGE([]byte("k7")))
+	require.False(t, iter.SeekPrefixGE([]byte("k8a")))
+	require.True(t, iter.Last())
+
+	stats := iter.Stats()
+	require.Equal(t, IteratorSeekStats{
+		SeekGECount:       3,
+		SeekPrefixGECount: 2,
+		SeekLTCount:       1,
+		FirstCount:        1,
+		LastCount:         1,
+		// First+3 steps, SeekGE(k5)+1 step and SeekLT+1 step; the others are
+		// followed by no step, including Last.
+		StepsBetweenSeeks: [NumSeekStepBuckets]int{5, 2, 1},
+		// k2 -> k5, k3 -> k7, k7 -> k8a.
+		AscendingSeeks: 3,
+		// k5 -> k3.
+		DescendingSeeks: 1,
+		// k5 -> k5.
+		RepeatedSeeks: 1,
+	}, stats.Seeks)
+	// Each SeekPrefixGE checks the filter of the single sstable, and the
+	// filter excludes the missing prefix.
+	require.Equal(t, uint64(2), stats.InternalStats.Filter.Checks)
+	require.Equal(t, uint64(1), stats.InternalStats.Filter.Negatives)
+
+	// Stats are cumulative with Merge, and reset by ResetStats.
+	var merged IteratorStats
+	merged.Merge(stats)
+	merged.Merge(stats)
+	require.Equal(t, 2*stats.Seeks.SeekGECount, merged.Seeks.SeekGECount)
+	require.Equal(t, 2*stats.Seeks.StepsBetweenSeeks[0], merged.Seeks.StepsBetweenSeeks[0])
+
+	iter.ResetStats()
+	require.Equal(t, IteratorSeekStats{}, iter.Stats().Seeks)
+	require.True(t, iter.SeekGE([]byte("k0")))
+	require.True(t, iter.Next())
+	// The first seek following a reset isn't compared with earlier seeks.
+	require.Equal(t, IteratorSeekStats{
+		SeekGECount:       1,
+		StepsBetweenSeeks: [NumSeekStepBuckets]int{1: 1},
+	}, iter.Stats().Seeks)
+	require.NoError(t, iter.Close())
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/bytealloc"
+	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/testkeys"
@@ -698,7 +699,9 @@ func TestReadSampling(t *testing.T) {
 					db:     d,
 					seqNum: InternalKeySeqNumMax,
 				}
-				iter = snap.NewIter(nil)
+				iter = snap.NewIter(&IterOptions{
+					DisableReadSampling: td.HasArg("disable-read-sampling"),
+				})
 				iter.readSampling.forceReadSampling = true
 			}
 			return runIterCmd(td, iter, false)
@@ -720,6 +723,12 @@ func TestReadSampling(t *testing.T) {
 			d.mu.Unlock()
 			return sb.String()
 
+		case "read-compactions-requested":
+			if d == nil {
+				return fmt.Sprintf("%s: db is not defined", td.Cmd)
+			}
+			return fmt.Sprint(d.Metrics().Compact.ReadRequestedCount)
+
 		case "iter-read-compactions":
 			if iter == nil {
 				return fmt.Sprintf("%s: iter is not defined", td.Cmd)
@@ -1704,6 +1713,242 @@ func TestIteratorStatsMerge(t *testing.T) {
 	require.Equal(t, expected, s)
 }
 
+func TestIteratorBulkScan(t *testing.T) {
+	c := cache.New(1 << 20)
+	defer c.Unref()
+	opts := &Options{
+		Cache:  c,
+		FS:     vfs.NewMem(),
+		Levels: []LevelOptions{{BlockSize: 256}},
+	}
+	opts.Experimental.BulkScanCacheSize = 1 << 20
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%04d", i)), bytes.Repeat([]byte("v"), 10), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	scan := func(o *IterOptions) (count int) {
+		iter := d.NewIter(o)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			count++
+		}
+		require.NoError(t, iter.Close())
+		return count
+	}
+
+	// Open the table, reading its metadata blocks into the block cache.
+	_, _, err = d.Get([]byte("k0000x"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// The blocks read by a bulk scan are cached in the bulk scan cache, and
+	// not in the block cache.
+	before := d.Metrics().BlockCache
+	require.Equal(t, 1000, scan(&IterOptions{BulkScan: true}))
+	m := d.Metrics()
+	require.Equal(t, before.Count, m.BlockCache.Count)
+	require.Less(t, int64(10), m.BulkScanCache.Count)
+
+	// Repeated bulk scans hit in the bulk scan cache.
+	bulkHits := m.BulkScanCache.Hits
+	require.Equal(t, 1000, scan(&IterOptions{BulkScan: true}))
+	m = d.Metrics()
+	require.Less(t, bulkHits+10, m.BulkScanCache.Hits)
+
+	// Regular scans use the block cache, and bulk scans are then served from
+	// the block cache.
+	require.Equal(t, 1000, scan(nil))
+	m = d.Metrics()
+	require.Less(t, before.Count+10, m.BlockCache.Count)
+	bulkMisses := m.BulkScanCache.Misses
+	require.Equal(t, 1000, scan(&IterOptions{BulkScan: true}))
+	require.Equal(t, bulkMisses, d.Metrics().BulkScanCache.Misses)
+}
+
+func TestIteratorCacheMode(t *testing.T) {
+	c := cache.New(1 << 20)
+	defer c.Unref()
+	opts := &Options{
+		Cache:  c,
+		FS:     vfs.NewMem(),
+		Levels: []LevelOptions{{BlockSize: 256}},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%04d", i)), bytes.Repeat([]byte("v"), 10), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	scan := func(o *IterOptions) (count int) {
+		iter := d.NewIter(o)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			count++
+		}
+		require.NoError(t, iter.Close())
+		return count
+	}
+
+	// Open the table, reading its metadata blocks into the block cache.
+	_, _, err = d.Get([]byte("k0000x"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// The blocks read with CacheModeNoFill aren't added to the block cache.
+	before := d.Metrics().BlockCache
+	require.Equal(t, 1000, scan(&IterOptions{CacheMode: CacheModeNoFill}))
+	m := d.Metrics().BlockCache
+	require.Equal(t, before.Count, m.Count)
+	require.Less(t, before.Misses+10, m.Misses)
+
+	// Changing the mode of an iterator applies to the blocks it reads next.
+	iter := d.NewIter(&IterOptions{CacheMode: CacheModeNoFill})
+	iter.SetOptions(&IterOptions{CacheMode: CacheModeFillLowPriority})
+	count := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		count++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 1000, count)
+	m = d.Metrics().BlockCache
+	require.Less(t, before.Count+10, m.Count)
+
+	// The cached blocks are then read from the block cache.
+	misses := m.Misses
+	require.Equal(t, 1000, scan(&IterOptions{CacheMode: CacheModeNoFill}))
+	require.Equal(t, misses, d.Metrics().BlockCache.Misses)
+}
+
+func TestIteratorSeekPrefixLT(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer:           testkeys.Comparer,
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a@1", "a@3", "a@5", "b@2", "b@4", "b@6", "c@1"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+	// Shadow b@6 and add b@3 in the memtable.
+	require.NoError(t, d.Delete([]byte("b@6"), nil))
+	require.NoError(t, d.Set([]byte("b@3"), []byte("b@3"), nil))
+
+	iter := d.NewIter(nil)
+	defer func() { require.NoError(t, iter.Close()) }()
+	key := func(valid bool) string {
+		require.NoError(t, iter.Error())
+		if !valid {
+			return "."
+		}
+		return string(iter.Key())
+	}
+
+	// Keys with larger suffixes sort first, so the last key with the prefix
+	// has the smallest suffix.
+	require.Equal(t, "b@2", key(iter.SeekPrefixLast([]byte("b"))))
+	require.Equal(t, "b@3", key(iter.Prev()))
+	require.Equal(t, "b@4", key(iter.Prev()))
+	require.Equal(t, ".", key(iter.Prev()))
+	require.Equal(t, ".", key(iter.Prev()))
+	// Switching directions after exhausting the prefix.
+	require.Equal(t, "b@4", key(iter.Next()))
+	require.Equal(t, "b@3", key(iter.Next()))
+	require.Equal(t, "b@2", key(iter.Next()))
+	require.Equal(t, ".", key(iter.Next()))
+	require.Equal(t, "b@2", key(iter.Prev()))
+	require.Equal(t, ".", key(iter.NextPrefix()))
+
+	require.Equal(t, "b@4", key(iter.SeekPrefixLT([]byte("b@3"))))
+	require.Equal(t, ".", key(iter.Prev()))
+	require.Equal(t, ".", key(iter.SeekPrefixLT([]byte("a@5"))))
+	require.Equal(t, "a@5", key(iter.Next()))
+	require.Equal(t, "c@1", key(iter.SeekPrefixLast([]byte("c"))))
+	require.Equal(t, ".", key(iter.SeekPrefixLast([]byte("d"))))
+
+	// Other positioning operations leave the prefix.
+	require.Equal(t, "a@1", key(iter.SeekPrefixLast([]byte("a"))))
+	require.Equal(t, ".", key(iter.Next()))
+	require.Equal(t, "b@4", key(iter.SeekGE([]byte("b"))))
+	require.Equal(t, "a@1", key(iter.Prev()))
+
+	// The bounds constrain the prefix.
+	iter.SetBounds(nil, []byte("b@2"))
+	require.Equal(t, "b@3", key(iter.SeekPrefixLast([]byte("b"))))
+	iter.SetBounds([]byte("b@3"), nil)
+	require.Equal(t, "b@3", key(iter.SeekPrefixLT([]byte("b@2"))))
+	require.Equal(t, ".", key(iter.Prev()))
+}
+
+func TestIteratorRefresh(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+
+	iter := d.NewIter(nil)
+	defer func() { require.NoError(t, iter.Close()) }()
+	scan := func() string {
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Error())
+		return strings.Join(keys, " ")
+	}
+	// l0 returns the sstable iterator of the L0 level iterator.
+	l0 := func() internalIterator {
+		return iter.merging.levels[len(iter.merging.levels)-1].iter.(*levelIter).iter
+	}
+	require.Equal(t, "a b", scan())
+
+	// Writes to the same memtable are observed without reconstructing the
+	// sstable iterators.
+	require.NoError(t, d.Set([]byte("c"), nil, nil))
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("b"), nil))
+	require.Equal(t, "a b", scan())
+	require.True(t, iter.SeekGE([]byte("a")))
+	iter.Refresh()
+	require.False(t, iter.Valid())
+	require.NotNil(t, l0())
+	require.Equal(t, "b c", scan())
+
+	// A flush changes the LSM, and reconstructs the iterator.
+	require.NoError(t, d.Set([]byte("d"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.True(t, iter.SeekGE([]byte("a")))
+	iter.Refresh()
+	require.Nil(t, l0())
+	require.Equal(t, "b c d", scan())
+
+	// A rotated memtable is included.
+	require.NoError(t, d.Set([]byte("e"), nil, nil))
+	_, err = d.AsyncFlush()
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("f"), nil, nil))
+	iter.Refresh()
+	require.Equal(t, "b c d e f", scan())
+
+	// Iterators over snapshots can't be refreshed.
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	snapIter := snap.NewIter(nil)
+	defer func() { require.NoError(t, snapIter.Close()) }()
+	require.Panics(t, snapIter.Refresh)
+}
+
 // TestSetOptionsEquivalence tests equivalence between SetOptions to mutate an
 // iterator and constructing a new iterator with NewIter. The long-lived
 // iterator and the new iterator should surface identical iterator states.
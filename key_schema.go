@@ -0,0 +1,176 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// ErrInvalidKey is the error marking the errors returned by Apply and Ingest
+// when a key fails the validation of Options.Experimental.KeySchema. It may be
+// detected with errors.Is.
+var ErrInvalidKey = errors.New("pebble: invalid key")
+
+// KeyValidator validates the encoding of a user key, returning an error if
+// the key is malformed. It must not retain or modify the key, and may be
+// called concurrently.
+type KeyValidator func(userKey []byte) error
+
+// KeyLengthBounds returns a KeyValidator that checks that the length of keys
+// is within [min, max]. A max of zero means the length is unbounded.
+func KeyLengthBounds(min, max int) KeyValidator {
+	return func(userKey []byte) error {
+		if len(userKey) < min || (max > 0 && len(userKey) > max) {
+			return errors.Errorf("key length %d not within [%d, %d]", len(userKey), min, max)
+		}
+		return nil
+	}
+}
+
+// KeyPrefixAllowlist returns a KeyValidator that checks that keys start with
+// one of the given prefixes, eg, the prefixes of the tenants allowed to write
+// to a DB.
+func KeyPrefixAllowlist(prefixes ...[]byte) KeyValidator {
+	return func(userKey []byte) error {
+		for _, p := range prefixes {
+			if bytes.HasPrefix(userKey, p) {
+				return nil
+			}
+		}
+		return errors.New("key does not have an allowed prefix")
+	}
+}
+
+// KeyValidationMode determines when the validators of a KeySchema are run.
+type KeyValidationMode int8
+
+const (
+	// KeyValidationInvariants runs the validators only in builds with
+	// invariants enabled, ie, race detector builds and builds with the
+	// invariants build tag, as used by tests.
+	KeyValidationInvariants KeyValidationMode = iota
+	// KeyValidationAlways runs the validators in all builds.
+	KeyValidationAlways
+)
+
+// KeySchema describes the encoding of the user keys written to a DB, in order
+// to catch key-encoding bugs at write time rather than once the malformed keys
+// have been persisted. See Options.Experimental.KeySchema.
+//
+// The validators are called for the user key of every operation of the
+// batches passed to Apply (and so to Set, Delete, etc), and of every key of
+// the sstables passed to Ingest, as well as for the end keys of range
+// deletions and range keys. If a validator returns an error, the batch is not
+// applied, or the sstables are not ingested, and the error is returned marked
+// with ErrInvalidKey. Validating the keys of ingested sstables requires
+// reading the sstables in full.
+type KeySchema struct {
+	// Validators are the validators of the keys. Every key must pass all of
+	// them.
+	Validators []KeyValidator
+	// Mode determines when the validators are run. Defaults to
+	// KeyValidationInvariants.
+	Mode KeyValidationMode
+}
+
+// enabled returns true if the keys are validated in this build.
+func (s *KeySchema) enabled() bool {
+	return len(s.Validators) > 0 && (s.Mode == KeyValidationAlways || invariants.Enabled)
+}
+
+// validate validates the given user key.
+func (s *KeySchema) validate(userKey []byte, formatKey base.FormatKey) error {
+	for _, v := range s.Validators {
+		if err := v(userKey); err != nil {
+			return errors.Mark(errors.Wrapf(err, "pebble: invalid key %s", formatKey(userKey)), ErrInvalidKey)
+		}
+	}
+	return nil
+}
+
+// validateBatchKeys validates the keys of the batch against the KeySchema.
+func (d *DB) validateBatchKeys(b *Batch) error {
+	schema := &d.opts.Experimental.KeySchema
+	formatKey := d.opts.Comparer.FormatKey
+	r := b.Reader()
+	for {
+		kind, ukey, value, ok := r.Next()
+		if !ok {
+			return nil
+		}
+		switch kind {
+		case InternalKeyKindLogData, InternalKeyKindIngestSST:
+			continue
+		}
+		if err := schema.validate(ukey, formatKey); err != nil {
+			return err
+		}
+		switch kind {
+		case InternalKeyKindRangeDelete:
+			if err := schema.validate(value, formatKey); err != nil {
+				return err
+			}
+		case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+			endKey, _, ok := rangekey.DecodeEndKey(kind, value)
+			if !ok {
+				return errors.Errorf("pebble: unable to decode range key end key")
+			}
+			if err := schema.validate(endKey, formatKey); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ingestValidateSchemaKeys validates all the keys of an sstable being ingested
+// against the KeySchema.
+func ingestValidateSchemaKeys(opts *Options, r *sstable.Reader) error {
+	schema := &opts.Experimental.KeySchema
+	formatKey := opts.Comparer.FormatKey
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return err
+	}
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+		if err := schema.validate(key.UserKey, formatKey); err != nil {
+			_ = iter.Close()
+			return err
+		}
+	}
+	if err := firstError(iter.Error(), iter.Close()); err != nil {
+		return err
+	}
+
+	spanIters := make([]keyspan.FragmentIterator, 0, 2)
+	if rangeDelIter, err := r.NewRawRangeDelIter(); err != nil {
+		return err
+	} else if rangeDelIter != nil {
+		spanIters = append(spanIters, rangeDelIter)
+	}
+	if rangeKeyIter, err := r.NewRawRangeKeyIter(); err != nil {
+		for _, iter := range spanIters {
+			_ = iter.Close()
+		}
+		return err
+	} else if rangeKeyIter != nil {
+		spanIters = append(spanIters, rangeKeyIter)
+	}
+	for _, iter := range spanIters {
+		for s := iter.First(); s != nil && err == nil; s = iter.Next() {
+			if err = schema.validate(s.Start, formatKey); err == nil {
+				err = schema.validate(s.End, formatKey)
+			}
+		}
+		err = firstError(err, firstError(iter.Error(), iter.Close()))
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySchema(t *testing.T) {
+	for _, mode := range []KeyValidationMode{KeyValidationInvariants, KeyValidationAlways} {
+		mem := vfs.NewMem()
+		opts := &Options{
+			Comparer:           testkeys.Comparer,
+			FS:                 mem,
+			FormatMajorVersion: FormatNewest,
+			Logger:             panicLogger{},
+		}
+		opts.Experimental.KeySchema = KeySchema{
+			Validators: []KeyValidator{
+				KeyLengthBounds(2, 8),
+				KeyPrefixAllowlist([]byte("t1/"), []byte("t2/")),
+			},
+			Mode: mode,
+		}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+
+		validated := mode == KeyValidationAlways || invariants.Enabled
+		check := func(err error) {
+			t.Helper()
+			if validated {
+				require.True(t, errors.Is(err, ErrInvalidKey), "%v", err)
+			} else {
+				require.NoError(t, err)
+			}
+		}
+
+		require.NoError(t, d.Set([]byte("t1/a"), nil, nil))
+		require.NoError(t, d.DeleteRange([]byte("t1/a"), []byte("t2/a"), nil))
+		check(d.Set([]byte("t3/a"), nil, nil))
+		check(d.Set([]byte("t1/toolong"), nil, nil))
+		check(d.DeleteRange([]byte("t1/a"), []byte("t3/a"), nil))
+		check(d.RangeKeySet([]byte("t1/a"), []byte("t3/a"), nil, nil, nil))
+
+		// An invalid key fails the entire batch.
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte("t2/b"), nil, nil))
+		require.NoError(t, b.Set([]byte("x"), nil, nil))
+		check(b.Commit(nil))
+		if validated {
+			_, _, err := d.Get([]byte("t2/b"))
+			require.ErrorIs(t, err, ErrNotFound)
+		}
+
+		writeSST := func(path string, keys ...string) {
+			f, err := mem.Create(path)
+			require.NoError(t, err)
+			w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+				Comparer:    testkeys.Comparer,
+				TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+			})
+			for _, k := range keys {
+				require.NoError(t, w.Set([]byte(k), nil))
+			}
+			require.NoError(t, w.Close())
+		}
+		writeSST("valid.sst", "t1/c", "t2/c")
+		require.NoError(t, d.Ingest([]string{"valid.sst"}))
+		// The invalid key is neither the smallest nor the largest key of the
+		// table.
+		writeSST("invalid.sst", "t1/d", "t1/e/toolong", "t2/d")
+		check(d.Ingest([]string{"invalid.sst"}))
+
+		require.NoError(t, d.Close())
+	}
+}
@@ -33,6 +33,16 @@ type tableNewIters func(
 	internalOpts internalIterOpts,
 ) (internalIterator, keyspan.FragmentIterator, error)
 
+// tablePrefixFilter returns false if the given sstable has no key relevant to
+// a prefix seek with the given prefix. See
+// tableCacheContainer.mayContainPrefix.
+type tablePrefixFilter func(
+	ctx context.Context,
+	file *manifest.FileMetadata,
+	prefix []byte,
+	stats *base.InternalIteratorStats,
+) (bool, error)
+
 // tableNewRangeDelIter takes a tableNewIters and returns a TableNewSpanIter
 // for the rangedel iterator returned by tableNewIters.
 func tableNewRangeDelIter(ctx context.Context, newIters tableNewIters) keyspan.TableNewSpanIter {
@@ -134,6 +144,10 @@ type levelIter struct {
 	// levelIterBoundaryContext.isIgnorableBoundaryKey.
 	filteredIter filteredIter
 	newIters     tableNewIters
+	// prefixFilter, if set, is consulted by SeekPrefixGE to skip the files
+	// which have no key relevant to the prefix without opening them. See
+	// Options.Experimental.PrecheckTableFilters.
+	prefixFilter tablePrefixFilter
 	// When rangeDelIterPtr != nil, the caller requires that *rangeDelIterPtr must
 	// point to a range del iterator corresponding to the current file. When this
 	// iterator returns nil, *rangeDelIterPtr should also be set to nil. Whenever
@@ -268,15 +282,23 @@ func (l *levelIter) init(
 	l.tableOpts.TableFilter = opts.TableFilter
 	l.tableOpts.PointKeyFilters = opts.PointKeyFilters
 	l.tableOpts.UseL6Filters = opts.UseL6Filters
+	l.tableOpts.BulkScan = opts.BulkScan
+	l.tableOpts.CacheMode = opts.CacheMode
+	l.tableOpts.skipFilesBelowSeqNum = opts.skipFilesBelowSeqNum
 	l.tableOpts.level = l.level
 	l.cmp = cmp
 	l.split = split
 	l.iterFile = nil
 	l.newIters = newIters
+	l.prefixFilter = nil
 	l.files = files
 	l.internalOpts = internalOpts
 }
 
+func (l *levelIter) initPrefixFilter(prefixFilter tablePrefixFilter) {
+	l.prefixFilter = prefixFilter
+}
+
 func (l *levelIter) initRangeDel(rangeDelIter *keyspan.FragmentIterator) {
 	l.rangeDelIterPtr = rangeDelIter
 }
@@ -635,7 +657,7 @@ func (l *levelIter) loadFile(file *fileMetadata, dir int) loadFileReturnIndicato
 		}
 
 		l.maybeTriggerCombinedIteration(file, dir)
-		if !file.HasPointKeys {
+		if !file.HasPointKeys || file.LargestSeqNum < l.tableOpts.skipFilesBelowSeqNum {
 			switch dir {
 			case +1:
 				file = l.files.Next()
@@ -749,7 +771,11 @@ func (l *levelIter) SeekPrefixGE(
 
 	// NB: the top-level Iterator has already adjusted key based on
 	// IterOptions.LowerBound.
-	loadFileIndicator := l.loadFile(l.findFileGE(key, flags), +1)
+	file := l.findFileGE(key, flags)
+	if l.skipFileByPrefixFilter(file, prefix) {
+		return nil, base.LazyValue{}
+	}
+	loadFileIndicator := l.loadFile(file, +1)
 	if loadFileIndicator == noFileLoaded {
 		return nil, base.LazyValue{}
 	}
@@ -802,6 +828,41 @@ func (l *levelIter) SeekPrefixGE(
 	return l.verify(l.skipEmptyFileForward())
 }
 
+// skipFileByPrefixFilter returns true if SeekPrefixGE may skip the given file,
+// which it would otherwise load, because the file has no key relevant to the
+// prefix: the file has no range keys, its prefix filter doesn't match, and it
+// has no range deletions. As with a file whose filter doesn't match once
+// loaded, the prefix must not extend beyond the file, so that the seek is
+// exhausted. The file becomes the current file, without iterator, as if it
+// were beyond the iteration bounds.
+func (l *levelIter) skipFileByPrefixFilter(file *fileMetadata, prefix []byte) bool {
+	if l.prefixFilter == nil || file == nil || (file == l.iterFile && l.iter != nil) {
+		return false
+	}
+	if !file.HasPointKeys || file.HasRangeKeys {
+		return false
+	}
+	if manifest.LevelToInt(l.level) == numLevels-1 && !l.tableOpts.UseL6Filters {
+		// Mirror the table cache, which doesn't use the filters of L6 files by
+		// default.
+		return false
+	}
+	if n := l.split(file.LargestPointKey.UserKey); l.cmp(prefix, file.LargestPointKey.UserKey[:n]) >= 0 {
+		return false
+	}
+	mayContain, err := l.prefixFilter(l.ctx, file, prefix, l.internalOpts.stats)
+	if err != nil || mayContain {
+		// Errors surface when loading the file.
+		return false
+	}
+	// Close the iterators of the previous file, as loadFile would.
+	_ = l.Close()
+	l.smallestBoundary = nil
+	l.largestBoundary = nil
+	l.iterFile = file
+	return true
+}
+
 func (l *levelIter) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, base.LazyValue) {
 	l.err = nil // clear cached iteration error
 	if l.boundaryContext != nil {
@@ -18,6 +18,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/rangedel"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
@@ -722,3 +723,86 @@ func BenchmarkLevelIterPrev(b *testing.B) {
 			})
 	}
 }
+
+func TestLevelIterPrecheckTableFilters(t *testing.T) {
+	open := func(precheck bool) *DB {
+		opts := &Options{
+			Comparer:                    testkeys.Comparer,
+			FS:                          vfs.NewMem(),
+			DisableAutomaticCompactions: true,
+		}
+		opts.Levels = []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}}
+		opts.Experimental.PrecheckTableFilters = precheck
+		d, err := Open("", opts)
+		require.NoError(t, err)
+
+		// Write three overlapping L0 sstables, in distinct sublevels, holding
+		// interleaved keys. The third sstable also holds a range deletion.
+		for i := 0; i < 3; i++ {
+			for j := i; j < 30; j += 3 {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("k%02d", j)), []byte("v"), nil))
+			}
+			if i == 2 {
+				require.NoError(t, d.DeleteRange([]byte("k10"), []byte("k13"), nil))
+			}
+			require.NoError(t, d.Flush())
+		}
+		require.Equal(t, int64(3), d.Metrics().Levels[0].NumFiles)
+		return d
+	}
+	withPrecheck, withoutPrecheck := open(true), open(false)
+	defer func() {
+		require.NoError(t, withPrecheck.Close())
+		require.NoError(t, withoutPrecheck.Close())
+	}()
+
+	// Count the sstables opened by the seeks.
+	var opened int
+	for _, d := range []*DB{withPrecheck, withoutPrecheck} {
+		newIters := d.newIters
+		d.newIters = func(
+			ctx context.Context, file *manifest.FileMetadata, opts *IterOptions, internalOpts internalIterOpts,
+		) (internalIterator, keyspan.FragmentIterator, error) {
+			opened++
+			return newIters(ctx, file, opts, internalOpts)
+		}
+	}
+	seek := func(d *DB, prefix string) (key string, tablesOpened int) {
+		opened = 0
+		iter := d.NewIter(nil)
+		defer func() { require.NoError(t, iter.Close()) }()
+		if iter.SeekPrefixGE([]byte(prefix)) {
+			key = string(iter.Key())
+		}
+		return key, opened
+	}
+
+	var totalWith, totalWithout int
+	for i := 0; i < 32; i++ {
+		prefix := fmt.Sprintf("k%02d", i)
+		got, openedWith := seek(withPrecheck, prefix)
+		want, openedWithout := seek(withoutPrecheck, prefix)
+		require.Equal(t, want, got, "prefix %s", prefix)
+		require.LessOrEqual(t, openedWith, openedWithout, "prefix %s", prefix)
+		totalWith += openedWith
+		totalWithout += openedWithout
+		if i < 27 {
+			// All the sstables span the prefix. The one holding the key, if
+			// any, and the one holding the range deletion are opened, while
+			// the others are skipped.
+			require.Equal(t, 3, openedWithout, "prefix %s", prefix)
+			if i%3 == 2 {
+				require.Equal(t, 1, openedWith, "prefix %s", prefix)
+			} else {
+				require.Equal(t, 2, openedWith, "prefix %s", prefix)
+			}
+		}
+	}
+	require.Less(t, totalWith, totalWithout)
+
+	// Keys covered by the range deletion are still deleted.
+	got, _ := seek(withPrecheck, "k10")
+	require.Equal(t, "", got)
+	got, _ = seek(withPrecheck, "k09")
+	require.Equal(t, "k09", got)
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/humanize"
+)
+
+// DefaultLiveDataAgeBounds are the bucket bounds used by DB.LiveDataAge when
+// none are provided.
+var DefaultLiveDataAgeBounds = []time.Duration{
+	time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+	90 * 24 * time.Hour,
+	365 * 24 * time.Hour,
+}
+
+// LiveDataAgeBucket is a bucket of a LiveDataAge histogram.
+type LiveDataAgeBucket struct {
+	// MaxAge is the exclusive upper bound of the age of the data in the
+	// bucket. It is math.MaxInt64 for the last bucket, which has no upper
+	// bound. The lower bound is the MaxAge of the previous bucket, if any.
+	MaxAge time.Duration
+	// Tables is the number of sstables in the bucket.
+	Tables int
+	// Bytes is the estimated number of live bytes in the bucket, per level.
+	Bytes [numLevels]uint64
+}
+
+// TotalBytes returns the estimated number of live bytes in the bucket.
+func (b *LiveDataAgeBucket) TotalBytes() uint64 {
+	var total uint64
+	for _, n := range b.Bytes {
+		total += n
+	}
+	return total
+}
+
+// LiveDataAge is a histogram of the age of the live data in the LSM. The age
+// of data is estimated by the time since the creation of the sstable
+// containing it. As sstables are rewritten by compactions, the age is the
+// time since the data was last written by a flush, an ingestion or a
+// compaction, which is a lower bound on the time since the data was last
+// modified by the application.
+type LiveDataAge struct {
+	// Buckets are in increasing order of MaxAge.
+	Buckets []LiveDataAgeBucket
+	// UnknownAgeTables and UnknownAgeBytes are the number of sstables, and
+	// their estimated number of live bytes, that have no recorded creation
+	// time, eg, because they were created by an old version of Pebble.
+	UnknownAgeTables int
+	UnknownAgeBytes  uint64
+}
+
+// String implements fmt.Stringer.
+func (a *LiveDataAge) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "       age  tables    size\n")
+	var minAge time.Duration
+	for i := range a.Buckets {
+		b := &a.Buckets[i]
+		var age string
+		if b.MaxAge == math.MaxInt64 {
+			age = fmt.Sprintf(">=%s", minAge)
+		} else {
+			age = fmt.Sprintf("<%s", b.MaxAge)
+		}
+		fmt.Fprintf(&buf, "%10s  %6d  %6s\n", age, b.Tables, humanize.IEC.Uint64(b.TotalBytes()))
+		minAge = b.MaxAge
+	}
+	if a.UnknownAgeTables > 0 {
+		fmt.Fprintf(&buf, "%10s  %6d  %6s\n", "unknown", a.UnknownAgeTables,
+			humanize.IEC.Uint64(a.UnknownAgeBytes))
+	}
+	return buf.String()
+}
+
+// LiveDataAge returns a histogram of the age of the live data in the LSM, so
+// that operators can quantify the amount of cold data, eg, before tiering it
+// to cheaper storage. The histogram has a bucket for the ages lower than each
+// of the given bounds, which must be increasing, and a last bucket for the
+// older data. DefaultLiveDataAgeBounds are used if bounds is empty.
+//
+// The live bytes of an sstable are estimated by its size, which includes data
+// shadowed or deleted by newer data in the LSM.
+func (d *DB) LiveDataAge(bounds []time.Duration) LiveDataAge {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	readState := d.loadReadState()
+	defer readState.unref()
+	return liveDataAge(readState.current, time.Now(), bounds)
+}
+
+func liveDataAge(v *version, now time.Time, bounds []time.Duration) LiveDataAge {
+	if len(bounds) == 0 {
+		bounds = DefaultLiveDataAgeBounds
+	}
+	a := LiveDataAge{Buckets: make([]LiveDataAgeBucket, len(bounds)+1)}
+	for i, bound := range bounds {
+		if i > 0 && bound <= bounds[i-1] {
+			panic(fmt.Sprintf("pebble: live data age bounds must be increasing: %v", bounds))
+		}
+		a.Buckets[i].MaxAge = bound
+	}
+	a.Buckets[len(bounds)].MaxAge = math.MaxInt64
+
+	for level := range v.Levels {
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.CreationTime == 0 {
+				a.UnknownAgeTables++
+				a.UnknownAgeBytes += f.Size
+				continue
+			}
+			age := now.Sub(time.Unix(f.CreationTime, 0))
+			i := 0
+			for i < len(bounds) && age >= a.Buckets[i].MaxAge {
+				i++
+			}
+			a.Buckets[i].Tables++
+			a.Buckets[i].Bytes[level] += f.Size
+		}
+	}
+	return a
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveDataAge(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	var files [numLevels][]*fileMetadata
+	var fileNum base.FileNum
+	add := func(level int, key string, age time.Duration, size uint64) {
+		fileNum++
+		m := &fileMetadata{FileNum: fileNum, Size: size}
+		if age >= 0 {
+			m.CreationTime = now.Add(-age).Unix()
+		}
+		k := base.MakeInternalKey([]byte(key), 1, InternalKeyKindSet)
+		m.ExtendPointKeyBounds(DefaultComparer.Compare, k, k)
+		m.InitPhysicalBacking()
+		files[level] = append(files[level], m)
+	}
+	add(0, "a", time.Minute, 1)
+	add(0, "b", 2*time.Hour, 2)
+	add(5, "a", 3*time.Hour, 4)
+	add(6, "a", 10*24*time.Hour, 8)
+	add(6, "b", 24*time.Hour, 16)
+	add(6, "c", -1, 32)
+	v := newVersion(&Options{Comparer: DefaultComparer}, files)
+
+	a := liveDataAge(v, now, []time.Duration{time.Hour, 24 * time.Hour})
+	require.Len(t, a.Buckets, 3)
+	require.Equal(t, LiveDataAgeBucket{MaxAge: time.Hour, Tables: 1, Bytes: [numLevels]uint64{0: 1}}, a.Buckets[0])
+	require.Equal(t, LiveDataAgeBucket{MaxAge: 24 * time.Hour, Tables: 2, Bytes: [numLevels]uint64{0: 2, 5: 4}}, a.Buckets[1])
+	require.Equal(t, LiveDataAgeBucket{MaxAge: math.MaxInt64, Tables: 2, Bytes: [numLevels]uint64{6: 24}}, a.Buckets[2])
+	require.Equal(t, uint64(24), a.Buckets[2].TotalBytes())
+	require.Equal(t, 1, a.UnknownAgeTables)
+	require.Equal(t, uint64(32), a.UnknownAgeBytes)
+	require.Equal(t, `       age  tables    size
+   <1h0m0s       1     1 B
+  <24h0m0s       2     6 B
+ >=24h0m0s       2    24 B
+   unknown       1    32 B
+`, a.String())
+
+	require.Len(t, liveDataAge(v, now, nil).Buckets, len(DefaultLiveDataAgeBounds)+1)
+	require.Panics(t, func() { liveDataAge(v, now, []time.Duration{time.Hour, time.Minute}) })
+}
+
+func TestDBLiveDataAge(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+
+	// The table just flushed is in the youngest bucket.
+	a := d.LiveDataAge(nil)
+	require.Equal(t, 1, a.Buckets[0].Tables)
+	require.Equal(t, uint64(d.Metrics().Levels[0].Size), a.Buckets[0].Bytes[0])
+	require.Zero(t, a.UnknownAgeTables)
+}
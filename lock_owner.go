@@ -0,0 +1,206 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// lockOwnerFilename is the name of the file recording the owner of the
+// database lock. The owner is not recorded in the LOCK file itself, as
+// opening and closing another handle on the LOCK file releases the POSIX
+// lock held on it by the process.
+const lockOwnerFilename = "LOCK-OWNER"
+
+// ErrLockFenced is returned when a database opened with
+// Options.Experimental.LockFencing attempts to modify its state after the
+// database lock was taken over by another owner through TakeoverLock.
+var ErrLockFenced = errors.New("pebble: database lock was taken over by another owner")
+
+// LockOwner describes the process that acquired the lock on a database
+// directory.
+type LockOwner struct {
+	// PID is the ID of the process.
+	PID int
+	// Host is the host name of the machine running the process.
+	Host string
+	// AcquiredAt is the time at which the lock was acquired.
+	AcquiredAt time.Time
+	// Epoch is incremented every time the lock is acquired, and is used to
+	// fence off previous owners. See TakeoverLock.
+	Epoch uint64
+}
+
+// String implements fmt.Stringer.
+func (o LockOwner) String() string {
+	return fmt.Sprintf("pid %d on host %q since %s (epoch %d)",
+		o.PID, o.Host, o.AcquiredAt.Format(time.RFC3339), o.Epoch)
+}
+
+// LockHeldError is returned by Open and LockDirectory when the lock on the
+// database directory is held by another owner.
+type LockHeldError struct {
+	// Dirname is the database directory.
+	Dirname string
+	// Owner is the last recorded owner of the lock. It's the zero value if
+	// the owner could not be determined, eg, because the lock is held by a
+	// version of Pebble that did not record its owner. As Open only records
+	// the owner with Options.Experimental.LockFencing, the recorded owner
+	// may predate the current holder of the lock.
+	Owner LockOwner
+	// Err is the error returned when acquiring the lock.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *LockHeldError) Error() string {
+	if e.Owner == (LockOwner{}) {
+		return fmt.Sprintf("pebble: lock on %q is held: %v", e.Dirname, e.Err)
+	}
+	return fmt.Sprintf("pebble: lock on %q is held by %s: %v", e.Dirname, e.Owner, e.Err)
+}
+
+// Unwrap returns the error returned when acquiring the lock.
+func (e *LockHeldError) Unwrap() error {
+	return e.Err
+}
+
+// ReadLockOwner returns the last recorded owner of the lock on the database
+// directory. An error satisfying oserror.IsNotExist is returned if no owner
+// was ever recorded.
+func ReadLockOwner(fs vfs.FS, dirname string) (LockOwner, error) {
+	data, err := readFile(fs, fs.PathJoin(dirname, lockOwnerFilename))
+	if err != nil {
+		return LockOwner{}, err
+	}
+	var o LockOwner
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), "=")
+		if !ok {
+			return LockOwner{}, errors.Errorf("pebble: invalid lock owner line: %q", s.Text())
+		}
+		switch key {
+		case "pid":
+			o.PID, err = strconv.Atoi(value)
+		case "host":
+			o.Host = value
+		case "acquired_at":
+			o.AcquiredAt, err = time.Parse(time.RFC3339Nano, value)
+		case "epoch":
+			o.Epoch, err = strconv.ParseUint(value, 10, 64)
+		}
+		if err != nil {
+			return LockOwner{}, errors.Wrapf(err, "pebble: invalid lock owner line: %q", s.Text())
+		}
+	}
+	return o, s.Err()
+}
+
+// TakeoverLock acquires the lock on the database directory on behalf of a new
+// owner, for orchestrated failovers of a database on a shared volume where
+// the previous owner may be unreachable but still hold the lock. The takeover
+// succeeds only if the epoch of the current owner (see ReadLockOwner) is the
+// given epoch, so that concurrent takeovers are detected. The returned Lock
+// may be passed to Open through Options.Lock.
+//
+// If the lock file is still held, TakeoverLock proceeds without holding it,
+// and the previous owner is not prevented from modifying the database unless
+// it was opened with Options.Experimental.LockFencing, in which case it fails
+// with ErrLockFenced the next time it attempts to modify the database's
+// state.
+func TakeoverLock(dirname string, fs vfs.FS, epoch uint64) (*Lock, error) {
+	owner, err := ReadLockOwner(fs, dirname)
+	if err != nil && !oserror.IsNotExist(err) {
+		return nil, err
+	}
+	if owner.Epoch != epoch {
+		return nil, errors.Errorf("pebble: lock on %q is at epoch %d, not %d", dirname, owner.Epoch, epoch)
+	}
+	fileLock, err := fs.Lock(lockFilename(fs, dirname))
+	if err != nil {
+		if oserror.IsNotExist(err) {
+			return nil, err
+		}
+		fileLock = nil
+	}
+	l := newLock(dirname, fs, fileLock)
+	if err := l.recordOwner(owner.Epoch + 1); err != nil {
+		return nil, errors.CombineErrors(err, l.Close())
+	}
+	return l, nil
+}
+
+// Owner returns the owner recorded when the lock was acquired. It's the zero
+// value if no owner was recorded, which is the case if the lock was acquired
+// by Open without Options.Experimental.LockFencing, or for a read-only
+// database.
+func (l *Lock) Owner() LockOwner {
+	return l.owner
+}
+
+// recordOwner records the current process as the owner of the lock, at the
+// given epoch.
+func (l *Lock) recordOwner(epoch uint64) error {
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	o := LockOwner{
+		PID:        os.Getpid(),
+		Host:       host,
+		AcquiredAt: time.Now().UTC(),
+		Epoch:      epoch,
+	}
+	data := fmt.Sprintf("pid=%d\nhost=%s\nacquired_at=%s\nepoch=%d\n",
+		o.PID, o.Host, o.AcquiredAt.Format(time.RFC3339Nano), o.Epoch)
+
+	// Write the owner to a temporary file first, so that a reader never
+	// observes a partially written owner.
+	path := l.fs.PathJoin(l.dirname, lockOwnerFilename)
+	tmpPath := path + ".dbtmp"
+	if err := writeFile(l.fs, tmpPath, []byte(data)); err != nil {
+		return err
+	}
+	if err := l.fs.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	dir, err := l.fs.OpenDir(l.dirname)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		return errors.CombineErrors(err, dir.Close())
+	}
+	if err := dir.Close(); err != nil {
+		return err
+	}
+	l.owner = o
+	return nil
+}
+
+// checkFence returns an error wrapping ErrLockFenced if the lock was taken
+// over by another owner since it was acquired.
+func (l *Lock) checkFence() error {
+	owner, err := ReadLockOwner(l.fs, l.dirname)
+	if err != nil {
+		return err
+	}
+	if owner.Epoch != l.owner.Epoch {
+		return errors.Wrapf(ErrLockFenced, "lock on %q acquired at epoch %d is now held by %s",
+			l.dirname, l.owner.Epoch, owner)
+	}
+	return nil
+}
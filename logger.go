@@ -14,3 +14,13 @@ var DefaultLogger = base.DefaultLogger
 
 // LoggerAndTracer defines an interface for logging and tracing.
 type LoggerAndTracer = base.LoggerAndTracer
+
+// Tracer defines an interface for tracing operations with spans (see
+// Options.Tracer).
+type Tracer = base.Tracer
+
+// Span is a traced operation, started by Tracer.StartSpan.
+type Span = base.Span
+
+// NoopTracer does no tracing.
+type NoopTracer = base.NoopTracer
@@ -0,0 +1,209 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// lookupCacheBuckets is the number of buckets into which the keys of a
+	// lookupCache are hashed to track the writes invalidating them.
+	lookupCacheBuckets = 256
+	// lookupCacheEntryOverhead approximates the memory used by an entry of a
+	// lookupCache, besides its key and value.
+	lookupCacheEntryOverhead = 96
+)
+
+// lookupCache caches the results of the recent point lookups of the DB, so
+// that repeated lookups of the same key are served without constructing an
+// iterator (see Options.Experimental.LookupCacheSize). Its entries are
+// strictly consistent with the DB: the entry of a key is invalidated before
+// a write to the key becomes visible, and all the entries are invalidated
+// when the LSM changes, as flushes, compactions or ingestions may change the
+// visible keys, eg, through a CompactionFilter. The least recently used
+// entries are evicted once the cache is full.
+type lookupCache struct {
+	capacity int64
+	hits     atomic.Int64
+	misses   atomic.Int64
+	// epoch is incremented whenever all the entries are invalidated. It's
+	// only modified while mu is held.
+	epoch atomic.Uint64
+
+	mu struct {
+		sync.Mutex
+		size    int64
+		entries map[string]*list.Element
+		// lru holds the *lookupCacheEntry values, from the most to the least
+		// recently used.
+		lru list.List
+		// minSeqNum[i] is the minimum sequence number of the lookups whose
+		// results may be added for the keys of the i-th bucket: lookups at
+		// lower sequence numbers may not observe the writes to the keys that
+		// invalidated the bucket. minSeqNumAll applies to all the keys.
+		minSeqNum    [lookupCacheBuckets]uint64
+		minSeqNumAll uint64
+	}
+}
+
+type lookupCacheEntry struct {
+	key   string
+	value []byte
+	// found is false if the key wasn't found.
+	found bool
+	// seqNum is the sequence number at which the lookup read.
+	seqNum uint64
+}
+
+func newLookupCache(capacity int64) *lookupCache {
+	c := &lookupCache{capacity: capacity}
+	c.mu.entries = make(map[string]*list.Element)
+	c.mu.lru.Init()
+	return c
+}
+
+// lookupCacheBucket returns the bucket of the key, hashed with FNV-1a.
+func lookupCacheBucket(key []byte) int {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return int(h % lookupCacheBuckets)
+}
+
+func lookupCacheEntrySize(key, value []byte) int64 {
+	return int64(len(key)+len(value)) + lookupCacheEntryOverhead
+}
+
+// get returns the cached result of a lookup of the key at sequence number
+// seqNum, or ok=false if there is none. The returned value must not be
+// modified.
+func (c *lookupCache) get(key []byte, seqNum uint64) (value []byte, found, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, exists := c.mu.entries[string(key)]; exists {
+		entry := e.Value.(*lookupCacheEntry)
+		// The entry is valid until it's invalidated, so it also holds the
+		// result of lookups at higher sequence numbers.
+		if entry.seqNum <= seqNum {
+			c.mu.lru.MoveToFront(e)
+			c.hits.Add(1)
+			return entry.value, entry.found, true
+		}
+	}
+	c.misses.Add(1)
+	return nil, false, false
+}
+
+// add caches the result of a lookup of the key at sequence number seqNum. The
+// epoch must be loaded before the lookup loads the DB's readState. The result
+// isn't cached if the cache was invalidated by writes the lookup may not have
+// observed. The key and value are copied.
+func (c *lookupCache) add(key, value []byte, found bool, seqNum, epoch uint64) {
+	size := lookupCacheEntrySize(key, value)
+	if size > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch != c.epoch.Load() || seqNum < c.mu.minSeqNumAll ||
+		seqNum < c.mu.minSeqNum[lookupCacheBucket(key)] {
+		return
+	}
+	if e, exists := c.mu.entries[string(key)]; exists {
+		c.removeLocked(e)
+	}
+	entry := &lookupCacheEntry{
+		key:    string(key),
+		found:  found,
+		seqNum: seqNum,
+	}
+	if found {
+		entry.value = append(make([]byte, 0, len(value)), value...)
+	}
+	c.mu.entries[entry.key] = c.mu.lru.PushFront(entry)
+	c.mu.size += size
+	for c.mu.size > c.capacity {
+		c.removeLocked(c.mu.lru.Back())
+	}
+}
+
+func (c *lookupCache) removeLocked(e *list.Element) {
+	entry := c.mu.lru.Remove(e).(*lookupCacheEntry)
+	delete(c.mu.entries, entry.key)
+	c.mu.size -= lookupCacheEntrySize([]byte(entry.key), entry.value)
+}
+
+// invalidateBatch invalidates the entries of the keys written by a batch
+// about to become visible. Range deletions invalidate all the entries.
+func (c *lookupCache) invalidateBatch(b *Batch) {
+	// The lookups at sequence numbers past the batch observe its writes.
+	seqNum := b.SeqNum() + uint64(b.Count())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := b.Reader()
+	for {
+		kind, ukey, _, ok := r.Next()
+		if !ok {
+			break
+		}
+		switch kind {
+		case InternalKeyKindSet, InternalKeyKindMerge, InternalKeyKindDelete,
+			InternalKeyKindSingleDelete, InternalKeyKindSetWithDelete:
+			if e, exists := c.mu.entries[string(ukey)]; exists {
+				c.removeLocked(e)
+			}
+			bucket := lookupCacheBucket(ukey)
+			if c.mu.minSeqNum[bucket] < seqNum {
+				c.mu.minSeqNum[bucket] = seqNum
+			}
+		case InternalKeyKindRangeDelete:
+			c.invalidateAllLocked(seqNum)
+		}
+	}
+}
+
+// invalidateAll invalidates all the entries, as the visible keys may have
+// changed. The lookups at sequence numbers lower than seqNum may not observe
+// the change.
+func (c *lookupCache) invalidateAll(seqNum uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidateAllLocked(seqNum)
+}
+
+func (c *lookupCache) invalidateAllLocked(seqNum uint64) {
+	c.epoch.Add(1)
+	if c.mu.minSeqNumAll < seqNum {
+		c.mu.minSeqNumAll = seqNum
+	}
+	if len(c.mu.entries) == 0 {
+		return
+	}
+	c.mu.entries = make(map[string]*list.Element)
+	c.mu.lru.Init()
+	c.mu.size = 0
+}
+
+// noopCloser is the io.Closer returned along with the values served by a
+// lookupCache, which don't need to be released.
+type noopCloser struct{}
+
+func (noopCloser) Close() error { return nil }
+
+func (c *lookupCache) metrics() CacheMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheMetrics{
+		Size:   c.mu.size,
+		Count:  int64(len(c.mu.entries)),
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
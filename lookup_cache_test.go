@@ -0,0 +1,169 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupCache(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true}
+	opts.Experimental.LookupCacheSize = 1 << 20
+	// Values with the legacy "v1:" encoding are rewritten with the "v2:"
+	// encoding by compactions.
+	opts.Experimental.CompactionFilter = func(
+		level int, key, value []byte,
+	) (CompactionFilterDecision, []byte) {
+		if bytes.HasPrefix(value, []byte("v1:")) {
+			return CompactionFilterChangeValue, append([]byte("v2:"), value[3:]...)
+		}
+		return CompactionFilterKeep, nil
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	get := func(r Reader, key string) string {
+		value, closer, err := r.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer func() { require.NoError(t, closer.Close()) }()
+		return string(value)
+	}
+	hits := func() int64 {
+		return d.Metrics().LookupCache.Hits
+	}
+
+	// Repeated lookups are served by the cache, including those of missing
+	// keys.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.Equal(t, "1", get(d, "a"))
+	require.Equal(t, int64(0), hits())
+	require.Equal(t, "1", get(d, "a"))
+	require.Equal(t, "<not found>", get(d, "b"))
+	require.Equal(t, "<not found>", get(d, "b"))
+	require.Equal(t, int64(2), hits())
+	m := d.Metrics().LookupCache
+	require.Equal(t, int64(2), m.Count)
+	require.Equal(t, int64(2), m.Misses)
+
+	// Writes invalidate the cached results of the keys they write.
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("v1:2"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("3"), nil))
+	require.Equal(t, "v1:2", get(d, "a"))
+	require.Equal(t, "3", get(d, "b"))
+	require.Equal(t, int64(2), hits())
+	require.Equal(t, "v1:2", get(d, "a"))
+	require.Equal(t, int64(3), hits())
+
+	// Snapshots don't read the results of lookups of newer states.
+	require.Equal(t, "1", get(snap, "a"))
+	require.Equal(t, int64(3), hits())
+
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("b"), nil))
+	require.Equal(t, "<not found>", get(d, "a"))
+	require.Equal(t, "3", get(d, "b"))
+	require.Equal(t, int64(3), hits())
+	require.NoError(t, d.Set([]byte("a"), []byte("v1:4"), nil))
+	require.Equal(t, "v1:4", get(d, "a"))
+
+	// Changes to the LSM invalidate all the cached results, as compactions
+	// may change the visible values.
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	require.Equal(t, "v2:4", get(d, "a"))
+	require.Equal(t, "v2:4", get(d, "a"))
+	require.Equal(t, int64(4), hits())
+
+	// The cache is incompatible with TTL.
+	opts.Experimental.TTL.Expiration = func(key, value []byte) (time.Time, bool) {
+		return time.Time{}, false
+	}
+	require.Error(t, opts.Validate())
+}
+
+func TestLookupCacheEviction(t *testing.T) {
+	c := newLookupCache(4 * (lookupCacheEntryOverhead + 2))
+	for i := 0; i < 8; i++ {
+		key := []byte(strconv.Itoa(i))
+		c.add(key, key, true /* found */, 1 /* seqNum */, c.epoch.Load())
+		// The least recently used entry is evicted, so the first entry is
+		// retained.
+		_, _, ok := c.get([]byte("0"), 1)
+		require.True(t, ok)
+	}
+	m := c.metrics()
+	require.Equal(t, int64(4), m.Count)
+	_, _, ok := c.get([]byte("4"), 1)
+	require.False(t, ok)
+	_, _, ok = c.get([]byte("7"), 1)
+	require.True(t, ok)
+
+	// The results of lookups that may not observe the writes invalidating the
+	// cache aren't added.
+	c.invalidateAll(10)
+	c.add([]byte("a"), nil, false /* found */, 9, c.epoch.Load())
+	epoch := c.epoch.Load()
+	c.invalidateAll(10)
+	c.add([]byte("a"), nil, false /* found */, 10, epoch)
+	require.Equal(t, int64(0), c.metrics().Count)
+	c.add([]byte("a"), nil, false /* found */, 10, c.epoch.Load())
+	require.Equal(t, int64(1), c.metrics().Count)
+}
+
+// TestLookupCacheConsistency verifies that a lookup observes the writes that
+// completed before it, while concurrent lookups populate the cache.
+func TestLookupCacheConsistency(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), MemTableSize: 256 << 10}
+	opts.Experimental.LookupCacheSize = 1 << 20
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const keys = 4
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; !done.Load(); j++ {
+				_, closer, err := d.Get([]byte(fmt.Sprint(j % keys)))
+				if err == nil {
+					_ = closer.Close()
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5000; i++ {
+		key := []byte(fmt.Sprint(i % keys))
+		value := []byte(strconv.Itoa(i))
+		if i%100 == 0 {
+			require.NoError(t, d.Delete(key, nil))
+			_, _, err := d.Get(key)
+			require.Equal(t, ErrNotFound, err)
+		}
+		require.NoError(t, d.Set(key, value, nil))
+		v, closer, err := d.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, string(value), string(v))
+		require.NoError(t, closer.Close())
+	}
+	done.Store(true)
+	wg.Wait()
+	require.Greater(t, d.Metrics().LookupCache.Hits, int64(0))
+}
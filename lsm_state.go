@@ -0,0 +1,219 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// LSMState is a structured snapshot of the state of the LSM, suitable for
+// serialization (eg, through encoding/json) and rendering by external tools.
+type LSMState struct {
+	// FormatMajorVersion is the database's format major version.
+	FormatMajorVersion FormatMajorVersion
+	// BaseLevel is the level into which L0 is currently compacted.
+	BaseLevel int
+	// Levels is indexed by level.
+	Levels [numLevels]LSMLevelState
+}
+
+// LSMLevelState describes a single level of the LSM.
+type LSMLevelState struct {
+	// Level is the level number.
+	Level int
+	// Size is the sum of the sizes of the files in the level.
+	Size uint64
+	// NumFiles is the number of files in the level.
+	NumFiles int
+	// Score is the compaction picker's score for the level.
+	Score float64
+	// Sublevels holds the files in the level, indexed by sublevel. Only L0 has
+	// more than one sublevel, and the sublevels are ordered from oldest to
+	// newest.
+	Sublevels [][]LSMFileState `json:",omitempty"`
+}
+
+// LSMFileState describes a single sstable in the LSM.
+type LSMFileState struct {
+	// FileNum is the file number of the table.
+	FileNum base.FileNum
+	// BackingFileNum is the file number of the physical file backing the
+	// table. It is equal to FileNum unless the table is virtual.
+	BackingFileNum base.FileNum
+	// Sublevel is the L0 sublevel of the table, or zero for tables in other
+	// levels.
+	Sublevel int
+	// Size is the size of the table in bytes. For a virtual table, the size is
+	// an estimate.
+	Size uint64
+	// Smallest and Largest are the formatted bounds of the table.
+	Smallest string
+	Largest  string
+	// SmallestSeqNum and LargestSeqNum bound the sequence numbers of the keys
+	// in the table.
+	SmallestSeqNum uint64
+	LargestSeqNum  uint64
+	// HasPointKeys and HasRangeKeys indicate the types of keys in the table.
+	HasPointKeys bool
+	HasRangeKeys bool
+	// Virtual is true if the table is a virtual sstable.
+	Virtual bool
+	// Shared is true if the backing table resides on shared storage.
+	Shared bool
+	// Foreign is true if the backing table resides on shared storage and was
+	// created by another DB instance.
+	Foreign bool
+	// Compacting is true if the table is an input to an ongoing compaction.
+	Compacting bool
+	// MarkedForCompaction is true if the table has been marked for a rewrite
+	// compaction.
+	MarkedForCompaction bool
+	// Properties holds a summary of the table's properties. It is only
+	// populated if the WithProperties option is passed to LSMState.
+	Properties *LSMFileProperties `json:",omitempty"`
+}
+
+// LSMFileProperties summarizes the properties of an sstable. If the table is
+// virtual, the properties are those of the backing table.
+type LSMFileProperties struct {
+	NumEntries        uint64
+	NumDeletions      uint64
+	NumRangeDeletions uint64
+	NumRangeKeys      uint64
+	RawKeySize        uint64
+	RawValueSize      uint64
+	DataSize          uint64
+	IndexSize         uint64
+	FilterSize        uint64
+	ValueBlocksSize   uint64
+}
+
+func levelSize(lm manifest.LevelMetadata) uint64 {
+	s := lm.Slice()
+	return s.SizeSum()
+}
+
+func makeLSMFileProperties(p *sstable.Properties) *LSMFileProperties {
+	return &LSMFileProperties{
+		NumEntries:        p.NumEntries,
+		NumDeletions:      p.NumDeletions,
+		NumRangeDeletions: p.NumRangeDeletions,
+		NumRangeKeys:      p.NumRangeKeys(),
+		RawKeySize:        p.RawKeySize,
+		RawValueSize:      p.RawValueSize,
+		DataSize:          p.DataSize,
+		IndexSize:         p.IndexSize,
+		FilterSize:        p.FilterSize,
+		ValueBlocksSize:   p.ValueBlocksSize,
+	}
+}
+
+// LSMState returns a structured snapshot of the current state of the LSM. The
+// WithProperties option may be passed to include a summary of each table's
+// properties, which may require reading them from disk.
+func (d *DB) LSMState(opts ...SSTablesOption) (*LSMState, error) {
+	opt := &sstablesOptions{}
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+
+	// Grab and reference the current readState.
+	readState := d.loadReadState()
+	defer readState.unref()
+	v := readState.current
+
+	s := &LSMState{}
+	d.mu.Lock()
+	s.FormatMajorVersion = d.mu.formatVers.vers
+	var scores [numLevels]float64
+	if p := d.mu.versions.picker; p != nil {
+		s.BaseLevel = p.getBaseLevel()
+		scores = p.getScores(d.getInProgressCompactionInfoLocked(nil))
+	}
+	// The compacting state of files is protected by d.mu.
+	compacting := make(map[*fileMetadata]bool)
+	for level := range v.Levels {
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.IsCompacting() {
+				compacting[f] = true
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	makeFileState := func(f *fileMetadata) (LSMFileState, error) {
+		fs := LSMFileState{
+			FileNum:             f.FileNum,
+			BackingFileNum:      f.FileBacking.DiskFileNum.FileNum(),
+			Size:                f.Size,
+			Smallest:            fmt.Sprint(f.Smallest.Pretty(d.opts.Comparer.FormatKey)),
+			Largest:             fmt.Sprint(f.Largest.Pretty(d.opts.Comparer.FormatKey)),
+			SmallestSeqNum:      f.SmallestSeqNum,
+			LargestSeqNum:       f.LargestSeqNum,
+			HasPointKeys:        f.HasPointKeys,
+			HasRangeKeys:        f.HasRangeKeys,
+			Virtual:             f.Virtual,
+			Compacting:          compacting[f],
+			MarkedForCompaction: f.MarkedForCompaction,
+		}
+		if meta, err := d.objProvider.Lookup(fileTypeTable, f.FileBacking.DiskFileNum); err == nil {
+			fs.Shared = meta.IsShared()
+			fs.Foreign = d.objProvider.IsForeign(meta)
+		}
+		if opt.withProperties {
+			p, err := d.tableCache.getTableProperties(f)
+			if err != nil {
+				return LSMFileState{}, err
+			}
+			fs.Properties = makeLSMFileProperties(p)
+		}
+		return fs, nil
+	}
+
+	for level := range v.Levels {
+		ls := &s.Levels[level]
+		ls.Level = level
+		ls.Size = levelSize(v.Levels[level])
+		ls.NumFiles = v.Levels[level].Len()
+		ls.Score = scores[level]
+		if ls.NumFiles == 0 {
+			continue
+		}
+		if level == 0 {
+			ls.Sublevels = make([][]LSMFileState, len(v.L0SublevelFiles))
+			for sublevel, files := range v.L0SublevelFiles {
+				iter := files.Iter()
+				for f := iter.First(); f != nil; f = iter.Next() {
+					fs, err := makeFileState(f)
+					if err != nil {
+						return nil, err
+					}
+					fs.Sublevel = sublevel
+					ls.Sublevels[sublevel] = append(ls.Sublevels[sublevel], fs)
+				}
+			}
+			continue
+		}
+		files := make([]LSMFileState, 0, ls.NumFiles)
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			fs, err := makeFileState(f)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, fs)
+		}
+		ls.Sublevels = [][]LSMFileState{files}
+	}
+	return s, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLSMState(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Create two overlapping L0 tables, and one L6 table.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false))
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("3"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, d.Flush())
+
+	s, err := d.LSMState()
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Levels[0].NumFiles)
+	require.Len(t, s.Levels[0].Sublevels, 2)
+	for sublevel, files := range s.Levels[0].Sublevels {
+		require.Len(t, files, 1)
+		require.Equal(t, sublevel, files[0].Sublevel)
+		require.Nil(t, files[0].Properties)
+	}
+	require.Equal(t, 1, s.Levels[6].NumFiles)
+	f := s.Levels[6].Sublevels[0][0]
+	require.Equal(t, "a#10,SET", f.Smallest)
+	require.Equal(t, "c#11,SET", f.Largest)
+	require.Equal(t, f.FileNum, f.BackingFileNum)
+	require.False(t, f.Virtual)
+	require.False(t, f.Shared)
+	require.Equal(t, f.Size, s.Levels[6].Size)
+	for level := 1; level < 6; level++ {
+		require.Zero(t, s.Levels[level].NumFiles)
+		require.Nil(t, s.Levels[level].Sublevels)
+	}
+
+	s, err = d.LSMState(WithProperties())
+	require.NoError(t, err)
+	require.NotNil(t, s.Levels[6].Sublevels[0][0].Properties)
+	require.Equal(t, uint64(2), s.Levels[6].Sublevels[0][0].Properties.NumEntries)
+
+	// The state must be serializable.
+	_, err = json.Marshal(s)
+	require.NoError(t, err)
+}
@@ -386,6 +386,7 @@ func randomOptions(
 	opts.Experimental.LevelMultiplier = 5 << rng.Intn(7)           // 5 - 320
 	opts.Experimental.MinDeletionRate = 1 << uint(20+rng.Intn(10)) // 1MB - 1GB
 	opts.Experimental.ValidateOnIngest = rng.Intn(2) != 0
+	opts.Experimental.VerifyCompactions = rng.Intn(2) != 0
 	opts.L0CompactionThreshold = 1 + rng.Intn(100)     // 1 - 100
 	opts.L0CompactionFileThreshold = 1 << rng.Intn(11) // 1 - 1024
 	opts.L0StopWritesThreshold = 1 + rng.Intn(100)     // 1 - 100
@@ -409,6 +410,7 @@ func randomOptions(
 		// same effect currently.
 		opts.Experimental.MaxWriterConcurrency = 2
 		opts.Experimental.ForceWriterParallelism = true
+		opts.Experimental.CompressionConcurrency = rng.Intn(4) // 0 - 3
 	}
 	if rng.Intn(2) == 0 {
 		opts.Experimental.DisableIngestAsFlushable = func() bool { return true }
@@ -378,7 +378,9 @@ func (p *parser) parseArgs(op op, methodName string, args []interface{}) {
 
 		case *pebble.FormatMajorVersion:
 			_, lit := p.scanToken(token.INT)
-			val, err := strconv.ParseUint(lit, 0, 64)
+			// The version is formatted as a zero-padded base 10 integer, which
+			// must not be parsed as an octal literal.
+			val, err := strconv.ParseUint(lit, 10, 64)
 			if err != nil {
 				panic(err)
 			}
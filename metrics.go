@@ -12,6 +12,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/redact"
@@ -21,6 +22,10 @@ import (
 // CacheMetrics holds metrics for the block and table cache.
 type CacheMetrics = cache.Metrics
 
+// SecondaryCacheMetrics holds metrics for the persistent cache of the blocks
+// of sstables on shared storage.
+type SecondaryCacheMetrics = objstorage.SecondaryCacheMetrics
+
 // FilterMetrics holds metrics for the filter policy
 type FilterMetrics = sstable.FilterMetrics
 
@@ -85,11 +90,19 @@ type LevelMetrics struct {
 		// level. Printed by LevelMetrics.format iff there is at least one level
 		// with a non-zero value.
 		ValueBlocksSize uint64
+		// The sum of Properties.FilterSize for all the sstables in this level.
+		// Not printed by LevelMetrics.format.
+		FilterSize uint64
 		// Cumulative metrics about bytes written to data blocks and value blocks,
 		// via compactions (except move compactions) or flushes. Not printed by
 		// LevelMetrics.format, but are available to sophisticated clients.
 		BytesWrittenDataBlocks  uint64
 		BytesWrittenValueBlocks uint64
+		// The approximate count of RANGEKEYSET fragments in the sstables of
+		// this level, from the table stats which have been loaded. Not printed
+		// by LevelMetrics.format. The sum across the levels is
+		// Metrics.Keys.RangeKeySetsCount.
+		RangeKeySetsCount uint64
 	}
 }
 
@@ -110,6 +123,8 @@ func (m *LevelMetrics) Add(u *LevelMetrics) {
 	m.Additional.BytesWrittenDataBlocks += u.Additional.BytesWrittenDataBlocks
 	m.Additional.BytesWrittenValueBlocks += u.Additional.BytesWrittenValueBlocks
 	m.Additional.ValueBlocksSize += u.Additional.ValueBlocksSize
+	m.Additional.FilterSize += u.Additional.FilterSize
+	m.Additional.RangeKeySetsCount += u.Additional.RangeKeySetsCount
 }
 
 // WriteAmp computes the write amplification for compactions at this
@@ -156,6 +171,20 @@ func (m *LevelMetrics) format(
 type Metrics struct {
 	BlockCache CacheMetrics
 
+	// BulkScanCache holds the metrics of the cache used by bulk scans, if the
+	// DB is configured with one. See Options.Experimental.BulkScanCacheSize.
+	BulkScanCache CacheMetrics
+
+	// LookupCache holds the metrics of the cache of the results of point
+	// lookups, if the DB is configured with one. See
+	// Options.Experimental.LookupCacheSize.
+	LookupCache CacheMetrics
+
+	// SecondaryCache holds the metrics of the persistent cache of the blocks
+	// of sstables on shared storage, if the DB is configured with one. See
+	// Options.Experimental.SecondaryCacheSizeBytes.
+	SecondaryCache SecondaryCacheMetrics
+
 	Compact struct {
 		// The total number of compactions, and per-compaction type counts.
 		Count            int64
@@ -166,6 +195,29 @@ type Metrics struct {
 		ReadCount        int64
 		RewriteCount     int64
 		MultiLevelCount  int64
+		// ReadRequestedCount is the number of read compactions requested by
+		// sampled iterator reads since Open, including the requests that were
+		// deduplicated or evicted from the bounded queue of pending read
+		// compactions, while ReadCount is the number of read compactions that
+		// ran. Read sampling is configured by
+		// Options.Experimental.ReadSamplingMultiplier, and can be disabled per
+		// iterator with IterOptions.DisableReadSampling.
+		ReadRequestedCount int64
+		// SmallFileCount is the number of compactions that merged runs of
+		// small sstables purely to restore the target file size (see
+		// SmallFileCompactionOptions), and SmallFileInputCount is the total
+		// number of sstables merged by them.
+		SmallFileCount      int64
+		SmallFileInputCount int64
+		// QueueDeletedBytes is the total size of the sstables dropped by
+		// delete-only compactions because all of their keys were deleted by
+		// point tombstones (see Options.Experimental.QueueDeletionDetection).
+		QueueDeletedBytes uint64
+		// ShortLivedExpiredCount is the number of delete-only compactions
+		// dropping the expired sstables of short-lived spans, and
+		// ShortLivedExpiredBytes their total size (see ShortLivedSpan).
+		ShortLivedExpiredCount int64
+		ShortLivedExpiredBytes uint64
 		// An estimate of the number of bytes that need to be compacted for the LSM
 		// to reach a stable state.
 		EstimatedDebt uint64
@@ -200,6 +252,15 @@ type Metrics struct {
 		// AsIngestBytes is a monotonically increasing counter of the bytes flushed
 		// for flushables that originated as ingestion operations.
 		AsIngestBytes uint64
+		// CoalescedRangeDelSpans is a monotonically increasing counter of the
+		// range deletions written by flushes that coalesce runs of abutting range
+		// deletions (see Options.Experimental.FlushRangeDelCoalesceThreshold), and
+		// CoalescedRangeDelFragments of the fragments they replaced.
+		CoalescedRangeDelSpans     uint64
+		CoalescedRangeDelFragments uint64
+		// CoalescedRangeDelTables is a monotonically increasing counter of the
+		// sstables holding only coalesced range deletions written by flushes.
+		CoalescedRangeDelTables uint64
 	}
 
 	Filter FilterMetrics
@@ -227,6 +288,22 @@ type Metrics struct {
 		TombstoneCount uint64
 	}
 
+	RangeKeys struct {
+		// The cumulative count of RANGEKEYSET, RANGEKEYUNSET and RANGEKEYDEL
+		// keys written by committed batches.
+		SetsWritten    uint64
+		UnsetsWritten  uint64
+		DeletesWritten uint64
+		// The cumulative count of range key blocks read by iterators, from the
+		// block cache or from disk. Range key blocks skipped by the range key
+		// filters aren't counted.
+		BlocksRead uint64
+		// The cumulative count of point keys skipped by range key masking in
+		// iterators, counted when an iterator is closed or its stats are reset.
+		// See RangeKeyIteratorStats.SkippedPoints.
+		MaskedPoints uint64
+	}
+
 	Snapshots struct {
 		// The number of currently open snapshots.
 		Count int
@@ -255,6 +332,48 @@ type Metrics struct {
 		ZombieCount int64
 	}
 
+	// DeletionPacing describes the deletions of obsolete files, which are paced
+	// by Options.Experimental.MinDeletionRate and MinDeletionFileRate.
+	DeletionPacing struct {
+		// The number and total size of the obsolete files awaiting deletion.
+		BacklogCount int64
+		BacklogSize  uint64
+		// The cumulative time the deletions were delayed by the pacing.
+		ThrottledDuration time.Duration
+	}
+
+	BlobFiles struct {
+		// The number of blob files referenced by the tables of the DB. See
+		// Options.Experimental.ValueSeparation.
+		Count int64
+		// The total size of the blob files.
+		Size uint64
+		// The total length of the values of the blob files that are still
+		// referenced by the tables of the DB. The difference from Size is the
+		// garbage that remains until the blob files are rewritten or deleted.
+		LiveValueSize uint64
+		// The cumulative number and length of the values written to blob files
+		// by flushes and compactions.
+		ValuesWritten     uint64
+		ValueBytesWritten uint64
+		// The cumulative number and length of the values which weren't written
+		// to blob files because they were deduplicated with identical values.
+		// See ValueSeparationOptions.DedupMinimumSize.
+		DedupValues uint64
+		DedupBytes  uint64
+	}
+
+	TableValidation struct {
+		// The number of sstables written by flushes and compactions that have
+		// been validated. See Options.Experimental.ValidateOnWriteSampleRate.
+		Count int64
+		// The number of validated sstables that failed validation.
+		FailedCount int64
+		// The number of obsolete tables whose deletion is currently deferred
+		// until the tables written before they became obsolete are validated.
+		HeldCount int64
+	}
+
 	TableCache CacheMetrics
 
 	// Count of the number of open sstable iterators.
@@ -262,6 +381,16 @@ type Metrics struct {
 	// Uptime is the total time since this DB was opened.
 	Uptime time.Duration
 
+	// Rates holds the rates at which key metrics changed over the last minute,
+	// ten minutes and hour, computed from samples of the metrics taken every
+	// 10 seconds. They describe the current activity of the DB without
+	// requiring the caller to retain and difference successive Metrics.
+	Rates struct {
+		OneMinute  MetricsRates
+		TenMinutes MetricsRates
+		OneHour    MetricsRates
+	}
+
 	WAL struct {
 		// Number of live WAL files.
 		Files int64
@@ -286,6 +415,49 @@ type Metrics struct {
 		record.LogWriterMetrics
 	}
 
+	// Commit describes the commit pipeline. The latency of the batches
+	// committed since the DB was opened is attributed to the WAL, memtable
+	// rotation and write stalls by the cumulative BatchCommitStats. For batches
+	// committed with DB.ApplyNoSyncWait, the wait in Batch.SyncWait is not
+	// included.
+	Commit struct {
+		// The number of committed batches.
+		Count int64
+		// The number of committed batches waiting for the WAL to be synced.
+		PendingSyncs int64
+		// The number of bytes written to the WAL that have not been synced yet.
+		UnsyncedBytes uint64
+		// The maximum TotalDuration of a committed batch.
+		MaxDuration time.Duration
+		// The cumulative commit stats of the committed batches.
+		BatchCommitStats
+	}
+
+	// Freeze describes the freezes of the LSM by FreezeLSMs.
+	Freeze struct {
+		// Whether the LSM is currently frozen.
+		Frozen bool
+		// The number of freezes, including the current one.
+		Count int64
+		// The number of freezes released automatically once their maximum
+		// duration elapsed.
+		ExpiredCount int64
+		// The number of attempts to freeze the LSM which timed out.
+		TimeoutCount int64
+		// The cumulative and maximum durations of the ended freezes.
+		TotalDuration time.Duration
+		MaxDuration   time.Duration
+	}
+
+	// WriteStall describes the write stalls reported by
+	// EventListener.WriteStallBegin and WriteStallEnd.
+	WriteStall struct {
+		// The number of write stalls, including the current one, if any.
+		Count int64
+		// The cumulative duration of the write stalls.
+		Duration time.Duration
+	}
+
 	private struct {
 		optionsFileSize  uint64
 		manifestFileSize uint64
@@ -312,12 +484,23 @@ func (m *Metrics) DiskSpaceUsage() uint64 {
 	}
 	usageBytes += m.Table.ObsoleteSize
 	usageBytes += m.Table.ZombieSize
+	usageBytes += m.BlobFiles.Size
 	usageBytes += m.private.optionsFileSize
 	usageBytes += m.private.manifestFileSize
 	usageBytes += uint64(m.Compact.InProgressBytes)
 	return usageBytes
 }
 
+// BlobDedupRatio returns the proportion of the bytes of the values separated
+// into blob files which were deduplicated rather than written.
+func (m *Metrics) BlobDedupRatio() float64 {
+	total := m.BlobFiles.ValueBytesWritten + m.BlobFiles.DedupBytes
+	if total == 0 {
+		return 0
+	}
+	return float64(m.BlobFiles.DedupBytes) / float64(total)
+}
+
 func (m *Metrics) levelSizes() [numLevels]int64 {
 	var sizes [numLevels]int64
 	for i := 0; i < len(sizes); i++ {
@@ -0,0 +1,402 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "encoding/json"
+
+// MetricsJSONVersion is the version of the schema of the JSON encoding of
+// Metrics (see Metrics.MarshalJSON). Fields may be added to the schema without
+// changing its version, but fields are only removed, renamed or given a
+// different meaning along with a new version.
+const MetricsJSONVersion = 1
+
+// MarshalJSON implements json.Marshaler, encoding the metrics as a JSON object
+// suitable for scraping by monitoring systems. Unlike the Metrics struct,
+// whose fields may change between releases, the schema of the object is
+// versioned by MetricsJSONVersion, which is the value of its "version" field.
+//
+// The names of the fields are in snake case, and the units of the values are
+// suffixes of the names: sizes are in bytes and durations in seconds. Values
+// without a unit are counts, unless documented otherwise.
+// The metrics of each level are in the "levels" array, which is indexed by
+// level, and the metrics of the WAL in the "wal" object. Histograms, such as
+// LogWriter.FsyncLatency, aren't encoded.
+func (m *Metrics) MarshalJSON() ([]byte, error) {
+	j := metricsJSON{
+		Version:             MetricsJSONVersion,
+		UptimeSeconds:       m.Uptime.Seconds(),
+		ReadAmp:             m.ReadAmp(),
+		DiskSpaceUsageBytes: m.DiskSpaceUsage(),
+		Levels:              make([]levelMetricsJSON, numLevels),
+		Total:               makeLevelMetricsJSON(-1, m.Total()),
+		Compaction: compactionMetricsJSON{
+			Count:               m.Compact.Count,
+			DefaultCount:        m.Compact.DefaultCount,
+			DeleteOnlyCount:     m.Compact.DeleteOnlyCount,
+			ElisionOnlyCount:    m.Compact.ElisionOnlyCount,
+			MoveCount:           m.Compact.MoveCount,
+			ReadCount:           m.Compact.ReadCount,
+			RewriteCount:        m.Compact.RewriteCount,
+			MultiLevelCount:     m.Compact.MultiLevelCount,
+			ReadRequestedCount:  m.Compact.ReadRequestedCount,
+			SmallFileCount:      m.Compact.SmallFileCount,
+			SmallFileInputCount: m.Compact.SmallFileInputCount,
+			QueueDeletedBytes:   m.Compact.QueueDeletedBytes,
+			EstimatedDebtBytes:  m.Compact.EstimatedDebt,
+			InProgressBytes:     m.Compact.InProgressBytes,
+			NumInProgress:       m.Compact.NumInProgress,
+			MarkedFiles:         m.Compact.MarkedFiles,
+			DurationSeconds:     m.Compact.Duration.Seconds(),
+		},
+		Flush: flushMetricsJSON{
+			Count:                    m.Flush.Count,
+			NumInProgress:            m.Flush.NumInProgress,
+			WriteBytes:               m.Flush.WriteThroughput.Bytes,
+			WriteWorkDurationSeconds: m.Flush.WriteThroughput.WorkDuration.Seconds(),
+			WriteIdleDurationSeconds: m.Flush.WriteThroughput.IdleDuration.Seconds(),
+			AsIngestCount:            m.Flush.AsIngestCount,
+			AsIngestTableCount:       m.Flush.AsIngestTableCount,
+			AsIngestBytes:            m.Flush.AsIngestBytes,
+		},
+		MemTable: memTableMetricsJSON{
+			SizeBytes:       m.MemTable.Size,
+			Count:           m.MemTable.Count,
+			ZombieSizeBytes: m.MemTable.ZombieSize,
+			ZombieCount:     m.MemTable.ZombieCount,
+		},
+		Keys: keysMetricsJSON{
+			RangeKeySetsCount: m.Keys.RangeKeySetsCount,
+			TombstoneCount:    m.Keys.TombstoneCount,
+		},
+		RangeKeys: rangeKeysMetricsJSON{
+			SetsWritten:    m.RangeKeys.SetsWritten,
+			UnsetsWritten:  m.RangeKeys.UnsetsWritten,
+			DeletesWritten: m.RangeKeys.DeletesWritten,
+			BlocksRead:     m.RangeKeys.BlocksRead,
+			MaskedPoints:   m.RangeKeys.MaskedPoints,
+		},
+		Snapshots: snapshotsMetricsJSON{
+			Count:           m.Snapshots.Count,
+			EarliestSeqNum:  m.Snapshots.EarliestSeqNum,
+			PinnedKeys:      m.Snapshots.PinnedKeys,
+			PinnedSizeBytes: m.Snapshots.PinnedSize,
+		},
+		Table: tableMetricsJSON{
+			ObsoleteSizeBytes: m.Table.ObsoleteSize,
+			ObsoleteCount:     m.Table.ObsoleteCount,
+			ZombieSizeBytes:   m.Table.ZombieSize,
+			ZombieCount:       m.Table.ZombieCount,
+			Iterators:         m.TableIters,
+		},
+		BlobFiles: blobFilesMetricsJSON{
+			Count:              m.BlobFiles.Count,
+			SizeBytes:          m.BlobFiles.Size,
+			LiveValueSizeBytes: m.BlobFiles.LiveValueSize,
+			ValuesWritten:      m.BlobFiles.ValuesWritten,
+			ValueBytesWritten:  m.BlobFiles.ValueBytesWritten,
+			DedupValues:        m.BlobFiles.DedupValues,
+			DedupBytes:         m.BlobFiles.DedupBytes,
+		},
+		BlockCache:    makeCacheMetricsJSON(&m.BlockCache),
+		BulkScanCache: makeCacheMetricsJSON(&m.BulkScanCache),
+		LookupCache:   makeCacheMetricsJSON(&m.LookupCache),
+		SecondaryCache: secondaryCacheMetricsJSON{
+			SizeBytes:         m.SecondaryCache.Size,
+			Count:             m.SecondaryCache.Count,
+			Hits:              m.SecondaryCache.Hits,
+			Misses:            m.SecondaryCache.Misses,
+			Evictions:         m.SecondaryCache.Evictions,
+			WriteBackFailures: m.SecondaryCache.WriteBackFailures,
+			CorruptBlocks:     m.SecondaryCache.CorruptBlocks,
+		},
+		TableCache: makeCacheMetricsJSON(&m.TableCache),
+		Filter: filterMetricsJSON{
+			Hits:   m.Filter.Hits,
+			Misses: m.Filter.Misses,
+		},
+		WAL: walMetricsJSON{
+			Files:                     m.WAL.Files,
+			ObsoleteFiles:             m.WAL.ObsoleteFiles,
+			ObsoletePhysicalSizeBytes: m.WAL.ObsoletePhysicalSize,
+			SizeBytes:                 m.WAL.Size,
+			PhysicalSizeBytes:         m.WAL.PhysicalSize,
+			BytesIn:                   m.WAL.BytesIn,
+			BytesWritten:              m.WAL.BytesWritten,
+			WriteBytes:                m.LogWriter.WriteThroughput.Bytes,
+			WriteWorkDurationSeconds:  m.LogWriter.WriteThroughput.WorkDuration.Seconds(),
+			WriteIdleDurationSeconds:  m.LogWriter.WriteThroughput.IdleDuration.Seconds(),
+		},
+		Commit: commitMetricsJSON{
+			Count:                              m.Commit.Count,
+			PendingSyncs:                       m.Commit.PendingSyncs,
+			UnsyncedBytes:                      m.Commit.UnsyncedBytes,
+			MaxDurationSeconds:                 m.Commit.MaxDuration.Seconds(),
+			TotalDurationSeconds:               m.Commit.TotalDuration.Seconds(),
+			SemaphoreWaitDurationSeconds:       m.Commit.SemaphoreWaitDuration.Seconds(),
+			WALQueueWaitDurationSeconds:        m.Commit.WALQueueWaitDuration.Seconds(),
+			MemTableWriteStallDurationSeconds:  m.Commit.MemTableWriteStallDuration.Seconds(),
+			L0ReadAmpWriteStallDurationSeconds: m.Commit.L0ReadAmpWriteStallDuration.Seconds(),
+			WALRotationDurationSeconds:         m.Commit.WALRotationDuration.Seconds(),
+			CommitWaitDurationSeconds:          m.Commit.CommitWaitDuration.Seconds(),
+		},
+		TableValidation: tableValidationMetricsJSON{
+			Count:       m.TableValidation.Count,
+			FailedCount: m.TableValidation.FailedCount,
+			HeldCount:   m.TableValidation.HeldCount,
+		},
+		DeletionPacing: deletionPacingMetricsJSON{
+			BacklogCount:             m.DeletionPacing.BacklogCount,
+			BacklogSizeBytes:         m.DeletionPacing.BacklogSize,
+			ThrottledDurationSeconds: m.DeletionPacing.ThrottledDuration.Seconds(),
+		},
+		WriteStall: writeStallMetricsJSON{
+			Count:           m.WriteStall.Count,
+			DurationSeconds: m.WriteStall.Duration.Seconds(),
+		},
+	}
+	for level := range m.Levels {
+		j.Levels[level] = makeLevelMetricsJSON(level, m.Levels[level])
+	}
+	return json.Marshal(&j)
+}
+
+// The types below define the schema of the JSON encoding of Metrics. See
+// Metrics.MarshalJSON.
+
+type metricsJSON struct {
+	Version             int                        `json:"version"`
+	UptimeSeconds       float64                    `json:"uptime_seconds"`
+	ReadAmp             int                        `json:"read_amp"`
+	DiskSpaceUsageBytes uint64                     `json:"disk_space_usage_bytes"`
+	Levels              []levelMetricsJSON         `json:"levels"`
+	Total               levelMetricsJSON           `json:"total"`
+	Compaction          compactionMetricsJSON      `json:"compaction"`
+	Flush               flushMetricsJSON           `json:"flush"`
+	MemTable            memTableMetricsJSON        `json:"memtable"`
+	Keys                keysMetricsJSON            `json:"keys"`
+	RangeKeys           rangeKeysMetricsJSON       `json:"range_keys"`
+	Snapshots           snapshotsMetricsJSON       `json:"snapshots"`
+	Table               tableMetricsJSON           `json:"table"`
+	BlobFiles           blobFilesMetricsJSON       `json:"blob_files"`
+	BlockCache          cacheMetricsJSON           `json:"block_cache"`
+	BulkScanCache       cacheMetricsJSON           `json:"bulk_scan_cache"`
+	LookupCache         cacheMetricsJSON           `json:"lookup_cache"`
+	SecondaryCache      secondaryCacheMetricsJSON  `json:"secondary_cache"`
+	TableCache          cacheMetricsJSON           `json:"table_cache"`
+	Filter              filterMetricsJSON          `json:"filter"`
+	WAL                 walMetricsJSON             `json:"wal"`
+	Commit              commitMetricsJSON          `json:"commit"`
+	TableValidation     tableValidationMetricsJSON `json:"table_validation"`
+	DeletionPacing      deletionPacingMetricsJSON  `json:"deletion_pacing"`
+	WriteStall          writeStallMetricsJSON      `json:"write_stall"`
+}
+
+// levelMetricsJSON encodes LevelMetrics. The level of the total of the
+// levels is omitted.
+type levelMetricsJSON struct {
+	Level                   *int    `json:"level,omitempty"`
+	Sublevels               int32   `json:"sublevels"`
+	NumFiles                int64   `json:"num_files"`
+	SizeBytes               int64   `json:"size_bytes"`
+	Score                   float64 `json:"score"`
+	BytesIn                 uint64  `json:"bytes_in"`
+	BytesIngested           uint64  `json:"bytes_ingested"`
+	BytesMoved              uint64  `json:"bytes_moved"`
+	BytesRead               uint64  `json:"bytes_read"`
+	BytesCompacted          uint64  `json:"bytes_compacted"`
+	BytesFlushed            uint64  `json:"bytes_flushed"`
+	TablesCompacted         uint64  `json:"tables_compacted"`
+	TablesFlushed           uint64  `json:"tables_flushed"`
+	TablesIngested          uint64  `json:"tables_ingested"`
+	TablesMoved             uint64  `json:"tables_moved"`
+	WriteAmp                float64 `json:"write_amp"`
+	ValueBlocksSizeBytes    uint64  `json:"value_blocks_size_bytes"`
+	FilterSizeBytes         uint64  `json:"filter_size_bytes"`
+	BytesWrittenDataBlocks  uint64  `json:"bytes_written_data_blocks"`
+	BytesWrittenValueBlocks uint64  `json:"bytes_written_value_blocks"`
+	RangeKeySetsCount       uint64  `json:"range_key_sets_count"`
+}
+
+func makeLevelMetricsJSON(level int, m LevelMetrics) levelMetricsJSON {
+	j := levelMetricsJSON{
+		Sublevels:               m.Sublevels,
+		NumFiles:                m.NumFiles,
+		SizeBytes:               m.Size,
+		Score:                   m.Score,
+		BytesIn:                 m.BytesIn,
+		BytesIngested:           m.BytesIngested,
+		BytesMoved:              m.BytesMoved,
+		BytesRead:               m.BytesRead,
+		BytesCompacted:          m.BytesCompacted,
+		BytesFlushed:            m.BytesFlushed,
+		TablesCompacted:         m.TablesCompacted,
+		TablesFlushed:           m.TablesFlushed,
+		TablesIngested:          m.TablesIngested,
+		TablesMoved:             m.TablesMoved,
+		WriteAmp:                m.WriteAmp(),
+		ValueBlocksSizeBytes:    m.Additional.ValueBlocksSize,
+		FilterSizeBytes:         m.Additional.FilterSize,
+		BytesWrittenDataBlocks:  m.Additional.BytesWrittenDataBlocks,
+		BytesWrittenValueBlocks: m.Additional.BytesWrittenValueBlocks,
+		RangeKeySetsCount:       m.Additional.RangeKeySetsCount,
+	}
+	if level >= 0 {
+		j.Level = &level
+	}
+	return j
+}
+
+type compactionMetricsJSON struct {
+	Count               int64   `json:"count"`
+	DefaultCount        int64   `json:"default_count"`
+	DeleteOnlyCount     int64   `json:"delete_only_count"`
+	ElisionOnlyCount    int64   `json:"elision_only_count"`
+	MoveCount           int64   `json:"move_count"`
+	ReadCount           int64   `json:"read_count"`
+	RewriteCount        int64   `json:"rewrite_count"`
+	MultiLevelCount     int64   `json:"multi_level_count"`
+	ReadRequestedCount  int64   `json:"read_requested_count"`
+	SmallFileCount      int64   `json:"small_file_count"`
+	SmallFileInputCount int64   `json:"small_file_input_count"`
+	QueueDeletedBytes   uint64  `json:"queue_deleted_bytes"`
+	EstimatedDebtBytes  uint64  `json:"estimated_debt_bytes"`
+	InProgressBytes     int64   `json:"in_progress_bytes"`
+	NumInProgress       int64   `json:"num_in_progress"`
+	MarkedFiles         int     `json:"marked_files"`
+	DurationSeconds     float64 `json:"duration_seconds"`
+}
+
+type flushMetricsJSON struct {
+	Count                    int64   `json:"count"`
+	NumInProgress            int64   `json:"num_in_progress"`
+	WriteBytes               int64   `json:"write_bytes"`
+	WriteWorkDurationSeconds float64 `json:"write_work_duration_seconds"`
+	WriteIdleDurationSeconds float64 `json:"write_idle_duration_seconds"`
+	AsIngestCount            uint64  `json:"as_ingest_count"`
+	AsIngestTableCount       uint64  `json:"as_ingest_table_count"`
+	AsIngestBytes            uint64  `json:"as_ingest_bytes"`
+}
+
+type memTableMetricsJSON struct {
+	SizeBytes       uint64 `json:"size_bytes"`
+	Count           int64  `json:"count"`
+	ZombieSizeBytes uint64 `json:"zombie_size_bytes"`
+	ZombieCount     int64  `json:"zombie_count"`
+}
+
+type keysMetricsJSON struct {
+	RangeKeySetsCount uint64 `json:"range_key_sets_count"`
+	TombstoneCount    uint64 `json:"tombstone_count"`
+}
+
+type rangeKeysMetricsJSON struct {
+	SetsWritten    uint64 `json:"sets_written"`
+	UnsetsWritten  uint64 `json:"unsets_written"`
+	DeletesWritten uint64 `json:"deletes_written"`
+	BlocksRead     uint64 `json:"blocks_read"`
+	MaskedPoints   uint64 `json:"masked_points"`
+}
+
+type snapshotsMetricsJSON struct {
+	Count           int    `json:"count"`
+	EarliestSeqNum  uint64 `json:"earliest_seq_num"`
+	PinnedKeys      uint64 `json:"pinned_keys"`
+	PinnedSizeBytes uint64 `json:"pinned_size_bytes"`
+}
+
+type tableMetricsJSON struct {
+	ObsoleteSizeBytes uint64 `json:"obsolete_size_bytes"`
+	ObsoleteCount     int64  `json:"obsolete_count"`
+	ZombieSizeBytes   uint64 `json:"zombie_size_bytes"`
+	ZombieCount       int64  `json:"zombie_count"`
+	Iterators         int64  `json:"iterators"`
+}
+
+type blobFilesMetricsJSON struct {
+	Count              int64  `json:"count"`
+	SizeBytes          uint64 `json:"size_bytes"`
+	LiveValueSizeBytes uint64 `json:"live_value_size_bytes"`
+	ValuesWritten      uint64 `json:"values_written"`
+	ValueBytesWritten  uint64 `json:"value_bytes_written"`
+	DedupValues        uint64 `json:"dedup_values"`
+	DedupBytes         uint64 `json:"dedup_bytes"`
+}
+
+type cacheMetricsJSON struct {
+	SizeBytes int64 `json:"size_bytes"`
+	Count     int64 `json:"count"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Admitted  int64 `json:"admitted"`
+	Rejected  int64 `json:"rejected"`
+}
+
+type secondaryCacheMetricsJSON struct {
+	SizeBytes         int64 `json:"size_bytes"`
+	Count             int64 `json:"count"`
+	Hits              int64 `json:"hits"`
+	Misses            int64 `json:"misses"`
+	Evictions         int64 `json:"evictions"`
+	WriteBackFailures int64 `json:"write_back_failures"`
+	CorruptBlocks     int64 `json:"corrupt_blocks"`
+}
+
+func makeCacheMetricsJSON(m *CacheMetrics) cacheMetricsJSON {
+	return cacheMetricsJSON{
+		SizeBytes: m.Size, Count: m.Count, Hits: m.Hits, Misses: m.Misses,
+		Admitted: m.Admitted, Rejected: m.Rejected,
+	}
+}
+
+type filterMetricsJSON struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+type walMetricsJSON struct {
+	Files                     int64   `json:"files"`
+	ObsoleteFiles             int64   `json:"obsolete_files"`
+	ObsoletePhysicalSizeBytes uint64  `json:"obsolete_physical_size_bytes"`
+	SizeBytes                 uint64  `json:"size_bytes"`
+	PhysicalSizeBytes         uint64  `json:"physical_size_bytes"`
+	BytesIn                   uint64  `json:"bytes_in"`
+	BytesWritten              uint64  `json:"bytes_written"`
+	WriteBytes                int64   `json:"write_bytes"`
+	WriteWorkDurationSeconds  float64 `json:"write_work_duration_seconds"`
+	WriteIdleDurationSeconds  float64 `json:"write_idle_duration_seconds"`
+}
+
+type commitMetricsJSON struct {
+	Count                              int64   `json:"count"`
+	PendingSyncs                       int64   `json:"pending_syncs"`
+	UnsyncedBytes                      uint64  `json:"unsynced_bytes"`
+	MaxDurationSeconds                 float64 `json:"max_duration_seconds"`
+	TotalDurationSeconds               float64 `json:"total_duration_seconds"`
+	SemaphoreWaitDurationSeconds       float64 `json:"semaphore_wait_duration_seconds"`
+	WALQueueWaitDurationSeconds        float64 `json:"wal_queue_wait_duration_seconds"`
+	MemTableWriteStallDurationSeconds  float64 `json:"memtable_write_stall_duration_seconds"`
+	L0ReadAmpWriteStallDurationSeconds float64 `json:"l0_read_amp_write_stall_duration_seconds"`
+	WALRotationDurationSeconds         float64 `json:"wal_rotation_duration_seconds"`
+	CommitWaitDurationSeconds          float64 `json:"commit_wait_duration_seconds"`
+}
+
+type tableValidationMetricsJSON struct {
+	Count       int64 `json:"count"`
+	FailedCount int64 `json:"failed_count"`
+	HeldCount   int64 `json:"held_count"`
+}
+
+type deletionPacingMetricsJSON struct {
+	BacklogCount             int64   `json:"backlog_count"`
+	BacklogSizeBytes         uint64  `json:"backlog_size_bytes"`
+	ThrottledDurationSeconds float64 `json:"throttled_duration_seconds"`
+}
+
+type writeStallMetricsJSON struct {
+	Count           int64   `json:"count"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+var _ json.Marshaler = (*Metrics)(nil)
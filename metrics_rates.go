@@ -0,0 +1,150 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "time"
+
+const (
+	// metricsRatesInterval is the interval at which the metrics from which
+	// Metrics.Rates is computed are sampled.
+	metricsRatesInterval = 10 * time.Second
+	// metricsRatesRetention is the longest window over which Metrics.Rates are
+	// computed.
+	metricsRatesRetention = time.Hour
+)
+
+// MetricsRates holds the rates at which key metrics changed over a window of
+// time. See Metrics.Rates.
+type MetricsRates struct {
+	// Window is the duration over which the rates were computed. It is shorter
+	// than the nominal window if the DB was opened more recently than that,
+	// and may exceed it by up to the 10 second sampling interval.
+	Window time.Duration
+	// CompactionBytesPerSecond is the rate at which compactions wrote
+	// sstables (see LevelMetrics.BytesCompacted).
+	CompactionBytesPerSecond float64
+	// FlushBytesPerSecond is the rate at which flushes wrote sstables (see
+	// LevelMetrics.BytesFlushed).
+	FlushBytesPerSecond float64
+	// WriteStallFraction is the fraction of the window during which writes
+	// were stalled (see EventListener.WriteStallBegin).
+	WriteStallFraction float64
+	// BlockCacheMissesPerSecond and TableCacheMissesPerSecond are the rates
+	// of misses in the block cache and the table cache.
+	BlockCacheMissesPerSecond float64
+	TableCacheMissesPerSecond float64
+}
+
+// metricsSample holds the cumulative values of the metrics from which
+// MetricsRates are computed, at a point in time.
+type metricsSample struct {
+	at               time.Time
+	compactionBytes  uint64
+	flushBytes       uint64
+	writeStall       time.Duration
+	blockCacheMisses int64
+	tableCacheMisses int64
+}
+
+// makeMetricsSample constructs a metricsSample from the given metrics and
+// cumulative write stall duration.
+func makeMetricsSample(at time.Time, m *Metrics, writeStall time.Duration) metricsSample {
+	s := metricsSample{
+		at:               at,
+		writeStall:       writeStall,
+		blockCacheMisses: m.BlockCache.Misses,
+		tableCacheMisses: m.TableCache.Misses,
+	}
+	for i := range m.Levels {
+		s.compactionBytes += m.Levels[i].BytesCompacted
+		s.flushBytes += m.Levels[i].BytesFlushed
+	}
+	return s
+}
+
+// ratesSince returns the rates at which the metrics changed between prev and
+// s.
+func (s metricsSample) ratesSince(prev metricsSample) MetricsRates {
+	r := MetricsRates{Window: s.at.Sub(prev.at)}
+	secs := r.Window.Seconds()
+	if secs <= 0 {
+		return r
+	}
+	r.CompactionBytesPerSecond = float64(s.compactionBytes-prev.compactionBytes) / secs
+	r.FlushBytesPerSecond = float64(s.flushBytes-prev.flushBytes) / secs
+	r.WriteStallFraction = float64(s.writeStall-prev.writeStall) / float64(r.Window)
+	r.BlockCacheMissesPerSecond = float64(s.blockCacheMisses-prev.blockCacheMisses) / secs
+	r.TableCacheMissesPerSecond = float64(s.tableCacheMisses-prev.tableCacheMisses) / secs
+	return r
+}
+
+// metricsRates retains the samples of the last metricsRatesRetention, from
+// which the rates over windows of up to that length are computed.
+type metricsRates struct {
+	// samples is ordered by time. The first sample is the newest sample that is
+	// at least metricsRatesRetention old, if any.
+	samples []metricsSample
+}
+
+func (r *metricsRates) add(s metricsSample) {
+	r.samples = append(r.samples, s)
+	cutoff := s.at.Add(-metricsRatesRetention)
+	var i int
+	for i+1 < len(r.samples) && !r.samples[i+1].at.After(cutoff) {
+		i++
+	}
+	if i > 0 {
+		r.samples = append(r.samples[:0], r.samples[i:]...)
+	}
+}
+
+// rates returns the rates at which the metrics changed over the given window,
+// ending at the current sample cur. The rates are computed relative to the
+// newest sample that is at least window old, or the oldest sample if there is
+// no such sample.
+func (r *metricsRates) rates(cur metricsSample, window time.Duration) MetricsRates {
+	if len(r.samples) == 0 {
+		return MetricsRates{}
+	}
+	cutoff := cur.at.Add(-window)
+	prev := r.samples[0]
+	for _, s := range r.samples[1:] {
+		if s.at.After(cutoff) {
+			break
+		}
+		prev = s
+	}
+	return cur.ratesSince(prev)
+}
+
+// sampleMetricsRates periodically samples the metrics from which
+// Metrics.Rates is computed, until the DB is closed.
+func (d *DB) sampleMetricsRates() {
+	ticker := time.NewTicker(metricsRatesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			// The caches may be released once the DB is closed.
+			if d.closed.Load() == nil {
+				d.addMetricsSampleLocked()
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// addMetricsSampleLocked records a sample of the metrics from which
+// Metrics.Rates is computed. d.mu must be held when calling this.
+func (d *DB) addMetricsSampleLocked() {
+	var m Metrics
+	m.Levels = d.mu.versions.metrics.Levels
+	m.BlockCache = d.opts.Cache.Metrics()
+	m.TableCache, _ = d.tableCache.metrics()
+	d.mu.metricsRates.add(makeMetricsSample(d.timeNow(), &m, d.mu.writeStallDuration))
+}
@@ -5,10 +5,13 @@
 package pebble
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/datadriven"
 	"github.com/cockroachdb/pebble/internal/humanize"
@@ -322,3 +325,193 @@ func TestMetricsWAmpDisableWAL(t *testing.T) {
 	require.Greater(t, tot.WriteAmp(), 1.0)
 	require.NoError(t, d.Close())
 }
+
+func TestMetricsCommit(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	var stats []BatchCommitStats
+	for i := 0; i < 5; i++ {
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte("a"), []byte(strconv.Itoa(i)), nil))
+		require.NoError(t, b.Commit(Sync))
+		stats = append(stats, b.CommitStats())
+		require.NoError(t, b.Close())
+	}
+
+	m := d.Metrics()
+	var total, commitWait, max time.Duration
+	for _, s := range stats {
+		total += s.TotalDuration
+		commitWait += s.CommitWaitDuration
+		if s.TotalDuration > max {
+			max = s.TotalDuration
+		}
+	}
+	require.EqualValues(t, len(stats), m.Commit.Count)
+	require.Equal(t, total, m.Commit.TotalDuration)
+	require.Equal(t, commitWait, m.Commit.CommitWaitDuration)
+	require.Equal(t, max, m.Commit.MaxDuration)
+	// All the syncs have completed.
+	require.Zero(t, m.Commit.PendingSyncs)
+	require.Zero(t, m.Commit.UnsyncedBytes)
+
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), NoSync))
+	require.EqualValues(t, len(stats)+1, d.Metrics().Commit.Count)
+}
+
+func TestMetricsRates(t *testing.T) {
+	var r metricsRates
+	start := time.Unix(0, 0)
+	sample := func(secs int, flushBytes uint64, stall time.Duration) metricsSample {
+		return metricsSample{at: start.Add(time.Duration(secs) * time.Second), flushBytes: flushBytes, writeStall: stall}
+	}
+	require.Equal(t, MetricsRates{}, r.rates(sample(0, 0, 0), time.Minute))
+
+	// Sample every 10 seconds for two hours, flushing 100 bytes per second
+	// during the last minute only, half of which writes were stalled.
+	for secs := 0; secs <= 7200; secs += 10 {
+		var flushBytes uint64
+		var stall time.Duration
+		if secs > 7140 {
+			flushBytes = uint64(secs-7140) * 100
+			stall = time.Duration(secs-7140) * time.Second / 2
+		}
+		r.add(sample(secs, flushBytes, stall))
+	}
+	// Only the samples of the last hour, and the sample at its start, are
+	// retained.
+	require.Equal(t, 361, len(r.samples))
+
+	cur := sample(7200, 6000, 30*time.Second)
+	oneMinute := r.rates(cur, time.Minute)
+	require.Equal(t, time.Minute, oneMinute.Window)
+	require.Equal(t, 100.0, oneMinute.FlushBytesPerSecond)
+	require.Equal(t, 0.5, oneMinute.WriteStallFraction)
+	oneHour := r.rates(cur, time.Hour)
+	require.Equal(t, time.Hour, oneHour.Window)
+	require.InDelta(t, 6000.0/3600, oneHour.FlushBytesPerSecond, 1e-9)
+
+	// A window that precedes the retained samples is truncated.
+	require.Equal(t, time.Hour, r.rates(cur, 2*time.Hour).Window)
+
+	// The rates are populated by DB.Metrics.
+	now := start
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return now }
+	d.mu.metricsRates = metricsRates{}
+	d.addMetricsSampleLocked()
+	d.mu.Unlock()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	now = now.Add(30 * time.Second)
+	m := d.Metrics()
+	require.Equal(t, 30*time.Second, m.Rates.OneMinute.Window)
+	require.Equal(t, float64(m.Levels[0].BytesFlushed)/30, m.Rates.OneMinute.FlushBytesPerSecond)
+	require.Equal(t, m.Rates.OneMinute, m.Rates.OneHour)
+}
+
+func TestMetricsJSON(t *testing.T) {
+	var m Metrics
+	m.BlockCache.Size = 1
+	m.BlockCache.Hits = 2
+	m.Compact.Count = 3
+	m.Compact.Duration = 4 * time.Second
+	m.Flush.Count = 5
+	m.WAL.Files = 6
+	m.WAL.BytesIn = 7
+	m.WAL.BytesWritten = 8
+	m.Commit.MaxDuration = 9 * time.Millisecond
+	m.Uptime = time.Minute
+	for i := range m.Levels {
+		l := &m.Levels[i]
+		l.Sublevels = 1
+		l.NumFiles = int64(i + 1)
+		l.Size = int64(i+1) * 100
+		l.BytesIn = 10
+		l.BytesCompacted = 20
+	}
+
+	// The encoding of the metrics follows the stable schema documented by
+	// Metrics.MarshalJSON. Changes to the schema other than the addition of
+	// fields require a new MetricsJSONVersion.
+	b, err := json.MarshalIndent(&m, "", "  ")
+	require.NoError(t, err)
+	expected, err := os.ReadFile("testdata/metrics_json")
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(b)+"\n")
+
+	var decoded struct {
+		Version int `json:"version"`
+		Levels  []struct {
+			Level     int   `json:"level"`
+			NumFiles  int64 `json:"num_files"`
+			SizeBytes int64 `json:"size_bytes"`
+		} `json:"levels"`
+	}
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Equal(t, MetricsJSONVersion, decoded.Version)
+	require.Len(t, decoded.Levels, numLevels)
+	for i, l := range decoded.Levels {
+		require.Equal(t, i, l.Level)
+		require.Equal(t, m.Levels[i].NumFiles, l.NumFiles)
+		require.Equal(t, m.Levels[i].Size, l.SizeBytes)
+	}
+}
+
+func TestRangeKeyMetrics(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer:                    testkeys.Comparer,
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	b := d.NewBatch()
+	require.NoError(t, b.RangeKeySet([]byte("a"), []byte("c"), []byte("@5"), nil, nil))
+	require.NoError(t, b.RangeKeySet([]byte("d"), []byte("f"), []byte("@5"), nil, nil))
+	require.NoError(t, b.RangeKeyUnset([]byte("e"), []byte("f"), []byte("@5"), nil))
+	require.NoError(t, b.RangeKeyDelete([]byte("x"), []byte("y"), nil))
+	require.NoError(t, b.Set([]byte("b@3"), nil, nil))
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.Set([]byte("b@7"), nil, nil))
+	require.NoError(t, d.Flush())
+
+	m := d.Metrics()
+	require.EqualValues(t, 2, m.RangeKeys.SetsWritten)
+	require.EqualValues(t, 1, m.RangeKeys.UnsetsWritten)
+	require.EqualValues(t, 1, m.RangeKeys.DeletesWritten)
+	require.Zero(t, m.RangeKeys.MaskedPoints)
+
+	// The range key @5 masks the point b@3, but not b@7.
+	iter := d.NewIter(&IterOptions{
+		KeyTypes:        IterKeyTypePointsAndRanges,
+		RangeKeyMasking: RangeKeyMasking{Suffix: []byte("@9")},
+	})
+	var points int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if hasPoint, _ := iter.HasPointAndRange(); hasPoint {
+			points++
+		}
+	}
+	require.Equal(t, 1, points)
+	require.NoError(t, iter.Close())
+
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	m = d.Metrics()
+	require.EqualValues(t, 1, m.RangeKeys.MaskedPoints)
+	require.NotZero(t, m.RangeKeys.BlocksRead)
+	// The flushed table holds the fragments [a,c)@5 and [d,e)@5 of the sets,
+	// [e,f) being unset.
+	require.EqualValues(t, 2, m.Levels[0].Additional.RangeKeySetsCount)
+	require.Equal(t, m.Keys.RangeKeySetsCount, m.Total().Additional.RangeKeySetsCount)
+}
@@ -0,0 +1,352 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package metricsprom provides a prometheus.Collector exporting the metrics of
+// Pebble DBs (see pebble.Metrics).
+//
+// A single Collector exports the metrics of any number of DBs, which are
+// distinguished by the "db" label of the metrics:
+//
+//	c := metricsprom.NewCollector()
+//	prometheus.MustRegister(c)
+//	c.Register("users", usersDB)
+//	c.Register("events", eventsDB)
+//
+// The metrics are collected from the DBs when the Collector is scraped, by
+// calling DB.Metrics. The cumulative metrics of a DB, such as the number of
+// bytes compacted, are exported as counters, and its other metrics, such as the
+// number of files in a level, as gauges. The per-level metrics have a "level"
+// label.
+package metricsprom
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsSource is a source of the metrics of a DB. It's implemented by
+// *pebble.DB.
+type MetricsSource interface {
+	Metrics() *pebble.Metrics
+}
+
+// MetricsSourceFunc adapts a function to a MetricsSource. It's useful to
+// export the metrics of a DB that is reopened, by returning the metrics of
+// the DB that is currently open.
+type MetricsSourceFunc func() *pebble.Metrics
+
+// Metrics implements MetricsSource.
+func (f MetricsSourceFunc) Metrics() *pebble.Metrics {
+	return f()
+}
+
+// Collector is a prometheus.Collector exporting the metrics of the DBs
+// registered with it.
+//
+// The counters exported by a Collector track the changes of the cumulative
+// metrics of a DB, rather than their values, so that they don't decrease when
+// the metrics are reset because the source of the metrics of a DB is reopened
+// (see MetricsSourceFunc). A counter increases by the difference between the
+// values of the metric at successive scrapes, or by the value of the metric if
+// it decreased.
+type Collector struct {
+	mu struct {
+		sync.Mutex
+		dbs map[string]*dbState
+	}
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// dbState holds the source of the metrics of a registered DB and the state of
+// its counters.
+type dbState struct {
+	source   MetricsSource
+	counters map[counterKey]*counterState
+}
+
+// counterKey identifies a counter of a DB, by its descriptor and the values of
+// its labels other than "db".
+type counterKey struct {
+	desc   *prometheus.Desc
+	labels string
+}
+
+// counterState is the state of a counter: last is the value of the cumulative
+// metric at the last scrape, and total the value of the counter.
+type counterState struct {
+	last  float64
+	total float64
+}
+
+// NewCollector returns a new Collector, without any registered DB.
+func NewCollector() *Collector {
+	c := &Collector{}
+	c.mu.dbs = make(map[string]*dbState)
+	return c
+}
+
+// Register registers a DB with the collector, under the given name, which is
+// the value of the "db" label of its metrics. If a DB is already registered
+// under the name, it's replaced, but the counters of the name keep tracking
+// the changes of the cumulative metrics of the new DB. A DB must be
+// unregistered before it's closed, as the metrics of a closed DB can't be
+// collected.
+func (c *Collector) Register(name string, source MetricsSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.mu.dbs[name]; ok {
+		s.source = source
+		return
+	}
+	c.mu.dbs[name] = &dbState{source: source, counters: make(map[counterKey]*counterState)}
+}
+
+// Unregister unregisters the DB registered under the given name, whose
+// metrics are no longer exported, and discards the state of its counters.
+func (c *Collector) Unregister(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.mu.dbs, name)
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range allDescs {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, s := range c.mu.dbs {
+		s.collect(ch, name, s.source.Metrics())
+	}
+}
+
+func (s *dbState) gauge(
+	ch chan<- prometheus.Metric, desc *prometheus.Desc, v float64, labels ...string,
+) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+}
+
+// counter exports the counter tracking the given value of a cumulative metric.
+// The first label is the name of the DB.
+func (s *dbState) counter(
+	ch chan<- prometheus.Metric, desc *prometheus.Desc, v float64, labels ...string,
+) {
+	key := counterKey{desc: desc}
+	if len(labels) > 1 {
+		key.labels = labels[1]
+	}
+	cs, ok := s.counters[key]
+	if !ok {
+		cs = &counterState{}
+		s.counters[key] = cs
+	}
+	if v >= cs.last {
+		cs.total += v - cs.last
+	} else {
+		// The metric was reset.
+		cs.total += v
+	}
+	cs.last = v
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, cs.total, labels...)
+}
+
+func (s *dbState) collect(ch chan<- prometheus.Metric, name string, m *pebble.Metrics) {
+	for level := range m.Levels {
+		l := &m.Levels[level]
+		lv := strconv.Itoa(level)
+		s.gauge(ch, levelSublevels, float64(l.Sublevels), name, lv)
+		s.gauge(ch, levelFiles, float64(l.NumFiles), name, lv)
+		s.gauge(ch, levelSize, float64(l.Size), name, lv)
+		s.gauge(ch, levelScore, l.Score, name, lv)
+		s.counter(ch, levelBytesIn, float64(l.BytesIn), name, lv)
+		s.counter(ch, levelBytesIngested, float64(l.BytesIngested), name, lv)
+		s.counter(ch, levelBytesMoved, float64(l.BytesMoved), name, lv)
+		s.counter(ch, levelBytesRead, float64(l.BytesRead), name, lv)
+		s.counter(ch, levelBytesCompacted, float64(l.BytesCompacted), name, lv)
+		s.counter(ch, levelBytesFlushed, float64(l.BytesFlushed), name, lv)
+		s.counter(ch, levelTablesCompacted, float64(l.TablesCompacted), name, lv)
+		s.counter(ch, levelTablesFlushed, float64(l.TablesFlushed), name, lv)
+		s.counter(ch, levelTablesIngested, float64(l.TablesIngested), name, lv)
+		s.counter(ch, levelTablesMoved, float64(l.TablesMoved), name, lv)
+		s.gauge(ch, levelRangeKeySets, float64(l.Additional.RangeKeySetsCount), name, lv)
+	}
+
+	s.gauge(ch, readAmp, float64(m.ReadAmp()), name)
+	s.gauge(ch, diskSpaceUsage, float64(m.DiskSpaceUsage()), name)
+
+	for _, k := range []struct {
+		kind  string
+		count int64
+	}{
+		{"default", m.Compact.DefaultCount},
+		{"delete-only", m.Compact.DeleteOnlyCount},
+		{"elision-only", m.Compact.ElisionOnlyCount},
+		{"move", m.Compact.MoveCount},
+		{"read", m.Compact.ReadCount},
+		{"rewrite", m.Compact.RewriteCount},
+		{"multi-level", m.Compact.MultiLevelCount},
+	} {
+		s.counter(ch, compactions, float64(k.count), name, k.kind)
+	}
+	s.counter(ch, compactionDuration, m.Compact.Duration.Seconds(), name)
+	s.gauge(ch, compactionEstimatedDebt, float64(m.Compact.EstimatedDebt), name)
+	s.gauge(ch, compactionsInProgress, float64(m.Compact.NumInProgress), name)
+	s.gauge(ch, compactionInProgressBytes, float64(m.Compact.InProgressBytes), name)
+	s.gauge(ch, compactionMarkedFiles, float64(m.Compact.MarkedFiles), name)
+
+	s.counter(ch, flushes, float64(m.Flush.Count), name)
+	s.counter(ch, flushBytes, float64(m.Flush.WriteThroughput.Bytes), name)
+	s.gauge(ch, flushesInProgress, float64(m.Flush.NumInProgress), name)
+
+	s.gauge(ch, memTableSize, float64(m.MemTable.Size), name)
+	s.gauge(ch, memTableCount, float64(m.MemTable.Count), name)
+	s.gauge(ch, memTableZombieSize, float64(m.MemTable.ZombieSize), name)
+	s.gauge(ch, memTableZombieCount, float64(m.MemTable.ZombieCount), name)
+
+	s.gauge(ch, tombstones, float64(m.Keys.TombstoneCount), name)
+	s.gauge(ch, rangeKeySets, float64(m.Keys.RangeKeySetsCount), name)
+	for _, k := range []struct {
+		kind  string
+		count uint64
+	}{
+		{"set", m.RangeKeys.SetsWritten},
+		{"unset", m.RangeKeys.UnsetsWritten},
+		{"delete", m.RangeKeys.DeletesWritten},
+	} {
+		s.counter(ch, rangeKeysWritten, float64(k.count), name, k.kind)
+	}
+	s.counter(ch, rangeKeyBlocksRead, float64(m.RangeKeys.BlocksRead), name)
+	s.counter(ch, rangeKeyMaskedPoints, float64(m.RangeKeys.MaskedPoints), name)
+
+	s.gauge(ch, snapshots, float64(m.Snapshots.Count), name)
+	s.counter(ch, snapshotPinnedKeys, float64(m.Snapshots.PinnedKeys), name)
+	s.counter(ch, snapshotPinnedSize, float64(m.Snapshots.PinnedSize), name)
+
+	s.gauge(ch, tableObsoleteSize, float64(m.Table.ObsoleteSize), name)
+	s.gauge(ch, tableObsoleteCount, float64(m.Table.ObsoleteCount), name)
+	s.gauge(ch, tableZombieSize, float64(m.Table.ZombieSize), name)
+	s.gauge(ch, tableZombieCount, float64(m.Table.ZombieCount), name)
+	s.gauge(ch, tableIters, float64(m.TableIters), name)
+
+	for _, c := range []struct {
+		cache string
+		m     *pebble.CacheMetrics
+	}{
+		{"block", &m.BlockCache},
+		{"table", &m.TableCache},
+	} {
+		s.gauge(ch, cacheSize, float64(c.m.Size), name, c.cache)
+		s.gauge(ch, cacheCount, float64(c.m.Count), name, c.cache)
+		s.counter(ch, cacheHits, float64(c.m.Hits), name, c.cache)
+		s.counter(ch, cacheMisses, float64(c.m.Misses), name, c.cache)
+	}
+	s.counter(ch, filterHits, float64(m.Filter.Hits), name)
+	s.counter(ch, filterMisses, float64(m.Filter.Misses), name)
+
+	s.gauge(ch, walFiles, float64(m.WAL.Files), name)
+	s.gauge(ch, walSize, float64(m.WAL.Size), name)
+	s.gauge(ch, walPhysicalSize, float64(m.WAL.PhysicalSize), name)
+	s.gauge(ch, walObsoleteFiles, float64(m.WAL.ObsoleteFiles), name)
+	s.gauge(ch, walObsoletePhysicalSize, float64(m.WAL.ObsoletePhysicalSize), name)
+	s.counter(ch, walBytesIn, float64(m.WAL.BytesIn), name)
+	s.counter(ch, walBytesWritten, float64(m.WAL.BytesWritten), name)
+
+	s.counter(ch, commits, float64(m.Commit.Count), name)
+	s.counter(ch, commitDuration, m.Commit.TotalDuration.Seconds(), name)
+	s.counter(ch, commitWriteStallDuration,
+		(m.Commit.MemTableWriteStallDuration + m.Commit.L0ReadAmpWriteStallDuration).Seconds(), name)
+	s.gauge(ch, commitPendingSyncs, float64(m.Commit.PendingSyncs), name)
+}
+
+func newDesc(name, help string, labels ...string) *prometheus.Desc {
+	d := prometheus.NewDesc("pebble_"+name, help, append([]string{"db"}, labels...), nil)
+	allDescs = append(allDescs, d)
+	return d
+}
+
+// allDescs holds the descriptors of all the metrics exported by Collectors.
+var allDescs []*prometheus.Desc
+
+var (
+	levelSublevels       = newDesc("level_sublevels", "Number of sublevels of the level.", "level")
+	levelFiles           = newDesc("level_files", "Number of sstables in the level.", "level")
+	levelSize            = newDesc("level_size_bytes", "Total size of the sstables in the level.", "level")
+	levelScore           = newDesc("level_score", "Compaction score of the level.", "level")
+	levelBytesIn         = newDesc("level_bytes_in_total", "Bytes read from other levels by compactions into the level, or written to the WAL for L0.", "level")
+	levelBytesIngested   = newDesc("level_bytes_ingested_total", "Bytes of the sstables ingested into the level.", "level")
+	levelBytesMoved      = newDesc("level_bytes_moved_total", "Bytes of the sstables moved into the level.", "level")
+	levelBytesRead       = newDesc("level_bytes_read_total", "Bytes read by compactions at the level.", "level")
+	levelBytesCompacted  = newDesc("level_bytes_compacted_total", "Bytes written to the level by compactions.", "level")
+	levelBytesFlushed    = newDesc("level_bytes_flushed_total", "Bytes written to the level by flushes.", "level")
+	levelTablesCompacted = newDesc("level_tables_compacted_total", "Number of sstables written to the level by compactions.", "level")
+	levelTablesFlushed   = newDesc("level_tables_flushed_total", "Number of sstables written to the level by flushes.", "level")
+	levelTablesIngested  = newDesc("level_tables_ingested_total", "Number of sstables ingested into the level.", "level")
+	levelTablesMoved     = newDesc("level_tables_moved_total", "Number of sstables moved into the level.", "level")
+	levelRangeKeySets    = newDesc("level_range_key_sets", "Approximate number of range key sets in the level.", "level")
+
+	readAmp        = newDesc("read_amp", "Read amplification of the DB.")
+	diskSpaceUsage = newDesc("disk_space_usage_bytes", "Disk space used by the DB, including obsolete files.")
+
+	compactions               = newDesc("compactions_total", "Number of compactions, by kind.", "kind")
+	compactionDuration        = newDesc("compaction_duration_seconds_total", "Cumulative duration of the compactions.")
+	compactionEstimatedDebt   = newDesc("compaction_estimated_debt_bytes", "Estimated number of bytes to compact for the LSM to reach a stable state.")
+	compactionsInProgress     = newDesc("compactions_in_progress", "Number of compactions in progress.")
+	compactionInProgressBytes = newDesc("compaction_in_progress_bytes", "Bytes of the sstables being written by compactions in progress.")
+	compactionMarkedFiles     = newDesc("compaction_marked_files", "Number of sstables marked for compaction.")
+
+	flushes           = newDesc("flushes_total", "Number of flushes.")
+	flushBytes        = newDesc("flush_bytes_total", "Bytes written by flushes.")
+	flushesInProgress = newDesc("flushes_in_progress", "Number of flushes in progress.")
+
+	memTableSize        = newDesc("memtable_size_bytes", "Bytes allocated by memtables and large batches.")
+	memTableCount       = newDesc("memtables", "Number of memtables.")
+	memTableZombieSize  = newDesc("memtable_zombie_size_bytes", "Bytes of the memtables no longer referenced by the DB but still used by iterators.")
+	memTableZombieCount = newDesc("memtable_zombies", "Number of memtables no longer referenced by the DB but still used by iterators.")
+
+	tombstones   = newDesc("tombstones", "Approximate number of tombstones in the DB.")
+	rangeKeySets = newDesc("range_key_sets", "Approximate number of range key sets in the DB.")
+
+	rangeKeysWritten     = newDesc("range_keys_written_total", "Number of range keys written by committed batches, by kind.", "kind")
+	rangeKeyBlocksRead   = newDesc("range_key_blocks_read_total", "Number of range key blocks read by iterators.")
+	rangeKeyMaskedPoints = newDesc("range_key_masked_points_total", "Number of point keys skipped by range key masking in iterators.")
+
+	snapshots          = newDesc("snapshots", "Number of open snapshots.")
+	snapshotPinnedKeys = newDesc("snapshot_pinned_keys_total", "Number of keys written by flushes and compactions only because of open snapshots.")
+	snapshotPinnedSize = newDesc("snapshot_pinned_bytes_total", "Bytes of the keys and values written by flushes and compactions only because of open snapshots.")
+
+	tableObsoleteSize  = newDesc("table_obsolete_size_bytes", "Bytes of the obsolete sstables.")
+	tableObsoleteCount = newDesc("table_obsolete", "Number of obsolete sstables.")
+	tableZombieSize    = newDesc("table_zombie_size_bytes", "Bytes of the sstables no longer referenced by the DB but still used by iterators.")
+	tableZombieCount   = newDesc("table_zombies", "Number of sstables no longer referenced by the DB but still used by iterators.")
+	tableIters         = newDesc("table_iterators", "Number of open sstable iterators.")
+
+	cacheSize    = newDesc("cache_size_bytes", "Bytes used by the cache.", "cache")
+	cacheCount   = newDesc("cache_entries", "Number of entries in the cache.", "cache")
+	cacheHits    = newDesc("cache_hits_total", "Number of cache hits.", "cache")
+	cacheMisses  = newDesc("cache_misses_total", "Number of cache misses.", "cache")
+	filterHits   = newDesc("filter_hits_total", "Number of data block reads avoided by filters.")
+	filterMisses = newDesc("filter_misses_total", "Number of filter checks that didn't avoid data block reads.")
+
+	walFiles                = newDesc("wal_files", "Number of live WAL files.")
+	walSize                 = newDesc("wal_size_bytes", "Bytes of the live data in the WAL files.")
+	walPhysicalSize         = newDesc("wal_physical_size_bytes", "Bytes of the live WAL files on disk.")
+	walObsoleteFiles        = newDesc("wal_obsolete_files", "Number of obsolete WAL files.")
+	walObsoletePhysicalSize = newDesc("wal_obsolete_physical_size_bytes", "Bytes of the obsolete WAL files on disk.")
+	walBytesIn              = newDesc("wal_bytes_in_total", "Logical bytes written to the WAL.")
+	walBytesWritten         = newDesc("wal_bytes_written_total", "Physical bytes written to the WAL.")
+
+	commits                  = newDesc("commits_total", "Number of committed batches.")
+	commitDuration           = newDesc("commit_duration_seconds_total", "Cumulative duration of the commits.")
+	commitWriteStallDuration = newDesc("commit_write_stall_duration_seconds_total", "Cumulative duration of the commits stalled by write stalls.")
+	commitPendingSyncs       = newDesc("commit_pending_syncs", "Number of committed batches waiting for the WAL to be synced.")
+)
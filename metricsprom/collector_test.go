@@ -0,0 +1,95 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package metricsprom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	d, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+
+	c := NewCollector()
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+	c.Register("foo", d)
+	c.Register("bar", d)
+
+	problems, err := testutil.GatherAndLint(reg)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	// The metrics of each DB are labeled by its name.
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP pebble_flushes_total Number of flushes.
+# TYPE pebble_flushes_total counter
+pebble_flushes_total{db="bar"} 1
+pebble_flushes_total{db="foo"} 1
+# HELP pebble_level_files Number of sstables in the level.
+# TYPE pebble_level_files gauge
+pebble_level_files{db="bar",level="0"} 1
+pebble_level_files{db="bar",level="1"} 0
+pebble_level_files{db="bar",level="2"} 0
+pebble_level_files{db="bar",level="3"} 0
+pebble_level_files{db="bar",level="4"} 0
+pebble_level_files{db="bar",level="5"} 0
+pebble_level_files{db="bar",level="6"} 0
+pebble_level_files{db="foo",level="0"} 1
+pebble_level_files{db="foo",level="1"} 0
+pebble_level_files{db="foo",level="2"} 0
+pebble_level_files{db="foo",level="3"} 0
+pebble_level_files{db="foo",level="4"} 0
+pebble_level_files{db="foo",level="5"} 0
+pebble_level_files{db="foo",level="6"} 0
+`), "pebble_flushes_total", "pebble_level_files"))
+
+	c.Unregister("bar")
+	require.Equal(t, 1, testutil.CollectAndCount(c, "pebble_flushes_total"))
+}
+
+func TestCollectorCounterReset(t *testing.T) {
+	var m pebble.Metrics
+	c := NewCollector()
+	c.Register("foo", MetricsSourceFunc(func() *pebble.Metrics { return &m }))
+	flushes := func() string {
+		return `
+# HELP pebble_flushes_total Number of flushes.
+# TYPE pebble_flushes_total counter
+pebble_flushes_total{db="foo"} `
+	}
+
+	for _, tc := range []struct {
+		count    int64
+		expected string
+	}{
+		{count: 3, expected: "3"},
+		{count: 5, expected: "5"},
+		// The DB was reopened, so the counter increases by the new value.
+		{count: 2, expected: "7"},
+		{count: 4, expected: "9"},
+	} {
+		m.Flush.Count = tc.count
+		require.NoError(t, testutil.CollectAndCompare(c,
+			strings.NewReader(flushes()+tc.expected+"\n"), "pebble_flushes_total"))
+	}
+
+	// The counters of a DB replaced under the same name keep increasing.
+	var m2 pebble.Metrics
+	m2.Flush.Count = 1
+	c.Register("foo", MetricsSourceFunc(func() *pebble.Metrics { return &m2 }))
+	require.NoError(t, testutil.CollectAndCompare(c,
+		strings.NewReader(flushes()+"10\n"), "pebble_flushes_total"))
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sort"
+)
+
+// MultiGet gets the values for the given keys. The returned slice holds the
+// value of each key at the same index, or nil if the DB does not contain the
+// key. The values of keys with empty values are empty, non-nil slices. The
+// returned values are owned by the caller.
+//
+// The keys are looked up in a single pass over the LSM, in key order, through
+// a single iterator. The sstables, blocks and block cache handles loaded for a
+// key are reused for the following keys, avoiding much of the per-key
+// overhead of issuing a Get for each key. The keys need not be sorted, and may
+// contain duplicates. Bloom filters are consulted for each key if the Comparer
+// provides a Split function.
+//
+// Unlike Get, MultiGet is not subject to
+// Options.Experimental.MaxTablesPerGet.
+func (d *DB) MultiGet(keys [][]byte) ([][]byte, error) {
+	return d.multiGet(context.Background(), nil /* snapshot */, keys)
+}
+
+// MultiGet gets the values for the given keys, as of the snapshot. See
+// DB.MultiGet.
+func (s *Snapshot) MultiGet(keys [][]byte) ([][]byte, error) {
+	if s.closed() {
+		panic(ErrClosed)
+	}
+	return s.db.multiGet(context.Background(), s, keys)
+}
+
+func (d *DB) multiGet(ctx context.Context, s *Snapshot, keys [][]byte) ([][]byte, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return d.cmp(keys[order[a]], keys[order[b]]) < 0
+	})
+
+	// The values are copied into a single buffer, and sliced once all the
+	// keys have been looked up, as the buffer may be reallocated. The buffer
+	// is non-nil so that empty values are non-nil.
+	buf := []byte{}
+	start := make([]int, len(keys))
+	end := make([]int, len(keys))
+	found := make([]bool, len(keys))
+	iter := d.newIter(ctx, nil /* batch */, s, nil /* options */)
+	for _, i := range order {
+		var ok bool
+		if d.opts.Comparer.Split != nil {
+			ok = iter.SeekPrefixGE(keys[i])
+		} else {
+			ok = iter.SeekGE(keys[i])
+		}
+		if ok && d.equal(iter.Key(), keys[i]) {
+			value, err := iter.ValueAndErr()
+			if err != nil {
+				return nil, firstError(err, iter.Close())
+			}
+			start[i] = len(buf)
+			buf = append(buf, value...)
+			end[i] = len(buf)
+			found[i] = true
+		} else if err := iter.Error(); err != nil {
+			return nil, firstError(err, iter.Close())
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(keys))
+	for i := range values {
+		if found[i] {
+			values[i] = buf[start[i]:end[i]:end[i]]
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiGet(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 100; i += 2 {
+		k := fmt.Sprintf("k%03d", i)
+		require.NoError(t, d.Set([]byte(k), []byte("v-"+k), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("k010"), []byte("v2-k010"), nil))
+	require.NoError(t, d.Delete([]byte("k020"), nil))
+	require.NoError(t, d.Set([]byte("k021"), nil, nil))
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	require.NoError(t, d.Set([]byte("k030"), []byte("v2-k030"), nil))
+
+	// The keys are unsorted, and contain duplicates and missing keys.
+	keys := [][]byte{
+		[]byte("k030"), []byte("k010"), []byte("k001"), []byte("k020"),
+		[]byte("k021"), []byte("k098"), []byte("k010"), []byte("z"),
+	}
+	format := func(values [][]byte) []string {
+		var res []string
+		for _, v := range values {
+			if v == nil {
+				res = append(res, "<nil>")
+			} else {
+				res = append(res, fmt.Sprintf("%q", v))
+			}
+		}
+		return res
+	}
+
+	values, err := d.MultiGet(keys)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`"v2-k030"`, `"v2-k010"`, "<nil>", "<nil>", `""`, `"v-k098"`, `"v2-k010"`, "<nil>",
+	}, format(values))
+	for i, k := range keys {
+		v, closer, err := d.Get(k)
+		if values[i] == nil {
+			require.ErrorIs(t, err, ErrNotFound)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, string(v), string(values[i]))
+		require.NoError(t, closer.Close())
+	}
+
+	values, err = snap.MultiGet(keys)
+	require.NoError(t, err)
+	require.Equal(t, `"v-k030"`, format(values)[0])
+
+	values, err = d.MultiGet(nil)
+	require.NoError(t, err)
+	require.Empty(t, values)
+}
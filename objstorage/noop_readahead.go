@@ -32,3 +32,6 @@ func (*NoopReadHandle) SetupForCompaction() {}
 
 // RecordCacheHit is part of the ReadHandle interface.
 func (*NoopReadHandle) RecordCacheHit(_ context.Context, offset, size int64) {}
+
+// LastReadaheadSize is part of the ReadHandle interface.
+func (*NoopReadHandle) LastReadaheadSize() int64 { return 0 }
@@ -59,6 +59,11 @@ type ReadHandle interface {
 	// block from cache. This is useful for example when the implementation is
 	// trying to detect a sequential reading pattern.
 	RecordCacheHit(ctx context.Context, offset, size int64)
+
+	// LastReadaheadSize returns the size of the readahead issued by the last
+	// ReadAt call, or 0 if the call didn't read ahead. The implementation
+	// chooses the readahead size based on the observed reading pattern.
+	LastReadaheadSize() int64
 }
 
 // Writable is the handle for an object that is open for writing.
@@ -199,6 +204,10 @@ type Provider interface {
 	// Does not perform any I/O.
 	Lookup(fileType base.FileType, FileNum base.DiskFileNum) (ObjectMetadata, error)
 
+	// IsForeign returns true if the object is on shared storage and was created
+	// by a different DB instance.
+	IsForeign(meta ObjectMetadata) bool
+
 	// Path returns an internal, implementation-dependent path for the object. It is
 	// meant to be used for informational purposes (like logging).
 	Path(meta ObjectMetadata) string
@@ -216,12 +225,20 @@ type Provider interface {
 	// Cannot be called if shared storage is not configured for the provider.
 	SetCreatorID(creatorID CreatorID) error
 
+	// SetBytesPerSync changes the number of bytes written to local objects
+	// between background syncs, for objects created after the call. Zero
+	// disables the periodic syncing.
+	SetBytesPerSync(bytesPerSync int)
+
 	// SharedObjectBacking encodes the shared object metadata.
 	SharedObjectBacking(meta *ObjectMetadata) (SharedObjectBackingHandle, error)
 
 	// AttachSharedObjects registers existing shared objects with this provider.
 	AttachSharedObjects(objs []SharedObjectToAttach) ([]ObjectMetadata, error)
 
+	// Metrics returns metrics about the provider.
+	Metrics() Metrics
+
 	Close() error
 
 	// IsNotExistError indicates whether the error is known to report that a file or
@@ -229,6 +246,34 @@ type Provider interface {
 	IsNotExistError(err error) bool
 }
 
+// Metrics holds metrics about a Provider.
+type Metrics struct {
+	// SecondaryCache holds the metrics of the cache of the objects on shared
+	// storage, if the provider is configured with one.
+	SecondaryCache SecondaryCacheMetrics
+}
+
+// SecondaryCacheMetrics holds metrics for the persistent cache, on the local
+// filesystem, of the blocks of objects on shared storage.
+type SecondaryCacheMetrics struct {
+	// Size is the number of bytes of the cached blocks, and Count the number
+	// of cached blocks.
+	Size  int64
+	Count int64
+	// Hits is the number of reads served entirely by the cache, and Misses the
+	// number of reads which read from shared storage.
+	Hits   int64
+	Misses int64
+	// Evictions is the number of blocks evicted to make room for others.
+	Evictions int64
+	// WriteBackFailures is the number of failed writes to the cache of data
+	// read from shared storage.
+	WriteBackFailures int64
+	// CorruptBlocks is the number of blocks discarded because their checksum
+	// didn't match, such as blocks being written when the process crashed.
+	CorruptBlocks int64
+}
+
 // SharedObjectBacking encodes the metadata necessary to incorporate a shared
 // object into a different Pebble instance. The encoding is specific to a given
 // Provider implementation.
@@ -231,6 +231,11 @@ func (rh *readHandle) RecordCacheHit(ctx context.Context, offset, size int64) {
 	rh.rh.RecordCacheHit(ctx, offset, size)
 }
 
+// LastReadaheadSize is part of the objstorage.ReadHandle interface.
+func (rh *readHandle) LastReadaheadSize() int64 {
+	return rh.rh.LastReadaheadSize()
+}
+
 type ctxInfo struct {
 	reason       Reason
 	blockType    BlockType
@@ -10,6 +10,7 @@ import (
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
@@ -26,6 +27,10 @@ import (
 type provider struct {
 	st Settings
 
+	// bytesPerSync is initially Settings.BytesPerSync, and can be changed with
+	// SetBytesPerSync.
+	bytesPerSync atomic.Int64
+
 	fsDir vfs.File
 
 	tracer *objiotracing.Tracer
@@ -106,8 +111,22 @@ type Settings struct {
 		// 2*runtime.GOMAXPROCS is used as the shard count.
 		CacheShardCount int
 
-		// TODO(radu): allow the cache to live on another FS/location (e.g. to use
-		// instance-local SSD).
+		// CacheDirName is the directory of the cache, such as a directory on an
+		// instance-local SSD. If empty, the cache is stored in FSDirName. The
+		// cache persists across restarts, so the directory must not be shared
+		// with other caches.
+		CacheDirName string
+
+		// ExternalCache is an optional cache tier, such as a memcached or redis
+		// deployment, consulted when reading shared objects before reading from
+		// Storage. It may be shared with other Pebble instances reading the same
+		// objects.
+		ExternalCache shared.Cache
+
+		// ExternalCacheBlockSize is the size of the blocks of objects stored in
+		// ExternalCache; if 0, the default of 32KB is used. Instances sharing an
+		// external cache should use the same block size.
+		ExternalCacheBlockSize int
 	}
 }
 
@@ -145,6 +164,7 @@ func open(settings Settings) (p *provider, _ error) {
 		st:    settings,
 		fsDir: fsDir,
 	}
+	p.bytesPerSync.Store(int64(settings.BytesPerSync))
 	p.mu.knownObjects = make(map[base.DiskFileNum]objstorage.ObjectMetadata)
 	p.mu.protectedObjects = make(map[base.DiskFileNum]int)
 
@@ -172,6 +192,10 @@ func (p *provider) Close() error {
 		err = p.fsDir.Close()
 		p.fsDir = nil
 	}
+	if p.shared.cache != nil {
+		err = firstError(err, p.shared.cache.Close())
+		p.shared.cache = nil
+	}
 	if objiotracing.Enabled {
 		if p.tracer != nil {
 			p.tracer.Close()
@@ -181,6 +205,15 @@ func (p *provider) Close() error {
 	return err
 }
 
+// Metrics is part of the objstorage.Provider interface.
+func (p *provider) Metrics() objstorage.Metrics {
+	var m objstorage.Metrics
+	if p.shared.cache != nil {
+		m.SecondaryCache = p.shared.cache.Metrics()
+	}
+	return m
+}
+
 // OpenForReading opens an existing object.
 func (p *provider) OpenForReading(
 	ctx context.Context,
@@ -309,7 +342,7 @@ func (p *provider) LinkOrCopyFromLocal(
 		// vfs.NewSyncingFile.
 		fs := vfs.NewSyncingFS(p.st.FS, vfs.SyncingFileOptions{
 			NoSyncOnClose: p.st.NoSyncOnClose,
-			BytesPerSync:  p.st.BytesPerSync,
+			BytesPerSync:  int(p.bytesPerSync.Load()),
 		})
 		dstPath := p.vfsPath(dstFileType, dstFileNum)
 		if err := vfs.LinkOrCopy(fs, srcFilePath, dstPath); err != nil {
@@ -358,6 +391,11 @@ func (p *provider) LinkOrCopyFromLocal(
 	return meta, nil
 }
 
+// SetBytesPerSync is part of the objstorage.Provider interface.
+func (p *provider) SetBytesPerSync(bytesPerSync int) {
+	p.bytesPerSync.Store(int64(bytesPerSync))
+}
+
 // Lookup is part of the objstorage.Provider interface.
 func (p *provider) Lookup(
 	fileType base.FileType, fileNum base.DiskFileNum,
@@ -38,6 +38,22 @@ func makeReadaheadState() readaheadState {
 	}
 }
 
+// reset resets the state on a read that doesn't follow the previous reads
+// sequentially, ending at currentReadEnd. No readahead is issued until
+// sequential reads are observed again, so that point-seek patterns don't
+// read ahead at all. The readahead size is ramped down rather than reset to
+// initialReadaheadSize, so that reads alternating between scans and
+// occasional seeks don't ramp up the readahead size from scratch.
+func (rs *readaheadState) reset(currentReadEnd int64) {
+	rs.numReads = 1
+	rs.limit = currentReadEnd
+	rs.size /= 2
+	if rs.size < initialReadaheadSize {
+		rs.size = initialReadaheadSize
+	}
+	rs.prevSize = 0
+}
+
 func (rs *readaheadState) recordCacheHit(offset, blockLength int64) {
 	currentReadEnd := offset + blockLength
 	if rs.numReads >= minFileReadsForReadahead {
@@ -49,11 +65,8 @@ func (rs *readaheadState) recordCacheHit(offset, blockLength int64) {
 		}
 		if currentReadEnd < rs.limit-rs.prevSize || offset > rs.limit+maxReadaheadSize {
 			// We read too far away from rs.limit to benefit from readahead in
-			// any scenario. Reset all variables.
-			rs.numReads = 1
-			rs.limit = currentReadEnd
-			rs.size = initialReadaheadSize
-			rs.prevSize = 0
+			// any scenario. Reset the readahead state.
+			rs.reset(currentReadEnd)
 			return
 		}
 		// Reads in the range [rs.limit - rs.prevSize, rs.limit] end up
@@ -68,11 +81,8 @@ func (rs *readaheadState) recordCacheHit(offset, blockLength int64) {
 		return
 	}
 	// We read too far ahead of the last read, or before it. This indicates
-	// a random read, where readahead is not desirable. Reset all variables.
-	rs.numReads = 1
-	rs.limit = currentReadEnd
-	rs.size = initialReadaheadSize
-	rs.prevSize = 0
+	// a random read, where readahead is not desirable. Reset the readahead state.
+	rs.reset(currentReadEnd)
 }
 
 // maybeReadahead updates state and determines whether to issue a readahead /
@@ -131,7 +141,7 @@ func (rs *readaheadState) maybeReadahead(offset, blockLength int64) int64 {
 			// The above conditional has rs.limit > rs.prevSize to confirm that
 			// rs.limit - rs.prevSize would not underflow.
 			// We read too far away from rs.limit to benefit from readahead in
-			// any scenario. Reset all variables.
+			// any scenario. Reset the readahead state.
 			// The case where we read too far ahead:
 			//
 			// (rs.limit - rs.prevSize)    (rs.limit)   (rs.limit + maxReadaheadSize)
@@ -148,10 +158,7 @@ func (rs *readaheadState) maybeReadahead(offset, blockLength int64) int64 {
 			//    |-------------|
 			// offset       currentReadEnd
 			//
-			rs.numReads = 1
-			rs.limit = currentReadEnd
-			rs.size = initialReadaheadSize
-			rs.prevSize = 0
+			rs.reset(currentReadEnd)
 
 			return 0
 		}
@@ -184,7 +191,7 @@ func (rs *readaheadState) maybeReadahead(offset, blockLength int64) int64 {
 		return 0
 	}
 	// We read too far ahead of the last read, or before it. This indicates
-	// a random read, where readahead is not desirable. Reset all variables.
+	// a random read, where readahead is not desirable. Reset the readahead state.
 	//
 	// (rs.limit - maxReadaheadSize)  (rs.limit)   (rs.limit + maxReadaheadSize)
 	//                     |+++++++++++++|=============|
@@ -192,9 +199,6 @@ func (rs *readaheadState) maybeReadahead(offset, blockLength int64) int64 {
 	//                                                    |-------|
 	//                                                offset    currentReadEnd
 	//
-	rs.numReads = 1
-	rs.limit = currentReadEnd
-	rs.size = initialReadaheadSize
-	rs.prevSize = 0
+	rs.reset(currentReadEnd)
 	return 0
 }
@@ -5,12 +5,15 @@
 package objstorageprovider
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
 
@@ -57,3 +60,51 @@ func TestMaybeReadahead(t *testing.T) {
 		}
 	})
 }
+
+func TestReadHandleAdaptiveReadahead(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	f, err := fs.Create("test")
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 4<<20))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	f, err = fs.Open("test")
+	require.NoError(t, err)
+	r, err := newFileReadable(f, fs, "test")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	for _, prealloc := range []bool{false, true} {
+		var rh objstorage.ReadHandle
+		if prealloc {
+			rh = UsePreallocatedReadHandle(ctx, r, &PreallocatedReadHandle{})
+		} else {
+			rh = r.NewReadHandle(ctx)
+		}
+		buf := make([]byte, 4<<10)
+		read := func(offset int64) int64 {
+			require.NoError(t, rh.ReadAt(ctx, buf, offset))
+			return rh.LastReadaheadSize()
+		}
+
+		// Sequential reads ramp up the readahead size, until OS-level readahead
+		// is used.
+		var sizes []int64
+		offset := int64(0)
+		for !TestingCheckMaxReadahead(rh) {
+			if size := read(offset); size > 0 {
+				sizes = append(sizes, size)
+			}
+			offset += int64(len(buf))
+		}
+		require.Equal(t, []int64{initialReadaheadSize, 2 * initialReadaheadSize, maxReadaheadSize}, sizes)
+
+		// Random reads stop the use of OS-level readahead, and don't read ahead.
+		for _, offset := range []int64{3 << 20, 1 << 20, 2 << 20} {
+			require.Zero(t, read(offset))
+			require.False(t, TestingCheckMaxReadahead(rh))
+		}
+		require.NoError(t, rh.Close())
+	}
+}
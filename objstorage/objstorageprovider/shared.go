@@ -42,6 +42,14 @@ func (ss *sharedSubsystem) init(creatorID objstorage.CreatorID) {
 	})
 }
 
+// IsForeign is part of the objstorage.Provider interface.
+func (p *provider) IsForeign(meta objstorage.ObjectMetadata) bool {
+	if !meta.IsShared() {
+		return false
+	}
+	return !p.shared.initialized.Load() || meta.Shared.CreatorID != p.shared.creatorID
+}
+
 func (p *provider) sharedStorage() shared.Storage {
 	return p.st.Shared.Storage
 }
@@ -79,7 +87,12 @@ func (p *provider) sharedInit() error {
 			numShards = 2 * runtime.GOMAXPROCS(0)
 		}
 
-		p.shared.cache, err = openSharedCache(p.st.FS, p.st.FSDirName, blockSize, p.st.Shared.CacheSizeBytes, numShards)
+		dirName := p.st.Shared.CacheDirName
+		if dirName == "" {
+			dirName = p.st.FSDirName
+		}
+
+		p.shared.cache, err = openSharedCache(p.st.Logger, p.st.FS, dirName, blockSize, p.st.Shared.CacheSizeBytes, numShards)
 		if err != nil {
 			return errors.Wrapf(err, "pebble: could not open shared object cache")
 		}
@@ -231,6 +244,24 @@ func (p *provider) sharedOpenForReading(
 		}
 		return nil, err
 	}
+	if p.st.Shared.ExternalCache != nil {
+		blockSize := p.st.Shared.ExternalCacheBlockSize
+		if blockSize == 0 {
+			blockSize = defaultExternalCacheBlockSize
+		}
+		reader = newExternalCacheReader(p.st.Shared.ExternalCache, p.st.Logger, objName, reader, size, blockSize)
+	}
+	if p.shared.cache != nil {
+		// The secondary cache is consulted before the external cache, which it
+		// layers over.
+		reader = &sharedCacheReader{
+			cache:     p.shared.cache,
+			creatorID: meta.Shared.CreatorID,
+			fileNum:   meta.Shared.CreatorFileNum.FileNum(),
+			objReader: reader,
+			size:      size,
+		}
+	}
 	return newSharedReadable(reader, size), nil
 }
 
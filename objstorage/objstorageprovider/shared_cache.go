@@ -6,6 +6,7 @@ package objstorageprovider
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"sync"
@@ -13,33 +14,55 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/shared"
 	"github.com/cockroachdb/pebble/vfs"
 )
 
+// sharedCache is a persistent cache, on the local filesystem, of the blocks of
+// objects on shared storage. It is consulted on block cache misses before
+// reading from shared storage.
+//
+// Each shard is a file holding a fixed number of cache blocks, followed by a
+// metadata record per cache block which identifies the object block it holds
+// along with its checksum. The cache survives restarts, as the records are
+// loaded when the cache is opened. It's also crash-safe without syncing: a
+// block and its record may not both be written before a crash, and a block
+// is only read from the cache if its checksum matches its record.
 type sharedCache struct {
 	shards []sharedCacheShard
 	logger base.Logger
 
-	// TODO(josh): Have a dedicated metrics struct. Right now, this
-	// is just for testing.
-	misses atomic.Int32
+	metrics struct {
+		hits              atomic.Int64
+		misses            atomic.Int64
+		evictions         atomic.Int64
+		writeBackFailures atomic.Int64
+		corruptBlocks     atomic.Int64
+	}
 }
 
 func openSharedCache(
-	fs vfs.FS, fsDir string, blockSize int, sizeBytes int64, numShards int,
+	logger base.Logger, fs vfs.FS, fsDir string, blockSize int, sizeBytes int64, numShards int,
 ) (*sharedCache, error) {
 	min := shardingBlockSize * int64(numShards)
 	if sizeBytes < min {
 		return nil, errors.Errorf("cache size %d lower than min %d", sizeBytes, min)
 	}
+	if err := fs.MkdirAll(fsDir, 0755); err != nil {
+		return nil, err
+	}
 
-	sc := &sharedCache{}
+	sc := &sharedCache{logger: logger}
 	sc.shards = make([]sharedCacheShard, numShards)
 	blocksPerShard := sizeBytes / int64(numShards) / int64(blockSize)
 	for i := range sc.shards {
-		if err := sc.shards[i].init(fs, fsDir, i, blocksPerShard, blockSize); err != nil {
+		if err := sc.shards[i].init(sc, fs, fsDir, i, blocksPerShard, blockSize); err != nil {
+			for j := 0; j < i; j++ {
+				_ = sc.shards[j].Close()
+			}
 			return nil, err
 		}
 	}
@@ -57,41 +80,76 @@ func (sc *sharedCache) Close() error {
 	return retErr
 }
 
+// Metrics returns the metrics of the cache.
+func (sc *sharedCache) Metrics() objstorage.SecondaryCacheMetrics {
+	m := objstorage.SecondaryCacheMetrics{
+		Hits:              sc.metrics.hits.Load(),
+		Misses:            sc.metrics.misses.Load(),
+		Evictions:         sc.metrics.evictions.Load(),
+		WriteBackFailures: sc.metrics.writeBackFailures.Load(),
+		CorruptBlocks:     sc.metrics.corruptBlocks.Load(),
+	}
+	for i := range sc.shards {
+		s := &sc.shards[i]
+		s.mu.Lock()
+		m.Count += int64(len(s.mu.where))
+		m.Size += s.mu.size
+		s.mu.Unlock()
+	}
+	return m
+}
+
 // ReadAt performs a read form an object, attempting to use cached data when
-// possible.
+// possible. The object is identified by the creator ID and file number it was
+// created with, which are unique across the DBs sharing the storage. It is of
+// size objSize, and is read through objReader on cache misses.
 func (sc *sharedCache) ReadAt(
-	ctx context.Context, fileNum base.FileNum, p []byte, ofs int64, readable objstorage.Readable,
+	ctx context.Context,
+	creatorID objstorage.CreatorID,
+	fileNum base.FileNum,
+	p []byte,
+	ofs int64,
+	objReader shared.ObjectReader,
+	objSize int64,
 ) error {
-	n, err := sc.Get(fileNum, p, ofs)
+	if ofs < 0 || ofs+int64(len(p)) > objSize {
+		// Let the object reader reject the read.
+		return objReader.ReadAt(ctx, p, ofs)
+	}
+	n, err := sc.Get(creatorID, fileNum, p, ofs)
 	if err != nil {
 		return err
 	}
 	if n == len(p) {
 		// Everything was in cache!
+		sc.metrics.hits.Add(1)
 		return nil
 	}
 
 	// We must do reads with offset & size that are multiples of the block size. Else
 	// later cache hits may return incorrect zeroed results from the cache. We assume
-	// that all shards have the same block size.
-	blockSize := sc.shards[0].blockSize
-	adjustedOfs := ((ofs + int64(n)) / int64(blockSize)) * int64(blockSize)
-	adjustedP := make([]byte, ((len(p[n:])+(blockSize-1))/blockSize)*blockSize+int(ofs-adjustedOfs))
+	// that all shards have the same block size. The last block of the object is
+	// shorter than the block size.
+	blockSize := int64(sc.shards[0].blockSize)
+	start := ofs + int64(n)
+	adjustedOfs := (start / blockSize) * blockSize
+	adjustedEnd := ((ofs + int64(len(p)) + blockSize - 1) / blockSize) * blockSize
+	if adjustedEnd > objSize {
+		adjustedEnd = objSize
+	}
+	adjustedP := make([]byte, adjustedEnd-adjustedOfs)
 
 	// Read the rest from the object.
-	sc.misses.Add(1)
-	// TODO(josh): To have proper EOF handling, we will need readable.ReadAt to return
-	// the number of bytes read successfully. As is, we cannot tell if the readable.ReadAt
-	// should be returned from sharedCache.ReadAt. For now, the cache just swallows all
-	// io.EOF errors.
-	if err := readable.ReadAt(ctx, adjustedP, adjustedOfs); err != nil && err != io.EOF {
+	sc.metrics.misses.Add(1)
+	if err := objReader.ReadAt(ctx, adjustedP, adjustedOfs); err != nil {
 		return err
 	}
-	copy(p[n:], adjustedP[ofs%int64(blockSize):])
+	copy(p[n:], adjustedP[start-adjustedOfs:])
 
 	// TODO(josh): Writing back to the cache should be async with respect to the
 	// call to ReadAt.
-	if err := sc.Set(fileNum, adjustedP, adjustedOfs); err != nil {
+	if err := sc.Set(creatorID, fileNum, adjustedP, adjustedOfs); err != nil {
+		sc.metrics.writeBackFailures.Add(1)
 		// TODO(josh): Would like to log at error severity, but base.Logger doesn't
 		// have error severity.
 		sc.logger.Infof("writing back to cache after miss failed: %v", err)
@@ -105,17 +163,19 @@ func (sc *sharedCache) ReadAt(
 //
 // If data is partially available, a prefix of the data is read; returns n < len(p)
 // and no error. If no prefix is available, returns n = 0 and no error.
-func (sc *sharedCache) Get(fileNum base.FileNum, p []byte, ofs int64) (n int, _ error) {
+func (sc *sharedCache) Get(
+	creatorID objstorage.CreatorID, fileNum base.FileNum, p []byte, ofs int64,
+) (n int, _ error) {
 	// The data extent might cross shard boundaries, hence the loop. In the hot
 	// path, max two iterations of this loop will be executed, since reads are sized
 	// in units of sstable block size.
 	for {
-		shard := sc.getShard(fileNum, ofs+int64(n))
+		shard := sc.getShard(creatorID, fileNum, ofs+int64(n))
 		cappedLen := len(p[n:])
-		if toBoundary := int(shardingBlockSize - (ofs % shardingBlockSize)); cappedLen > toBoundary {
+		if toBoundary := int(shardingBlockSize - ((ofs + int64(n)) % shardingBlockSize)); cappedLen > toBoundary {
 			cappedLen = toBoundary
 		}
-		numRead, err := shard.Get(fileNum, p[n:n+cappedLen], ofs+int64(n))
+		numRead, err := shard.Get(creatorID, fileNum, p[n:n+cappedLen], ofs+int64(n))
 		if err != nil {
 			return n, err
 		}
@@ -135,18 +195,20 @@ func (sc *sharedCache) Get(fileNum base.FileNum, p []byte, ofs int64) (n int, _
 // Set attempts to write the requested data to the cache.
 //
 // If all of p is not written to the shard, Set returns a non-nil error.
-func (sc *sharedCache) Set(fileNum base.FileNum, p []byte, ofs int64) error {
+func (sc *sharedCache) Set(
+	creatorID objstorage.CreatorID, fileNum base.FileNum, p []byte, ofs int64,
+) error {
 	// The data extent might cross shard boundaries, hence the loop. In the hot
 	// path, max two iterations of this loop will be executed, since reads are sized
 	// in units of sstable block size.
 	n := 0
 	for {
-		shard := sc.getShard(fileNum, ofs+int64(n))
+		shard := sc.getShard(creatorID, fileNum, ofs+int64(n))
 		cappedLen := len(p[n:])
-		if toBoundary := int(shardingBlockSize - (ofs % shardingBlockSize)); cappedLen > toBoundary {
+		if toBoundary := int(shardingBlockSize - ((ofs + int64(n)) % shardingBlockSize)); cappedLen > toBoundary {
 			cappedLen = toBoundary
 		}
-		err := shard.Set(fileNum, p[n:n+cappedLen], ofs+int64(n))
+		err := shard.Set(creatorID, fileNum, p[n:n+cappedLen], ofs+int64(n))
 		if err != nil {
 			return err
 		}
@@ -162,9 +224,11 @@ func (sc *sharedCache) Set(fileNum base.FileNum, p []byte, ofs int64) error {
 
 const shardingBlockSize = 1024 * 1024
 
-func (sc *sharedCache) getShard(fileNum base.FileNum, ofs int64) *sharedCacheShard {
+func (sc *sharedCache) getShard(
+	creatorID objstorage.CreatorID, fileNum base.FileNum, ofs int64,
+) *sharedCacheShard {
 	const prime64 = 1099511628211
-	hash := uint64(fileNum)*prime64 + uint64(ofs)/shardingBlockSize
+	hash := (uint64(creatorID)*prime64+uint64(fileNum))*prime64 + uint64(ofs)/shardingBlockSize
 	// TODO(josh): Instance change ops are often run in production. Such an operation
 	// updates len(sc.shards); see openSharedCache. As a result, the behavior of this
 	// function changes, and the cache empties out at restart time. We may want a better
@@ -172,7 +236,30 @@ func (sc *sharedCache) getShard(fileNum base.FileNum, ofs int64) *sharedCacheSha
 	return &sc.shards[hash%uint64(len(sc.shards))]
 }
 
+// sharedCacheReader is a shared.ObjectReader which reads an object through
+// the sharedCache.
+type sharedCacheReader struct {
+	cache     *sharedCache
+	creatorID objstorage.CreatorID
+	fileNum   base.FileNum
+	objReader shared.ObjectReader
+	size      int64
+}
+
+var _ shared.ObjectReader = (*sharedCacheReader)(nil)
+
+// ReadAt is part of the shared.ObjectReader interface.
+func (r *sharedCacheReader) ReadAt(ctx context.Context, p []byte, offset int64) error {
+	return r.cache.ReadAt(ctx, r.creatorID, r.fileNum, p, offset, r.objReader, r.size)
+}
+
+// Close is part of the shared.ObjectReader interface.
+func (r *sharedCacheReader) Close() error {
+	return r.objReader.Close()
+}
+
 type sharedCacheShard struct {
+	cache        *sharedCache
 	file         vfs.File
 	sizeInBlocks int64
 	blockSize    int
@@ -182,18 +269,72 @@ type sharedCacheShard struct {
 		// Focusing on correctness to start.
 		where map[metadataKey]int64
 		free  []int64
+		// blocks holds the metadata of each cache block.
+		blocks []cacheBlockMeta
+		// size is the total length of the cached blocks.
+		size int64
 	}
 }
 
 type metadataKey struct {
+	creatorID  objstorage.CreatorID
 	filenum    base.FileNum
 	blockIndex int64
 }
 
+// cacheBlockMeta is the metadata of a cache block, which is persisted in the
+// block's record.
+type cacheBlockMeta struct {
+	key metadataKey
+	// length is the length of the cached data, which is shorter than the block
+	// size for the last block of an object. It's zero if the block is free.
+	length int64
+	// checksum is the checksum of the cached data.
+	checksum uint32
+}
+
+// cacheBlockRecordSize is the size of the record of a cache block, which holds
+// the creator ID (8 bytes), file number (8 bytes) and block index (8 bytes)
+// of the object block, the length (4 bytes) and checksum (4 bytes) of the
+// cached data, the block size of the cache (4 bytes), and the checksum of the
+// preceding fields (4 bytes).
+const cacheBlockRecordSize = 40
+
+func (m *cacheBlockMeta) encode(buf []byte, blockSize int) {
+	binary.LittleEndian.PutUint64(buf[0:], uint64(m.key.creatorID))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(m.key.filenum))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(m.key.blockIndex))
+	binary.LittleEndian.PutUint32(buf[24:], uint32(m.length))
+	binary.LittleEndian.PutUint32(buf[28:], m.checksum)
+	binary.LittleEndian.PutUint32(buf[32:], uint32(blockSize))
+	binary.LittleEndian.PutUint32(buf[36:], crc.New(buf[:36]).Value())
+}
+
+// decode decodes a record, returning false if it's invalid or was written by
+// a cache with a different block size. Records that were never written are
+// invalid.
+func (m *cacheBlockMeta) decode(buf []byte, blockSize int) bool {
+	if binary.LittleEndian.Uint32(buf[36:]) != crc.New(buf[:36]).Value() ||
+		binary.LittleEndian.Uint32(buf[32:]) != uint32(blockSize) {
+		return false
+	}
+	*m = cacheBlockMeta{
+		key: metadataKey{
+			creatorID:  objstorage.CreatorID(binary.LittleEndian.Uint64(buf[0:])),
+			filenum:    base.FileNum(binary.LittleEndian.Uint64(buf[8:])),
+			blockIndex: int64(binary.LittleEndian.Uint64(buf[16:])),
+		},
+		length:   int64(binary.LittleEndian.Uint32(buf[24:])),
+		checksum: binary.LittleEndian.Uint32(buf[28:]),
+	}
+	return m.length > 0 && m.length <= int64(blockSize)
+}
+
 func (s *sharedCacheShard) init(
-	fs vfs.FS, fsDir string, shardIdx int, sizeInBlocks int64, blockSize int,
+	cache *sharedCache, fs vfs.FS, fsDir string, shardIdx int, sizeInBlocks int64, blockSize int,
 ) error {
 	*s = sharedCacheShard{
+		cache:        cache,
 		sizeInBlocks: sizeInBlocks,
 	}
 	if blockSize < 1024 || shardingBlockSize%blockSize != 0 {
@@ -206,27 +347,51 @@ func (s *sharedCacheShard) init(
 	}
 	// TODO(radu): truncate file if necessary (especially important if we restart
 	// with more shards).
-	if err := file.Preallocate(0, int64(blockSize)*sizeInBlocks); err != nil {
+	if err := file.Preallocate(0, s.recordOffset(sizeInBlocks)); err != nil {
+		_ = file.Close()
 		return err
 	}
 	s.file = file
 
-	// TODO(josh): Right now, the secondary cache is not persistent. All existing
-	// cache contents will be over-written, since all metadata is only stored in
-	// memory.
+	// Load the records of the blocks cached before the cache was last closed.
+	// The records that were never written, or were written with a different
+	// geometry, are invalid and their blocks are free.
+	records := make([]byte, sizeInBlocks*cacheBlockRecordSize)
+	if _, err := file.ReadAt(records, s.recordOffset(0)); err != nil && err != io.EOF {
+		_ = file.Close()
+		return err
+	}
 	s.mu.where = make(map[metadataKey]int64)
-	for i := int64(0); i < sizeInBlocks; i++ {
+	s.mu.blocks = make([]cacheBlockMeta, sizeInBlocks)
+	for i := sizeInBlocks - 1; i >= 0; i-- {
+		m := &s.mu.blocks[i]
+		if m.decode(records[i*cacheBlockRecordSize:], blockSize) {
+			if _, ok := s.mu.where[m.key]; !ok {
+				s.mu.where[m.key] = i
+				s.mu.size += m.length
+				continue
+			}
+		}
+		*m = cacheBlockMeta{}
 		s.mu.free = append(s.mu.free, i)
 	}
-
 	return nil
 }
 
+// recordOffset returns the offset of the record of the given cache block,
+// which follows the cache blocks in the file.
+func (s *sharedCacheShard) recordOffset(cacheBlockInd int64) int64 {
+	return s.sizeInBlocks*int64(s.blockSize) + cacheBlockInd*cacheBlockRecordSize
+}
+
 func (s *sharedCacheShard) Close() error {
 	defer func() {
 		s.file = nil
 	}()
-	return s.file.Close()
+	// The cache is crash-safe without syncing, but syncing on close avoids
+	// losing its contents on a machine crash following a clean shutdown.
+	err := s.file.Sync()
+	return errors.CombineErrors(err, s.file.Close())
 }
 
 // Get attempts to read the requested data from the shard. The data must not
@@ -236,7 +401,9 @@ func (s *sharedCacheShard) Close() error {
 //
 // If data is partially available, a prefix of the data is read; returns n < len(p)
 // and no error. If no prefix is available, returns n = 0 and no error.
-func (s *sharedCacheShard) Get(fileNum base.FileNum, p []byte, ofs int64) (n int, _ error) {
+func (s *sharedCacheShard) Get(
+	creatorID objstorage.CreatorID, fileNum base.FileNum, p []byte, ofs int64,
+) (n int, _ error) {
 	if invariants.Enabled {
 		if ofs/shardingBlockSize != (ofs+int64(len(p))-1)/shardingBlockSize {
 			panic("Get crosses shard boundary")
@@ -251,50 +418,60 @@ func (s *sharedCacheShard) Get(fileNum base.FileNum, p []byte, ofs int64) (n int
 	// The data extent might cross cache block boundaries, hence the loop. In the hot
 	// path, max two iterations of this loop will be executed, since reads are sized
 	// in units of sstable block size.
-	for {
-		cacheBlockInd, ok := s.mu.where[metadataKey{
+	var buf []byte
+	for n < len(p) {
+		k := metadataKey{
+			creatorID:  creatorID,
 			filenum:    fileNum,
 			blockIndex: (ofs + int64(n)) / int64(s.blockSize),
-		}]
+		}
+		cacheBlockInd, ok := s.mu.where[k]
 		if !ok {
 			return n, nil
 		}
-
-		readAt := cacheBlockInd * int64(s.blockSize)
-		if n == 0 { // if first read
-			readAt += ofs % int64(s.blockSize)
-		}
-		readSize := s.blockSize
-		if n == 0 { // if first read
-			// Cast to int safe since ofs is modded by block size.
-			readSize -= int(ofs % int64(s.blockSize))
+		m := &s.mu.blocks[cacheBlockInd]
+		blockOfs := (ofs + int64(n)) % int64(s.blockSize)
+		if blockOfs >= m.length {
+			// The block holds the end of the object.
+			return n, nil
 		}
 
-		if len(p[n:]) <= readSize {
-			numRead, err := s.file.ReadAt(p[n:], readAt)
-			return n + numRead, err
+		// The whole block is read to verify its checksum, which may not match
+		// if the block was being written when the process crashed.
+		if int64(cap(buf)) < m.length {
+			buf = make([]byte, s.blockSize)
 		}
-		numRead, err := s.file.ReadAt(p[n:n+readSize], readAt)
-		if err != nil {
-			return 0, err
+		buf = buf[:m.length]
+		if _, err := s.file.ReadAt(buf, cacheBlockInd*int64(s.blockSize)); err != nil && err != io.EOF {
+			return n, err
 		}
-
-		// Note that numRead == readSize, since we checked for an error above.
-		n += numRead
+		if crc.New(buf).Value() != m.checksum {
+			s.cache.metrics.corruptBlocks.Add(1)
+			s.cache.logger.Infof("discarding corrupt cache block %d of %s", k.blockIndex, k.filenum)
+			s.removeLocked(cacheBlockInd)
+			return n, nil
+		}
+		n += copy(p[n:], buf[blockOfs:])
 	}
+	return n, nil
 }
 
 // Set attempts to write the requested data to the shard. The data must not
-// cross a shard boundary, and both ofs & len(p) must be multiples of the
-// block size.
+// cross a shard boundary, and ofs must be a multiple of the block size. The
+// length of p must be a multiple of the block size, unless p ends at the end
+// of the object.
 //
 // If all of p is not written to the shard, Set returns a non-nil error.
-func (s *sharedCacheShard) Set(fileNum base.FileNum, p []byte, ofs int64) error {
+func (s *sharedCacheShard) Set(
+	creatorID objstorage.CreatorID, fileNum base.FileNum, p []byte, ofs int64,
+) error {
 	if invariants.Enabled {
 		if ofs/shardingBlockSize != (ofs+int64(len(p))-1)/shardingBlockSize {
 			panic("Set crosses shard boundary")
 		}
-		// TODO(josh): Assert that ofs & len(p) are multiples of the block size.
+		if ofs%int64(s.blockSize) != 0 {
+			panic("Set offset isn't a multiple of the block size")
+		}
 	}
 
 	// TODO(josh): Make the locking more fine-grained. Do not hold locks during calls
@@ -305,44 +482,64 @@ func (s *sharedCacheShard) Set(fileNum base.FileNum, p []byte, ofs int64) error
 	// The data extent might cross cache block boundaries, hence the loop. In the hot
 	// path, max two iterations of this loop will be executed, since reads are sized
 	// in units of sstable block size.
-	n := 0
-	for {
-		var cacheBlockInd int64
-		if len(s.mu.free) == 0 {
+	var record [cacheBlockRecordSize]byte
+	for n := 0; n < len(p); n += s.blockSize {
+		data := p[n:]
+		if len(data) > s.blockSize {
+			data = data[:s.blockSize]
+		}
+		k := metadataKey{
+			creatorID:  creatorID,
+			filenum:    fileNum,
+			blockIndex: (ofs + int64(n)) / int64(s.blockSize),
+		}
+		cacheBlockInd, ok := s.mu.where[k]
+		switch {
+		case ok:
+			// The block is already cached; overwrite it.
+			s.mu.size -= s.mu.blocks[cacheBlockInd].length
+		case len(s.mu.free) == 0:
 			// TODO(josh): Right now, we do random eviction. Eventually, we will do something
 			// more sophisticated, e.g. leverage ClockPro.
-			var k metadataKey
-			for k1, v := range s.mu.where {
+			for _, v := range s.mu.where {
 				cacheBlockInd = v
-				k = k1
 				break
 			}
-			delete(s.mu.where, k)
-		} else {
+			s.removeLocked(cacheBlockInd)
+			s.cache.metrics.evictions.Add(1)
+			fallthrough
+		default:
 			cacheBlockInd = s.mu.free[len(s.mu.free)-1]
 			s.mu.free = s.mu.free[:len(s.mu.free)-1]
 		}
 
-		s.mu.where[metadataKey{
-			filenum:    fileNum,
-			blockIndex: (ofs + int64(n)) / int64(s.blockSize),
-		}] = cacheBlockInd
-
-		writeAt := cacheBlockInd * int64(s.blockSize)
-		writeSize := s.blockSize
-
-		if len(p[n:]) <= writeSize {
-			// Ignore num written ret value, since if partial write, an error
-			// is returned.
-			_, err := s.file.WriteAt(p[n:], writeAt)
+		// The block is written before its record. If the process crashes in
+		// between, the record of the block's previous contents doesn't match
+		// the new contents' checksum, and the block is discarded when read.
+		m := cacheBlockMeta{key: k, length: int64(len(data)), checksum: crc.New(data).Value()}
+		s.mu.blocks[cacheBlockInd] = m
+		s.mu.where[k] = cacheBlockInd
+		s.mu.size += m.length
+		if _, err := s.file.WriteAt(data, cacheBlockInd*int64(s.blockSize)); err != nil {
+			s.removeLocked(cacheBlockInd)
 			return err
 		}
-		numWritten, err := s.file.WriteAt(p[n:n+writeSize], writeAt)
-		if err != nil {
+		m.encode(record[:], s.blockSize)
+		if _, err := s.file.WriteAt(record[:], s.recordOffset(cacheBlockInd)); err != nil {
+			s.removeLocked(cacheBlockInd)
 			return err
 		}
-
-		// Note that numWritten == writeSize, since we checked for an error above.
-		n += numWritten
 	}
+	return nil
+}
+
+// removeLocked frees the given cache block. Its record isn't updated: the
+// record is overwritten when the block is reused, and a block that's not
+// reused before the cache is closed is loaded again when it's reopened.
+func (s *sharedCacheShard) removeLocked(cacheBlockInd int64) {
+	m := &s.mu.blocks[cacheBlockInd]
+	delete(s.mu.where, m.key)
+	s.mu.size -= m.length
+	*m = cacheBlockMeta{}
+	s.mu.free = append(s.mu.free, cacheBlockInd)
 }
@@ -24,7 +24,7 @@ func TestSharedCache(t *testing.T) {
 			log.Infof("<local fs> "+fmt, args...)
 		})
 
-		cache, err := openSharedCache(fs, "", 32*1024, size, 32)
+		cache, err := openSharedCache(base.DefaultLogger, fs, "", 32*1024, size, 32)
 		require.NoError(t, err)
 		defer cache.Close()
 
@@ -80,7 +80,7 @@ func TestSharedCache(t *testing.T) {
 				defer readable.Close()
 
 				got := make([]byte, size)
-				err = cache.ReadAt(ctx, 1, got, offset, readable)
+				err = cache.ReadAt(ctx, 1, 1, got, offset, readable, readable.Size())
 				// We always expect cache.ReadAt to succeed.
 				require.NoError(t, err)
 				// It is easier to assert this condition programmatically, rather than returning
@@ -89,7 +89,7 @@ func TestSharedCache(t *testing.T) {
 
 				// TODO(josh): Not tracing out filesystem activity here, since logging_fs.go
 				// doesn't trace calls to ReadAt or WriteAt. We should consider changing this.
-				return fmt.Sprintf("misses=%d", cache.misses.Load())
+				return fmt.Sprintf("misses=%d", cache.Metrics().Misses)
 			default:
 				d.Fatalf(t, "unknown command %s", d.Cmd)
 				return ""
@@ -97,3 +97,83 @@ func TestSharedCache(t *testing.T) {
 		})
 	})
 }
+
+func TestSharedCachePersistence(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	const blockSize = 32 * 1024
+	const numShards = 2
+	size := shardingBlockSize * int64(numShards)
+
+	// Write an object of 100 blocks, whose last block is partial.
+	objSize := int64(100*blockSize - 10)
+	data := make([]byte, objSize)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	f, err := fs.Create("obj")
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	f, err = fs.Open("obj")
+	require.NoError(t, err)
+	readable, err := newFileReadable(f, fs, "obj")
+	require.NoError(t, err)
+	defer readable.Close()
+
+	read := func(cache *sharedCache, ofs int64, n int) {
+		t.Helper()
+		got := make([]byte, n)
+		require.NoError(t, cache.ReadAt(ctx, 1, 1, got, ofs, readable, objSize))
+		require.Equal(t, data[ofs:ofs+int64(n)], got)
+	}
+
+	cache, err := openSharedCache(base.DefaultLogger, fs, "cache", blockSize, size, numShards)
+	require.NoError(t, err)
+	read(cache, 100, 1000)
+	read(cache, objSize-20, 20)
+	read(cache, 100, 1000)
+	m := cache.Metrics()
+	require.Equal(t, int64(1), m.Hits)
+	require.Equal(t, int64(2), m.Misses)
+	require.Equal(t, int64(2), m.Count)
+	require.Equal(t, int64(2*blockSize-10), m.Size)
+	require.NoError(t, cache.Close())
+
+	// The cached blocks are read from the cache once it's reopened.
+	cache, err = openSharedCache(base.DefaultLogger, fs, "cache", blockSize, size, numShards)
+	require.NoError(t, err)
+	read(cache, 200, 500)
+	read(cache, objSize-10, 10)
+	m = cache.Metrics()
+	require.Equal(t, int64(2), m.Hits)
+	require.Equal(t, int64(0), m.Misses)
+	require.Equal(t, int64(2), m.Count)
+
+	// A block whose contents don't match its record, such as a block being
+	// overwritten when the process crashed, is discarded.
+	shard := cache.getShard(1, 1, 0)
+	shard.mu.Lock()
+	cacheBlockInd := shard.mu.where[metadataKey{creatorID: 1, filenum: 1, blockIndex: 0}]
+	shard.mu.Unlock()
+	_, err = shard.file.WriteAt([]byte("torn"), cacheBlockInd*blockSize)
+	require.NoError(t, err)
+	require.NoError(t, cache.Close())
+	cache, err = openSharedCache(base.DefaultLogger, fs, "cache", blockSize, size, numShards)
+	require.NoError(t, err)
+	read(cache, 0, 100)
+	m = cache.Metrics()
+	require.Equal(t, int64(1), m.CorruptBlocks)
+	require.Equal(t, int64(1), m.Misses)
+	read(cache, 0, 100)
+	require.Equal(t, int64(1), cache.Metrics().Hits)
+
+	// The cache is size-capped: once it's full, blocks are evicted to make
+	// room for others.
+	read(cache, 0, int(objSize))
+	m = cache.Metrics()
+	require.Equal(t, size/blockSize, m.Count)
+	require.Less(t, int64(0), m.Evictions)
+	require.NoError(t, cache.Close())
+}
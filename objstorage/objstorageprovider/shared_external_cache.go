@@ -0,0 +1,142 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+)
+
+const defaultExternalCacheBlockSize = 32 * 1024
+
+// externalCacheReader is a shared.ObjectReader which consults an external
+// cache before reading from the shared object. Reads are split into blocks of
+// a fixed size; the blocks missing from the cache are read from the object
+// with a single read, and written back to the cache.
+type externalCacheReader struct {
+	cache     shared.Cache
+	logger    base.Logger
+	objName   string
+	objReader shared.ObjectReader
+	size      int64
+	blockSize int64
+}
+
+var _ shared.ObjectReader = (*externalCacheReader)(nil)
+
+func newExternalCacheReader(
+	cache shared.Cache,
+	logger base.Logger,
+	objName string,
+	objReader shared.ObjectReader,
+	size int64,
+	blockSize int,
+) *externalCacheReader {
+	return &externalCacheReader{
+		cache:     cache,
+		logger:    logger,
+		objName:   objName,
+		objReader: objReader,
+		size:      size,
+		blockSize: int64(blockSize),
+	}
+}
+
+// blockKey returns the cache key of the given block. The key includes the
+// block size so that instances configured with different block sizes don't
+// read each other's blocks.
+func (r *externalCacheReader) blockKey(block int64) string {
+	return fmt.Sprintf("%s#%d.%d", r.objName, r.blockSize, block)
+}
+
+// blockLen returns the length of the given block, which is shorter than the
+// block size for the last block of the object.
+func (r *externalCacheReader) blockLen(block int64) int64 {
+	if n := r.size - block*r.blockSize; n < r.blockSize {
+		return n
+	}
+	return r.blockSize
+}
+
+// ReadAt is part of the shared.ObjectReader interface.
+func (r *externalCacheReader) ReadAt(ctx context.Context, p []byte, offset int64) error {
+	if len(p) == 0 || offset < 0 || offset+int64(len(p)) > r.size {
+		// Let the object reader handle (or reject) the read.
+		return r.objReader.ReadAt(ctx, p, offset)
+	}
+	first := offset / r.blockSize
+	last := (offset + int64(len(p)) - 1) / r.blockSize
+	keys := make([]string, last-first+1)
+	for i := range keys {
+		keys[i] = r.blockKey(first + int64(i))
+	}
+	values, err := r.cache.Get(ctx, keys)
+	if err != nil {
+		// The cache is best effort: read everything from the object.
+		r.logger.Infof("external cache lookup failed: %v", err)
+		values = nil
+	}
+
+	// copyBlock copies the part of the given block that overlaps the read.
+	copyBlock := func(block int64, data []byte) {
+		start := block * r.blockSize
+		if start < offset {
+			data = data[offset-start:]
+			start = offset
+		}
+		copy(p[start-offset:], data)
+	}
+
+	missingFirst, missingLast := int64(-1), int64(-1)
+	for i := range keys {
+		block := first + int64(i)
+		// A value of an unexpected length can't be trusted.
+		if i < len(values) && values[i] != nil && int64(len(values[i])) == r.blockLen(block) {
+			copyBlock(block, values[i])
+			continue
+		}
+		if missingFirst < 0 {
+			missingFirst = block
+		}
+		missingLast = block
+	}
+	if missingFirst < 0 {
+		return nil
+	}
+
+	// Read the missing blocks in their entirety with a single read. Blocks
+	// between them which were found in the cache are read again, which is
+	// cheaper than issuing several reads.
+	start := missingFirst * r.blockSize
+	end := missingLast*r.blockSize + r.blockLen(missingLast)
+	buf := make([]byte, end-start)
+	if err := r.objReader.ReadAt(ctx, buf, start); err != nil {
+		return err
+	}
+	var setKeys []string
+	var setValues [][]byte
+	for block := missingFirst; block <= missingLast; block++ {
+		i := block - first
+		data := buf[(block-missingFirst)*r.blockSize:][:r.blockLen(block)]
+		copyBlock(block, data)
+		if int(i) < len(values) && values[i] != nil && int64(len(values[i])) == r.blockLen(block) {
+			continue
+		}
+		setKeys = append(setKeys, keys[i])
+		setValues = append(setValues, data)
+	}
+	if err := r.cache.Set(ctx, setKeys, setValues); err != nil {
+		r.logger.Infof("writing back to external cache failed: %v", err)
+	}
+	return nil
+}
+
+// Close is part of the shared.ObjectReader interface.
+func (r *externalCacheReader) Close() error {
+	return r.objReader.Close()
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/stretchr/testify/require"
+)
+
+// countingReader counts the reads and bytes read from an object.
+type countingReader struct {
+	shared.ObjectReader
+	reads, bytes int
+}
+
+func (r *countingReader) ReadAt(ctx context.Context, p []byte, offset int64) error {
+	r.reads++
+	r.bytes += len(p)
+	return r.ObjectReader.ReadAt(ctx, p, offset)
+}
+
+func TestExternalCacheReader(t *testing.T) {
+	ctx := context.Background()
+	store := shared.NewInMem()
+	data := make([]byte, 10*1000+123)
+	rng := rand.New(rand.NewSource(1))
+	rng.Read(data)
+	w, err := store.CreateObject("obj")
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	cache := shared.NewInMemCache()
+	open := func() (*externalCacheReader, *countingReader) {
+		objReader, size, err := store.ReadObject(ctx, "obj")
+		require.NoError(t, err)
+		counting := &countingReader{ObjectReader: objReader}
+		return newExternalCacheReader(cache, base.DefaultLogger, "obj", counting, size, 1000), counting
+	}
+
+	r1, c1 := open()
+	read := func(r *externalCacheReader, offset, n int) {
+		t.Helper()
+		p := make([]byte, n)
+		require.NoError(t, r.ReadAt(ctx, p, int64(offset)))
+		require.Equal(t, data[offset:offset+n], p)
+	}
+	// A read spanning blocks 1-3 reads them in their entirety.
+	read(r1, 1500, 1800)
+	require.Equal(t, 1, c1.reads)
+	require.Equal(t, 3000, c1.bytes)
+
+	// Another reader of the same object is served by the cache.
+	r2, c2 := open()
+	read(r2, 1000, 3000)
+	read(r2, 2999, 2)
+	require.Equal(t, 0, c2.reads)
+
+	// Only the missing blocks are read, along with the cached blocks between
+	// them: blocks 0 and 4-5 are missing, so blocks 0-5 are read.
+	read(r2, 500, 5000)
+	require.Equal(t, 1, c2.reads)
+	require.Equal(t, 6000, c2.bytes)
+
+	// The last block of the object is shorter.
+	read(r2, len(data)-200, 200)
+	read(r1, len(data)-123, 123)
+	require.Equal(t, 1, c1.reads)
+	require.Equal(t, 2, c2.reads)
+
+	// Reads past the end of the object are rejected by the object reader.
+	require.Error(t, r1.ReadAt(ctx, make([]byte, 10), int64(len(data)-5)))
+	require.Equal(t, 2, c1.reads)
+
+	// A cached value of an unexpected length is ignored.
+	require.NoError(t, cache.Set(ctx, []string{r1.blockKey(8)}, [][]byte{[]byte("foo")}))
+	read(r1, 8000, 1000)
+	require.Equal(t, 3, c1.reads)
+	read(r2, 8000, 1000)
+	require.Equal(t, 2, c2.reads)
+}
+
+// failingCache is a shared.Cache whose lookups fail.
+type failingCache struct{}
+
+func (failingCache) Get(ctx context.Context, keys []string) ([][]byte, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (failingCache) Set(ctx context.Context, keys []string, values [][]byte) error {
+	return io.ErrUnexpectedEOF
+}
+
+func TestExternalCacheReaderErrors(t *testing.T) {
+	ctx := context.Background()
+	store := shared.NewInMem()
+	w, err := store.CreateObject("obj")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	objReader, size, err := store.ReadObject(ctx, "obj")
+	require.NoError(t, err)
+	r := newExternalCacheReader(failingCache{}, &base.InMemLogger{}, "obj", objReader, size, 4)
+	p := make([]byte, 5)
+	require.NoError(t, r.ReadAt(ctx, p, 6))
+	require.Equal(t, "world", string(p))
+}
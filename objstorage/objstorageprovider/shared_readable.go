@@ -53,6 +53,8 @@ type sharedReadHandle struct {
 		state  readaheadState
 		data   []byte
 		offset int64
+		// lastSize is the size of the readahead issued by the last ReadAt call.
+		lastSize int64
 	}
 	forCompaction bool
 }
@@ -60,6 +62,7 @@ type sharedReadHandle struct {
 var _ objstorage.ReadHandle = (*sharedReadHandle)(nil)
 
 func (r *sharedReadHandle) ReadAt(ctx context.Context, p []byte, offset int64) error {
+	r.readahead.lastSize = 0
 	readaheadSize := r.maybeReadahead(offset, len(p))
 
 	// Check if we already have the data from a previous read-ahead.
@@ -77,6 +80,11 @@ func (r *sharedReadHandle) ReadAt(ctx context.Context, p []byte, offset int64) e
 		}
 	}
 
+	// Don't read ahead past the end of the object.
+	if remaining := r.readable.size - offset; int64(readaheadSize) > remaining {
+		readaheadSize = int(remaining)
+	}
+
 	if readaheadSize > len(p) {
 		r.readahead.offset = offset
 		// TODO(radu): we need to somehow account for this memory.
@@ -90,6 +98,7 @@ func (r *sharedReadHandle) ReadAt(ctx context.Context, p []byte, offset int64) e
 			r.readahead.data = r.readahead.data[:0]
 			return err
 		}
+		r.readahead.lastSize = int64(readaheadSize)
 		copy(p, r.readahead.data)
 		return nil
 	}
@@ -120,3 +129,7 @@ func (r *sharedReadHandle) RecordCacheHit(_ context.Context, offset, size int64)
 		r.readahead.state.recordCacheHit(offset, size)
 	}
 }
+
+func (r *sharedReadHandle) LastReadaheadSize() int64 {
+	return r.readahead.lastSize
+}
@@ -44,7 +44,7 @@ func (p *provider) vfsCreate(
 	}
 	file = vfs.NewSyncingFile(file, vfs.SyncingFileOptions{
 		NoSyncOnClose: p.st.NoSyncOnClose,
-		BytesPerSync:  p.st.BytesPerSync,
+		BytesPerSync:  int(p.bytesPerSync.Load()),
 	})
 	meta := objstorage.ObjectMetadata{
 		DiskFileNum: fileNum,
@@ -70,7 +70,7 @@ func (p *provider) vfsInit() error {
 
 	for _, filename := range listing {
 		fileType, fileNum, ok := base.ParseFilename(p.st.FS, filename)
-		if ok && fileType == base.FileTypeTable {
+		if ok && (fileType == base.FileTypeTable || fileType == base.FileTypeBlob) {
 			o := objstorage.ObjectMetadata{
 				FileType:    fileType,
 				DiskFileNum: fileNum,
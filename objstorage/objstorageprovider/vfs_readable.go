@@ -85,9 +85,16 @@ type vfsReadHandle struct {
 
 	// sequentialFile holds a file descriptor to the same underlying File,
 	// except with fadvise(FADV_SEQUENTIAL) called on it to take advantage of
-	// OS-level readahead. Once this is non-nil, the other variables in
-	// readaheadState don't matter much as we defer to OS-level readahead.
+	// OS-level readahead. While this is non-nil, readaheadState is only used to
+	// detect that the reads are no longer sequential, in which case we stop
+	// relying on OS-level readahead.
 	sequentialFile vfs.File
+	// forCompaction is set by SetupForCompaction, in which case OS-level
+	// readahead is used regardless of the reading pattern.
+	forCompaction bool
+	// lastReadaheadSize is the size of the readahead issued by the last ReadAt
+	// call.
+	lastReadaheadSize int64
 }
 
 var _ objstorage.ReadHandle = (*vfsReadHandle)(nil)
@@ -119,13 +126,17 @@ func (rh *vfsReadHandle) Close() error {
 
 // ReadAt is part of the objstorage.ReadHandle interface.
 func (rh *vfsReadHandle) ReadAt(_ context.Context, p []byte, offset int64) error {
-	var n int
-	var err error
-	if rh.sequentialFile != nil {
-		// Use OS-level read-ahead.
-		n, err = rh.sequentialFile.ReadAt(p, offset)
-	} else {
-		if readaheadSize := rh.rs.maybeReadahead(offset, int64(len(p))); readaheadSize > 0 {
+	rh.lastReadaheadSize = 0
+	if !rh.forCompaction {
+		readaheadSize := rh.rs.maybeReadahead(offset, int64(len(p)))
+		if rh.sequentialFile != nil && rh.rs.numReads < minFileReadsForReadahead {
+			// The reads are no longer sequential, eg, because the iterator went
+			// from scanning to seeking. Stop relying on OS-level readahead,
+			// which would waste IO.
+			rh.switchFromOSReadahead()
+		}
+		if rh.sequentialFile == nil && readaheadSize > 0 {
+			rh.lastReadaheadSize = readaheadSize
 			if readaheadSize >= maxReadaheadSize {
 				// We've reached the maximum readahead size. Beyond this point, rely on
 				// OS-level readahead.
@@ -134,6 +145,13 @@ func (rh *vfsReadHandle) ReadAt(_ context.Context, p []byte, offset int64) error
 				_ = rh.r.file.Prefetch(offset, readaheadSize)
 			}
 		}
+	}
+	var n int
+	var err error
+	if rh.sequentialFile != nil {
+		// Use OS-level read-ahead.
+		n, err = rh.sequentialFile.ReadAt(p, offset)
+	} else {
 		n, err = rh.r.file.ReadAt(p, offset)
 	}
 	if invariants.Enabled && err == nil && n != len(p) {
@@ -144,6 +162,7 @@ func (rh *vfsReadHandle) ReadAt(_ context.Context, p []byte, offset int64) error
 
 // SetupForCompaction is part of the objstorage.ReadHandle interface.
 func (rh *vfsReadHandle) SetupForCompaction() {
+	rh.forCompaction = true
 	rh.switchToOSReadahead()
 }
 
@@ -160,15 +179,29 @@ func (rh *vfsReadHandle) switchToOSReadahead() {
 	}
 }
 
+func (rh *vfsReadHandle) switchFromOSReadahead() {
+	if rh.sequentialFile == nil {
+		return
+	}
+	_ = rh.sequentialFile.Close()
+	rh.sequentialFile = nil
+}
+
 // RecordCacheHit is part of the objstorage.ReadHandle interface.
 func (rh *vfsReadHandle) RecordCacheHit(_ context.Context, offset, size int64) {
-	if rh.sequentialFile != nil {
-		// Using OS-level readahead, so do nothing.
+	if rh.forCompaction {
+		// Using OS-level readahead regardless of the reading pattern, so do
+		// nothing.
 		return
 	}
 	rh.rs.recordCacheHit(offset, size)
 }
 
+// LastReadaheadSize is part of the objstorage.ReadHandle interface.
+func (rh *vfsReadHandle) LastReadaheadSize() int64 {
+	return rh.lastReadaheadSize
+}
+
 // TestingCheckMaxReadahead returns true if the ReadHandle has switched to
 // OS-level read-ahead.
 func TestingCheckMaxReadahead(rh objstorage.ReadHandle) bool {
@@ -207,7 +240,7 @@ func UsePreallocatedReadHandle(
 ) objstorage.ReadHandle {
 	if r, ok := readable.(*fileReadable); ok {
 		// See fileReadable.NewReadHandle.
-		rh.vfsReadHandle = vfsReadHandle{r: r}
+		rh.vfsReadHandle = vfsReadHandle{r: r, rs: makeReadaheadState()}
 		return rh
 	}
 	return readable.NewReadHandle(ctx)
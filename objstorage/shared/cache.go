@@ -0,0 +1,73 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package shared
+
+import (
+	"context"
+	"sync"
+)
+
+// Cache is an interface for an external cache tier holding blocks of shared
+// objects, such as a memcached or redis deployment. Since shared objects are
+// immutable and their names are the same on every Pebble instance reading
+// them, a Cache can be shared by multiple instances, which keeps it warm for
+// all of them.
+//
+// The cache is consulted by the objstorage.Provider when reading shared
+// objects. Keys identify a fixed-size block of an object; a cached value must
+// be returned exactly as it was set. A Cache is free to drop any value at any
+// time, and errors returned by it never fail reads: the provider falls back to
+// reading from the shared Storage.
+type Cache interface {
+	// Get looks up the given keys. It returns a slice parallel to keys, with a
+	// nil value for each key that isn't in the cache.
+	//
+	// Get may be called concurrently.
+	Get(ctx context.Context, keys []string) ([][]byte, error)
+
+	// Set stores the given values, which the caller may not modify after the
+	// call. Set is best effort and may complete asynchronously.
+	//
+	// Set may be called concurrently.
+	Set(ctx context.Context, keys []string, values [][]byte) error
+}
+
+// NewInMemCache returns an in-memory implementation of the shared.Cache
+// interface (for testing).
+func NewInMemCache() Cache {
+	c := &inMemCache{}
+	c.mu.values = make(map[string][]byte)
+	return c
+}
+
+// inMemCache is an in-memory implementation of the shared.Cache interface
+// (for testing).
+type inMemCache struct {
+	mu struct {
+		sync.Mutex
+		values map[string][]byte
+	}
+}
+
+var _ Cache = (*inMemCache)(nil)
+
+func (c *inMemCache) Get(ctx context.Context, keys []string) ([][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		values[i] = c.mu.values[k]
+	}
+	return values, nil
+}
+
+func (c *inMemCache) Set(ctx context.Context, keys []string, values [][]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, k := range keys {
+		c.mu.values[k] = values[i]
+	}
+	return nil
+}
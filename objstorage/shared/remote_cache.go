@@ -0,0 +1,354 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package shared
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RemoteCacheClient is a client of a remote key-value cache, such as memcached
+// or redis, which supports multi-key requests. It is adapted to the Cache
+// interface by NewRemoteCache.
+type RemoteCacheClient interface {
+	// MultiGet returns the values of the given keys that are in the cache.
+	MultiGet(ctx context.Context, keys []string) (map[string][]byte, error)
+
+	// MultiSet stores the given values in the cache.
+	MultiSet(ctx context.Context, keys []string, values [][]byte) error
+}
+
+// RemoteCacheOptions configure a RemoteCache.
+type RemoteCacheOptions struct {
+	// MaxBatchKeys is the maximum number of keys in a request to the client.
+	// Lookups of more keys are split into several requests. The default is 64.
+	MaxBatchKeys int
+
+	// FlushInterval is the maximum time during which a value passed to Set is
+	// buffered before being sent to the client along with other values. Set
+	// values are also sent as soon as MaxBatchKeys of them are buffered. The
+	// default is 10ms.
+	FlushInterval time.Duration
+
+	// MaxPendingKeys bounds the number of values buffered by Set; further
+	// values are dropped until the buffered ones are sent. The default is 16
+	// times MaxBatchKeys.
+	MaxPendingKeys int
+
+	// NegativeTTL is the time during which a key missing from the remote cache
+	// is remembered as such, so that lookups of the key don't reach the remote
+	// cache again. A key is forgotten as soon as it is Set. The default is 1s;
+	// a negative value disables negative caching.
+	NegativeTTL time.Duration
+
+	// MaxNegativeKeys bounds the number of keys remembered as missing. The
+	// default is 64K.
+	MaxNegativeKeys int
+
+	// ErrorBackoff is the time during which the remote cache isn't contacted
+	// after a request failed; lookups during that time miss. The default is
+	// 1s.
+	ErrorBackoff time.Duration
+}
+
+func (o *RemoteCacheOptions) ensureDefaults() {
+	if o.MaxBatchKeys <= 0 {
+		o.MaxBatchKeys = 64
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 10 * time.Millisecond
+	}
+	if o.MaxPendingKeys <= 0 {
+		o.MaxPendingKeys = 16 * o.MaxBatchKeys
+	}
+	if o.NegativeTTL == 0 {
+		o.NegativeTTL = time.Second
+	}
+	if o.MaxNegativeKeys <= 0 {
+		o.MaxNegativeKeys = 64 << 10
+	}
+	if o.ErrorBackoff <= 0 {
+		o.ErrorBackoff = time.Second
+	}
+}
+
+// RemoteCacheMetrics holds the metrics of a RemoteCache.
+type RemoteCacheMetrics struct {
+	// Lookups is the number of keys looked up.
+	Lookups int64
+	// Hits is the number of keys found, either in the remote cache or among
+	// the values buffered by Set.
+	Hits int64
+	// NegativeHits is the number of keys known to be missing without
+	// contacting the remote cache.
+	NegativeHits int64
+	// GetRequests and SetRequests are the numbers of requests to the client.
+	GetRequests int64
+	SetRequests int64
+	// Dropped is the number of values passed to Set which were dropped
+	// because too many values were buffered.
+	Dropped int64
+	// Errors is the number of failed requests to the client.
+	Errors int64
+}
+
+// RemoteCache adapts a RemoteCacheClient to the Cache interface. It batches
+// the values passed to Set into few requests, and caches negatively the keys
+// missing from the remote cache to avoid repeated round-trips for them.
+//
+// A RemoteCache runs a goroutine sending the values passed to Set, and must
+// be closed.
+type RemoteCache struct {
+	client RemoteCacheClient
+	opts   RemoteCacheOptions
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu struct {
+		sync.Mutex
+		// pending holds the values passed to Set which weren't yet sent, and
+		// pendingKeys their keys in the order in which they were set.
+		pending     map[string][]byte
+		pendingKeys []string
+		// negative holds the keys known to be missing from the remote cache,
+		// along with the time at which the knowledge expires.
+		negative map[string]time.Time
+		// backoffUntil is the time until which the remote cache isn't
+		// contacted after a failed request.
+		backoffUntil time.Time
+	}
+
+	// flushMu serializes flushes, which send a prefix of pendingKeys.
+	flushMu sync.Mutex
+	flushC  chan struct{}
+	closeC  chan struct{}
+	wg      sync.WaitGroup
+
+	metrics struct {
+		lookups, hits, negativeHits atomic.Int64
+		getRequests, setRequests    atomic.Int64
+		dropped, errors             atomic.Int64
+	}
+}
+
+var _ Cache = (*RemoteCache)(nil)
+
+// NewRemoteCache returns a Cache sending batched requests to the given
+// client.
+func NewRemoteCache(client RemoteCacheClient, opts RemoteCacheOptions) *RemoteCache {
+	opts.ensureDefaults()
+	c := &RemoteCache{
+		client: client,
+		opts:   opts,
+		now:    time.Now,
+		flushC: make(chan struct{}, 1),
+		closeC: make(chan struct{}),
+	}
+	c.mu.pending = make(map[string][]byte)
+	c.mu.negative = make(map[string]time.Time)
+	c.wg.Add(1)
+	go c.flushLoop()
+	return c
+}
+
+// Get is part of the Cache interface.
+func (c *RemoteCache) Get(ctx context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	c.metrics.lookups.Add(int64(len(keys)))
+
+	// Serve what we can locally, from the values not yet sent and the keys
+	// known to be missing.
+	var remote []int
+	now := c.now()
+	c.mu.Lock()
+	backoff := now.Before(c.mu.backoffUntil)
+	for i, k := range keys {
+		if v, ok := c.mu.pending[k]; ok {
+			values[i] = v
+			c.metrics.hits.Add(1)
+			continue
+		}
+		if expiry, ok := c.mu.negative[k]; ok {
+			if now.Before(expiry) {
+				c.metrics.negativeHits.Add(1)
+				continue
+			}
+			delete(c.mu.negative, k)
+		}
+		if !backoff {
+			remote = append(remote, i)
+		}
+	}
+	c.mu.Unlock()
+
+	for len(remote) > 0 {
+		n := len(remote)
+		if n > c.opts.MaxBatchKeys {
+			n = c.opts.MaxBatchKeys
+		}
+		batch := make([]string, n)
+		for j, i := range remote[:n] {
+			batch[j] = keys[i]
+		}
+		c.metrics.getRequests.Add(1)
+		found, err := c.client.MultiGet(ctx, batch)
+		if err != nil {
+			c.recordError()
+			return values, err
+		}
+		var missing []string
+		for j, i := range remote[:n] {
+			if v, ok := found[batch[j]]; ok {
+				values[i] = v
+				c.metrics.hits.Add(1)
+			} else {
+				missing = append(missing, batch[j])
+			}
+		}
+		c.addNegative(missing)
+		remote = remote[n:]
+	}
+	return values, nil
+}
+
+// Set is part of the Cache interface. The values are buffered, and sent to
+// the client in batches.
+func (c *RemoteCache) Set(ctx context.Context, keys []string, values [][]byte) error {
+	c.mu.Lock()
+	for i, k := range keys {
+		delete(c.mu.negative, k)
+		if _, ok := c.mu.pending[k]; ok {
+			c.mu.pending[k] = values[i]
+			continue
+		}
+		if len(c.mu.pendingKeys) >= c.opts.MaxPendingKeys {
+			c.metrics.dropped.Add(1)
+			continue
+		}
+		c.mu.pending[k] = values[i]
+		c.mu.pendingKeys = append(c.mu.pendingKeys, k)
+	}
+	full := len(c.mu.pendingKeys) >= c.opts.MaxBatchKeys
+	c.mu.Unlock()
+	if full {
+		select {
+		case c.flushC <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush sends all the buffered values to the client.
+func (c *RemoteCache) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	for {
+		c.mu.Lock()
+		n := len(c.mu.pendingKeys)
+		if n == 0 {
+			c.mu.Unlock()
+			return nil
+		}
+		if n > c.opts.MaxBatchKeys {
+			n = c.opts.MaxBatchKeys
+		}
+		keys := c.mu.pendingKeys[:n:n]
+		values := make([][]byte, n)
+		for i, k := range keys {
+			values[i] = c.mu.pending[k]
+		}
+		c.mu.Unlock()
+
+		c.metrics.setRequests.Add(1)
+		err := c.client.MultiSet(ctx, keys, values)
+
+		// The values are only removed from the buffer once sent, so that
+		// lookups meanwhile still find them.
+		c.mu.Lock()
+		for _, k := range keys {
+			delete(c.mu.pending, k)
+		}
+		c.mu.pendingKeys = c.mu.pendingKeys[n:]
+		c.mu.Unlock()
+		if err != nil {
+			c.recordError()
+			return err
+		}
+	}
+}
+
+// Metrics returns the metrics of the cache.
+func (c *RemoteCache) Metrics() RemoteCacheMetrics {
+	return RemoteCacheMetrics{
+		Lookups:      c.metrics.lookups.Load(),
+		Hits:         c.metrics.hits.Load(),
+		NegativeHits: c.metrics.negativeHits.Load(),
+		GetRequests:  c.metrics.getRequests.Load(),
+		SetRequests:  c.metrics.setRequests.Load(),
+		Dropped:      c.metrics.dropped.Load(),
+		Errors:       c.metrics.errors.Load(),
+	}
+}
+
+// Close sends the buffered values to the client and stops the goroutine of
+// the cache. The client isn't closed.
+func (c *RemoteCache) Close() error {
+	close(c.closeC)
+	c.wg.Wait()
+	return c.Flush(context.Background())
+}
+
+func (c *RemoteCache) flushLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeC:
+			return
+		case <-ticker.C:
+		case <-c.flushC:
+		}
+		// Errors are counted in the metrics; the values of a failed request
+		// are dropped, as the cache is best effort.
+		_ = c.Flush(context.Background())
+	}
+}
+
+// addNegative remembers the given keys as missing from the remote cache.
+func (c *RemoteCache) addNegative(keys []string) {
+	if c.opts.NegativeTTL < 0 || len(keys) == 0 {
+		return
+	}
+	now := c.now()
+	expiry := now.Add(c.opts.NegativeTTL)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.mu.negative)+len(keys) > c.opts.MaxNegativeKeys {
+		for k, e := range c.mu.negative {
+			if !now.Before(e) {
+				delete(c.mu.negative, k)
+			}
+		}
+	}
+	for _, k := range keys {
+		if len(c.mu.negative) >= c.opts.MaxNegativeKeys {
+			return
+		}
+		if _, ok := c.mu.pending[k]; !ok {
+			c.mu.negative[k] = expiry
+		}
+	}
+}
+
+func (c *RemoteCache) recordError() {
+	c.metrics.errors.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.backoffUntil = c.now().Add(c.opts.ErrorBackoff)
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package shared
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// testRemoteCacheClient is an in-memory RemoteCacheClient recording the
+// sizes of the requests it receives.
+type testRemoteCacheClient struct {
+	mu       sync.Mutex
+	values   map[string][]byte
+	gets     []int
+	sets     []int
+	failGets bool
+}
+
+func (c *testRemoteCacheClient) MultiGet(
+	ctx context.Context, keys []string,
+) (map[string][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets = append(c.gets, len(keys))
+	if c.failGets {
+		return nil, errors.New("unavailable")
+	}
+	res := make(map[string][]byte)
+	for _, k := range keys {
+		if v, ok := c.values[k]; ok {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+
+func (c *testRemoteCacheClient) MultiSet(
+	ctx context.Context, keys []string, values [][]byte,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sets = append(c.sets, len(keys))
+	for i, k := range keys {
+		c.values[k] = values[i]
+	}
+	return nil
+}
+
+func TestRemoteCache(t *testing.T) {
+	ctx := context.Background()
+	client := &testRemoteCacheClient{values: make(map[string][]byte)}
+	c := NewRemoteCache(client, RemoteCacheOptions{
+		MaxBatchKeys: 2,
+		// Only flush explicitly.
+		FlushInterval:  time.Hour,
+		MaxPendingKeys: 5,
+	})
+	defer func() { require.NoError(t, c.Close()) }()
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	// Lookups are split into batches.
+	values, err := c.Get(ctx, []string{"a", "b", "c"})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{nil, nil, nil}, values)
+	require.Equal(t, []int{2, 1}, client.gets)
+
+	// The misses are cached negatively.
+	values, err = c.Get(ctx, []string{"a", "b", "c"})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{nil, nil, nil}, values)
+	require.Equal(t, []int{2, 1}, client.gets)
+
+	// Set values are found before being sent, and are no longer cached
+	// negatively.
+	require.NoError(t, c.Set(ctx, []string{"a"}, [][]byte{[]byte("1")}))
+	values, err = c.Get(ctx, []string{"a", "b"})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("1"), nil}, values)
+	require.Equal(t, []int{2, 1}, client.gets)
+
+	// Values in excess of MaxPendingKeys are dropped.
+	c.mu.Lock()
+	c.mu.pendingKeys = append(c.mu.pendingKeys, "x", "y", "z", "w")
+	for _, k := range c.mu.pendingKeys[1:] {
+		c.mu.pending[k] = []byte(k)
+	}
+	c.mu.Unlock()
+	require.NoError(t, c.Set(ctx, []string{"b"}, [][]byte{[]byte("2")}))
+	require.NoError(t, c.Flush(ctx))
+	require.Equal(t, []int{2, 2, 1}, client.sets)
+	require.Equal(t, int64(1), c.Metrics().Dropped)
+
+	// Once the negative entries expire, the remote cache is consulted again.
+	now = now.Add(2 * time.Second)
+	values, err = c.Get(ctx, []string{"a", "b", "c"})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("1"), nil, nil}, values)
+	require.Equal(t, []int{2, 1, 2, 1}, client.gets)
+
+	// After a failed request, the remote cache isn't consulted for a while.
+	now = now.Add(2 * time.Second)
+	client.failGets = true
+	_, err = c.Get(ctx, []string{"a"})
+	require.Error(t, err)
+	client.failGets = false
+	values, err = c.Get(ctx, []string{"a"})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{nil}, values)
+	require.Equal(t, []int{2, 1, 2, 1, 1}, client.gets)
+	now = now.Add(2 * time.Second)
+	values, err = c.Get(ctx, []string{"a"})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("1")}, values)
+
+	m := c.Metrics()
+	require.Equal(t, int64(6), m.GetRequests)
+	require.Equal(t, int64(1), m.Errors)
+}
+
+func TestRemoteCacheBackgroundFlush(t *testing.T) {
+	ctx := context.Background()
+	client := &testRemoteCacheClient{values: make(map[string][]byte)}
+	c := NewRemoteCache(client, RemoteCacheOptions{
+		MaxBatchKeys:  2,
+		FlushInterval: time.Hour,
+	})
+	// Buffering MaxBatchKeys values triggers a flush.
+	require.NoError(t, c.Set(ctx, []string{"a", "b"}, [][]byte{[]byte("1"), []byte("2")}))
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.values) == 2
+	}, 10*time.Second, time.Millisecond)
+	// Closing flushes the remaining values.
+	require.NoError(t, c.Set(ctx, []string{"c"}, [][]byte{[]byte("3")}))
+	require.NoError(t, c.Close())
+	require.Equal(t, 3, len(client.values))
+}
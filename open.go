@@ -72,8 +72,8 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	// In all error cases, we return db = nil; this is used by various
 	// deferred cleanups.
 
-	// Open the database and WAL directories first.
-	walDirname, dataDir, walDir, err := prepareAndOpenDirs(dirname, opts)
+	// Open the database, WAL and sstable directories first.
+	walDirname, tableDirname, dataDir, walDir, err := prepareAndOpenDirs(dirname, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +99,10 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 		fileLock = opts.Lock
 	} else {
-		fileLock, err = LockDirectory(dirname, opts.FS)
+		// The owner of the lock is only recorded if it's used for fencing, so
+		// as not to write and sync the owner on every Open.
+		recordOwner := opts.Experimental.LockFencing && !opts.ReadOnly
+		fileLock, err = lockDirectory(dirname, opts.FS, recordOwner)
 		if err != nil {
 			return nil, err
 		}
@@ -120,6 +123,30 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			formatVersionMarker.Close()
 		}
 	}()
+	// Previous versions of Pebble can't replay striped WALs.
+	if len(opts.Experimental.WALStripeDirs) > 0 && !opts.ReadOnly &&
+		formatVersion < FormatStripedWAL && opts.FormatMajorVersion < FormatStripedWAL {
+		return nil, errors.Errorf(
+			"pebble: WAL striping requires at least format major version %d (current: %d)",
+			FormatStripedWAL, formatVersion,
+		)
+	}
+	// Previous versions of Pebble can't read sstables declaring their
+	// features.
+	if opts.Experimental.ValueChecksums && !opts.ReadOnly &&
+		formatVersion < FormatTableFeatures && opts.FormatMajorVersion < FormatTableFeatures {
+		return nil, errors.Errorf(
+			"pebble: value checksums require at least format major version %d (current: %d)",
+			FormatTableFeatures, formatVersion,
+		)
+	}
+	if opts.Experimental.EncryptionKeyManager != nil && !opts.ReadOnly &&
+		formatVersion < FormatTableFeatures && opts.FormatMajorVersion < FormatTableFeatures {
+		return nil, errors.Errorf(
+			"pebble: sstable encryption requires at least format major version %d (current: %d)",
+			FormatTableFeatures, formatVersion,
+		)
+	}
 
 	// Find the currently active manifest, if there is one.
 	manifestMarker, manifestFileNum, manifestExists, err := findCurrentManifest(formatVersion, opts.FS, dirname)
@@ -150,25 +177,44 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	}
 
 	d := &DB{
-		cacheID:             opts.Cache.NewID(),
-		dirname:             dirname,
-		walDirname:          walDirname,
-		opts:                opts,
-		cmp:                 opts.Comparer.Compare,
-		equal:               opts.equal(),
-		merge:               opts.Merger.Merge,
-		split:               opts.Comparer.Split,
-		abbreviatedKey:      opts.Comparer.AbbreviatedKey,
-		largeBatchThreshold: (opts.MemTableSize - int(memTableEmptySize)) / 2,
-		fileLock:            fileLock,
-		dataDir:             dataDir,
-		walDir:              walDir,
-		logRecycler:         logRecycler{limit: opts.MemTableStopWritesThreshold + 1},
-		closed:              new(atomic.Value),
-		closedCh:            make(chan struct{}),
+		cacheID:        opts.Cache.NewID(),
+		dirname:        dirname,
+		walDirname:     walDirname,
+		tableDirname:   tableDirname,
+		opts:           opts,
+		cmp:            opts.Comparer.Compare,
+		equal:          opts.equal(),
+		merge:          opts.Merger.Merge,
+		split:          opts.Comparer.Split,
+		abbreviatedKey: opts.Comparer.AbbreviatedKey,
+		fileLock:       fileLock,
+		dataDir:        dataDir,
+		walDir:         walDir,
+		logRecycler:    logRecycler{limit: opts.MemTableStopWritesThreshold + 1},
+		closed:         new(atomic.Value),
+		closedCh:       make(chan struct{}),
 	}
 	d.mu.versions = &versionSet{}
 	d.diskAvailBytes.Store(math.MaxUint64)
+	d.setLargeBatchThreshold(opts.MemTableSize)
+	d.initCompressionDictTrainers()
+	// Allow DB.SetOptions to override the configured compaction concurrency.
+	maxConcurrentCompactions := opts.MaxConcurrentCompactions
+	opts.MaxConcurrentCompactions = func() int {
+		if n := d.maxConcurrentCompactions.Load(); n > 0 {
+			return int(n)
+		}
+		return maxConcurrentCompactions()
+	}
+	if opts.Experimental.HotRangeSampling.SampleEvery > 0 {
+		d.hotRanges = newHotRangeTracker(opts.Experimental.HotRangeSampling, opts.Comparer)
+	}
+	if opts.Experimental.HotKeySampling.SampleEvery > 0 {
+		d.hotKeys = newHotKeyTracker(opts.Experimental.HotKeySampling, opts.Comparer)
+	}
+	if opts.Experimental.LookupCacheSize > 0 {
+		d.lookupCache = newLookupCache(opts.Experimental.LookupCacheSize)
+	}
 	d.mu.versions.diskAvailBytes = d.getDiskAvailableBytesCached
 
 	defer func() {
@@ -186,6 +232,9 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			if d.tableCache != nil {
 				_ = d.tableCache.close()
 			}
+			if d.blobFiles != nil {
+				d.blobFiles.close()
+			}
 
 			for _, mem := range d.mu.mem.queue {
 				switch t := mem.flushable.(type) {
@@ -197,31 +246,52 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			if d.objProvider != nil {
 				d.objProvider.Close()
 			}
+			for _, dir := range d.walStripeDirs {
+				dir.Close()
+			}
 			if r != nil {
 				panic(r)
 			}
 		}
 	}()
 
-	d.commit = newCommitPipeline(commitEnv{
+	if !opts.ReadOnly {
+		if err := d.openWALStripeDirs(); err != nil {
+			return nil, err
+		}
+	}
+
+	commitEnv := commitEnv{
 		logSeqNum:     &d.mu.versions.logSeqNum,
 		visibleSeqNum: &d.mu.versions.visibleSeqNum,
 		apply:         d.commitApply,
 		write:         d.commitWrite,
-	})
-	d.deletionLimiter = rate.NewLimiter(
-		rate.Limit(d.opts.Experimental.MinDeletionRate),
-		d.opts.Experimental.MinDeletionRate)
+
+		bulkCommitConcurrency: opts.Experimental.BulkCommitConcurrency,
+	}
+	if opts.Experimental.ApplyCommitted != nil {
+		commitEnv.observe = d.observeCommit
+	}
+	d.commit = newCommitPipeline(commitEnv)
+	if r := d.opts.Experimental.MinDeletionRate; r > 0 {
+		d.deletionLimiter = rate.NewLimiter(rate.Limit(r), r)
+	}
+	if r := d.opts.Experimental.MinDeletionFileRate; r > 0 {
+		d.deletionFileLimiter = rate.NewLimiter(rate.Limit(r), r)
+	}
 	d.mu.nextJobID = 1
 	d.mu.mem.nextSize = opts.MemTableSize
 	if d.mu.mem.nextSize > initialMemTableSize {
 		d.mu.mem.nextSize = initialMemTableSize
 	}
 	d.mu.cleaner.cond.L = &d.mu.Mutex
+	d.mu.ingestOrder.cond.L = &d.mu.Mutex
 	d.mu.compact.cond.L = &d.mu.Mutex
 	d.mu.compact.inProgress = make(map[*compaction]struct{})
 	d.mu.compact.noOngoingFlushStartTime = time.Now()
 	d.mu.snapshots.init()
+	d.mu.prepared = make(map[string]*preparedBatch)
+	d.mu.preparing = make(map[string]struct{})
 	// logSeqNum is the next sequence number that will be assigned. Start
 	// assigning sequence numbers from base.SeqNumStart to leave room for reserved
 	// sequence numbers (see comments around SeqNumStart).
@@ -239,6 +309,9 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	d.mu.nextJobID++
 
 	setCurrent := setCurrentFunc(d.mu.formatVers.vers, manifestMarker, opts.FS, dirname, d.dataDir)
+	if opts.Experimental.LockFencing && !opts.ReadOnly {
+		d.mu.versions.fence = fileLock.checkFence
+	}
 
 	if !manifestExists {
 		// DB does not exist.
@@ -288,16 +361,26 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 		ls = append(ls, ls2...)
 	}
+	if d.tableDirname != d.dirname && d.tableDirname != d.walDirname {
+		ls2, err := opts.FS.List(d.tableDirname)
+		if err != nil {
+			return nil, err
+		}
+		ls = append(ls, ls2...)
+	}
 	providerSettings := objstorageprovider.Settings{
 		Logger:              opts.Logger,
 		FS:                  opts.FS,
-		FSDirName:           dirname,
+		FSDirName:           d.tableDirname,
 		FSDirInitialListing: ls,
 		FSCleaner:           opts.Cleaner,
 		NoSyncOnClose:       opts.NoSyncOnClose,
 		BytesPerSync:        opts.BytesPerSync,
 	}
 	providerSettings.Shared.Storage = opts.Experimental.SharedStorage
+	providerSettings.Shared.ExternalCache = opts.Experimental.SharedExternalCache
+	providerSettings.Shared.CacheSizeBytes = opts.Experimental.SecondaryCacheSizeBytes
+	providerSettings.Shared.CacheDirName = opts.Experimental.SecondaryCacheDir
 
 	d.objProvider, err = objstorageprovider.Open(providerSettings)
 	if err != nil {
@@ -306,13 +389,15 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 	if manifestExists {
 		curVersion := d.mu.versions.currentVersion()
-		if err := checkConsistency(curVersion, dirname, d.objProvider); err != nil {
+		if err := runConsistencyCheck(curVersion, dirname, d.objProvider, d.opts, d.cacheID); err != nil {
 			return nil, err
 		}
 	}
 
 	tableCacheSize := TableCacheSize(opts.MaxOpenFiles)
 	d.tableCache = newTableCacheContainer(opts.TableCache, d.cacheID, d.objProvider, d.opts, tableCacheSize)
+	d.blobFiles = newBlobFileCache(d.objProvider)
+	d.tableCache.dbOpts.opts.BlobValueFetcher = d.blobFiles
 	d.newIters = d.tableCache.newIters
 	d.tableNewRangeKeyIter = d.tableCache.newRangeKeyIter
 
@@ -363,6 +448,12 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 	}
 
+	// Load the ID of the DB before replaying the WAL, which may flush
+	// sstables stamped with the ID.
+	if err := d.loadOrCreateID(); err != nil {
+		return nil, err
+	}
+
 	// Validate the most-recent OPTIONS file, if there is one.
 	var strictWALTail bool
 	if previousOptionsFilename != "" {
@@ -379,13 +470,20 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 	var ve versionEdit
 	var toFlush flushableList
+	// replayedSeqNum is the sequence number following the batches of the WALs
+	// replayed so far, or zero if none were.
+	var replayedSeqNum uint64
 	for i, lf := range logFiles {
 		lastWAL := i == len(logFiles)-1
 		flush, maxSeqNum, err := d.replayWAL(jobID, &ve, opts.FS,
-			opts.FS.PathJoin(d.walDirname, lf.name), lf.num, strictWALTail && !lastWAL)
+			opts.FS.PathJoin(d.walDirname, lf.name), lf.num, strictWALTail && !lastWAL,
+			replayedSeqNum)
 		if err != nil {
 			return nil, err
 		}
+		if replayedSeqNum < maxSeqNum {
+			replayedSeqNum = maxSeqNum
+		}
 		toFlush = append(toFlush, flush...)
 		d.mu.versions.markFileNumUsed(lf.num)
 		if d.mu.versions.logSeqNum.Load() < maxSeqNum {
@@ -417,6 +515,9 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		for _, entry := range toFlush {
 			entry.readerUnrefLocked(true)
 		}
+		if err := d.removeObsoleteWALStripes(newLogNum); err != nil {
+			return nil, err
+		}
 
 		newLogName := base.MakeFilepath(opts.FS, d.walDirname, fileTypeLog, newLogNum.DiskFileNum())
 		d.mu.log.queue = append(d.mu.log.queue, fileInfo{fileNum: newLogNum.DiskFileNum(), fileSize: 0})
@@ -436,11 +537,8 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		// memtables being flushed, only for the next unflushed memtable.
 		d.mu.mem.queue[len(d.mu.mem.queue)-1].logNum = newLogNum
 
-		logFile = vfs.NewSyncingFile(logFile, vfs.SyncingFileOptions{
-			NoSyncOnClose:   d.opts.NoSyncOnClose,
-			BytesPerSync:    d.opts.WALBytesPerSync,
-			PreallocateSize: d.walPreallocateSize(),
-		})
+		walOpts := d.walSyncingFileOptions()
+		logFile = vfs.NewSyncingFile(logFile, walOpts)
 		d.mu.log.metrics.fsyncLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
 			Buckets: FsyncLatencyBuckets,
 		})
@@ -450,9 +548,24 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			WALFsyncLatency:    d.mu.log.metrics.fsyncLatency,
 			QueueSemChan:       d.commit.logSyncQSem,
 		}
-		d.mu.log.LogWriter = record.NewLogWriter(logFile, newLogNum, logWriterConfig)
+		logWriter, err := d.createWALStripes(logFile, newLogNum.DiskFileNum(), walOpts)
+		if err != nil {
+			return nil, err
+		}
+		d.mu.log.LogWriter = record.NewLogWriter(logWriter, newLogNum, logWriterConfig)
+		if err := d.writeWALStripeHeader(); err != nil {
+			return nil, err
+		}
+		// The prepared batches recovered from the replayed WALs are rewritten
+		// to the new WAL before the replayed WALs are deleted.
+		if err := d.relogPreparedLocked(); err != nil {
+			return nil, err
+		}
 		d.mu.versions.metrics.WAL.Files++
 	}
+	if len(d.opts.Experimental.ShortLivedSpans) > 0 {
+		d.markShortLivedFilesLocked()
+	}
 	d.updateReadStateLocked(d.opts.DebugCheck)
 
 	// If the Options specify a format major version higher than the
@@ -517,6 +630,9 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	}
 	d.calculateDiskAvailableBytes()
 
+	d.addMetricsSampleLocked()
+	go d.sampleMetricsRates()
+
 	d.maybeScheduleFlush()
 	d.maybeScheduleCompaction()
 
@@ -549,25 +665,35 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 }
 
 // prepareAndOpenDirs opens the directories for the store (and creates them if
-// necessary).
+// necessary). The sstable directory is not opened, as it is opened (and
+// synced) by the objstorage provider.
 //
 // Returns an error if ReadOnly is set and the directories don't exist.
 func prepareAndOpenDirs(
 	dirname string, opts *Options,
-) (walDirname string, dataDir vfs.File, walDir vfs.File, err error) {
+) (walDirname, tableDirname string, dataDir vfs.File, walDir vfs.File, err error) {
 	walDirname = opts.WALDir
 	if opts.WALDir == "" {
 		walDirname = dirname
 	}
+	tableDirname = opts.TableDir
+	if opts.TableDir == "" {
+		tableDirname = dirname
+	}
 
 	// Create directories if needed.
 	if !opts.ReadOnly {
 		if err := opts.FS.MkdirAll(dirname, 0755); err != nil {
-			return "", nil, nil, err
+			return "", "", nil, nil, err
 		}
 		if walDirname != dirname {
 			if err := opts.FS.MkdirAll(walDirname, 0755); err != nil {
-				return "", nil, nil, err
+				return "", "", nil, nil, err
+			}
+		}
+		if tableDirname != dirname && tableDirname != walDirname {
+			if err := opts.FS.MkdirAll(tableDirname, 0755); err != nil {
+				return "", "", nil, nil, err
 			}
 		}
 	}
@@ -575,9 +701,9 @@ func prepareAndOpenDirs(
 	dataDir, err = opts.FS.OpenDir(dirname)
 	if err != nil {
 		if opts.ReadOnly && oserror.IsNotExist(err) {
-			return "", nil, nil, errors.Errorf("pebble: database %q does not exist", dirname)
+			return "", "", nil, nil, errors.Errorf("pebble: database %q does not exist", dirname)
 		}
-		return "", nil, nil, err
+		return "", "", nil, nil, err
 	}
 
 	if walDirname == dirname {
@@ -586,10 +712,10 @@ func prepareAndOpenDirs(
 		walDir, err = opts.FS.OpenDir(walDirname)
 		if err != nil {
 			dataDir.Close()
-			return "", nil, nil, err
+			return "", "", nil, nil, err
 		}
 	}
-	return walDirname, dataDir, walDir, nil
+	return walDirname, tableDirname, dataDir, walDir, nil
 }
 
 // GetVersion returns the engine version string from the latest options
@@ -660,12 +786,23 @@ func GetVersion(dir string, fs vfs.FS) (string, error) {
 // to the manifest, it is up to the caller of replayWAL to unreference the
 // toFlush flushables returned by replayWAL.
 //
+// If Options.Experimental.SalvageWAL is set, the records following a corrupt
+// chunk are salvaged, and the corruption is reported to
+// EventListener.WALSalvaged with the sequence numbers lost, which follow
+// prevSeqNum if the corruption precedes the first intact batch of the WAL.
+//
 // d.mu must be held when calling this, but the mutex may be dropped and
 // re-acquired during the course of this method.
 func (d *DB) replayWAL(
-	jobID int, ve *versionEdit, fs vfs.FS, filename string, logNum FileNum, strictWALTail bool,
+	jobID int,
+	ve *versionEdit,
+	fs vfs.FS,
+	filename string,
+	logNum FileNum,
+	strictWALTail bool,
+	prevSeqNum uint64,
 ) (toFlush flushableList, maxSeqNum uint64, err error) {
-	file, err := fs.Open(filename)
+	file, err := openWAL(fs, filename, d.opts.Experimental.WALStripeDirs, logNum)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -678,6 +815,9 @@ func (d *DB) replayWAL(
 		rr              = record.NewReader(file, logNum)
 		offset          int64 // byte offset in rr
 		lastFlushOffset int64
+		// salvage is the corruption being skipped, if the records following
+		// a corrupt chunk are being salvaged.
+		salvage *WALSalvageInfo
 	)
 
 	if d.opts.ReadOnly {
@@ -748,13 +888,42 @@ func (d *DB) replayWAL(
 			// truncated and to avoid replaying subsequent WALs, but want
 			// to otherwise treat them like EOF.
 			if err == io.EOF {
+				if salvage != nil && strictWALTail {
+					// No intact record follows the corruption, which is at the
+					// tail of the WAL.
+					return nil, 0, errors.Wrap(salvage.Err, "pebble: error when replaying WAL")
+				}
 				break
+			} else if record.IsInvalidRecord(err) && d.opts.Experimental.SalvageWAL {
+				// Skip to the next intact record, if any, remembering where the
+				// corruption began.
+				if salvage == nil {
+					salvage = &WALSalvageInfo{
+						JobID:       jobID,
+						Path:        filename,
+						FileNum:     logNum,
+						Offset:      offset,
+						Err:         err,
+						StartSeqNum: maxSeqNum,
+					}
+					if maxSeqNum == 0 {
+						salvage.StartSeqNum = prevSeqNum
+					}
+				}
+				buf.Reset()
+				rr.Recover()
+				continue
 			} else if record.IsInvalidRecord(err) && !strictWALTail {
 				break
 			}
 			return nil, 0, errors.Wrap(err, "pebble: error when replaying WAL")
 		}
 
+		if _, _, ok := decodeWALStripeHeader(buf.Bytes()); ok {
+			// The header of a striped WAL.
+			buf.Reset()
+			continue
+		}
 		if buf.Len() < batchHeaderLen {
 			return nil, 0, base.CorruptionErrorf("pebble: corrupt log file %q (num %s)",
 				filename, errors.Safe(logNum))
@@ -769,6 +938,30 @@ func (d *DB) replayWAL(
 		b = Batch{db: d}
 		b.SetRepr(buf.Bytes())
 		seqNum := b.SeqNum()
+
+		if kind, xid, repr, ok, err := decodePreparedRecord(b.Repr()); err != nil {
+			return nil, 0, err
+		} else if ok {
+			// A record of a two-phase commit. Prepare and rollback records
+			// consume no sequence numbers, and may be rewritten to newer WALs
+			// with newer sequence numbers, so they're skipped.
+			committed, err := d.replayPreparedRecordLocked(kind, xid, repr, seqNum, b.Count())
+			if err != nil {
+				return nil, 0, err
+			}
+			if committed == nil {
+				buf.Reset()
+				continue
+			}
+			b = Batch{db: d}
+			b.SetRepr(committed)
+		}
+		if salvage != nil {
+			// The batches between the corruption and this one were lost.
+			salvage.EndSeqNum = seqNum
+			d.opts.EventListener.WALSalvaged(*salvage)
+			salvage = nil
+		}
 		maxSeqNum = seqNum + uint64(b.Count())
 
 		{
@@ -801,7 +994,7 @@ func (d *DB) replayWAL(
 
 				paths := make([]string, len(fileNums))
 				for i, n := range fileNums {
-					paths[i] = base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, n)
+					paths[i] = base.MakeFilepath(d.opts.FS, d.tableDirname, fileTypeTable, n)
 				}
 
 				var meta []*manifest.FileMetadata
@@ -865,7 +1058,7 @@ func (d *DB) replayWAL(
 			}
 		}
 
-		if b.memTableSize >= uint64(d.largeBatchThreshold) {
+		if b.memTableSize >= uint64(d.largeBatchThreshold.Load()) {
 			flushMem()
 			// Make a copy of the data slice since it is currently owned by buf and will
 			// be reused in the next iteration.
@@ -991,26 +1184,65 @@ func Peek(dirname string, fs vfs.FS) (*DBDesc, error) {
 //
 // LockDirectory may be used to expand the critical section protected by the
 // database lock to include setup before the call to Open.
+//
+// LockDirectory records the calling process as the owner of the lock. If the
+// lock is held by another owner, LockDirectory returns a *LockHeldError
+// describing it.
 func LockDirectory(dirname string, fs vfs.FS) (*Lock, error) {
-	fileLock, err := fs.Lock(base.MakeFilepath(fs, dirname, fileTypeLock, base.FileNum(0).DiskFileNum()))
+	return lockDirectory(dirname, fs, true /* recordOwner */)
+}
+
+// lockDirectory acquires the database directory lock, recording the owner of
+// the lock if recordOwner is true. Open only records the owner if
+// Options.Experimental.LockFencing is enabled.
+func lockDirectory(dirname string, fs vfs.FS, recordOwner bool) (*Lock, error) {
+	fileLock, err := fs.Lock(lockFilename(fs, dirname))
 	if err != nil {
-		return nil, err
+		if oserror.IsNotExist(err) {
+			return nil, err
+		}
+		// The owner is informational; ignore any error reading it.
+		owner, _ := ReadLockOwner(fs, dirname)
+		return nil, &LockHeldError{Dirname: dirname, Owner: owner, Err: err}
+	}
+	l := newLock(dirname, fs, fileLock)
+	if recordOwner {
+		owner, err := ReadLockOwner(fs, dirname)
+		if err != nil && !oserror.IsNotExist(err) {
+			return nil, errors.CombineErrors(err, l.Close())
+		}
+		if err := l.recordOwner(owner.Epoch + 1); err != nil {
+			return nil, errors.CombineErrors(err, l.Close())
+		}
 	}
-	l := &Lock{dirname: dirname, fileLock: fileLock}
+	return l, nil
+}
+
+func lockFilename(fs vfs.FS, dirname string) string {
+	return base.MakeFilepath(fs, dirname, fileTypeLock, base.FileNum(0).DiskFileNum())
+}
+
+func newLock(dirname string, fs vfs.FS, fileLock io.Closer) *Lock {
+	l := &Lock{dirname: dirname, fs: fs, fileLock: fileLock}
 	l.refs.Store(1)
 	invariants.SetFinalizer(l, func(obj interface{}) {
 		if refs := obj.(*Lock).refs.Load(); refs > 0 {
 			panic(errors.AssertionFailedf("lock for %q finalized with %d refs", dirname, refs))
 		}
 	})
-	return l, nil
+	return l
 }
 
 // Lock represents a file lock on a directory. It may be passed to Open through
 // Options.Lock to elide lock aquisition during Open.
 type Lock struct {
-	dirname  string
+	dirname string
+	fs      vfs.FS
+	// fileLock is nil if the lock was taken over through TakeoverLock while
+	// the lock file was still held.
 	fileLock io.Closer
+	// owner is the owner recorded when the lock was acquired.
+	owner LockOwner
 	// refs is a count of the number of handles on the lock. refs must be 0, 1
 	// or 2.
 	//
@@ -1042,6 +1274,9 @@ func (l *Lock) Close() error {
 	if l.refs.Add(-1) > 0 {
 		return nil
 	}
+	if l.fileLock == nil {
+		return nil
+	}
 	defer func() { l.fileLock = nil }()
 	return l.fileLock.Close()
 }
@@ -21,11 +21,13 @@ import (
 
 	"github.com/cockroachdb/datadriven"
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/errorfs"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/pebble/vfs/atomicfs"
 	"github.com/cockroachdb/redact"
@@ -182,11 +184,87 @@ func TestOpenAlreadyLocked(t *testing.T) {
 	})
 }
 
+func TestLockOwner(t *testing.T) {
+	dirname := t.TempDir()
+	// The owner is only recorded with LockFencing.
+	d, err := Open(dirname, &Options{FS: vfs.Default})
+	require.NoError(t, err)
+	require.Equal(t, LockOwner{}, d.fileLock.Owner())
+	require.NoError(t, d.Close())
+	_, err = ReadLockOwner(vfs.Default, dirname)
+	require.True(t, oserror.IsNotExist(err), "%v", err)
+
+	opts := &Options{FS: vfs.Default}
+	opts.Experimental.LockFencing = true
+	d, err = Open(dirname, opts)
+	require.NoError(t, err)
+	owner, err := ReadLockOwner(vfs.Default, dirname)
+	require.NoError(t, err)
+	require.Equal(t, os.Getpid(), owner.PID)
+	require.Equal(t, uint64(1), owner.Epoch)
+	require.Equal(t, owner, d.fileLock.Owner())
+
+	// The lock is held, and the error describes the owner.
+	_, err = LockDirectory(dirname, vfs.Default)
+	var heldErr *LockHeldError
+	require.True(t, errors.As(err, &heldErr))
+	require.Equal(t, owner, heldErr.Owner)
+	require.Contains(t, err.Error(), fmt.Sprintf("held by pid %d", os.Getpid()))
+	require.NoError(t, d.Close())
+
+	// Each acquisition increments the epoch. Read-only databases don't record
+	// their ownership.
+	d, err = Open(dirname, opts)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), d.fileLock.Owner().Epoch)
+	require.NoError(t, d.Close())
+	roOpts := opts.Clone()
+	roOpts.ReadOnly = true
+	d, err = Open(dirname, roOpts)
+	require.NoError(t, err)
+	require.Equal(t, LockOwner{}, d.fileLock.Owner())
+	require.NoError(t, d.Close())
+	owner, err = ReadLockOwner(vfs.Default, dirname)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), owner.Epoch)
+}
+
+func TestTakeoverLock(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{FS: fs}
+	opts.Experimental.LockFencing = true
+	d1, err := Open("db", opts)
+	require.NoError(t, err)
+	require.NoError(t, d1.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d1.Flush())
+
+	// The takeover fails if the epoch is stale.
+	_, err = TakeoverLock("db", fs, 0)
+	require.EqualError(t, err, `pebble: lock on "db" is at epoch 1, not 0`)
+
+	lock, err := TakeoverLock("db", fs, 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lock.Owner().Epoch)
+	d2, err := Open("db", &Options{FS: fs, Lock: lock})
+	require.NoError(t, err)
+
+	// The previous owner is fenced off.
+	err = d1.Compact([]byte("a"), []byte("b"), false /* parallelize */)
+	require.True(t, errors.Is(err, ErrLockFenced), "%v", err)
+	require.NoError(t, d1.Close())
+
+	require.NoError(t, d2.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d2.Flush())
+	require.NoError(t, d2.Close())
+	require.NoError(t, lock.Close())
+}
+
 func TestNewDBFilenames(t *testing.T) {
 	versions := map[FormatMajorVersion][]string{
 		FormatMostCompatible: {
 			"000002.log",
 			"CURRENT",
+			"IDENTITY",
 			"LOCK",
 			"MANIFEST-000001",
 			"OPTIONS-000003",
@@ -194,10 +272,11 @@ func TestNewDBFilenames(t *testing.T) {
 		FormatNewest: {
 			"000002.log",
 			"CURRENT",
+			"IDENTITY",
 			"LOCK",
 			"MANIFEST-000001",
 			"OPTIONS-000003",
-			"marker.format-version.000013.014",
+			"marker.format-version.000020.021",
 			"marker.manifest.000001.MANIFEST-000001",
 		},
 	}
@@ -344,6 +423,65 @@ func TestOpenCloseOpenClose(t *testing.T) {
 	}
 }
 
+func TestOpenTableDir(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{
+		FS:       fs,
+		WALDir:   "wal",
+		TableDir: "tables",
+	}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	require.NoError(t, d.Close())
+
+	// Each kind of file resides in its own directory.
+	count := func(dir string, fileType base.FileType) int {
+		ls, err := fs.List(dir)
+		require.NoError(t, err)
+		var n int
+		for _, filename := range ls {
+			if ft, _, ok := base.ParseFilename(fs, filename); ok && ft == fileType {
+				n++
+			}
+		}
+		return n
+	}
+	for _, dir := range []string{"db", "wal", "tables"} {
+		for _, ft := range []base.FileType{fileTypeTable, fileTypeLog, fileTypeManifest, fileTypeOptions} {
+			var expected bool
+			switch dir {
+			case "db":
+				expected = ft == fileTypeManifest || ft == fileTypeOptions
+			case "wal":
+				expected = ft == fileTypeLog
+			case "tables":
+				expected = ft == fileTypeTable
+			}
+			require.Equal(t, expected, count(dir, ft) > 0, "%s: %s", dir, ft)
+		}
+	}
+
+	// The DB and the checkpoint, which is self-contained, can be reopened.
+	for _, dirname := range []string{"db", "checkpoint"} {
+		o := &Options{FS: fs}
+		if dirname == "db" {
+			o = opts
+		}
+		d, err = Open(dirname, o)
+		require.NoError(t, err)
+		for _, k := range []string{"a", "b"} {
+			_, closer, err := d.Get([]byte(k))
+			require.NoError(t, err)
+			require.NoError(t, closer.Close())
+		}
+		require.NoError(t, d.Close())
+	}
+}
+
 func TestOpenOptionsCheck(t *testing.T) {
 	mem := vfs.NewMem()
 	opts := &Options{FS: mem}
@@ -627,7 +765,7 @@ func TestWALReplaySequenceNumBug(t *testing.T) {
 	require.NoError(t, d.Set([]byte("2"), nil, nil))
 
 	// Write a large batch. This should go to a separate memtable.
-	largeValue := []byte(strings.Repeat("a", d.largeBatchThreshold))
+	largeValue := []byte(strings.Repeat("a", int(d.largeBatchThreshold.Load())))
 	require.NoError(t, d.Set([]byte("1"), largeValue, nil))
 
 	// This write should go the mutable memtable after the large batch in the
@@ -694,7 +832,7 @@ func TestOpenWALReplay2(t *testing.T) {
 						require.NoError(t, d.Set([]byte("2"), largeValue, nil))
 						require.NoError(t, d.Set([]byte("3"), largeValue, nil))
 					case "large-batch":
-						largeValue := []byte(strings.Repeat("a", d.largeBatchThreshold))
+						largeValue := []byte(strings.Repeat("a", int(d.largeBatchThreshold.Load())))
 						require.NoError(t, d.Set([]byte("1"), nil, nil))
 						require.NoError(t, d.Set([]byte("2"), largeValue, nil))
 						require.NoError(t, d.Set([]byte("3"), nil, nil))
@@ -852,6 +990,81 @@ func TestTwoWALReplayPermissive(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+// TestSalvageWAL tests that WAL replay salvages the batches following a
+// corrupt batch when Options.Experimental.SalvageWAL is set, and reports the
+// sequence numbers lost.
+func TestSalvageWAL(t *testing.T) {
+	for _, salvageWAL := range []bool{false, true} {
+		t.Run(fmt.Sprintf("salvage=%t", salvageWAL), func(t *testing.T) {
+			mem := vfs.NewMem()
+			d, err := Open("", &Options{FS: mem})
+			require.NoError(t, err)
+			// Each value spans two 32KiB blocks of the WAL.
+			value := bytes.Repeat([]byte("v"), 40<<10)
+			for _, k := range []string{"a", "b", "c"} {
+				require.NoError(t, d.Set([]byte(k), value, nil))
+			}
+			seqNumB := d.mu.versions.logSeqNum.Load() - 2
+			require.NoError(t, d.Close())
+
+			// Corrupt a chunk in the middle of the record of b.
+			ls, err := mem.List("")
+			require.NoError(t, err)
+			var wal string
+			for _, name := range ls {
+				if filepath.Ext(name) == ".log" {
+					wal = name
+				}
+			}
+			f, err := mem.Open(wal)
+			require.NoError(t, err)
+			data, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			data[60<<10] ^= 0xff
+			f, err = mem.Create(wal)
+			require.NoError(t, err)
+			_, err = f.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			var salvaged []WALSalvageInfo
+			opts := &Options{
+				FS: mem,
+				EventListener: &EventListener{
+					WALSalvaged: func(info WALSalvageInfo) {
+						salvaged = append(salvaged, info)
+					},
+				},
+			}
+			opts.Experimental.SalvageWAL = salvageWAL
+			d, err = Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+			get := func(k string) error {
+				_, closer, err := d.Get([]byte(k))
+				if err == nil {
+					err = closer.Close()
+				}
+				return err
+			}
+			require.NoError(t, get("a"))
+			require.ErrorIs(t, get("b"), ErrNotFound)
+			if !salvageWAL {
+				// The corruption ends the WAL.
+				require.ErrorIs(t, get("c"), ErrNotFound)
+				require.Empty(t, salvaged)
+				return
+			}
+			require.NoError(t, get("c"))
+			require.Len(t, salvaged, 1)
+			require.Equal(t, seqNumB, salvaged[0].StartSeqNum)
+			require.Equal(t, seqNumB+1, salvaged[0].EndSeqNum)
+			require.True(t, record.IsInvalidRecord(salvaged[0].Err))
+		})
+	}
+}
+
 // TestCrashOpenCrashAfterWALCreation tests a database that exits
 // ungracefully, begins recovery, creates the new WAL but promptly exits
 // ungracefully again.
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
@@ -24,8 +25,9 @@ import (
 )
 
 const (
-	cacheDefaultSize       = 8 << 20 // 8 MB
-	defaultLevelMultiplier = 10
+	cacheDefaultSize             = 8 << 20 // 8 MB
+	defaultLevelMultiplier       = 10
+	defaultL0SublevelScoreWeight = 2
 )
 
 // Compression exports the base.Compression type.
@@ -39,6 +41,20 @@ const (
 	ZstdCompression    = sstable.ZstdCompression
 )
 
+// CacheMode exports the sstable.CacheMode type.
+type CacheMode = sstable.CacheMode
+
+// Exported CacheMode constants.
+const (
+	CacheModeDefault         = sstable.CacheModeDefault
+	CacheModeNoFill          = sstable.CacheModeNoFill
+	CacheModeFillLowPriority = sstable.CacheModeFillLowPriority
+	CacheModePin             = sstable.CacheModePin
+)
+
+// ZstdOptions exports the sstable.ZstdOptions type.
+type ZstdOptions = sstable.ZstdOptions
+
 // FilterType exports the base.FilterType type.
 type FilterType = base.FilterType
 
@@ -62,6 +78,12 @@ type BlockPropertyCollector = sstable.BlockPropertyCollector
 // BlockPropertyFilter exports the sstable.BlockPropertyFilter type.
 type BlockPropertyFilter = base.BlockPropertyFilter
 
+// FilterVerificationOptions exports the sstable.FilterVerificationOptions type.
+type FilterVerificationOptions = sstable.FilterVerificationOptions
+
+// FilterDiscrepancy exports the sstable.FilterDiscrepancy type.
+type FilterDiscrepancy = sstable.FilterDiscrepancy
+
 // ShortAttributeExtractor exports the base.ShortAttributeExtractor type.
 type ShortAttributeExtractor = base.ShortAttributeExtractor
 
@@ -171,6 +193,47 @@ type IterOptions struct {
 	// existing is not low or if we just expect a one-time Seek (where loading the
 	// data block directly is better).
 	UseL6Filters bool
+	// BulkScan indicates that the iterator is used by a large scan, such as an
+	// export, whose blocks are unlikely to be read again. Blocks read by the
+	// iterator that aren't already in the block cache are cached in a separate
+	// transient cache of size Options.Experimental.BulkScanCacheSize, so that
+	// the scan doesn't evict the working set of the block cache. BulkScan has
+	// no effect if the DB is not configured with a bulk scan cache.
+	BulkScan bool
+	// CacheMode controls how the blocks read by the iterator are admitted to
+	// the block cache. CacheModeNoFill and CacheModeFillLowPriority are
+	// suited to large background scans, so that they don't evict the blocks
+	// of latency sensitive point lookups, while CacheModePin retains the
+	// blocks of a hot iterator in preference to others. The blocks that are
+	// already cached are served from the cache regardless of the mode.
+	CacheMode CacheMode
+	// IncludeDeletions configures the iterator to surface deletions alongside
+	// live keys, eg, for building diff or merge tooling above Pebble. A point
+	// tombstone that is the newest visible version of its key is surfaced as
+	// an entry with Iterator.EntryKind() == IterEntryDelete. Each fragment of
+	// the visible range deletions is surfaced as an entry at its start key,
+	// before any point key with the same key, with Iterator.EntryKind() ==
+	// IterEntryRangeDelete; its bounds are returned by
+	// Iterator.RangeDeleteBounds. Keys deleted by range deletions are not
+	// surfaced. IncludeDeletions is not supported for iterators over range
+	// keys, or for external iterators.
+	IncludeDeletions bool
+	// DisableReadSampling disables the sampling of the reads of the iterator
+	// that triggers read compactions (see
+	// Options.Experimental.ReadSamplingMultiplier). It is useful for
+	// iterators whose reads aren't representative of the workload, such as
+	// analytical scans, whose sampled reads would trigger compactions that
+	// don't benefit the rest of the workload.
+	DisableReadSampling bool
+	// ReadTimestamp, if set, reads the DB as of a timestamp, for a Comparer
+	// with CompareTimestamps: the versions of the keys with timestamps newer
+	// than ReadTimestamp are hidden, so that the first version of a key read
+	// iterating forward is the version visible at ReadTimestamp, and
+	// Iterator.NextPrefix skips the older versions. The keys without a
+	// timestamp and the range keys are unaffected. Reads below the GC
+	// watermark (see Options.Experimental.Timestamps) may observe partially
+	// collected history.
+	ReadTimestamp []byte
 
 	// Internal options.
 
@@ -180,6 +243,12 @@ type IterOptions struct {
 	level manifest.Level
 	// disableLazyCombinedIteration is an internal testing option.
 	disableLazyCombinedIteration bool
+	// skipFilesBelowSeqNum, if non-zero, configures level iterators to skip
+	// the files whose keys all have sequence numbers less than it. The
+	// iterator may then surface values shadowed by the keys of skipped files,
+	// so it's only suitable for finding the keys written after a sequence
+	// number, as in DB.ScanDiff.
+	skipFilesBelowSeqNum uint64
 
 	// NB: If adding new Options, you must account for them in iterator
 	// construction and Iterator.SetOptions.
@@ -321,6 +390,48 @@ type WriteOptions struct {
 	//
 	// The default value is true.
 	Sync bool
+
+	// CommitClass is the class of the write in the commit pipeline. Bulk
+	// writes, such as those of bulk loads, should use CommitClassBulk so that
+	// they can't fill the commit pipeline, whose capacity is shared with
+	// interactive writes. The classes share a single commit queue and WAL:
+	// the class only limits how many bulk writes may be in the pipeline at
+	// once, and doesn't change the batching of writes to the WAL or the order
+	// in which they're committed.
+	//
+	// The default value is CommitClassInteractive.
+	CommitClass CommitClass
+}
+
+// CommitClass classifies writes in the commit pipeline, which orders the
+// writes to the WAL and memtable. See WriteOptions.CommitClass.
+type CommitClass int8
+
+const (
+	// CommitClassInteractive is the class of latency sensitive writes.
+	CommitClassInteractive CommitClass = iota
+	// CommitClassBulk is the class of bulk writes, whose throughput matters
+	// more than their latency. At most
+	// Options.Experimental.BulkCommitConcurrency bulk writes are committed
+	// concurrently, leaving room in the commit pipeline for interactive
+	// writes. Bulk writes aren't otherwise prioritized: once admitted to the
+	// commit pipeline, they're written to the WAL in the order in which they
+	// arrive, and are published in that order, so an interactive write
+	// doesn't become visible before the bulk writes that precede it have
+	// been applied to the memtable.
+	CommitClassBulk
+)
+
+// String implements fmt.Stringer.
+func (c CommitClass) String() string {
+	switch c {
+	case CommitClassInteractive:
+		return "interactive"
+	case CommitClassBulk:
+		return "bulk"
+	default:
+		return fmt.Sprintf("CommitClass(%d)", int8(c))
+	}
 }
 
 // Sync specifies the default write options for writes which synchronize to
@@ -336,6 +447,77 @@ func (o *WriteOptions) GetSync() bool {
 	return o == nil || o.Sync
 }
 
+// GetCommitClass returns the CommitClass value or CommitClassInteractive if
+// the receiver is nil.
+func (o *WriteOptions) GetCommitClass() CommitClass {
+	if o == nil {
+		return CommitClassInteractive
+	}
+	return o.CommitClass
+}
+
+// DeletionCompactionRange overrides, for the sstables overlapping the key
+// range [Start, End), the proportion of deletions at which a sstable in the
+// bottommost level becomes eligible for an elision-only compaction, which
+// rewrites the sstable to drop the deleted data. By default a sstable is
+// eligible once its deletion tombstones make up more than 10% of its entries
+// or its range deletions delete at least 10% of its data. A lower threshold
+// reclaims space more eagerly in ranges that experience heavy deletion, such
+// as ranges used as queues.
+type DeletionCompactionRange struct {
+	Start, End []byte
+	// ThresholdPercent is the deletion threshold, as a percentage in the range
+	// [1, 100].
+	ThresholdPercent int
+}
+
+// defaultDeletionThresholdPercent is the deletion threshold of sstables that
+// do not overlap any of Options.Experimental.DeletionCompactionRanges.
+const defaultDeletionThresholdPercent = 10
+
+// deletionThresholdPercent returns the deletion threshold of the sstable with
+// the given bounds: the lowest threshold of the DeletionCompactionRanges that
+// the sstable overlaps, or the default threshold if there are none.
+func (o *Options) deletionThresholdPercent(smallest, largest []byte) int {
+	threshold := defaultDeletionThresholdPercent
+	for _, r := range o.Experimental.DeletionCompactionRanges {
+		if o.Comparer.Compare(smallest, r.End) < 0 && o.Comparer.Compare(r.Start, largest) <= 0 &&
+			r.ThresholdPercent < threshold {
+			threshold = r.ThresholdPercent
+		}
+	}
+	return threshold
+}
+
+// SmallFileCompactionOptions configures compactions that merge runs of small
+// sstables within a level into sstables of the level's target file size. Runs
+// of small sstables may accumulate, for example after many small ingestions,
+// increasing the number of files and the pressure on the table cache, without
+// triggering the score-based compactions. These compactions are low-priority,
+// and are only picked when no other compaction is.
+type SmallFileCompactionOptions struct {
+	// MinFiles is the minimum number of adjacent small sstables in a level
+	// that are merged by a compaction. The compaction of small sstables is
+	// disabled if MinFiles is zero, which is the default.
+	MinFiles int
+	// SizeThresholdPercent determines which sstables are small: those smaller
+	// than SizeThresholdPercent percent of the target file size of their
+	// level. Defaults to 25.
+	SizeThresholdPercent int
+}
+
+// defaultSmallFileSizeThresholdPercent is the default value of
+// SmallFileCompactionOptions.SizeThresholdPercent.
+const defaultSmallFileSizeThresholdPercent = 25
+
+// smallFileThreshold returns the size below which a sstable is eligible for a
+// small-file compaction, given the level whose target file size applies to
+// the sstable.
+func (o *Options) smallFileThreshold(level int) uint64 {
+	return uint64(o.Level(level).TargetFileSize) *
+		uint64(o.Experimental.SmallFileCompaction.SizeThresholdPercent) / 100
+}
+
 // LevelOptions holds the optional per-level parameters.
 type LevelOptions struct {
 	// BlockRestartInterval is the number of keys between restart points
@@ -361,12 +543,45 @@ type LevelOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// Zstd configures the compression level and window of the level's tables
+	// when Compression is ZstdCompression, eg, to compress the bottommost
+	// levels harder. The parameters are recorded in the table properties, so
+	// that readers may reject the tables they can't decompress. Changing them
+	// only affects the tables written subsequently.
+	Zstd ZstdOptions
+
+	// CompressionDict is a dictionary with which the data and value blocks of
+	// the level's tables are compressed when Compression is ZstdCompression,
+	// improving the compression of small blocks (see
+	// sstable.WriterOptions.CompressionDict). The dictionary is stored in each
+	// table, at the cost of its size. It isn't serialized by Options.String.
+	// Dictionaries are only used at format major version FormatTableFeatures
+	// or later.
+	CompressionDict []byte
+
+	// CompressionDictSize, if positive and CompressionDict is unset, makes the
+	// DB train dictionaries of at most this many bytes from the blocks written
+	// by the flushes and compactions into the level, with which the
+	// subsequent tables of the level are compressed when Compression is
+	// ZstdCompression. The dictionaries are trained from scratch when the DB
+	// is opened. Sizes of a few kilobytes to a few times the BlockSize are
+	// typical. Dictionaries are only trained at format major version
+	// FormatTableFeatures or later.
+	CompressionDictSize int
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
 	// One such implementation is bloom.FilterPolicy(10) from the pebble/bloom
 	// package.
 	//
+	// The filter policy may differ between levels, eg, to disable filters in
+	// L0, or to use more bits per key in L6. Tables moved between levels
+	// with different filter policies are rewritten, rather than moved, so
+	// that the tables of a level have the filters of the level. The filter
+	// policies of levels with the same Name must be able to read each other's
+	// filters, as is the case for bloom filters with differing bits per key.
+	//
 	// The default value means to use no filter.
 	FilterPolicy FilterPolicy
 
@@ -520,6 +735,16 @@ type Options struct {
 		// deletion pacing, which is also the default.
 		MinDeletionRate int
 
+		// MinDeletionFileRate is the minimum number of obsolete files per
+		// second that would be deleted. It paces the deletions of obsolete
+		// sstables, blob files and WALs alongside MinDeletionRate, and like it,
+		// is disabled when there are too many obsolete files or there isn't
+		// enough disk space available. The deletions paced by either are
+		// performed in the background, and the files awaiting deletion are
+		// reported by Metrics.DeletionPacing. Setting this to 0 disables
+		// pacing by the number of files, which is also the default.
+		MinDeletionFileRate int
+
 		// ReadCompactionRate controls the frequency of read triggered
 		// compactions by adjusting `AllowedSeeks` in manifest.FileMetadata:
 		//
@@ -548,6 +773,8 @@ type Options struct {
 		// to trigger a read triggered compaction. A value of -1 prevents sampling
 		// and disables read triggered compactions. The default is 1 << 4. which
 		// gets multiplied with a constant of 1 << 16 to yield 1 << 20 (1MB).
+		// Sampling may also be disabled for individual iterators with
+		// IterOptions.DisableReadSampling.
 		ReadSamplingMultiplier int64
 
 		// TableCacheShards is the number of shards per table cache.
@@ -579,6 +806,39 @@ type Options struct {
 		// By default, this value is false.
 		ValidateOnIngest bool
 
+		// ValidateOnWriteSampleRate, if positive, schedules the background
+		// validation of the sstables written by flushes and compactions,
+		// re-reading them to verify their block checksums in order to detect
+		// corruption introduced while the tables were written back to storage.
+		// The deletion of the tables made obsolete by flushes and compactions
+		// is deferred until the tables written before they became obsolete
+		// have been validated, so that the inputs of a flush or compaction
+		// remain on disk until its outputs have been checked. If a table fails
+		// validation, the failure is reported to EventListener.TableValidated
+		// before the obsolete tables held back for it are released.
+		//
+		// ValidateOnWriteSampleRate is the fraction, in the range [0, 1], of
+		// the data blocks of each table that are validated. The index, filter
+		// and meta blocks of the tables are always validated. A rate of 1
+		// validates the tables fully. By default, the value is zero, which
+		// disables the validation.
+		ValidateOnWriteSampleRate float64
+
+		// ConsistencyCheck is the level of validation of the sstables of the
+		// LSM performed by Open, which fails if an sstable is found to be
+		// missing or corrupt. The default, ConsistencyCheckFast, verifies that
+		// the sstables referenced by the MANIFEST exist with the expected sizes.
+		// The outcome and duration of the validation are reported to
+		// EventListener.ConsistencyChecked.
+		ConsistencyCheck ConsistencyCheckLevel
+
+		// ConsistencyCheckSampleRate is the fraction, in the range [0, 1], of
+		// the data blocks of each sstable whose checksums are verified by
+		// ConsistencyCheckThorough. The footer, index, filter and meta blocks
+		// are always verified. If zero, a tenth of the data blocks are
+		// verified.
+		ConsistencyCheckSampleRate float64
+
 		// LevelMultiplier configures the size multiplier used to determine the
 		// desired size of each level of the LSM. Defaults to 10.
 		LevelMultiplier int
@@ -601,11 +861,220 @@ type Options struct {
 		// is enough CPU available, and this option bypasses that.
 		ForceWriterParallelism bool
 
+		// CompressionConcurrency is the number of goroutines compressing the
+		// data blocks of an sstable concurrently, when the sstable Writer uses
+		// parallelism (see MaxWriterConcurrency). The blocks are written in
+		// order regardless. If CompressionConcurrency <= 1, the data blocks are
+		// compressed sequentially.
+		CompressionConcurrency int
+
 		// CPUWorkPermissionGranter should be set if Pebble should be given the
 		// ability to optionally schedule additional CPU. See the documentation
 		// for CPUWorkPermissionGranter for more details.
 		CPUWorkPermissionGranter CPUWorkPermissionGranter
 
+		// BackgroundWorkScheduler, if set, is consulted before every flush and
+		// compaction. Sharing a scheduler (eg, one returned by
+		// NewStaggeredScheduler) between DBs that share a process or disk
+		// staggers their background work. See the documentation for
+		// BackgroundWorkScheduler for more details.
+		BackgroundWorkScheduler BackgroundWorkScheduler
+
+		// BatchValidator, if set, is used to validate the contents of every batch
+		// before it is applied. It is a debugging aid; see the documentation for
+		// BatchValidator for more details.
+		BatchValidator BatchValidator
+
+		// KeySchema, if it has validators, is used to validate the keys of
+		// every batch before it is applied, and of every sstable before it is
+		// ingested, in builds with invariants enabled or in all builds. See the
+		// documentation for KeySchema for more details.
+		KeySchema KeySchema
+
+		// BatchApplyHooks are called with every batch before it is applied,
+		// and may add derived writes that are committed atomically with the
+		// batch. See the documentation for BatchApplyHook for more details.
+		BatchApplyHooks []BatchApplyHook
+
+		// ProvenanceStore, if set, describes the store of the DB, and is
+		// stamped into the sstables written by the DB along with the ID of the
+		// DB (see ReadSSTableProvenance). The sstables may outlive the DB, or
+		// be shared with other DBs, so by default only the ID is stamped.
+		ProvenanceStore string
+
+		// FilterVerification, if non-nil, enables the paranoid verification of
+		// a sample of the negative decisions made by table filters and block
+		// property filters. By default, the properties of skipped blocks are
+		// recomputed using Options.BlockPropertyCollectors, and discrepancies
+		// are logged. See FilterVerificationOptions for more details.
+		FilterVerification *FilterVerificationOptions
+
+		// HotRangeSampling configures the sampling of committed batches used
+		// to identify the key ranges with the most write contention. Sampling
+		// is disabled by default. See DB.HotRanges.
+		HotRangeSampling HotRangeSamplingOptions
+
+		// HotKeySampling configures the sampling of reads and committed
+		// batches used to identify the most frequently accessed keys. Sampling
+		// is disabled by default. See DB.HotKeys.
+		HotKeySampling HotKeySamplingOptions
+
+		// BulkCommitConcurrency is the maximum number of writes with
+		// WriteOptions.CommitClass set to CommitClassBulk that may be committed
+		// concurrently. Limiting the concurrency of bulk writes leaves room in
+		// the commit pipeline for interactive writes, which otherwise aren't
+		// prioritized over bulk writes. If zero, defaults to 1.
+		BulkCommitConcurrency int
+
+		// BulkScanCacheSize, if positive, is the size of the cache used by
+		// iterators created with IterOptions.BulkScan to cache the blocks that
+		// they read, rather than the block cache. The bulk scan cache is
+		// private to the DB, and is only consulted by bulk scans, which also
+		// read the blocks already present in the block cache. By default, the
+		// value is zero, and bulk scans use the block cache.
+		BulkScanCacheSize int64
+
+		// MaxTablesPerGet, if positive, bounds the number of sstables whose
+		// bounds contain the key that a Get may consult. A Get that does not
+		// find its key within that many sstables fails with
+		// ErrReadAmpLimitExceeded rather than consulting further sstables, so
+		// that pathological read amplification surfaces as errors rather than
+		// as silent tail latency. Callers may fall back to reading the key
+		// through an Iterator, which is not subject to the limit. The
+		// memtables and batches consulted by a Get are not counted.
+		MaxTablesPerGet int
+
+		// LookupCacheSize, if positive, is the size of a cache of the results
+		// of the recent point lookups by DB.Get and Snapshot.Get, which serves
+		// repeated lookups of the same key without constructing an iterator,
+		// absorbing the read storms on hot keys. The cache is strictly
+		// consistent: the entry of a key is invalidated before the writes to
+		// the key become visible, and all the entries are invalidated whenever
+		// the LSM changes, eg, by a flush or a compaction. The lookups through
+		// indexed batches or traced by a GetTrace bypass the cache. Its
+		// effectiveness is reported by Metrics.LookupCache. The cache is
+		// incompatible with TTL. By default, the value is zero, and there is
+		// no cache.
+		LookupCacheSize int64
+
+		// PrecheckTableFilters enables checking the table filter of an sstable
+		// through the table cache before a SeekPrefixGE opens the sstable's
+		// iterators, which load its index block. The sstables whose filter
+		// excludes the prefix, and which hold no range deletions or range keys,
+		// are skipped without reading their index block, reducing the latency
+		// of prefix seeks over cold sstables in wide LSMs. The sstables whose
+		// filter matches pay for an additional table cache lookup. It has no
+		// effect unless the levels configure a FilterPolicy.
+		PrecheckTableFilters bool
+
+		// FlushRangeDelCoalesceThreshold, if positive, is the number of abutting
+		// range deletion fragments from which a flush coalesces them into a
+		// single range deletion, written to a dedicated sstable holding only
+		// range deletions. Such sstables are prioritized by the table stats
+		// collector, so that the delete-only compactions of the sstables they
+		// cover are scheduled promptly. This is intended for bulk drops issued
+		// as many adjacent DeleteRanges. A run isn't coalesced if it would
+		// change the keys visible to an open snapshot, or delete a key that
+		// the run doesn't. The default value is zero, which disables
+		// coalescing.
+		FlushRangeDelCoalesceThreshold int
+
+		// ShortLivedSpans configures the spans of user keys holding short-lived
+		// data, whose flushed sstables bypass the compactions of the main LSM
+		// and are dropped once expired. The spans must be sorted and
+		// nonoverlapping. See ShortLivedSpan for details.
+		ShortLivedSpans []ShortLivedSpan
+
+		// ColumnFamilyPrefix enables the column families created by
+		// DB.NewColumnFamily, which are stored under this prefix of the
+		// keyspace. The keys with the prefix are reserved for column
+		// families, and must not be written otherwise. The comparer must order
+		// keys bytewise over the prefix, and the prefix must not change once
+		// column families were created. See ColumnFamily.
+		ColumnFamilyPrefix []byte
+
+		// ApplyCommitted, if set, is called with every batch committed to the
+		// DB, strictly in sequence number order, enabling consumers such as
+		// in-process materialized views to observe the DB's mutations in the
+		// order they were sequenced. Sequence numbers allocated by sstable
+		// ingestions and excises are also reported, so that the sequence
+		// numbers reported form a contiguous range. ApplyCommitted is called
+		// once the batch's mutations are visible to reads, though possibly
+		// before the WAL has been synced. Calls are serialized and performed
+		// within the commit pipeline, so ApplyCommitted must be fast and must
+		// not commit to the DB.
+		ApplyCommitted func(CommittedBatch)
+
+		// L0SublevelScoreWeight and L0ScoreSmoothing configure the score used to
+		// prioritize compactions out of L0. The raw L0 score is the larger of a
+		// sublevel-based score and a file-count-based score:
+		//
+		//   sublevelScore = L0SublevelScoreWeight * sublevels / L0CompactionThreshold
+		//   fileScore     = files / L0CompactionFileThreshold
+		//   rawScore      = max(sublevelScore, fileScore)
+		//
+		// where sublevels is the L0 read-amplification after accounting for
+		// ongoing compactions and files is the number of L0 files that are not
+		// being compacted. An L0 compaction is considered once the score reaches
+		// 1. A larger L0SublevelScoreWeight compacts L0 more eagerly, trading
+		// compaction churn for fewer write stalls.
+		//
+		// If L0ScoreSmoothing is non-zero, the score is additionally smoothed
+		// with an exponentially weighted moving average across LSM versions:
+		//
+		//   score = L0ScoreSmoothing * prevScore + (1 - L0ScoreSmoothing) * rawScore
+		//
+		// where prevScore is the smoothed score when the previous version was
+		// installed. Smoothing dampens the reaction to short bursts of flushes at
+		// the cost of reacting more slowly to sustained L0 growth.
+		//
+		// The default value of L0SublevelScoreWeight is 2, and the default value
+		// of L0ScoreSmoothing is 0 (no smoothing). L0ScoreSmoothing must be in
+		// the range [0, 1).
+		L0SublevelScoreWeight float64
+		L0ScoreSmoothing      float64
+
+		// DeletionCompactionRanges lowers the proportion of deletions at which
+		// bottommost sstables within particular key ranges are compacted to
+		// reclaim space. See DeletionCompactionRange for more details.
+		DeletionCompactionRanges []DeletionCompactionRange
+
+		// SmallFileCompaction configures compactions that merge runs of small
+		// sstables within a level. See SmallFileCompactionOptions for more
+		// details.
+		SmallFileCompaction SmallFileCompactionOptions
+
+		// QueueDeletionDetection enables the detection of sstables whose keys
+		// have all been deleted by point tombstones in a higher level, as
+		// happens when the DB is used as a FIFO queue: a contiguous run of keys
+		// is written, and deleted shortly after. Such sstables are dropped by
+		// delete-only compactions, rather than compacted with the tombstones.
+		// Detection happens when the table stats of a new sstable containing
+		// point tombstones are loaded, and requires reading the sstables
+		// beneath it that fall within its bounds until a live key is found.
+		// The size of the dropped sstables is reported by
+		// Metrics.Compact.QueueDeletedBytes.
+		QueueDeletionDetection bool
+
+		// LockFencing enables fencing of the database against takeovers of its
+		// lock through TakeoverLock. When enabled, Open records the owner of
+		// the lock (see ReadLockOwner), which is checked before every change
+		// to the database's state is recorded in the MANIFEST, and the change fails with ErrLockFenced if the lock was
+		// taken over since the database was opened. The check reads a small
+		// file from the database directory. LockFencing has no effect on
+		// read-only databases.
+		LockFencing bool
+
+		// StrictIngestOrder guarantees that overlapping ingestions become
+		// visible in the order in which DB.Ingest (or one of its variants) was
+		// called, rather than in the order in which the ingestions are assigned
+		// sequence numbers, which depends on the time taken to load the
+		// ingested sstables. An ingestion waits for the ingestions called
+		// before it to be sequenced if they overlap it, or if their bounds are
+		// not yet known, so the ingestions of a replication log can be applied
+		// concurrently while preserving the order of the log.
+		StrictIngestOrder bool
+
 		// PointTombstoneWeight is a float in the range [0, +inf) used to weight the
 		// point tombstone heuristics during compaction picking.
 		//
@@ -640,11 +1109,26 @@ type Options struct {
 		// sstables, and does not start rewriting existing sstables.
 		RequiredInPlaceValueBound UserKeyPrefixBound
 
+		// ValueSeparation configures the separation of large values into blob
+		// files by flushes and compactions. Value separation is disabled by
+		// default. See ValueSeparationOptions.
+		ValueSeparation ValueSeparationOptions
+
 		// DisableIngestAsFlushable disables lazy ingestion of sstables through
 		// a WAL write and memtable rotation. Only effectual if the the format
 		// major version is at least `FormatFlushableIngest`.
 		DisableIngestAsFlushable func() bool
 
+		// EnableIngestCompactionHints enables the read compactions queued for
+		// the key ranges of newly ingested sstables, and of the virtual
+		// sstables left at the boundaries of excised ranges, which consolidate
+		// these sstables and the sstables overlapping them into the lower
+		// levels of the LSM. Like other read compactions, the hints are
+		// disabled by a negative ReadSamplingMultiplier.
+		//
+		// By default, this value is false.
+		EnableIngestCompactionHints bool
+
 		// SharedStorage is a second FS-like storage medium that can be shared
 		// between multiple Pebble instances. It is used to store sstables only, and
 		// is managed by objstorage.Provider. Each sstable might only be written to
@@ -654,6 +1138,98 @@ type Options struct {
 		// be reading this file. This FS is expected to have slower read/write
 		// performance than the default FS above.
 		SharedStorage shared.Storage
+
+		// SharedExternalCache is an optional cache tier for the blocks of the
+		// sstables on SharedStorage, such as a memcached or redis deployment
+		// (see shared.NewRemoteCache). It is consulted on block cache misses
+		// before reading from SharedStorage, and can be shared by the Pebble
+		// instances reading the same sstables to keep it warm for all of them.
+		SharedExternalCache shared.Cache
+
+		// SecondaryCacheSizeBytes, if positive, is the size of a persistent
+		// cache of the blocks of the sstables on SharedStorage, stored on the
+		// local filesystem. It is consulted on block cache misses before
+		// SharedExternalCache and SharedStorage, and survives restarts and
+		// crashes. Blocks are evicted at random once the cache is full. The
+		// size must be at least 1MB per shard of the cache, which has
+		// 2*GOMAXPROCS shards.
+		SecondaryCacheSizeBytes int64
+
+		// SecondaryCacheDir is the directory of the secondary cache (see
+		// SecondaryCacheSizeBytes), such as a directory on an instance-local
+		// SSD. If empty, the cache is stored in the directory of the sstables.
+		SecondaryCacheDir string
+
+		// WALStripeDirs, if non-empty, stripes the WAL across the WAL directory
+		// (see WALDir) and these directories, which are intended to be on
+		// independent devices: each WAL is written to one file per directory,
+		// and syncing the WAL syncs the files in parallel. This increases the
+		// throughput of synced writes beyond what a single device can sustain.
+		// The order of the records of the WAL is preserved on replay.
+		//
+		// The directories are positional: a WAL striped across N files requires
+		// at least N-1 directories to be replayed, the same as when it was
+		// written. WALs written without striping are replayed regardless, so
+		// striping may be enabled for an existing store, but not disabled until
+		// the striped WALs are obsolete. Checkpoints copy the WALs unstriped.
+		// Striping requires format major version FormatStripedWAL or later.
+		WALStripeDirs []string
+
+		// SalvageWAL, if true, makes Open replay the intact records that follow
+		// a corrupt chunk of a WAL, resynchronizing at the next intact record,
+		// instead of treating the corruption as the end of the WAL. Each
+		// corruption followed by intact records is reported to
+		// EventListener.WALSalvaged with the sequence numbers of the batches
+		// lost. A corruption at the tail of a WAL, as left by a crash, ends the
+		// WAL as usual. Salvaging may replay batches that were committed after
+		// the lost ones, which the application must be able to tolerate.
+		SalvageWAL bool
+
+		// EncryptionKeyManager, if set, enables block-level encryption of the
+		// sstables written by the DB, and is used to retrieve the data keys of
+		// encrypted sstables when they are read. Unlike encryption performed by
+		// a vfs.FS wrapper, sstables remain encrypted when they reside on shared
+		// storage. Encryption requires format major version FormatTableFeatures
+		// or later. See sstable.EncryptionKeyManager for details.
+		EncryptionKeyManager sstable.EncryptionKeyManager
+
+		// ValueChecksums, if true, stores a checksum with each value written
+		// to an sstable, which is verified when the value is read, including
+		// values stored in value blocks. Unlike block checksums, which are
+		// verified when a block is read from storage, value checksums detect
+		// values corrupted in memory, eg, in the block cache. Enabling value
+		// checksums adds 4 bytes to each value and the cost of verifying the
+		// checksum to each read of a value, and only applies to the sstables
+		// written after enabling them. Value checksums require format major
+		// version FormatTableFeatures or later. See
+		// sstable.WriterOptions.ValueChecksums.
+		ValueChecksums bool
+
+		// VerifyCompactions, if true, reconciles the point keys read by each
+		// flush and compaction with the keys it writes and the keys it drops,
+		// by kind and by reason (eg, shadowed by a newer version, deleted by a
+		// range deletion or elided tombstone), before the outputs are installed
+		// and the inputs deleted. A mismatch, which indicates a bug in the
+		// compaction logic or corrupted memory, fails the flush or compaction
+		// with a corruption error and is reported to
+		// EventListener.CompactionVerificationFailed. Verification adds the
+		// cost of hashing each key read by flushes and compactions.
+		VerifyCompactions bool
+
+		// TTL configures the expiration of point keys, which are hidden from
+		// reads once expired, and dropped by compactions. TTL is disabled by
+		// default. See TTLOptions for details.
+		TTL TTLOptions
+
+		// CompactionFilter, if set, is invoked by flushes and compactions for
+		// each point key written with Set, and may remove the key or rewrite
+		// its value. See CompactionFilter for details.
+		CompactionFilter CompactionFilter
+
+		// Timestamps configures the garbage collection of the old versions of
+		// the keys with timestamps, for a Comparer with CompareTimestamps. See
+		// TimestampOptions for details.
+		Timestamps TimestampOptions
 	}
 
 	// Filters is a map from filter policy name to filter policy. It is used for
@@ -825,6 +1401,19 @@ type Options struct {
 	// and lives for the lifetime of the table.
 	TablePropertyCollectors []func() TablePropertyCollector
 
+	// Tracer is used to trace Gets, the block reads of Gets and iterators,
+	// flushes and compactions with spans, so that slow operations can be
+	// attributed to the sstables, levels and blocks they read. The spans of
+	// Gets and block reads are children of the span of the context passed to
+	// DB.GetWithContext or DB.NewIterWithContext, and are only started if
+	// Tracer.IsTracingEnabled returns true for that context. Flushes and
+	// compactions are traced with root spans. The blocks read by flushes and
+	// compactions aren't traced individually, as they read every block of
+	// their inputs.
+	//
+	// The default tracer does no tracing.
+	Tracer Tracer
+
 	// BlockPropertyCollectors is a list of BlockPropertyCollector creation
 	// functions. A new BlockPropertyCollector is created for each sstable
 	// built and lives for the lifetime of writing that table.
@@ -846,6 +1435,14 @@ type Options struct {
 	// (i.e. the directory passed to pebble.Open).
 	WALDir string
 
+	// TableDir specifies the directory to store sstables in. If empty (the
+	// default), sstables will be stored in the directory passed to pebble.Open.
+	// The MANIFEST, OPTIONS and other metadata files are always stored in the
+	// directory passed to pebble.Open, so setting TableDir (and WALDir) allows
+	// the metadata, the WALs and the sstables of a DB to reside on different
+	// devices. The directory must reside on the DB's filesystem (Options.FS).
+	TableDir string
+
 	// WALMinSyncInterval is the minimum duration between syncs of the WAL. If
 	// WAL syncs are requested faster than this interval, they will be
 	// artificially delayed. Introducing a small artificial delay (500us) between
@@ -991,6 +1588,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Logger == nil {
 		o.Logger = DefaultLogger
 	}
+	if o.Tracer == nil {
+		o.Tracer = NoopTracer{}
+	}
 	if o.EventListener == nil {
 		o.EventListener = &EventListener{}
 	}
@@ -1037,15 +1637,27 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Experimental.ReadSamplingMultiplier == 0 {
 		o.Experimental.ReadSamplingMultiplier = 1 << 4
 	}
+	if o.Experimental.SmallFileCompaction.SizeThresholdPercent <= 0 {
+		o.Experimental.SmallFileCompaction.SizeThresholdPercent = defaultSmallFileSizeThresholdPercent
+	}
 	if o.Experimental.TableCacheShards <= 0 {
 		o.Experimental.TableCacheShards = runtime.GOMAXPROCS(0)
 	}
+	if o.Experimental.TTL.Now == nil {
+		o.Experimental.TTL.Now = time.Now
+	}
 	if o.Experimental.CPUWorkPermissionGranter == nil {
 		o.Experimental.CPUWorkPermissionGranter = defaultCPUWorkGranter{}
 	}
+	if o.Experimental.BackgroundWorkScheduler == nil {
+		o.Experimental.BackgroundWorkScheduler = defaultBackgroundWorkScheduler{}
+	}
 	if o.Experimental.PointTombstoneWeight == 0 {
 		o.Experimental.PointTombstoneWeight = 1
 	}
+	if o.Experimental.L0SublevelScoreWeight <= 0 {
+		o.Experimental.L0SublevelScoreWeight = defaultL0SublevelScoreWeight
+	}
 
 	if o.Experimental.MultiLevelCompactionHueristic == nil {
 		o.Experimental.MultiLevelCompactionHueristic = NoMultiLevel{}
@@ -1122,6 +1734,25 @@ func (o *Options) Clone() *Options {
 	return n
 }
 
+// sameFilters returns true if the tables written with the receiver's level
+// options have the same filters as the tables written with the given level
+// options.
+func (o LevelOptions) sameFilters(other LevelOptions) bool {
+	if o.FilterPolicy == nil || other.FilterPolicy == nil {
+		return o.FilterPolicy == nil && other.FilterPolicy == nil
+	}
+	if o.FilterType != other.FilterType || o.FilterPolicy.Name() != other.FilterPolicy.Name() {
+		return false
+	}
+	// Filter policies of the same name may still differ in their parameters,
+	// eg, the bits per key of a bloom filter. Policies that can't be compared
+	// are assumed to be the same.
+	if t := reflect.TypeOf(o.FilterPolicy); t == reflect.TypeOf(other.FilterPolicy) && t.Comparable() {
+		return o.FilterPolicy == other.FilterPolicy
+	}
+	return true
+}
+
 func filterPolicyName(p FilterPolicy) string {
 	if p == nil {
 		return "none"
@@ -1141,39 +1772,105 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  pebble_version=0.1\n")
 	fmt.Fprintf(&buf, "\n")
 	fmt.Fprintf(&buf, "[Options]\n")
+	if o.Experimental.BulkCommitConcurrency != 0 {
+		fmt.Fprintf(&buf, "  bulk_commit_concurrency=%d\n", o.Experimental.BulkCommitConcurrency)
+	}
+	if o.Experimental.BulkScanCacheSize != 0 {
+		fmt.Fprintf(&buf, "  bulk_scan_cache_size=%d\n", o.Experimental.BulkScanCacheSize)
+	}
 	fmt.Fprintf(&buf, "  bytes_per_sync=%d\n", o.BytesPerSync)
 	fmt.Fprintf(&buf, "  cache_size=%d\n", cacheSize)
 	fmt.Fprintf(&buf, "  cleaner=%s\n", o.Cleaner)
 	fmt.Fprintf(&buf, "  compaction_debt_concurrency=%d\n", o.Experimental.CompactionDebtConcurrency)
+	if o.Experimental.CompressionConcurrency != 0 {
+		fmt.Fprintf(&buf, "  compression_concurrency=%d\n", o.Experimental.CompressionConcurrency)
+	}
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
+	if o.Experimental.ConsistencyCheck != ConsistencyCheckFast {
+		fmt.Fprintf(&buf, "  consistency_check=%s\n", o.Experimental.ConsistencyCheck)
+	}
+	if o.Experimental.ConsistencyCheckSampleRate != 0 {
+		fmt.Fprintf(&buf, "  consistency_check_sample_rate=%f\n", o.Experimental.ConsistencyCheckSampleRate)
+	}
 	fmt.Fprintf(&buf, "  disable_wal=%t\n", o.DisableWAL)
 	if o.Experimental.DisableIngestAsFlushable != nil && o.Experimental.DisableIngestAsFlushable() {
 		fmt.Fprintf(&buf, "  disable_ingest_as_flushable=%t\n", true)
 	}
+	if o.Experimental.EnableIngestCompactionHints {
+		fmt.Fprintf(&buf, "  enable_ingest_compaction_hints=true\n")
+	}
 	fmt.Fprintf(&buf, "  flush_delay_delete_range=%s\n", o.FlushDelayDeleteRange)
 	fmt.Fprintf(&buf, "  flush_delay_range_key=%s\n", o.FlushDelayRangeKey)
+	if o.Experimental.FlushRangeDelCoalesceThreshold != 0 {
+		fmt.Fprintf(&buf, "  flush_range_del_coalesce_threshold=%d\n", o.Experimental.FlushRangeDelCoalesceThreshold)
+	}
 	fmt.Fprintf(&buf, "  flush_split_bytes=%d\n", o.FlushSplitBytes)
 	fmt.Fprintf(&buf, "  format_major_version=%d\n", o.FormatMajorVersion)
 	fmt.Fprintf(&buf, "  l0_compaction_concurrency=%d\n", o.Experimental.L0CompactionConcurrency)
 	fmt.Fprintf(&buf, "  l0_compaction_file_threshold=%d\n", o.L0CompactionFileThreshold)
 	fmt.Fprintf(&buf, "  l0_compaction_threshold=%d\n", o.L0CompactionThreshold)
+	if o.Experimental.L0ScoreSmoothing != 0 {
+		fmt.Fprintf(&buf, "  l0_score_smoothing=%f\n", o.Experimental.L0ScoreSmoothing)
+	}
 	fmt.Fprintf(&buf, "  l0_stop_writes_threshold=%d\n", o.L0StopWritesThreshold)
+	if w := o.Experimental.L0SublevelScoreWeight; w != 0 && w != defaultL0SublevelScoreWeight {
+		fmt.Fprintf(&buf, "  l0_sublevel_score_weight=%f\n", o.Experimental.L0SublevelScoreWeight)
+	}
 	fmt.Fprintf(&buf, "  lbase_max_bytes=%d\n", o.LBaseMaxBytes)
 	if o.Experimental.LevelMultiplier != defaultLevelMultiplier {
 		fmt.Fprintf(&buf, "  level_multiplier=%d\n", o.Experimental.LevelMultiplier)
 	}
+	if o.Experimental.LockFencing {
+		fmt.Fprintf(&buf, "  lock_fencing=true\n")
+	}
+	if o.Experimental.LookupCacheSize != 0 {
+		fmt.Fprintf(&buf, "  lookup_cache_size=%d\n", o.Experimental.LookupCacheSize)
+	}
 	fmt.Fprintf(&buf, "  max_concurrent_compactions=%d\n", o.MaxConcurrentCompactions())
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
+	if o.Experimental.MaxTablesPerGet != 0 {
+		fmt.Fprintf(&buf, "  max_tables_per_get=%d\n", o.Experimental.MaxTablesPerGet)
+	}
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
 	fmt.Fprintf(&buf, "  min_deletion_rate=%d\n", o.Experimental.MinDeletionRate)
+	if o.Experimental.MinDeletionFileRate != 0 {
+		fmt.Fprintf(&buf, "  min_deletion_file_rate=%d\n", o.Experimental.MinDeletionFileRate)
+	}
 	fmt.Fprintf(&buf, "  merger=%s\n", o.Merger.Name)
 	fmt.Fprintf(&buf, "  point_tombstone_weight=%f\n", o.Experimental.PointTombstoneWeight)
+	if o.Experimental.PrecheckTableFilters {
+		fmt.Fprintf(&buf, "  precheck_table_filters=true\n")
+	}
+	if o.Experimental.QueueDeletionDetection {
+		fmt.Fprintf(&buf, "  queue_deletion_detection=true\n")
+	}
 	fmt.Fprintf(&buf, "  read_compaction_rate=%d\n", o.Experimental.ReadCompactionRate)
 	fmt.Fprintf(&buf, "  read_sampling_multiplier=%d\n", o.Experimental.ReadSamplingMultiplier)
+	if o.Experimental.SalvageWAL {
+		fmt.Fprintf(&buf, "  salvage_wal=true\n")
+	}
+	if o.Experimental.SecondaryCacheDir != "" {
+		fmt.Fprintf(&buf, "  secondary_cache_dir=%s\n", o.Experimental.SecondaryCacheDir)
+	}
+	if o.Experimental.SecondaryCacheSizeBytes != 0 {
+		fmt.Fprintf(&buf, "  secondary_cache_size_bytes=%d\n", o.Experimental.SecondaryCacheSizeBytes)
+	}
+	if o.Experimental.SmallFileCompaction.MinFiles != 0 {
+		fmt.Fprintf(&buf, "  small_file_compaction_min_files=%d\n",
+			o.Experimental.SmallFileCompaction.MinFiles)
+		fmt.Fprintf(&buf, "  small_file_compaction_size_threshold_percent=%d\n",
+			o.Experimental.SmallFileCompaction.SizeThresholdPercent)
+	}
+	if o.Experimental.StrictIngestOrder {
+		fmt.Fprintf(&buf, "  strict_ingest_order=true\n")
+	}
 	fmt.Fprintf(&buf, "  strict_wal_tail=%t\n", o.private.strictWALTail)
 	fmt.Fprintf(&buf, "  table_cache_shards=%d\n", o.Experimental.TableCacheShards)
+	if o.TableDir != "" {
+		fmt.Fprintf(&buf, "  table_dir=%s\n", o.TableDir)
+	}
 	fmt.Fprintf(&buf, "  table_property_collectors=[")
 	for i := range o.TablePropertyCollectors {
 		if i > 0 {
@@ -1185,6 +1882,15 @@ func (o *Options) String() string {
 	}
 	fmt.Fprintf(&buf, "]\n")
 	fmt.Fprintf(&buf, "  validate_on_ingest=%t\n", o.Experimental.ValidateOnIngest)
+	if o.Experimental.ValidateOnWriteSampleRate != 0 {
+		fmt.Fprintf(&buf, "  validate_on_write_sample_rate=%f\n", o.Experimental.ValidateOnWriteSampleRate)
+	}
+	if o.Experimental.ValueChecksums {
+		fmt.Fprintf(&buf, "  value_checksums=true\n")
+	}
+	if o.Experimental.VerifyCompactions {
+		fmt.Fprintf(&buf, "  verify_compactions=true\n")
+	}
 	fmt.Fprintf(&buf, "  wal_dir=%s\n", o.WALDir)
 	fmt.Fprintf(&buf, "  wal_bytes_per_sync=%d\n", o.WALBytesPerSync)
 	fmt.Fprintf(&buf, "  max_writer_concurrency=%d\n", o.Experimental.MaxWriterConcurrency)
@@ -1218,6 +1924,15 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "  filter_type=%s\n", l.FilterType)
 		fmt.Fprintf(&buf, "  index_block_size=%d\n", l.IndexBlockSize)
 		fmt.Fprintf(&buf, "  target_file_size=%d\n", l.TargetFileSize)
+		if l.Zstd.Level != 0 {
+			fmt.Fprintf(&buf, "  zstd_level=%d\n", l.Zstd.Level)
+		}
+		if l.Zstd.WindowLog != 0 {
+			fmt.Fprintf(&buf, "  zstd_window_log=%d\n", l.Zstd.WindowLog)
+		}
+		if l.CompressionDictSize != 0 {
+			fmt.Fprintf(&buf, "  compression_dict_size=%d\n", l.CompressionDictSize)
+		}
 	}
 
 	return buf.String()
@@ -1308,6 +2023,10 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 		case section == "Options":
 			var err error
 			switch key {
+			case "bulk_commit_concurrency":
+				o.Experimental.BulkCommitConcurrency, err = strconv.Atoi(value)
+			case "bulk_scan_cache_size":
+				o.Experimental.BulkScanCacheSize, err = strconv.ParseInt(value, 10, 64)
 			case "bytes_per_sync":
 				o.BytesPerSync, err = strconv.Atoi(value)
 			case "cache_size":
@@ -1341,8 +2060,14 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Comparer, err = hooks.NewComparer(value)
 					}
 				}
+			case "consistency_check":
+				o.Experimental.ConsistencyCheck, err = parseConsistencyCheckLevel(value)
+			case "consistency_check_sample_rate":
+				o.Experimental.ConsistencyCheckSampleRate, err = strconv.ParseFloat(value, 64)
 			case "compaction_debt_concurrency":
 				o.Experimental.CompactionDebtConcurrency, err = strconv.Atoi(value)
+			case "compression_concurrency":
+				o.Experimental.CompressionConcurrency, err = strconv.Atoi(value)
 			case "delete_range_flush_delay":
 				// NB: This is a deprecated serialization of the
 				// `flush_delay_delete_range`.
@@ -1357,6 +2082,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				if err == nil {
 					o.Experimental.DisableIngestAsFlushable = func() bool { return v }
 				}
+			case "enable_ingest_compaction_hints":
+				o.Experimental.EnableIngestCompactionHints, err = strconv.ParseBool(value)
 			case "disable_lazy_combined_iteration":
 				o.private.disableLazyCombinedIteration, err = strconv.ParseBool(value)
 			case "disable_wal":
@@ -1365,6 +2092,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.FlushDelayDeleteRange, err = time.ParseDuration(value)
 			case "flush_delay_range_key":
 				o.FlushDelayRangeKey, err = time.ParseDuration(value)
+			case "flush_range_del_coalesce_threshold":
+				o.Experimental.FlushRangeDelCoalesceThreshold, err = strconv.Atoi(value)
 			case "flush_split_bytes":
 				o.FlushSplitBytes, err = strconv.ParseInt(value, 10, 64)
 			case "format_major_version":
@@ -1386,14 +2115,22 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.L0CompactionFileThreshold, err = strconv.Atoi(value)
 			case "l0_compaction_threshold":
 				o.L0CompactionThreshold, err = strconv.Atoi(value)
+			case "l0_score_smoothing":
+				o.Experimental.L0ScoreSmoothing, err = strconv.ParseFloat(value, 64)
 			case "l0_stop_writes_threshold":
 				o.L0StopWritesThreshold, err = strconv.Atoi(value)
 			case "l0_sublevel_compactions":
 				// Do nothing; option existed in older versions of pebble.
+			case "l0_sublevel_score_weight":
+				o.Experimental.L0SublevelScoreWeight, err = strconv.ParseFloat(value, 64)
 			case "lbase_max_bytes":
 				o.LBaseMaxBytes, err = strconv.ParseInt(value, 10, 64)
 			case "level_multiplier":
 				o.Experimental.LevelMultiplier, err = strconv.Atoi(value)
+			case "lock_fencing":
+				o.Experimental.LockFencing, err = strconv.ParseBool(value)
+			case "lookup_cache_size":
+				o.Experimental.LookupCacheSize, err = strconv.ParseInt(value, 10, 64)
 			case "max_concurrent_compactions":
 				var concurrentCompactions int
 				concurrentCompactions, err = strconv.Atoi(value)
@@ -1406,6 +2143,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.MaxManifestFileSize, err = strconv.ParseInt(value, 10, 64)
 			case "max_open_files":
 				o.MaxOpenFiles, err = strconv.Atoi(value)
+			case "max_tables_per_get":
+				o.Experimental.MaxTablesPerGet, err = strconv.Atoi(value)
 			case "mem_table_size":
 				o.MemTableSize, err = strconv.Atoi(value)
 			case "mem_table_stop_writes_threshold":
@@ -1415,11 +2154,17 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				// may be meaningful again eventually.
 			case "min_deletion_rate":
 				o.Experimental.MinDeletionRate, err = strconv.Atoi(value)
+			case "min_deletion_file_rate":
+				o.Experimental.MinDeletionFileRate, err = strconv.Atoi(value)
 			case "min_flush_rate":
 				// Do nothing; option existed in older versions of pebble, and
 				// may be meaningful again eventually.
+			case "precheck_table_filters":
+				o.Experimental.PrecheckTableFilters, err = strconv.ParseBool(value)
 			case "point_tombstone_weight":
 				o.Experimental.PointTombstoneWeight, err = strconv.ParseFloat(value, 64)
+			case "strict_ingest_order":
+				o.Experimental.StrictIngestOrder, err = strconv.ParseBool(value)
 			case "strict_wal_tail":
 				o.private.strictWALTail, err = strconv.ParseBool(value)
 			case "merger":
@@ -1433,10 +2178,22 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Merger, err = hooks.NewMerger(value)
 					}
 				}
+			case "queue_deletion_detection":
+				o.Experimental.QueueDeletionDetection, err = strconv.ParseBool(value)
 			case "read_compaction_rate":
 				o.Experimental.ReadCompactionRate, err = strconv.ParseInt(value, 10, 64)
 			case "read_sampling_multiplier":
 				o.Experimental.ReadSamplingMultiplier, err = strconv.ParseInt(value, 10, 64)
+			case "salvage_wal":
+				o.Experimental.SalvageWAL, err = strconv.ParseBool(value)
+			case "secondary_cache_dir":
+				o.Experimental.SecondaryCacheDir = value
+			case "secondary_cache_size_bytes":
+				o.Experimental.SecondaryCacheSizeBytes, err = strconv.ParseInt(value, 10, 64)
+			case "small_file_compaction_min_files":
+				o.Experimental.SmallFileCompaction.MinFiles, err = strconv.Atoi(value)
+			case "small_file_compaction_size_threshold_percent":
+				o.Experimental.SmallFileCompaction.SizeThresholdPercent, err = strconv.Atoi(value)
 			case "table_cache_shards":
 				o.Experimental.TableCacheShards, err = strconv.Atoi(value)
 			case "table_format":
@@ -1446,10 +2203,18 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				default:
 					return errors.Errorf("pebble: unknown table format: %q", errors.Safe(value))
 				}
+			case "table_dir":
+				o.TableDir = value
 			case "table_property_collectors":
 				// TODO(peter): set o.TablePropertyCollectors
 			case "validate_on_ingest":
 				o.Experimental.ValidateOnIngest, err = strconv.ParseBool(value)
+			case "validate_on_write_sample_rate":
+				o.Experimental.ValidateOnWriteSampleRate, err = strconv.ParseFloat(value, 64)
+			case "value_checksums":
+				o.Experimental.ValueChecksums, err = strconv.ParseBool(value)
+			case "verify_compactions":
+				o.Experimental.VerifyCompactions, err = strconv.ParseBool(value)
 			case "wal_dir":
 				o.WALDir = value
 			case "wal_bytes_per_sync":
@@ -1521,6 +2286,12 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				l.IndexBlockSize, err = strconv.Atoi(value)
 			case "target_file_size":
 				l.TargetFileSize, err = strconv.ParseInt(value, 10, 64)
+			case "zstd_level":
+				l.Zstd.Level, err = strconv.Atoi(value)
+			case "zstd_window_log":
+				l.Zstd.WindowLog, err = strconv.Atoi(value)
+			case "compression_dict_size":
+				l.CompressionDictSize, err = strconv.Atoi(value)
 			default:
 				if hooks != nil && hooks.SkipUnknown != nil && hooks.SkipUnknown(section+"."+key, value) {
 					return nil
@@ -1582,6 +2353,14 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "L0CompactionConcurrency (%d) must be >= 1\n",
 			o.Experimental.L0CompactionConcurrency)
 	}
+	if len(o.Experimental.WALStripeDirs) >= maxWALStripes {
+		fmt.Fprintf(&buf, "WALStripeDirs (%d) must have fewer than %d directories\n",
+			len(o.Experimental.WALStripeDirs), maxWALStripes)
+	}
+	if o.Experimental.L0ScoreSmoothing < 0 || o.Experimental.L0ScoreSmoothing >= 1 {
+		fmt.Fprintf(&buf, "L0ScoreSmoothing (%f) must be in the range [0, 1)\n",
+			o.Experimental.L0ScoreSmoothing)
+	}
 	if o.L0StopWritesThreshold < o.L0CompactionThreshold {
 		fmt.Fprintf(&buf, "L0StopWritesThreshold (%d) must be >= L0CompactionThreshold (%d)\n",
 			o.L0StopWritesThreshold, o.L0CompactionThreshold)
@@ -1598,6 +2377,51 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "FormatMajorVersion (%d) must be <= %d\n",
 			o.FormatMajorVersion, FormatNewest)
 	}
+	for i, s := range o.Experimental.ShortLivedSpans {
+		if o.Comparer.Compare(s.Start, s.End) >= 0 {
+			fmt.Fprintf(&buf, "ShortLivedSpan start (%s) must be < end (%s)\n",
+				o.Comparer.FormatKey(s.Start), o.Comparer.FormatKey(s.End))
+		}
+		if i > 0 && o.Comparer.Compare(o.Experimental.ShortLivedSpans[i-1].End, s.Start) > 0 {
+			fmt.Fprintf(&buf, "ShortLivedSpans must be sorted and nonoverlapping\n")
+		}
+	}
+	for _, r := range o.Experimental.DeletionCompactionRanges {
+		if r.ThresholdPercent < 1 || r.ThresholdPercent > 100 {
+			fmt.Fprintf(&buf, "DeletionCompactionRange threshold (%d) must be in the range [1, 100]\n",
+				r.ThresholdPercent)
+		}
+		if o.Comparer.Compare(r.Start, r.End) >= 0 {
+			fmt.Fprintf(&buf, "DeletionCompactionRange start (%s) must be < end (%s)\n",
+				o.Comparer.FormatKey(r.Start), o.Comparer.FormatKey(r.End))
+		}
+	}
+	if o.Experimental.LookupCacheSize > 0 && o.Experimental.TTL.Expiration != nil {
+		fmt.Fprintf(&buf, "LookupCacheSize is incompatible with TTL\n")
+	}
+	if ts := o.Experimental.Timestamps; (ts.Watermark != nil || ts.Filter != nil) &&
+		o.Comparer.CompareTimestamps == nil {
+		fmt.Fprintf(&buf, "Timestamps require a Comparer with CompareTimestamps\n")
+	}
+	if sfc := o.Experimental.SmallFileCompaction; sfc.MinFiles == 1 || sfc.MinFiles < 0 {
+		fmt.Fprintf(&buf, "SmallFileCompaction.MinFiles (%d) must be 0 or >= 2\n", sfc.MinFiles)
+	}
+	if p := o.Experimental.SmallFileCompaction.SizeThresholdPercent; p > 100 {
+		fmt.Fprintf(&buf, "SmallFileCompaction.SizeThresholdPercent (%d) must be <= 100\n", p)
+	}
+	if r := o.Experimental.ConsistencyCheckSampleRate; r < 0 || r > 1 {
+		fmt.Fprintf(&buf, "ConsistencyCheckSampleRate (%f) must be in the range [0, 1]\n", r)
+	}
+	if r := o.Experimental.ValidateOnWriteSampleRate; r < 0 || r > 1 {
+		fmt.Fprintf(&buf, "ValidateOnWriteSampleRate (%f) must be in the range [0, 1]\n", r)
+	}
+	for i := range o.Levels {
+		if l := &o.Levels[i]; l.Compression == ZstdCompression {
+			if err := l.Zstd.Validate(); err != nil {
+				fmt.Fprintf(&buf, "Level %d: %s\n", i, err)
+			}
+		}
+	}
 	if o.TableCache != nil && o.Cache != o.TableCache.cache {
 		fmt.Fprintf(&buf, "underlying cache in the TableCache and the Cache dont match\n")
 	}
@@ -1619,6 +2443,31 @@ func (o *Options) MakeReaderOptions() sstable.ReaderOptions {
 			readerOpts.MergerName = o.Merger.Name
 		}
 		readerOpts.LoggerAndTracer = o.LoggerAndTracer
+		readerOpts.Tracer = o.Tracer
+		readerOpts.EncryptionKeyManager = o.Experimental.EncryptionKeyManager
+		if fv := o.Experimental.FilterVerification; fv != nil {
+			// The options are copied field by field, as each copy has its own
+			// verification queue.
+			v := &FilterVerificationOptions{
+				SampleRate:              fv.SampleRate,
+				QueueSize:               fv.QueueSize,
+				BlockPropertyCollectors: fv.BlockPropertyCollectors,
+				OnDiscrepancy:           fv.OnDiscrepancy,
+			}
+			if v.BlockPropertyCollectors == nil {
+				v.BlockPropertyCollectors = o.BlockPropertyCollectors
+			}
+			if v.OnDiscrepancy == nil {
+				logger := o.Logger
+				if logger == nil {
+					logger = DefaultLogger
+				}
+				v.OnDiscrepancy = func(d FilterDiscrepancy) {
+					logger.Infof("pebble: filter verification failed: %s", d)
+				}
+			}
+			readerOpts.FilterVerification = v
+		}
 	}
 	return readerOpts
 }
@@ -1636,6 +2485,9 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 		}
 		writerOpts.TablePropertyCollectors = o.TablePropertyCollectors
 		writerOpts.BlockPropertyCollectors = o.BlockPropertyCollectors
+		writerOpts.EncryptionKeyManager = o.Experimental.EncryptionKeyManager
+		writerOpts.ValueChecksums = o.Experimental.ValueChecksums
+		writerOpts.CompressionConcurrency = o.Experimental.CompressionConcurrency
 	}
 	if format >= sstable.TableFormatPebblev3 {
 		writerOpts.ShortAttributeExtractor = o.Experimental.ShortAttributeExtractor
@@ -1646,6 +2498,8 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 	writerOpts.BlockSize = levelOpts.BlockSize
 	writerOpts.BlockSizeThreshold = levelOpts.BlockSizeThreshold
 	writerOpts.Compression = levelOpts.Compression
+	writerOpts.Zstd = levelOpts.Zstd
+	writerOpts.CompressionDict = levelOpts.CompressionDict
 	writerOpts.FilterPolicy = levelOpts.FilterPolicy
 	writerOpts.FilterType = levelOpts.FilterType
 	writerOpts.IndexBlockSize = levelOpts.IndexBlockSize
@@ -0,0 +1,202 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/pebble/bloom"
+
+// The presets below return Options tuned for common hardware and workloads, as
+// a starting point that is better than the defaults, which are conservative
+// and tuned for tests more than for production. The options of a preset are
+// coherent with each other, eg, the L0 thresholds with the memtable size, so
+// changes to a preset should be made with care. Options.Diff lists the
+// changes made to a preset.
+//
+// The presets don't configure the block cache, which should be sized according
+// to the memory available to the process and shared by the DBs of the process,
+// nor the FS, Logger and EventListener.
+
+// OptionsForCloudSSD returns Options tuned for network-attached SSDs, such as
+// cloud block storage volumes, which have high throughput but a higher latency
+// than local SSDs, and limits on the IOPS and throughput that cause stalls when
+// exceeded. The preset is tuned for a mixed read and write workload:
+//
+//   - Large memtables and aggressive L0 compactions keep the L0 read
+//     amplification low, while the L0 stop threshold is high enough that
+//     bursts of writes are slowed down rather than stalled.
+//   - Bloom filters avoid the reads of data blocks for missing keys, in all
+//     but the last level, whose filters would be large and rarely useful.
+//   - Deletions of obsolete files are paced so that they don't exceed the
+//     throughput limits of the volume, and sstables are synced incrementally.
+func OptionsForCloudSSD() *Options {
+	opts := &Options{
+		BytesPerSync:                512 << 10, // 512 KB
+		L0CompactionThreshold:       2,
+		L0CompactionFileThreshold:   500,
+		L0StopWritesThreshold:       1000,
+		LBaseMaxBytes:               64 << 20, // 64 MB
+		MaxConcurrentCompactions:    func() int { return 3 },
+		MemTableSize:                64 << 20, // 64 MB
+		MemTableStopWritesThreshold: 4,
+	}
+	opts.Experimental.MinDeletionRate = 128 << 20 // 128 MB/s
+	opts.Experimental.ReadSamplingMultiplier = 16
+	opts.Levels = make([]LevelOptions, numLevels)
+	for i := range opts.Levels {
+		l := &opts.Levels[i]
+		l.BlockSize = 32 << 10       // 32 KB
+		l.IndexBlockSize = 256 << 10 // 256 KB
+		l.Compression = SnappyCompression
+		if i < numLevels-1 {
+			l.FilterPolicy = bloom.FilterPolicy(10)
+			l.FilterType = TableFilter
+		}
+		l.TargetFileSize = 2 << 20 // 2 MB
+		if i > 0 {
+			l.TargetFileSize = opts.Levels[i-1].TargetFileSize * 2
+		}
+	}
+	return opts.EnsureDefaults()
+}
+
+// OptionsForHDDArchive returns Options tuned for spinning disks storing data
+// that is written once, eg, in bulk, and read rarely. Disk seeks are the
+// scarcest resource, so the preset trades CPU and memory for fewer, larger
+// I/Os:
+//
+//   - Large blocks and sstables make reads and writes sequential, and
+//     compactions infrequent. A single compaction runs at a time, so that
+//     compactions don't compete for the disk head.
+//   - Zstandard compression minimizes the disk space and the bytes read.
+//   - Bloom filters in all levels avoid seeks for missing keys.
+//   - Read-triggered compactions are disabled, as reads are rare.
+func OptionsForHDDArchive() *Options {
+	opts := &Options{
+		BytesPerSync:                1 << 20, // 1 MB
+		L0CompactionThreshold:       4,
+		L0CompactionFileThreshold:   500,
+		L0StopWritesThreshold:       64,
+		LBaseMaxBytes:               512 << 20, // 512 MB
+		MaxConcurrentCompactions:    func() int { return 1 },
+		MemTableSize:                128 << 20, // 128 MB
+		MemTableStopWritesThreshold: 4,
+		WALBytesPerSync:             1 << 20, // 1 MB
+	}
+	opts.Experimental.ReadSamplingMultiplier = -1
+	opts.Levels = make([]LevelOptions, numLevels)
+	for i := range opts.Levels {
+		l := &opts.Levels[i]
+		l.BlockSize = 64 << 10       // 64 KB
+		l.IndexBlockSize = 256 << 10 // 256 KB
+		l.Compression = ZstdCompression
+		l.FilterPolicy = bloom.FilterPolicy(10)
+		l.FilterType = TableFilter
+		l.TargetFileSize = 32 << 20 // 32 MB
+		if i > 0 {
+			l.TargetFileSize = opts.Levels[i-1].TargetFileSize * 2
+		}
+	}
+	return opts.EnsureDefaults()
+}
+
+// OptionsForInMemoryCache returns Options tuned for a DB used as a cache,
+// whose data is disposable and whose working set fits in the block cache, or
+// whose FS is in memory (see vfs.NewMem). CPU is the scarcest resource:
+//
+//   - The WAL is disabled, as the data may be lost in a crash. Writes must
+//     use NoSync, and are only durable once flushed, eg, by DB.Flush.
+//   - Blocks aren't compressed, so that they're read without decompressing
+//     them, and are small, so that point reads decode few keys.
+//   - Bloom filters in all levels avoid the reads of data blocks for missing
+//     keys, which are common in caches.
+func OptionsForInMemoryCache() *Options {
+	opts := &Options{
+		DisableWAL:                  true,
+		L0CompactionThreshold:       4,
+		L0StopWritesThreshold:       32,
+		LBaseMaxBytes:               64 << 20, // 64 MB
+		MaxConcurrentCompactions:    func() int { return 2 },
+		MemTableSize:                32 << 20, // 32 MB
+		MemTableStopWritesThreshold: 4,
+	}
+	opts.Levels = make([]LevelOptions, numLevels)
+	for i := range opts.Levels {
+		l := &opts.Levels[i]
+		l.BlockSize = 4 << 10 // 4 KB
+		l.Compression = NoCompression
+		l.FilterPolicy = bloom.FilterPolicy(10)
+		l.FilterType = TableFilter
+		l.TargetFileSize = 4 << 20 // 4 MB
+		if i > 0 {
+			l.TargetFileSize = opts.Levels[i-1].TargetFileSize * 2
+		}
+	}
+	return opts.EnsureDefaults()
+}
+
+// OptionsDiff is an option whose value differs between two Options (see
+// Options.Diff).
+type OptionsDiff struct {
+	// Section and Key name the option as in the OPTIONS file (see
+	// Options.String), eg, "Options" and "mem_table_size", or "Level \"0\""
+	// and "block_size".
+	Section string
+	Key     string
+	// Value is the value of the option in the Options, and BaseValue its
+	// value in the Options they're compared with. A value is empty if the
+	// option isn't set, eg, because the Options have fewer levels.
+	Value     string
+	BaseValue string
+}
+
+// Diff returns the options whose values differ from their values in base, eg,
+// the changes made to a preset such as OptionsForCloudSSD. The options are
+// compared as they're written to the OPTIONS file (see Options.String), with
+// the defaults of the unset options, and are listed in the order in which they
+// are written. Options that aren't written to the OPTIONS file, such as the
+// FS, aren't compared.
+func (o *Options) Diff(base *Options) []OptionsDiff {
+	type option struct {
+		section, key string
+	}
+	parse := func(opts *Options) (map[option]string, []option) {
+		values := make(map[option]string)
+		var order []option
+		// Copy the level options, which EnsureDefaults modifies in place.
+		opts = opts.Clone()
+		opts.Levels = append([]LevelOptions(nil), opts.Levels...)
+		// Options.String can't fail to be parsed.
+		_ = parseOptions(opts.EnsureDefaults().String(), func(section, key, value string) error {
+			k := option{section, key}
+			values[k] = value
+			order = append(order, k)
+			return nil
+		})
+		return values, order
+	}
+	values, order := parse(o)
+	baseValues, baseOrder := parse(base)
+	for _, k := range baseOrder {
+		if _, ok := values[k]; !ok {
+			order = append(order, k)
+		}
+	}
+	var diffs []OptionsDiff
+	for _, k := range order {
+		if values[k] != baseValues[k] {
+			diffs = append(diffs, OptionsDiff{
+				Section:   k.section,
+				Key:       k.key,
+				Value:     values[k],
+				BaseValue: baseValues[k],
+			})
+		}
+	}
+	return diffs
+}
+
+// String implements fmt.Stringer.
+func (d OptionsDiff) String() string {
+	return d.Section + "." + d.Key + ": " + d.BaseValue + " -> " + d.Value
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionsPresets(t *testing.T) {
+	presets := map[string]func() *Options{
+		"cloud-ssd":       OptionsForCloudSSD,
+		"hdd-archive":     OptionsForHDDArchive,
+		"in-memory-cache": OptionsForInMemoryCache,
+	}
+	for name, preset := range presets {
+		t.Run(name, func(t *testing.T) {
+			opts := preset()
+			require.NoError(t, opts.Validate())
+			require.Empty(t, opts.Diff(preset()))
+			require.NotEmpty(t, opts.Diff(&Options{}))
+
+			// The preset round-trips through the OPTIONS file.
+			var parsed Options
+			require.NoError(t, parsed.Parse(opts.String(), &ParseHooks{
+				NewFilterPolicy: func(name string) (FilterPolicy, error) {
+					if name == "none" {
+						return nil, nil
+					}
+					return bloom.FilterPolicy(10), nil
+				},
+			}))
+			require.Empty(t, parsed.Diff(opts))
+
+			opts.FS = vfs.NewMem()
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			for i := 0; i < 100; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprint(i)), []byte("v"), NoSync))
+			}
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact([]byte("0"), []byte("a"), false /* parallelize */))
+			v, closer, err := d.Get([]byte("42"))
+			require.NoError(t, err)
+			require.Equal(t, "v", string(v))
+			require.NoError(t, closer.Close())
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+func TestOptionsDiff(t *testing.T) {
+	base := OptionsForCloudSSD()
+	opts := OptionsForCloudSSD()
+	opts.MemTableSize = 32 << 20
+	opts.Levels[6].Compression = ZstdCompression
+	opts.Experimental.VerifyCompactions = true
+
+	var diffs []string
+	for _, d := range opts.Diff(base) {
+		diffs = append(diffs, d.String())
+	}
+	require.Equal(t, []string{
+		"Options.mem_table_size: 67108864 -> 33554432",
+		"Options.verify_compactions:  -> true",
+		`Level "6".compression: Snappy -> ZSTD`,
+	}, diffs)
+
+	// Unset options are compared with their defaults, which depend on the
+	// options that are set.
+	opts = &Options{Levels: []LevelOptions{{BlockSize: 8 << 10}}}
+	diffs = diffs[:0]
+	for _, d := range opts.Diff(&Options{}) {
+		diffs = append(diffs, d.String())
+	}
+	require.Equal(t, []string{
+		`Level "0".block_size: 4096 -> 8192`,
+		`Level "0".index_block_size: 4096 -> 8192`,
+	}, diffs)
+	// The Options compared aren't modified.
+	require.Equal(t, LevelOptions{BlockSize: 8 << 10}, opts.Levels[0])
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -221,20 +222,44 @@ func TestOptionsParse(t *testing.T) {
 			opts.Comparer = c.comparer
 			opts.Merger = c.merger
 			opts.WALDir = "wal"
+			opts.TableDir = "tables"
 			opts.Levels = make([]LevelOptions, 3)
 			opts.Levels[0].BlockSize = 1024
 			opts.Levels[1].BlockSize = 2048
 			opts.Levels[2].BlockSize = 4096
+			opts.Levels[2].Compression = ZstdCompression
+			opts.Levels[2].Zstd = ZstdOptions{Level: 19, WindowLog: 20}
+			opts.Levels[2].CompressionDictSize = 8 << 10
 			opts.Experimental.CompactionDebtConcurrency = 100
 			opts.FlushDelayDeleteRange = 10 * time.Second
 			opts.FlushDelayRangeKey = 11 * time.Second
 			opts.Experimental.LevelMultiplier = 5
+			opts.Experimental.L0SublevelScoreWeight = 3
+			opts.Experimental.L0ScoreSmoothing = 0.5
 			opts.Experimental.MinDeletionRate = 200
+			opts.Experimental.MinDeletionFileRate = 20
 			opts.Experimental.ReadCompactionRate = 300
 			opts.Experimental.ReadSamplingMultiplier = 400
+			opts.Experimental.SmallFileCompaction.MinFiles = 8
+			opts.Experimental.SmallFileCompaction.SizeThresholdPercent = 30
+			opts.Experimental.QueueDeletionDetection = true
+			opts.Experimental.LockFencing = true
+			opts.Experimental.ValueChecksums = true
+			opts.Experimental.MaxTablesPerGet = 12
+			opts.Experimental.PrecheckTableFilters = true
+			opts.Experimental.FlushRangeDelCoalesceThreshold = 16
+			opts.Experimental.BulkScanCacheSize = 1 << 20
+			opts.Experimental.BulkCommitConcurrency = 2
+			opts.Experimental.EnableIngestCompactionHints = true
+			opts.Experimental.ValidateOnWriteSampleRate = 0.5
+			opts.Experimental.ConsistencyCheck = ConsistencyCheckThorough
+			opts.Experimental.ConsistencyCheckSampleRate = 0.25
 			opts.Experimental.TableCacheShards = 500
 			opts.Experimental.MaxWriterConcurrency = 1
 			opts.Experimental.ForceWriterParallelism = true
+			opts.Experimental.CompressionConcurrency = 4
+			opts.Experimental.SecondaryCacheSizeBytes = 64 << 20
+			opts.Experimental.SecondaryCacheDir = "secondary-cache"
 			opts.EnsureDefaults()
 			str := opts.String()
 
@@ -282,6 +307,26 @@ func TestOptionsValidate(t *testing.T) {
 `,
 			`MemTableStopWritesThreshold .* must be >= 2`,
 		},
+		{`
+[Options]
+  small_file_compaction_min_files=1
+`,
+			`SmallFileCompaction.MinFiles \(1\) must be 0 or >= 2`,
+		},
+		{`
+[Level "0"]
+  compression=ZSTD
+  zstd_level=23
+`,
+			`Level 0: pebble: zstd level 23 must be in the range \[1, 22\]`,
+		},
+		{`
+[Level "0"]
+  compression=ZSTD
+  zstd_window_log=28
+`,
+			`Level 0: pebble: zstd window log 28 must be in the range \[10, 27\]`,
+		},
 	}
 
 	for _, c := range testCases {
@@ -317,3 +362,31 @@ func TestOptionsValidateCache(t *testing.T) {
 		t.Errorf("Unexpected error message")
 	}
 }
+
+func TestMakeReaderOptionsFilterVerification(t *testing.T) {
+	var logged []string
+	opts := &Options{
+		BlockPropertyCollectors: []func() BlockPropertyCollector{
+			sstable.NewTestKeysBlockPropertyCollector,
+		},
+		Logger: loggerFunc(func(format string, args ...interface{}) {
+			logged = append(logged, fmt.Sprintf(format, args...))
+		}),
+	}
+	require.Nil(t, opts.MakeReaderOptions().FilterVerification)
+
+	opts.Experimental.FilterVerification = &FilterVerificationOptions{SampleRate: 0.5}
+	fv := opts.MakeReaderOptions().FilterVerification
+	require.NotNil(t, fv)
+	require.Equal(t, 0.5, fv.SampleRate)
+	require.Len(t, fv.BlockPropertyCollectors, 1)
+	// The defaults are not written back to the configured options.
+	require.Nil(t, opts.Experimental.FilterVerification.OnDiscrepancy)
+	fv.OnDiscrepancy(FilterDiscrepancy{Filter: "f", Key: base.MakeInternalKey([]byte("k"), 1, InternalKeyKindSet)})
+	require.Equal(t, []string{`pebble: filter verification failed: sstable 000000: filter "f" excluded data containing key k#1,1`}, logged)
+}
+
+type loggerFunc func(format string, args ...interface{})
+
+func (f loggerFunc) Infof(format string, args ...interface{})  { f(format, args...) }
+func (f loggerFunc) Fatalf(format string, args ...interface{}) { f(format, args...) }
@@ -5,6 +5,8 @@
 package pebble
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -40,19 +42,27 @@ type deletionPacerInfo struct {
 // negatively impacted if too many blocks are deleted very quickly, so this
 // mechanism helps mitigate that.
 type deletionPacer struct {
+	// limiter limits the bytes deleted per second, and fileLimiter the files
+	// deleted per second. Either may be nil.
 	limiter               limiter
+	fileLimiter           limiter
 	freeSpaceThreshold    uint64
 	obsoleteBytesMaxRatio float64
 
 	getInfo func() deletionPacerInfo
+	// throttled is the cumulative time deletions were delayed.
+	throttled time.Duration
 }
 
 // newDeletionPacer instantiates a new deletionPacer for use when deleting
-// obsolete files. The limiter passed in must be a singleton shared across this
+// obsolete files. The limiters passed in must be singletons shared across this
 // pebble instance.
-func newDeletionPacer(limiter limiter, getInfo func() deletionPacerInfo) *deletionPacer {
+func newDeletionPacer(
+	limiter, fileLimiter limiter, getInfo func() deletionPacerInfo,
+) *deletionPacer {
 	return &deletionPacer{
-		limiter: limiter,
+		limiter:     limiter,
+		fileLimiter: fileLimiter,
 		// If there are less than freeSpaceThreshold bytes of free space on
 		// disk, do not pace deletions at all.
 		freeSpaceThreshold: 16 << 30, // 16 GB
@@ -64,9 +74,9 @@ func newDeletionPacer(limiter limiter, getInfo func() deletionPacerInfo) *deleti
 	}
 }
 
-// limit applies rate limiting if the current free disk space is more than
-// freeSpaceThreshold, and the ratio of obsolete to live bytes is less than
-// obsoleteBytesMaxRatio.
+// limit applies rate limiting to the deletion of a file of amount bytes if the
+// current free disk space is more than freeSpaceThreshold, and the ratio of
+// obsolete to live bytes is less than obsoleteBytesMaxRatio.
 func (p *deletionPacer) limit(amount uint64, info deletionPacerInfo) error {
 	obsoleteBytesRatio := float64(1.0)
 	if info.liveBytes > 0 {
@@ -74,39 +84,58 @@ func (p *deletionPacer) limit(amount uint64, info deletionPacerInfo) error {
 	}
 	paceDeletions := info.freeBytes > p.freeSpaceThreshold &&
 		obsoleteBytesRatio < p.obsoleteBytesMaxRatio
+	if p.fileLimiter != nil {
+		if err := p.limitN(p.fileLimiter, 1, paceDeletions); err != nil {
+			return err
+		}
+	}
+	if p.limiter != nil {
+		return p.limitN(p.limiter, amount, paceDeletions)
+	}
+	return nil
+}
+
+func (p *deletionPacer) limitN(l limiter, amount uint64, paceDeletions bool) error {
 	if paceDeletions {
-		burst := p.limiter.Burst()
+		burst := l.Burst()
 		for amount > uint64(burst) {
-			d := p.limiter.DelayN(time.Now(), burst)
+			d := l.DelayN(time.Now(), burst)
 			if d == rate.InfDuration {
 				return errors.Errorf("pacing failed")
 			}
-			time.Sleep(d)
+			p.sleep(d)
 			amount -= uint64(burst)
 		}
-		d := p.limiter.DelayN(time.Now(), int(amount))
+		d := l.DelayN(time.Now(), int(amount))
 		if d == rate.InfDuration {
 			return errors.Errorf("pacing failed")
 		}
-		time.Sleep(d)
+		p.sleep(d)
 	} else {
-		burst := p.limiter.Burst()
+		burst := l.Burst()
 		for amount > uint64(burst) {
 			// AllowN will subtract burst if there are enough tokens available,
 			// else leave the tokens untouched. That is, we are making a
 			// best-effort to account for this activity in the limiter, but by
 			// ignoring the return value, we do the activity instantaneously
 			// anyway.
-			p.limiter.AllowN(time.Now(), burst)
+			l.AllowN(time.Now(), burst)
 			amount -= uint64(burst)
 		}
-		p.limiter.AllowN(time.Now(), int(amount))
+		l.AllowN(time.Now(), int(amount))
 	}
 	return nil
 }
 
+func (p *deletionPacer) sleep(d time.Duration) {
+	if d > 0 {
+		time.Sleep(d)
+		p.throttled += d
+	}
+}
+
 // maybeThrottle slows down a deletion of this file if it's faster than
-// opts.Experimental.MinDeletionRate.
+// opts.Experimental.MinDeletionRate or MinDeletionFileRate.
 func (p *deletionPacer) maybeThrottle(bytesToDelete uint64) error {
 	return p.limit(bytesToDelete, p.getInfo())
 }
@@ -116,3 +145,160 @@ type noopPacer struct{}
 func (p *noopPacer) maybeThrottle(_ uint64) error {
 	return nil
 }
+
+// manualCompactionPacer paces the compactions performed by a single call to
+// DB.CompactWithOptions or DB.CompactAsync, reports their progress, and
+// cancels them. The compactions account for the input bytes they read through
+// maybeThrottle, which may be called concurrently by parallel compactions.
+type manualCompactionPacer struct {
+	opts    CompactOptions
+	limiter *rate.Limiter
+	timeNow func() time.Time
+	// cancelled is set once the compactions are cancelled through
+	// CompactionHandle.Cancel.
+	cancelled atomic.Bool
+
+	mu struct {
+		sync.Mutex
+		// startTime is set once the compactions start, after the memtables
+		// overlapping the compacted range are flushed.
+		startTime time.Time
+		// endTime is set once all the compactions have completed.
+		endTime time.Time
+		// compacted is the number of input bytes compacted so far.
+		compacted uint64
+		// total is the estimated number of input bytes to compact.
+		total      uint64
+		lastReport time.Time
+	}
+}
+
+func newManualCompactionPacer(
+	opts CompactOptions, timeNow func() time.Time,
+) *manualCompactionPacer {
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = time.Second
+	}
+	p := &manualCompactionPacer{
+		opts:    opts,
+		timeNow: timeNow,
+	}
+	if opts.BytesPerSecond > 0 {
+		// Allow bursts of up to a tenth of a second's worth of bytes, so that
+		// the compaction proceeds smoothly.
+		burst := opts.BytesPerSecond / 10
+		if burst < 1 {
+			burst = 1
+		}
+		p.limiter = rate.NewLimiter(rate.Limit(opts.BytesPerSecond), int(burst))
+	}
+	return p
+}
+
+// start records the start of the compactions, which are estimated to read
+// total input bytes.
+func (p *manualCompactionPacer) start(total uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.startTime = p.timeNow()
+	p.mu.lastReport = p.mu.startTime
+	p.mu.total = total
+}
+
+// maybeThrottle accounts for the input bytes read by c since the previous
+// call, slowing down c if it's faster than CompactOptions.BytesPerSecond. key
+// is the key c is about to process. It returns ErrCompactionCancelled if the
+// compactions were cancelled.
+func (p *manualCompactionPacer) maybeThrottle(c *compaction, key []byte) error {
+	if p.cancelled.Load() {
+		return ErrCompactionCancelled
+	}
+	n := c.bytesIterated - c.manualBytesReported
+	if n == 0 {
+		return nil
+	}
+	c.manualBytesReported = c.bytesIterated
+	if p.limiter != nil {
+		burst := uint64(p.limiter.Burst())
+		for remaining := n; remaining > 0; {
+			m := remaining
+			if m > burst {
+				m = burst
+			}
+			d := p.limiter.DelayN(p.timeNow(), int(m))
+			if d == rate.InfDuration {
+				return errors.Errorf("pacing failed")
+			}
+			time.Sleep(d)
+			remaining -= m
+		}
+	}
+	p.report(n, key, false /* final */)
+	return nil
+}
+
+// compactionDone accounts for the input bytes of c that were not read through
+// the compaction iterator, eg, because c moved its input file. Progress is not
+// reported, as DB.mu is held.
+func (p *manualCompactionPacer) compactionDone(c *compaction) {
+	var size uint64
+	for _, cl := range c.inputs {
+		size += cl.files.SizeSum()
+	}
+	if size > c.manualBytesReported {
+		p.mu.Lock()
+		p.mu.compacted += size - c.manualBytesReported
+		p.mu.Unlock()
+		c.manualBytesReported = size
+	}
+}
+
+// finish reports the final progress once all compactions have completed.
+func (p *manualCompactionPacer) finish() {
+	p.report(0, nil /* key */, true /* final */)
+}
+
+func (p *manualCompactionPacer) report(n uint64, key []byte, final bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.compacted += n
+	now := p.timeNow()
+	if final {
+		p.mu.endTime = now
+	}
+	if p.opts.OnProgress == nil {
+		return
+	}
+	if !final && now.Sub(p.mu.lastReport) < p.opts.ProgressInterval {
+		return
+	}
+	p.mu.lastReport = now
+	progress := p.progressLocked(now)
+	progress.CurrentKey = key
+	p.opts.OnProgress(progress)
+}
+
+// progress returns the progress of the compactions so far. The CurrentKey
+// isn't set.
+func (p *manualCompactionPacer) progress() CompactionProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.progressLocked(p.timeNow())
+}
+
+func (p *manualCompactionPacer) progressLocked(now time.Time) CompactionProgress {
+	if p.mu.startTime.IsZero() {
+		return CompactionProgress{}
+	}
+	final := !p.mu.endTime.IsZero()
+	if final {
+		now = p.mu.endTime
+	}
+	progress := CompactionProgress{
+		BytesCompacted: p.mu.compacted,
+		BytesTotal:     p.mu.total,
+		Elapsed:        now.Sub(p.mu.startTime),
+	}
+	progress.estimateRemaining(final)
+	return progress
+}
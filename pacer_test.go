@@ -13,6 +13,9 @@ import (
 	"time"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
 )
 
 type mockPrintLimiter struct {
@@ -88,7 +91,7 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 							obsoleteBytes: obsoleteBytes,
 						}
 					}
-					deletionPacer := newDeletionPacer(&mockLimiter, getInfo)
+					deletionPacer := newDeletionPacer(&mockLimiter, nil /* fileLimiter */, getInfo)
 					deletionPacer.freeSpaceThreshold = slowdownThreshold
 					err := deletionPacer.maybeThrottle(bytesIterated)
 					if err != nil {
@@ -105,3 +108,66 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 			}
 		})
 }
+
+func TestDeletionPacerFileRate(t *testing.T) {
+	info := deletionPacerInfo{freeBytes: 100, liveBytes: 100}
+	getInfo := func() deletionPacerInfo { return info }
+	limiter := &mockPrintLimiter{burst: 10}
+	fileLimiter := &mockPrintLimiter{burst: 1}
+	p := newDeletionPacer(limiter, fileLimiter, getInfo)
+	p.freeSpaceThreshold = 10
+	require.NoError(t, p.maybeThrottle(15))
+	require.Equal(t, "wait: 1\n", fileLimiter.buf.String())
+	require.Equal(t, "wait: 10\nwait: 5\n", limiter.buf.String())
+
+	// Deletions aren't paced by the number of files when there are too many
+	// obsolete bytes, but are still accounted for.
+	p = newDeletionPacer(nil /* limiter */, fileLimiter, getInfo)
+	p.freeSpaceThreshold = 10
+	info.obsoleteBytes = 50
+	fileLimiter.buf.Reset()
+	require.NoError(t, p.maybeThrottle(15))
+	require.Equal(t, "allow: 1\n", fileLimiter.buf.String())
+
+	// The time deletions are delayed by is accumulated.
+	info.obsoleteBytes = 0
+	p = newDeletionPacer(nil /* limiter */, rate.NewLimiter(1000, 1), getInfo)
+	p.freeSpaceThreshold = 10
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.maybeThrottle(15))
+	}
+	require.Greater(t, p.throttled, time.Duration(0))
+}
+
+func TestDeletionPacingBacklog(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.MinDeletionRate = 1 << 20
+	opts.Experimental.MinDeletionFileRate = 100
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprint(i)), []byte("value"), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("0"), []byte("3"), false /* parallelize */))
+
+	// The tables obsoleted by the compaction are deleted in the background,
+	// draining the backlog.
+	require.Eventually(t, func() bool {
+		m := d.Metrics()
+		return m.DeletionPacing.BacklogCount == 0 && m.Table.ObsoleteCount == 0
+	}, 10*time.Second, time.Millisecond)
+	m := d.Metrics()
+	require.Zero(t, m.DeletionPacing.BacklogSize)
+	ls, err := opts.FS.List("")
+	require.NoError(t, err)
+	var tables int
+	for _, name := range ls {
+		if strings.HasSuffix(name, ".sst") {
+			tables++
+		}
+	}
+	require.Equal(t, 1, tables)
+}
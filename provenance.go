@@ -0,0 +1,217 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+const (
+	// checkpointSourceFilename is the name of the file recording the DB from
+	// which a checkpoint was created. See ReadCheckpointSource.
+	checkpointSourceFilename = "CHECKPOINT"
+
+	// DBIDPropertyName is the name of the user property of the sstables
+	// written by a DB that holds the ID of the DB. See DB.ID.
+	DBIDPropertyName = "pebble.provenance.db_id"
+	// DBStorePropertyName is the name of the user property of the sstables
+	// written by a DB that holds the description of the store of the DB, if
+	// configured. See Options.Experimental.ProvenanceStore.
+	DBStorePropertyName = "pebble.provenance.store"
+)
+
+// ID returns the unique ID of the DB, a random UUID generated when the DB was
+// created. The ID is stable across restarts of the DB, and is stamped into
+// the properties of the sstables written by the DB (see SSTableProvenance),
+// so that the origin of an sstable can be traced. A checkpoint of the DB is
+// given a new ID when it's first opened, and records the ID of the DB from
+// which it was created (see ReadCheckpointSource).
+//
+// A DB created by a version of Pebble that did not generate IDs is given an
+// ID the next time it's opened. If such a DB is opened in read-only mode, the
+// ID is not persisted, and changes every time the DB is opened.
+func (d *DB) ID() string {
+	return d.id
+}
+
+// newDBID generates a random (version 4) UUID.
+func newDBID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// loadOrCreateID loads the ID of the DB from the IDENTITY file, generating a
+// new ID and writing the file if it does not exist. d.mu must be held when
+// calling this.
+func (d *DB) loadOrCreateID() error {
+	fs := d.opts.FS
+	path := base.MakeFilepath(fs, d.dirname, fileTypeIdentity, base.FileNum(0).DiskFileNum())
+	data, err := readFile(fs, path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			d.id = id
+			return nil
+		}
+	} else if !oserror.IsNotExist(err) {
+		return err
+	}
+
+	if d.id, err = newDBID(); err != nil {
+		return err
+	}
+	if d.opts.ReadOnly {
+		return nil
+	}
+	// Write the ID to a temporary file first, in case we crash before we're
+	// done. A temporary file left behind by a crash is overwritten the next
+	// time the DB is opened. The temporary file isn't named after a file
+	// number, as the file numbers of a new DB are deterministic.
+	tmpPath := path + ".dbtmp"
+	if err := writeFile(fs, tmpPath, []byte(d.id+"\n")); err != nil {
+		return err
+	}
+	if err := fs.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return d.dataDir.Sync()
+}
+
+// stampProvenance configures o such that the sstables written with it are
+// stamped with the ID of the DB, and the description of its store if any.
+func (d *DB) stampProvenance(o *sstable.WriterOptions) {
+	id, store := d.id, d.opts.Experimental.ProvenanceStore
+	n := len(o.TablePropertyCollectors)
+	o.TablePropertyCollectors = append(o.TablePropertyCollectors[:n:n], func() TablePropertyCollector {
+		return &provenanceCollector{id: id, store: store}
+	})
+}
+
+// provenanceCollector is a TablePropertyCollector that stamps the ID and
+// store of the DB that wrote a sstable into its user properties.
+type provenanceCollector struct {
+	id, store string
+}
+
+var _ TablePropertyCollector = (*provenanceCollector)(nil)
+
+func (c *provenanceCollector) Add(InternalKey, []byte) error {
+	return nil
+}
+
+func (c *provenanceCollector) Finish(userProps map[string]string) error {
+	userProps[DBIDPropertyName] = c.id
+	if c.store != "" {
+		userProps[DBStorePropertyName] = c.store
+	}
+	return nil
+}
+
+func (c *provenanceCollector) Name() string {
+	return "pebble.provenance"
+}
+
+// SSTableProvenance describes the DB that wrote a sstable.
+type SSTableProvenance struct {
+	// DBID is the ID of the DB. See DB.ID.
+	DBID string
+	// Store is the description of the store of the DB at the time the
+	// sstable was written, and is empty unless configured by
+	// Options.Experimental.ProvenanceStore.
+	Store string
+}
+
+// ReadSSTableProvenance returns the provenance of the sstable with the given
+// properties, and false if the sstable was not stamped with its provenance,
+// eg, because it was written by an sstable.Writer outside of a DB, or by a
+// version of Pebble that did not stamp sstables.
+func ReadSSTableProvenance(props *sstable.Properties) (SSTableProvenance, bool) {
+	id, ok := props.UserProperties[DBIDPropertyName]
+	if !ok {
+		return SSTableProvenance{}, false
+	}
+	return SSTableProvenance{
+		DBID:  id,
+		Store: props.UserProperties[DBStorePropertyName],
+	}, true
+}
+
+// CheckpointSource describes the DB from which a checkpoint was created.
+type CheckpointSource struct {
+	// DBID is the ID of the DB. See DB.ID.
+	DBID string
+	// Store is the directory of the DB.
+	Store string
+}
+
+// ReadCheckpointSource returns the source of the checkpoint in dirname, as
+// recorded by DB.Checkpoint. An error satisfying oserror.IsNotExist is returned
+// if dirname does not contain a checkpoint, or contains a checkpoint created
+// by a version of Pebble that did not record its source.
+func ReadCheckpointSource(fs vfs.FS, dirname string) (CheckpointSource, error) {
+	data, err := readFile(fs, fs.PathJoin(dirname, checkpointSourceFilename))
+	if err != nil {
+		return CheckpointSource{}, err
+	}
+	var src CheckpointSource
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), "=")
+		if !ok {
+			return CheckpointSource{}, errors.Errorf("pebble: invalid checkpoint source line: %q", s.Text())
+		}
+		switch key {
+		case "db_id":
+			src.DBID = value
+		case "store":
+			src.Store = value
+		}
+	}
+	return src, s.Err()
+}
+
+// writeCheckpointSource records the source of a checkpoint in destDir.
+func (d *DB) writeCheckpointSource(fs vfs.FS, destDir string) error {
+	data := fmt.Sprintf("db_id=%s\nstore=%s\n", d.id, d.dirname)
+	return writeFile(fs, fs.PathJoin(destDir, checkpointSourceFilename), []byte(data))
+}
+
+func readFile(fs vfs.FS, path string) ([]byte, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func writeFile(fs vfs.FS, path string, data []byte) error {
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return errors.CombineErrors(err, f.Close())
+	}
+	if err := f.Sync(); err != nil {
+		return errors.CombineErrors(err, f.Close())
+	}
+	return f.Close()
+}
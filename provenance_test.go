@@ -0,0 +1,75 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvenance(t *testing.T) {
+	fs := vfs.NewMem()
+	d, err := Open("db", &Options{FS: fs})
+	require.NoError(t, err)
+	id := d.ID()
+	require.Len(t, id, 36)
+
+	// The ID is stamped into flushed sstables.
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	require.Len(t, tables[0], 1)
+	prov, ok := ReadSSTableProvenance(tables[0][0].Properties)
+	require.True(t, ok)
+	require.Equal(t, SSTableProvenance{DBID: id}, prov)
+
+	// The checkpoint records its source.
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	src, err := ReadCheckpointSource(fs, "checkpoint")
+	require.NoError(t, err)
+	require.Equal(t, CheckpointSource{DBID: id, Store: "db"}, src)
+	_, err = ReadCheckpointSource(fs, "db")
+	require.True(t, oserror.IsNotExist(err))
+	require.NoError(t, d.Close())
+
+	// The ID is stable across restarts. The store is only stamped if
+	// configured.
+	opts := &Options{FS: fs}
+	opts.Experimental.ProvenanceStore = "store-1"
+	d, err = Open("db", opts)
+	require.NoError(t, err)
+	require.Equal(t, id, d.ID())
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	tables, err = d.SSTables(WithProperties())
+	require.NoError(t, err)
+	require.Len(t, tables[0], 2)
+	for _, table := range tables[0] {
+		prov, ok := ReadSSTableProvenance(table.Properties)
+		require.True(t, ok)
+		if string(table.Smallest.UserKey) == "b" {
+			require.Equal(t, SSTableProvenance{DBID: id, Store: "store-1"}, prov)
+		} else {
+			require.Equal(t, SSTableProvenance{DBID: id}, prov)
+		}
+	}
+	require.NoError(t, d.Close())
+
+	// A checkpoint is given its own ID, and the sstables it shares with its
+	// source retain their provenance.
+	d, err = Open("checkpoint", &Options{FS: fs})
+	require.NoError(t, err)
+	require.NotEqual(t, id, d.ID())
+	tables, err = d.SSTables(WithProperties())
+	require.NoError(t, err)
+	prov, ok = ReadSSTableProvenance(tables[0][0].Properties)
+	require.True(t, ok)
+	require.Equal(t, id, prov.DBID)
+	require.NoError(t, d.Close())
+}
@@ -357,18 +357,18 @@ func TestRangeDelCompactionTruncation(t *testing.T) {
 1:
   000008:[a#12,RANGEDEL-b#inf,RANGEDEL]
 2:
-  000012:[b#13,SET-c#inf,RANGEDEL]
+  000013:[b#13,SET-c#inf,RANGEDEL]
 3:
-  000013:[c#14,SET-d#inf,RANGEDEL]
+  000014:[c#14,SET-d#inf,RANGEDEL]
 `)
 		} else {
 			expectLSM(`
 1:
   000008:[a#12,RANGEDEL-b#inf,RANGEDEL]
 2:
-  000012:[b#13,SETWITHDEL-c#inf,RANGEDEL]
+  000013:[b#13,SETWITHDEL-c#inf,RANGEDEL]
 3:
-  000013:[c#14,SET-d#inf,RANGEDEL]
+  000014:[c#14,SET-d#inf,RANGEDEL]
 `)
 		}
 
@@ -5,6 +5,8 @@
 package pebble
 
 import (
+	"sync/atomic"
+
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/keyspan"
@@ -665,3 +667,53 @@ func (i *lazyCombinedIter) String() string {
 	}
 	return i.pointIter.String()
 }
+
+// rangeKeyMetrics accumulates the cumulative range key metrics of a DB which
+// aren't derived from the LSM. See Metrics.RangeKeys.
+type rangeKeyMetrics struct {
+	setsWritten    atomic.Uint64
+	unsetsWritten  atomic.Uint64
+	deletesWritten atomic.Uint64
+	maskedPoints   atomic.Uint64
+}
+
+// recordBatch records the range keys written by a committed batch.
+func (m *rangeKeyMetrics) recordBatch(b *Batch) {
+	if b.countRangeKeys == 0 {
+		return
+	}
+	var sets, unsets, deletes uint64
+	for r := b.Reader(); ; {
+		kind, _, _, ok := r.Next()
+		if !ok {
+			break
+		}
+		switch kind {
+		case InternalKeyKindRangeKeySet:
+			sets++
+		case InternalKeyKindRangeKeyUnset:
+			unsets++
+		case InternalKeyKindRangeKeyDelete:
+			deletes++
+		}
+	}
+	m.setsWritten.Add(sets)
+	m.unsetsWritten.Add(unsets)
+	m.deletesWritten.Add(deletes)
+}
+
+// recordIterator records the range key stats of an iterator which is closed,
+// or whose stats are reset.
+func (m *rangeKeyMetrics) recordIterator(s *RangeKeyIteratorStats) {
+	if s.SkippedPoints > 0 {
+		m.maskedPoints.Add(uint64(s.SkippedPoints))
+	}
+}
+
+func (m *rangeKeyMetrics) load(metrics *Metrics) {
+	r := &metrics.RangeKeys
+	r.SetsWritten = m.setsWritten.Load()
+	r.UnsetsWritten = m.unsetsWritten.Load()
+	r.DeletesWritten = m.deletesWritten.Load()
+	r.MaskedPoints = m.maskedPoints.Load()
+}
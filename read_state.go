@@ -103,4 +103,9 @@ func (d *DB) updateReadStateLocked(checker func(*DB) error) {
 	if old != nil {
 		old.unrefLocked()
 	}
+	// The keys visible through the new readState may differ, at any sequence
+	// number allocated so far.
+	if d.lookupCache != nil {
+		d.lookupCache.invalidateAll(d.mu.versions.logSeqNum.Load())
+	}
 }
@@ -0,0 +1,113 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/redact"
+)
+
+// ReclaimableSpace is an estimate of the disk space used by data that is
+// logically deleted, but that remains on disk, broken down by the reason the
+// space has not been reclaimed yet.
+type ReclaimableSpace struct {
+	// AwaitingCompaction is the estimated size of the data deleted by the
+	// point and range tombstones of the LSM that will be reclaimed once the
+	// tombstones are compacted into the data they delete.
+	AwaitingCompaction uint64
+	// PinnedBySnapshots is the estimated size of the data deleted by the
+	// tombstones of tables containing keys newer than the earliest open
+	// snapshot. Compactions must retain the deleted data visible to the open
+	// snapshots, so the space may not be reclaimed until they're closed.
+	PinnedBySnapshots uint64
+	// HeldByIterators is the size of the tables that are no longer part of
+	// the LSM, but that are still in use by open iterators. The space is
+	// reclaimed once the iterators are closed.
+	HeldByIterators uint64
+	// AwaitingDeletion is the size of the obsolete tables that are not yet
+	// deleted, eg, because of deletion pacing, or because file deletions are
+	// disabled while a checkpoint is taken.
+	AwaitingDeletion uint64
+	// CompactionDebt is the estimated number of bytes that need to be
+	// compacted for the LSM to reach a stable state. A large debt indicates
+	// that compactions lag behind writes, delaying the reclamation of the
+	// AwaitingCompaction space.
+	CompactionDebt uint64
+	// TablesWithoutStats is the number of tables whose statistics have not
+	// been loaded yet. The deletions of these tables are not included in
+	// AwaitingCompaction or PinnedBySnapshots.
+	TablesWithoutStats int
+}
+
+// Total returns the total estimated reclaimable space.
+func (s *ReclaimableSpace) Total() uint64 {
+	return s.AwaitingCompaction + s.PinnedBySnapshots + s.HeldByIterators + s.AwaitingDeletion
+}
+
+// String implements fmt.Stringer.
+func (s *ReclaimableSpace) String() string {
+	return redact.StringWithoutMarkers(s)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (s *ReclaimableSpace) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("reclaimable: %s\n", humanize.IEC.Uint64(s.Total()))
+	w.Printf("  awaiting compaction: %s (compaction debt %s)\n",
+		humanize.IEC.Uint64(s.AwaitingCompaction), humanize.IEC.Uint64(s.CompactionDebt))
+	w.Printf("  pinned by snapshots: %s\n", humanize.IEC.Uint64(s.PinnedBySnapshots))
+	w.Printf("  held by iterators: %s\n", humanize.IEC.Uint64(s.HeldByIterators))
+	w.Printf("  awaiting deletion: %s\n", humanize.IEC.Uint64(s.AwaitingDeletion))
+	if s.TablesWithoutStats > 0 {
+		w.Printf("  tables without stats: %d\n", redact.Safe(s.TablesWithoutStats))
+	}
+}
+
+var _ fmt.Stringer = (*ReclaimableSpace)(nil)
+
+// EstimateReclaimableSpace returns an estimate of the disk space used by data
+// that is logically deleted but still on disk, broken down by the reason the
+// space has not been reclaimed.
+//
+// The estimates of the data deleted by tombstones are those of the table
+// statistics, and are attributed at the granularity of tables: the deletions
+// of a table are considered pinned by snapshots if the table contains keys
+// newer than the earliest open snapshot.
+func (d *DB) EstimateReclaimableSpace() *ReclaimableSpace {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	s := &ReclaimableSpace{}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	earliestSnapshot := uint64(InternalKeySeqNumMax)
+	if !d.mu.snapshots.empty() {
+		earliestSnapshot = d.mu.snapshots.earliest()
+	}
+	vers := d.mu.versions.currentVersion()
+	for level := 0; level < numLevels; level++ {
+		iter := vers.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if !f.StatsValid() {
+				s.TablesWithoutStats++
+				continue
+			}
+			deleted := f.Stats.PointDeletionsBytesEstimate + f.Stats.RangeDeletionsBytesEstimate
+			if f.LargestSeqNum >= earliestSnapshot {
+				s.PinnedBySnapshots += deleted
+			} else {
+				s.AwaitingCompaction += deleted
+			}
+		}
+	}
+	for _, size := range d.mu.versions.zombieTables {
+		s.HeldByIterators += size
+	}
+	s.AwaitingDeletion = d.mu.versions.metrics.Table.ObsoleteSize
+	s.CompactionDebt = d.mu.versions.picker.estimatedCompactionDebt(0)
+	return s
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateReclaimableSpace(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	estimate := func() *ReclaimableSpace {
+		d.mu.Lock()
+		d.waitTableStats()
+		d.mu.Unlock()
+		return d.EstimateReclaimableSpace()
+	}
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%03d", i)) }
+
+	value := bytes.Repeat([]byte("v"), 1024)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set(key(i), value, nil))
+	}
+	require.NoError(t, d.Flush())
+	require.Zero(t, estimate().Total())
+
+	// Deletions that are newer than an open snapshot are pinned by it.
+	snap := d.NewSnapshot()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Delete(key(i), nil))
+	}
+	require.NoError(t, d.Flush())
+	s := estimate()
+	require.Zero(t, s.AwaitingCompaction)
+	require.NotZero(t, s.PinnedBySnapshots)
+	require.NoError(t, snap.Close())
+	s = estimate()
+	require.NotZero(t, s.AwaitingCompaction)
+	require.Zero(t, s.PinnedBySnapshots)
+	require.Equal(t, s.AwaitingCompaction, s.Total())
+
+	// Tables compacted away while an iterator is open are held by it.
+	iter := d.NewIter(nil)
+	require.NoError(t, d.Compact(key(0), key(100), false))
+	s = estimate()
+	require.Zero(t, s.AwaitingCompaction)
+	require.NotZero(t, s.HeldByIterators)
+	require.NoError(t, iter.Close())
+}
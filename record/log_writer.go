@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/failpoint"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/crc"
 	"github.com/prometheus/client_golang/prometheus"
@@ -299,6 +300,10 @@ type LogWriter struct {
 
 	// See the comment for LogWriterConfig.QueueSemChan.
 	queueSemChan chan struct{}
+
+	// unsyncedBytes is the number of bytes written to w that have not been
+	// synced yet. It is only modified by the flush loop.
+	unsyncedBytes atomic.Int64
 }
 
 // LogWriterConfig is a struct used for configuring new LogWriters
@@ -525,11 +530,17 @@ func (w *LogWriter) flushPending(
 		bytesWritten += int64(n)
 		_, err = w.w.Write(data)
 	}
+	w.unsyncedBytes.Add(bytesWritten)
 
 	synced = head != tail
 	if synced {
 		if err == nil && w.s != nil {
-			syncLatency, err = w.syncWithLatency()
+			if err = failpoint.Inject(failpoint.WALBeforeSync); err == nil {
+				syncLatency, err = w.syncWithLatency()
+			}
+		}
+		if err == nil {
+			w.unsyncedBytes.Store(0)
 		}
 		f := &w.flusher
 		if popErr := f.syncQ.pop(head, tail, err, w.queueSemChan); popErr != nil {
@@ -772,6 +783,20 @@ func (w *LogWriter) Metrics() *LogWriterMetrics {
 	return w.flusher.metrics
 }
 
+// PendingSyncs returns the number of sync requests waiting for the log to be
+// synced.
+func (w *LogWriter) PendingSyncs() int {
+	_, _, n := w.flusher.syncQ.load()
+	return int(n)
+}
+
+// UnsyncedBytes returns the number of bytes written to the underlying writer
+// that have not been synced yet. It does not include the bytes buffered in the
+// LogWriter that are still to be written.
+func (w *LogWriter) UnsyncedBytes() int64 {
+	return w.unsyncedBytes.Load()
+}
+
 // LogWriterMetrics contains misc metrics for the log writer.
 type LogWriterMetrics struct {
 	WriteThroughput  base.ThroughputMetric
@@ -450,6 +450,33 @@ func TestMetricsWithSync(t *testing.T) {
 	require.LessOrEqual(t, int64(syncLatency/2), int64(m.WriteThroughput.WorkDuration))
 }
 
+func TestPendingSyncs(t *testing.T) {
+	f := &syncFileWithWait{}
+	f.syncWG.Add(1)
+	w := NewLogWriter(f, 0, LogWriterConfig{})
+	const recordSize = 16
+	_, _, err := w.SyncRecord([]byte("hello"), nil, nil)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	var syncErr error
+	wg.Add(1)
+	_, _, err = w.SyncRecord([]byte("hello"), &wg, &syncErr)
+	require.NoError(t, err)
+
+	// The flush loop writes both records and blocks in the sync.
+	require.Eventually(t, func() bool {
+		return w.UnsyncedBytes() == 2*recordSize
+	}, 10*time.Second, time.Millisecond)
+	require.Equal(t, 1, w.PendingSyncs())
+
+	f.syncWG.Done()
+	wg.Wait()
+	require.NoError(t, syncErr)
+	require.Equal(t, 0, w.PendingSyncs())
+	require.EqualValues(t, 0, w.UnsyncedBytes())
+	require.NoError(t, w.Close())
+}
+
 func valueAtQuantileWindowed(histogram *prometheusgo.Histogram, q float64) float64 {
 	buckets := histogram.Bucket
 	n := float64(*histogram.SampleCount)
@@ -334,6 +334,16 @@ func (r *Reader) recover() {
 	r.seq++
 }
 
+// Recover clears any errors read so far, so that calling Next will return the
+// next intact record, skipping the rest of the current 32KiB block and the
+// chunks that aren't the first of a record. If there are no such records,
+// Next will return io.EOF. Recover also marks the current reader, the one most
+// recently returned by Next, as stale. If Recover is called without any prior
+// error, then Recover is a no-op.
+func (r *Reader) Recover() {
+	r.recover()
+}
+
 // seekRecord seeks in the underlying io.Reader such that calling r.Next
 // returns the record whose first chunk header starts at the provided offset.
 // Its behavior is undefined if the argument given is not such an offset, as
@@ -0,0 +1,373 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/objstorage"
+)
+
+// RenamePrefix atomically moves the keys with the prefix from to the prefix
+// to, replacing from with to in each key, without rewriting the keys. This
+// allows data to be staged under a temporary prefix, eg, by a bulk load
+// through a series of ingestions, and then exposed under its final prefix
+// all at once.
+//
+// The memtables are flushed if they contain keys with either prefix, so that
+// the keys with the prefix from are all in sstables. The sstables are then
+// replaced, in a single version edit, by tables sharing their storage that
+// are read with a prefix replacement (see PrefixReplacement). An sstable
+// containing keys both with and without the prefix from can't be renamed, and
+// an error is returned if there's one. Such sstables may be produced by
+// compactions if keys adjacent to the prefix are written.
+//
+// The key space of the prefix to must be empty, the prefixes must not be
+// prefixes of one another, and the DB must not have open snapshots, as these
+// could observe the renamed keys. Writes to keys with either prefix that are
+// concurrent with the rename may cause it to fail. The comparer must order
+// keys bytewise over the prefixes. The DB must be at format major version
+// FormatPrefixReplacement or later.
+//
+// This is an experimental API.
+func (d *DB) RenamePrefix(from, to []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if v := d.FormatMajorVersion(); v < FormatPrefixReplacement {
+		return errors.Errorf(
+			"pebble: prefix replacements require at least format major version %d (current: %d)",
+			FormatPrefixReplacement, v,
+		)
+	}
+	p := &PrefixReplacement{
+		ContentPrefix:   append([]byte(nil), from...),
+		SyntheticPrefix: append([]byte(nil), to...),
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if bytes.HasPrefix(from, to) || bytes.HasPrefix(to, from) {
+		return errors.Errorf("pebble: cannot rename prefix %q to overlapping prefix %q", from, to)
+	}
+
+	d.mu.Lock()
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	files, err := d.renamePrefixPrepareLocked(p)
+	if err != nil || len(files) == 0 {
+		d.mu.Unlock()
+		return err
+	}
+
+	d.mu.Unlock()
+	err = d.renamePrefixLink(jobID, files, p)
+	d.mu.Lock()
+	if err == nil {
+		err = d.renamePrefixApplyLocked(jobID, files, p)
+	}
+	for _, f := range files {
+		if err == nil {
+			f.old.SetCompactionState(manifest.CompactionStateCompacted)
+		} else {
+			f.old.SetCompactionState(manifest.CompactionStateNotCompacting)
+		}
+	}
+	d.mu.versions.currentVersion().L0Sublevels.InitCompactingFileInfo(
+		inProgressL0Compactions(d.getInProgressCompactionInfoLocked(nil)))
+	d.maybeScheduleCompaction()
+	d.mu.Unlock()
+	if err != nil {
+		d.renamePrefixCleanup(files)
+	}
+	return err
+}
+
+// renamedFile describes an sstable renamed by RenamePrefix, and the table that
+// replaces it.
+type renamedFile struct {
+	level int
+	old   *fileMetadata
+	new   *fileMetadata
+	// linked is true once the object of the new table has been created.
+	linked bool
+}
+
+// renamePrefixPrepareLocked flushes the memtables containing keys with either
+// prefix of the replacement, waits for the compactions of the sstables
+// containing keys with the content prefix, and returns these sstables, marked
+// as compacting so that they're not compacted until the rename completes.
+// Requires DB.mu is held.
+func (d *DB) renamePrefixPrepareLocked(p *PrefixReplacement) ([]renamedFile, error) {
+	fromLower, fromUpper := p.ContentBounds()
+	toLower, toUpper := p.SyntheticBounds()
+	for {
+		if d.memtablesOverlapLocked(fromLower, fromUpper) || d.memtablesOverlapLocked(toLower, toUpper) {
+			d.mu.Unlock()
+			err := d.Flush()
+			d.mu.Lock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		files, err := d.renamePrefixFilesLocked(p)
+		if err != nil {
+			return nil, err
+		}
+		compacting := false
+		for _, f := range files {
+			compacting = compacting || f.old.IsCompacting()
+		}
+		if compacting {
+			d.mu.compact.cond.Wait()
+			continue
+		}
+		for _, f := range files {
+			f.old.SetCompactionState(manifest.CompactionStateCompacting)
+		}
+		d.mu.versions.currentVersion().L0Sublevels.InitCompactingFileInfo(
+			inProgressL0Compactions(d.getInProgressCompactionInfoLocked(nil)))
+		return files, nil
+	}
+}
+
+// renamePrefixFilesLocked returns the sstables of the current version
+// containing keys with the content prefix of the replacement, verifying that
+// they don't contain other keys, and that the key space of the synthetic
+// prefix is empty. Requires DB.mu is held.
+func (d *DB) renamePrefixFilesLocked(p *PrefixReplacement) ([]renamedFile, error) {
+	fromLower, fromUpper := p.ContentBounds()
+	toLower, toUpper := p.SyntheticBounds()
+	current := d.mu.versions.currentVersion()
+	var files []renamedFile
+	for level := 0; level < numLevels; level++ {
+		if overlaps := current.Overlaps(level, d.cmp, toLower, toUpper, true /* exclusiveEnd */); !overlaps.Empty() {
+			return nil, errors.Errorf("pebble: cannot rename prefix: L%d overlaps [%q, %q)", level, toLower, toUpper)
+		}
+		overlaps := current.Overlaps(level, d.cmp, fromLower, fromUpper, true /* exclusiveEnd */)
+		iter := overlaps.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if d.cmp(f.Smallest.UserKey, fromLower) < 0 {
+				return nil, errors.Errorf("pebble: cannot rename prefix: table %s smallest key %q precedes prefix %q",
+					f.FileNum, f.Smallest.UserKey, p.ContentPrefix)
+			}
+			if c := d.cmp(f.Largest.UserKey, fromUpper); c > 0 || (c == 0 && !f.Largest.IsExclusiveSentinel()) {
+				return nil, errors.Errorf("pebble: cannot rename prefix: table %s largest key %q follows prefix %q",
+					f.FileNum, f.Largest.UserKey, p.ContentPrefix)
+			}
+			if f.Virtual {
+				return nil, errors.Errorf("pebble: cannot rename prefix: table %s is virtual", f.FileNum)
+			}
+			if r := f.PrefixReplacement; r != nil && !bytes.HasPrefix(r.SyntheticPrefix, p.ContentPrefix) &&
+				!bytes.HasPrefix(p.ContentPrefix, r.SyntheticPrefix) {
+				return nil, errors.Errorf("pebble: cannot rename prefix: table %s is read with prefix replacement %s",
+					f.FileNum, r)
+			}
+			files = append(files, renamedFile{level: level, old: f})
+		}
+	}
+	return files, nil
+}
+
+// memtablesOverlapLocked returns true if the queued memtables contain keys
+// within [lower, upper). Requires DB.mu is held.
+func (d *DB) memtablesOverlapLocked(lower, upper []byte) bool {
+	bounds := (&fileMetadata{}).ExtendPointKeyBounds(
+		d.cmp,
+		base.MakeInternalKey(lower, InternalKeySeqNumMax, InternalKeyKindMax),
+		base.MakeExclusiveSentinelKey(InternalKeyKindRangeDelete, upper),
+	)
+	for _, m := range d.mu.mem.queue {
+		iter := m.newIter(nil)
+		rangeDelIter := m.newRangeDelIter(nil)
+		rkeyIter := m.newRangeKeyIter(nil)
+		overlaps := overlapWithIterator(iter, &rangeDelIter, rkeyIter, bounds, d.cmp)
+		err := iter.Close()
+		if rangeDelIter != nil {
+			err = firstError(err, rangeDelIter.Close())
+		}
+		if rkeyIter != nil {
+			err = firstError(err, rkeyIter.Close())
+		}
+		if err != nil {
+			// Err on the side of flushing the memtable.
+			d.opts.Logger.Infof("error reading memtable for log %s: %s", m.logNum, err)
+			return true
+		}
+		if overlaps {
+			return true
+		}
+	}
+	return false
+}
+
+// renamePrefixLink creates the objects of the tables replacing the renamed
+// sstables, sharing the storage of the sstables, and the metadata of the
+// tables, read with the replacement composed with the existing replacement of
+// the sstables, if any.
+func (d *DB) renamePrefixLink(jobID int, files []renamedFile, p *PrefixReplacement) error {
+	d.mu.Lock()
+	for i := range files {
+		files[i].new = &fileMetadata{FileNum: d.mu.versions.getNextFileNum()}
+	}
+	d.mu.Unlock()
+
+	for i := range files {
+		f := &files[i]
+		objMeta, err := d.objProvider.Lookup(fileTypeTable, f.old.FileBacking.DiskFileNum)
+		if err != nil {
+			return err
+		}
+		newFileNum := f.new.FileNum.DiskFileNum()
+		if objMeta.IsShared() {
+			h, err := d.objProvider.SharedObjectBacking(&objMeta)
+			if err != nil {
+				return err
+			}
+			backing, err := h.Get()
+			if err == nil {
+				_, err = d.objProvider.AttachSharedObjects([]objstorage.SharedObjectToAttach{{
+					FileNum:  newFileNum,
+					FileType: fileTypeTable,
+					Backing:  backing,
+				}})
+			}
+			h.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			objMeta, err = d.objProvider.LinkOrCopyFromLocal(
+				context.TODO(), d.opts.FS, d.objProvider.Path(objMeta), fileTypeTable, newFileNum,
+				objstorage.CreateOptions{},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		f.linked = true
+		if d.opts.EventListener.TableCreated != nil {
+			d.opts.EventListener.TableCreated(TableCreateInfo{
+				JobID:   jobID,
+				Reason:  "renaming",
+				Path:    d.objProvider.Path(objMeta),
+				FileNum: f.new.FileNum,
+			})
+		}
+		renamePrefixFileMetadata(d.cmp, f.old, f.new, p)
+	}
+	// Make the new objects durable before they're referenced by the MANIFEST.
+	return d.objProvider.Sync()
+}
+
+// renamePrefixFileMetadata initializes the metadata of the table replacing the
+// renamed sstable old, with its bounds translated by the replacement.
+func renamePrefixFileMetadata(cmp Compare, old, new *fileMetadata, p *PrefixReplacement) {
+	// The keys of the sstable are stored with the content prefix of its
+	// existing replacement, if any, and are exposed with the synthetic prefix
+	// of the existing replacement, which has the content prefix of p, or is a
+	// prefix of it.
+	composed := &PrefixReplacement{ContentPrefix: p.ContentPrefix, SyntheticPrefix: p.SyntheticPrefix}
+	if r := old.PrefixReplacement; r != nil {
+		if bytes.HasPrefix(r.SyntheticPrefix, p.ContentPrefix) {
+			composed.ContentPrefix = r.ContentPrefix
+			composed.SyntheticPrefix = append(append([]byte(nil), p.SyntheticPrefix...),
+				r.SyntheticPrefix[len(p.ContentPrefix):]...)
+		} else {
+			composed.ContentPrefix = append(append([]byte(nil), r.ContentPrefix...),
+				p.ContentPrefix[len(r.SyntheticPrefix):]...)
+		}
+	}
+	translate := func(k InternalKey) InternalKey {
+		return InternalKey{UserKey: p.ReplaceResult(nil, k.UserKey), Trailer: k.Trailer}
+	}
+	new.Size = old.Size
+	new.CreationTime = old.CreationTime
+	new.SmallestSeqNum = old.SmallestSeqNum
+	new.LargestSeqNum = old.LargestSeqNum
+	new.MarkedForCompaction = old.MarkedForCompaction
+	new.PrefixReplacement = composed
+	if old.HasPointKeys {
+		new.ExtendPointKeyBounds(cmp, translate(old.SmallestPointKey), translate(old.LargestPointKey))
+	}
+	if old.HasRangeKeys {
+		new.ExtendRangeKeyBounds(cmp, translate(old.SmallestRangeKey), translate(old.LargestRangeKey))
+	}
+	new.InitPhysicalBacking()
+}
+
+// renamePrefixApplyLocked replaces the renamed sstables by the new tables in
+// the LSM, provided the LSM didn't change in the key spaces of the replacement
+// in the meantime. Requires DB.mu is held.
+func (d *DB) renamePrefixApplyLocked(jobID int, files []renamedFile, p *PrefixReplacement) error {
+	d.mu.versions.logLock()
+	if !d.mu.snapshots.empty() {
+		d.mu.versions.logUnlock()
+		return errors.New("pebble: cannot rename prefix while snapshots are open")
+	}
+	fromLower, fromUpper := p.ContentBounds()
+	toLower, toUpper := p.SyntheticBounds()
+	if d.memtablesOverlapLocked(fromLower, fromUpper) || d.memtablesOverlapLocked(toLower, toUpper) {
+		d.mu.versions.logUnlock()
+		return errors.New("pebble: cannot rename prefix: concurrent writes to the prefixes")
+	}
+	current, err := d.renamePrefixFilesLocked(p)
+	if err == nil && len(current) != len(files) {
+		err = errors.New("pebble: cannot rename prefix: concurrent changes to the prefixes")
+	}
+	for i := 0; err == nil && i < len(current); i++ {
+		if current[i].old != files[i].old {
+			err = errors.New("pebble: cannot rename prefix: concurrent changes to the prefixes")
+		}
+	}
+	if err != nil {
+		d.mu.versions.logUnlock()
+		return err
+	}
+
+	ve := &versionEdit{
+		DeletedFiles: make(map[deletedFileEntry]*fileMetadata, len(files)),
+		NewFiles:     make([]newFileEntry, len(files)),
+	}
+	for i, f := range files {
+		ve.DeletedFiles[deletedFileEntry{Level: f.level, FileNum: f.old.FileNum}] = f.old
+		ve.NewFiles[i] = newFileEntry{Level: f.level, Meta: f.new}
+	}
+	if err := d.mu.versions.logAndApply(jobID, ve, nil /* metrics */, false /* forceRotation */, func() []compactionInfo {
+		return d.getInProgressCompactionInfoLocked(nil)
+	}); err != nil {
+		return err
+	}
+	d.updateReadStateLocked(d.opts.DebugCheck)
+	d.updateTableStatsLocked(ve.NewFiles)
+	return nil
+}
+
+// renamePrefixCleanup removes the objects created for a failed rename. The
+// removal is synced, as the file numbers of the objects may otherwise be reused
+// after a restart, before the removal is made durable.
+func (d *DB) renamePrefixCleanup(files []renamedFile) {
+	var err error
+	for _, f := range files {
+		if f.linked {
+			err = firstError(err, d.objProvider.Remove(fileTypeTable, f.new.FileNum.DiskFileNum()))
+		}
+	}
+	if err == nil {
+		err = d.objProvider.Sync()
+	}
+	if err != nil {
+		d.opts.Logger.Infof("rename prefix cleanup failed: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenamePrefix(t *testing.T) {
+	for _, useShared := range []bool{false, true} {
+		t.Run(fmt.Sprintf("shared=%t", useShared), func(t *testing.T) {
+			opts := &Options{
+				FS:                          vfs.NewMem(),
+				DisableAutomaticCompactions: true,
+				FormatMajorVersion:          FormatPrefixReplacement,
+				Logger:                      panicLogger{},
+			}
+			if useShared {
+				opts.Experimental.SharedStorage = shared.NewInMem()
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+			if useShared {
+				require.NoError(t, d.SetCreatorID(1))
+			}
+
+			scan := func() string {
+				iter := d.NewIter(nil)
+				var buf strings.Builder
+				for valid := iter.First(); valid; valid = iter.Next() {
+					fmt.Fprintf(&buf, "%s=%s ", iter.Key(), iter.Value())
+				}
+				for valid := iter.Last(); valid; valid = iter.Prev() {
+					fmt.Fprintf(&buf, "%s ", iter.Key())
+				}
+				require.NoError(t, iter.Close())
+				return strings.TrimSpace(buf.String())
+			}
+			get := func(k string) string {
+				v, closer, err := d.Get([]byte(k))
+				if err == ErrNotFound {
+					return "not found"
+				}
+				require.NoError(t, err)
+				defer closer.Close()
+				return string(v)
+			}
+
+			// Stage keys in L6, in L0 and in the memtable, separately from the
+			// keys surrounding the staging prefix.
+			require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Set([]byte("z"), []byte("z"), nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Set([]byte("stage/1"), []byte("v1"), nil))
+			require.NoError(t, d.Set([]byte("stage/2"), []byte("v2"), nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact([]byte("stage/"), []byte("stage0"), false))
+			require.NoError(t, d.Set([]byte("stage/3"), []byte("v3"), nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Delete([]byte("stage/2"), nil))
+			require.NoError(t, d.Set([]byte("stage/4"), []byte("v4"), nil))
+			require.Equal(t, "a=a stage/1=v1 stage/3=v3 stage/4=v4 z=z z stage/4 stage/3 stage/1 a", scan())
+
+			iter := d.NewIter(nil)
+			require.NoError(t, d.RenamePrefix([]byte("stage/"), []byte("final/")))
+			const renamed = "a=a final/1=v1 final/3=v3 final/4=v4 z=z z final/4 final/3 final/1 a"
+			require.Equal(t, renamed, scan())
+			require.Equal(t, "v3", get("final/3"))
+			require.Equal(t, "not found", get("final/2"))
+			require.Equal(t, "not found", get("stage/1"))
+			// Open iterators are unaffected.
+			require.True(t, iter.SeekGE([]byte("b")))
+			require.Equal(t, "stage/1", string(iter.Key()))
+			require.NoError(t, iter.Close())
+
+			// The renamed tables may be renamed again, and compacted.
+			require.NoError(t, d.RenamePrefix([]byte("final/"), []byte("l/")))
+			require.Equal(t, "a=a l/1=v1 l/3=v3 l/4=v4 z=z z l/4 l/3 l/1 a", scan())
+			require.NoError(t, d.RenamePrefix([]byte("l/"), []byte("final/")))
+			require.NoError(t, d.Compact([]byte("final/"), []byte("final0"), false))
+			require.Equal(t, renamed, scan())
+
+			// Renaming an empty prefix is a no-op.
+			require.NoError(t, d.RenamePrefix([]byte("empty/"), []byte("other/")))
+			require.Equal(t, renamed, scan())
+		})
+	}
+}
+
+func TestRenamePrefixPersisted(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		FormatMajorVersion:          FormatPrefixReplacement,
+		Logger:                      panicLogger{},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("stage/1"), []byte("v1"), nil))
+	require.NoError(t, d.RenamePrefix([]byte("stage/"), []byte("final/")))
+	require.NoError(t, d.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	v, closer, err := d.Get([]byte("final/1"))
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(v))
+	require.NoError(t, closer.Close())
+	_, _, err = d.Get([]byte("stage/1"))
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestRenamePrefixErrors(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Logger:                      panicLogger{},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Renaming requires FormatPrefixReplacement.
+	require.Error(t, d.RenamePrefix([]byte("stage/"), []byte("final/")))
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatPrefixReplacement))
+
+	require.NoError(t, d.Set([]byte("stage/1"), nil, nil))
+	require.NoError(t, d.Set([]byte("stage0"), nil, nil))
+	require.NoError(t, d.Set([]byte("final/1"), nil, nil))
+	require.Error(t, d.RenamePrefix([]byte("stage/"), []byte("stage/a")))
+	require.Error(t, d.RenamePrefix([]byte("stage/"), []byte("")))
+	// The target key space isn't empty.
+	require.Error(t, d.RenamePrefix([]byte("stage/"), []byte("final/")))
+	require.NoError(t, d.Delete([]byte("final/1"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	// The table containing stage/1 also contains stage0.
+	require.Error(t, d.RenamePrefix([]byte("stage/"), []byte("final/")))
+
+	require.NoError(t, d.Delete([]byte("stage0"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	snap := d.NewSnapshot()
+	require.Error(t, d.RenamePrefix([]byte("stage/"), []byte("final/")))
+	require.NoError(t, snap.Close())
+	require.NoError(t, d.RenamePrefix([]byte("stage/"), []byte("final/")))
+	_, closer, err := d.Get([]byte("final/1"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+}
@@ -571,7 +571,6 @@ const (
 // eventListener returns a Pebble EventListener that is installed on the replay
 // database so that the replay runner has access to internal Pebble events.
 func (r *Runner) eventListener() pebble.EventListener {
-	var writeStallBegin time.Time
 	l := pebble.EventListener{
 		BackgroundError: func(err error) {
 			r.err.Store(err)
@@ -579,11 +578,10 @@ func (r *Runner) eventListener() pebble.EventListener {
 		},
 		WriteStallBegin: func(pebble.WriteStallBeginInfo) {
 			atomic.AddUint64(&r.metrics.writeStalls, 1)
-			writeStallBegin = time.Now()
 		},
-		WriteStallEnd: func() {
+		WriteStallEnd: func(info pebble.WriteStallEndInfo) {
 			atomic.AddUint64(&r.metrics.writeStallsDurationNano,
-				uint64(time.Since(writeStallBegin).Nanoseconds()))
+				uint64(info.Duration.Nanoseconds()))
 		},
 		CompactionBegin: func(_ pebble.CompactionInfo) {
 			r.compactionMu.Lock()
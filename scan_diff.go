@@ -0,0 +1,240 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/cockroachdb/errors"
+)
+
+// DiffKind describes how the visible value of a key changed between two
+// snapshots.
+type DiffKind int8
+
+const (
+	// DiffAdded indicates the key is visible to the newer snapshot, but not
+	// to the older snapshot.
+	DiffAdded DiffKind = iota
+	// DiffDeleted indicates the key is visible to the older snapshot, but not
+	// to the newer snapshot.
+	DiffDeleted
+	// DiffModified indicates the key is visible to both snapshots, with
+	// different values.
+	DiffModified
+)
+
+// String implements fmt.Stringer.
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffDeleted:
+		return "deleted"
+	case DiffModified:
+		return "modified"
+	default:
+		return "unknown"
+	}
+}
+
+// ScanDiff visits the keys within [lower, upper) whose visible values differ
+// between the older and newer snapshots of the DB, in key order. A nil bound
+// leaves the corresponding end of the key space unbounded. The oldValue is nil
+// for added keys, and the newValue is nil for deleted keys. The key and values
+// passed to visit are only valid for the duration of the call. If visit
+// returns an error, the scan stops and the error is returned.
+//
+// Only the keys written after the older snapshot are examined: the files whose
+// keys all precede the older snapshot, as per their metadata, are skipped
+// entirely. The keys written after the older snapshot whose visible values
+// are unchanged, eg, because they were overwritten with the same value, are
+// not visited. The snapshots must be views of the DB, and the older snapshot
+// must not be newer than the newer snapshot.
+//
+// This is an experimental API.
+func (d *DB) ScanDiff(
+	ctx context.Context,
+	older, newer *Snapshot,
+	lower, upper []byte,
+	visit func(key, oldValue, newValue []byte, kind DiffKind) error,
+) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if older.closed() || newer.closed() {
+		panic(ErrClosed)
+	}
+	if older.db != d || newer.db != d {
+		return errors.New("pebble: ScanDiff snapshots must belong to the DB")
+	}
+	if older.seqNum > newer.seqNum {
+		return errors.Errorf("pebble: ScanDiff older snapshot (#%d) is newer than newer snapshot (#%d)",
+			errors.Safe(older.seqNum), errors.Safe(newer.seqNum))
+	}
+	if older.seqNum == newer.seqNum {
+		return nil
+	}
+
+	s := &scanDiff{
+		d:      d,
+		older:  older,
+		upper:  upper,
+		visit:  visit,
+		oldVis: older.NewIterWithContext(ctx, &IterOptions{LowerBound: lower, UpperBound: upper}),
+		newVis: newer.NewIterWithContext(ctx, &IterOptions{LowerBound: lower, UpperBound: upper}),
+	}
+	// The candidates iterator surfaces the keys and range deletions of the
+	// newer snapshot, skipping the files that only contain keys visible to the
+	// older snapshot. Its values may be stale and are ignored.
+	s.candidates = d.newIter(ctx, nil /* batch */, newer, &IterOptions{
+		LowerBound:           lower,
+		UpperBound:           upper,
+		IncludeDeletions:     true,
+		skipFilesBelowSeqNum: older.seqNum,
+	})
+	err := s.run()
+	err = firstError(err, s.candidates.Close())
+	err = firstError(err, s.oldVis.Close())
+	err = firstError(err, s.newVis.Close())
+	return err
+}
+
+// scanDiff holds the state of a DB.ScanDiff.
+type scanDiff struct {
+	d          *DB
+	older      *Snapshot
+	upper      []byte
+	visit      func(key, oldValue, newValue []byte, kind DiffKind) error
+	candidates *Iterator
+	// oldVis and newVis are iterators over the visible keys of the older and
+	// newer snapshots.
+	oldVis, newVis *Iterator
+	keyBuf, endBuf []byte
+}
+
+func (s *scanDiff) run() error {
+	valid := s.candidates.First()
+	for valid {
+		if s.candidates.EntryKind() != IterEntryRangeDelete {
+			s.keyBuf = append(s.keyBuf[:0], s.candidates.Key()...)
+			if err := s.diffKey(s.keyBuf); err != nil {
+				return err
+			}
+			valid = s.candidates.Next()
+			continue
+		}
+		if s.candidates.deletions.seqNum < s.older.seqNum {
+			// The range deletion is visible to the older snapshot.
+			valid = s.candidates.Next()
+			continue
+		}
+		// The keys deleted by the range deletion may have been written to any
+		// file, so diff all the visible keys of the snapshots over the span.
+		// The span may start before the current key if the iterator was
+		// positioned by a seek, in which case the keys preceding it have
+		// already been diffed.
+		_, end := s.candidates.RangeDeleteBounds()
+		s.keyBuf = append(s.keyBuf[:0], s.candidates.Key()...)
+		s.endBuf = append(s.endBuf[:0], end...)
+		if err := s.diffSpan(s.keyBuf, s.endBuf); err != nil {
+			return err
+		}
+		if s.upper != nil && s.d.cmp(s.endBuf, s.upper) >= 0 {
+			break
+		}
+		valid = s.candidates.SeekGE(s.endBuf)
+	}
+	return s.candidates.Error()
+}
+
+// diffKey visits the key if its visible value differs between the snapshots.
+func (s *scanDiff) diffKey(key []byte) error {
+	oldOK := s.oldVis.SeekGE(key) && s.d.equal(s.oldVis.Key(), key)
+	newOK := s.newVis.SeekGE(key) && s.d.equal(s.newVis.Key(), key)
+	if err := firstError(s.oldVis.Error(), s.newVis.Error()); err != nil {
+		return err
+	}
+	switch {
+	case oldOK && newOK:
+		return s.visitModified(key)
+	case oldOK:
+		return s.visitOne(s.oldVis, DiffDeleted)
+	case newOK:
+		return s.visitOne(s.newVis, DiffAdded)
+	}
+	return nil
+}
+
+// diffSpan visits the keys within [start, end) whose visible values differ
+// between the snapshots.
+func (s *scanDiff) diffSpan(start, end []byte) error {
+	oldOK := s.oldVis.SeekGE(start)
+	newOK := s.newVis.SeekGE(start)
+	for {
+		oldOK = oldOK && s.d.cmp(s.oldVis.Key(), end) < 0
+		newOK = newOK && s.d.cmp(s.newVis.Key(), end) < 0
+		if !oldOK && !newOK {
+			break
+		}
+		c := 0
+		switch {
+		case !newOK:
+			c = -1
+		case !oldOK:
+			c = +1
+		default:
+			c = s.d.cmp(s.oldVis.Key(), s.newVis.Key())
+		}
+		var err error
+		switch {
+		case c < 0:
+			err = s.visitOne(s.oldVis, DiffDeleted)
+			oldOK = s.oldVis.Next()
+		case c > 0:
+			err = s.visitOne(s.newVis, DiffAdded)
+			newOK = s.newVis.Next()
+		default:
+			err = s.visitModified(s.newVis.Key())
+			oldOK = s.oldVis.Next()
+			newOK = s.newVis.Next()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return firstError(s.oldVis.Error(), s.newVis.Error())
+}
+
+// visitOne visits the key at the current position of iter, which is only
+// visible to one of the snapshots.
+func (s *scanDiff) visitOne(iter *Iterator, kind DiffKind) error {
+	v, err := iter.ValueAndErr()
+	if err != nil {
+		return err
+	}
+	if kind == DiffAdded {
+		return s.visit(iter.Key(), nil, v, kind)
+	}
+	return s.visit(iter.Key(), v, nil, kind)
+}
+
+// visitModified visits the key at the current positions of the snapshot
+// iterators if its values differ.
+func (s *scanDiff) visitModified(key []byte) error {
+	oldValue, err := s.oldVis.ValueAndErr()
+	if err != nil {
+		return err
+	}
+	newValue, err := s.newVis.ValueAndErr()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(oldValue, newValue) {
+		return nil
+	}
+	return s.visit(key, oldValue, newValue, DiffModified)
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanDiff(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Logger:                      panicLogger{},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	set := func(k, v string) { require.NoError(t, d.Set([]byte(k), []byte(v), nil)) }
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		set(k, "v1-"+k)
+	}
+	require.NoError(t, d.Flush())
+	older := d.NewSnapshot()
+	defer func() { require.NoError(t, older.Close()) }()
+
+	set("b", "v2-b")
+	// Overwriting a key with the same value doesn't change it.
+	set("c", "v1-c")
+	require.NoError(t, d.Delete([]byte("d"), nil))
+	// Deleting a key that doesn't exist doesn't change it.
+	require.NoError(t, d.Delete([]byte("dd"), nil))
+	set("i", "v2-i")
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.DeleteRange([]byte("f"), []byte("gg"), nil))
+	set("ff", "v2-ff")
+	newer := d.NewSnapshot()
+	defer func() { require.NoError(t, newer.Close()) }()
+	// Writes after the newer snapshot are not visible to it.
+	set("a", "v3-a")
+
+	scan := func(older, newer *Snapshot, lower, upper string) []string {
+		var l, u []byte
+		if lower != "" {
+			l = []byte(lower)
+		}
+		if upper != "" {
+			u = []byte(upper)
+		}
+		var got []string
+		require.NoError(t, d.ScanDiff(context.Background(), older, newer, l, u,
+			func(key, oldValue, newValue []byte, kind DiffKind) error {
+				got = append(got, fmt.Sprintf("%s:%s:%s->%s", kind, key, oldValue, newValue))
+				return nil
+			}))
+		return got
+	}
+	require.Equal(t, []string{
+		"modified:b:v1-b->v2-b",
+		"deleted:d:v1-d->",
+		"deleted:f:v1-f->",
+		"added:ff:->v2-ff",
+		"deleted:g:v1-g->",
+		"added:i:->v2-i",
+	}, scan(older, newer, "", ""))
+
+	// The bounds constrain the keys visited, including within range
+	// deletions.
+	require.Equal(t, []string{
+		"deleted:d:v1-d->",
+		"deleted:f:v1-f->",
+	}, scan(older, newer, "c", "fa"))
+	require.Equal(t, []string{
+		"added:ff:->v2-ff",
+		"deleted:g:v1-g->",
+	}, scan(older, newer, "fa", "h"))
+
+	// The diff of a snapshot with itself is empty.
+	require.Empty(t, scan(newer, newer, "", ""))
+
+	// Compacting the keys of the snapshots into the same files doesn't change
+	// the diff.
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	require.Equal(t, []string{
+		"modified:b:v1-b->v2-b",
+		"deleted:d:v1-d->",
+		"deleted:f:v1-f->",
+		"added:ff:->v2-ff",
+		"deleted:g:v1-g->",
+		"added:i:->v2-i",
+	}, scan(older, newer, "", ""))
+
+	// Errors returned by the visitor stop the scan.
+	errStop := errors.New("stop")
+	var visited int
+	err = d.ScanDiff(context.Background(), older, newer, nil, nil,
+		func(key, oldValue, newValue []byte, kind DiffKind) error {
+			visited++
+			return errStop
+		})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, visited)
+
+	// The snapshots must be ordered.
+	require.Error(t, d.ScanDiff(context.Background(), newer, older, nil, nil,
+		func(key, oldValue, newValue []byte, kind DiffKind) error { return nil }))
+}
+
+func TestScanDiffSkipsOlderFiles(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Logger:                      panicLogger{},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v1"), nil))
+	}
+	require.NoError(t, d.Flush())
+	older := d.NewSnapshot()
+	defer func() { require.NoError(t, older.Close()) }()
+	require.NoError(t, d.Set([]byte("k050"), []byte("v2"), nil))
+	require.NoError(t, d.Flush())
+	newer := d.NewSnapshot()
+	defer func() { require.NoError(t, newer.Close()) }()
+
+	// The candidate keys of the diff are only read from the newer file.
+	iter := d.newIter(context.Background(), nil, newer, &IterOptions{
+		IncludeDeletions:     true,
+		skipFilesBelowSeqNum: older.SeqNum(),
+	})
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"k050"}, keys)
+
+	var got []string
+	require.NoError(t, d.ScanDiff(context.Background(), older, newer, nil, nil,
+		func(key, oldValue, newValue []byte, kind DiffKind) error {
+			got = append(got, fmt.Sprintf("%s:%s:%s->%s", kind, key, oldValue, newValue))
+			return nil
+		}))
+	require.Equal(t, []string{"modified:k050:v1->v2"}, got)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
 )
 
 const (
@@ -62,6 +63,10 @@ type SharedSSTMeta struct {
 	// point key bounds of this sstable. Must lie within [Smallest, Largest].
 	SmallestPointKey, LargestPointKey InternalKey
 
+	// SmallestSeqNum and LargestSeqNum are the bounds of the sequence numbers
+	// of the keys of this sstable on the source Pebble instance.
+	SmallestSeqNum, LargestSeqNum uint64
+
 	// Level denotes the level at which this file was present at read time.
 	// For files visited by ScanInternal, this value will only be 5 or 6.
 	Level uint8
@@ -69,11 +74,88 @@ type SharedSSTMeta struct {
 	// Size contains an estimate of the size of this sstable.
 	Size uint64
 
+	// Properties summarizes the contents of this sstable, allowing consumers
+	// to decide whether to use the sstable without opening it.
+	Properties SharedSSTProperties
+
 	// fileNum at time of creation in the creator instance. Only used for
 	// debugging/tests.
 	fileNum base.FileNum
 }
 
+// SharedSSTProperties summarizes the contents of a shared sstable. The summary
+// is derived from the properties of the backing sstable, and so describes the
+// entire backing sstable rather than only the portion of it within the bounds
+// of the SharedSSTMeta.
+type SharedSSTProperties struct {
+	// NumEntries is the number of point keys, including point tombstones and
+	// range deletions.
+	NumEntries uint64
+	// NumDeletions is the number of point tombstones and range deletions.
+	NumDeletions uint64
+	// NumRangeDeletions is the number of range deletions.
+	NumRangeDeletions uint64
+	// NumRangeKeys is the number of range key sets, unsets and deletions.
+	NumRangeKeys uint64
+	// RawKeySize and RawValueSize are the uncompressed sizes of the point keys
+	// and values.
+	RawKeySize, RawValueSize uint64
+	// DataSize is the size of the sstable's data blocks, after compression.
+	DataSize uint64
+	// UserProperties holds the properties recorded by the table and block
+	// property collectors that were configured when the sstable was written.
+	// Block property values are prefixed by an internal identifier; use
+	// BlockProperty to retrieve them.
+	UserProperties map[string]string
+}
+
+// BlockProperty returns the table-level property recorded by the block
+// property collector with the given name, suitable for passing to the
+// Intersects method of a BlockPropertyFilter. For example, a collector of the
+// interval of suffixes within each block records the minimum and maximum
+// suffixes of the sstable. The returned boolean is false if the collector was
+// not used when writing the sstable.
+func (p *SharedSSTProperties) BlockProperty(name string) ([]byte, bool) {
+	prop, ok := p.UserProperties[name]
+	if !ok {
+		return nil, false
+	}
+	if len(prop) == 0 {
+		return nil, true
+	}
+	// Strip the collector's short ID, which occupies the first byte.
+	return []byte(prop[1:]), true
+}
+
+// Intersects returns whether the sstable may contain keys that satisfy the
+// given block property filter. It returns true if the filter's property was
+// not recorded when writing the sstable.
+func (p *SharedSSTProperties) Intersects(filter BlockPropertyFilter) (bool, error) {
+	prop, ok := p.BlockProperty(filter.Name())
+	if !ok {
+		return true, nil
+	}
+	return filter.Intersects(prop)
+}
+
+func (p *SharedSSTProperties) init(props *sstable.Properties) {
+	*p = SharedSSTProperties{
+		NumEntries:        props.NumEntries,
+		NumDeletions:      props.NumDeletions,
+		NumRangeDeletions: props.NumRangeDeletions,
+		NumRangeKeys:      props.NumRangeKeys(),
+		RawKeySize:        props.RawKeySize,
+		RawValueSize:      props.RawValueSize,
+		DataSize:          props.DataSize,
+	}
+	if len(props.UserProperties) > 0 {
+		p.UserProperties = make(map[string]string, len(props.UserProperties))
+		for k, v := range props.UserProperties {
+			p.UserProperties[k] = v
+		}
+	}
+}
+
 func (s *SharedSSTMeta) cloneFromFileMeta(f *fileMetadata) {
 	*s = SharedSSTMeta{
 		Smallest:         f.Smallest.Clone(),
@@ -82,6 +164,8 @@ func (s *SharedSSTMeta) cloneFromFileMeta(f *fileMetadata) {
 		LargestRangeKey:  f.LargestRangeKey.Clone(),
 		SmallestPointKey: f.SmallestPointKey.Clone(),
 		LargestPointKey:  f.LargestPointKey.Clone(),
+		SmallestSeqNum:   f.SmallestSeqNum,
+		LargestSeqNum:    f.LargestSeqNum,
 		Size:             f.Size,
 		fileNum:          f.FileNum,
 	}
@@ -468,6 +552,11 @@ func (d *DB) truncateSharedFile(
 	if err != nil {
 		return nil, false, err
 	}
+	props, err := d.tableCache.getTableProperties(file)
+	if err != nil {
+		return nil, false, err
+	}
+	sst.Properties.init(props)
 	needsLowerTruncate := cmp(lower, file.Smallest.UserKey) > 0
 	needsUpperTruncate := cmp(upper, file.Largest.UserKey) < 0 || (cmp(upper, file.Largest.UserKey) == 0 && !file.Largest.IsExclusiveSentinel())
 	// Fast path: file is entirely within [lower, upper).
@@ -620,7 +709,9 @@ func scanInternalImpl(
 				if err != nil {
 					return err
 				}
-				if !objMeta.IsShared() {
+				// Tables attached with a prefix replacement can't be shared
+				// further, as the replacement isn't part of the shared backing.
+				if !objMeta.IsShared() || f.PrefixReplacement != nil {
 					return errors.Wrapf(ErrInvalidSkipSharedIteration, "when processing file %s", objMeta.DiskFileNum)
 				}
 				var sst *SharedSSTMeta
@@ -210,6 +210,8 @@ func TestScanInternal(t *testing.T) {
 			var reader scanInternalReader = d
 			var b strings.Builder
 			var fileVisitor func(sst *SharedSSTMeta) error
+			var sharedProps bool
+			var suffixFilter BlockPropertyFilter
 			for _, arg := range td.CmdArgs {
 				switch arg.Key {
 				case "lower":
@@ -226,8 +228,26 @@ func TestScanInternal(t *testing.T) {
 				case "skip-shared":
 					fileVisitor = func(sst *SharedSSTMeta) error {
 						fmt.Fprintf(&b, "shared file: %s [%s-%s]\n", sst.fileNum, sst.Smallest.String(), sst.Largest.String())
+						if sharedProps {
+							p := &sst.Properties
+							fmt.Fprintf(&b, "  entries=%d deletions=%d range-dels=%d range-keys=%d raw-key-size=%d raw-value-size=%d\n",
+								p.NumEntries, p.NumDeletions, p.NumRangeDeletions, p.NumRangeKeys, p.RawKeySize, p.RawValueSize)
+						}
+						if suffixFilter != nil {
+							ok, err := sst.Properties.Intersects(suffixFilter)
+							if err != nil {
+								return err
+							}
+							fmt.Fprintf(&b, "  intersects-suffix-filter=%t\n", ok)
+						}
 						return nil
 					}
+				case "shared-props":
+					sharedProps = true
+				case "suffix-filter":
+					var lo, hi uint64
+					td.ScanArgs(t, "suffix-filter", &lo, &hi)
+					suffixFilter = sstable.NewTestKeysBlockPropertyFilter(lo, hi)
 				}
 			}
 			err := reader.ScanInternal(context.TODO(), lower, upper, func(key *InternalKey, value LazyValue) error {
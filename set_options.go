@@ -0,0 +1,142 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+)
+
+// SetOptions changes a subset of the options of an open DB, without reopening
+// it. The options are named as in the [Options] section of the OPTIONS file
+// (see Options.String), and their values are parsed the same way. The options
+// that may be changed are:
+//
+//	max_concurrent_compactions  Options.MaxConcurrentCompactions
+//	mem_table_size              Options.MemTableSize
+//	l0_compaction_threshold     Options.L0CompactionThreshold
+//	l0_stop_writes_threshold    Options.L0StopWritesThreshold
+//	bytes_per_sync              Options.BytesPerSync
+//	wal_bytes_per_sync          Options.WALBytesPerSync
+//	cache_size                  the maximum size of Options.Cache
+//
+// L0CompactionThreshold is the L0 read amplification at which compactions out
+// of L0 start, and thus the threshold beyond which writes may be slowed down
+// by compaction debt, while writes stop at L0StopWritesThreshold.
+//
+// Either all the options are changed, or, if an option is unknown or its value
+// is invalid, none are. The changes take effect for subsequent memtables,
+// compactions and files, and aren't persisted to the OPTIONS file. Note that
+// the block cache may be shared by other DBs, which are also affected by a
+// change of its size.
+func (d *DB) SetOptions(opts map[string]string) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	// Parse the options in a deterministic order, so that errors are too.
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	o := Options{
+		MemTableSize:          d.opts.MemTableSize,
+		L0CompactionThreshold: d.opts.L0CompactionThreshold,
+		L0StopWritesThreshold: d.opts.L0StopWritesThreshold,
+		BytesPerSync:          d.opts.BytesPerSync,
+		WALBytesPerSync:       d.opts.WALBytesPerSync,
+	}
+	// Unless it's changed, MaxConcurrentCompactions keeps following the
+	// configured function.
+	var maxConcurrentCompactions int
+	cacheSize := d.opts.Cache.MaxSize()
+	for _, key := range keys {
+		value := opts[key]
+		var err error
+		switch key {
+		case "max_concurrent_compactions":
+			maxConcurrentCompactions, err = strconv.Atoi(value)
+			if err == nil && maxConcurrentCompactions <= 0 {
+				err = errors.Errorf("max_concurrent_compactions (%d) must be > 0", maxConcurrentCompactions)
+			}
+		case "mem_table_size":
+			o.MemTableSize, err = strconv.Atoi(value)
+			if err == nil && o.MemTableSize <= 0 {
+				err = errors.Errorf("mem_table_size (%d) must be > 0", o.MemTableSize)
+			}
+		case "l0_compaction_threshold":
+			o.L0CompactionThreshold, err = strconv.Atoi(value)
+			if err == nil && o.L0CompactionThreshold <= 0 {
+				err = errors.Errorf("l0_compaction_threshold (%d) must be > 0", o.L0CompactionThreshold)
+			}
+		case "l0_stop_writes_threshold":
+			o.L0StopWritesThreshold, err = strconv.Atoi(value)
+		case "bytes_per_sync":
+			o.BytesPerSync, err = strconv.Atoi(value)
+			if err == nil && o.BytesPerSync <= 0 {
+				err = errors.Errorf("bytes_per_sync (%d) must be > 0", o.BytesPerSync)
+			}
+		case "wal_bytes_per_sync":
+			o.WALBytesPerSync, err = strconv.Atoi(value)
+			if err == nil && o.WALBytesPerSync < 0 {
+				err = errors.Errorf("wal_bytes_per_sync (%d) must be >= 0", o.WALBytesPerSync)
+			}
+		case "cache_size":
+			cacheSize, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && cacheSize < 0 {
+				err = errors.Errorf("cache_size (%d) must be >= 0", cacheSize)
+			}
+		default:
+			return errors.Errorf("pebble: option %s cannot be changed", errors.Safe(key))
+		}
+		if err != nil {
+			return errors.Wrapf(err, "pebble: invalid value for option %s", errors.Safe(key))
+		}
+	}
+	if uint64(o.MemTableSize) >= maxMemTableSize {
+		return errors.Errorf("pebble: mem_table_size (%d) must be < %d", o.MemTableSize, maxMemTableSize)
+	}
+	if o.L0StopWritesThreshold < o.L0CompactionThreshold {
+		return errors.Errorf("pebble: l0_stop_writes_threshold (%d) must be >= l0_compaction_threshold (%d)",
+			o.L0StopWritesThreshold, o.L0CompactionThreshold)
+	}
+
+	// The options read by background goroutines without holding DB.mu are
+	// changed atomically. The others are read while holding DB.mu.
+	if maxConcurrentCompactions > 0 {
+		d.maxConcurrentCompactions.Store(int32(maxConcurrentCompactions))
+	}
+	d.opts.MemTableSize = o.MemTableSize
+	d.setLargeBatchThreshold(o.MemTableSize)
+	if d.mu.mem.nextSize > o.MemTableSize {
+		d.mu.mem.nextSize = o.MemTableSize
+	}
+	d.opts.L0CompactionThreshold = o.L0CompactionThreshold
+	d.opts.L0StopWritesThreshold = o.L0StopWritesThreshold
+	d.opts.BytesPerSync = o.BytesPerSync
+	d.objProvider.SetBytesPerSync(o.BytesPerSync)
+	d.opts.WALBytesPerSync = o.WALBytesPerSync
+	if cacheSize != d.opts.Cache.MaxSize() {
+		d.opts.Cache.SetMaxSize(cacheSize)
+	}
+
+	// Writes stalled on the previous thresholds reevaluate them, and
+	// compactions are scheduled according to the new ones.
+	d.mu.compact.cond.Broadcast()
+	d.maybeScheduleCompaction()
+	return nil
+}
+
+// setLargeBatchThreshold sets the threshold beyond which batches are "large"
+// for the given memtable size.
+func (d *DB) setLargeBatchThreshold(memTableSize int) {
+	d.largeBatchThreshold.Store(int64(memTableSize-int(memTableEmptySize)) / 2)
+}
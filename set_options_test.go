@@ -0,0 +1,129 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetOptions(t *testing.T) {
+	c := cache.New(1 << 20)
+	defer c.Unref()
+	d, err := Open("", &Options{
+		Cache:                       c,
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		L0CompactionThreshold:       1,
+		L0StopWritesThreshold:       2,
+		MaxConcurrentCompactions:    func() int { return 2 },
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Invalid changes don't change any option.
+	for _, opts := range []map[string]string{
+		{"mem_table_size": "1024", "comparer": "leveldb.BytewiseComparator"},
+		{"mem_table_size": "1024", "max_concurrent_compactions": "0"},
+		{"mem_table_size": "1024", "l0_compaction_threshold": "3"},
+		{"mem_table_size": "1024", "bytes_per_sync": "foo"},
+		{"mem_table_size": "1024", "bytes_per_sync": "0"},
+		{"mem_table_size": "8589934592"},
+	} {
+		require.Error(t, d.SetOptions(opts), "%v", opts)
+		require.Equal(t, 4<<20, d.opts.MemTableSize)
+		require.Equal(t, 2, d.opts.MaxConcurrentCompactions())
+	}
+
+	// Writes stalled on the L0 read amplification resume once the stop
+	// threshold is raised.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, d.Set([]byte("a"), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	flushed := make(chan error, 1)
+	go func() { flushed <- d.Flush() }()
+	select {
+	case <-flushed:
+		t.Fatal("flush unexpectedly did not stall")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.NoError(t, d.SetOptions(map[string]string{
+		"l0_stop_writes_threshold":   "10",
+		"mem_table_size":             "1048576",
+		"max_concurrent_compactions": "3",
+		"bytes_per_sync":             "1024",
+		"wal_bytes_per_sync":         "4096",
+		"cache_size":                 "4096",
+	}))
+	require.NoError(t, <-flushed)
+
+	require.Equal(t, 10, d.opts.L0StopWritesThreshold)
+	require.Equal(t, 1<<20, d.opts.MemTableSize)
+	require.EqualValues(t, (1<<20-memTableEmptySize)/2, d.largeBatchThreshold.Load())
+	require.Equal(t, 3, d.opts.MaxConcurrentCompactions())
+	require.Equal(t, 1024, d.opts.BytesPerSync)
+	require.Equal(t, 4096, d.opts.WALBytesPerSync)
+	require.EqualValues(t, 4096, c.MaxSize())
+	// New memtables are sized according to the new MemTableSize.
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	require.LessOrEqual(t, len(d.mu.mem.mutable.arenaBuf), 1<<20)
+	d.mu.Unlock()
+}
+
+// TestSetOptionsConcurrentWALRotation changes the options WAL files are
+// created with while WALs rotate, for the race detector to check that they're
+// only read while holding DB.mu.
+func TestSetOptionsConcurrentWALRotation(t *testing.T) {
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatStripedWAL,
+		MemTableSize:       256 << 10,
+	}
+	opts.Experimental.WALStripeDirs = []string{"stripe1"}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := d.SetOptions(map[string]string{
+				"mem_table_size":     strconv.Itoa((256 + i%64) << 10),
+				"wal_bytes_per_sync": strconv.Itoa(i % 64 << 10),
+			}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%03d", i)), nil, nil))
+		// Flushing rotates the WAL.
+		require.NoError(t, d.Flush())
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// ShortLivedSpan configures a span of user keys [Start, End) holding
+// short-lived data, such as session state, whose keys are only read for a
+// bounded time after being written. See
+// Options.Experimental.ShortLivedSpans.
+//
+// Flushes split their output at the bounds of short-lived spans, and the
+// sstables they write within a span stay in L0 outside of the main LSM: they
+// are never picked by automatic L0 compactions, which avoids the write
+// amplification of merging them into lower levels. Instead, an sstable is
+// dropped in its entirety by a delete-only compaction once TTL has elapsed
+// since it was flushed, oldest first. The keys of the span are thus expected
+// to be written with the knowledge that they disappear at least TTL after
+// being written, which is a relaxation of the usual guarantees of the DB.
+//
+// An expired sstable is only dropped if doing so doesn't uncover older
+// versions of its keys, ie, once the older sstables it overlaps have also
+// expired, and if no open snapshot may read its keys. Expiry is checked
+// whenever compactions are scheduled, eg, after flushes.
+//
+// The sstables of short-lived spans contribute to the read amplification of
+// L0, and count towards L0StopWritesThreshold, so that TTL should be chosen
+// such that few flushes of a span happen within it.
+type ShortLivedSpan struct {
+	Start, End []byte
+	// TTL is the time after which the sstables flushed within the span are
+	// dropped. A zero TTL never drops sstables, which only stay outside of
+	// the main LSM until they're compacted manually.
+	TTL time.Duration
+}
+
+// findShortLivedSpan returns the short-lived span containing the given
+// bounds, or nil if none.
+func findShortLivedSpan(
+	cmp Compare, spans []ShortLivedSpan, smallest, largest InternalKey,
+) *ShortLivedSpan {
+	i := sort.Search(len(spans), func(i int) bool {
+		return cmp(spans[i].End, smallest.UserKey) > 0
+	})
+	if i == len(spans) || cmp(spans[i].Start, smallest.UserKey) > 0 {
+		return nil
+	}
+	if c := cmp(largest.UserKey, spans[i].End); c > 0 || (c == 0 && !largest.IsExclusiveSentinel()) {
+		return nil
+	}
+	return &spans[i]
+}
+
+// findShortLivedLimit returns the next bound of a short-lived span after the
+// given key, so that flushes write the keys of short-lived spans to their own
+// sstables.
+func (c *compaction) findShortLivedLimit(start []byte) []byte {
+	spans := c.shortLivedSpans
+	i := sort.Search(len(spans), func(i int) bool {
+		return c.cmp(spans[i].End, start) > 0
+	})
+	if i == len(spans) {
+		return nil
+	}
+	if c.cmp(spans[i].Start, start) > 0 {
+		return spans[i].Start
+	}
+	return spans[i].End
+}
+
+// markShortLivedFilesLocked flags the L0 sstables of the current version that
+// fall within short-lived spans. The flag isn't persisted, so that the
+// sstables of spans removed from the options rejoin the main LSM.
+//
+// d.mu must be held when calling this.
+func (d *DB) markShortLivedFilesLocked() {
+	v := d.mu.versions.currentVersion()
+	iter := v.Levels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		f.ShortLived = findShortLivedSpan(d.cmp, d.opts.Experimental.ShortLivedSpans, f.Smallest, f.Largest) != nil
+	}
+	v.L0Sublevels.InitCompactingFileInfo(inProgressL0Compactions(d.getInProgressCompactionInfoLocked(nil)))
+}
+
+// pickExpiredShortLivedFiles returns the L0 sstables of short-lived spans
+// which expired and may be dropped by a delete-only compaction, along with
+// their total size.
+//
+// d.mu must be held when calling this.
+func (d *DB) pickExpiredShortLivedFiles(
+	v *version, snapshots []uint64,
+) (_ []compactionLevel, size uint64) {
+	now := d.timeNow()
+	var candidates []*fileMetadata
+	iter := v.Levels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if !f.ShortLived || f.IsCompacting() {
+			continue
+		}
+		s := findShortLivedSpan(d.cmp, d.opts.Experimental.ShortLivedSpans, f.Smallest, f.Largest)
+		if s == nil || s.TTL <= 0 || now.Sub(time.Unix(f.CreationTime, 0)) < s.TTL {
+			continue
+		}
+		// An open snapshot more recent than the oldest key of the sstable may
+		// read its keys.
+		if len(snapshots) > 0 && snapshots[len(snapshots)-1] > f.SmallestSeqNum {
+			continue
+		}
+		candidates = append(candidates, f)
+	}
+	if len(candidates) == 0 {
+		return nil, 0
+	}
+
+	// An sstable may only be dropped along with all of the sstables holding
+	// older keys that it may shadow. Considering the candidates from oldest
+	// to newest, one is dropped if the older sstables it overlaps were.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LargestSeqNum < candidates[j].LargestSeqNum
+	})
+	dropped := make(map[*fileMetadata]bool, len(candidates))
+	var files []*fileMetadata
+	for _, f := range candidates {
+		if d.shortLivedFileDroppable(v, f, dropped) {
+			dropped[f] = true
+			files = append(files, f)
+			size += f.Size
+		}
+	}
+	if len(files) == 0 {
+		return nil, 0
+	}
+	return []compactionLevel{{
+		level: 0,
+		files: manifest.NewLevelSliceSeqSorted(files),
+	}}, size
+}
+
+// shortLivedFileDroppable returns true if every sstable overlapping f that
+// may hold keys older than f's keys is in dropped.
+func (d *DB) shortLivedFileDroppable(
+	v *version, f *fileMetadata, dropped map[*fileMetadata]bool,
+) bool {
+	for level := range v.Levels {
+		overlaps := v.Overlaps(level, d.cmp, f.Smallest.UserKey, f.Largest.UserKey,
+			f.Largest.IsExclusiveSentinel())
+		iter := overlaps.Iter()
+		for m := iter.First(); m != nil; m = iter.Next() {
+			if m == f || m.SmallestSeqNum >= f.LargestSeqNum || dropped[m] {
+				continue
+			}
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShortLivedSpans(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                    mem,
+		L0CompactionThreshold: 1,
+	}
+	opts.Experimental.ShortLivedSpans = []ShortLivedSpan{
+		{Start: []byte("s"), End: []byte("t"), TTL: time.Hour},
+	}
+	open := func() *DB {
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		return d
+	}
+	waitForCompactions := func(d *DB) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+	// l0 returns the number of short-lived and other files of L0.
+	l0 := func(d *DB) (shortLived, other int) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		iter := d.mu.versions.currentVersion().Levels[0].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.ShortLived {
+				require.True(t, findShortLivedSpan(d.cmp, opts.Experimental.ShortLivedSpans, f.Smallest, f.Largest) != nil)
+				shortLived++
+			} else {
+				other++
+			}
+		}
+		return shortLived, other
+	}
+	write := func(d *DB, value string) {
+		for _, prefix := range []string{"a", "s", "z"} {
+			for i := 0; i < 10; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%02d", prefix, i)), []byte(value), nil))
+			}
+		}
+		require.NoError(t, d.Flush())
+		waitForCompactions(d)
+	}
+	// age makes the files of L0 an hour older.
+	age := func(d *DB) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		iter := d.mu.versions.currentVersion().Levels[0].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			f.CreationTime -= int64(time.Hour / time.Second)
+		}
+	}
+	get := func(d *DB, key string) string {
+		v, closer, err := d.Get([]byte(key))
+		if err == ErrNotFound {
+			return ""
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	d := open()
+	for i := 0; i < 3; i++ {
+		write(d, fmt.Sprint(i))
+	}
+	// The flushes split their output at the bounds of the short-lived span,
+	// and only the other files are compacted out of L0.
+	shortLived, other := l0(d)
+	require.Equal(t, 3, shortLived)
+	require.Zero(t, other)
+	require.Equal(t, "2", get(d, "s05"))
+	require.Equal(t, "2", get(d, "z05"))
+
+	// The flag is restored when the DB is reopened.
+	require.NoError(t, d.Close())
+	d = open()
+	shortLived, _ = l0(d)
+	require.Equal(t, 3, shortLived)
+
+	// Once expired, the files are dropped, unless an open snapshot may read
+	// them.
+	s := d.NewSnapshot()
+	age(d)
+	write(d, "3")
+	shortLived, _ = l0(d)
+	require.Equal(t, 4, shortLived)
+	require.NoError(t, s.Close())
+
+	write(d, "4")
+	shortLived, _ = l0(d)
+	require.Equal(t, 2, shortLived)
+	require.Equal(t, "4", get(d, "s05"))
+	m := d.Metrics()
+	require.EqualValues(t, 1, m.Compact.ShortLivedExpiredCount)
+	require.NotZero(t, m.Compact.ShortLivedExpiredBytes)
+
+	age(d)
+	write(d, "5")
+	shortLived, _ = l0(d)
+	require.Equal(t, 1, shortLived)
+	require.Equal(t, "5", get(d, "s05"))
+	require.Equal(t, "5", get(d, "a05"))
+	require.NoError(t, d.Close())
+}
+
+func TestShortLivedFileDroppable(t *testing.T) {
+	opts := (&Options{}).EnsureDefaults()
+	d := &DB{cmp: opts.Comparer.Compare, opts: opts}
+	file := func(num int, smallest, largest string, smallestSeq, largestSeq uint64) *fileMetadata {
+		m := (&fileMetadata{
+			FileNum:        base.FileNum(num),
+			SmallestSeqNum: smallestSeq,
+			LargestSeqNum:  largestSeq,
+		}).ExtendPointKeyBounds(d.cmp,
+			base.MakeInternalKey([]byte(smallest), largestSeq, InternalKeyKindSet),
+			base.MakeInternalKey([]byte(largest), smallestSeq, InternalKeyKindSet))
+		m.InitPhysicalBacking()
+		return m
+	}
+	older := file(1, "s1", "s5", 1, 10)
+	newer := file(2, "s3", "s8", 11, 20)
+	disjoint := file(3, "s6", "s9", 1, 10)
+	v := newVersion(opts, [numLevels][]*fileMetadata{0: {older, newer}, 6: {disjoint}})
+
+	dropped := map[*fileMetadata]bool{}
+	require.False(t, d.shortLivedFileDroppable(v, newer, dropped))
+	require.True(t, d.shortLivedFileDroppable(v, older, dropped))
+	dropped[older] = true
+	require.False(t, d.shortLivedFileDroppable(v, newer, dropped))
+	dropped[disjoint] = true
+	require.True(t, d.shortLivedFileDroppable(v, newer, dropped))
+}
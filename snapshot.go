@@ -9,14 +9,23 @@ import (
 	"io"
 	"math"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 )
 
+// ErrOutsideSnapshotBounds is returned by Snapshot.Get for keys outside the
+// bounds of a bounded snapshot (see Snapshot.CloneWithBounds).
+var ErrOutsideSnapshotBounds = errors.New("pebble: key outside of snapshot bounds")
+
 // Snapshot provides a read-only point-in-time view of the DB state.
 type Snapshot struct {
 	// The db the snapshot was created from.
 	db     *DB
 	seqNum uint64
+	// lower and upper are set if the snapshot is bounded (see CloneWithBounds),
+	// in which case it only pins the history of the keys within [lower, upper).
+	// Nil bounds are unbounded.
+	lower, upper []byte
 
 	// The list the snapshot is linked into.
 	list *snapshotList
@@ -35,10 +44,13 @@ var _ Reader = (*Snapshot)(nil)
 // slice will remain valid until the returned Closer is closed. On success, the
 // caller MUST call closer.Close() or a memory leak will occur.
 func (s *Snapshot) Get(key []byte) ([]byte, io.Closer, error) {
-	if s.db == nil {
+	if s.closed() {
 		panic(ErrClosed)
 	}
-	return s.db.getInternal(key, nil /* batch */, s)
+	if !s.contains(key) {
+		return nil, nil, ErrOutsideSnapshotBounds
+	}
+	return s.db.getInternal(context.Background(), key, nil /* batch */, s)
 }
 
 // NewIter returns an iterator that is unpositioned (Iterator.Valid() will
@@ -51,7 +63,7 @@ func (s *Snapshot) NewIter(o *IterOptions) *Iterator {
 // NewIterWithContext is like NewIter, and additionally accepts a context for
 // tracing.
 func (s *Snapshot) NewIterWithContext(ctx context.Context, o *IterOptions) *Iterator {
-	if s.db == nil {
+	if s.closed() {
 		panic(ErrClosed)
 	}
 	return s.db.newIter(ctx, nil /* batch */, s, o)
@@ -71,9 +83,10 @@ func (s *Snapshot) ScanInternal(
 	visitRangeKey func(start, end []byte, keys []keyspan.Key) error,
 	visitSharedFile func(sst *SharedSSTMeta) error,
 ) error {
-	if s.db == nil {
+	if s.closed() {
 		panic(ErrClosed)
 	}
+	lower, upper = constrainBounds(s.db.cmp, lower, upper, s.lower, s.upper)
 	iter := s.db.newInternalIter(s, &scanInternalOptions{
 		IterOptions: IterOptions{
 			KeyTypes:   IterKeyTypePointsAndRanges,
@@ -87,6 +100,103 @@ func (s *Snapshot) ScanInternal(
 	return scanInternalImpl(ctx, lower, upper, iter, visitPointKey, visitRangeDel, visitRangeKey, visitSharedFile)
 }
 
+// SeqNum returns the sequence number at which the snapshot reads. Only keys
+// with sequence numbers less than SeqNum are visible to the snapshot.
+func (s *Snapshot) SeqNum() uint64 {
+	return s.seqNum
+}
+
+// WithSeqNumCeiling returns a view of the snapshot that reads at the lesser of
+// the snapshot's sequence number and seqNum: only keys with sequence numbers
+// less than both are visible to it. This allows reading "as of" an earlier
+// point within the snapshot (eg, before a batch with a known sequence number,
+// see Batch.SeqNum), which a new snapshot can't do.
+//
+// The view is linked into the DB's snapshot list like any snapshot, so that
+// compactions preserve the versions of keys visible to it. However, versions
+// that were only visible at seqNum may have been compacted away before the
+// view was created, since s didn't pin them: if a key was overwritten or
+// deleted by a write with a sequence number in [seqNum, s.SeqNum()), the view
+// may observe an older version of the key or none at all. The view is exact
+// for keys that were not written within that range.
+//
+// The returned view is independent of s and must be closed separately.
+func (s *Snapshot) WithSeqNumCeiling(seqNum uint64) *Snapshot {
+	if s.closed() {
+		panic(ErrClosed)
+	}
+	v := &Snapshot{db: s.db, seqNum: s.seqNum, lower: s.lower, upper: s.upper}
+	if seqNum < v.seqNum {
+		v.seqNum = seqNum
+	}
+	s.db.mu.Lock()
+	s.db.mu.snapshots.insert(v)
+	s.db.mu.Unlock()
+	return v
+}
+
+// CloneWithBounds returns a new snapshot reading at the same sequence number as
+// s, which only pins the history of the keys within [lower, upper). Nil bounds
+// are unbounded. If s is itself bounded, the bounds are intersected with those
+// of s.
+//
+// Compactions of key ranges that don't overlap the bounds ignore the returned
+// snapshot, so they're free to drop the obsolete versions of keys outside the
+// bounds. Accordingly, the returned snapshot only reads the keys within its
+// bounds: Get returns ErrOutsideSnapshotBounds for keys outside them, and the
+// bounds of its iterators are constrained to them.
+//
+// The returned snapshot is independent of s and must be closed separately.
+// Closing s after cloning it releases the history outside the bounds, eg,
+// when backing up a single key range.
+func (s *Snapshot) CloneWithBounds(lower, upper []byte) *Snapshot {
+	if s.closed() {
+		panic(ErrClosed)
+	}
+	lower, upper = constrainBounds(s.db.cmp, lower, upper, s.lower, s.upper)
+	c := &Snapshot{db: s.db, seqNum: s.seqNum}
+	if lower != nil {
+		c.lower = append([]byte(nil), lower...)
+	}
+	if upper != nil {
+		c.upper = append([]byte(nil), upper...)
+	}
+	s.db.mu.Lock()
+	s.db.mu.snapshots.insert(c)
+	s.db.mu.Unlock()
+	return c
+}
+
+// contains returns true if the key is within the snapshot's bounds.
+func (s *Snapshot) contains(key []byte) bool {
+	return (s.lower == nil || s.db.cmp(key, s.lower) >= 0) &&
+		(s.upper == nil || s.db.cmp(key, s.upper) < 0)
+}
+
+// constrainBounds intersects the bounds [lower, upper) with the bounds of a
+// bounded snapshot. Nil bounds are unbounded.
+func constrainBounds(cmp Compare, lower, upper, snapLower, snapUpper []byte) ([]byte, []byte) {
+	if snapLower == nil && snapUpper == nil {
+		return lower, upper
+	}
+	if snapLower != nil && (lower == nil || cmp(lower, snapLower) < 0) {
+		lower = snapLower
+	}
+	if snapUpper != nil && (upper == nil || cmp(upper, snapUpper) > 0) {
+		upper = snapUpper
+	}
+	// Bounds disjoint from the snapshot's are constrained to an empty range.
+	if lower != nil && upper != nil && cmp(lower, upper) > 0 {
+		lower = upper
+	}
+	return lower, upper
+}
+
+// closed returns true if the snapshot has been closed.
+func (s *Snapshot) closed() bool {
+	return s.db == nil
+}
+
 // Close closes the snapshot, releasing its resources. Close must be called.
 // Failure to do so will result in a tiny memory leak and a large leak of
 // resources on disk due to the entries the snapshot is preventing from being
@@ -151,6 +261,23 @@ func (l *snapshotList) toSlice() []uint64 {
 	return results
 }
 
+// toSliceOverlapping is like toSlice, but omits the bounded snapshots whose
+// bounds don't overlap the user key range [smallest, largest].
+func (l *snapshotList) toSliceOverlapping(cmp Compare, smallest, largest []byte) []uint64 {
+	if l.empty() {
+		return nil
+	}
+	var results []uint64
+	for i := l.root.next; i != &l.root; i = i.next {
+		if (i.lower != nil && cmp(largest, i.lower) < 0) ||
+			(i.upper != nil && cmp(smallest, i.upper) >= 0) {
+			continue
+		}
+		results = append(results, i.seqNum)
+	}
+	return results
+}
+
 func (l *snapshotList) pushBack(s *Snapshot) {
 	if s.list != nil || s.prev != nil || s.next != nil {
 		panic("pebble: snapshot list is inconsistent")
@@ -162,6 +289,23 @@ func (l *snapshotList) pushBack(s *Snapshot) {
 	s.list = l
 }
 
+// insert links s into the list, which is ordered by sequence number, after
+// the snapshots with the same sequence number.
+func (l *snapshotList) insert(s *Snapshot) {
+	if s.list != nil || s.prev != nil || s.next != nil {
+		panic("pebble: snapshot list is inconsistent")
+	}
+	at := l.root.prev
+	for at != &l.root && at.seqNum > s.seqNum {
+		at = at.prev
+	}
+	s.prev = at
+	s.next = at.next
+	s.prev.next = s
+	s.next.prev = s
+	s.list = l
+}
+
 func (l *snapshotList) remove(s *Snapshot) {
 	if s == &l.root {
 		panic("pebble: cannot remove snapshot list root node")
@@ -348,3 +348,154 @@ func TestNewSnapshotRace(t *testing.T) {
 	wg.Wait()
 	require.NoError(t, d.Close())
 }
+
+func TestSnapshotWithSeqNumCeiling(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	apply := func(fn func(b *Batch)) uint64 {
+		b := d.NewBatch()
+		fn(b)
+		require.NoError(t, d.Apply(b, nil))
+		return b.SeqNum()
+	}
+	apply(func(b *Batch) { _ = b.Set([]byte("a"), []byte("1"), nil) })
+	seqB := apply(func(b *Batch) { _ = b.Set([]byte("b"), []byte("1"), nil) })
+	seqC := apply(func(b *Batch) { _ = b.Set([]byte("c"), []byte("1"), nil) })
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	// Writes after the snapshot are not visible to any view of it.
+	apply(func(b *Batch) { _ = b.Set([]byte("d"), []byte("1"), nil) })
+
+	scan := func(s *Snapshot) string {
+		iter := s.NewIter(nil)
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(keys, ",")
+	}
+	scanAt := func(seqNum uint64) string {
+		v := snap.WithSeqNumCeiling(seqNum)
+		defer func() { require.NoError(t, v.Close()) }()
+		return scan(v)
+	}
+	require.Equal(t, "a,b,c", scan(snap))
+	require.Equal(t, "a", scanAt(seqB))
+	require.Equal(t, "a,b", scanAt(seqC))
+	require.Equal(t, "a,b,c", scanAt(seqC+100))
+
+	// Views of views use the lowest ceiling.
+	v1 := snap.WithSeqNumCeiling(seqC)
+	v := v1.WithSeqNumCeiling(seqC + 100)
+	require.NoError(t, v1.Close())
+	require.Equal(t, seqC, v.SeqNum())
+	_, _, err = v.Get([]byte("c"))
+	require.Equal(t, ErrNotFound, err)
+	val, closer, err := v.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(val))
+	require.NoError(t, closer.Close())
+
+	// Closing a view does not close the snapshot.
+	require.NoError(t, v.Close())
+	require.Equal(t, "a,b,c", scan(snap))
+}
+
+func TestSnapshotWithSeqNumCeilingPinsVersions(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("a"), []byte("2"), nil))
+	require.NoError(t, d.Apply(b, nil))
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	v := snap.WithSeqNumCeiling(b.SeqNum())
+	defer func() { require.NoError(t, v.Close()) }()
+	d.mu.Lock()
+	require.Equal(t, []uint64{b.SeqNum(), snap.SeqNum()}, d.mu.snapshots.toSlice())
+	d.mu.Unlock()
+
+	// The first version of a is only visible to the view, which keeps it from
+	// being compacted away.
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false /* parallelize */))
+	val, closer, err := v.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(val))
+	require.NoError(t, closer.Close())
+}
+
+func TestSnapshotCloneWithBounds(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Each version is compacted into its own table, so that each compaction
+	// only compacts the versions of a single key.
+	set := func(key, value string) {
+		require.NoError(t, d.Set([]byte(key), []byte(value), nil))
+		require.NoError(t, d.Flush())
+		end := []byte(key + "\x00")
+		require.NoError(t, d.Compact([]byte(key), end, false /* parallelize */))
+	}
+	set("a", "1")
+	set("m", "1")
+	snap := d.NewSnapshot()
+	bounded := snap.CloneWithBounds([]byte("k"), []byte("n"))
+	defer func() { require.NoError(t, bounded.Close()) }()
+	require.NoError(t, snap.Close())
+
+	// The history of a is no longer pinned, unlike that of m.
+	set("a", "2")
+	set("m", "2")
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	var entries uint64
+	for level := range tables {
+		for _, table := range tables[level] {
+			entries += table.Properties.NumEntries
+		}
+	}
+	require.Equal(t, uint64(3), entries)
+
+	val, closer, err := bounded.Get([]byte("m"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(val))
+	require.NoError(t, closer.Close())
+	_, _, err = bounded.Get([]byte("a"))
+	require.Equal(t, ErrOutsideSnapshotBounds, err)
+
+	// The bounds of iterators are constrained to those of the snapshot.
+	scan := func(iter *Iterator) string {
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return strings.Join(keys, ",")
+	}
+	iter := bounded.NewIter(nil)
+	require.Equal(t, "m", scan(iter))
+	iter.SetBounds([]byte("a"), nil)
+	require.Equal(t, "m", scan(iter))
+	iter.SetBounds([]byte("a"), []byte("b"))
+	require.Equal(t, "", scan(iter))
+	require.NoError(t, iter.Close())
+
+	// Clones of views of snapshots are ordered by their sequence numbers.
+	view := bounded.WithSeqNumCeiling(bounded.SeqNum() - 1)
+	clone := view.CloneWithBounds(nil, []byte("l"))
+	require.NoError(t, view.Close())
+	require.Equal(t, []byte("k"), clone.lower)
+	require.Equal(t, []byte("l"), clone.upper)
+	d.mu.Lock()
+	require.Equal(t, []uint64{clone.SeqNum(), bounded.SeqNum()}, d.mu.snapshots.toSlice())
+	require.Equal(t, []uint64{bounded.SeqNum()}, d.mu.snapshots.toSliceOverlapping(d.cmp, []byte("l"), []byte("z")))
+	d.mu.Unlock()
+	require.NoError(t, clone.Close())
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/blob"
+)
+
+// Blob values
+//
+// The value of a SET may be stored in a blob file (see the internal/blob
+// package) rather than in the table, in which case the table stores a
+// blob.Handle in its place. Such values are added with
+// Writer.AddWithBlobHandle, and are stored with the valueKindIsBlobHandle
+// value prefix, so they require TableFormatPebblev3 or later:
+//
+//	+---------------+-----------------------------+
+//	| valuePrefix   | blob.Handle (Handle.Encode) |
+//	+---------------+-----------------------------+
+//
+// Since the handle starts with the length of the value, it is decoded like a
+// valueHandle into the length and the remainder of the handle, and the
+// iterators of the table return a LazyValue whose ValueOrHandle is the
+// remainder, fetched through ReaderOptions.BlobValueFetcher. The table does
+// not know about the blob files it references: the value checksums of
+// WriterOptions.ValueChecksums do not apply to blob values, which are
+// checksummed in the blob file.
+
+func makePrefixForBlobHandle(setHasSameKeyPrefix bool, attribute base.ShortAttribute) valuePrefix {
+	prefix := valueKindIsBlobHandle | valuePrefix(attribute)
+	if setHasSameKeyPrefix {
+		prefix = prefix | setHasSameKeyPrefixMask
+	}
+	return prefix
+}
+
+func isBlobHandle(b valuePrefix) bool {
+	return b&valueKindMask == valueKindIsBlobHandle
+}
+
+// noBlobValueFetcher is the fetcher of the blob values of tables read without
+// a ReaderOptions.BlobValueFetcher.
+var noBlobValueFetcher = &errBlobValueFetcher{}
+
+type errBlobValueFetcher struct{}
+
+// Fetch implements base.ValueFetcher.
+func (*errBlobValueFetcher) Fetch(
+	handle []byte, valLen int32, buf []byte,
+) (val []byte, callerOwned bool, err error) {
+	return nil, false, errors.New("pebble/table: value is stored in a blob file, but no BlobValueFetcher is configured")
+}
+
+// blobValueFetcher returns the fetcher for the blob values of the table.
+func (r *Reader) blobValueFetcher() base.ValueFetcher {
+	if r.opts.BlobValueFetcher != nil {
+		return r.opts.BlobValueFetcher
+	}
+	return noBlobValueFetcher
+}
+
+// getLazyValueForBlobHandle returns the LazyValue for the given value, which
+// starts with a valueKindIsBlobHandle prefix.
+func (i *blockIter) getLazyValueForBlobHandle(handle []byte) base.LazyValue {
+	fetcher := &i.lazyValueHandling.blobLazyFetcher
+	valLen, h := decodeLenFromValueHandle(handle[1:])
+	*fetcher = base.LazyFetcher{
+		Fetcher: i.lazyValueHandling.blobFetcher,
+		Attribute: base.AttributeAndLen{
+			ValueLen:       int32(valLen),
+			ShortAttribute: getShortAttribute(valuePrefix(handle[0])),
+		},
+	}
+	return base.LazyValue{
+		ValueOrHandle: h,
+		Fetcher:       fetcher,
+	}
+}
+
+// AddWithBlobHandle adds a SET key whose value is stored in a blob file, with
+// the given handle and short attribute. The key must be added in order, like
+// a key passed to Add. The value is not available to the table and block
+// property collectors, which are passed a nil value (the block property
+// collectors only interested in the length of the value are passed the length
+// of the value in the blob file).
+//
+// AddWithBlobHandle requires TableFormatPebblev3 or later.
+func (w *Writer) AddWithBlobHandle(
+	key InternalKey, h blob.Handle, attribute base.ShortAttribute,
+) error {
+	if w.err != nil {
+		return w.err
+	}
+	if key.Kind() != InternalKeyKindSet {
+		w.err = errors.Errorf("pebble: blob handles can only be added for SET keys, not %s", key.Kind())
+		return w.err
+	}
+	if w.valueBlockWriter == nil {
+		w.err = errors.Errorf("pebble: blob handles require table format %s or later, not %s",
+			TableFormatPebblev3, w.tableFormat)
+		return w.err
+	}
+	maxSharedKeyLen := w.lastPointKeyInfo.prefixLen
+	setHasSameKeyPrefix, _, err := w.makeAddPointDecisionV3(key, int(h.ValueLen))
+	if err != nil {
+		return err
+	}
+	var buf [blob.MaxHandleLen]byte
+	n := h.Encode(buf[:])
+	prefix := makePrefixForBlobHandle(setHasSameKeyPrefix, attribute)
+	if err := w.addPointEntry(
+		key, nil /* value */, int(h.ValueLen), buf[:n], n+1, maxSharedKeyLen,
+		true /* addPrefix */, prefix, setHasSameKeyPrefix,
+	); err != nil {
+		return err
+	}
+	w.props.NumBlobValues++
+	w.props.BlobValueSize += uint64(h.ValueLen)
+	return nil
+}
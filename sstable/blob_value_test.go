@@ -0,0 +1,139 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/blob"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// testBlobValueFetcher fetches blob values by synthesizing them from their
+// handles.
+type testBlobValueFetcher struct {
+	fetches int
+}
+
+func testBlobValue(h blob.Handle) []byte {
+	return []byte(strings.Repeat(fmt.Sprintf("%d.%d.", h.FileNum, h.Offset), int(h.ValueLen))[:h.ValueLen])
+}
+
+func (f *testBlobValueFetcher) Fetch(
+	handle []byte, valLen int32, buf []byte,
+) (val []byte, callerOwned bool, err error) {
+	h, err := blob.DecodeHandleWithLen(handle, uint32(valLen))
+	if err != nil {
+		return nil, false, err
+	}
+	f.fetches++
+	return testBlobValue(h), true, nil
+}
+
+func TestBlobValues(t *testing.T) {
+	fs := vfs.NewMem()
+	// Only SETs may have blob values, and only in tables of format Pebblev3 or
+	// later.
+	h := blob.Handle{FileNum: base.FileNum(1).DiskFileNum(), ValueLen: 1}
+	for _, tc := range []struct {
+		format TableFormat
+		kind   InternalKeyKind
+	}{
+		{TableFormatPebblev3, InternalKeyKindMerge},
+		{TableFormatPebblev3, base.InternalKeyKindSetWithDelete},
+		{TableFormatPebblev2, InternalKeyKindSet},
+	} {
+		f, err := fs.Create("invalid")
+		require.NoError(t, err)
+		w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{TableFormat: tc.format})
+		require.Error(t, w.AddWithBlobHandle(base.MakeInternalKey([]byte("a"), 0, tc.kind), h, 0))
+		require.Error(t, w.Close())
+	}
+
+	open := func(name string, fetcher base.ValueFetcher) *Reader {
+		f, err := fs.Open(name)
+		require.NoError(t, err)
+		readable, err := NewSimpleReadable(f)
+		require.NoError(t, err)
+		r, err := NewReader(readable, ReaderOptions{Comparer: testkeys.Comparer, BlobValueFetcher: fetcher})
+		require.NoError(t, err)
+		return r
+	}
+
+	for _, valueChecksums := range []bool{false, true} {
+		t.Run(fmt.Sprintf("checksums=%t", valueChecksums), func(t *testing.T) {
+			// Write two versions of each key, alternating between values
+			// stored in-place and in blob files.
+			f, err := fs.Create("table")
+			require.NoError(t, err)
+			w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+				BlockSize:      64,
+				Comparer:       testkeys.Comparer,
+				TableFormat:    TableFormatPebblev3,
+				ValueChecksums: valueChecksums,
+			})
+			var blobValues int
+			for i := 0; i < 20; i++ {
+				for _, ts := range []int{2, 1} {
+					k := base.MakeInternalKey([]byte(fmt.Sprintf("k%02d@%d", i, ts)), 0, InternalKeyKindSet)
+					if (i+ts)%2 == 0 {
+						h := blob.Handle{FileNum: base.FileNum(i).DiskFileNum(), Offset: uint64(ts), ValueLen: 100}
+						require.NoError(t, w.AddWithBlobHandle(k, h, 5))
+						blobValues++
+					} else {
+						require.NoError(t, w.Add(k, []byte(fmt.Sprintf("value-%02d-%d", i, ts))))
+					}
+				}
+			}
+			require.NoError(t, w.Close())
+
+			fetcher := &testBlobValueFetcher{}
+			r := open("table", fetcher)
+			require.EqualValues(t, blobValues, r.Properties.NumBlobValues)
+			require.EqualValues(t, 100*blobValues, r.Properties.BlobValueSize)
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			var count int
+			for k, v := iter.First(); k != nil; k, v = iter.Next() {
+				i, ts := count/2, 2-count%2
+				require.Equal(t, fmt.Sprintf("k%02d@%d", i, ts), string(k.UserKey))
+				val, _, err := v.Value(nil)
+				require.NoError(t, err)
+				if (i+ts)%2 == 0 {
+					require.Equal(t, 100, v.Len())
+					attr, ok := v.TryGetShortAttribute()
+					require.True(t, ok)
+					require.EqualValues(t, 5, attr)
+					h := blob.Handle{FileNum: base.FileNum(i).DiskFileNum(), Offset: uint64(ts), ValueLen: 100}
+					require.Equal(t, testBlobValue(h), val)
+				} else {
+					require.Equal(t, fmt.Sprintf("value-%02d-%d", i, ts), string(val))
+				}
+				count++
+			}
+			require.Equal(t, 40, count)
+			require.Equal(t, blobValues, fetcher.fetches)
+			require.NoError(t, iter.Close())
+			require.NoError(t, r.Close())
+
+			// Without a fetcher, the blob values cannot be read.
+			r = open("table", nil)
+			iter, err = r.NewIter(nil, nil)
+			require.NoError(t, err)
+			k, v := iter.SeekGE([]byte("k01@1"), base.SeekGEFlagsNone)
+			require.NotNil(t, k)
+			_, _, err = v.Value(nil)
+			require.Error(t, err)
+			require.NoError(t, iter.Close())
+			require.NoError(t, r.Close())
+		})
+	}
+}
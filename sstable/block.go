@@ -380,6 +380,10 @@ type blockIter struct {
 	lazyValueHandling struct {
 		vbr            *valueBlockReader
 		hasValuePrefix bool
+		// blobFetcher is the fetcher of the values stored in blob files. It is
+		// nil if the table has no blob values.
+		blobFetcher     base.ValueFetcher
+		blobLazyFetcher base.LazyFetcher
 	}
 }
 
@@ -743,6 +747,8 @@ func (i *blockIter) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, ba
 		if !i.lazyValueHandling.hasValuePrefix ||
 			base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 			i.lazyValue = base.MakeInPlaceValue(i.val)
+		} else if i.lazyValueHandling.blobFetcher != nil && isBlobHandle(valuePrefix(i.val[0])) {
+			i.lazyValue = i.getLazyValueForBlobHandle(i.val)
 		} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
 			i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 		} else {
@@ -906,6 +912,8 @@ func (i *blockIter) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, ba
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
+	} else if i.lazyValueHandling.blobFetcher != nil && isBlobHandle(valuePrefix(i.val[0])) {
+		i.lazyValue = i.getLazyValueForBlobHandle(i.val)
 	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
@@ -927,6 +935,8 @@ func (i *blockIter) First() (*InternalKey, base.LazyValue) {
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
+	} else if i.lazyValueHandling.blobFetcher != nil && isBlobHandle(valuePrefix(i.val[0])) {
+		i.lazyValue = i.getLazyValueForBlobHandle(i.val)
 	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
@@ -962,6 +972,8 @@ func (i *blockIter) Last() (*InternalKey, base.LazyValue) {
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
+	} else if i.lazyValueHandling.blobFetcher != nil && isBlobHandle(valuePrefix(i.val[0])) {
+		i.lazyValue = i.getLazyValueForBlobHandle(i.val)
 	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
@@ -1007,6 +1019,8 @@ func (i *blockIter) Next() (*InternalKey, base.LazyValue) {
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
+	} else if i.lazyValueHandling.blobFetcher != nil && isBlobHandle(valuePrefix(i.val[0])) {
+		i.lazyValue = i.getLazyValueForBlobHandle(i.val)
 	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
@@ -1278,6 +1292,8 @@ func (i *blockIter) nextPrefixV3(succKey []byte) (*InternalKey, base.LazyValue)
 			}
 			if base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 				i.lazyValue = base.MakeInPlaceValue(i.val)
+			} else if i.lazyValueHandling.blobFetcher != nil && isBlobHandle(valuePrefix(i.val[0])) {
+				i.lazyValue = i.getLazyValueForBlobHandle(i.val)
 			} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
 				i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 			} else {
@@ -1331,6 +1347,8 @@ func (i *blockIter) Prev() (*InternalKey, base.LazyValue) {
 		if !i.lazyValueHandling.hasValuePrefix ||
 			base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 			i.lazyValue = base.MakeInPlaceValue(i.val)
+		} else if i.lazyValueHandling.blobFetcher != nil && isBlobHandle(valuePrefix(i.val[0])) {
+			i.lazyValue = i.getLazyValueForBlobHandle(i.val)
 		} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
 			i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 		} else {
@@ -1386,6 +1404,8 @@ func (i *blockIter) Prev() (*InternalKey, base.LazyValue) {
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
+	} else if i.lazyValueHandling.blobFetcher != nil && isBlobHandle(valuePrefix(i.val[0])) {
+		i.lazyValue = i.getLazyValueForBlobHandle(i.val)
 	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
@@ -1417,6 +1437,7 @@ func (i *blockIter) Close() error {
 	i.val = nil
 	i.lazyValue = base.LazyValue{}
 	i.lazyValueHandling.vbr = nil
+	i.lazyValueHandling.blobFetcher = nil
 	return nil
 }
 
@@ -465,6 +465,53 @@ func (b *BlockIntervalFilter) SetInterval(lower, upper uint64) {
 	b.filterInterval = interval{lower: lower, upper: upper}
 }
 
+// BlockPropertySummary is the table-level property recorded in an sstable by
+// a block property collector.
+type BlockPropertySummary struct {
+	// Name is the name of the collector.
+	Name string
+	// Value is the property encoded by the collector for the whole sstable.
+	Value []byte
+	// Interval is true if the collector is a BlockIntervalCollector, in which
+	// case Value is decoded as the set [Lower, Upper). An empty set is decoded
+	// as [0, 0).
+	Interval     bool
+	Lower, Upper uint64
+}
+
+// SummarizeBlockProperties returns the table-level properties recorded in the
+// given sstable properties by the block property collectors constructed by
+// the given functions, in order. The collectors which weren't used when
+// writing the sstable are skipped.
+func SummarizeBlockProperties(
+	props *Properties, collectors []func() BlockPropertyCollector,
+) ([]BlockPropertySummary, error) {
+	var summaries []BlockPropertySummary
+	for _, newCollector := range collectors {
+		c := newCollector()
+		encoded, ok := props.UserProperties[c.Name()]
+		if !ok {
+			continue
+		}
+		if len(encoded) < 1 {
+			return nil, base.CorruptionErrorf(
+				"block properties for %s is corrupted", c.Name())
+		}
+		// Skip the shortID.
+		s := BlockPropertySummary{Name: c.Name(), Value: []byte(encoded[1:])}
+		switch c.(type) {
+		case *BlockIntervalCollector, *suffixReplacementBlockCollectorWrapper:
+			var i interval
+			if err := i.decode(s.Value); err != nil {
+				return nil, err
+			}
+			s.Interval, s.Lower, s.Upper = true, i.lower, i.upper
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, nil
+}
+
 // When encoding block properties for each block, we cannot afford to encode
 // the name. Instead, the name is mapped to a shortID, in the scope of that
 // sstable, and the shortID is encoded. Since we use a uint8, there is a limit
@@ -0,0 +1,66 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import "context"
+
+// CacheMode is the policy with which the blocks read by an iterator are
+// admitted to the block cache. See WithCacheMode.
+type CacheMode int8
+
+const (
+	// CacheModeDefault adds the blocks read from storage to the block cache,
+	// subject to its admission policy.
+	CacheModeDefault CacheMode = iota
+	// CacheModeNoFill reads the blocks that aren't in the block cache from
+	// storage without adding them to the cache. The blocks already in the
+	// cache are served from it, without affecting their eviction.
+	CacheModeNoFill
+	// CacheModeFillLowPriority adds the blocks read from storage to the block
+	// cache as the first to be evicted, unless they're read again by another
+	// iterator. The blocks already in the cache are served from it, without
+	// affecting their eviction.
+	CacheModeFillLowPriority
+	// CacheModePin adds the blocks read from storage to the block cache
+	// regardless of its admission policy, and retains them in preference to
+	// the blocks read by other iterators. The cache may still evict them
+	// under memory pressure.
+	CacheModePin
+)
+
+// String implements fmt.Stringer.
+func (m CacheMode) String() string {
+	switch m {
+	case CacheModeDefault:
+		return "default"
+	case CacheModeNoFill:
+		return "no-fill"
+	case CacheModeFillLowPriority:
+		return "fill-low-priority"
+	case CacheModePin:
+		return "pin"
+	default:
+		return "unknown"
+	}
+}
+
+type cacheModeKey struct{}
+
+// WithCacheMode returns a context that directs the iterators constructed with
+// it to admit the blocks they read to the block cache (or the scan cache, see
+// WithScanCache) with the given mode.
+func WithCacheMode(ctx context.Context, m CacheMode) context.Context {
+	return context.WithValue(ctx, cacheModeKey{}, m)
+}
+
+// cacheModeFromContext returns the cache mode set by WithCacheMode, or
+// CacheModeDefault.
+func cacheModeFromContext(ctx context.Context) CacheMode {
+	if ctx == nil {
+		return CacheModeDefault
+	}
+	m, _ := ctx.Value(cacheModeKey{}).(CacheMode)
+	return m
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 )
 
 func decompressedLen(blockType blockType, b []byte) (int, int, error) {
@@ -20,7 +21,7 @@ func decompressedLen(blockType blockType, b []byte) (int, int, error) {
 	case snappyCompressionBlockType:
 		l, err := snappy.DecodedLen(b)
 		return l, 0, err
-	case zstdCompressionBlockType:
+	case zstdCompressionBlockType, zstdDictCompressionBlockType:
 		// This will also be used by zlib, bzip2 and lz4 to retrieve the decodedLen
 		// if we implement these algorithms in the future.
 		decodedLenU64, varIntLen := binary.Uvarint(b)
@@ -33,7 +34,11 @@ func decompressedLen(blockType blockType, b []byte) (int, int, error) {
 	}
 }
 
-func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte, error) {
+// decompressInto decompresses the block into buf. The dictionary of the table,
+// if any, is only used by the blocks compressed with it.
+func decompressInto(
+	blockType blockType, compressed []byte, buf []byte, dict *zstdDict,
+) ([]byte, error) {
 	var result []byte
 	var err error
 	switch blockType {
@@ -41,6 +46,8 @@ func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte,
 		result, err = snappy.Decode(buf, compressed)
 	case zstdCompressionBlockType:
 		result, err = decodeZstd(buf, compressed)
+	case zstdDictCompressionBlockType:
+		result, err = decodeZstdDict(buf, compressed, dict)
 	}
 	if err != nil {
 		return nil, base.MarkCorruptionError(err)
@@ -53,7 +60,9 @@ func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte,
 }
 
 // decompressBlock decompresses an SST block, with space allocated from a cache.
-func decompressBlock(cache *cache.Cache, blockType blockType, b []byte) (*cache.Value, error) {
+func decompressBlock(
+	cache *cache.Cache, blockType blockType, b []byte, dict *zstdDict,
+) (*cache.Value, error) {
 	if blockType == noCompressionBlockType {
 		return nil, nil
 	}
@@ -68,15 +77,18 @@ func decompressBlock(cache *cache.Cache, blockType blockType, b []byte) (*cache.
 	// Allocate sufficient space from the cache.
 	decoded := cache.Alloc(decodedLen)
 	decodedBuf := decoded.Buf()
-	if _, err := decompressInto(blockType, b, decodedBuf); err != nil {
+	if _, err := decompressInto(blockType, b, decodedBuf, dict); err != nil {
 		cache.Free(decoded)
+		return nil, err
 	}
 	return decoded, nil
 }
 
-// compressBlock compresses an SST block, using compressBuf as the desired destination.
+// compressBlock compresses an SST block, using compressBuf as the desired
+// destination. The zstd options and the dictionary, if non-nil, are only used
+// by ZstdCompression.
 func compressBlock(
-	compression Compression, b []byte, compressedBuf []byte,
+	compression Compression, zstdOpts ZstdOptions, dict *zstdDict, b []byte, compressedBuf []byte,
 ) (blockType blockType, compressed []byte) {
 	switch compression {
 	case SnappyCompression:
@@ -91,8 +103,23 @@ func compressBlock(
 	varIntLen := binary.PutUvarint(compressedBuf, uint64(len(b)))
 	switch compression {
 	case ZstdCompression:
-		return zstdCompressionBlockType, encodeZstd(compressedBuf, varIntLen, b)
+		if dict != nil {
+			return zstdDictCompressionBlockType, encodeZstdDict(compressedBuf, varIntLen, b, zstdOpts, dict)
+		}
+		return zstdCompressionBlockType, encodeZstd(compressedBuf, varIntLen, b, zstdOpts)
 	default:
 		return noCompressionBlockType, b
 	}
 }
+
+// encodeZstdGo compresses b with the pure Go implementation of the Zstandard
+// algorithm, like encodeZstd.
+func encodeZstdGo(compressedBuf []byte, varIntLen int, b []byte, opts ZstdOptions) []byte {
+	eopts := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opts.level()))}
+	if opts.WindowLog != 0 {
+		eopts = append(eopts, zstd.WithWindowSize(1<<opts.WindowLog))
+	}
+	encoder, _ := zstd.NewWriter(nil, eopts...)
+	defer encoder.Close()
+	return encoder.EncodeAll(b, compressedBuf[:varIntLen])
+}
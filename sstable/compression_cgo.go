@@ -20,14 +20,19 @@ func decodeZstd(decodedBuf, b []byte) ([]byte, error) {
 	return zstd.Decompress(decodedBuf, b)
 }
 
-// encodeZstd compresses b with the Zstandard algorithm at default compression
-// level (level 3). It reuses the preallocated capacity of compressedBuf if it
-// is sufficient. The subslice `compressedBuf[:varIntLen]` should already encode
-// the length of `b` before calling encodeZstd. It returns the encoded byte
-// slice, including the `compressedBuf[:varIntLen]` prefix.
-func encodeZstd(compressedBuf []byte, varIntLen int, b []byte) []byte {
+// encodeZstd compresses b with the Zstandard algorithm at the compression
+// level and with the window of opts. It reuses the preallocated capacity of
+// compressedBuf if it is sufficient. The subslice `compressedBuf[:varIntLen]`
+// should already encode the length of `b` before calling encodeZstd. It
+// returns the encoded byte slice, including the `compressedBuf[:varIntLen]`
+// prefix.
+func encodeZstd(compressedBuf []byte, varIntLen int, b []byte, opts ZstdOptions) []byte {
+	if opts.WindowLog != 0 {
+		// The cgo implementation doesn't allow limiting the window.
+		return encodeZstdGo(compressedBuf, varIntLen, b, opts)
+	}
 	buf := bytes.NewBuffer(compressedBuf[:varIntLen])
-	writer := zstd.NewWriterLevel(buf, 3)
+	writer := zstd.NewWriterLevel(buf, opts.level())
 	writer.Write(b)
 	writer.Close()
 	return buf.Bytes()
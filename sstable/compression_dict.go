@@ -0,0 +1,179 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDictMagic is the magic number at the start of the dictionaries in the
+// zstd format, such as those produced by `zstd --train`. The dictionaries
+// without it are raw content dictionaries: their content is used as if it
+// preceded every block compressed with them.
+const zstdDictMagic = 0xEC30A437
+
+// zstdDict is a dictionary shared by the zstd-compressed data and value
+// blocks of a table. It's stored in the metaindex block named
+// metaZstdDictName.
+type zstdDict struct {
+	content []byte
+	// id identifies a raw content dictionary in the frames compressed with it.
+	// It's derived from the content, in the range of ids which the zstd format
+	// doesn't reserve.
+	id uint32
+}
+
+func newZstdDict(content []byte) *zstdDict {
+	return &zstdDict{
+		content: content,
+		id:      crc.New(content).Value()&(1<<31-1) | 1<<15,
+	}
+}
+
+func (d *zstdDict) isRaw() bool {
+	return len(d.content) < 4 || binary.LittleEndian.Uint32(d.content) != zstdDictMagic
+}
+
+func (d *zstdDict) encoderOption() zstd.EOption {
+	if d.isRaw() {
+		return zstd.WithEncoderDictRaw(d.id, d.content)
+	}
+	return zstd.WithEncoderDict(d.content)
+}
+
+func (d *zstdDict) decoderOption() zstd.DOption {
+	if d.isRaw() {
+		return zstd.WithDecoderDictRaw(d.id, d.content)
+	}
+	return zstd.WithDecoderDicts(d.content)
+}
+
+// validateZstdDict returns an error if the dictionary is in the zstd format
+// but can't be parsed.
+func validateZstdDict(content []byte) error {
+	d := newZstdDict(content)
+	if d.isRaw() {
+		return nil
+	}
+	encoder, err := zstd.NewWriter(nil, d.encoderOption())
+	if err != nil {
+		return errors.Wrap(err, "pebble: invalid zstd dictionary")
+	}
+	return encoder.Close()
+}
+
+// encodeZstdDict compresses b like encodeZstd, with the dictionary. The pure Go
+// implementation of the Zstandard algorithm is used regardless of cgo.
+func encodeZstdDict(
+	compressedBuf []byte, varIntLen int, b []byte, opts ZstdOptions, dict *zstdDict,
+) []byte {
+	eopts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opts.level())),
+		dict.encoderOption(),
+	}
+	if opts.WindowLog != 0 {
+		eopts = append(eopts, zstd.WithWindowSize(1<<opts.WindowLog))
+	}
+	encoder, _ := zstd.NewWriter(nil, eopts...)
+	defer encoder.Close()
+	return encoder.EncodeAll(b, compressedBuf[:varIntLen])
+}
+
+// decodeZstdDict decompresses b like decodeZstd, with the dictionary.
+func decodeZstdDict(decodedBuf, b []byte, dict *zstdDict) ([]byte, error) {
+	if dict == nil {
+		return nil, errors.New("pebble/table: zstd dictionary block in a table without a dictionary")
+	}
+	decoder, err := zstd.NewReader(nil, dict.decoderOption())
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	return decoder.DecodeAll(b, decodedBuf[:0])
+}
+
+const (
+	// dictTrainerMaxSamples is the number of most recent blocks sampled by a
+	// DictionaryTrainer.
+	dictTrainerMaxSamples = 128
+	// dictTrainerMinSamples is the number of blocks a DictionaryTrainer must
+	// sample before producing a dictionary.
+	dictTrainerMinSamples = 8
+	// dictTrainerMinSampleLen is the minimum length of a sample.
+	dictTrainerMinSampleLen = 64
+)
+
+// DictionaryTrainer trains a raw content zstd dictionary (see
+// WriterOptions.CompressionDict) from samples of the uncompressed data blocks
+// of the tables written with it (see WriterOptions.CompressionDictTrainer). The
+// dictionary is made of the prefixes of the most recently sampled blocks,
+// which share the keys and values recurring across the blocks. A
+// DictionaryTrainer is safe for concurrent use by multiple Writers.
+type DictionaryTrainer struct {
+	size      int
+	sampleLen int
+
+	mu struct {
+		sync.Mutex
+		// samples is a ring buffer of the most recent samples, of which next is
+		// the oldest once it's full.
+		samples [][]byte
+		next    int
+	}
+}
+
+// NewDictionaryTrainer returns a trainer of dictionaries of at most size
+// bytes.
+func NewDictionaryTrainer(size int) *DictionaryTrainer {
+	sampleLen := size / dictTrainerMaxSamples
+	if sampleLen < dictTrainerMinSampleLen {
+		sampleLen = dictTrainerMinSampleLen
+	}
+	return &DictionaryTrainer{size: size, sampleLen: sampleLen}
+}
+
+// Add samples an uncompressed block. The block is copied.
+func (t *DictionaryTrainer) Add(block []byte) {
+	if len(block) > t.sampleLen {
+		block = block[:t.sampleLen]
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.mu.samples) < dictTrainerMaxSamples {
+		t.mu.samples = append(t.mu.samples, append([]byte(nil), block...))
+		return
+	}
+	t.mu.samples[t.mu.next] = append(t.mu.samples[t.mu.next][:0], block...)
+	t.mu.next = (t.mu.next + 1) % dictTrainerMaxSamples
+}
+
+// Dictionary returns a dictionary trained from the blocks sampled so far, or
+// nil if too few blocks were sampled.
+func (t *DictionaryTrainer) Dictionary() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.mu.samples)
+	if n < dictTrainerMinSamples {
+		return nil
+	}
+	// The most recent samples are placed at the end of the dictionary, where
+	// the compressor finds them at the shortest distances, and those which
+	// don't fit are dropped.
+	first, size := n, 0
+	for first > 0 && size+len(t.mu.samples[(t.mu.next+first-1)%n]) <= t.size {
+		first--
+		size += len(t.mu.samples[(t.mu.next+first)%n])
+	}
+	dict := make([]byte, 0, size)
+	for i := first; i < n; i++ {
+		dict = append(dict, t.mu.samples[(t.mu.next+i)%n]...)
+	}
+	return dict
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterCompressionDict(t *testing.T) {
+	mem := vfs.NewMem()
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf("user-%d,region=us-east-%d,status=active,payload=%08d", i%7, i%3, i))
+	}
+	write := func(name string, dict []byte, trainer *DictionaryTrainer) {
+		f, err := mem.Create(name)
+		require.NoError(t, err)
+		w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+			BlockSize:              512,
+			Compression:            ZstdCompression,
+			CompressionDict:        dict,
+			CompressionDictTrainer: trainer,
+			TableFormat:            TableFormatPebblev3,
+		})
+		for i := 0; i < 2000; i++ {
+			require.NoError(t, w.Set([]byte(fmt.Sprintf("key%06d", i)), value(i)))
+		}
+		require.NoError(t, w.Close())
+	}
+	// check verifies the table, returning the size of its data blocks.
+	check := func(name string, hasDict bool) uint64 {
+		f, err := mem.Open(name)
+		require.NoError(t, err)
+		c := cache.New(1 << 20)
+		defer c.Unref()
+		r, err := newReader(f, ReaderOptions{Cache: c})
+		require.NoError(t, err)
+		defer r.Close()
+		l, err := r.Layout()
+		require.NoError(t, err)
+		require.Equal(t, hasDict, l.ZstdDict.Length != 0)
+		var buf bytes.Buffer
+		l.Describe(&buf, true /* verbose */, r, nil)
+		require.Equal(t, hasDict, strings.Contains(buf.String(), "zstd-dict"))
+		require.NoError(t, r.ValidateBlockChecksums())
+
+		iter, err := r.NewIter(nil, nil)
+		require.NoError(t, err)
+		var n int
+		for k, v := iter.First(); k != nil; k, v = iter.Next() {
+			val, _, err := v.Value(nil)
+			require.NoError(t, err)
+			require.Equal(t, value(n), val)
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, 2000, n)
+		return r.Properties.DataSize
+	}
+
+	// The first table trains the dictionary, with which the second table is
+	// compressed.
+	trainer := NewDictionaryTrainer(4 << 10)
+	write("plain", nil, trainer)
+	plainSize := check("plain", false)
+	dict := trainer.Dictionary()
+	require.NotEmpty(t, dict)
+	require.LessOrEqual(t, len(dict), 4<<10)
+
+	write("dict", dict, nil)
+	dictSize := check("dict", true)
+	require.Less(t, dictSize, plainSize)
+
+	// Invalid dictionaries in the zstd format are rejected by the writer.
+	f, err := mem.Create("invalid")
+	require.NoError(t, err)
+	w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+		Compression:     ZstdCompression,
+		CompressionDict: []byte{0x37, 0xa4, 0x30, 0xec, 0x01},
+	})
+	require.Error(t, w.Close())
+}
+
+func TestDecompressWithoutDict(t *testing.T) {
+	b := bytes.Repeat([]byte("pebble"), 100)
+	dict := newZstdDict(bytes.Repeat([]byte("pebble"), 10))
+	typ, compressed := compressBlock(ZstdCompression, ZstdOptions{}, dict, b, nil)
+	require.Equal(t, zstdDictCompressionBlockType, typ)
+
+	c := cache.New(1 << 20)
+	defer c.Unref()
+	v, err := decompressBlock(c, typ, compressed, dict)
+	require.NoError(t, err)
+	require.Equal(t, b, v.Buf())
+	c.Free(v)
+
+	_, err = decompressBlock(c, typ, compressed, nil)
+	require.Error(t, err)
+}
+
+func TestDictionaryTrainer(t *testing.T) {
+	trainer := NewDictionaryTrainer(dictTrainerMaxSamples * 100)
+	block := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 150)
+	}
+	for i := 0; i < dictTrainerMinSamples-1; i++ {
+		trainer.Add(block(i))
+	}
+	require.Nil(t, trainer.Dictionary())
+	trainer.Add(block(dictTrainerMinSamples - 1))
+	require.Equal(t, dictTrainerMinSamples*100, len(trainer.Dictionary()))
+
+	// Once the trainer is full, the oldest samples are replaced, and the
+	// dictionary ends with the most recent sample.
+	for i := dictTrainerMinSamples; i < 3*dictTrainerMaxSamples; i++ {
+		trainer.Add(block(i))
+	}
+	dict := trainer.Dictionary()
+	require.Equal(t, dictTrainerMaxSamples*100, len(dict))
+	require.Equal(t, block(2 * dictTrainerMaxSamples)[:100], dict[:100])
+	require.Equal(t, block(3*dictTrainerMaxSamples - 1)[:100], dict[len(dict)-100:])
+}
@@ -18,13 +18,12 @@ func decodeZstd(decodedBuf, b []byte) ([]byte, error) {
 	return decoder.DecodeAll(b, decodedBuf[:0])
 }
 
-// encodeZstd compresses b with the Zstandard algorithm at default compression
-// level (level 3). It reuses the preallocated capacity of compressedBuf if it
-// is sufficient. The subslice `compressedBuf[:varIntLen]` should already encode
-// the length of `b` before calling encodeZstd. It returns the encoded byte
-// slice, including the `compressedBuf[:varIntLen]` prefix.
-func encodeZstd(compressedBuf []byte, varIntLen int, b []byte) []byte {
-	encoder, _ := zstd.NewWriter(nil)
-	defer encoder.Close()
-	return encoder.EncodeAll(b, compressedBuf[:varIntLen])
+// encodeZstd compresses b with the Zstandard algorithm at the compression
+// level and with the window of opts. It reuses the preallocated capacity of
+// compressedBuf if it is sufficient. The subslice `compressedBuf[:varIntLen]`
+// should already encode the length of `b` before calling encodeZstd. It
+// returns the encoded byte slice, including the `compressedBuf[:varIntLen]`
+// prefix.
+func encodeZstd(compressedBuf []byte, varIntLen int, b []byte, opts ZstdOptions) []byte {
+	return encodeZstdGo(compressedBuf, varIntLen, b, opts)
 }
@@ -0,0 +1,93 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// EncryptionKeyManager provides the data keys used to encrypt the blocks of
+// sstables. Each sstable is encrypted with its own data key, obtained from
+// NewDataKey when the sstable is written. The identifier of the data key is
+// recorded in the sstable's properties, and is used to retrieve the key
+// through DataKey when the sstable is read.
+//
+// Encrypted sstables declare TableFeatureEncryption in their footer, which
+// versions of Pebble that predate encryption fail to parse, and so require a
+// Pebble table format.
+//
+// Blocks are encrypted with AES in CTR mode, using a random nonce per block,
+// after compression and before the block checksum is computed. The
+// metaindex and properties blocks are not encrypted, as they must be read to
+// determine the data key; all other blocks, including the filter, index and
+// value blocks, are encrypted. Since blocks are decrypted when they are read,
+// the block cache holds plaintext blocks.
+//
+// Because encryption is performed by the sstable format itself, sstables
+// remain encrypted when they reside on shared storage, unlike encryption
+// performed by a vfs.FS wrapper.
+type EncryptionKeyManager interface {
+	// NewDataKey returns a new data key along with its identifier. The key
+	// must be 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256
+	// respectively. The identifier must be non-empty.
+	NewDataKey() (id string, key []byte, err error)
+	// DataKey returns the data key with the given identifier.
+	DataKey(id string) (key []byte, err error)
+}
+
+// encryptedBlockTypeMask is set in the block type of the trailer of encrypted
+// blocks. The remaining bits of the block type give the compression format.
+const encryptedBlockTypeMask blockType = 0x80
+
+// blockNonceLen is the length of the nonce that prefixes the ciphertext of an
+// encrypted block.
+const blockNonceLen = aes.BlockSize
+
+// blockCipher encrypts and decrypts the blocks of a single sstable. It is safe
+// for concurrent use.
+type blockCipher struct {
+	block cipher.Block
+}
+
+func newBlockCipher(key []byte) (*blockCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "pebble/table: invalid data key")
+	}
+	return &blockCipher{block: block}, nil
+}
+
+// encrypt encrypts b, writing a random nonce followed by the ciphertext into
+// buf, which is grown if necessary. It returns the encrypted block.
+func (c *blockCipher) encrypt(buf, b []byte) []byte {
+	n := blockNonceLen + len(b)
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	nonce := buf[:blockNonceLen]
+	if _, err := rand.Read(nonce); err != nil {
+		// crypto/rand.Read never returns an error on supported platforms.
+		panic(err)
+	}
+	cipher.NewCTR(c.block, nonce).XORKeyStream(buf[blockNonceLen:], b)
+	return buf
+}
+
+// decrypt decrypts the encrypted block b in place, returning the plaintext,
+// which aliases b.
+func (c *blockCipher) decrypt(b []byte) ([]byte, error) {
+	if len(b) < blockNonceLen {
+		return nil, base.CorruptionErrorf("pebble/table: encrypted block too short: %d", errors.Safe(len(b)))
+	}
+	plaintext := b[blockNonceLen:]
+	cipher.NewCTR(c.block, b[:blockNonceLen]).XORKeyStream(plaintext, plaintext)
+	return plaintext, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type testKeyManager struct {
+	keys map[string][]byte
+}
+
+func (m *testKeyManager) NewDataKey() (string, []byte, error) {
+	id := fmt.Sprintf("key-%d", len(m.keys))
+	key := bytes.Repeat([]byte{byte(len(m.keys) + 1)}, 32)
+	m.keys[id] = key
+	return id, key, nil
+}
+
+func (m *testKeyManager) DataKey(id string) ([]byte, error) {
+	if key, ok := m.keys[id]; ok {
+		return key, nil
+	}
+	return nil, errors.Newf("unknown key %q", id)
+}
+
+func TestEncryption(t *testing.T) {
+	const secret = "secret-value-"
+	for _, parallelism := range []bool{false, true} {
+		t.Run(fmt.Sprintf("parallelism=%t", parallelism), func(t *testing.T) {
+			km := &testKeyManager{keys: map[string][]byte{}}
+			fs := vfs.NewMem()
+			f, err := fs.Create("test")
+			require.NoError(t, err)
+			w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+				BlockSize:            64,
+				Comparer:             testkeys.Comparer,
+				Compression:          NoCompression,
+				EncryptionKeyManager: km,
+				FilterPolicy:         bloom.FilterPolicy(10),
+				Parallelism:          parallelism,
+				TableFormat:          TableFormatPebblev3,
+			})
+			const n = 50
+			for i := 0; i < n; i++ {
+				// Write two versions of each key, so that the older version is
+				// written to a value block.
+				for _, ts := range []int{2, 1} {
+					k := []byte(fmt.Sprintf("k%03d@%d", i, ts))
+					require.NoError(t, w.Set(k, []byte(fmt.Sprintf("%s%03d-%d", secret, i, ts))))
+				}
+			}
+			require.NoError(t, w.DeleteRange([]byte("z"), []byte("zz")))
+			require.NoError(t, w.Close())
+
+			// No plaintext value or key appears in the file.
+			rf, err := fs.Open("test")
+			require.NoError(t, err)
+			raw, err := io.ReadAll(rf)
+			require.NoError(t, err)
+			require.NoError(t, rf.Close())
+			require.False(t, bytes.Contains(raw, []byte(secret)))
+			require.False(t, bytes.Contains(raw, []byte("k001")))
+
+			open := func(km EncryptionKeyManager) (*Reader, error) {
+				f, err := fs.Open("test")
+				require.NoError(t, err)
+				readable, err := NewSimpleReadable(f)
+				require.NoError(t, err)
+				return NewReader(readable, ReaderOptions{
+					Comparer:             testkeys.Comparer,
+					EncryptionKeyManager: km,
+					Filters: map[string]FilterPolicy{
+						bloom.FilterPolicy(10).Name(): bloom.FilterPolicy(10),
+					},
+				})
+			}
+			_, err = open(nil)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "no EncryptionKeyManager is configured")
+
+			r, err := open(km)
+			require.NoError(t, err)
+			defer r.Close()
+			require.Equal(t, "key-0", r.Properties.EncryptionKeyID)
+			require.Equal(t, TableFeaturesDeclared|TableFeatureEncryption, r.Features())
+			require.Less(t, uint64(0), r.Properties.NumValuesInValueBlocks)
+			require.NoError(t, r.ValidateBlockChecksums())
+
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			var count int
+			for k, v := iter.First(); k != nil; k, v = iter.Next() {
+				i, ts := count/2, 2-count%2
+				require.Equal(t, fmt.Sprintf("k%03d@%d", i, ts), string(k.UserKey))
+				val, _, err := v.Value(nil)
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("%s%03d-%d", secret, i, ts), string(val))
+				count++
+			}
+			require.Equal(t, 2*n, count)
+			// Prefix seeks consult the filter block.
+			k, _ := iter.SeekPrefixGE([]byte("k001"), []byte("k001@2"), base.SeekGEFlagsNone)
+			require.NotNil(t, k)
+			require.NoError(t, iter.Close())
+
+			rangeDels, err := r.NewRawRangeDelIter()
+			require.NoError(t, err)
+			s := rangeDels.First()
+			require.NotNil(t, s)
+			require.Equal(t, "z-zz:{(#0,RANGEDEL)}", s.String())
+			require.NoError(t, rangeDels.Close())
+
+			var buf bytes.Buffer
+			l, err := r.Layout()
+			require.NoError(t, err)
+			l.Describe(&buf, true, r, nil)
+			require.Contains(t, buf.String(), "none+encrypted")
+		})
+	}
+}
+
+func TestEncryptionNotInheritedFromPool(t *testing.T) {
+	fs := vfs.NewMem()
+	write := func(name string, km EncryptionKeyManager) {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+			BlockSize:            1,
+			EncryptionKeyManager: km,
+			TableFormat:          TableFormatPebblev3,
+		})
+		for i := 0; i < 10; i++ {
+			require.NoError(t, w.Set([]byte(fmt.Sprintf("k%02d", i)), []byte("v")))
+		}
+		require.NoError(t, w.Close())
+	}
+	// Buffers released by the writer of an encrypted table must not encrypt
+	// the blocks of a subsequent unencrypted table.
+	write("encrypted", &testKeyManager{keys: map[string][]byte{}})
+	write("plain", nil)
+
+	f, err := fs.Open("plain")
+	require.NoError(t, err)
+	readable, err := NewSimpleReadable(f)
+	require.NoError(t, err)
+	r, err := NewReader(readable, ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+	require.NoError(t, r.ValidateBlockChecksums())
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	var count int
+	for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+		count++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 10, count)
+}
@@ -0,0 +1,296 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// FilterVerificationOptions configures the paranoid verification of the
+// negative decisions made by filters when reading sstables. A sample of the
+// seeks for which the table filter (eg, a bloom filter) reports that the
+// sstable does not contain the sought prefix are verified by searching the
+// sstable for the prefix. Similarly, a sample of the data and index blocks
+// skipped because of block property filters are verified by reading the
+// skipped data blocks and recomputing their properties. Each discrepancy
+// indicates a bug in a filter policy or block property collector, and is
+// reported to OnDiscrepancy.
+//
+// Sampled decisions are queued and verified asynchronously by a background
+// goroutine, so that reads do not incur the cost of reading the data that the
+// filter allowed them to skip. The queue is bounded, and samples are dropped
+// while it is full. Verification is intended to build confidence when
+// deploying new filter policies or block property collectors.
+type FilterVerificationOptions struct {
+	// SampleRate is the fraction, in the range [0, 1], of negative filter
+	// decisions that are verified.
+	SampleRate float64
+	// QueueSize is the maximum number of sampled decisions awaiting
+	// verification. Samples taken while the queue is full are dropped. If
+	// zero, defaults to 64.
+	QueueSize int
+	// BlockPropertyCollectors is used to recompute the properties of skipped
+	// data blocks. It should match WriterOptions.BlockPropertyCollectors.
+	// Skipped blocks are only verified for filters whose property is
+	// collected by one of these collectors.
+	BlockPropertyCollectors []func() BlockPropertyCollector
+	// OnDiscrepancy is called for every discrepancy found. It may be called
+	// concurrently from multiple goroutines.
+	OnDiscrepancy func(FilterDiscrepancy)
+
+	queue filterVerificationQueue
+}
+
+// filterVerificationQueue is a bounded queue of pending verifications. A
+// worker goroutine is started when work is queued and exits once the queue
+// is empty.
+type filterVerificationQueue struct {
+	mu      sync.Mutex
+	tasks   []func()
+	running bool
+	// pending counts the queued and running tasks.
+	pending sync.WaitGroup
+}
+
+// FilterDiscrepancy describes a negative filter decision that verification
+// found to be incorrect.
+type FilterDiscrepancy struct {
+	// FileNum identifies the sstable.
+	FileNum base.DiskFileNum
+	// Filter is the name of the table filter policy that made the incorrect
+	// decision or, for block property filters, the comma-separated names of
+	// the properties of the filters, one or more of which made the incorrect
+	// decision.
+	Filter string
+	// Key is a key contained in the data that the filter excluded: a key with
+	// the sought prefix for table filters, or a key in the skipped block for
+	// block property filters.
+	Key InternalKey
+}
+
+func (d FilterDiscrepancy) String() string {
+	return fmt.Sprintf("sstable %s: filter %q excluded data containing key %s",
+		d.FileNum, d.Filter, d.Key)
+}
+
+func (o *FilterVerificationOptions) sample() bool {
+	return o != nil && o.SampleRate > 0 && rand.Float64() < o.SampleRate
+}
+
+// readerVerification tracks whether a Reader is closed, so that queued
+// verifications don't read from a closed Reader.
+type readerVerification struct {
+	sync.RWMutex
+	closed bool
+}
+
+// enqueue queues the verification of a negative decision made for r's
+// sstable, dropping it if the queue is full. The verification is skipped if r
+// is closed before it runs.
+func (o *FilterVerificationOptions) enqueue(r *Reader, verify func()) {
+	size := o.QueueSize
+	if size == 0 {
+		size = 64
+	}
+	q := &o.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tasks) >= size {
+		return
+	}
+	q.pending.Add(1)
+	q.tasks = append(q.tasks, func() {
+		r.verification.RLock()
+		defer r.verification.RUnlock()
+		if !r.verification.closed {
+			verify()
+		}
+	})
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+func (q *filterVerificationQueue) run() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.tasks) > 0 {
+		task := q.tasks[0]
+		q.tasks[0] = nil
+		q.tasks = q.tasks[1:]
+		q.mu.Unlock()
+		task()
+		q.pending.Done()
+		q.mu.Lock()
+	}
+	q.running = false
+}
+
+// wait waits for the queued verifications to complete.
+func (o *FilterVerificationOptions) wait() {
+	o.queue.pending.Wait()
+}
+
+// queueTableFilterNegative queues the verification of a negative decision of
+// the table filter for the given prefix.
+func (r *Reader) queueTableFilterNegative(prefix []byte) {
+	prefix = append([]byte(nil), prefix...)
+	r.opts.FilterVerification.enqueue(r, func() {
+		r.verifyTableFilterNegative(prefix)
+	})
+}
+
+// queueBlockPropertiesExclusion queues the verification of the exclusion of
+// the data block, or if index is true the index block, with the given handle
+// by the filters of bpfs.
+func (r *Reader) queueBlockPropertiesExclusion(
+	bh BlockHandle, bpfs *BlockPropertiesFilterer, index bool,
+) {
+	// The filterer belongs to the iterator, so the filters are copied.
+	filters := append([]BlockPropertyFilter(nil), bpfs.filters...)
+	if bpfs.boundLimitedFilter != nil {
+		filters = append(filters, bpfs.boundLimitedFilter)
+	}
+	r.opts.FilterVerification.enqueue(r, func() {
+		ctx := context.Background()
+		if index {
+			r.verifyIndexBlockPropertiesExclusion(ctx, bh, filters)
+		} else {
+			r.verifyBlockPropertiesExclusion(ctx, bh, filters)
+		}
+	})
+}
+
+// verifyTableFilterNegative verifies that the sstable contains no point keys
+// with the given prefix, as claimed by the table filter.
+func (r *Reader) verifyTableFilterNegative(prefix []byte) {
+	iter, err := r.NewIterWithBlockPropertyFilters(
+		prefix, nil, nil, false /* useFilterBlock */, nil, /* stats */
+		TrivialReaderProvider{Reader: r})
+	if err != nil {
+		return
+	}
+	defer iter.Close()
+	if k, _ := iter.SeekGE(prefix, base.SeekGEFlagsNone); k != nil && r.Split != nil {
+		if n := r.Split(k.UserKey); r.Compare(k.UserKey[:n], prefix) == 0 {
+			r.opts.FilterVerification.OnDiscrepancy(FilterDiscrepancy{
+				FileNum: r.fileNum,
+				Filter:  r.tableFilter.policy.Name(),
+				Key:     k.Clone(),
+			})
+		}
+	}
+}
+
+// verifyIndexBlockPropertiesExclusion verifies that none of the data blocks
+// referenced by the index block with the given handle intersect the filters
+// filters.
+func (r *Reader) verifyIndexBlockPropertiesExclusion(
+	ctx context.Context, bh BlockHandle, filters []BlockPropertyFilter,
+) {
+	h, err := r.readBlock(ctx, bh, nil /* transform */, nil /* readHandle */, nil /* stats */)
+	if err != nil {
+		return
+	}
+	defer h.Release()
+	var index blockIter
+	if err := index.init(r.Compare, h.Get(), r.Properties.GlobalSeqNum); err != nil {
+		return
+	}
+	for k, v := index.First(); k != nil; k, v = index.Next() {
+		bhp, err := decodeBlockHandleWithProperties(v.InPlaceValue())
+		if err != nil {
+			return
+		}
+		r.verifyBlockPropertiesExclusion(ctx, bhp.BlockHandle, filters)
+	}
+}
+
+// verifyBlockPropertiesExclusion verifies that the data block with the given
+// handle does not intersect the filters, by recomputing the block's
+// properties from its keys.
+func (r *Reader) verifyBlockPropertiesExclusion(
+	ctx context.Context, bh BlockHandle, filters []BlockPropertyFilter,
+) {
+	// Construct the collectors of the filtered properties.
+	collectors := make([]BlockPropertyCollector, len(filters))
+	var found bool
+	for _, newCollector := range r.opts.FilterVerification.BlockPropertyCollectors {
+		c := newCollector()
+		for j, f := range filters {
+			if f.Name() == c.Name() {
+				collectors[j] = c
+				found = true
+			}
+		}
+	}
+	if !found {
+		return
+	}
+
+	h, err := r.readBlock(ctx, bh, nil /* transform */, nil /* readHandle */, nil /* stats */)
+	if err != nil {
+		return
+	}
+	defer h.Release()
+	var data blockIter
+	if err := data.init(r.Compare, h.Get(), r.Properties.GlobalSeqNum); err != nil {
+		return
+	}
+	hasValuePrefix := r.tableFormat >= TableFormatPebblev3
+	var first InternalKey
+	var empty = true
+	for k, v := data.First(); k != nil; k, v = data.Next() {
+		if empty {
+			first, empty = k.Clone(), false
+		}
+		value := v.InPlaceValue()
+		if hasValuePrefix && k.Kind() == InternalKeyKindSet {
+			// The Writer does not pass the values of SETs to block property
+			// collectors when values may be stored in value blocks.
+			value = nil
+		}
+		for _, c := range collectors {
+			if c != nil {
+				if err := c.Add(*k, value); err != nil {
+					return
+				}
+			}
+		}
+	}
+	if empty {
+		return
+	}
+	// The block was excluded by at least one of the filters. The exclusion is
+	// verified only if the properties of all of the filters were recomputed,
+	// and is incorrect if every filter intersects the recomputed properties.
+	var names []string
+	for j, f := range filters {
+		if collectors[j] == nil {
+			return
+		}
+		prop, err := collectors[j].FinishDataBlock(nil)
+		if err != nil {
+			return
+		}
+		intersects, err := f.Intersects(prop)
+		if err != nil || !intersects {
+			return
+		}
+		names = append(names, f.Name())
+	}
+	r.opts.FilterVerification.OnDiscrepancy(FilterDiscrepancy{
+		FileNum: r.fileNum,
+		Filter:  strings.Join(names, ","),
+		Key:     first,
+	})
+}
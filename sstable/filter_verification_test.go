@@ -0,0 +1,152 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenFilterPolicy is a bloom filter policy whose filters never match.
+type brokenFilterPolicy struct {
+	base.FilterPolicy
+}
+
+func (p brokenFilterPolicy) MayContain(ftype FilterType, filter, key []byte) bool {
+	return false
+}
+
+// shiftedIntervalCollector is a buggy testkeys suffix interval collector that
+// records an incorrect interval for blocks containing the suffix @5.
+type shiftedIntervalCollector struct {
+	testKeysSuffixIntervalCollector
+}
+
+func (c *shiftedIntervalCollector) FinishDataBlock() (lower, upper uint64, err error) {
+	lower, upper, err = c.testKeysSuffixIntervalCollector.FinishDataBlock()
+	if lower == 5 {
+		lower, upper = lower+100, upper+100
+	}
+	return lower, upper, err
+}
+
+func TestFilterVerification(t *testing.T) {
+	// Verify skips of both data blocks and, with two-level indexes, index
+	// blocks.
+	for _, indexBlockSize := range []int{4096, 1} {
+		t.Run(fmt.Sprintf("index-block-size=%d", indexBlockSize), func(t *testing.T) {
+			fs := vfs.NewMem()
+			f, err := fs.Create("test")
+			require.NoError(t, err)
+			w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+				BlockSize:      1,
+				IndexBlockSize: indexBlockSize,
+				BlockPropertyCollectors: []func() BlockPropertyCollector{
+					func() BlockPropertyCollector {
+						return NewBlockIntervalCollector(testKeysBlockPropertyName, &shiftedIntervalCollector{}, nil)
+					},
+				},
+				Comparer:     testkeys.Comparer,
+				FilterPolicy: bloom.FilterPolicy(10),
+				TableFormat:  TableFormatPebblev3,
+			})
+			require.NoError(t, w.Set([]byte("a@5"), []byte("a")))
+			require.NoError(t, w.Set([]byte("b@2"), []byte("b")))
+			require.NoError(t, w.Close())
+
+			var discrepancies []FilterDiscrepancy
+			f2, err := fs.Open("test")
+			require.NoError(t, err)
+			readable, err := NewSimpleReadable(f2)
+			require.NoError(t, err)
+			policy := brokenFilterPolicy{bloom.FilterPolicy(10)}
+			r, err := NewReader(readable, ReaderOptions{
+				Comparer: testkeys.Comparer,
+				Filters:  map[string]FilterPolicy{policy.Name(): policy},
+				FilterVerification: &FilterVerificationOptions{
+					SampleRate: 1,
+					BlockPropertyCollectors: []func() BlockPropertyCollector{
+						NewTestKeysBlockPropertyCollector,
+					},
+					OnDiscrepancy: func(d FilterDiscrepancy) {
+						discrepancies = append(discrepancies, d)
+					},
+				},
+			})
+			require.NoError(t, err)
+			defer r.Close()
+
+			// The broken filter claims that no prefix is present.
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			k, _ := iter.SeekPrefixGE([]byte("b"), []byte("b@2"), base.SeekGEFlagsNone)
+			require.Nil(t, k)
+			k, _ = iter.SeekPrefixGE([]byte("c"), []byte("c@2"), base.SeekGEFlagsNone)
+			require.Nil(t, k)
+			require.NoError(t, iter.Close())
+			r.opts.FilterVerification.wait()
+			require.Len(t, discrepancies, 1)
+			require.Equal(t, bloom.FilterPolicy(10).Name(), discrepancies[0].Filter)
+			require.Equal(t, "b@2", string(discrepancies[0].Key.UserKey))
+			discrepancies = nil
+
+			// The block containing a@5 has an incorrect property, causing the filter
+			// to skip it.
+			filterer, err := IntersectsTable(
+				[]BlockPropertyFilter{NewTestKeysBlockPropertyFilter(1, 10)}, nil, r.Properties.UserProperties)
+			require.NoError(t, err)
+			require.NotNil(t, filterer)
+			iter, err = r.NewIterWithBlockPropertyFilters(nil, nil, filterer, false, nil, TrivialReaderProvider{Reader: r})
+			require.NoError(t, err)
+			k, _ = iter.First()
+			require.NotNil(t, k)
+			require.Equal(t, "b@2", string(k.UserKey))
+			require.NoError(t, iter.Close())
+			r.opts.FilterVerification.wait()
+			require.Len(t, discrepancies, 1)
+			require.Equal(t, testKeysBlockPropertyName, discrepancies[0].Filter)
+			require.Equal(t, "a@5", string(discrepancies[0].Key.UserKey))
+			require.Contains(t, discrepancies[0].String(), `filter "pebble.internal.testkeys.suffixes" excluded data containing key a@5`)
+		})
+	}
+}
+
+func TestFilterVerificationQueue(t *testing.T) {
+	o := &FilterVerificationOptions{QueueSize: 1}
+	r := &Reader{verification: &readerVerification{}}
+	block := make(chan struct{})
+	var ran []int
+	o.enqueue(r, func() { <-block; ran = append(ran, 0) })
+	// Wait for the worker to dequeue the blocked task.
+	for {
+		o.queue.mu.Lock()
+		n := len(o.queue.tasks)
+		o.queue.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		runtime.Gosched()
+	}
+	o.enqueue(r, func() { ran = append(ran, 1) })
+	// The queue is full, so this verification is dropped.
+	o.enqueue(r, func() { ran = append(ran, 2) })
+	close(block)
+	o.wait()
+	require.Equal(t, []int{0, 1}, ran)
+
+	// Verifications queued for a closed Reader are skipped.
+	r.verification.closed = true
+	o.enqueue(r, func() { ran = append(ran, 3) })
+	o.wait()
+	require.Equal(t, []int{0, 1}, ran)
+}
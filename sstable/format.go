@@ -5,6 +5,8 @@
 package sstable
 
 import (
+	"fmt"
+
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 )
@@ -29,36 +31,104 @@ const (
 	TableFormatMax = TableFormatPebblev4
 )
 
+// TableFeatures is a bitmap of the features of a table that are not supported
+// by readers of older table formats. It is stored in the high 16 bits of the
+// version of the footer of tables with the Pebble magic number, the low 16 bits
+// holding the version number.
+//
+// Writers declare the features of a table through the TableFeaturesDeclared
+// bit, setting the bit of every feature used by the table that changes the
+// encoding of the table in a way older readers don't understand. Features
+// that older readers can safely ignore, eg, new properties or meta blocks,
+// don't have a bit. Readers that predate the bits fail to parse the version
+// of a table declaring its features, rather than misreading the table. A
+// table of a format newer than TableFormatMax that declares its features,
+// and only uses features known to the reader, is read as a TableFormatMax
+// table. This allows sstables written by newer versions of Pebble to be
+// ingested by older versions, eg, in a mixed-version cluster, as long as they
+// don't use incompatible features.
+type TableFeatures uint16
+
+const (
+	// TableFeaturesDeclared is set by writers that declare the features used
+	// by the table. Tables of unknown format versions that don't declare
+	// their features are not readable.
+	TableFeaturesDeclared TableFeatures = 1 << iota
+	// TableFeatureValueChecksums is set by tables that append a checksum to
+	// their values (see WriterOptions.ValueChecksums).
+	TableFeatureValueChecksums
+	// TableFeatureEncryption is set by tables whose blocks are encrypted (see
+	// WriterOptions.EncryptionKeyManager).
+	TableFeatureEncryption
+	// TableFeatureCompressionDict is set by tables whose blocks are compressed
+	// with a zstd dictionary (see WriterOptions.CompressionDict).
+	TableFeatureCompressionDict
+
+	// knownTableFeatures are the features supported by this version.
+	knownTableFeatures = TableFeaturesDeclared | TableFeatureValueChecksums |
+		TableFeatureEncryption | TableFeatureCompressionDict
+)
+
+const (
+	pebbleVersionMask   = 0xffff
+	pebbleFeaturesShift = 16
+)
+
+// String implements fmt.Stringer.
+func (f TableFeatures) String() string {
+	return fmt.Sprintf("%#04x", uint16(f))
+}
+
 // ParseTableFormat parses the given magic bytes and version into its
 // corresponding internal TableFormat.
 func ParseTableFormat(magic []byte, version uint32) (TableFormat, error) {
+	f, _, err := parseTableFormat(magic, version)
+	return f, err
+}
+
+// parseTableFormat parses the given magic bytes and version into its
+// corresponding internal TableFormat, and the features declared by the table.
+func parseTableFormat(magic []byte, version uint32) (TableFormat, TableFeatures, error) {
 	switch string(magic) {
 	case levelDBMagic:
-		return TableFormatLevelDB, nil
+		return TableFormatLevelDB, 0, nil
 	case rocksDBMagic:
 		if version != rocksDBFormatVersion2 {
-			return TableFormatUnspecified, base.CorruptionErrorf(
+			return TableFormatUnspecified, 0, base.CorruptionErrorf(
 				"pebble/table: unsupported rocksdb format version %d", errors.Safe(version),
 			)
 		}
-		return TableFormatRocksDBv2, nil
+		return TableFormatRocksDBv2, 0, nil
 	case pebbleDBMagic:
+		features := TableFeatures(version >> pebbleFeaturesShift)
+		if unknown := features &^ knownTableFeatures; unknown != 0 {
+			return TableFormatUnspecified, 0, base.CorruptionErrorf(
+				"pebble/table: unsupported pebble format version %d (unknown features %s)",
+				errors.Safe(version&pebbleVersionMask), errors.Safe(unknown),
+			)
+		}
+		version &= pebbleVersionMask
+		if _, maxVersion := TableFormatMax.AsTuple(); version > maxVersion && features&TableFeaturesDeclared != 0 {
+			// The table was written by a newer version, which declared that
+			// the table only uses features known to this version.
+			return TableFormatMax, features, nil
+		}
 		switch version {
 		case 1:
-			return TableFormatPebblev1, nil
+			return TableFormatPebblev1, features, nil
 		case 2:
-			return TableFormatPebblev2, nil
+			return TableFormatPebblev2, features, nil
 		case 3:
-			return TableFormatPebblev3, nil
+			return TableFormatPebblev3, features, nil
 		case 4:
-			return TableFormatPebblev4, nil
+			return TableFormatPebblev4, features, nil
 		default:
-			return TableFormatUnspecified, base.CorruptionErrorf(
+			return TableFormatUnspecified, 0, base.CorruptionErrorf(
 				"pebble/table: unsupported pebble format version %d", errors.Safe(version),
 			)
 		}
 	default:
-		return TableFormatUnspecified, base.CorruptionErrorf(
+		return TableFormatUnspecified, 0, base.CorruptionErrorf(
 			"pebble/table: invalid table (bad magic number: 0x%x)", magic,
 		)
 	}
@@ -5,8 +5,11 @@
 package sstable
 
 import (
+	"encoding/binary"
 	"testing"
 
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
 
@@ -17,6 +20,8 @@ func TestTableFormat_RoundTrip(t *testing.T) {
 		version uint32
 		want    TableFormat
 		wantErr string
+		// newer is set for tables of newer formats, which don't round trip.
+		newer bool
 	}{
 		// Valid cases.
 		{
@@ -55,6 +60,13 @@ func TestTableFormat_RoundTrip(t *testing.T) {
 			version: 4,
 			want:    TableFormatPebblev4,
 		},
+		{
+			name:    "PebbleDBv5 with declared features",
+			magic:   pebbleDBMagic,
+			version: 5 | uint32(TableFeaturesDeclared)<<pebbleFeaturesShift,
+			want:    TableFormatMax,
+			newer:   true,
+		},
 		// Invalid cases.
 		{
 			name:    "Invalid RocksDB version",
@@ -68,6 +80,12 @@ func TestTableFormat_RoundTrip(t *testing.T) {
 			version: 5,
 			wantErr: "pebble/table: unsupported pebble format version 5",
 		},
+		{
+			name:    "PebbleDBv5 with unknown features",
+			magic:   pebbleDBMagic,
+			version: 5 | uint32(TableFeaturesDeclared|1<<7)<<pebbleFeaturesShift,
+			wantErr: "pebble/table: unsupported pebble format version 5 (unknown features 0x0080)",
+		},
 		{
 			name:    "Unknown magic string",
 			magic:   "foo",
@@ -86,6 +104,9 @@ func TestTableFormat_RoundTrip(t *testing.T) {
 			}
 			require.NoError(t, err)
 			require.Equal(t, tc.want, f)
+			if tc.newer {
+				return
+			}
 
 			// TableFormat -> Tuple.
 			s, v := f.AsTuple()
@@ -94,3 +115,97 @@ func TestTableFormat_RoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestTableFeatures(t *testing.T) {
+	// Write a table, and rewrite its footer as if it had been written by a
+	// newer format version.
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{TableFormat: TableFormatMax})
+	require.NoError(t, w.Set([]byte("a"), []byte("1")))
+	require.NoError(t, w.Set([]byte("b"), []byte("2")))
+	require.NoError(t, w.Close())
+
+	open := func(features TableFeatures) (*Reader, error) {
+		f, err := mem.Open("test")
+		require.NoError(t, err)
+		stat, err := f.Stat()
+		require.NoError(t, err)
+		data := make([]byte, stat.Size())
+		_, err = f.ReadAt(data, 0)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		footer := data[len(data)-rocksDBFooterLen:]
+		_, maxVersion := TableFormatMax.AsTuple()
+		binary.LittleEndian.PutUint32(footer[rocksDBVersionOffset:],
+			(maxVersion+1)|uint32(features)<<pebbleFeaturesShift)
+		newFile, err := mem.Create("newer")
+		require.NoError(t, err)
+		_, err = newFile.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, newFile.Close())
+
+		newFile, err = mem.Open("newer")
+		require.NoError(t, err)
+		readable, err := NewSimpleReadable(newFile)
+		require.NoError(t, err)
+		return NewReader(readable, ReaderOptions{})
+	}
+
+	// Tables of newer formats that don't declare their features, or that use
+	// unknown features, are rejected.
+	_, err = open(0)
+	require.Error(t, err)
+	_, err = open(TableFeaturesDeclared | 1<<15)
+	require.Error(t, err)
+
+	r, err := open(TableFeaturesDeclared)
+	require.NoError(t, err)
+	defer r.Close()
+	tf, err := r.TableFormat()
+	require.NoError(t, err)
+	require.Equal(t, TableFormatMax, tf)
+	require.Equal(t, TableFeaturesDeclared, r.Features())
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	var keys []string
+	for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+		keys = append(keys, string(k.UserKey))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"a", "b"}, keys)
+}
+
+func TestFooterFeatures(t *testing.T) {
+	for _, format := range []TableFormat{TableFormatPebblev1, TableFormatPebblev3} {
+		var buf [rocksDBFooterLen]byte
+		want := footer{
+			format:      format,
+			checksum:    ChecksumTypeCRC32c,
+			metaindexBH: BlockHandle{Offset: 1, Length: 2},
+			indexBH:     BlockHandle{Offset: 3, Length: 4},
+			features:    TableFeaturesDeclared,
+		}
+		mem := vfs.NewMem()
+		f, err := mem.Create("test")
+		require.NoError(t, err)
+		// Pad the file, so that the block handles of the footer are in range.
+		_, err = f.Write(make([]byte, 16))
+		require.NoError(t, err)
+		_, err = f.Write(want.encode(buf[:]))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		f, err = mem.Open("test")
+		require.NoError(t, err)
+		readable, err := NewSimpleReadable(f)
+		require.NoError(t, err)
+		got, err := readFooter(readable)
+		require.NoError(t, err)
+		require.NoError(t, readable.Close())
+		want.footerBH = BlockHandle{Offset: 16, Length: rocksDBFooterLen}
+		require.Equal(t, want, got)
+	}
+}
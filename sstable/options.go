@@ -5,6 +5,7 @@
 package sstable
 
 import (
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 )
@@ -36,6 +37,57 @@ func (c Compression) String() string {
 	}
 }
 
+// The bounds of the ZstdOptions.
+const (
+	// DefaultZstdLevel is the compression level used when ZstdOptions.Level
+	// is zero.
+	DefaultZstdLevel = 3
+	// MaxZstdLevel is the maximum ZstdOptions.Level.
+	MaxZstdLevel = 22
+	// MinZstdWindowLog and MaxZstdWindowLog bound ZstdOptions.WindowLog.
+	// Tables whose blocks were compressed with a window larger than
+	// 1<<MaxZstdWindowLog are rejected by readers, since decompressing their
+	// blocks could require an unbounded amount of memory.
+	MinZstdWindowLog = 10
+	MaxZstdWindowLog = 27
+)
+
+// ZstdOptions configures the compression of the blocks of a table whose
+// Compression is ZstdCompression. The parameters are recorded in the
+// properties of the table (Properties.ZstdLevel and ZstdWindowLog).
+type ZstdOptions struct {
+	// Level is the compression level, between 1 and MaxZstdLevel: higher
+	// levels compress better but more slowly. The default value of zero means
+	// DefaultZstdLevel.
+	Level int
+	// WindowLog is the base 2 logarithm of the maximum size of the window of
+	// the compressor, between MinZstdWindowLog and MaxZstdWindowLog, which
+	// bounds the memory required to decompress a block. Since blocks are
+	// compressed independently, a window larger than the blocks has no
+	// effect. The default value of zero lets the compressor size the window
+	// according to the level and the blocks.
+	WindowLog int
+}
+
+func (o ZstdOptions) level() int {
+	if o.Level == 0 {
+		return DefaultZstdLevel
+	}
+	return o.Level
+}
+
+// Validate returns an error if the options are out of bounds.
+func (o ZstdOptions) Validate() error {
+	if o.Level < 0 || o.Level > MaxZstdLevel {
+		return errors.Errorf("pebble: zstd level %d must be in the range [1, %d]", o.Level, MaxZstdLevel)
+	}
+	if o.WindowLog != 0 && (o.WindowLog < MinZstdWindowLog || o.WindowLog > MaxZstdWindowLog) {
+		return errors.Errorf("pebble: zstd window log %d must be in the range [%d, %d]",
+			o.WindowLog, MinZstdWindowLog, MaxZstdWindowLog)
+	}
+	return nil
+}
+
 // FilterType exports the base.FilterType type.
 type FilterType = base.FilterType
 
@@ -120,6 +172,24 @@ type ReaderOptions struct {
 
 	// Logger is an optional logger and tracer.
 	LoggerAndTracer base.LoggerAndTracer
+
+	// Tracer is used to trace the blocks read from storage (see
+	// WithTraceLevel). The default tracer does no tracing.
+	Tracer base.Tracer
+
+	// EncryptionKeyManager is used to retrieve the data keys of encrypted
+	// tables. Opening an encrypted table fails if it is nil.
+	EncryptionKeyManager EncryptionKeyManager
+
+	// FilterVerification, if non-nil, enables the verification of a sample of
+	// the negative decisions made by filters. See FilterVerificationOptions.
+	FilterVerification *FilterVerificationOptions
+
+	// BlobValueFetcher fetches the values of the table that are stored in blob
+	// files (see Writer.AddWithBlobHandle). It is passed the encoded handle of
+	// the value, without its length. Reading such a value fails if it is nil.
+	// The fetcher must be comparable.
+	BlobValueFetcher base.ValueFetcher
 }
 
 func (o ReaderOptions) ensureDefaults() ReaderOptions {
@@ -132,6 +202,9 @@ func (o ReaderOptions) ensureDefaults() ReaderOptions {
 	if o.LoggerAndTracer == nil {
 		o.LoggerAndTracer = base.NoopLoggerAndTracer{}
 	}
+	if o.Tracer == nil {
+		o.Tracer = base.NoopTracer{}
+	}
 	return o
 }
 
@@ -172,6 +245,24 @@ type WriterOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// Zstd configures the compression level and window of ZstdCompression.
+	// It is ignored by the other compressions.
+	Zstd ZstdOptions
+
+	// CompressionDict is a dictionary used to compress the data and value
+	// blocks of the table when Compression is ZstdCompression, improving the
+	// compression of small blocks whose contents recur across blocks. It's
+	// either a dictionary in the zstd format, such as one produced by `zstd
+	// --train`, or raw content (see DictionaryTrainer). The dictionary is
+	// stored in the table, so that readers don't need it. Tables compressed
+	// with a dictionary declare TableFeatureCompressionDict, which requires a
+	// Pebble table format. It's ignored by the other compressions.
+	CompressionDict []byte
+
+	// CompressionDictTrainer, if non-nil, is given the uncompressed data blocks
+	// of the table, to train the dictionaries of subsequent tables.
+	CompressionDictTrainer *DictionaryTrainer
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
@@ -228,6 +319,13 @@ type WriterOptions struct {
 	// Writer client goroutine.
 	Parallelism bool
 
+	// CompressionConcurrency is the number of goroutines compressing data
+	// blocks concurrently when Parallelism is set. The blocks are written in
+	// order regardless of the order in which their compression completes. If
+	// it's at most 1, data blocks are compressed by the Writer client
+	// goroutine.
+	CompressionConcurrency int
+
 	// ShortAttributeExtractor mirrors
 	// Options.Experimental.ShortAttributeExtractor.
 	ShortAttributeExtractor base.ShortAttributeExtractor
@@ -235,6 +333,19 @@ type WriterOptions struct {
 	// RequiredInPlaceValueBound mirrors
 	// Options.Experimental.RequiredInPlaceValueBound.
 	RequiredInPlaceValueBound UserKeyPrefixBound
+
+	// EncryptionKeyManager, if non-nil, enables block-level encryption of the
+	// table. A new data key is obtained from the EncryptionKeyManager for each
+	// table. See EncryptionKeyManager for details.
+	EncryptionKeyManager EncryptionKeyManager
+
+	// ValueChecksums, if true, appends a checksum to each value of the table,
+	// which is verified when the value is read, to detect the corruption of
+	// values in memory, eg, in the block cache, that block checksums don't
+	// detect. Tables written with value checksums declare
+	// TableFeatureValueChecksums, which requires a Pebble table format, and
+	// cannot be read by versions of Pebble that predate them.
+	ValueChecksums bool
 }
 
 func (o WriterOptions) ensureDefaults() WriterOptions {
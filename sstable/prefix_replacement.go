@@ -0,0 +1,268 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+)
+
+// Prefix replacement
+//
+// A Reader opened with a base.PrefixReplacement exposes the keys of the table
+// that have the content prefix with the synthetic prefix in its place, and
+// hides the other keys of the table. The iterators of such a Reader translate
+// the keys passed to them, ie, seek keys and bounds, into the content key
+// space, and the keys they return into the synthetic key space. Point
+// iterators are constrained to the content key space through their bounds, or
+// for compaction iterators which don't support bounds, by skipping the keys
+// outside it. Range deletion and range key spans are truncated to the content
+// key space.
+
+// prefixReplacementOpt is a Reader open option for specifying the prefix
+// replacement applied to the keys of the table.
+type prefixReplacementOpt struct {
+	p *base.PrefixReplacement
+}
+
+func (o prefixReplacementOpt) readerApply(r *Reader) {
+	r.prefixReplacement = o.p
+}
+
+// prefixReplacingIter wraps the point iterator of a Reader opened with a prefix
+// replacement.
+type prefixReplacingIter struct {
+	Iterator
+	cmp Compare
+	p   *base.PrefixReplacement
+	// contentLower and contentUpper are the bounds of the content key space.
+	contentLower, contentUpper []byte
+	// bounded is true if the bounds of the wrapped iterator constrain it to
+	// the content key space. lower and upper are the bounds of the wrapped
+	// iterator, in the content key space.
+	bounded      bool
+	lower, upper []byte
+	// boundsBuf holds two buffers used to store the translated bounds, so that
+	// the bounds previously passed to the wrapped iterator remain valid when
+	// new bounds are set.
+	boundsBuf    [2][]byte
+	boundsBufIdx int
+	argBuf       []byte
+	keyBuf       []byte
+	ikey         InternalKey
+}
+
+var _ Iterator = (*prefixReplacingIter)(nil)
+
+func newPrefixReplacingIter(r *Reader, bounded bool) *prefixReplacingIter {
+	i := &prefixReplacingIter{cmp: r.Compare, p: r.prefixReplacement, bounded: bounded}
+	i.contentLower, i.contentUpper = i.p.ContentBounds()
+	return i
+}
+
+// translateBounds translates the given bounds into the content key space,
+// constraining them to it.
+func (i *prefixReplacingIter) translateBounds(lower, upper []byte) ([]byte, []byte) {
+	buf := i.boundsBuf[i.boundsBufIdx][:0]
+	i.lower, i.upper = i.contentLower, i.contentUpper
+	if lower != nil {
+		buf = i.p.ReplaceArg(buf, lower)
+		i.lower = buf
+	}
+	if upper != nil {
+		n := len(buf)
+		buf = i.p.ReplaceArg(buf, upper)
+		i.upper = buf[n:]
+		if lower != nil {
+			i.lower = buf[:n]
+		}
+	}
+	i.boundsBuf[i.boundsBufIdx] = buf
+	i.boundsBufIdx = 1 - i.boundsBufIdx
+	return i.lower, i.upper
+}
+
+// forward skips the keys that precede the content key space when iterating
+// forward, and returns the translated key, or nil if the key follows the
+// content key space.
+func (i *prefixReplacingIter) forward(
+	k *InternalKey, v base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	for k != nil && i.cmp(k.UserKey, i.contentLower) < 0 {
+		k, v = i.Iterator.Next()
+	}
+	if k != nil && i.cmp(k.UserKey, i.contentUpper) >= 0 {
+		return nil, base.LazyValue{}
+	}
+	return i.result(k, v)
+}
+
+// backward skips the keys that follow the content key space when iterating
+// backward, and returns the translated key, or nil if the key precedes the
+// content key space.
+func (i *prefixReplacingIter) backward(
+	k *InternalKey, v base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	for k != nil && i.cmp(k.UserKey, i.contentUpper) >= 0 {
+		k, v = i.Iterator.Prev()
+	}
+	if k != nil && i.cmp(k.UserKey, i.contentLower) < 0 {
+		return nil, base.LazyValue{}
+	}
+	return i.result(k, v)
+}
+
+func (i *prefixReplacingIter) result(
+	k *InternalKey, v base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	if k == nil {
+		return nil, v
+	}
+	i.keyBuf = i.p.ReplaceResult(i.keyBuf[:0], k.UserKey)
+	i.ikey = InternalKey{UserKey: i.keyBuf, Trailer: k.Trailer}
+	return &i.ikey, v
+}
+
+// SeekGE implements base.InternalIterator.
+func (i *prefixReplacingIter) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	i.argBuf = i.p.ReplaceArg(i.argBuf[:0], key)
+	return i.forward(i.Iterator.SeekGE(i.argBuf, flags))
+}
+
+// SeekPrefixGE implements base.InternalIterator.
+func (i *prefixReplacingIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	if !bytes.HasPrefix(prefix, i.p.SyntheticPrefix) {
+		// The prefix can't be translated. Fall back to a SeekGE, which is
+		// permitted to return keys that don't match the prefix.
+		return i.SeekGE(key, flags)
+	}
+	i.argBuf = i.p.ReplaceArg(i.argBuf[:0], key)
+	n := len(i.argBuf)
+	i.argBuf = i.p.ReplaceArg(i.argBuf, prefix)
+	return i.forward(i.Iterator.SeekPrefixGE(i.argBuf[n:], i.argBuf[:n], flags))
+}
+
+// SeekLT implements base.InternalIterator.
+func (i *prefixReplacingIter) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*InternalKey, base.LazyValue) {
+	i.argBuf = i.p.ReplaceArg(i.argBuf[:0], key)
+	return i.backward(i.Iterator.SeekLT(i.argBuf, flags))
+}
+
+// First implements base.InternalIterator.
+func (i *prefixReplacingIter) First() (*InternalKey, base.LazyValue) {
+	if i.bounded {
+		// The wrapped iterator has a lower bound, which First doesn't respect.
+		return i.forward(i.Iterator.SeekGE(i.lower, base.SeekGEFlagsNone))
+	}
+	return i.forward(i.Iterator.First())
+}
+
+// Last implements base.InternalIterator.
+func (i *prefixReplacingIter) Last() (*InternalKey, base.LazyValue) {
+	if i.bounded {
+		// The wrapped iterator has an upper bound, which Last doesn't respect.
+		return i.backward(i.Iterator.SeekLT(i.upper, base.SeekLTFlagsNone))
+	}
+	return i.backward(i.Iterator.Last())
+}
+
+// Next implements base.InternalIterator.
+func (i *prefixReplacingIter) Next() (*InternalKey, base.LazyValue) {
+	return i.forward(i.Iterator.Next())
+}
+
+// NextPrefix implements Iterator.
+func (i *prefixReplacingIter) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	i.argBuf = i.p.ReplaceArg(i.argBuf[:0], succKey)
+	return i.forward(i.Iterator.NextPrefix(i.argBuf))
+}
+
+// Prev implements base.InternalIterator.
+func (i *prefixReplacingIter) Prev() (*InternalKey, base.LazyValue) {
+	return i.backward(i.Iterator.Prev())
+}
+
+// SetBounds implements base.InternalIterator.
+func (i *prefixReplacingIter) SetBounds(lower, upper []byte) {
+	i.Iterator.SetBounds(i.translateBounds(lower, upper))
+}
+
+// prefixReplacingSpanIter wraps the range deletion or range key iterator of a
+// Reader opened with a prefix replacement. The wrapped iterator must be
+// truncated to the content key space.
+type prefixReplacingSpanIter struct {
+	keyspan.FragmentIterator
+	p                *base.PrefixReplacement
+	argBuf           []byte
+	startBuf, endBuf []byte
+	span             keyspan.Span
+}
+
+var _ keyspan.FragmentIterator = (*prefixReplacingSpanIter)(nil)
+
+func newPrefixReplacingSpanIter(r *Reader, iter keyspan.FragmentIterator) *prefixReplacingSpanIter {
+	lower, upper := r.prefixReplacement.ContentBounds()
+	return &prefixReplacingSpanIter{
+		FragmentIterator: keyspan.Truncate(
+			r.Compare, iter, lower, upper, nil, nil, false, /* panicOnPartialOverlap */
+		),
+		p: r.prefixReplacement,
+	}
+}
+
+func (i *prefixReplacingSpanIter) result(s *keyspan.Span) *keyspan.Span {
+	if s == nil {
+		return nil
+	}
+	i.startBuf = i.p.ReplaceResult(i.startBuf[:0], s.Start)
+	i.endBuf = i.p.ReplaceResult(i.endBuf[:0], s.End)
+	i.span = keyspan.Span{
+		Start:     i.startBuf,
+		End:       i.endBuf,
+		Keys:      s.Keys,
+		KeysOrder: s.KeysOrder,
+	}
+	return &i.span
+}
+
+// SeekGE implements keyspan.FragmentIterator.
+func (i *prefixReplacingSpanIter) SeekGE(key []byte) *keyspan.Span {
+	i.argBuf = i.p.ReplaceArg(i.argBuf[:0], key)
+	return i.result(i.FragmentIterator.SeekGE(i.argBuf))
+}
+
+// SeekLT implements keyspan.FragmentIterator.
+func (i *prefixReplacingSpanIter) SeekLT(key []byte) *keyspan.Span {
+	i.argBuf = i.p.ReplaceArg(i.argBuf[:0], key)
+	return i.result(i.FragmentIterator.SeekLT(i.argBuf))
+}
+
+// First implements keyspan.FragmentIterator.
+func (i *prefixReplacingSpanIter) First() *keyspan.Span {
+	return i.result(i.FragmentIterator.First())
+}
+
+// Last implements keyspan.FragmentIterator.
+func (i *prefixReplacingSpanIter) Last() *keyspan.Span {
+	return i.result(i.FragmentIterator.Last())
+}
+
+// Next implements keyspan.FragmentIterator.
+func (i *prefixReplacingSpanIter) Next() *keyspan.Span {
+	return i.result(i.FragmentIterator.Next())
+}
+
+// Prev implements keyspan.FragmentIterator.
+func (i *prefixReplacingSpanIter) Prev() *keyspan.Span {
+	return i.result(i.FragmentIterator.Prev())
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixReplacement(t *testing.T) {
+	fs := vfs.NewMem()
+	f, err := fs.Create("table")
+	require.NoError(t, err)
+	w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+		BlockSize:   32,
+		TableFormat: TableFormatPebblev2,
+	})
+	var keys []string
+	for _, prefix := range []string{"a/", "src/", "z/"} {
+		for i := 0; i < 10; i++ {
+			keys = append(keys, fmt.Sprintf("%s%02d", prefix, i))
+		}
+	}
+	for _, k := range keys {
+		require.NoError(t, w.Set([]byte(k), []byte(k)))
+	}
+	require.NoError(t, w.DeleteRange([]byte("a/05"), []byte("src/03")))
+	require.NoError(t, w.RangeKeySet([]byte("src/08"), []byte("z/02"), nil, []byte("v")))
+	require.NoError(t, w.Close())
+
+	f, err = fs.Open("table")
+	require.NoError(t, err)
+	readable, err := NewSimpleReadable(f)
+	require.NoError(t, err)
+	p := &base.PrefixReplacement{ContentPrefix: []byte("src/"), SyntheticPrefix: []byte("dst/")}
+	r, err := NewReader(readable, ReaderOptions{}, prefixReplacementOpt{p})
+	require.NoError(t, err)
+	defer r.Close()
+
+	collect := func(iter Iterator, k *InternalKey, next func() (*InternalKey, base.LazyValue)) string {
+		var buf strings.Builder
+		for ; k != nil; k, _ = next() {
+			fmt.Fprintf(&buf, "%s ", k.UserKey)
+		}
+		require.NoError(t, iter.Error())
+		return strings.TrimSpace(buf.String())
+	}
+	var all []string
+	for i := 0; i < 10; i++ {
+		all = append(all, fmt.Sprintf("dst/%02d", i))
+	}
+
+	// Only the keys with the content prefix are exposed, with the synthetic
+	// prefix in its place, in both directions.
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	k, _ := iter.First()
+	require.Equal(t, strings.Join(all, " "), collect(iter, k, iter.Next))
+	k, _ = iter.Last()
+	var reversed []string
+	for i := len(all) - 1; i >= 0; i-- {
+		reversed = append(reversed, all[i])
+	}
+	require.Equal(t, strings.Join(reversed, " "), collect(iter, k, iter.Prev))
+
+	// Seek keys are translated, including those without the synthetic prefix.
+	k, _ = iter.SeekGE([]byte("dst/05"), base.SeekGEFlagsNone)
+	require.Equal(t, "dst/05", string(k.UserKey))
+	k, _ = iter.SeekGE([]byte("b"), base.SeekGEFlagsNone)
+	require.Equal(t, "dst/00", string(k.UserKey))
+	k, _ = iter.SeekGE([]byte("e"), base.SeekGEFlagsNone)
+	require.Nil(t, k)
+	k, _ = iter.SeekLT([]byte("e"), base.SeekLTFlagsNone)
+	require.Equal(t, "dst/09", string(k.UserKey))
+	k, _ = iter.SeekPrefixGE([]byte("dst/07"), []byte("dst/07"), base.SeekGEFlagsNone)
+	require.Equal(t, "dst/07", string(k.UserKey))
+	require.NoError(t, iter.Close())
+
+	// Bounds are translated.
+	iter, err = r.NewIter([]byte("dst/03"), []byte("dst/06"))
+	require.NoError(t, err)
+	k, _ = iter.First()
+	require.Equal(t, "dst/03 dst/04 dst/05", collect(iter, k, iter.Next))
+	iter.SetBounds([]byte("c"), []byte("dst/02"))
+	k, _ = iter.Last()
+	require.Equal(t, "dst/01 dst/00", collect(iter, k, iter.Prev))
+	require.NoError(t, iter.Close())
+
+	// Compaction iterators skip the keys without the content prefix.
+	iter, err = r.NewCompactionIter(new(uint64), TrivialReaderProvider{Reader: r})
+	require.NoError(t, err)
+	k, _ = iter.First()
+	require.Equal(t, strings.Join(all, " "), collect(iter, k, iter.Next))
+	require.NoError(t, iter.Close())
+
+	// Spans are truncated to the content key space and translated.
+	spans := func(iter keyspan.FragmentIterator) string {
+		var buf strings.Builder
+		for s := iter.First(); s != nil; s = iter.Next() {
+			fmt.Fprintf(&buf, "%s ", s)
+		}
+		require.NoError(t, iter.Close())
+		return strings.TrimSpace(buf.String())
+	}
+	rangeDelIter, err := r.NewRawRangeDelIter()
+	require.NoError(t, err)
+	require.Equal(t, "dst/-dst/03:{(#0,RANGEDEL)}", spans(rangeDelIter))
+	rangeKeyIter, err := r.NewRawRangeKeyIter()
+	require.NoError(t, err)
+	require.Equal(t, "dst/08-dst0:{(#0,RANGEKEYSET,,v)}", spans(rangeKeyIter))
+}
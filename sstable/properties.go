@@ -56,6 +56,9 @@ func init() {
 // automatically populated during sstable creation and load from the properties
 // meta block when an sstable is opened.
 type Properties struct {
+	// The total length of the values of this table stored in blob files. Only
+	// serialized if > 0.
+	BlobValueSize uint64 `prop:"pebble.blob.value.size"`
 	// ID of column family for this SST file, corresponding to the CF identified
 	// by column_family_name.
 	ColumnFamilyID uint64 `prop:"rocksdb.column.family.id"`
@@ -73,6 +76,9 @@ type Properties struct {
 	CreationTime uint64 `prop:"rocksdb.creation.time"`
 	// The total size of all data blocks.
 	DataSize uint64 `prop:"rocksdb.data.size"`
+	// The identifier of the data key with which the table's blocks are
+	// encrypted. Empty if the table is not encrypted.
+	EncryptionKeyID string `prop:"pebble.encryption.key-id"`
 	// The external sstable version format. Version 2 is the one RocksDB has been
 	// using since 5.13. RocksDB only uses the global sequence number for an
 	// sstable if this property has been set.
@@ -103,6 +109,9 @@ type Properties struct {
 	IndexValueIsDeltaEncoded uint64 `prop:"rocksdb.index.value.is.delta.encoded"`
 	// The name of the merger used in this table. Empty if no merger is used.
 	MergerName string `prop:"rocksdb.merge.operator"`
+	// The number of values of this table stored in blob files. Only serialized
+	// if > 0.
+	NumBlobValues uint64 `prop:"pebble.num.blob-values"`
 	// The number of blocks in this table.
 	NumDataBlocks uint64 `prop:"rocksdb.num.data.blocks"`
 	// The number of deletion entries in this table, including both point and
@@ -159,8 +168,18 @@ type Properties struct {
 	UserProperties map[string]string
 	// Total size of value blocks and value index block. Only serialized if > 0.
 	ValueBlocksSize uint64 `prop:"pebble.value-blocks.size"`
+	// Whether the values of the table carry checksums (see
+	// WriterOptions.ValueChecksums). Only serialized if true.
+	ValueChecksums bool `prop:"pebble.value.checksums"`
 	// If filtering is enabled, was the filter created on the whole key.
 	WholeKeyFiltering bool `prop:"rocksdb.block.based.table.whole.key.filtering"`
+	// The zstd compression level of the blocks of the table, if the table is
+	// compressed with ZstdCompression (see WriterOptions.Zstd). Only
+	// serialized if > 0, and otherwise DefaultZstdLevel.
+	ZstdLevel uint64 `prop:"pebble.compression.zstd.level"`
+	// The base 2 logarithm of the maximum window of the zstd compressor, if
+	// it was limited. Only serialized if > 0.
+	ZstdWindowLog uint64 `prop:"pebble.compression.zstd.window-log"`
 
 	// Loaded set indicating which fields have been loaded from disk. Indexed by
 	// the field's byte offset within the struct
@@ -321,6 +340,9 @@ func (p *Properties) save(tblFormat TableFormat, w *rawBlockWriter) {
 	}
 	p.saveUvarint(m, unsafe.Offsetof(p.CreationTime), p.CreationTime)
 	p.saveUvarint(m, unsafe.Offsetof(p.DataSize), p.DataSize)
+	if p.EncryptionKeyID != "" {
+		p.saveString(m, unsafe.Offsetof(p.EncryptionKeyID), p.EncryptionKeyID)
+	}
 	if p.ExternalFormatVersion != 0 {
 		p.saveUint32(m, unsafe.Offsetof(p.ExternalFormatVersion), p.ExternalFormatVersion)
 		p.saveUint64(m, unsafe.Offsetof(p.GlobalSeqNum), p.GlobalSeqNum)
@@ -364,6 +386,12 @@ func (p *Properties) save(tblFormat TableFormat, w *rawBlockWriter) {
 		p.saveUvarint(m, unsafe.Offsetof(p.RawRangeKeyKeySize), p.RawRangeKeyKeySize)
 		p.saveUvarint(m, unsafe.Offsetof(p.RawRangeKeyValueSize), p.RawRangeKeyValueSize)
 	}
+	if p.NumBlobValues > 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.NumBlobValues), p.NumBlobValues)
+	}
+	if p.BlobValueSize > 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.BlobValueSize), p.BlobValueSize)
+	}
 	if p.NumValueBlocks > 0 {
 		p.saveUvarint(m, unsafe.Offsetof(p.NumValueBlocks), p.NumValueBlocks)
 	}
@@ -388,7 +416,16 @@ func (p *Properties) save(tblFormat TableFormat, w *rawBlockWriter) {
 	if p.ValueBlocksSize > 0 {
 		p.saveUvarint(m, unsafe.Offsetof(p.ValueBlocksSize), p.ValueBlocksSize)
 	}
+	if p.ValueChecksums {
+		p.saveBool(m, unsafe.Offsetof(p.ValueChecksums), p.ValueChecksums)
+	}
 	p.saveBool(m, unsafe.Offsetof(p.WholeKeyFiltering), p.WholeKeyFiltering)
+	if p.ZstdLevel > 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.ZstdLevel), p.ZstdLevel)
+	}
+	if p.ZstdWindowLog > 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.ZstdWindowLog), p.ZstdWindowLog)
+	}
 
 	keys := make([]string, 0, len(m))
 	for key := range m {
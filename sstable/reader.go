@@ -10,6 +10,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"sort"
 	"sync"
@@ -19,6 +20,7 @@ import (
 	"github.com/cespare/xxhash/v2"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/blob"
 	"github.com/cockroachdb/pebble/internal/bytealloc"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/crc"
@@ -457,6 +459,9 @@ func (i *singleLevelIterator) init(
 			i.vbRH = objstorageprovider.UsePreallocatedReadHandle(ctx, r.readable, &i.vbRHPrealloc)
 		}
 		i.data.lazyValueHandling.hasValuePrefix = true
+		if r.Properties.NumBlobValues > 0 {
+			i.data.lazyValueHandling.blobFetcher = r.blobValueFetcher()
+		}
 	}
 	return nil
 }
@@ -555,6 +560,9 @@ func (i *singleLevelIterator) loadBlock(dir int8) loadBlockResult {
 			intersects = i.resolveMaybeExcluded(dir)
 		}
 		if intersects == blockExcluded {
+			if i.reader.opts.FilterVerification.sample() {
+				i.reader.queueBlockPropertiesExclusion(bhp.BlockHandle, i.bpfs, false /* index */)
+			}
 			i.maybeFilteredKeysSingleLevel = true
 			return loadBlockIrrelevant
 		}
@@ -955,7 +963,16 @@ func (i *singleLevelIterator) seekPrefixGE(
 		}
 		mayContain := i.reader.tableFilter.mayContain(dataH.Get(), prefix)
 		dataH.Release()
+		if i.stats != nil {
+			i.stats.Filter.Checks++
+			if !mayContain {
+				i.stats.Filter.Negatives++
+			}
+		}
 		if !mayContain {
+			if i.reader.opts.FilterVerification.sample() {
+				i.reader.queueTableFilterNegative(prefix)
+			}
 			// This invalidation may not be necessary for correctness, and may
 			// be a place to optimize later by reusing the already loaded
 			// block. It was necessary in earlier versions of the code since
@@ -1752,6 +1769,9 @@ func (i *twoLevelIterator) loadIndex(dir int8) loadBlockResult {
 			intersects = i.resolveMaybeExcluded(dir)
 		}
 		if intersects == blockExcluded {
+			if i.reader.opts.FilterVerification.sample() {
+				i.reader.queueBlockPropertiesExclusion(bhp.BlockHandle, i.bpfs, true /* index */)
+			}
 			i.maybeFilteredKeysTwoLevel = true
 			return loadBlockIrrelevant
 		}
@@ -1887,6 +1907,9 @@ func (i *twoLevelIterator) init(
 			i.vbRH = r.readable.NewReadHandle(ctx)
 		}
 		i.data.lazyValueHandling.hasValuePrefix = true
+		if r.Properties.NumBlobValues > 0 {
+			i.data.lazyValueHandling.blobFetcher = r.blobValueFetcher()
+		}
 	}
 	return nil
 }
@@ -2116,7 +2139,16 @@ func (i *twoLevelIterator) SeekPrefixGE(
 		}
 		mayContain := i.reader.tableFilter.mayContain(dataH.Get(), prefix)
 		dataH.Release()
+		if i.stats != nil {
+			i.stats.Filter.Checks++
+			if !mayContain {
+				i.stats.Filter.Negatives++
+			}
+		}
 		if !mayContain {
+			if i.reader.opts.FilterVerification.sample() {
+				i.reader.queueTableFilterNegative(prefix)
+			}
 			// This invalidation may not be necessary for correctness, and may
 			// be a place to optimize later by reusing the already loaded
 			// block. It was necessary in earlier versions of the code since
@@ -2845,6 +2877,9 @@ func init() {
 		return &cacheOpts{cacheID, fileNum}
 	}
 	private.SSTableRawTombstonesOpt = rawTombstonesOpt{}
+	private.SSTablePrefixReplacementOpt = func(p *base.PrefixReplacement) interface{} {
+		return prefixReplacementOpt{p}
+	}
 }
 
 // VirtualReader wraps Reader. Its purpose is to restrict functionality of the
@@ -2858,11 +2893,12 @@ type VirtualReader struct {
 	vState     virtualState
 	reader     *Reader
 	Properties struct {
-		// RawKeySize, RawValueSize are set upon construction of a
-		// VirtualReader. The values of the fields is extrapolated. See
+		// RawKeySize, RawValueSize and NumEntries are set upon construction of
+		// a VirtualReader. The values of the fields is extrapolated. See
 		// MakeVirtualReader for implementation details.
 		RawKeySize   uint64
 		RawValueSize uint64
+		NumEntries   uint64
 	}
 }
 
@@ -2896,6 +2932,8 @@ func MakeVirtualReader(reader *Reader, meta manifest.VirtualFileMeta) VirtualRea
 		(reader.Properties.RawKeySize * meta.Size) / meta.FileBacking.Size
 	v.Properties.RawValueSize =
 		(reader.Properties.RawValueSize * meta.Size) / meta.FileBacking.Size
+	v.Properties.NumEntries =
+		(reader.Properties.NumEntries * meta.Size) / meta.FileBacking.Size
 
 	return v
 }
@@ -2935,11 +2973,11 @@ func (v *VirtualReader) NewRawRangeDelIter() (keyspan.FragmentIterator, error) {
 		return nil, nil
 	}
 
-	// There should be no spans which cross virtual sstable bounds. So, no
-	// truncation should occur.
+	// Spans may cross the bounds of a virtual sstable created by an excise,
+	// whose bounds are set to truncate them.
 	return keyspan.Truncate(
 		v.reader.Compare, iter, v.vState.lower.UserKey, v.vState.upper.UserKey,
-		&v.vState.lower, &v.vState.upper, true, /* panicOnPartialOverlap */
+		&v.vState.lower, &v.vState.upper, false, /* panicOnPartialOverlap */
 	), nil
 }
 
@@ -2953,11 +2991,11 @@ func (v *VirtualReader) NewRawRangeKeyIter() (keyspan.FragmentIterator, error) {
 		return nil, nil
 	}
 
-	// There should be no spans which cross virtual sstable bounds. So, no
-	// truncation should occur.
+	// Spans may cross the bounds of a virtual sstable created by an excise,
+	// whose bounds are set to truncate them.
 	return keyspan.Truncate(
 		v.reader.Compare, iter, v.vState.lower.UserKey, v.vState.upper.UserKey,
-		&v.vState.lower, &v.vState.upper, true, /* panicOnPartialOverlap */
+		&v.vState.lower, &v.vState.upper, false, /* panicOnPartialOverlap */
 	), nil
 }
 
@@ -3015,6 +3053,7 @@ type Reader struct {
 	rangeDelTransform blockTransform
 	valueBIH          valueBlocksIndexHandle
 	propertiesBH      BlockHandle
+	zstdDictBH        BlockHandle
 	metaIndexBH       BlockHandle
 	footerBH          BlockHandle
 	opts              ReaderOptions
@@ -3022,17 +3061,35 @@ type Reader struct {
 	FormatKey         base.FormatKey
 	Split             Split
 	tableFilter       *tableFilterReader
+	// cipher is non-nil if the table's blocks are encrypted.
+	cipher *blockCipher
+	// zstdDict is non-nil if the table's blocks are compressed with a zstd
+	// dictionary (see WriterOptions.CompressionDict).
+	zstdDict *zstdDict
 	// Keep types that are not multiples of 8 bytes at the end and with
 	// decreasing size.
 	Properties    Properties
 	tableFormat   TableFormat
+	features      TableFeatures
 	rawTombstones bool
 	mergerOK      bool
 	checksumType  ChecksumType
+	// prefixReplacement is non-nil if the keys of the table are exposed with
+	// a replaced prefix. See prefix_replacement.go.
+	prefixReplacement *base.PrefixReplacement
+	// verification is non-nil if filter verification is enabled, and prevents
+	// the Reader from being closed while a queued verification is reading from
+	// it. See FilterVerificationOptions.
+	verification *readerVerification
 }
 
 // Close implements DB.Close, as documented in the pebble package.
 func (r *Reader) Close() error {
+	if r.verification != nil {
+		r.verification.Lock()
+		r.verification.closed = true
+		r.verification.Unlock()
+	}
 	r.opts.Cache.Unref()
 
 	if r.readable != nil {
@@ -3093,13 +3150,18 @@ func (r *Reader) newIterWithBlockPropertyFiltersAndContext(
 	// NB: pebble.tableCache wraps the returned iterator with one which performs
 	// reference counting on the Reader, preventing the Reader from being closed
 	// until the final iterator closes.
+	var pi *prefixReplacingIter
+	if r.prefixReplacement != nil {
+		pi = newPrefixReplacingIter(r, true /* bounded */)
+		lower, upper = pi.translateBounds(lower, upper)
+	}
 	if r.Properties.IndexType == twoLevelIndex {
 		i := twoLevelIterPool.Get().(*twoLevelIterator)
 		err := i.init(ctx, r, v, lower, upper, filterer, useFilterBlock, stats, rp)
 		if err != nil {
 			return nil, err
 		}
-		return i, nil
+		return r.maybeReplacePrefix(pi, r.maybeVerifyValueChecksums(i)), nil
 	}
 
 	i := singleLevelIterPool.Get().(*singleLevelIterator)
@@ -3107,7 +3169,26 @@ func (r *Reader) newIterWithBlockPropertyFiltersAndContext(
 	if err != nil {
 		return nil, err
 	}
-	return i, nil
+	return r.maybeReplacePrefix(pi, r.maybeVerifyValueChecksums(i)), nil
+}
+
+// maybeReplacePrefix wraps the iterator with the given prefix replacing
+// iterator, if the Reader was opened with a prefix replacement.
+func (r *Reader) maybeReplacePrefix(pi *prefixReplacingIter, iter Iterator) Iterator {
+	if pi == nil {
+		return iter
+	}
+	pi.Iterator = iter
+	return pi
+}
+
+// maybeVerifyValueChecksums wraps the iterator with one that verifies and
+// strips the value checksums if the table was written with value checksums.
+func (r *Reader) maybeVerifyValueChecksums(iter Iterator) Iterator {
+	if r.features&TableFeatureValueChecksums == 0 {
+		return iter
+	}
+	return &valueChecksumIter{Iterator: iter, reader: r}
 }
 
 // NewIter returns an iterator for the contents of the table. If an error
@@ -3130,6 +3211,12 @@ func (r *Reader) NewCompactionIter(bytesIterated *uint64, rp ReaderProvider) (It
 func (r *Reader) newCompactionIter(
 	bytesIterated *uint64, rp ReaderProvider, v *virtualState,
 ) (Iterator, error) {
+	var pi *prefixReplacingIter
+	if r.prefixReplacement != nil {
+		// Compaction iterators don't support bounds, so the keys outside the
+		// content key space are skipped instead.
+		pi = newPrefixReplacingIter(r, false /* bounded */)
+	}
 	if r.Properties.IndexType == twoLevelIndex {
 		i := twoLevelIterPool.Get().(*twoLevelIterator)
 		err := i.init(
@@ -3141,10 +3228,10 @@ func (r *Reader) newCompactionIter(
 			return nil, err
 		}
 		i.setupForCompaction()
-		return &twoLevelCompactionIterator{
+		return r.maybeReplacePrefix(pi, r.maybeVerifyValueChecksums(&twoLevelCompactionIterator{
 			twoLevelIterator: i,
 			bytesIterated:    bytesIterated,
-		}, nil
+		})), nil
 	}
 	i := singleLevelIterPool.Get().(*singleLevelIterator)
 	err := i.init(
@@ -3155,10 +3242,10 @@ func (r *Reader) newCompactionIter(
 		return nil, err
 	}
 	i.setupForCompaction()
-	return &compactionIterator{
+	return r.maybeReplacePrefix(pi, r.maybeVerifyValueChecksums(&compactionIterator{
 		singleLevelIterator: i,
 		bytesIterated:       bytesIterated,
-	}, nil
+	})), nil
 }
 
 // NewRawRangeDelIter returns an internal iterator for the contents of the
@@ -3179,7 +3266,16 @@ func (r *Reader) NewRawRangeDelIter() (keyspan.FragmentIterator, error) {
 	if err := i.blockIter.initHandle(r.Compare, h, r.Properties.GlobalSeqNum); err != nil {
 		return nil, err
 	}
-	return i, nil
+	return r.maybeReplaceSpanPrefix(i), nil
+}
+
+// maybeReplaceSpanPrefix wraps the span iterator with one that replaces the
+// prefix of the spans, if the Reader was opened with a prefix replacement.
+func (r *Reader) maybeReplaceSpanPrefix(iter keyspan.FragmentIterator) keyspan.FragmentIterator {
+	if r.prefixReplacement == nil {
+		return iter
+	}
+	return newPrefixReplacingSpanIter(r, iter)
 }
 
 // NewRawRangeKeyIter returns an internal iterator for the contents of the
@@ -3200,7 +3296,7 @@ func (r *Reader) NewRawRangeKeyIter() (keyspan.FragmentIterator, error) {
 	if err := i.blockIter.initHandle(r.Compare, h, r.Properties.GlobalSeqNum); err != nil {
 		return nil, err
 	}
-	return i, nil
+	return r.maybeReplaceSpanPrefix(i), nil
 }
 
 type rangeKeyFragmentBlockIter struct {
@@ -3228,6 +3324,38 @@ func (r *Reader) readFilter(
 	return r.readBlock(ctx, r.filterBH, nil /* transform */, nil /* readHandle */, stats)
 }
 
+// TableFilterMayContain returns false if the table filter of the sstable
+// excludes the given prefix, as returned by Split, and true if the sstable
+// may contain keys with the prefix or has no table filter usable by the
+// reader. Unlike the filter checks of SeekPrefixGE, it doesn't require
+// loading the index block of the sstable.
+func (r *Reader) TableFilterMayContain(
+	ctx context.Context, prefix []byte, stats *base.InternalIteratorStats,
+) (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+	if r.tableFilter == nil {
+		return true, nil
+	}
+	dataH, err := r.readFilter(ctx, stats)
+	if err != nil {
+		return false, err
+	}
+	mayContain := r.tableFilter.mayContain(dataH.Get(), prefix)
+	dataH.Release()
+	if stats != nil {
+		stats.Filter.Checks++
+		if !mayContain {
+			stats.Filter.Negatives++
+		}
+	}
+	if !mayContain && r.opts.FilterVerification.sample() {
+		r.queueTableFilterNegative(prefix)
+	}
+	return mayContain, nil
+}
+
 func (r *Reader) readRangeDel(stats *base.InternalIteratorStats) (cache.Handle, error) {
 	ctx := objiotracing.WithBlockType(context.Background(), objiotracing.MetadataBlock)
 	return r.readBlock(ctx, r.rangeDelBH, r.rangeDelTransform, nil /* readHandle */, stats)
@@ -3260,6 +3388,18 @@ func checkChecksum(
 	return nil
 }
 
+// blockRead returns the BlockRead passed to the observer of a context (see
+// WithBlockReadObserver) for a block read by readBlock.
+func (r *Reader) blockRead(bh BlockHandle, cacheHit bool, duration time.Duration) BlockRead {
+	return BlockRead{
+		FileNum:  r.fileNum.FileNum(),
+		Offset:   bh.Offset,
+		Length:   bh.Length,
+		CacheHit: cacheHit,
+		Duration: duration,
+	}
+}
+
 // readBlock reads and decompresses a block from disk into memory.
 func (r *Reader) readBlock(
 	ctx context.Context,
@@ -3267,8 +3407,18 @@ func (r *Reader) readBlock(
 	transform blockTransform,
 	readHandle objstorage.ReadHandle,
 	stats *base.InternalIteratorStats,
-) (handle cache.Handle, _ error) {
-	if h := r.opts.Cache.Get(r.cacheID, r.fileNum, bh.Offset); h.Get() != nil {
+) (handle cache.Handle, retErr error) {
+	observer := blockReadObserverFromContext(ctx)
+	cacheMode := cacheModeFromContext(ctx)
+	// Blocks read with CacheModeNoFill and CacheModeFillLowPriority don't
+	// affect the eviction of the cached blocks.
+	cacheGet := func(c *cache.Cache) cache.Handle {
+		if cacheMode == CacheModeNoFill || cacheMode == CacheModeFillLowPriority {
+			return c.Peek(r.cacheID, r.fileNum, bh.Offset)
+		}
+		return c.Get(r.cacheID, r.fileNum, bh.Offset)
+	}
+	if h := cacheGet(r.opts.Cache); h.Get() != nil {
 		if readHandle != nil {
 			readHandle.RecordCacheHit(ctx, int64(bh.Offset), int64(bh.Length+blockTrailerLen))
 		}
@@ -3276,8 +3426,55 @@ func (r *Reader) readBlock(
 			stats.BlockBytes += bh.Length
 			stats.BlockBytesInCache += bh.Length
 		}
+		if observer != nil {
+			observer(r.blockRead(bh, true /* cacheHit */, 0))
+		}
 		return h, nil
 	}
+	// Blocks read by scans using a scan cache are cached there instead of in
+	// the block cache. See WithScanCache.
+	blockCache := r.opts.Cache
+	if c := scanCacheFromContext(ctx); c != nil && c != blockCache {
+		if h := cacheGet(c); h.Get() != nil {
+			if readHandle != nil {
+				readHandle.RecordCacheHit(ctx, int64(bh.Offset), int64(bh.Length+blockTrailerLen))
+			}
+			if stats != nil {
+				stats.BlockBytes += bh.Length
+				stats.BlockBytesInCache += bh.Length
+			}
+			if observer != nil {
+				observer(r.blockRead(bh, true /* cacheHit */, 0))
+			}
+			return h, nil
+		}
+		blockCache = c
+	}
+	if observer != nil {
+		start := time.Now()
+		defer func() {
+			if retErr == nil {
+				observer(r.blockRead(bh, false /* cacheHit */, time.Since(start)))
+			}
+		}()
+	}
+
+	if r.opts.Tracer.IsTracingEnabled(ctx) {
+		var span base.Span
+		ctx, span = r.opts.Tracer.StartSpan(ctx, ReadBlockSpan)
+		span.SetAttribute(FileNumAttribute, uint64(r.fileNum.FileNum()))
+		if level, ok := traceLevelFromContext(ctx); ok {
+			span.SetAttribute(LevelAttribute, level)
+		}
+		span.SetAttribute(BlockOffsetAttribute, bh.Offset)
+		span.SetAttribute(BlockLengthAttribute, bh.Length)
+		defer func() {
+			if retErr != nil {
+				span.RecordError(retErr)
+			}
+			span.End()
+		}()
+	}
 
 	v := r.opts.Cache.Alloc(int(bh.Length + blockTrailerLen))
 	b := v.Buf()
@@ -3285,6 +3482,13 @@ func (r *Reader) readBlock(
 	var err error
 	if readHandle != nil {
 		err = readHandle.ReadAt(ctx, b, int64(bh.Offset))
+		if size := uint64(readHandle.LastReadaheadSize()); size > 0 && stats != nil {
+			stats.Readahead.Count++
+			stats.Readahead.Bytes += size
+			if stats.Readahead.MaxSize < size {
+				stats.Readahead.MaxSize = size
+			}
+		}
 	} else {
 		err = r.readable.ReadAt(ctx, b, int64(bh.Offset))
 	}
@@ -3316,9 +3520,26 @@ func (r *Reader) readBlock(
 
 	typ := blockType(b[bh.Length])
 	b = b[:bh.Length]
+	if typ&encryptedBlockTypeMask != 0 {
+		typ &^= encryptedBlockTypeMask
+		if r.cipher == nil {
+			r.opts.Cache.Free(v)
+			return cache.Handle{}, base.CorruptionErrorf(
+				"pebble/table: invalid table %s (encrypted block at %d in unencrypted table)",
+				r.fileNum, errors.Safe(bh.Offset))
+		}
+		plaintext, err := r.cipher.decrypt(b)
+		if err != nil {
+			r.opts.Cache.Free(v)
+			return cache.Handle{}, err
+		}
+		// Shift the plaintext to the start of the buffer, so that the buffer
+		// can be cached directly if the block is not compressed.
+		b = b[:copy(b, plaintext)]
+	}
 	v.Truncate(len(b))
 
-	decoded, err := decompressBlock(r.opts.Cache, typ, b)
+	decoded, err := decompressBlock(r.opts.Cache, typ, b, r.zstdDict)
 	if decoded != nil {
 		r.opts.Cache.Free(v)
 		v = decoded
@@ -3347,8 +3568,16 @@ func (r *Reader) readBlock(
 		stats.BlockBytes += bh.Length
 	}
 
-	h := r.opts.Cache.Set(r.cacheID, r.fileNum, bh.Offset, v)
-	return h, nil
+	switch cacheMode {
+	case CacheModeNoFill:
+		return cache.UncachedHandle(v), nil
+	case CacheModeFillLowPriority:
+		return blockCache.SetWithPriority(r.cacheID, r.fileNum, bh.Offset, v, cache.LowPriority), nil
+	case CacheModePin:
+		return blockCache.SetWithPriority(r.cacheID, r.fileNum, bh.Offset, v, cache.HighPriority), nil
+	default:
+		return blockCache.Set(r.cacheID, r.fileNum, bh.Offset, v), nil
+	}
 }
 
 func (r *Reader) transformRangeDelV1(b []byte) ([]byte, error) {
@@ -3394,6 +3623,24 @@ func (r *Reader) transformRangeDelV1(b []byte) ([]byte, error) {
 	return rangeDelBlock.finish(), nil
 }
 
+// initEncryption retrieves the data key of an encrypted table.
+func (r *Reader) initEncryption() error {
+	if r.opts.EncryptionKeyManager == nil {
+		return errors.Errorf("pebble/table: table %s is encrypted, but no EncryptionKeyManager is configured",
+			r.fileNum)
+	}
+	if r.Properties.EncryptionKeyID == "" {
+		return base.CorruptionErrorf("pebble/table: encrypted table %s is missing its data key identifier",
+			r.fileNum)
+	}
+	key, err := r.opts.EncryptionKeyManager.DataKey(r.Properties.EncryptionKeyID)
+	if err != nil {
+		return errors.Wrapf(err, "pebble/table: retrieving data key %q", r.Properties.EncryptionKeyID)
+	}
+	r.cipher, err = newBlockCipher(key)
+	return err
+}
+
 func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 	b, err := r.readBlock(
 		context.Background(), metaindexBH, nil /* transform */, nil /* readHandle */, nil /* stats */)
@@ -3449,6 +3696,24 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 		if err != nil {
 			return err
 		}
+		if r.features&TableFeatureEncryption != 0 {
+			if err := r.initEncryption(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The dictionary is loaded after the properties, which identify the key
+	// which the dictionary block may be encrypted with.
+	if bh, ok := meta[metaZstdDictName]; ok {
+		b, err = r.readBlock(
+			context.Background(), bh, nil /* transform */, nil /* readHandle */, nil /* stats */)
+		if err != nil {
+			return err
+		}
+		r.zstdDictBH = bh
+		r.zstdDict = newZstdDict(append([]byte(nil), b.Get()...))
+		b.Release()
 	}
 
 	if bh, ok := meta[metaRangeDelV2Name]; ok {
@@ -3507,6 +3772,7 @@ func (r *Reader) Layout() (*Layout, error) {
 		RangeKey:   r.rangeKeyBH,
 		ValueIndex: r.valueBIH.h,
 		Properties: r.propertiesBH,
+		ZstdDict:   r.zstdDictBH,
 		MetaIndex:  r.metaIndexBH,
 		Footer:     r.footerBH,
 		Format:     r.tableFormat,
@@ -3605,6 +3871,14 @@ func (r *Reader) Layout() (*Layout, error) {
 
 // ValidateBlockChecksums validates the checksums for each block in the SSTable.
 func (r *Reader) ValidateBlockChecksums() error {
+	return r.ValidateSampledBlockChecksums(1)
+}
+
+// ValidateSampledBlockChecksums is like ValidateBlockChecksums, but only
+// validates a random sample of the table's data blocks, each of which is
+// validated with probability sampleRate. The index, filter and meta blocks
+// are always validated. A sampleRate of 1 or more validates every block.
+func (r *Reader) ValidateSampledBlockChecksums(sampleRate float64) error {
 	// Pre-compute the BlockHandles for the underlying file.
 	l, err := r.Layout()
 	if err != nil {
@@ -3613,12 +3887,14 @@ func (r *Reader) ValidateBlockChecksums() error {
 
 	// Construct the set of blocks to check. Note that the footer is not checked
 	// as it is not a block with a checksum.
-	blocks := make([]BlockHandle, len(l.Data))
+	blocks := make([]BlockHandle, 0, len(l.Data))
 	for i := range l.Data {
-		blocks[i] = l.Data[i].BlockHandle
+		if sampleRate >= 1 || rand.Float64() < sampleRate {
+			blocks = append(blocks, l.Data[i].BlockHandle)
+		}
 	}
 	blocks = append(blocks, l.Index...)
-	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
+	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.ZstdDict, l.MetaIndex)
 
 	// Sorting by offset ensures we are performing a sequential scan of the
 	// file.
@@ -3667,6 +3943,10 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 	if r.err != nil {
 		return 0, r.err
 	}
+	if p := r.prefixReplacement; p != nil {
+		start = p.ReplaceArg(nil, start)
+		end = p.ReplaceArg(nil, end)
+	}
 
 	indexH, err := r.readIndex(context.Background(), nil)
 	if err != nil {
@@ -3788,6 +4068,11 @@ func (r *Reader) TableFormat() (TableFormat, error) {
 	return r.tableFormat, nil
 }
 
+// Features returns the features declared by the table (see TableFeatures).
+func (r *Reader) Features() TableFeatures {
+	return r.features
+}
+
 // NewReader returns a new table reader for the file. Closing the reader will
 // close the file.
 func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption) (*Reader, error) {
@@ -3796,6 +4081,9 @@ func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption
 		readable: f,
 		opts:     o,
 	}
+	if o.FilterVerification != nil {
+		r.verification = &readerVerification{}
+	}
 	if r.opts.Cache == nil {
 		r.opts.Cache = cache.New(0)
 	} else {
@@ -3827,6 +4115,7 @@ func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption
 	}
 	r.checksumType = footer.checksum
 	r.tableFormat = footer.format
+	r.features = footer.features
 	// Read the metaindex.
 	if err := r.readMetaindex(footer.metaindexBH); err != nil {
 		r.err = err
@@ -3864,6 +4153,10 @@ func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption
 				errors.Safe(r.fileNum), errors.Safe(r.Properties.MergerName))
 		}
 	}
+	if windowLog := r.Properties.ZstdWindowLog; windowLog > MaxZstdWindowLog {
+		r.err = errors.Errorf("pebble/table: %d: unsupported zstd window log %d exceeding %d",
+			errors.Safe(r.fileNum), errors.Safe(windowLog), errors.Safe(MaxZstdWindowLog))
+	}
 	if r.err != nil {
 		return nil, r.Close()
 	}
@@ -3885,6 +4178,7 @@ type Layout struct {
 	ValueBlock []BlockHandle
 	ValueIndex BlockHandle
 	Properties BlockHandle
+	ZstdDict   BlockHandle
 	MetaIndex  BlockHandle
 	Footer     BlockHandle
 	Format     TableFormat
@@ -3929,6 +4223,9 @@ func (l *Layout) Describe(
 	if l.Properties.Length != 0 {
 		blocks = append(blocks, block{l.Properties, "properties"})
 	}
+	if l.ZstdDict.Length != 0 {
+		blocks = append(blocks, block{l.ZstdDict, "zstd-dict"})
+	}
 	if l.MetaIndex.Length != 0 {
 		blocks = append(blocks, block{l.MetaIndex, "meta-index"})
 	}
@@ -3951,7 +4248,7 @@ func (l *Layout) Describe(
 		if !verbose {
 			continue
 		}
-		if b.name == "filter" {
+		if b.name == "filter" || b.name == "zstd-dict" {
 			continue
 		}
 
@@ -4073,6 +4370,13 @@ func (l *Layout) Describe(
 						v := value.InPlaceValue()
 						if base.TrailerKind(key.Trailer) != InternalKeyKindSet {
 							fmtRecord(key, v)
+						} else if isBlobHandle(valuePrefix(v[0])) {
+							h, err := blob.DecodeHandle(v[1:])
+							if err != nil {
+								fmtRecord(key, []byte(err.Error()))
+							} else {
+								fmtRecord(key, []byte(fmt.Sprintf("blob handle %+v", h)))
+							}
 						} else if !isValueHandle(valuePrefix(v[0])) {
 							fmtRecord(key, v[1:])
 						} else {
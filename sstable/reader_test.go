@@ -1272,6 +1272,15 @@ func TestValidateBlockChecksums(t *testing.T) {
 		err = r.ValidateBlockChecksums()
 		require.Error(t, err)
 		require.Regexp(t, `checksum mismatch`, err.Error())
+
+		// Sampled validation with a zero sample rate skips the data blocks,
+		// and only detects the corruption of the other blocks.
+		err = r.ValidateSampledBlockChecksums(0)
+		if len(corruptionLocations) == 1 && corruptionLocations[0] == corruptionLocationData {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+		}
 	}
 
 	for _, tc := range testCases {
@@ -0,0 +1,36 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"context"
+
+	"github.com/cockroachdb/pebble/internal/cache"
+)
+
+type scanCacheKey struct{}
+
+// WithScanCache returns a context that directs the blocks read by the
+// iterators constructed with it to the given cache, rather than the block
+// cache of the Reader (ReaderOptions.Cache). Blocks that are already present
+// in the block cache are still served from it, but the blocks read from
+// storage are added to the scan cache. This allows large scans that are
+// unlikely to read the same blocks again, such as exports, to avoid evicting
+// the working set of the block cache.
+//
+// The scan cache is keyed the same way as the block cache, so it must only be
+// used with Readers that share the block cache and cache ID.
+func WithScanCache(ctx context.Context, c *cache.Cache) context.Context {
+	return context.WithValue(ctx, scanCacheKey{}, c)
+}
+
+// scanCacheFromContext returns the scan cache set by WithScanCache, or nil.
+func scanCacheFromContext(ctx context.Context) *cache.Cache {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(scanCacheKey{}).(*cache.Cache)
+	return c
+}
@@ -82,6 +82,16 @@ func rewriteKeySuffixesInBlocks(
 		return nil, TableFormatUnspecified,
 			errors.New("sstable with a single suffix should not have value blocks")
 	}
+	if r.Properties.NumBlobValues > 0 {
+		return nil, TableFormatUnspecified,
+			errors.New("rewriting the suffixes of sstables with blob values is not supported")
+	}
+	// Blocks are copied and rewritten individually, without decrypting or
+	// re-encrypting them.
+	if r.cipher != nil || o.EncryptionKeyManager != nil {
+		return nil, TableFormatUnspecified,
+			errors.New("rewriting the suffixes of encrypted sstables is not supported")
+	}
 
 	tableFormat := r.tableFormat
 	o.TableFormat = tableFormat
@@ -152,6 +162,8 @@ func rewriteBlocks(
 	restartInterval int,
 	checksumType ChecksumType,
 	compression Compression,
+	zstd ZstdOptions,
+	zstdDict *zstdDict,
 	input []BlockHandleWithProperties,
 	output []blockWithSpan,
 	totalWorkers, worker int,
@@ -161,7 +173,7 @@ func rewriteBlocks(
 	bw := blockWriter{
 		restartInterval: restartInterval,
 	}
-	buf := blockBuf{checksummer: checksummer{checksumType: checksumType}}
+	buf := blockBuf{checksummer: checksummer{checksumType: checksumType}, zstd: zstd, zstdDict: zstdDict}
 	if checksumType == ChecksumTypeXXHash {
 		buf.checksummer.xxHasher = xxhash.New()
 	}
@@ -229,7 +241,7 @@ func rewriteBlocks(
 					return errors.Errorf("value has no prefix")
 				}
 				prefix := valuePrefix(v[0])
-				if isValueHandle(prefix) {
+				if isValueHandle(prefix) || isBlobHandle(prefix) {
 					return errors.Errorf("value prefix is incorrect")
 				}
 				if setHasSamePrefix(prefix) {
@@ -290,6 +302,8 @@ func rewriteDataBlocksToWriter(
 				w.dataBlockBuf.dataBlock.restartInterval,
 				w.blockBuf.checksummer.checksumType,
 				w.compression,
+				w.zstd,
+				w.zstdDict,
 				data,
 				blocks,
 				concurrency,
@@ -511,7 +525,7 @@ func readBlockBuf(r *Reader, bh BlockHandle, buf []byte) ([]byte, []byte, error)
 	if cap(buf) < decompressedLen {
 		buf = make([]byte, decompressedLen)
 	}
-	res, err := decompressInto(typ, raw[prefix:], buf[:decompressedLen])
+	res, err := decompressInto(typ, raw[prefix:], buf[:decompressedLen], r.zstdDict)
 	return res, buf, err
 }
 
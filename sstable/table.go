@@ -192,6 +192,7 @@ const (
 
 	metaRangeKeyName   = "pebble.range_key"
 	metaValueIndexName = "pebble.value_index"
+	metaZstdDictName   = "pebble.zstd_dict"
 	metaPropertiesName = "rocksdb.properties"
 	metaRangeDelName   = "rocksdb.range_del"
 	metaRangeDelV2Name = "rocksdb.range_del2"
@@ -256,10 +257,17 @@ const (
 	lz4hcCompressionBlockType  blockType = 5
 	xpressCompressionBlockType blockType = 6
 	zstdCompressionBlockType   blockType = 7
+	// zstdDictCompressionBlockType is a zstd-compressed block which depends on
+	// the dictionary of the table (see ZstdOptions.Dictionary). It's encoded
+	// like zstdCompressionBlockType.
+	zstdDictCompressionBlockType blockType = 8
 )
 
 // String implements fmt.Stringer.
 func (t blockType) String() string {
+	if t&encryptedBlockTypeMask != 0 {
+		return (t &^ encryptedBlockTypeMask).String() + "+encrypted"
+	}
 	switch t {
 	case 0:
 		return "none"
@@ -277,6 +285,8 @@ func (t blockType) String() string {
 		return "xpress"
 	case 7:
 		return "zstd"
+	case 8:
+		return "zstd+dict"
 	default:
 		panic(errors.Newf("sstable: unknown block type: %d", t))
 	}
@@ -303,6 +313,9 @@ type footer struct {
 	metaindexBH BlockHandle
 	indexBH     BlockHandle
 	footerBH    BlockHandle
+	// features are the features declared in the footer version of tables
+	// with the Pebble magic number (see TableFeatures).
+	features TableFeatures
 }
 
 func readFooter(f objstorage.Readable) (footer, error) {
@@ -345,11 +358,12 @@ func readFooter(f objstorage.Readable) (footer, error) {
 		footer.footerBH.Length = uint64(len(buf))
 		version := binary.LittleEndian.Uint32(buf[rocksDBVersionOffset:rocksDBMagicOffset])
 
-		format, err := ParseTableFormat(magic, version)
+		format, features, err := parseTableFormat(magic, version)
 		if err != nil {
 			return footer, err
 		}
 		footer.format = format
+		footer.features = features
 
 		switch ChecksumType(buf[0]) {
 		case ChecksumTypeCRC32c:
@@ -414,6 +428,9 @@ func (f footer) encode(buf []byte) []byte {
 		n := 1
 		n += encodeBlockHandle(buf[n:], f.metaindexBH)
 		encodeBlockHandle(buf[n:], f.indexBH)
+		if magic == pebbleDBMagic {
+			version |= uint32(f.features) << pebbleFeaturesShift
+		}
 		binary.LittleEndian.PutUint32(buf[rocksDBVersionOffset:], version)
 		copy(buf[len(buf)-len(rocksDBMagic):], magic)
 
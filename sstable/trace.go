@@ -0,0 +1,83 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// Span names and attribute keys of the spans started by Readers (see
+// ReaderOptions.Tracer).
+const (
+	// ReadBlockSpan is the name of the span of a block read from storage. The
+	// blocks found in the block cache aren't traced.
+	ReadBlockSpan = "pebble.sstable.read_block"
+	// FileNumAttribute is the file number of the sstable read.
+	FileNumAttribute = "pebble.file_num"
+	// LevelAttribute is the LSM level of the sstable read, if known (see
+	// WithTraceLevel).
+	LevelAttribute = "pebble.level"
+	// BlockOffsetAttribute and BlockLengthAttribute are the offset and the
+	// length of the block read, excluding its trailer.
+	BlockOffsetAttribute = "pebble.block.offset"
+	BlockLengthAttribute = "pebble.block.length"
+)
+
+type traceLevelKey struct{}
+
+// WithTraceLevel returns a context that annotates the spans of the blocks read
+// by the iterators constructed with it with the given LSM level. It should
+// only be used if tracing is enabled for the context, to avoid its allocation
+// otherwise.
+func WithTraceLevel(ctx context.Context, level int) context.Context {
+	return context.WithValue(ctx, traceLevelKey{}, level)
+}
+
+// traceLevelFromContext returns the level set by WithTraceLevel, if any.
+func traceLevelFromContext(ctx context.Context) (int, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	level, ok := ctx.Value(traceLevelKey{}).(int)
+	return level, ok
+}
+
+// BlockRead describes a block read by an iterator, from the block cache or
+// from storage (see WithBlockReadObserver).
+type BlockRead struct {
+	// FileNum is the file number of the sstable read.
+	FileNum base.FileNum
+	// Offset and Length are the offset and the length of the block, excluding
+	// its trailer.
+	Offset, Length uint64
+	// CacheHit is true if the block was found in the block cache (or the scan
+	// cache, see WithScanCache).
+	CacheHit bool
+	// Duration is the time spent reading, verifying and decompressing the
+	// block. It's zero for cache hits.
+	Duration time.Duration
+}
+
+type blockReadObserverKey struct{}
+
+// WithBlockReadObserver returns a context that makes the iterators
+// constructed with it call fn with every block they read. The calls are made
+// synchronously by the goroutine using the iterator.
+func WithBlockReadObserver(ctx context.Context, fn func(BlockRead)) context.Context {
+	return context.WithValue(ctx, blockReadObserverKey{}, fn)
+}
+
+// blockReadObserverFromContext returns the function set by
+// WithBlockReadObserver, if any.
+func blockReadObserverFromContext(ctx context.Context) func(BlockRead) {
+	if ctx == nil {
+		return nil
+	}
+	fn, _ := ctx.Value(blockReadObserverKey{}).(func(BlockRead))
+	return fn
+}
@@ -198,6 +198,9 @@ const (
 	valueKindMask           valuePrefix = '\xC0'
 	valueKindIsValueHandle  valuePrefix = '\x80'
 	valueKindIsInPlaceValue valuePrefix = '\x00'
+	// valueKindIsBlobHandle indicates the value is stored in a blob file, and
+	// is followed by an encoded blob.Handle. See blob_value.go.
+	valueKindIsBlobHandle valuePrefix = '\x40'
 
 	// 1 bit indicates SET has same key prefix as immediately preceding key that
 	// is also a SET. If the immediately preceding key in the same block is a
@@ -391,8 +394,14 @@ type valueBlockWriter struct {
 	blockSize, blockSizeThreshold int
 	// Configured compression.
 	compression Compression
+	// zstd and zstdDict configure ZstdCompression.
+	zstd     ZstdOptions
+	zstdDict *zstdDict
 	// checksummer with configured checksum type.
 	checksummer checksummer
+	// cipher, if non-nil, is used to encrypt value blocks. The value blocks
+	// index is not encrypted, as it contains only block handles.
+	cipher *blockCipher
 	// Block finished callback.
 	blockFinishedFunc func(compressedSize int)
 
@@ -556,13 +565,18 @@ func (w *valueBlockWriter) compressAndFlush() {
 	b := w.buf
 	if w.compression != NoCompression {
 		blockType, w.compressedBuf.b =
-			compressBlock(w.compression, w.buf.b, w.compressedBuf.b[:cap(w.compressedBuf.b)])
+			compressBlock(w.compression, w.zstd, w.zstdDict, w.buf.b, w.compressedBuf.b[:cap(w.compressedBuf.b)])
 		if len(w.compressedBuf.b) < len(w.buf.b)-len(w.buf.b)/8 {
 			b = w.compressedBuf
 		} else {
 			blockType = noCompressionBlockType
 		}
 	}
+	compressed := blockType != noCompressionBlockType
+	if w.cipher != nil {
+		b.b = w.cipher.encrypt(make([]byte, 0, blockNonceLen+len(b.b)+blockTrailerLen), b.b)
+		blockType |= encryptedBlockTypeMask
+	}
 	n := len(b.b)
 	if n+blockTrailerLen > cap(b.b) {
 		block := make([]byte, n+blockTrailerLen)
@@ -577,7 +591,6 @@ func (w *valueBlockWriter) compressAndFlush() {
 	w.totalBlockBytes += uint64(len(b.b))
 	// blockFinishedFunc length excludes the block trailer.
 	w.blockFinishedFunc(n)
-	compressed := blockType != noCompressionBlockType
 	w.blocks = append(w.blocks, blockAndHandle{
 		block:      b,
 		handle:     bh,
@@ -0,0 +1,188 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
+)
+
+// Value checksums
+//
+// Block checksums are verified when a block is read from storage, and so
+// only detect corruption of the stored block. A block that is corrupted in
+// memory after being read, eg, while it resides in the block cache, is
+// returned to readers undetected. Tables written with
+// WriterOptions.ValueChecksums append a checksum to each value of a SET,
+// SETWITHDEL and MERGE key, which is verified and stripped by the iterators
+// of the table when the value is returned, providing end-to-end integrity of
+// the values between the writer and the reader of the table. The checksum is
+// stored with the value regardless of where the value is stored, in-place or
+// in a value block.
+//
+// The checksum is a 4-byte CRC-32C of the value, encoded in little-endian
+// order. Tables written with value checksums declare
+// TableFeatureValueChecksums in their footer, which versions of Pebble that
+// predate value checksums fail to parse, and record them in
+// Properties.ValueChecksums.
+
+// valueChecksumLen is the length of the checksum appended to each value.
+const valueChecksumLen = 4
+
+// hasValueChecksum returns true if the values of keys of the given kind carry
+// a checksum in tables written with value checksums. The values of the other
+// kinds are either empty or interpreted by Pebble.
+func hasValueChecksum(kind InternalKeyKind) bool {
+	switch kind {
+	case InternalKeyKindSet, base.InternalKeyKindSetWithDelete, InternalKeyKindMerge:
+		return true
+	}
+	return false
+}
+
+// appendValueChecksum appends the value, followed by its checksum, to dst.
+func appendValueChecksum(dst, value []byte) []byte {
+	dst = append(dst, value...)
+	return binary.LittleEndian.AppendUint32(dst, crc.New(value).Value())
+}
+
+// verifyValueChecksum verifies the checksum of the given value, returning the
+// value stripped of its checksum.
+func verifyValueChecksum(v []byte) ([]byte, error) {
+	if len(v) < valueChecksumLen {
+		return nil, base.CorruptionErrorf("pebble/table: value of length %d is missing its checksum", len(v))
+	}
+	n := len(v) - valueChecksumLen
+	if binary.LittleEndian.Uint32(v[n:]) != crc.New(v[:n]).Value() {
+		return nil, base.CorruptionErrorf("pebble/table: value checksum mismatch")
+	}
+	return v[:n], nil
+}
+
+// valueChecksumIter wraps the iterator of a table written with value
+// checksums, verifying and stripping the checksums of the values it returns.
+// Values stored in value blocks are verified when they are fetched.
+type valueChecksumIter struct {
+	Iterator
+	reader  *Reader
+	fetcher base.LazyFetcher
+	err     error
+}
+
+var _ Iterator = (*valueChecksumIter)(nil)
+
+func (i *valueChecksumIter) verify(
+	key *InternalKey, value base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	i.err = nil
+	if key == nil || !hasValueChecksum(key.Kind()) {
+		return key, value
+	}
+	if value.Fetcher != nil {
+		if value.Fetcher.Fetcher == i.reader.blobValueFetcher() {
+			// Blob values are checksummed in the blob file.
+			return key, value
+		}
+		i.fetcher = base.LazyFetcher{
+			Fetcher:   valueChecksumFetcher{ValueFetcher: value.Fetcher.Fetcher},
+			Attribute: value.Fetcher.Attribute,
+		}
+		i.fetcher.Attribute.ValueLen -= valueChecksumLen
+		value.Fetcher = &i.fetcher
+		return key, value
+	}
+	v, err := verifyValueChecksum(value.ValueOrHandle)
+	if err != nil {
+		i.err = errors.Wrapf(err, "table %s, key %s", i.reader.fileNum, key.Pretty(i.reader.FormatKey))
+		return nil, base.LazyValue{}
+	}
+	return key, base.MakeInPlaceValue(v)
+}
+
+// SeekGE implements base.InternalIterator.
+func (i *valueChecksumIter) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	return i.verify(i.Iterator.SeekGE(key, flags))
+}
+
+// SeekPrefixGE implements base.InternalIterator.
+func (i *valueChecksumIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	return i.verify(i.Iterator.SeekPrefixGE(prefix, key, flags))
+}
+
+// SeekLT implements base.InternalIterator.
+func (i *valueChecksumIter) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*InternalKey, base.LazyValue) {
+	return i.verify(i.Iterator.SeekLT(key, flags))
+}
+
+// First implements base.InternalIterator.
+func (i *valueChecksumIter) First() (*InternalKey, base.LazyValue) {
+	return i.verify(i.Iterator.First())
+}
+
+// Last implements base.InternalIterator.
+func (i *valueChecksumIter) Last() (*InternalKey, base.LazyValue) {
+	return i.verify(i.Iterator.Last())
+}
+
+// Next implements base.InternalIterator.
+func (i *valueChecksumIter) Next() (*InternalKey, base.LazyValue) {
+	if i.err != nil {
+		return nil, base.LazyValue{}
+	}
+	return i.verify(i.Iterator.Next())
+}
+
+// NextPrefix implements base.InternalIterator.
+func (i *valueChecksumIter) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	if i.err != nil {
+		return nil, base.LazyValue{}
+	}
+	return i.verify(i.Iterator.NextPrefix(succKey))
+}
+
+// Prev implements base.InternalIterator.
+func (i *valueChecksumIter) Prev() (*InternalKey, base.LazyValue) {
+	if i.err != nil {
+		return nil, base.LazyValue{}
+	}
+	return i.verify(i.Iterator.Prev())
+}
+
+// Error implements base.InternalIterator.
+func (i *valueChecksumIter) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.Iterator.Error()
+}
+
+// valueChecksumFetcher wraps the ValueFetcher of the values stored in value
+// blocks, verifying and stripping their checksums.
+type valueChecksumFetcher struct {
+	base.ValueFetcher
+}
+
+// Fetch implements base.ValueFetcher.
+func (f valueChecksumFetcher) Fetch(
+	handle []byte, valLen int32, buf []byte,
+) (val []byte, callerOwned bool, err error) {
+	val, callerOwned, err = f.ValueFetcher.Fetch(handle, valLen+valueChecksumLen, buf)
+	if err != nil {
+		return nil, false, err
+	}
+	if val, err = verifyValueChecksum(val); err != nil {
+		return nil, false, err
+	}
+	return val, callerOwned, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueChecksums(t *testing.T) {
+	fs := vfs.NewMem()
+	write := func(name string, valueChecksums bool) {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+			BlockSize:      64,
+			Comparer:       testkeys.Comparer,
+			TableFormat:    TableFormatPebblev3,
+			ValueChecksums: valueChecksums,
+		})
+		for i := 0; i < 20; i++ {
+			// Write two versions of each key, so that the older version is
+			// written to a value block.
+			for _, ts := range []int{2, 1} {
+				k := []byte(fmt.Sprintf("k%02d@%d", i, ts))
+				require.NoError(t, w.Set(k, []byte(fmt.Sprintf("value-%02d-%d", i, ts))))
+			}
+		}
+		require.NoError(t, w.Delete([]byte("z")))
+		require.NoError(t, w.Close())
+	}
+	open := func(name string) *Reader {
+		f, err := fs.Open(name)
+		require.NoError(t, err)
+		readable, err := NewSimpleReadable(f)
+		require.NoError(t, err)
+		r, err := NewReader(readable, ReaderOptions{Comparer: testkeys.Comparer})
+		require.NoError(t, err)
+		return r
+	}
+	write("checksums", true)
+	write("plain", false)
+
+	// The checksums are verified and stripped, whether the values are stored
+	// in-place or in value blocks.
+	r := open("checksums")
+	require.True(t, r.Properties.ValueChecksums)
+	require.Equal(t, TableFeaturesDeclared|TableFeatureValueChecksums, r.Features())
+	require.Less(t, uint64(0), r.Properties.NumValuesInValueBlocks)
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	var count int
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if k.Kind() == InternalKeyKindDelete {
+			require.Equal(t, 0, v.Len())
+			continue
+		}
+		i, ts := count/2, 2-count%2
+		require.Equal(t, fmt.Sprintf("k%02d@%d", i, ts), string(k.UserKey))
+		require.Equal(t, len("value-00-0"), v.Len())
+		val, _, err := v.Value(nil)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("value-%02d-%d", i, ts), string(val))
+		count++
+	}
+	require.Equal(t, 40, count)
+	require.NoError(t, iter.Close())
+	require.NoError(t, r.Close())
+
+	// Reading values that don't match their checksums fails. Simulate this
+	// by reading a table written without checksums as though it had them.
+	r = open("plain")
+	require.Zero(t, r.Features())
+	r.features |= TableFeatureValueChecksums
+	iter, err = r.NewIter(nil, nil)
+	require.NoError(t, err)
+	k, _ := iter.First()
+	require.Nil(t, k)
+	require.True(t, errors.Is(iter.Error(), base.ErrCorruption))
+	require.Contains(t, iter.Error().Error(), "value checksum mismatch")
+
+	// The value of k00@1 is stored in a value block, and is verified when it's
+	// fetched.
+	k, v := iter.SeekGE([]byte("k00@1"), base.SeekGEFlagsNone)
+	require.NotNil(t, k)
+	require.NotNil(t, v.Fetcher)
+	_, _, err = v.Value(nil)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	require.NoError(t, iter.Close())
+	require.NoError(t, r.Close())
+
+	// Value checksums are declared in the footer, which the RocksDB format
+	// can't do.
+	f, err := fs.Create("rocksdb")
+	require.NoError(t, err)
+	w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+		TableFormat:    TableFormatRocksDBv2,
+		ValueChecksums: true,
+	})
+	require.Error(t, w.Close())
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+// ValueSizeBlockPropertyName is the name of the block property collected by
+// the collector returned by NewValueSizeBlockPropertyCollector.
+const ValueSizeBlockPropertyName = "pebble.value-size"
+
+// NewValueSizeBlockPropertyCollector returns a BlockPropertyCollector that
+// records the interval of the sizes of the point key values of each block, ie,
+// the smallest and largest value sizes. Tombstones are recorded as values of
+// size zero. Range keys are ignored.
+//
+// The property allows scans that only need small values, eg, metadata records
+// stored in a key space interleaved with large blobs, to skip the blocks whose
+// values are all large, using a filter returned by
+// NewValueSizeBlockPropertyFilter.
+func NewValueSizeBlockPropertyCollector() BlockPropertyCollector {
+	c := &valueSizeBlockCollector{}
+	c.BlockIntervalCollector = BlockIntervalCollector{
+		name:   ValueSizeBlockPropertyName,
+		points: &c.sizes,
+	}
+	return c
+}
+
+// NewValueSizeBlockPropertyFilter returns a BlockPropertyFilter that excludes
+// the blocks whose values are all larger than maxValueSize, as recorded by the
+// collector returned by NewValueSizeBlockPropertyCollector.
+//
+// Like other block property filters, the filter may hide keys, and it may
+// expose shadowed keys: the iterator still returns the large values of the
+// blocks that also contain small values, and a key whose newest value is large
+// may expose an older, small value, or the key may be hidden entirely. The
+// filter is thus only suitable for key spaces whose keys' values are all
+// either small or large.
+func NewValueSizeBlockPropertyFilter(maxValueSize uint64) *BlockIntervalFilter {
+	return NewBlockIntervalFilter(ValueSizeBlockPropertyName, 0, maxValueSize+1)
+}
+
+// valueLenBlockPropertyCollector is implemented by the block property
+// collectors that only need the lengths of values. The Writer provides the
+// lengths of the values that aren't required to be in-place, whose contents
+// aren't provided to block property collectors.
+type valueLenBlockPropertyCollector interface {
+	addValueLen(key InternalKey, valueLen int) error
+}
+
+type valueSizeBlockCollector struct {
+	BlockIntervalCollector
+	sizes valueSizeIntervalCollector
+}
+
+var _ valueLenBlockPropertyCollector = (*valueSizeBlockCollector)(nil)
+
+func (c *valueSizeBlockCollector) addValueLen(key InternalKey, valueLen int) error {
+	c.sizes.add(uint64(valueLen))
+	return nil
+}
+
+// valueSizeIntervalCollector is a DataBlockIntervalCollector that collects the
+// interval of the value sizes of a data block.
+type valueSizeIntervalCollector struct {
+	lower, upper uint64
+}
+
+var _ DataBlockIntervalCollector = (*valueSizeIntervalCollector)(nil)
+
+func (c *valueSizeIntervalCollector) add(size uint64) {
+	if c.lower >= c.upper {
+		c.lower, c.upper = size, size+1
+		return
+	}
+	if size < c.lower {
+		c.lower = size
+	}
+	if size >= c.upper {
+		c.upper = size + 1
+	}
+}
+
+// Add implements DataBlockIntervalCollector.
+func (c *valueSizeIntervalCollector) Add(key InternalKey, value []byte) error {
+	c.add(uint64(len(value)))
+	return nil
+}
+
+// FinishDataBlock implements DataBlockIntervalCollector.
+func (c *valueSizeIntervalCollector) FinishDataBlock() (lower, upper uint64, err error) {
+	lower, upper = c.lower, c.upper
+	c.lower, c.upper = 0, 0
+	return lower, upper, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSizeIntervalCollector(t *testing.T) {
+	var c valueSizeIntervalCollector
+	lower, upper, err := c.FinishDataBlock()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{0, 0}, [2]uint64{lower, upper})
+
+	for _, v := range []string{"abc", "", "abcdefgh", "ab"} {
+		require.NoError(t, c.Add(base.MakeInternalKey([]byte("k"), 1, InternalKeyKindSet), []byte(v)))
+	}
+	lower, upper, err = c.FinishDataBlock()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{0, 9}, [2]uint64{lower, upper})
+
+	c.add(100)
+	lower, upper, err = c.FinishDataBlock()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{100, 101}, [2]uint64{lower, upper})
+}
+
+func TestValueSizeBlockProperty(t *testing.T) {
+	for _, format := range []TableFormat{TableFormatPebblev2, TableFormatPebblev3} {
+		t.Run(format.String(), func(t *testing.T) {
+			fs := vfs.NewMem()
+			f, err := fs.Create("test")
+			require.NoError(t, err)
+			w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+				BlockSize:   64,
+				TableFormat: format,
+				BlockPropertyCollectors: []func() BlockPropertyCollector{
+					NewValueSizeBlockPropertyCollector,
+				},
+			})
+			// Interleave runs of keys with small values with runs of keys with
+			// large values, so that blocks contain either small or large values.
+			large := bytes.Repeat([]byte("x"), 256)
+			for i := 0; i < 4; i++ {
+				for j := 0; j < 4; j++ {
+					k := fmt.Sprintf("%d/a-meta/%d", i, j)
+					require.NoError(t, w.Set([]byte(k), []byte("m")))
+				}
+				for j := 0; j < 4; j++ {
+					k := fmt.Sprintf("%d/b-blob/%d", i, j)
+					require.NoError(t, w.Set([]byte(k), large))
+				}
+			}
+			require.NoError(t, w.Close())
+
+			f, err = fs.Open("test")
+			require.NoError(t, err)
+			readable, err := NewSimpleReadable(f)
+			require.NoError(t, err)
+			r, err := NewReader(readable, ReaderOptions{})
+			require.NoError(t, err)
+			defer r.Close()
+
+			scan := func(maxValueSize uint64) string {
+				filterer, err := IntersectsTable(
+					[]BlockPropertyFilter{NewValueSizeBlockPropertyFilter(maxValueSize)}, nil, r.Properties.UserProperties)
+				require.NoError(t, err)
+				if filterer == nil {
+					return ""
+				}
+				iter, err := r.NewIterWithBlockPropertyFilters(nil, nil, filterer, false, nil, TrivialReaderProvider{Reader: r})
+				require.NoError(t, err)
+				var small, big int
+				for k, v := iter.First(); k != nil; k, v = iter.Next() {
+					val, _, err := v.Value(nil)
+					require.NoError(t, err)
+					if len(val) > 16 {
+						big++
+					} else {
+						small++
+					}
+				}
+				require.NoError(t, iter.Close())
+				return fmt.Sprintf("small=%d large=%d", small, big)
+			}
+			// The blocks of large values are skipped.
+			got := scan(16)
+			require.True(t, strings.HasPrefix(got, "small=16 "), got)
+			require.NotEqual(t, "small=16 large=16", got)
+			// All the blocks are read when the limit exceeds all values.
+			require.Equal(t, "small=16 large=16", scan(1024))
+			// The table is skipped when no value is small enough.
+			require.Equal(t, "", scan(0))
+		})
+	}
+}
@@ -138,3 +138,62 @@ func (w *writeQueue) finish() error {
 	w.closed = true
 	return w.err
 }
+
+// compressionQueue compresses the data blocks of a Writer concurrently, using
+// a fixed number of worker goroutines. The writeQueue preserves the order in
+// which the blocks are written, as it waits for the compression of each block
+// before writing it. Only the Writer client goroutine adds tasks to the
+// compressionQueue.
+type compressionQueue struct {
+	tasks        chan *writeTask
+	wg           sync.WaitGroup
+	compression  Compression
+	sizeEstimate *dataBlockEstimates
+	closed       bool
+}
+
+func newCompressionQueue(
+	concurrency int, compression Compression, sizeEstimate *dataBlockEstimates,
+) *compressionQueue {
+	q := &compressionQueue{
+		tasks:        make(chan *writeTask, concurrency),
+		compression:  compression,
+		sizeEstimate: sizeEstimate,
+	}
+	q.wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go q.runWorker()
+	}
+	return q
+}
+
+func (q *compressionQueue) runWorker() {
+	for task := range q.tasks {
+		inflightSize := len(task.buf.uncompressed)
+		task.buf.compressAndChecksum(q.compression)
+		q.sizeEstimate.dataBlockCompressed(len(task.buf.compressed), inflightSize)
+		// The task may be released by the writeQueue once compressionDone is
+		// signaled, so it must not be accessed afterwards.
+		task.compressionDone <- true
+	}
+	q.wg.Done()
+}
+
+// add schedules the compression of the task's data block, which must be
+// finished. The task must be added to the writeQueue after it's added to the
+// compressionQueue.
+func (q *compressionQueue) add(task *writeTask) {
+	q.sizeEstimate.addInflightDataBlock(len(task.buf.uncompressed))
+	q.tasks <- task
+}
+
+// finish stops the workers of the compressionQueue. It should only be called
+// once no more tasks will be added to the compressionQueue.
+func (q *compressionQueue) finish() {
+	if q.closed {
+		return
+	}
+	close(q.tasks)
+	q.wg.Wait()
+	q.closed = true
+}
@@ -129,6 +129,9 @@ type Writer struct {
 	split                   Split
 	formatKey               base.FormatKey
 	compression             Compression
+	zstd                    ZstdOptions
+	zstdDict                *zstdDict
+	dictTrainer             *DictionaryTrainer
 	separator               Separator
 	successor               Successor
 	tableFormat             TableFormat
@@ -206,6 +209,19 @@ type Writer struct {
 	shortAttributeExtractor   base.ShortAttributeExtractor
 	requiredInPlaceValueBound UserKeyPrefixBound
 	valueBlockWriter          *valueBlockWriter
+
+	// cipher is non-nil if the table's blocks are encrypted.
+	cipher *blockCipher
+
+	// features are the features used by the table, which are declared in its
+	// footer (see TableFeatures).
+	features TableFeatures
+
+	// valueChecksums is set if a checksum is appended to each value (see
+	// WriterOptions.ValueChecksums). valueChecksumBuf holds the value of the
+	// current key followed by its checksum.
+	valueChecksums   bool
+	valueChecksumBuf []byte
 }
 
 type pointKeyInfo struct {
@@ -224,15 +240,21 @@ type coordinationState struct {
 	// used to maintain the order in which data blocks must be written to disk. For
 	// this reason, every single data block write must be done through the writeQueue.
 	writeQueue *writeQueue
+	// compressionQueue, if non-nil, is used to compress data blocks
+	// concurrently. See WriterOptions.CompressionConcurrency.
+	compressionQueue *compressionQueue
 
 	sizeEstimate dataBlockEstimates
 }
 
-func (c *coordinationState) init(parallelismEnabled bool, writer *Writer) {
+func (c *coordinationState) init(
+	parallelismEnabled bool, compressionConcurrency int, compression Compression, writer *Writer,
+) {
 	c.parallelismEnabled = parallelismEnabled
-	// useMutex is false regardless of parallelismEnabled, because we do not do
-	// parallel compression yet.
-	c.sizeEstimate.useMutex = false
+	// The size estimate is only updated concurrently with the Writer client
+	// goroutine if data blocks are compressed in parallel.
+	parallelCompression := parallelismEnabled && compressionConcurrency > 1
+	c.sizeEstimate.useMutex = parallelCompression
 
 	// writeQueueSize determines the size of the write queue, or the number
 	// of items which can be added to the queue without blocking. By default, we
@@ -243,6 +265,21 @@ func (c *coordinationState) init(parallelismEnabled bool, writer *Writer) {
 		writeQueueSize = runtime.GOMAXPROCS(0)
 	}
 	c.writeQueue = newWriteQueue(writeQueueSize, writer)
+	if parallelCompression {
+		c.compressionQueue = newCompressionQueue(compressionConcurrency, compression, &c.sizeEstimate)
+	}
+}
+
+// finish waits for the data blocks added to the queues to be written, and
+// returns any error encountered while writing them.
+func (c *coordinationState) finish() error {
+	err := c.writeQueue.finish()
+	if c.compressionQueue != nil {
+		// The writeQueue waits for the compression of the blocks it writes, so
+		// the compressionQueue is idle.
+		c.compressionQueue.finish()
+	}
+	return err
 }
 
 // sizeEstimate is a general purpose helper for estimating two kinds of sizes:
@@ -285,9 +322,9 @@ func (c *coordinationState) init(parallelismEnabled bool, writer *Writer) {
 // provide the actual delta size or the total size (latter must be
 // monotonically non-decreasing). If there were no calls to addInflight, there
 // isn't any real estimation happening here. So case A does not do any real
-// estimation. However, when we introduce parallel compression, there will be
-// estimation in that the client goroutine will call addInFlight and the
-// compression goroutines will call writtenWithDelta.
+// estimation, unless data blocks are compressed in parallel (see
+// WriterOptions.CompressionConcurrency), in which case the client goroutine
+// calls addInFlight and the compression goroutines call writtenWithDelta.
 type sizeEstimate struct {
 	// emptySize is the size when there is no inflight data, and numEntries is 0.
 	// emptySize is constant once set.
@@ -518,10 +555,9 @@ func (d *dataBlockEstimates) size() uint64 {
 	return d.estimate.size()
 }
 
-// Avoid linter unused error.
-var _ = (&dataBlockEstimates{}).addInflightDataBlock
-
-// NB: unused since no parallel compression.
+// addInflightDataBlock adds a data block of the given uncompressed size which
+// is being compressed in parallel. dataBlockCompressed must be called with the
+// same inflightSize once it's compressed.
 func (d *dataBlockEstimates) addInflightDataBlock(size int) {
 	if d.useMutex {
 		d.mu.Lock()
@@ -573,6 +609,15 @@ type blockBuf struct {
 	// lifetime of the blockBuf, avoiding the allocation of a temporary buffer for each block.
 	compressedBuf []byte
 	checksummer   checksummer
+	// cipher, if non-nil, is used to encrypt blocks after compression.
+	// encryptedBuf is the destination buffer for encryption, re-used like
+	// compressedBuf.
+	cipher       *blockCipher
+	encryptedBuf []byte
+	// zstd and zstdDict configure ZstdCompression. Like cipher, they survive
+	// clear.
+	zstd     ZstdOptions
+	zstdDict *zstdDict
 }
 
 func (b *blockBuf) clear() {
@@ -581,6 +626,8 @@ func (b *blockBuf) clear() {
 	// to make an allocation.
 	*b = blockBuf{
 		compressedBuf: b.compressedBuf, checksummer: b.checksummer,
+		cipher: b.cipher, encryptedBuf: b.encryptedBuf, zstd: b.zstd,
+		zstdDict: b.zstdDict,
 	}
 }
 
@@ -635,6 +682,10 @@ func newDataBlockBuf(restartInterval int, checksumType ChecksumType) *dataBlockB
 	d := dataBlockBufPool.Get().(*dataBlockBuf)
 	d.dataBlock.restartInterval = restartInterval
 	d.checksummer.checksumType = checksumType
+	// The cipher survives clear, so that it's retained across the blocks of
+	// a table, but a pooled buffer may have been used by another table.
+	d.cipher = nil
+	d.zstdDict = nil
 	return d
 }
 
@@ -837,6 +888,13 @@ func (w *Writer) makeAddPointDecisionV3(
 
 func (w *Writer) addPoint(key InternalKey, value []byte) error {
 	var err error
+	// storedValue is the value as stored in the table, which differs from
+	// value if it's followed by a checksum.
+	storedValue := value
+	if w.valueChecksums && hasValueChecksum(key.Kind()) {
+		w.valueChecksumBuf = appendValueChecksum(w.valueChecksumBuf[:0], value)
+		storedValue = w.valueChecksumBuf
+	}
 	var setHasSameKeyPrefix, writeToValueBlock, addPrefixToValueStoredWithKey bool
 	maxSharedKeyLen := len(key.UserKey)
 	if w.valueBlockWriter != nil {
@@ -844,7 +902,7 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 		// preceding key was in a different block, then the blockWriter will
 		// ignore this maxSharedKeyLen.
 		maxSharedKeyLen = w.lastPointKeyInfo.prefixLen
-		setHasSameKeyPrefix, writeToValueBlock, err = w.makeAddPointDecisionV3(key, len(value))
+		setHasSameKeyPrefix, writeToValueBlock, err = w.makeAddPointDecisionV3(key, len(storedValue))
 		addPrefixToValueStoredWithKey = base.TrailerKind(key.Trailer) == InternalKeyKindSet
 	} else {
 		err = w.makeAddPointDecisionV2(key)
@@ -856,7 +914,7 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 	var prefix valuePrefix
 	var valueStoredWithKeyLen int
 	if writeToValueBlock {
-		vh, err := w.valueBlockWriter.addValue(value)
+		vh, err := w.valueBlockWriter.addValue(storedValue)
 		if err != nil {
 			return err
 		}
@@ -876,14 +934,35 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 		}
 		prefix = makePrefixForValueHandle(setHasSameKeyPrefix, attribute)
 	} else {
-		valueStoredWithKey = value
-		valueStoredWithKeyLen = len(value)
+		valueStoredWithKey = storedValue
+		valueStoredWithKeyLen = len(storedValue)
 		if addPrefixToValueStoredWithKey {
 			valueStoredWithKeyLen++
 		}
 		prefix = makePrefixForInPlaceValue(setHasSameKeyPrefix)
 	}
 
+	return w.addPointEntry(
+		key, value, len(value), valueStoredWithKey, valueStoredWithKeyLen, maxSharedKeyLen,
+		addPrefixToValueStoredWithKey, prefix, setHasSameKeyPrefix)
+}
+
+// addPointEntry adds a point key to the current data block, with the value
+// stored with the key, which is preceded by the given prefix if addPrefix is
+// true. value is the value passed to the property collectors, and valueLen is
+// the length of the value in the DB, which may differ from len(value) if the
+// value is stored elsewhere.
+func (w *Writer) addPointEntry(
+	key InternalKey,
+	value []byte,
+	valueLen int,
+	valueStoredWithKey []byte,
+	valueStoredWithKeyLen int,
+	maxSharedKeyLen int,
+	addPrefix bool,
+	prefix valuePrefix,
+	setHasSameKeyPrefix bool,
+) error {
 	if err := w.maybeFlush(key, valueStoredWithKeyLen); err != nil {
 		return err
 	}
@@ -896,7 +975,14 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 	}
 	for i := range w.blockPropCollectors {
 		v := value
-		if addPrefixToValueStoredWithKey {
+		if c, ok := w.blockPropCollectors[i].(valueLenBlockPropertyCollector); ok {
+			if err := c.addValueLen(key, valueLen); err != nil {
+				w.err = err
+				return err
+			}
+			continue
+		}
+		if addPrefix {
 			// Values for SET are not required to be in-place, and in the future may
 			// not even be read by the compaction, so pass nil values. Block
 			// property collectors in such Pebble DB's must not look at the value.
@@ -910,7 +996,7 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 
 	w.maybeAddToFilter(key.UserKey)
 	w.dataBlockBuf.dataBlock.addWithOptionalValuePrefix(
-		key, valueStoredWithKey, maxSharedKeyLen, addPrefixToValueStoredWithKey, prefix,
+		key, valueStoredWithKey, maxSharedKeyLen, addPrefix, prefix,
 		setHasSameKeyPrefix)
 
 	w.meta.updateSeqNum(key.SeqNum())
@@ -937,7 +1023,7 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 		w.props.NumMergeOperands++
 	}
 	w.props.RawKeySize += uint64(key.Size())
-	w.props.RawValueSize += uint64(len(value))
+	w.props.RawValueSize += uint64(valueLen)
 	return nil
 }
 
@@ -1284,10 +1370,15 @@ func (w *Writer) flush(key InternalKey) error {
 		return err
 	}
 	w.dataBlockBuf.finish()
-	w.dataBlockBuf.compressAndChecksum(w.compression)
-	// Since dataBlockEstimates.addInflightDataBlock was never called, the
-	// inflightSize is set to 0.
-	w.coordination.sizeEstimate.dataBlockCompressed(len(w.dataBlockBuf.compressed), 0)
+	if w.dictTrainer != nil {
+		w.dictTrainer.Add(w.dataBlockBuf.uncompressed)
+	}
+	if w.coordination.compressionQueue == nil {
+		w.dataBlockBuf.compressAndChecksum(w.compression)
+		// Since dataBlockEstimates.addInflightDataBlock was never called, the
+		// inflightSize is set to 0.
+		w.coordination.sizeEstimate.dataBlockCompressed(len(w.dataBlockBuf.compressed), 0)
+	}
 
 	// Determine if the index block should be flushed. Since we're accessing the
 	// dataBlockBuf.dataBlock.curKey here, we have to make sure that once we start
@@ -1329,9 +1420,6 @@ func (w *Writer) flush(key InternalKey) error {
 
 	// Schedule a write.
 	writeTask := writeTaskPool.Get().(*writeTask)
-	// We're setting compressionDone to indicate that compression of this block
-	// has already been completed.
-	writeTask.compressionDone <- true
 	writeTask.buf = w.dataBlockBuf
 	writeTask.indexEntrySep = sep
 	writeTask.currIndexBlock = w.indexBlock
@@ -1343,12 +1431,25 @@ func (w *Writer) flush(key InternalKey) error {
 	w.indexBlock.addInflight(writeTask.indexInflightSize)
 
 	w.dataBlockBuf = nil
+	if q := w.coordination.compressionQueue; q != nil {
+		// The block is compressed by the compressionQueue, which signals
+		// compressionDone once it's done. The writeQueue writes the blocks in
+		// the order in which they're added, as it waits for their compression.
+		q.add(writeTask)
+	} else {
+		// We're setting compressionDone to indicate that compression of this
+		// block has already been completed.
+		writeTask.compressionDone <- true
+	}
 	if w.coordination.parallelismEnabled {
 		w.coordination.writeQueue.add(writeTask)
 	} else {
 		err = w.coordination.writeQueue.addSync(writeTask)
 	}
 	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType)
+	w.dataBlockBuf.cipher = w.cipher
+	w.dataBlockBuf.zstd = w.zstd
+	w.dataBlockBuf.zstdDict = w.zstdDict
 
 	return err
 }
@@ -1640,7 +1741,7 @@ func (w *Writer) writeTwoLevelIndex() (BlockHandle, error) {
 func compressAndChecksum(b []byte, compression Compression, blockBuf *blockBuf) []byte {
 	// Compress the buffer, discarding the result if the improvement isn't at
 	// least 12.5%.
-	blockType, compressed := compressBlock(compression, b, blockBuf.compressedBuf)
+	blockType, compressed := compressBlock(compression, blockBuf.zstd, blockBuf.zstdDict, b, blockBuf.compressedBuf)
 	if blockType != noCompressionBlockType && cap(compressed) > cap(blockBuf.compressedBuf) {
 		blockBuf.compressedBuf = compressed[:cap(compressed)]
 	}
@@ -1650,6 +1751,14 @@ func compressAndChecksum(b []byte, compression Compression, blockBuf *blockBuf)
 		blockType = noCompressionBlockType
 	}
 
+	// Encrypt the block after compression, and before computing the checksum,
+	// so that the checksum can be verified without the data key.
+	if blockBuf.cipher != nil {
+		b = blockBuf.cipher.encrypt(blockBuf.encryptedBuf, b)
+		blockBuf.encryptedBuf = b
+		blockType |= encryptedBlockTypeMask
+	}
+
 	blockBuf.tmp[0] = byte(blockType)
 
 	// Calculate the checksum.
@@ -1710,6 +1819,53 @@ func (w *Writer) writeBlock(
 	return w.writeCompressedBlock(b, blockBuf.tmp[:])
 }
 
+// requireFeature declares that the table uses the given feature, which readers
+// must support to read the table. Only tables with the Pebble magic number
+// declare their features.
+func (w *Writer) requireFeature(f TableFeatures) error {
+	if magic, _ := w.tableFormat.AsTuple(); magic != pebbleDBMagic {
+		return errors.Newf("pebble/table: table format %s cannot declare table features (%s)",
+			w.tableFormat, f)
+	}
+	w.features |= TableFeaturesDeclared | f
+	return nil
+}
+
+// initEncryption obtains a new data key for the table, and configures the
+// Writer to encrypt blocks with it.
+func (w *Writer) initEncryption(m EncryptionKeyManager) error {
+	if err := w.requireFeature(TableFeatureEncryption); err != nil {
+		return err
+	}
+	id, key, err := m.NewDataKey()
+	if err != nil {
+		return errors.Wrap(err, "pebble/table: obtaining data key")
+	}
+	if id == "" {
+		return errors.New("pebble/table: empty data key identifier")
+	}
+	if w.cipher, err = newBlockCipher(key); err != nil {
+		return err
+	}
+	w.props.EncryptionKeyID = id
+	w.blockBuf.cipher = w.cipher
+	w.dataBlockBuf.cipher = w.cipher
+	if w.valueBlockWriter != nil {
+		w.valueBlockWriter.cipher = w.cipher
+	}
+	return nil
+}
+
+// writeUnencryptedBlock writes an uncompressed block which is never
+// encrypted. It is used for the metaindex and properties blocks, which must be
+// readable before the data key of the table is known.
+func (w *Writer) writeUnencryptedBlock(b []byte) (BlockHandle, error) {
+	c := w.blockBuf.cipher
+	w.blockBuf.cipher = nil
+	defer func() { w.blockBuf.cipher = c }()
+	return w.writeBlock(b, NoCompression, &w.blockBuf)
+}
+
 // assertFormatCompatibility ensures that the features present on the table are
 // compatible with the table format version.
 func (w *Writer) assertFormatCompatibility() error {
@@ -1762,7 +1918,7 @@ func (w *Writer) Close() (err error) {
 	// finish must be called before we check for an error, because finish will
 	// block until every single task added to the writeQueue has been processed,
 	// and an error could be encountered while any of those tasks are processed.
-	if err := w.coordination.writeQueue.finish(); err != nil {
+	if err := w.coordination.finish(); err != nil {
 		return err
 	}
 
@@ -1914,6 +2070,17 @@ func (w *Writer) Close() (err error) {
 		metaindex.add(InternalKey{UserKey: []byte(metaRangeKeyName)}, w.blockBuf.tmp[:n])
 	}
 
+	// Write the zstd dictionary block, which sorts after the range key and
+	// value index blocks. It's encrypted like the blocks compressed with it.
+	if w.zstdDict != nil {
+		bh, err := w.writeBlock(w.zstdDict.content, NoCompression, &w.blockBuf)
+		if err != nil {
+			return err
+		}
+		n := encodeBlockHandle(w.blockBuf.tmp[:], bh)
+		metaindex.add(InternalKey{UserKey: []byte(metaZstdDictName)}, w.blockBuf.tmp[:n])
+	}
+
 	{
 		userProps := make(map[string]string)
 		for i := range w.propCollectors {
@@ -1950,7 +2117,7 @@ func (w *Writer) Close() (err error) {
 		raw.restartInterval = propertiesBlockRestartInterval
 		w.props.CompressionOptions = rocksDBCompressionOptions
 		w.props.save(w.tableFormat, &raw)
-		bh, err := w.writeBlock(raw.finish(), NoCompression, &w.blockBuf)
+		bh, err := w.writeUnencryptedBlock(raw.finish())
 		if err != nil {
 			return err
 		}
@@ -1976,7 +2143,7 @@ func (w *Writer) Close() (err error) {
 	// policy is nil. NoCompression is specified because a) RocksDB never
 	// compresses the meta-index block and b) RocksDB has some code paths which
 	// expect the meta-index block to not be compressed.
-	metaindexBH, err := w.writeBlock(metaindex.blockWriter.finish(), NoCompression, &w.blockBuf)
+	metaindexBH, err := w.writeUnencryptedBlock(metaindex.blockWriter.finish())
 	if err != nil {
 		return err
 	}
@@ -1987,6 +2154,7 @@ func (w *Writer) Close() (err error) {
 		checksum:    w.blockBuf.checksummer.checksumType,
 		metaindexBH: metaindexBH,
 		indexBH:     indexBH,
+		features:    w.features,
 	}
 	encoded := footer.encode(w.blockBuf.tmp[:])
 	if err := w.writable.Write(footer.encode(w.blockBuf.tmp[:])); err != nil {
@@ -2089,6 +2257,7 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 		split:                   o.Comparer.Split,
 		formatKey:               o.Comparer.FormatKey,
 		compression:             o.Compression,
+		zstd:                    o.Zstd,
 		separator:               o.Comparer.Separator,
 		successor:               o.Comparer.Successor,
 		tableFormat:             o.TableFormat,
@@ -2117,21 +2286,52 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 			w.blockSize, w.blockSizeThreshold, w.compression, w.checksumType, func(compressedSize int) {
 				w.coordination.sizeEstimate.dataBlockCompressed(compressedSize, 0)
 			})
+		w.valueBlockWriter.zstd = o.Zstd
 	}
 
 	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType)
+	w.dataBlockBuf.zstd = o.Zstd
 
 	w.blockBuf = blockBuf{
 		checksummer: checksummer{checksumType: o.Checksum},
+		zstd:        o.Zstd,
 	}
 
-	w.coordination.init(o.Parallelism, w)
+	w.coordination.init(o.Parallelism, o.CompressionConcurrency, o.Compression, w)
 
 	if writable == nil {
 		w.err = errors.New("pebble: nil writable")
 		return w
 	}
 
+	if o.Compression == ZstdCompression {
+		if w.err = o.Zstd.Validate(); w.err != nil {
+			return w
+		}
+		if len(o.CompressionDict) > 0 {
+			if w.err = validateZstdDict(o.CompressionDict); w.err != nil {
+				return w
+			}
+			if w.err = w.requireFeature(TableFeatureCompressionDict); w.err != nil {
+				return w
+			}
+			// The dictionary is only used by the data and value blocks, which
+			// dominate the size of the table and share its contents.
+			w.zstdDict = newZstdDict(o.CompressionDict)
+			w.dataBlockBuf.zstdDict = w.zstdDict
+			if w.valueBlockWriter != nil {
+				w.valueBlockWriter.zstdDict = w.zstdDict
+			}
+		}
+		w.dictTrainer = o.CompressionDictTrainer
+	}
+
+	if o.EncryptionKeyManager != nil {
+		if w.err = w.initEncryption(o.EncryptionKeyManager); w.err != nil {
+			return w
+		}
+	}
+
 	// Note that WriterOptions are applied in two places; the ones with a
 	// preApply() method are applied here. The rest are applied down below after
 	// default properties are set.
@@ -2161,9 +2361,20 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 	w.props.ColumnFamilyID = math.MaxInt32
 	w.props.ComparerName = o.Comparer.Name
 	w.props.CompressionName = o.Compression.String()
+	if o.Compression == ZstdCompression {
+		w.props.ZstdLevel = uint64(o.Zstd.Level)
+		w.props.ZstdWindowLog = uint64(o.Zstd.WindowLog)
+	}
 	w.props.MergerName = o.MergerName
 	w.props.PropertyCollectorNames = "[]"
 	w.props.ExternalFormatVersion = rocksDBExternalFormatVersion
+	w.props.ValueChecksums = o.ValueChecksums
+	w.valueChecksums = o.ValueChecksums
+	if o.ValueChecksums {
+		if w.err = w.requireFeature(TableFeatureValueChecksums); w.err != nil {
+			return w
+		}
+	}
 
 	if len(o.TablePropertyCollectors) > 0 || len(o.BlockPropertyCollectors) > 0 {
 		var buf bytes.Buffer
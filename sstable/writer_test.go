@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"strconv"
 	"strings"
@@ -507,6 +509,49 @@ func TestParallelWriterErrorProp(t *testing.T) {
 	require.Equal(t, err.Error(), "write queue write error")
 }
 
+func TestWriterCompressionConcurrency(t *testing.T) {
+	// writeTable writes a table of many small data blocks and returns its
+	// contents.
+	writeTable := func(opts WriterOptions) []byte {
+		fs := vfs.NewMem()
+		f, err := fs.Create("test")
+		require.NoError(t, err)
+		opts.TableFormat = TableFormatPebblev3
+		opts.BlockSize = 128
+		// A single-level index keeps the layout independent of the timing of
+		// the writes, which determines when index blocks are flushed.
+		opts.IndexBlockSize = math.MaxInt32
+		w := NewWriter(objstorageprovider.NewFileWritable(f), opts)
+		for i := 0; i < 10000; i++ {
+			key := []byte(fmt.Sprintf("key%06d", i))
+			require.NoError(t, w.Set(key, bytes.Repeat(key, i%7)))
+		}
+		require.NoError(t, w.Close())
+		data, err := fs.Open("test")
+		require.NoError(t, err)
+		defer data.Close()
+		buf, err := io.ReadAll(data)
+		require.NoError(t, err)
+		return buf
+	}
+
+	for _, c := range []Compression{SnappyCompression, ZstdCompression} {
+		t.Run(c.String(), func(t *testing.T) {
+			expected := writeTable(WriterOptions{Compression: c, Parallelism: true})
+			// The blocks are written in order regardless of the order in which
+			// their compression completes, so the tables are identical.
+			for _, concurrency := range []int{2, 4, 8} {
+				got := writeTable(WriterOptions{
+					Compression:            c,
+					Parallelism:            true,
+					CompressionConcurrency: concurrency,
+				})
+				require.Equal(t, expected, got, "concurrency %d", concurrency)
+			}
+		})
+	}
+}
+
 func TestSizeEstimate(t *testing.T) {
 	var sizeEstimate sizeEstimate
 	datadriven.RunTest(t, "testdata/size_estimate",
@@ -1008,3 +1053,72 @@ var test4bSuffixComparer = &base.Comparer{
 	},
 	Name: "comparer-split-4b-suffix",
 }
+
+func TestWriterZstdOptions(t *testing.T) {
+	mem := vfs.NewMem()
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("value-%d.", i%10)), 20)
+	}
+	write := func(name string, zstd ZstdOptions, mutate func(w *Writer)) error {
+		f, err := mem.Create(name)
+		require.NoError(t, err)
+		w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+			Compression: ZstdCompression,
+			Zstd:        zstd,
+			TableFormat: TableFormatPebblev3,
+		})
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, w.Set([]byte(fmt.Sprintf("key%04d", i)), value(i)))
+		}
+		if mutate != nil {
+			mutate(w)
+		}
+		return w.Close()
+	}
+	open := func(name string) (*Reader, error) {
+		f, err := mem.Open(name)
+		require.NoError(t, err)
+		return newReader(f, ReaderOptions{})
+	}
+
+	for _, zstd := range []ZstdOptions{{}, {Level: 19}, {Level: 1, WindowLog: 12}} {
+		t.Run(fmt.Sprintf("level=%d,window=%d", zstd.Level, zstd.WindowLog), func(t *testing.T) {
+			require.NoError(t, write("table", zstd, nil))
+			r, err := open("table")
+			require.NoError(t, err)
+			defer r.Close()
+			require.Equal(t, "ZSTD", r.Properties.CompressionName)
+			require.Equal(t, uint64(zstd.Level), r.Properties.ZstdLevel)
+			require.Equal(t, uint64(zstd.WindowLog), r.Properties.ZstdWindowLog)
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			var n int
+			for k, v := iter.First(); k != nil; k, v = iter.Next() {
+				val, _, err := v.Value(nil)
+				require.NoError(t, err)
+				require.Equal(t, value(n), val)
+				n++
+			}
+			require.NoError(t, iter.Close())
+			require.Equal(t, 1000, n)
+		})
+	}
+
+	// Invalid options are rejected by the writer.
+	f, err := mem.Create("invalid")
+	require.NoError(t, err)
+	w := NewWriter(objstorageprovider.NewFileWritable(f), WriterOptions{
+		Compression: ZstdCompression,
+		Zstd:        ZstdOptions{WindowLog: MaxZstdWindowLog + 1},
+	})
+	require.Error(t, w.Close())
+
+	// Tables compressed with a window exceeding the maximum are rejected by
+	// readers.
+	require.NoError(t, write("large-window", ZstdOptions{WindowLog: 20}, func(w *Writer) {
+		w.props.ZstdWindowLog = MaxZstdWindowLog + 1
+	}))
+	_, err = open("large-window")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported zstd window log 28")
+}
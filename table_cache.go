@@ -17,6 +17,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
@@ -62,12 +63,18 @@ type tableCacheOpts struct {
 	// iterCount keeps track of how many iterators are open. It is used to keep
 	// track of leaked iterators on a per-db level.
 	iterCount *atomic.Int32
+	// rangeKeyBlocksRead counts the range key blocks read by range key
+	// iterators, either from the block cache or from disk.
+	rangeKeyBlocksRead *atomic.Uint64
 
 	loggerAndTracer LoggerAndTracer
 	cacheID         uint64
 	objProvider     objstorage.Provider
 	opts            sstable.ReaderOptions
 	filterMetrics   *sstable.FilterMetricsTracker
+	// bulkScanCache, if non-nil, caches the blocks read by iterators created
+	// with IterOptions.BulkScan.
+	bulkScanCache *cache.Cache
 }
 
 // tableCacheContainer contains the table cache and
@@ -105,6 +112,10 @@ func newTableCacheContainer(
 	t.dbOpts.opts = opts.MakeReaderOptions()
 	t.dbOpts.filterMetrics = &sstable.FilterMetricsTracker{}
 	t.dbOpts.iterCount = new(atomic.Int32)
+	t.dbOpts.rangeKeyBlocksRead = new(atomic.Uint64)
+	if opts.Experimental.BulkScanCacheSize > 0 {
+		t.dbOpts.bulkScanCache = cache.New(opts.Experimental.BulkScanCacheSize)
+	}
 	return t
 }
 
@@ -125,6 +136,9 @@ func (c *tableCacheContainer) close() error {
 			shard.removeDB(&c.dbOpts)
 		}
 	}
+	if c.dbOpts.bulkScanCache != nil {
+		c.dbOpts.bulkScanCache.Unref()
+	}
 	return firstError(err, c.tableCache.Unref())
 }
 
@@ -149,6 +163,28 @@ func (c *tableCacheContainer) getTableProperties(file *fileMetadata) (*sstable.P
 	return c.tableCache.getShard(file.FileBacking.DiskFileNum).getTableProperties(file, &c.dbOpts)
 }
 
+// mayContainPrefix returns false if the given sstable contains neither point
+// keys with the given prefix, according to its table filter, nor range
+// deletions, in which case a prefix seek may skip the sstable without opening
+// its iterators. See Options.Experimental.PrecheckTableFilters.
+func (c *tableCacheContainer) mayContainPrefix(
+	ctx context.Context,
+	file *manifest.FileMetadata,
+	prefix []byte,
+	stats *base.InternalIteratorStats,
+) (bool, error) {
+	s := c.tableCache.getShard(file.FileBacking.DiskFileNum)
+	v := s.findNode(file, &c.dbOpts)
+	defer s.unrefValue(v)
+	if v.err != nil {
+		return false, v.err
+	}
+	if v.reader.Properties.NumRangeDeletions > 0 {
+		return true, nil
+	}
+	return v.reader.TableFilterMayContain(ctx, prefix, stats)
+}
+
 func (c *tableCacheContainer) evict(fileNum base.DiskFileNum) {
 	c.tableCache.getShard(fileNum).evict(fileNum, &c.dbOpts, false)
 }
@@ -441,6 +477,15 @@ func (c *tableCacheShard) newIters(
 	if opts != nil {
 		useFilter = manifest.LevelToInt(opts.level) != 6 || opts.UseL6Filters
 		ctx = objiotracing.WithLevel(ctx, manifest.LevelToInt(opts.level))
+		if t := dbOpts.opts.Tracer; t != nil && t.IsTracingEnabled(ctx) {
+			ctx = sstable.WithTraceLevel(ctx, manifest.LevelToInt(opts.level))
+		}
+		if opts.BulkScan && dbOpts.bulkScanCache != nil {
+			ctx = sstable.WithScanCache(ctx, dbOpts.bulkScanCache)
+		}
+		if opts.CacheMode != CacheModeDefault {
+			ctx = sstable.WithCacheMode(ctx, opts.CacheMode)
+		}
 	}
 	tableFormat, err := v.reader.TableFormat()
 	if err != nil {
@@ -538,6 +583,7 @@ func (c *tableCacheShard) newRangeKeyIter(
 		// the keyspan.LevelIter expects a non-nil iterator if err is nil.
 		return emptyKeyspanIter, nil
 	}
+	dbOpts.rangeKeyBlocksRead.Add(1)
 
 	return iter, nil
 }
@@ -749,9 +795,10 @@ func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *
 	pprof.Do(context.Background(), tableCacheLabels, func(context.Context) {
 		v.load(
 			loadInfo{
-				backingFileNum: meta.FileBacking.DiskFileNum,
-				smallestSeqNum: meta.SmallestSeqNum,
-				largestSeqNum:  meta.LargestSeqNum,
+				backingFileNum:    meta.FileBacking.DiskFileNum,
+				smallestSeqNum:    meta.SmallestSeqNum,
+				largestSeqNum:     meta.LargestSeqNum,
+				prefixReplacement: meta.PrefixReplacement,
 			}, c, dbOpts)
 	})
 	return v
@@ -884,6 +931,9 @@ func (c *tableCacheShard) evict(fileNum base.DiskFileNum, dbOpts *tableCacheOpts
 	}
 
 	dbOpts.opts.Cache.EvictFile(dbOpts.cacheID, fileNum)
+	if dbOpts.bulkScanCache != nil {
+		dbOpts.bulkScanCache.EvictFile(dbOpts.cacheID, fileNum)
+	}
 }
 
 // removeDB evicts any nodes which have a reference to the DB
@@ -981,6 +1031,9 @@ type loadInfo struct {
 	backingFileNum base.DiskFileNum
 	largestSeqNum  uint64
 	smallestSeqNum uint64
+	// prefixReplacement is non-nil if the keys of the table are read with a
+	// replaced prefix.
+	prefixReplacement *base.PrefixReplacement
 }
 
 func (v *tableCacheValue) load(loadInfo loadInfo, c *tableCacheShard, dbOpts *tableCacheOpts) {
@@ -991,7 +1044,12 @@ func (v *tableCacheValue) load(loadInfo loadInfo, c *tableCacheShard, dbOpts *ta
 	)
 	if v.err == nil {
 		cacheOpts := private.SSTableCacheOpts(dbOpts.cacheID, loadInfo.backingFileNum).(sstable.ReaderOption)
-		v.reader, v.err = sstable.NewReader(f, dbOpts.opts, cacheOpts, dbOpts.filterMetrics)
+		readerOpts := []sstable.ReaderOption{cacheOpts, dbOpts.filterMetrics}
+		if loadInfo.prefixReplacement != nil {
+			readerOpts = append(readerOpts,
+				private.SSTablePrefixReplacementOpt(loadInfo.prefixReplacement).(sstable.ReaderOption))
+		}
+		v.reader, v.err = sstable.NewReader(f, dbOpts.opts, readerOpts...)
 	}
 	if v.err == nil {
 		if loadInfo.smallestSeqNum == loadInfo.largestSeqNum {
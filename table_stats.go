@@ -87,6 +87,13 @@ func (d *DB) collectTableStats() bool {
 
 	pending := d.mu.tableStats.pending
 	d.mu.tableStats.pending = nil
+	// The stats of the tables holding range deletions coalesced by flushes
+	// are loaded first, in their own job, so that the delete-only compactions
+	// they enable aren't delayed by the stats of other new tables.
+	if coalesced, rest := partitionCoalescedRangeDels(pending); len(coalesced) > 0 && len(rest) > 0 {
+		pending = coalesced
+		d.mu.tableStats.pending = rest
+	}
 	d.mu.tableStats.loading = true
 	jobID := d.mu.nextJobID
 	d.mu.nextJobID++
@@ -151,6 +158,9 @@ func (d *DB) collectTableStats() bool {
 			}
 		}
 		d.mu.compact.deletionHints = append(d.mu.compact.deletionHints, keepHints...)
+		// Hints derived from point tombstones don't necessarily come with a
+		// range deletion estimate, so schedule a compaction explicitly.
+		maybeCompact = maybeCompact || len(keepHints) > 0
 	}
 	if maybeCompact {
 		d.maybeScheduleCompaction()
@@ -163,6 +173,21 @@ type collectedStats struct {
 	manifest.TableStats
 }
 
+// partitionCoalescedRangeDels partitions the given new files into those
+// holding range deletions coalesced by flushes and the others.
+func partitionCoalescedRangeDels(
+	files []manifest.NewFileEntry,
+) (coalesced, rest []manifest.NewFileEntry) {
+	for _, nf := range files {
+		if nf.Meta.CoalescedRangeDels {
+			coalesced = append(coalesced, nf)
+		} else {
+			rest = append(rest, nf)
+		}
+	}
+	return coalesced, rest
+}
+
 func (d *DB) loadNewFileStats(
 	rs *readState, pending []manifest.NewFileEntry,
 ) ([]collectedStats, []deleteCompactionHint) {
@@ -186,10 +211,7 @@ func (d *DB) loadNewFileStats(
 			continue
 		}
 
-		stats, newHints, err := d.loadTableStats(
-			rs.current, nf.Level,
-			nf.Meta.PhysicalMeta(),
-		)
+		stats, newHints, err := d.loadTableStats(rs.current, nf.Level, nf.Meta)
 		if err != nil {
 			d.opts.EventListener.BackgroundError(err)
 			continue
@@ -236,9 +258,7 @@ func (d *DB) scanReadStateTableStats(
 				return fill, hints, moreRemain
 			}
 
-			stats, newHints, err := d.loadTableStats(
-				rs.current, l, f.PhysicalMeta(),
-			)
+			stats, newHints, err := d.loadTableStats(rs.current, l, f)
 			if err != nil {
 				// Set `moreRemain` so we'll try again.
 				moreRemain = true
@@ -260,9 +280,20 @@ func (d *DB) scanReadStateTableStats(
 //
 // TODO(bananabrick): Support stats collection for virtual sstables.
 func (d *DB) loadTableStats(
-	v *version, level int, meta physicalMeta,
+	v *version, level int, file *fileMetadata,
 ) (manifest.TableStats, []deleteCompactionHint, error) {
 	var stats manifest.TableStats
+	if file.Virtual {
+		// The stats of virtual sstables are extrapolated from the properties of
+		// their backing sstable, and don't account for the tombstones.
+		err := d.tableCache.withVirtualReader(
+			file.VirtualMeta(), func(r sstable.VirtualReader) error {
+				stats.NumEntries = r.Properties.NumEntries
+				return nil
+			})
+		return stats, nil, err
+	}
+	meta := file.PhysicalMeta()
 	var compactionHints []deleteCompactionHint
 	err := d.tableCache.withReader(
 		meta, func(r *sstable.Reader) (err error) {
@@ -272,19 +303,27 @@ func (d *DB) loadTableStats(
 				if err = d.loadTablePointKeyStats(r, v, level, meta, &stats); err != nil {
 					return
 				}
+				if d.opts.Experimental.QueueDeletionDetection {
+					if compactionHints, err = d.loadQueueDeletionHints(r, v, level, meta); err != nil {
+						return
+					}
+				}
 			}
 			if r.Properties.NumRangeDeletions > 0 || r.Properties.NumRangeKeyDels > 0 {
-				if compactionHints, err = d.loadTableRangeDelStats(
+				var rangeDelHints []deleteCompactionHint
+				if rangeDelHints, err = d.loadTableRangeDelStats(
 					r, v, level, meta, &stats,
 				); err != nil {
 					return
 				}
+				compactionHints = append(compactionHints, rangeDelHints...)
 			}
 			// TODO(travers): Once we have real-world data, consider collecting
 			// additional stats that may provide improved heuristics for compaction
 			// picking.
 			stats.NumRangeKeySets = r.Properties.NumRangeKeySets
 			stats.ValueBlocksSize = r.Properties.ValueBlocksSize
+			stats.FilterSize = r.Properties.FilterSize
 			return
 		})
 	if err != nil {
@@ -313,6 +352,97 @@ func (d *DB) loadTablePointKeyStats(
 	return nil
 }
 
+// loadQueueDeletionHints returns deletion hints for the tables beneath the
+// given table whose keys have all been deleted by the table's point
+// tombstones. Such tables are typical of workloads using the LSM as a FIFO
+// queue, which delete contiguous runs of keys shortly after writing them.
+// Dropping the tables in a delete-only compaction avoids rewriting their keys
+// only to have them dropped by the tombstones. See
+// Options.Experimental.QueueDeletionDetection.
+func (d *DB) loadQueueDeletionHints(
+	r *sstable.Reader, v *version, level int, meta physicalMeta,
+) ([]deleteCompactionHint, error) {
+	var hints []deleteCompactionHint
+	for l := level + 1; l < numLevels; l++ {
+		overlaps := v.Overlaps(l, d.cmp, meta.Smallest.UserKey,
+			meta.Largest.UserKey, meta.Largest.IsExclusiveSentinel())
+		iter := overlaps.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			// Only physical tables that are older than all of the tombstones,
+			// contain no range keys, and are contained within the bounds of
+			// the tombstones' table are candidates.
+			if f.Virtual || f.HasRangeKeys || f.LargestSeqNum >= meta.SmallestSeqNum ||
+				d.cmp(f.Smallest.UserKey, meta.Smallest.UserKey) < 0 ||
+				d.cmp(f.Largest.UserKey, meta.Largest.UserKey) > 0 {
+				continue
+			}
+			deleted, err := d.tableKeysDeleted(r, f.PhysicalMeta())
+			if err != nil {
+				return nil, err
+			}
+			if !deleted {
+				continue
+			}
+			hints = append(hints, deleteCompactionHint{
+				hintType:                deleteCompactionHintTypePointKeyOnly,
+				start:                   f.Smallest.UserKey,
+				end:                     f.Largest.UserKey,
+				tombstoneFile:           meta.FileMetadata,
+				tombstoneLevel:          level,
+				tombstoneLargestSeqNum:  meta.LargestSeqNum,
+				tombstoneSmallestSeqNum: meta.SmallestSeqNum,
+				fileSmallestSeqNum:      f.SmallestSeqNum,
+				file:                    f,
+			})
+		}
+	}
+	return hints, nil
+}
+
+// tableKeysDeleted returns true if the newest version of every user key of
+// the table described by meta within the table read by tombstones is a point
+// deletion. The table must not contain range deletions, which may delete keys
+// that the point tombstones do not.
+func (d *DB) tableKeysDeleted(tombstones *sstable.Reader, meta physicalMeta) (bool, error) {
+	tIter, err := tombstones.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return false, err
+	}
+	defer tIter.Close()
+
+	deleted := false
+	err = d.tableCache.withReader(meta, func(r *sstable.Reader) error {
+		if r.Properties.NumRangeDeletions > 0 {
+			return nil
+		}
+		iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+		if err != nil {
+			return err
+		}
+		defer iter.Close()
+
+		var prevUserKey []byte
+		tKey, _ := tIter.SeekGE(meta.Smallest.UserKey, base.SeekGEFlagsNone)
+		for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+			if prevUserKey != nil && d.equal(prevUserKey, k.UserKey) {
+				continue
+			}
+			// The first key of tIter at or after the user key is the newest
+			// version of the user key within the tombstones' table.
+			for tKey != nil && d.cmp(tKey.UserKey, k.UserKey) < 0 {
+				tKey, _ = tIter.Next()
+			}
+			if tKey == nil || !d.equal(tKey.UserKey, k.UserKey) || tKey.Kind() != InternalKeyKindDelete {
+				return nil
+			}
+			prevUserKey = append(prevUserKey[:0], k.UserKey...)
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
 // loadTableRangeDelStats calculates the range deletion and range key deletion
 // statistics for the given table.
 func (d *DB) loadTableRangeDelStats(
@@ -618,6 +748,7 @@ func maybeSetStatsFromProperties(meta physicalMeta, props *sstable.Properties) b
 	meta.Stats.PointDeletionsBytesEstimate = pointEstimate
 	meta.Stats.RangeDeletionsBytesEstimate = 0
 	meta.Stats.ValueBlocksSize = props.ValueBlocksSize
+	meta.Stats.FilterSize = props.FilterSize
 	meta.StatsMarkValid()
 	return true
 }
@@ -892,14 +1023,20 @@ func (a rangeKeySetsAnnotator) Merge(src interface{}, dst interface{}) interface
 // calculations when possible.
 func countRangeKeySetFragments(v *version) (count uint64) {
 	for l := 0; l < numLevels; l++ {
-		if v.RangeKeyLevels[l].Empty() {
-			continue
-		}
-		count += *v.RangeKeyLevels[l].Annotation(rangeKeySetsAnnotator{}).(*uint64)
+		count += countRangeKeySetFragmentsForLevel(v, l)
 	}
 	return count
 }
 
+// countRangeKeySetFragmentsForLevel counts the number of RANGEKEYSET keys
+// across the files of the given level, as countRangeKeySetFragments does.
+func countRangeKeySetFragmentsForLevel(v *version, level int) uint64 {
+	if v.RangeKeyLevels[level].Empty() {
+		return 0
+	}
+	return *v.RangeKeyLevels[level].Annotation(rangeKeySetsAnnotator{}).(*uint64)
+}
+
 // tombstonesAnnotator implements manifest.Annotator, annotating B-Tree nodes
 // with the sum of the files' counts of tombstones (DEL, SINGLEDEL and RANGEDELk
 // eys). Its annotation type is a *uint64. The count of tombstones may change
@@ -993,3 +1130,47 @@ func valueBlocksSizeForLevel(v *version, level int) (count uint64) {
 	}
 	return *v.Levels[level].Annotation(valueBlocksSizeAnnotator{}).(*uint64)
 }
+
+// filterSizeAnnotator implements manifest.Annotator, annotating B-Tree nodes
+// with the sum of the files' Properties.FilterSize. Its annotation type is a
+// *uint64. Like valueBlocksSizeAnnotator, its values are marked as cacheable
+// only if a file's stats have been loaded.
+type filterSizeAnnotator struct{}
+
+var _ manifest.Annotator = filterSizeAnnotator{}
+
+func (a filterSizeAnnotator) Zero(dst interface{}) interface{} {
+	if dst == nil {
+		return new(uint64)
+	}
+	v := dst.(*uint64)
+	*v = 0
+	return v
+}
+
+func (a filterSizeAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (v interface{}, cacheOK bool) {
+	vptr := dst.(*uint64)
+	*vptr = *vptr + f.Stats.FilterSize
+	return vptr, f.StatsValid()
+}
+
+func (a filterSizeAnnotator) Merge(src interface{}, dst interface{}) interface{} {
+	srcV := src.(*uint64)
+	dstV := dst.(*uint64)
+	*dstV = *dstV + *srcV
+	return dstV
+}
+
+// filterSizeForLevel returns the Properties.FilterSize across all files for a
+// level of the LSM. It only includes the size for files for which table stats
+// have been loaded. It must not be called concurrently.
+//
+// REQUIRES: 0 <= level <= numLevels.
+func filterSizeForLevel(v *version, level int) uint64 {
+	if v.Levels[level].Empty() {
+		return 0
+	}
+	return *v.Levels[level].Annotation(filterSizeAnnotator{}).(*uint64)
+}
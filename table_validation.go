@@ -0,0 +1,87 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// Validation of written tables
+//
+// When Options.Experimental.ValidateOnWriteSampleRate is positive, the
+// sstables written by flushes and compactions are queued for validation in
+// the background, alongside the ingested tables validated when
+// Options.Experimental.ValidateOnIngest is set. A table that was corrupted
+// while being written back to storage would otherwise only be detected when
+// it is next read, by which time the inputs of the flush or compaction that
+// wrote it, from which its contents could have been recovered, have been
+// deleted.
+//
+// Each flush or compaction that queues tables increments writtenSeq. The
+// obsolete tables found by a cleaning turn while writtenSeq is ahead of
+// validatedSeq, ie, while some written tables await validation, are held back
+// from deletion, tagged with the writtenSeq at that time. The inputs of a
+// flush or compaction only become obsolete after its outputs are queued, so
+// a held table is released once validatedSeq catches up with its tag, at
+// which point the outputs of the flush or compaction that obsoleted it have
+// been validated. Note that this is conservative: a table may be held back
+// for the validation of tables written by unrelated, concurrent jobs.
+//
+// Held tables are released once the validation completes, whether or not it
+// succeeded. A failed validation is reported to EventListener.TableValidated,
+// whose handler runs before the held tables are released. Inputs that remain
+// referenced by open iterators until after the outputs have been validated
+// are deleted once they are no longer referenced.
+
+// heldTable is an obsolete table whose deletion is deferred until the tables
+// written before it became obsolete have been validated.
+type heldTable struct {
+	fileInfo
+	// seq is the value of tableValidation.writtenSeq when the table was held
+	// back.
+	seq uint64
+}
+
+// maybeValidateWrittenSSTablesLocked adds the tables written by a flush or
+// compaction to the queue of written tables to be validated, when the
+// feature is enabled. It must be called after the tables are installed in the
+// current version, but before the version edit's input tables may be deleted.
+// DB.mu must be locked when calling.
+func (d *DB) maybeValidateWrittenSSTablesLocked(c *compaction, newFiles []newFileEntry) {
+	if d.opts.Experimental.ValidateOnWriteSampleRate <= 0 || len(newFiles) == 0 {
+		return
+	}
+	// Move compactions and flushes of ingested tables don't write tables.
+	switch c.kind {
+	case compactionKindMove, compactionKindIngestedFlushable:
+		return
+	}
+	d.mu.tableValidation.written = append(d.mu.tableValidation.written, newFiles...)
+	d.mu.tableValidation.writtenSeq++
+	if d.shouldValidateSSTablesLocked() {
+		go d.validateSSTables()
+	}
+}
+
+// holdObsoleteTablesLocked holds back the given obsolete tables if written
+// tables are awaiting validation, and returns the obsolete tables that may
+// be deleted, including the previously held tables that have been released.
+// DB.mu must be locked when calling.
+func (d *DB) holdObsoleteTablesLocked(obsolete []fileInfo) []fileInfo {
+	tv := &d.mu.tableValidation
+	if tv.writtenSeq > tv.validatedSeq {
+		for _, fi := range obsolete {
+			tv.held = append(tv.held, heldTable{fileInfo: fi, seq: tv.writtenSeq})
+		}
+		obsolete = nil
+	}
+	n := 0
+	for _, t := range tv.held {
+		if t.seq <= tv.validatedSeq {
+			obsolete = append(obsolete, t.fileInfo)
+			continue
+		}
+		tv.held[n] = t
+		n++
+	}
+	tv.held = tv.held[:n]
+	return obsolete
+}
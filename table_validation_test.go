@@ -0,0 +1,142 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// writebackCorruptionFS corrupts the first byte written to each sstable
+// created while corrupt is set.
+type writebackCorruptionFS struct {
+	vfs.FS
+	corrupt atomic.Bool
+}
+
+func (fs *writebackCorruptionFS) Create(name string) (vfs.File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil || !fs.corrupt.Load() || !strings.HasSuffix(name, ".sst") {
+		return f, err
+	}
+	return &writebackCorruptionFile{File: f}, nil
+}
+
+type writebackCorruptionFile struct {
+	vfs.File
+	corrupted bool
+}
+
+func (f *writebackCorruptionFile) Write(p []byte) (int, error) {
+	if f.corrupted || len(p) == 0 {
+		return f.File.Write(p)
+	}
+	f.corrupted = true
+	q := append([]byte(nil), p...)
+	q[0]++
+	return f.File.Write(q)
+}
+
+func TestValidateOnWrite(t *testing.T) {
+	fs := &writebackCorruptionFS{FS: vfs.NewMem()}
+	var mu sync.Mutex
+	var validated []TableValidatedInfo
+	opts := &Options{
+		FS:                          fs,
+		DisableAutomaticCompactions: true,
+		EventListener: &EventListener{
+			TableValidated: func(info TableValidatedInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				validated = append(validated, info)
+			},
+		},
+		Logger: panicLogger{},
+	}
+	opts.Experimental.ValidateOnWriteSampleRate = 1
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	waitForValidation := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.tableValidation.validating || len(d.mu.tableValidation.written) > 0 {
+			d.mu.tableValidation.cond.Wait()
+		}
+	}
+	tables := func() []string {
+		ls, err := fs.List("")
+		require.NoError(t, err)
+		var tables []string
+		for _, name := range ls {
+			if strings.HasSuffix(name, ".sst") {
+				tables = append(tables, name)
+			}
+		}
+		sort.Strings(tables)
+		return tables
+	}
+	popValidated := func() []TableValidatedInfo {
+		mu.Lock()
+		defer mu.Unlock()
+		v := validated
+		validated = nil
+		return v
+	}
+	flushTables := func(n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("k%d", i)), []byte("v"), nil))
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	// The tables written by flushes and compactions are validated, and the
+	// inputs of the compaction are deleted once its output is validated.
+	flushTables(2)
+	waitForValidation()
+	require.Len(t, popValidated(), 2)
+	require.Len(t, tables(), 2)
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	waitForValidation()
+	v := popValidated()
+	require.Len(t, v, 1)
+	require.NoError(t, v[0].Err)
+	require.Equal(t, []string{base.MakeFilename(fileTypeTable, v[0].Meta.FileBacking.DiskFileNum)}, tables())
+	m := d.Metrics()
+	require.Equal(t, int64(3), m.TableValidation.Count)
+	require.Equal(t, int64(0), m.TableValidation.FailedCount)
+	require.Equal(t, int64(0), m.TableValidation.HeldCount)
+
+	// If the output of a compaction is corrupted as it is written, the
+	// failure is reported, and the inputs of the compaction are still
+	// released.
+	flushTables(1)
+	waitForValidation()
+	require.Len(t, popValidated(), 1)
+	require.Len(t, tables(), 2)
+	fs.corrupt.Store(true)
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	fs.corrupt.Store(false)
+	waitForValidation()
+	v = popValidated()
+	require.Len(t, v, 1)
+	require.True(t, errors.Is(v[0].Err, base.ErrCorruption))
+	require.Contains(t, v[0].String(), "table validation error")
+	require.Equal(t, []string{base.MakeFilename(fileTypeTable, v[0].Meta.FileBacking.DiskFileNum)}, tables())
+	m = d.Metrics()
+	require.Equal(t, int64(5), m.TableValidation.Count)
+	require.Equal(t, int64(1), m.TableValidation.FailedCount)
+	require.Equal(t, int64(0), m.TableValidation.HeldCount)
+}
@@ -0,0 +1,376 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// TimestampOptions configures the garbage collection of the versions of the
+// keys with timestamps, which requires a Comparer with CompareTimestamps.
+type TimestampOptions struct {
+	// Watermark, if set, returns the GC watermark: the oldest timestamp at
+	// which the DB may be read. Flushes and compactions remove the versions of
+	// a key older than its newest version at or below the watermark, which
+	// aren't visible at or above the watermark. Reads below the watermark may
+	// observe partially collected history. The versions read by open
+	// snapshots are kept: a version is only collected if the newer version
+	// collecting it is visible to every snapshot that sees it. The watermark
+	// is determined once per flush or compaction, and must never decrease. A
+	// nil watermark collects nothing.
+	//
+	// The versions at or below the watermark are assumed to be immutable:
+	// deleting one, with Delete or DeleteRange, may not expose the older
+	// versions, which may have been collected already.
+	Watermark func() []byte
+	// Filter, if set, is invoked by flushes and compactions for the versions
+	// they don't collect. See TimestampCompactionFilter for details.
+	Filter TimestampCompactionFilter
+}
+
+// TimestampCompactionFilter is a timestamp-aware CompactionFilter. It's
+// invoked by flushes and compactions for each version of a key with a
+// timestamp written with Set that they read and don't collect (see
+// TimestampOptions.Watermark), with the prefix and the timestamp of the key
+// (see Comparer.Split). The level is the level the flush or compaction writes
+// to.
+//
+// visibleAtWatermark is true if the version is the newest version of the key
+// at or below the watermark, which all the reads at or above the watermark
+// see. When the filter removes such a version, eg, because its value is a
+// deletion marker of the application, the older versions of the key are
+// removed as well, except for those kept for open snapshots (see
+// TimestampOptions.Watermark).
+//
+// Like a CompactionFilter, the filter must not retain or modify its
+// arguments, the returned value must remain valid until the filter is invoked
+// again, and the filter is invoked concurrently by concurrent compactions.
+// The filter is applied regardless of the open snapshots, so the versions it
+// removes or rewrites are affected as seen from the snapshots that can read
+// them.
+type TimestampCompactionFilter func(
+	level int, prefix, timestamp, value []byte, visibleAtWatermark bool,
+) (decision CompactionFilterDecision, newValue []byte)
+
+var errReadTimestampUnsupported = errors.New(
+	"pebble: IterOptions.ReadTimestamp requires Comparer.CompareTimestamps")
+
+// newReadTimestampIter wraps the given internal iterator so that the versions
+// of the keys with timestamps newer than ts are skipped. It returns the
+// iterator unmodified if ts is nil.
+func newReadTimestampIter(iter internalIterator, comparer *Comparer, ts []byte) internalIterator {
+	if ts == nil {
+		return iter
+	}
+	if comparer.CompareTimestamps == nil {
+		panic(errReadTimestampUnsupported)
+	}
+	return &readTimestampIter{
+		internalIterator:  iter,
+		split:             comparer.Split,
+		compareTimestamps: comparer.CompareTimestamps,
+		ts:                ts,
+	}
+}
+
+// unwrapReadTimestampIter returns the iterator wrapped by
+// newReadTimestampIter, if any.
+func unwrapReadTimestampIter(iter internalIterator) internalIterator {
+	if t, ok := iter.(*readTimestampIter); ok {
+		return t.internalIterator
+	}
+	return iter
+}
+
+// readTimestampIter is an internal iterator that skips the point keys of the
+// wrapped iterator whose timestamps are newer than a read timestamp. All the
+// internal keys of a user key with a timestamp are skipped alike, so that the
+// skipped versions don't shadow the older ones.
+type readTimestampIter struct {
+	internalIterator
+	split             Split
+	compareTimestamps func(a, b []byte) int
+	ts                []byte
+}
+
+var _ internalIterator = (*readTimestampIter)(nil)
+
+func (i *readTimestampIter) hidden(k *InternalKey) bool {
+	if k.Kind() == InternalKeyKindRangeDelete {
+		return false
+	}
+	suffix := k.UserKey[i.split(k.UserKey):]
+	return len(suffix) > 0 && i.compareTimestamps(suffix, i.ts) > 0
+}
+
+func (i *readTimestampIter) skipForward(k *InternalKey, v base.LazyValue) (*InternalKey, base.LazyValue) {
+	for k != nil && i.hidden(k) {
+		k, v = i.internalIterator.Next()
+	}
+	return k, v
+}
+
+func (i *readTimestampIter) skipBackward(k *InternalKey, v base.LazyValue) (*InternalKey, base.LazyValue) {
+	for k != nil && i.hidden(k) {
+		k, v = i.internalIterator.Prev()
+	}
+	return k, v
+}
+
+// SeekGE implements base.InternalIterator.
+func (i *readTimestampIter) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.internalIterator.SeekGE(key, flags))
+}
+
+// SeekPrefixGE implements base.InternalIterator.
+func (i *readTimestampIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.internalIterator.SeekPrefixGE(prefix, key, flags))
+}
+
+// SeekLT implements base.InternalIterator.
+func (i *readTimestampIter) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*InternalKey, base.LazyValue) {
+	return i.skipBackward(i.internalIterator.SeekLT(key, flags))
+}
+
+// First implements base.InternalIterator.
+func (i *readTimestampIter) First() (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.internalIterator.First())
+}
+
+// Last implements base.InternalIterator.
+func (i *readTimestampIter) Last() (*InternalKey, base.LazyValue) {
+	return i.skipBackward(i.internalIterator.Last())
+}
+
+// Next implements base.InternalIterator.
+func (i *readTimestampIter) Next() (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.internalIterator.Next())
+}
+
+// NextPrefix implements base.InternalIterator.
+func (i *readTimestampIter) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.internalIterator.NextPrefix(succKey))
+}
+
+// Prev implements base.InternalIterator.
+func (i *readTimestampIter) Prev() (*InternalKey, base.LazyValue) {
+	return i.skipBackward(i.internalIterator.Prev())
+}
+
+// newTimestampGCIter wraps the given internal iterator of a compaction writing
+// to the given level so that the versions collected as of the current
+// watermark are removed, and the others are filtered by the timestamp filter.
+// The versions read by the given snapshots are kept. It returns the iterator
+// unmodified if neither is configured.
+func (d *DB) newTimestampGCIter(
+	iter internalIterator, level int, snapshots []uint64,
+) internalIterator {
+	o := &d.opts.Experimental.Timestamps
+	if d.opts.Comparer.CompareTimestamps == nil || (o.Watermark == nil && o.Filter == nil) {
+		return iter
+	}
+	i := &timestampGCIter{
+		internalIterator:  iter,
+		split:             d.opts.Comparer.Split,
+		equal:             d.equal,
+		compareTimestamps: d.opts.Comparer.CompareTimestamps,
+		filter:            o.Filter,
+		level:             level,
+		snapshots:         snapshots,
+	}
+	if o.Watermark != nil {
+		i.watermark = append([]byte(nil), o.Watermark()...)
+	}
+	return i
+}
+
+// timestampGCIter is an internal iterator that removes the versions of the
+// keys with timestamps that are older than the newest live version at or
+// below the GC watermark, and applies a TimestampCompactionFilter to the
+// others. Like the compactionFilterIter, it transforms the removed keys into
+// DEL keys with the same sequence numbers, which are elided along with the
+// data they delete when possible.
+//
+// A version is only collected if the version collecting it is in the same
+// snapshot stripe or an older one, so that every snapshot which sees the
+// collected version sees the collecting one too.
+//
+// The versions of a key are read from newest to oldest when iterating forward,
+// as compactions do. The keys read iterating backward are returned
+// unmodified, and seeks reset the state of the iterator, so that it doesn't
+// collect a version unless it read a newer live version at or below the
+// watermark.
+type timestampGCIter struct {
+	internalIterator
+	split             Split
+	equal             Equal
+	compareTimestamps func(a, b []byte) int
+	watermark         []byte
+	filter            TimestampCompactionFilter
+	level             int
+	snapshots         []uint64
+
+	// prefix is the prefix of the current key.
+	prefix []byte
+	// userKey is the user key of the current key, whose first internal key
+	// determined obsolete and visible.
+	userKey []byte
+	// collecting is true once a live version of prefix at or below the
+	// watermark was read, and the older versions are collected.
+	collecting bool
+	// collectingStripe is the snapshot stripe of the version collecting the
+	// older versions of prefix.
+	collectingStripe int
+	// obsolete is true if the versions of userKey are collected.
+	obsolete bool
+	// visible is true if userKey is the version of prefix visible at the
+	// watermark.
+	visible bool
+	valid   bool
+	key     InternalKey
+	err     error
+}
+
+var _ internalIterator = (*timestampGCIter)(nil)
+
+func (i *timestampGCIter) apply(k *InternalKey, v base.LazyValue) (*InternalKey, base.LazyValue) {
+	if k == nil {
+		return nil, base.LazyValue{}
+	}
+	switch k.Kind() {
+	case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindMerge,
+		InternalKeyKindDelete, InternalKeyKindSingleDelete:
+	default:
+		return k, v
+	}
+	n := i.split(k.UserKey)
+	prefix, timestamp := k.UserKey[:n], k.UserKey[n:]
+	if len(timestamp) == 0 {
+		i.valid = false
+		return k, v
+	}
+
+	if !i.valid || !i.equal(i.userKey, k.UserKey) {
+		if !i.valid || !bytes.Equal(i.prefix, prefix) {
+			i.prefix = append(i.prefix[:0], prefix...)
+			i.collecting = false
+		}
+		i.userKey = append(i.userKey[:0], k.UserKey...)
+		i.valid = true
+		// The first internal key of a version, with the largest sequence
+		// number, determines whether the version is live.
+		i.obsolete = i.collecting
+		i.visible = false
+		if !i.collecting && i.watermark != nil && i.compareTimestamps(timestamp, i.watermark) <= 0 {
+			switch k.Kind() {
+			case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindMerge:
+				i.visible = true
+				i.collecting = true
+				i.collectingStripe, _ = snapshotIndex(k.SeqNum(), i.snapshots)
+			}
+		}
+	}
+	if i.obsolete && i.collectible(k.SeqNum()) {
+		i.key = base.MakeInternalKey(k.UserKey, k.SeqNum(), InternalKeyKindDelete)
+		return &i.key, base.LazyValue{}
+	}
+	if i.filter == nil {
+		return k, v
+	}
+	if kind := k.Kind(); kind != InternalKeyKindSet && kind != InternalKeyKindSetWithDelete {
+		return k, v
+	}
+	value, _, err := v.Value(nil)
+	if err != nil {
+		i.err = err
+		return nil, base.LazyValue{}
+	}
+	switch decision, newValue := i.filter(i.level, prefix, timestamp, value, i.visible); decision {
+	case CompactionFilterKeep:
+		return k, v
+	case CompactionFilterRemove:
+		i.key = base.MakeInternalKey(k.UserKey, k.SeqNum(), InternalKeyKindDelete)
+		return &i.key, base.LazyValue{}
+	case CompactionFilterChangeValue:
+		return k, base.MakeInPlaceValue(newValue)
+	default:
+		i.err = errors.AssertionFailedf("pebble: unknown compaction filter decision %s", decision)
+		return nil, base.LazyValue{}
+	}
+}
+
+// collectible returns true if the internal key of a collected version with
+// the given sequence number may be removed, ie, if no snapshot sees it
+// without seeing the version collecting it.
+func (i *timestampGCIter) collectible(seqNum uint64) bool {
+	stripe, _ := snapshotIndex(seqNum, i.snapshots)
+	return stripe >= i.collectingStripe
+}
+
+// SeekGE implements base.InternalIterator.
+func (i *timestampGCIter) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	i.err, i.valid = nil, false
+	return i.apply(i.internalIterator.SeekGE(key, flags))
+}
+
+// SeekPrefixGE implements base.InternalIterator.
+func (i *timestampGCIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	i.err, i.valid = nil, false
+	return i.apply(i.internalIterator.SeekPrefixGE(prefix, key, flags))
+}
+
+// SeekLT implements base.InternalIterator.
+func (i *timestampGCIter) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*InternalKey, base.LazyValue) {
+	i.err, i.valid = nil, false
+	return i.internalIterator.SeekLT(key, flags)
+}
+
+// First implements base.InternalIterator.
+func (i *timestampGCIter) First() (*InternalKey, base.LazyValue) {
+	i.err, i.valid = nil, false
+	return i.apply(i.internalIterator.First())
+}
+
+// Last implements base.InternalIterator.
+func (i *timestampGCIter) Last() (*InternalKey, base.LazyValue) {
+	i.err, i.valid = nil, false
+	return i.internalIterator.Last()
+}
+
+// Next implements base.InternalIterator.
+func (i *timestampGCIter) Next() (*InternalKey, base.LazyValue) {
+	return i.apply(i.internalIterator.Next())
+}
+
+// NextPrefix implements base.InternalIterator.
+func (i *timestampGCIter) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	return i.apply(i.internalIterator.NextPrefix(succKey))
+}
+
+// Prev implements base.InternalIterator.
+func (i *timestampGCIter) Prev() (*InternalKey, base.LazyValue) {
+	i.valid = false
+	return i.internalIterator.Prev()
+}
+
+// Error implements base.InternalIterator.
+func (i *timestampGCIter) Error() error {
+	return firstError(i.err, i.internalIterator.Error())
+}
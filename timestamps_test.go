@@ -0,0 +1,202 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTimestamp(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), Comparer: testkeys.Comparer})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a@1", "a@3", "a@5", "b@2", "b@6", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	// The flushed versions are shadowed by newer internal keys in the
+	// memtable, which must be hidden along with them.
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Delete([]byte("b@6"), nil))
+	require.NoError(t, d.Set([]byte("a@5"), []byte("a@5'"), nil))
+
+	scan := func(iter *Iterator, forward bool) string {
+		var keys []string
+		if forward {
+			for valid := iter.First(); valid; valid = iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+		} else {
+			for valid := iter.Last(); valid; valid = iter.Prev() {
+				keys = append(keys, string(iter.Key()))
+			}
+		}
+		require.NoError(t, iter.Error())
+		return strings.Join(keys, " ")
+	}
+
+	iter := d.NewIter(&IterOptions{ReadTimestamp: []byte("@4")})
+	require.Equal(t, "a@3 a@1 b@2 c", scan(iter, true))
+	require.Equal(t, "c b@2 a@1 a@3", scan(iter, false))
+
+	// The first version of each key is the version visible at the timestamp.
+	var visible []string
+	for valid := iter.First(); valid; valid = iter.NextPrefix() {
+		visible = append(visible, string(iter.Key()))
+	}
+	require.Equal(t, []string{"a@3", "b@2", "c"}, visible)
+	require.True(t, iter.SeekGE([]byte("a@4")))
+	require.Equal(t, "a@3", string(iter.Key()))
+
+	// Changing the timestamp reveals the newer versions.
+	iter.SetOptions(&IterOptions{ReadTimestamp: []byte("@5")})
+	require.Equal(t, "a@5 a@3 a@1 b@2 c", scan(iter, true))
+	iter.SetOptions(&IterOptions{})
+	require.Equal(t, "a@5 a@3 a@1 b@2 c", scan(iter, true))
+	require.NoError(t, iter.Close())
+
+	// Indexed batches are read at the timestamp too.
+	b := d.NewIndexedBatch()
+	require.NoError(t, b.Set([]byte("a@2"), nil, nil))
+	require.NoError(t, b.Set([]byte("a@4"), nil, nil))
+	iter = b.NewIter(&IterOptions{ReadTimestamp: []byte("@3")})
+	require.Equal(t, "a@3 a@2 a@1 b@2 c", scan(iter, true))
+	require.NoError(t, iter.Close())
+	require.NoError(t, b.Close())
+
+	// Reading at a timestamp requires a comparer with timestamps.
+	d2, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d2.Close()) }()
+	require.Panics(t, func() { d2.NewIter(&IterOptions{ReadTimestamp: []byte("@1")}) })
+}
+
+func TestTimestampGC(t *testing.T) {
+	watermark := []byte("@4")
+	var filtered []string
+	opts := &Options{FS: vfs.NewMem(), Comparer: testkeys.Comparer}
+	opts.Experimental.Timestamps = TimestampOptions{
+		Watermark: func() []byte { return watermark },
+		Filter: func(
+			level int, prefix, timestamp, value []byte, visibleAtWatermark bool,
+		) (CompactionFilterDecision, []byte) {
+			filtered = append(filtered, fmt.Sprintf("%s%s:%t", prefix, timestamp, visibleAtWatermark))
+			// A visible deletion marker is collected along with the older
+			// versions.
+			if visibleAtWatermark && string(value) == "deleted" {
+				return CompactionFilterRemove, nil
+			}
+			return CompactionFilterKeep, nil
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// a@3 is the version of a visible at the watermark, so a@1 is collected.
+	// b@3 is deleted, so b@1 remains visible. c@2 is a deletion marker, which
+	// is collected along with c@1. d is unversioned.
+	for _, k := range []string{"a@1", "a@3", "a@5", "b@1", "b@3", "c@1", "d"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Set([]byte("c@2"), []byte("deleted"), nil))
+	require.NoError(t, d.Delete([]byte("b@3"), nil))
+	require.NoError(t, d.Flush())
+	// The deleted b@3 is filtered, as are the versions of the keys shadowed by
+	// newer internal keys.
+	require.Equal(t, []string{"a@5:false", "a@3:true", "b@3:false", "b@1:true", "c@2:true"}, filtered)
+
+	keys := func() []string {
+		var keys []string
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		return keys
+	}
+	require.Equal(t, []string{"a@5", "a@3", "b@1", "d"}, keys())
+
+	// Raising the watermark collects a@3 once compacted. The overlapping
+	// flushed table ensures that the first is rewritten rather than moved.
+	watermark = []byte("@5")
+	filtered = nil
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("e"), true /* parallelize */))
+	require.Equal(t, []string{"a@5", "b", "b@1", "d"}, keys())
+	require.Equal(t, []string{"a@5:true", "b@1:true"}, filtered)
+
+	// The collected versions and their tombstones were elided by the
+	// compaction into the bottommost level.
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	var entries uint64
+	for level := range tables {
+		for _, table := range tables[level] {
+			require.Equal(t, numLevels-1, level)
+			entries += table.Properties.NumEntries
+		}
+	}
+	require.Equal(t, uint64(4), entries)
+
+	// Timestamps require a comparer with timestamps.
+	_, err = Open("", &Options{FS: vfs.NewMem(), Experimental: opts.Experimental})
+	require.Error(t, err)
+}
+
+func TestTimestampGCSnapshots(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), Comparer: testkeys.Comparer}
+	opts.Experimental.Timestamps = TimestampOptions{
+		Watermark: func() []byte { return []byte("@4") },
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	keys := func(r Reader) []string {
+		var keys []string
+		iter := r.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		return keys
+	}
+
+	// a@3 collects a@1, except from the snapshot taken before a@3 was
+	// written. b@3 was written before the snapshot, and collects b@1.
+	require.NoError(t, d.Set([]byte("a@1"), nil, nil))
+	require.NoError(t, d.Set([]byte("b@1"), nil, nil))
+	require.NoError(t, d.Set([]byte("b@3"), nil, nil))
+	snap := d.NewSnapshot()
+	require.NoError(t, d.Set([]byte("a@3"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, []string{"a@1", "b@3"}, keys(snap))
+	require.Equal(t, []string{"a@3", "a@1", "b@3"}, keys(d))
+
+	// Once the snapshot is closed, a@1 is collected. The overlapping flushed
+	// table ensures that the first is rewritten rather than moved.
+	require.NoError(t, snap.Close())
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	require.Equal(t, []string{"a@3", "b", "b@3"}, keys(d))
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	var entries uint64
+	for level := range tables {
+		for _, table := range tables[level] {
+			entries += table.Properties.NumEntries
+		}
+	}
+	require.Equal(t, uint64(3), entries)
+}
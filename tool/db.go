@@ -47,6 +47,8 @@ type dbT struct {
 	// Flags.
 	comparerName  string
 	mergerName    string
+	walDir        string
+	tableDir      string
 	fmtKey        keyFormatter
 	fmtValue      valueFormatter
 	start         key
@@ -176,6 +178,15 @@ specified database.
 			&d.mergerName, "merger", "", "merger name (use default if empty)")
 	}
 
+	for _, cmd := range []*cobra.Command{
+		d.Check, d.Checkpoint, d.Get, d.LSM, d.Properties, d.Scan, d.Set, d.Space, d.IOBench,
+	} {
+		cmd.Flags().StringVar(
+			&d.walDir, "wal-dir", "", "directory containing the WALs (the DB directory if empty)")
+		cmd.Flags().StringVar(
+			&d.tableDir, "table-dir", "", "directory containing the sstables (the DB directory if empty)")
+	}
+
 	for _, cmd := range []*cobra.Command{d.Scan, d.Space} {
 		cmd.Flags().Var(
 			&d.start, "start", "start key for the range")
@@ -297,6 +308,12 @@ func (d *dbT) openDB(dir string, openOptions ...openOption) (*pebble.DB, error)
 		}
 	}
 	opts := *d.opts
+	if d.walDir != "" {
+		opts.WALDir = d.walDir
+	}
+	if d.tableDir != "" {
+		opts.TableDir = d.tableDir
+	}
 	for _, opt := range openOptions {
 		opt.apply(&opts)
 	}
@@ -518,7 +535,11 @@ func (d *dbT) runProperties(cmd *cobra.Command, args []string) {
 			return err
 		}
 
-		objProvider, err := objstorageprovider.Open(objstorageprovider.DefaultSettings(d.opts.FS, dirname))
+		tableDirname := dirname
+		if d.tableDir != "" {
+			tableDirname = d.tableDir
+		}
+		objProvider, err := objstorageprovider.Open(objstorageprovider.DefaultSettings(d.opts.FS, tableDirname))
 		if err != nil {
 			return err
 		}
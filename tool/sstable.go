@@ -351,6 +351,12 @@ func (s *sstableT) runProperties(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(tw, "  whole-key\t%t\n", r.Properties.WholeKeyFiltering)
 		fmt.Fprintf(tw, "compression\t%s\n", r.Properties.CompressionName)
 		fmt.Fprintf(tw, "  options\t%s\n", r.Properties.CompressionOptions)
+		if r.Properties.ZstdLevel > 0 {
+			fmt.Fprintf(tw, "  zstd level\t%d\n", r.Properties.ZstdLevel)
+		}
+		if r.Properties.ZstdWindowLog > 0 {
+			fmt.Fprintf(tw, "  zstd window log\t%d\n", r.Properties.ZstdWindowLog)
+		}
 		fmt.Fprintf(tw, "user properties\t\n")
 		fmt.Fprintf(tw, "  collectors\t%s\n", r.Properties.PropertyCollectorNames)
 		keys := make([]string, 0, len(r.Properties.UserProperties))
@@ -0,0 +1,99 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "context"
+
+// Span names and attribute keys of the spans started by a DB (see
+// Options.Tracer). The spans of the blocks read from sstables are described
+// by sstable.ReadBlockSpan.
+const (
+	// GetSpan is the name of the span of a Get.
+	GetSpan = "pebble.get"
+	// FlushSpan is the name of the span of a flush.
+	FlushSpan = "pebble.flush"
+	// CompactionSpan is the name of the span of a compaction.
+	CompactionSpan = "pebble.compaction"
+
+	// FoundAttribute is set on the span of a Get to whether the key was
+	// found.
+	FoundAttribute = "pebble.found"
+	// JobIDAttribute is the job ID of a flush or compaction, as in the events
+	// of the EventListener.
+	JobIDAttribute = "pebble.job_id"
+	// ReasonAttribute is the reason for a compaction.
+	ReasonAttribute = "pebble.reason"
+	// InputLevelsAttribute and InputFilesAttribute are the levels and the file
+	// numbers of the sstables read by a compaction.
+	InputLevelsAttribute = "pebble.input.levels"
+	InputFilesAttribute  = "pebble.input.files"
+	// InputMemtablesAttribute is the number of memtables read by a flush.
+	InputMemtablesAttribute = "pebble.input.memtables"
+	// OutputLevelAttribute, OutputFilesAttribute and OutputBytesAttribute are
+	// the level, the file numbers and the size of the sstables written by a
+	// flush or compaction.
+	OutputLevelAttribute = "pebble.output.level"
+	OutputFilesAttribute = "pebble.output.files"
+	OutputBytesAttribute = "pebble.output.bytes"
+)
+
+// startFlushSpan starts the span of the flush that's beginning.
+func (d *DB) startFlushSpan(info FlushInfo) Span {
+	_, span := d.opts.Tracer.StartSpan(context.Background(), FlushSpan)
+	span.SetAttribute(JobIDAttribute, info.JobID)
+	span.SetAttribute(InputMemtablesAttribute, info.Input)
+	if !info.Ingest {
+		span.SetAttribute(OutputLevelAttribute, 0)
+	}
+	return span
+}
+
+// endFlushSpan ends the span of the flush that's done.
+func endFlushSpan(span Span, info FlushInfo) {
+	setOutputSpanAttributes(span, info.Output)
+	if info.Err != nil {
+		span.RecordError(info.Err)
+	}
+	span.End()
+}
+
+// startCompactionSpan starts the span of the compaction that's beginning.
+func (d *DB) startCompactionSpan(info CompactionInfo) Span {
+	_, span := d.opts.Tracer.StartSpan(context.Background(), CompactionSpan)
+	span.SetAttribute(JobIDAttribute, info.JobID)
+	span.SetAttribute(ReasonAttribute, info.Reason)
+	levels := make([]int, 0, len(info.Input))
+	var files []uint64
+	for _, l := range info.Input {
+		levels = append(levels, l.Level)
+		for _, t := range l.Tables {
+			files = append(files, uint64(t.FileNum))
+		}
+	}
+	span.SetAttribute(InputLevelsAttribute, levels)
+	span.SetAttribute(InputFilesAttribute, files)
+	span.SetAttribute(OutputLevelAttribute, info.Output.Level)
+	return span
+}
+
+// endCompactionSpan ends the span of the compaction that's done.
+func endCompactionSpan(span Span, info CompactionInfo) {
+	setOutputSpanAttributes(span, info.Output.Tables)
+	if info.Err != nil {
+		span.RecordError(info.Err)
+	}
+	span.End()
+}
+
+func setOutputSpanAttributes(span Span, tables []TableInfo) {
+	files := make([]uint64, 0, len(tables))
+	var size uint64
+	for _, t := range tables {
+		files = append(files, uint64(t.FileNum))
+		size += t.Size
+	}
+	span.SetAttribute(OutputFilesAttribute, files)
+	span.SetAttribute(OutputBytesAttribute, size)
+}
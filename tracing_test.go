@@ -0,0 +1,164 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedSpanKey struct{}
+
+// recordedSpan is a span recorded by spanRecorder.
+type recordedSpan struct {
+	tracer     *spanRecorder
+	name       string
+	parent     *recordedSpan
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended = true
+}
+
+// spanRecorder records the spans started in contexts that carry a span, and the
+// root spans of flushes and compactions.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *spanRecorder) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent, _ := ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	s := &recordedSpan{tracer: t, name: name, parent: parent, attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+func (t *spanRecorder) IsTracingEnabled(ctx context.Context) bool {
+	return ctx.Value(recordedSpanKey{}) != nil
+}
+
+func (t *spanRecorder) find(name string) []*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var spans []*recordedSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestTracerSpans(t *testing.T) {
+	tracer := &spanRecorder{}
+	// Disable the block cache, so that every block is read from storage.
+	cache := NewCache(0)
+	defer cache.Unref()
+	d, err := Open("", &Options{
+		Cache:  cache,
+		FS:     vfs.NewMem(),
+		Tracer: tracer,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v"), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("k"), []byte("l"), false /* parallelize */))
+
+	// The flush and the compaction are traced with root spans.
+	flushes := tracer.find(FlushSpan)
+	require.Len(t, flushes, 1)
+	require.True(t, flushes[0].ended)
+	require.Nil(t, flushes[0].parent)
+	require.Equal(t, 0, flushes[0].attributes[OutputLevelAttribute])
+	require.Len(t, flushes[0].attributes[OutputFilesAttribute], 1)
+	flushed := flushes[0].attributes[OutputFilesAttribute].([]uint64)[0]
+
+	compactions := tracer.find(CompactionSpan)
+	require.Len(t, compactions, 1)
+	require.True(t, compactions[0].ended)
+	require.Equal(t, []uint64{flushed}, compactions[0].attributes[InputFilesAttribute])
+	require.Equal(t, 6, compactions[0].attributes[OutputLevelAttribute])
+	require.Len(t, compactions[0].attributes[OutputFilesAttribute], 1)
+	compacted := compactions[0].attributes[OutputFilesAttribute].([]uint64)[0]
+
+	// Gets aren't traced without a span in their context.
+	v, closer, err := d.Get([]byte("k042"))
+	require.NoError(t, err)
+	require.Equal(t, "v", string(v))
+	require.NoError(t, closer.Close())
+	require.Empty(t, tracer.find(GetSpan))
+
+	// A traced Get is a child of the span of its context, and the blocks it
+	// reads from storage are children of the Get.
+	ctx, root := tracer.StartSpan(context.Background(), "root")
+	v, closer, err = d.GetWithContext(ctx, []byte("k042"))
+	require.NoError(t, err)
+	require.Equal(t, "v", string(v))
+	require.NoError(t, closer.Close())
+	_, _, err = d.GetWithContext(ctx, []byte("missing"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	gets := tracer.find(GetSpan)
+	require.Len(t, gets, 2)
+	for i, found := range []bool{true, false} {
+		require.True(t, gets[i].ended)
+		require.Equal(t, root, gets[i].parent)
+		require.Equal(t, found, gets[i].attributes[FoundAttribute])
+		require.NoError(t, gets[i].err)
+	}
+	reads := tracer.find(sstable.ReadBlockSpan)
+	require.NotEmpty(t, reads)
+	for _, s := range reads {
+		require.True(t, s.ended)
+		require.Equal(t, gets[0], s.parent)
+		require.Equal(t, compacted, s.attributes[sstable.FileNumAttribute])
+		require.Equal(t, 6, s.attributes[sstable.LevelAttribute])
+	}
+
+	// The blocks read by traced iterators are children of the span of their
+	// context.
+	n := len(reads)
+	iter := d.NewIterWithContext(ctx, nil)
+	for valid := iter.First(); valid; valid = iter.Next() {
+	}
+	require.NoError(t, iter.Close())
+	reads = tracer.find(sstable.ReadBlockSpan)
+	require.Greater(t, len(reads), n)
+	for _, s := range reads[n:] {
+		require.Equal(t, root, s.parent)
+		require.Equal(t, compacted, s.attributes[sstable.FileNumAttribute])
+		require.Equal(t, 6, s.attributes[sstable.LevelAttribute])
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// TTLOptions configures the expiration of point keys. An expired key is
+// hidden from reads as if it had been deleted, and it's replaced by a point
+// tombstone when it's compacted, reclaiming the space of its value once the
+// tombstone reaches the bottommost level containing the key.
+//
+// The expiration of a key is determined by its value, eg, a timestamp encoded
+// in the value by the application, so that the TTL may be chosen per key or
+// per write. Only the values of keys written with Set expire; merge operands
+// never expire, and merges of an expired key start from scratch.
+type TTLOptions struct {
+	// Expiration enables TTL semantics when set. It returns the time at which
+	// the given key, written with the given value, expires, or false if the
+	// key never expires. It must be deterministic. The value is read to
+	// determine the expiration of each key returned by an iterator, including
+	// values stored in value blocks that would otherwise only be read on
+	// demand.
+	Expiration func(key, value []byte) (expiresAt time.Time, ok bool)
+	// Now returns the current time used to determine whether keys have
+	// expired. Iterators and compactions determine the time once, when
+	// they're created, so that their view of the expired keys is stable.
+	// Defaults to time.Now.
+	Now func() time.Time
+}
+
+// newTTLIter wraps the given internal iterator so that the keys expired as of
+// the current time are transformed into point tombstones. It returns the
+// iterator unmodified if TTL is not enabled.
+func (o *TTLOptions) newTTLIter(iter internalIterator) internalIterator {
+	if o == nil || o.Expiration == nil {
+		return iter
+	}
+	return &ttlIter{internalIterator: iter, expiration: o.Expiration, now: o.Now()}
+}
+
+// unwrapTTLIter returns the iterator wrapped by newTTLIter, if any.
+func unwrapTTLIter(iter internalIterator) internalIterator {
+	if t, ok := iter.(*ttlIter); ok {
+		return t.internalIterator
+	}
+	return iter
+}
+
+// ttlIter is an internal iterator that transforms the SET and SETWITHDEL keys
+// of the wrapped iterator that expired as of a fixed time into DEL keys with
+// the same sequence numbers. The resulting tombstones shadow the older
+// versions of the expired keys, which expired along with the newer version.
+type ttlIter struct {
+	internalIterator
+	expiration func(key, value []byte) (time.Time, bool)
+	now        time.Time
+	key        InternalKey
+	err        error
+}
+
+var _ internalIterator = (*ttlIter)(nil)
+
+func (i *ttlIter) transform(k *InternalKey, v base.LazyValue) (*InternalKey, base.LazyValue) {
+	if k == nil {
+		return nil, base.LazyValue{}
+	}
+	if kind := k.Kind(); kind != InternalKeyKindSet && kind != InternalKeyKindSetWithDelete {
+		return k, v
+	}
+	value, _, err := v.Value(nil)
+	if err != nil {
+		i.err = err
+		return nil, base.LazyValue{}
+	}
+	if expiresAt, ok := i.expiration(k.UserKey, value); !ok || i.now.Before(expiresAt) {
+		return k, v
+	}
+	i.key = base.MakeInternalKey(k.UserKey, k.SeqNum(), InternalKeyKindDelete)
+	return &i.key, base.LazyValue{}
+}
+
+// SeekGE implements base.InternalIterator.
+func (i *ttlIter) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.transform(i.internalIterator.SeekGE(key, flags))
+}
+
+// SeekPrefixGE implements base.InternalIterator.
+func (i *ttlIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.transform(i.internalIterator.SeekPrefixGE(prefix, key, flags))
+}
+
+// SeekLT implements base.InternalIterator.
+func (i *ttlIter) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.transform(i.internalIterator.SeekLT(key, flags))
+}
+
+// First implements base.InternalIterator.
+func (i *ttlIter) First() (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.transform(i.internalIterator.First())
+}
+
+// Last implements base.InternalIterator.
+func (i *ttlIter) Last() (*InternalKey, base.LazyValue) {
+	i.err = nil
+	return i.transform(i.internalIterator.Last())
+}
+
+// Next implements base.InternalIterator.
+func (i *ttlIter) Next() (*InternalKey, base.LazyValue) {
+	return i.transform(i.internalIterator.Next())
+}
+
+// NextPrefix implements base.InternalIterator.
+func (i *ttlIter) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	return i.transform(i.internalIterator.NextPrefix(succKey))
+}
+
+// Prev implements base.InternalIterator.
+func (i *ttlIter) Prev() (*InternalKey, base.LazyValue) {
+	return i.transform(i.internalIterator.Prev())
+}
+
+// Error implements base.InternalIterator.
+func (i *ttlIter) Error() error {
+	return firstError(i.err, i.internalIterator.Error())
+}
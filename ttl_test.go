@@ -0,0 +1,91 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTL(t *testing.T) {
+	// Values are prefixed with their expiration time, in seconds, or zero if
+	// they never expire.
+	encode := func(expiresAt int64, v string) []byte {
+		buf := binary.BigEndian.AppendUint64(nil, uint64(expiresAt))
+		return append(buf, v...)
+	}
+	var now int64
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Logger:                      panicLogger{},
+	}
+	opts.Experimental.TTL = TTLOptions{
+		Expiration: func(key, value []byte) (time.Time, bool) {
+			expiresAt := int64(binary.BigEndian.Uint64(value))
+			return time.Unix(expiresAt, 0), expiresAt != 0
+		},
+		Now: func() time.Time { return time.Unix(now, 0) },
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	scan := func(iter *Iterator) string {
+		var buf strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s=%s ", iter.Key(), iter.Value()[8:])
+		}
+		for valid := iter.Last(); valid; valid = iter.Prev() {
+			fmt.Fprintf(&buf, "%s ", iter.Key())
+		}
+		require.NoError(t, iter.Close())
+		return strings.TrimSpace(buf.String())
+	}
+	get := func(k string) string {
+		v, closer, err := d.Get([]byte(k))
+		if err == ErrNotFound {
+			return "not found"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v[8:])
+	}
+
+	require.NoError(t, d.Set([]byte("a"), encode(100, "a1"), nil))
+	require.NoError(t, d.Set([]byte("b"), encode(0, "b1"), nil))
+	// The expired newer version of c shadows the older version, which never
+	// expires.
+	require.NoError(t, d.Set([]byte("c"), encode(0, "c1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("c"), encode(100, "c2"), nil))
+	require.NoError(t, d.Set([]byte("d"), encode(200, "d1"), nil))
+
+	now = 50
+	require.Equal(t, "a=a1 b=b1 c=c2 d=d1 d c b a", scan(d.NewIter(nil)))
+	stable := d.NewIter(nil)
+
+	now = 150
+	require.Equal(t, "b=b1 d=d1 d b", scan(d.NewIter(nil)))
+	require.Equal(t, "not found", get("a"))
+	require.Equal(t, "b1", get("b"))
+	require.Equal(t, "not found", get("c"))
+	require.Equal(t, "d1", get("d"))
+	// Iterators determine the expired keys when they're created.
+	require.Equal(t, "a=a1 b=b1 c=c2 d=d1 d c b a", scan(stable))
+
+	// Compactions drop the expired keys, which remain deleted if the clock
+	// goes back.
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	now = 0
+	require.Equal(t, "b=b1 d=d1 d b", scan(d.NewIter(nil)))
+	require.Equal(t, "not found", get("c"))
+}
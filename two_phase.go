@@ -0,0 +1,421 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+var (
+	// ErrPreparedNotFound is returned by DB.CommitPrepared and
+	// DB.RollbackPrepared when no batch is prepared under the transaction ID,
+	// or when it's being committed or rolled back concurrently.
+	ErrPreparedNotFound = errors.New("pebble: prepared batch not found")
+	// ErrPreparedExists is returned by Batch.Prepare when a batch is already
+	// prepared under the transaction ID.
+	ErrPreparedExists = errors.New("pebble: prepared batch already exists")
+)
+
+// The records of two-phase commits are written to the WAL as batches holding
+// a single LogData entry, whose data starts with preparedRecordMagic followed
+// by the kind of the record and the transaction ID:
+//
+//   - A prepare record holds the representation of the prepared batch, and
+//     doesn't consume any sequence number.
+//   - A commit record consumes the sequence numbers of the prepared batch,
+//     which is applied at these sequence numbers in place of the record. It
+//     holds the representation of the writes derived from the prepared batch
+//     by the batch apply hooks, if any, which are applied along with it.
+//   - A rollback record forgets the prepared batch.
+//
+// Versions of Pebble unaware of two-phase commits ignore these records as
+// any other LogData, and thus the batches committed by the commit records in
+// the WALs they replay.
+const preparedRecordMagic = "\x00pebble.prepared\x00"
+
+type preparedRecordKind byte
+
+const (
+	preparedRecordPrepare preparedRecordKind = iota + 1
+	preparedRecordCommit
+	preparedRecordRollback
+)
+
+// preparedBatch is a batch prepared by Batch.Prepare.
+type preparedBatch struct {
+	xid []byte
+	// repr is the representation of the batch, with a zero sequence number.
+	repr []byte
+	// seq orders the prepared batches by the time they were prepared.
+	seq uint64
+	// resolving is set while the batch is being committed or rolled back.
+	resolving bool
+}
+
+// twoPhaseOp is set on the batches committed by Batch.Prepare,
+// DB.CommitPrepared and DB.RollbackPrepared, whose prepared batch is
+// registered or resolved by DB.commitWrite.
+type twoPhaseOp struct {
+	kind     preparedRecordKind
+	prepared *preparedBatch
+}
+
+func encodePreparedRecord(kind preparedRecordKind, xid, repr []byte) []byte {
+	buf := make([]byte, 0, len(preparedRecordMagic)+1+binary.MaxVarintLen64+len(xid)+len(repr))
+	buf = append(buf, preparedRecordMagic...)
+	buf = append(buf, byte(kind))
+	buf = binary.AppendUvarint(buf, uint64(len(xid)))
+	buf = append(buf, xid...)
+	return append(buf, repr...)
+}
+
+// decodePreparedRecord decodes the record of a two-phase commit held by the
+// given batch representation, if any.
+func decodePreparedRecord(
+	repr []byte,
+) (kind preparedRecordKind, xid, preparedRepr []byte, ok bool, err error) {
+	r, _ := ReadBatch(repr)
+	k, data, _, ok := r.Next()
+	if !ok || k != InternalKeyKindLogData || !bytes.HasPrefix(data, []byte(preparedRecordMagic)) {
+		return 0, nil, nil, false, nil
+	}
+	if len(r) != 0 {
+		return 0, nil, nil, false, nil
+	}
+	data = data[len(preparedRecordMagic):]
+	if len(data) == 0 {
+		return 0, nil, nil, false, base.CorruptionErrorf("pebble: corrupt prepared batch record")
+	}
+	kind, data = preparedRecordKind(data[0]), data[1:]
+	n, m := binary.Uvarint(data)
+	if m <= 0 || uint64(len(data)-m) < n {
+		return 0, nil, nil, false, base.CorruptionErrorf("pebble: corrupt prepared batch record")
+	}
+	xid, preparedRepr = data[m:m+int(n)], data[m+int(n):]
+	switch kind {
+	case preparedRecordPrepare:
+		if len(preparedRepr) < batchHeaderLen {
+			return 0, nil, nil, false, base.CorruptionErrorf("pebble: corrupt prepared batch record")
+		}
+	case preparedRecordCommit:
+		if len(preparedRepr) != 0 && len(preparedRepr) < batchHeaderLen {
+			return 0, nil, nil, false, base.CorruptionErrorf("pebble: corrupt prepared batch record")
+		}
+	case preparedRecordRollback:
+	default:
+		return 0, nil, nil, false, base.CorruptionErrorf(
+			"pebble: unknown prepared batch record kind %d", errors.Safe(kind))
+	}
+	return kind, xid, preparedRepr, true, nil
+}
+
+// Prepare persists the batch in the WAL as prepared under the given
+// transaction ID, without applying it. The batch is later committed by
+// DB.CommitPrepared, or discarded by DB.RollbackPrepared, which only write
+// small records to the WAL referencing the transaction ID. This allows
+// external transaction coordinators to implement two-phase commits without
+// buffering the data of the batch, or writing it twice.
+//
+// The prepared batches survive restarts, and DB.Prepared lists them, so that
+// the coordinator can resolve them. A prepared batch holds no locks: the keys
+// it writes may be written by other batches before it's committed, which
+// then shadows them. The batch validators run when the batch is prepared,
+// and again along with the batch apply hooks when it's committed, so that the
+// hooks don't observe the batches rolled back. The batch must not be
+// committed after Prepare, and must still be closed.
+//
+// Prepare requires the WAL, and the DB to be at format major version
+// FormatTwoPhaseCommit or later. The WriteOptions apply to the prepare record, and
+// should specify Sync for the prepared batch to be durable when Prepare
+// returns.
+func (b *Batch) Prepare(xid []byte, opts *WriteOptions) error {
+	if b.db == nil {
+		return errors.New("pebble: batch not created by a DB")
+	}
+	return b.db.prepare(xid, b, opts)
+}
+
+func (d *DB) prepare(xid []byte, batch *Batch, opts *WriteOptions) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if batch.applied.Load() {
+		panic("pebble: batch already applied")
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if d.opts.DisableWAL {
+		return errors.New("pebble: prepared batches require the WAL")
+	}
+	if v := d.FormatMajorVersion(); v < FormatTwoPhaseCommit {
+		return errors.Errorf(
+			"pebble: two-phase commits require at least format major version %d (current: %d)",
+			FormatTwoPhaseCommit, v,
+		)
+	}
+	d.mu.Lock()
+	_, exists := d.mu.prepared[string(xid)]
+	if _, preparing := d.mu.preparing[string(xid)]; exists || preparing {
+		d.mu.Unlock()
+		return ErrPreparedExists
+	}
+	d.mu.preparing[string(xid)] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.mu.preparing, string(xid))
+		d.mu.Unlock()
+	}()
+
+	if d.opts.Experimental.BatchValidator != nil {
+		if err := d.validateBatch(batch); err != nil {
+			return err
+		}
+	}
+	if d.opts.Experimental.KeySchema.enabled() {
+		if err := d.validateBatchKeys(batch); err != nil {
+			return err
+		}
+	}
+	if batch.countRangeKeys > 0 && d.split == nil {
+		return errNoSplit
+	}
+
+	p := &preparedBatch{
+		xid:  append([]byte(nil), xid...),
+		repr: append([]byte(nil), batch.Repr()...),
+	}
+	binary.LittleEndian.PutUint64(p.repr[:8], 0)
+
+	b := newBatch(d)
+	defer b.Close()
+	_ = b.LogData(encodePreparedRecord(preparedRecordPrepare, p.xid, p.repr), nil)
+	b.twoPhase = &twoPhaseOp{kind: preparedRecordPrepare, prepared: p}
+	if err := d.Apply(b, opts); err != nil {
+		return err
+	}
+	batch.applied.Store(true)
+	return nil
+}
+
+// CommitPrepared commits the batch prepared under the given transaction ID
+// by Batch.Prepare. The prepared batch is applied at new sequence numbers,
+// as if it had been committed by Batch.Commit, while the WAL only records
+// the commit of the transaction ID. It returns ErrPreparedNotFound if no
+// batch is prepared under the transaction ID.
+func (d *DB) CommitPrepared(xid []byte, opts *WriteOptions) error {
+	p, err := d.startResolvingPrepared(xid)
+	if err != nil {
+		return err
+	}
+	b := newBatch(d)
+	defer b.Close()
+	b.SetRepr(append([]byte(nil), p.repr...))
+	b.twoPhase = &twoPhaseOp{kind: preparedRecordCommit, prepared: p}
+	return d.applyResolvingPrepared(p, b, opts)
+}
+
+// RollbackPrepared discards the batch prepared under the given transaction
+// ID by Batch.Prepare. It returns ErrPreparedNotFound if no batch is
+// prepared under the transaction ID.
+func (d *DB) RollbackPrepared(xid []byte, opts *WriteOptions) error {
+	p, err := d.startResolvingPrepared(xid)
+	if err != nil {
+		return err
+	}
+	b := newBatch(d)
+	defer b.Close()
+	_ = b.LogData(encodePreparedRecord(preparedRecordRollback, p.xid, nil), nil)
+	b.twoPhase = &twoPhaseOp{kind: preparedRecordRollback, prepared: p}
+	return d.applyResolvingPrepared(p, b, opts)
+}
+
+// Prepared returns the transaction IDs of the batches prepared by
+// Batch.Prepare that have been neither committed nor rolled back, including
+// the batches recovered from the WAL when the DB was opened, in the order
+// they were prepared.
+func (d *DB) Prepared() [][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prepared := d.sortedPreparedLocked()
+	xids := make([][]byte, len(prepared))
+	for i, p := range prepared {
+		xids[i] = append([]byte(nil), p.xid...)
+	}
+	return xids
+}
+
+func (d *DB) startResolvingPrepared(xid []byte) (*preparedBatch, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if v := d.FormatMajorVersion(); v < FormatTwoPhaseCommit {
+		return nil, errors.Errorf(
+			"pebble: two-phase commits require at least format major version %d (current: %d)",
+			FormatTwoPhaseCommit, v,
+		)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.mu.prepared[string(xid)]
+	if !ok || p.resolving {
+		return nil, ErrPreparedNotFound
+	}
+	p.resolving = true
+	return p, nil
+}
+
+func (d *DB) applyResolvingPrepared(p *preparedBatch, b *Batch, opts *WriteOptions) error {
+	err := d.Apply(b, opts)
+	if err != nil {
+		d.mu.Lock()
+		p.resolving = false
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// commitRecord returns the representation of the commit record written to
+// the WAL in place of the given batch, which holds the prepared batch followed
+// by the writes derived from it by the batch apply hooks. The record holds a
+// single LogData entry, but consumes the sequence numbers of the batch.
+func (op *twoPhaseOp) commitRecord(b *Batch) []byte {
+	var derived []byte
+	if body := b.Repr()[len(op.prepared.repr):]; len(body) > 0 {
+		n := b.Count() - binary.LittleEndian.Uint32(op.prepared.repr[8:batchHeaderLen])
+		derived = make([]byte, batchHeaderLen, batchHeaderLen+len(body))
+		binary.LittleEndian.PutUint32(derived[8:batchHeaderLen], n)
+		derived = append(derived, body...)
+	}
+	var rec Batch
+	_ = rec.LogData(encodePreparedRecord(preparedRecordCommit, op.prepared.xid, derived), nil)
+	repr := rec.Repr()
+	copy(repr[:8], b.seqNumData())
+	binary.LittleEndian.PutUint32(repr[8:batchHeaderLen], b.Count())
+	return repr
+}
+
+// applyTwoPhaseLocked registers or resolves the prepared batch of a batch
+// whose record is about to be written to the WAL. It's called by
+// DB.commitWrite, so that the prepared batches rewritten to a new WAL by
+// relogPreparedLocked are exactly those prepared by the preceding records.
+//
+// d.mu must be held when calling this.
+func (d *DB) applyTwoPhaseLocked(op *twoPhaseOp) {
+	switch op.kind {
+	case preparedRecordPrepare:
+		d.mu.preparedSeq++
+		op.prepared.seq = d.mu.preparedSeq
+		d.mu.prepared[string(op.prepared.xid)] = op.prepared
+	case preparedRecordCommit, preparedRecordRollback:
+		delete(d.mu.prepared, string(op.prepared.xid))
+	}
+}
+
+// replayPreparedRecordLocked applies a record of a two-phase commit found
+// while replaying the WAL. For a commit record, it returns the representation
+// of the committed batch, to be applied in place of the record.
+//
+// d.mu must be held when calling this.
+func (d *DB) replayPreparedRecordLocked(
+	kind preparedRecordKind, xid, repr []byte, seqNum uint64, count uint32,
+) ([]byte, error) {
+	switch kind {
+	case preparedRecordPrepare:
+		if _, ok := d.mu.prepared[string(xid)]; ok {
+			// The batch was rewritten to a newer WAL by relogPreparedLocked.
+			return nil, nil
+		}
+		d.mu.preparedSeq++
+		d.mu.prepared[string(xid)] = &preparedBatch{
+			xid:  append([]byte(nil), xid...),
+			repr: append([]byte(nil), repr...),
+			seq:  d.mu.preparedSeq,
+		}
+	case preparedRecordRollback:
+		delete(d.mu.prepared, string(xid))
+	case preparedRecordCommit:
+		p, ok := d.mu.prepared[string(xid)]
+		if !ok {
+			return nil, base.CorruptionErrorf("pebble: commit of unknown prepared batch %q", xid)
+		}
+		delete(d.mu.prepared, string(xid))
+		committed := append([]byte(nil), p.repr...)
+		binary.LittleEndian.PutUint64(committed[:8], seqNum)
+		n := binary.LittleEndian.Uint32(committed[8:batchHeaderLen])
+		if len(repr) > 0 {
+			// The writes derived from the prepared batch by the batch apply
+			// hooks.
+			committed = append(committed, repr[batchHeaderLen:]...)
+			n += binary.LittleEndian.Uint32(repr[8:batchHeaderLen])
+			binary.LittleEndian.PutUint32(committed[8:batchHeaderLen], n)
+		}
+		if n != count {
+			return nil, base.CorruptionErrorf(
+				"pebble: commit of prepared batch %q has count %d, expected %d", xid, count, n)
+		}
+		return committed, nil
+	}
+	return nil, nil
+}
+
+func (d *DB) sortedPreparedLocked() []*preparedBatch {
+	prepared := make([]*preparedBatch, 0, len(d.mu.prepared))
+	for _, p := range d.mu.prepared {
+		prepared = append(prepared, p)
+	}
+	sort.Slice(prepared, func(i, j int) bool {
+		return prepared[i].seq < prepared[j].seq
+	})
+	return prepared
+}
+
+// relogPreparedLocked rewrites the prepare records of the prepared batches
+// to a new WAL, and waits for them to be synced, so that the prepared batches
+// survive the deletion of the WALs that held their original records once
+// the memtables of these WALs are flushed.
+//
+// d.mu must be held when calling this.
+func (d *DB) relogPreparedLocked() error {
+	if len(d.mu.prepared) == 0 {
+		return nil
+	}
+	// The last record is synced along with the records written before it. The
+	// sync reserves a slot in the sync queue, as the commit pipeline does.
+	prepared := d.sortedPreparedLocked()
+	var b Batch
+	for i, p := range prepared {
+		b.Reset()
+		_ = b.LogData(encodePreparedRecord(preparedRecordPrepare, p.xid, p.repr), nil)
+		b.setSeqNum(d.mu.versions.logSeqNum.Load())
+		if i < len(prepared)-1 {
+			if _, err := d.mu.log.WriteRecord(b.Repr()); err != nil {
+				return err
+			}
+			continue
+		}
+		var wg sync.WaitGroup
+		var syncErr error
+		wg.Add(1)
+		d.commit.logSyncQSem <- struct{}{}
+		if _, _, err := d.mu.log.SyncRecord(b.Repr(), &wg, &syncErr); err != nil {
+			<-d.commit.logSyncQSem
+			return err
+		}
+		wg.Wait()
+		return syncErr
+	}
+	return nil
+}
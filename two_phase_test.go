@@ -0,0 +1,214 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoPhaseCommit(t *testing.T) {
+	mem := vfs.NewMem()
+	open := func() *DB {
+		d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatTwoPhaseCommit})
+		require.NoError(t, err)
+		return d
+	}
+	get := func(d *DB, key string) string {
+		v, closer, err := d.Get([]byte(key))
+		if err == ErrNotFound {
+			return ""
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	prepare := func(d *DB, xid string, kvs ...string) error {
+		b := d.NewBatch()
+		defer b.Close()
+		for i := 0; i < len(kvs); i += 2 {
+			require.NoError(t, b.Set([]byte(kvs[i]), []byte(kvs[i+1]), nil))
+		}
+		return b.Prepare([]byte(xid), Sync)
+	}
+	xids := func(d *DB) []string {
+		var xids []string
+		for _, xid := range d.Prepared() {
+			xids = append(xids, string(xid))
+		}
+		return xids
+	}
+
+	// Two-phase commits require FormatTwoPhaseCommit.
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatTwoPhaseCommit - 1})
+	require.NoError(t, err)
+	require.Error(t, prepare(d, "tx1", "a", "1"))
+	require.Error(t, d.CommitPrepared([]byte("tx1"), Sync))
+	require.NoError(t, d.Close())
+
+	d = open()
+	require.NoError(t, prepare(d, "tx1", "a", "1", "b", "1"))
+	require.NoError(t, prepare(d, "tx2", "c", "2"))
+	require.ErrorIs(t, prepare(d, "tx1", "d", "1"), ErrPreparedExists)
+	require.Equal(t, []string{"tx1", "tx2"}, xids(d))
+
+	// Prepared batches aren't visible until committed.
+	require.Equal(t, "", get(d, "a"))
+	require.NoError(t, d.CommitPrepared([]byte("tx1"), Sync))
+	require.Equal(t, "1", get(d, "a"))
+	require.Equal(t, "1", get(d, "b"))
+	require.ErrorIs(t, d.CommitPrepared([]byte("tx1"), Sync), ErrPreparedNotFound)
+	require.Equal(t, []string{"tx2"}, xids(d))
+
+	// The committed batch shadows the writes committed since it was prepared.
+	require.NoError(t, prepare(d, "tx3", "e", "3"))
+	require.NoError(t, d.Set([]byte("e"), []byte("set"), Sync))
+	require.NoError(t, d.CommitPrepared([]byte("tx3"), Sync))
+	require.Equal(t, "3", get(d, "e"))
+
+	require.NoError(t, prepare(d, "tx4", "f", "4"))
+	require.NoError(t, d.RollbackPrepared([]byte("tx4"), Sync))
+	require.ErrorIs(t, d.RollbackPrepared([]byte("tx4"), Sync), ErrPreparedNotFound)
+	require.Equal(t, "", get(d, "f"))
+
+	// Prepared, committed and rolled back batches are recovered from the WAL.
+	require.NoError(t, prepare(d, "tx5", "g", "5"))
+	require.NoError(t, d.Close())
+	d = open()
+	require.Equal(t, []string{"tx2", "tx5"}, xids(d))
+	require.Equal(t, "1", get(d, "a"))
+	require.Equal(t, "3", get(d, "e"))
+	require.Equal(t, "", get(d, "f"))
+	require.Equal(t, "", get(d, "g"))
+
+	// Prepared batches survive the deletion of the WALs holding their prepare
+	// records, once flushed.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, d.Set([]byte("h"), []byte("h"), Sync))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.CommitPrepared([]byte("tx2"), Sync))
+	require.NoError(t, d.Close())
+	d = open()
+	require.Equal(t, []string{"tx5"}, xids(d))
+	require.Equal(t, "2", get(d, "c"))
+	require.NoError(t, d.CommitPrepared([]byte("tx5"), Sync))
+	require.Equal(t, "5", get(d, "g"))
+	require.NoError(t, d.Close())
+
+	d = open()
+	require.Empty(t, xids(d))
+	require.Equal(t, "5", get(d, "g"))
+	require.NoError(t, d.Close())
+}
+
+func TestTwoPhaseCommitHooks(t *testing.T) {
+	var observed []string
+	hook := batchApplyHookFunc(func(ops []BatchOp, derived Writer) error {
+		for _, op := range ops {
+			observed = append(observed, string(op.Key))
+			if err := derived.Set(append([]byte("idx/"), op.Key...), nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	var validated int
+	var reject bool
+	validator := batchValidatorFunc(func(cmp Compare, ops []BatchOp) error {
+		validated++
+		if reject {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+
+	mem := vfs.NewMem()
+	open := func() *DB {
+		opts := &Options{FS: mem, FormatMajorVersion: FormatTwoPhaseCommit}
+		opts.Experimental.BatchApplyHooks = []BatchApplyHook{hook}
+		opts.Experimental.BatchValidator = validator
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		return d
+	}
+	keys := func(d *DB) []string {
+		iter := d.NewIter(nil)
+		var keys []string
+		for iter.First(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		return keys
+	}
+	prepare := func(d *DB, xid, key string) {
+		b := d.NewBatch()
+		defer b.Close()
+		require.NoError(t, b.Set([]byte(key), nil, nil))
+		require.NoError(t, b.Prepare([]byte(xid), Sync))
+	}
+
+	// The batches are validated when prepared, but the hooks only observe
+	// them when committed.
+	d := open()
+	prepare(d, "tx1", "a")
+	prepare(d, "tx2", "b")
+	require.Equal(t, 2, validated)
+	require.Empty(t, observed)
+	require.NoError(t, d.RollbackPrepared([]byte("tx2"), Sync))
+	require.Equal(t, 2, validated)
+	require.Empty(t, observed)
+
+	// The batches are validated again when committed, and may be rejected.
+	reject = true
+	require.Error(t, d.CommitPrepared([]byte("tx1"), Sync))
+	require.Equal(t, 3, validated)
+	reject = false
+	require.NoError(t, d.CommitPrepared([]byte("tx1"), Sync))
+	require.Equal(t, 4, validated)
+	require.Equal(t, []string{"a", "a"}, observed)
+	require.Equal(t, []string{"a", "idx/a"}, keys(d))
+
+	// The derived writes are recovered from the commit record in the WAL.
+	require.NoError(t, d.Close())
+	d = open()
+	require.Equal(t, []string{"a", "idx/a"}, keys(d))
+	require.NoError(t, d.Close())
+}
+
+func TestPreparedRecordEncoding(t *testing.T) {
+	var b Batch
+	require.NoError(t, b.Set([]byte("k"), []byte("v"), nil))
+	for _, kind := range []preparedRecordKind{preparedRecordPrepare, preparedRecordCommit, preparedRecordRollback} {
+		var repr []byte
+		if kind == preparedRecordPrepare {
+			repr = b.Repr()
+		}
+		var rec Batch
+		require.NoError(t, rec.LogData(encodePreparedRecord(kind, []byte("xid"), repr), nil))
+		decodedKind, xid, decodedRepr, ok, err := decodePreparedRecord(rec.Repr())
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, kind, decodedKind)
+		require.Equal(t, "xid", string(xid))
+		if repr == nil {
+			require.Empty(t, decodedRepr)
+		} else {
+			require.Equal(t, repr, decodedRepr)
+		}
+	}
+
+	// Other batches, including those holding other LogData, aren't records.
+	var rec Batch
+	require.NoError(t, rec.LogData([]byte("data"), nil))
+	for _, repr := range [][]byte{b.Repr(), rec.Repr()} {
+		_, _, _, ok, err := decodePreparedRecord(repr)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+}
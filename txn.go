@@ -0,0 +1,316 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+var (
+	// ErrTxnConflict is returned by Txn.Commit when a key read or written by
+	// the transaction was written by another batch since the transaction
+	// began.
+	ErrTxnConflict = errors.New("pebble: transaction conflict")
+
+	// ErrTxnDone is returned when using a Transaction which was committed or
+	// rolled back.
+	ErrTxnDone = errors.New("pebble: transaction already committed or rolled back")
+)
+
+// Transaction is implemented by the transactions of a DB: the optimistic
+// transactions of DB.NewTxn, which fail at commit when they conflict with
+// other writes, and the pessimistic transactions of the txndb package, which
+// lock the keys they access instead. The writes of a transaction are buffered
+// until Commit. A transaction must be ended by calling either Commit or
+// Rollback, after which its methods return ErrTxnDone.
+type Transaction interface {
+	// Get gets the value for the given key, as seen by the transaction. It
+	// returns ErrNotFound if the transaction doesn't see the key.
+	Get(key []byte) ([]byte, io.Closer, error)
+	// Set sets the value for the given key.
+	Set(key, value []byte) error
+	// Delete deletes the value for the given key.
+	Delete(key []byte) error
+	// Merge merges the value for the given key.
+	Merge(key, value []byte) error
+	// Commit applies the writes of the transaction, and ends it.
+	Commit(opts *WriteOptions) error
+	// Rollback discards the writes of the transaction, and ends it. Rolling
+	// back an ended transaction is a no-op.
+	Rollback()
+}
+
+var _ Transaction = (*Txn)(nil)
+
+// Txn is an optimistic transaction. Reads observe a consistent snapshot of
+// the DB taken when the transaction began, along with the transaction's own
+// writes, which are buffered in an indexed batch until Commit. Commit applies
+// the batch only if none of the keys read or written by the transaction were
+// written by another batch since the snapshot was taken; otherwise it fails
+// with ErrTxnConflict and the transaction should be retried.
+//
+// Conflicts are detected with sequence numbers, like RocksDB's
+// OptimisticTransactionDB. The writes visible when Commit is called are
+// checked first, which may read sstables. The writes since are checked by the
+// commit pipeline once every batch sequenced so far is visible, and the
+// pipeline then verifies, under its mutex, that no batch was sequenced since,
+// so that no concurrent write, transactional or not, can slip in between the
+// check and the commit. If one was, the new writes are checked in turn. This
+// second check only reads the memtables, and conservatively reports a
+// conflict for the keys overlapping sstables written since the first check,
+// such as flushed memtables or ingested sstables. Only the keys passed to Get, Set, Delete and Merge are
+// tracked; writes to other keys, such as keys observed through iterators,
+// don't cause conflicts.
+//
+// A Txn is not safe for concurrent use. It must be ended by calling either
+// Commit or Rollback.
+type Txn struct {
+	db    *DB
+	snap  *Snapshot
+	batch *Batch
+	// keys holds the keys read or written by the transaction, which are
+	// checked for conflicts at commit.
+	keys map[string]struct{}
+	// checkedSeqNum is the sequence number below which the keys were checked
+	// for conflicts, by checkConflicts and then by checkRecentConflicts.
+	checkedSeqNum uint64
+}
+
+// NewTxn begins an optimistic transaction.
+func (d *DB) NewTxn() *Txn {
+	return &Txn{
+		db:    d,
+		snap:  d.NewSnapshot(),
+		batch: d.NewIndexedBatch(),
+		keys:  make(map[string]struct{}),
+	}
+}
+
+// Get gets the value for the given key, as seen by the transaction. It
+// returns ErrNotFound if the transaction doesn't see the key. The key is
+// checked for conflicts at commit, whether it was found or not.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The
+// returned slice will remain valid until the returned Closer is closed. On
+// success, the caller MUST call closer.Close() or a memory leak will occur.
+func (t *Txn) Get(key []byte) ([]byte, io.Closer, error) {
+	if t.batch == nil {
+		return nil, nil, ErrTxnDone
+	}
+	t.track(key)
+	return t.db.getInternal(context.Background(), key, t.batch, t.snap)
+}
+
+// Set sets the value for the given key. It overwrites any previous value for
+// that key. The write is only visible to the transaction until Commit.
+func (t *Txn) Set(key, value []byte) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	t.track(key)
+	return t.batch.Set(key, value, nil)
+}
+
+// Delete deletes the value for the given key. The write is only visible to
+// the transaction until Commit.
+func (t *Txn) Delete(key []byte) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	t.track(key)
+	return t.batch.Delete(key, nil)
+}
+
+// Merge merges the value for the given key. The write is only visible to the
+// transaction until Commit.
+func (t *Txn) Merge(key, value []byte) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	t.track(key)
+	return t.batch.Merge(key, value, nil)
+}
+
+func (t *Txn) track(key []byte) {
+	if _, ok := t.keys[string(key)]; !ok {
+		t.keys[string(key)] = struct{}{}
+	}
+}
+
+// Commit applies the writes of the transaction, unless one of the keys it
+// read or wrote was written by another batch since the transaction began, in
+// which case ErrTxnConflict is returned and nothing is written. Commit may
+// also conservatively return ErrTxnConflict when it can't rule out such
+// writes, such as when batches are committed concurrently faster than the
+// commit pipeline applies them. The transaction is ended either way.
+func (t *Txn) Commit(opts *WriteOptions) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	defer t.Rollback()
+	if t.batch.Empty() {
+		// Transactions which only read have nothing to commit, and observed a
+		// consistent snapshot.
+		return nil
+	}
+	if err := t.checkConflicts(); err != nil {
+		return err
+	}
+	t.batch.commitCheck = t.checkRecentConflicts
+	err := t.db.Apply(t.batch, opts)
+	if errors.Is(err, errCommitCheckContended) {
+		return ErrTxnConflict
+	}
+	return err
+}
+
+// checkConflicts returns ErrTxnConflict if one of the tracked keys was
+// written since the transaction's snapshot by a visible batch.
+func (t *Txn) checkConflicts() error {
+	seqNum := t.db.mu.versions.visibleSeqNum.Load()
+	for key := range t.keys {
+		latest, ok, err := t.db.latestKeySeqNum([]byte(key), seqNum)
+		if err != nil {
+			return err
+		}
+		if ok && latest >= t.snap.seqNum {
+			return ErrTxnConflict
+		}
+	}
+	t.checkedSeqNum = seqNum
+	return nil
+}
+
+// checkRecentConflicts returns ErrTxnConflict if one of the tracked keys may
+// have been written below seqNum since they were last checked. It is called
+// by the commit pipeline once the batches sequenced before seqNum are
+// visible, possibly several times if batches are sequenced concurrently. To
+// keep the commit latency low, it doesn't read sstables: the memtables are
+// searched for the recent writes, and the keys overlapping sstables holding
+// recent writes conservatively conflict.
+func (t *Txn) checkRecentConflicts(seqNum uint64) error {
+	d := t.db
+	if seqNum <= t.checkedSeqNum {
+		return nil
+	}
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	// Ingested sstables queued as flushables are searched as sstables.
+	mem := make(flushableList, 0, len(readState.memtables))
+	var tables []manifest.LevelSlice
+	for _, m := range readState.memtables {
+		if f, ok := m.flushable.(*ingestedFlushable); ok {
+			tables = append(tables, f.slice)
+			continue
+		}
+		mem = append(mem, m)
+	}
+	tables = append(tables, readState.current.L0SublevelFiles...)
+	for level := 1; level < numLevels; level++ {
+		tables = append(tables, readState.current.Levels[level].Slice())
+	}
+
+	for key := range t.keys {
+		for _, s := range tables {
+			if overlapsRecentTable(d.cmp, s, []byte(key), t.checkedSeqNum) {
+				return ErrTxnConflict
+			}
+		}
+		get := &getIter{
+			ctx:      context.Background(),
+			logger:   d.opts.Logger,
+			cmp:      d.cmp,
+			equal:    d.equal,
+			newIters: d.newIters,
+			snapshot: seqNum,
+			key:      []byte(key),
+			mem:      mem,
+			// Skip the sstables.
+			level: numLevels,
+		}
+		latest, ok, err := get.latestSeqNum()
+		if err != nil {
+			return err
+		}
+		if ok && latest >= t.checkedSeqNum {
+			return ErrTxnConflict
+		}
+	}
+	t.checkedSeqNum = seqNum
+	return nil
+}
+
+// overlapsRecentTable returns true if one of the sstables of s whose bounds
+// contain key may hold writes at or above seqNum. The sstables of s must not
+// overlap.
+func overlapsRecentTable(cmp Compare, s manifest.LevelSlice, key []byte, seqNum uint64) bool {
+	iter := s.Iter()
+	for f := iter.SeekGE(cmp, key); f != nil && cmp(f.Smallest.UserKey, key) <= 0; f = iter.Next() {
+		if f.LargestSeqNum >= seqNum {
+			return true
+		}
+	}
+	return false
+}
+
+// Rollback discards the writes of the transaction, and ends it. Rolling back
+// an ended transaction is a no-op.
+func (t *Txn) Rollback() {
+	if t.batch == nil {
+		return
+	}
+	_ = t.batch.Close()
+	_ = t.snap.Close()
+	t.batch, t.snap, t.keys = nil, nil, nil
+}
+
+// latestKeySeqNum returns the sequence number of the most recent write to key
+// visible at seqNum, including range deletions covering it, and false if no
+// such write exists.
+func (d *DB) latestKeySeqNum(key []byte, seqNum uint64) (uint64, bool, error) {
+	readState := d.loadReadState()
+	defer readState.unref()
+	get := &getIter{
+		ctx:      context.Background(),
+		logger:   d.opts.Logger,
+		cmp:      d.cmp,
+		equal:    d.equal,
+		newIters: d.newIters,
+		snapshot: seqNum,
+		key:      key,
+		mem:      readState.memtables,
+		l0:       readState.current.L0SublevelFiles,
+		version:  readState.current,
+	}
+	return get.latestSeqNum()
+}
+
+// latestSeqNum returns the sequence number of the first write to the key
+// found by the getIter, including range deletions covering it, and false if
+// no such write exists. It closes the getIter.
+func (g *getIter) latestSeqNum() (uint64, bool, error) {
+	if ikey, _ := g.First(); ikey != nil {
+		latest := ikey.SeqNum()
+		return latest, true, g.Close()
+	}
+	if err := g.Close(); err != nil {
+		return 0, false, err
+	}
+	// The getIter stops at a range deletion covering the key.
+	if g.tombstone != nil {
+		for _, k := range g.tombstone.Keys {
+			if k.VisibleAt(g.snapshot) {
+				return k.SeqNum(), true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
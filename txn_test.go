@@ -0,0 +1,197 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxn(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	get := func(txn *Txn, key string) string {
+		t.Helper()
+		v, closer, err := txn.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+
+	// A transaction reads its own writes and a consistent snapshot.
+	txn := d.NewTxn()
+	require.NoError(t, txn.Set([]byte("b"), []byte("2")))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.Equal(t, "1", get(txn, "a"))
+	require.Equal(t, "2", get(txn, "b"))
+	require.Equal(t, "<not found>", get(txn, "c"))
+	// The transaction read c, which was written since it began.
+	require.ErrorIs(t, txn.Commit(nil), ErrTxnConflict)
+	txn = d.NewTxn()
+	require.Equal(t, "3", get(txn, "c"))
+	require.NoError(t, txn.Set([]byte("b"), []byte("2")))
+	require.NoError(t, txn.Commit(nil))
+	v, closer, err := d.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, "2", string(v))
+	require.NoError(t, closer.Close())
+
+	// A key read by a transaction and written concurrently conflicts, even if
+	// the write was flushed.
+	txn = d.NewTxn()
+	require.Equal(t, "1", get(txn, "a"))
+	require.NoError(t, txn.Set([]byte("d"), []byte("4")))
+	require.NoError(t, d.Set([]byte("a"), []byte("5"), nil))
+	require.NoError(t, d.Flush())
+	require.ErrorIs(t, txn.Commit(nil), ErrTxnConflict)
+	_, _, err = d.Get([]byte("d"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// So do keys written by the transaction, and keys read but not found.
+	txn = d.NewTxn()
+	require.NoError(t, txn.Set([]byte("a"), []byte("6")))
+	require.NoError(t, d.Set([]byte("a"), []byte("7"), nil))
+	require.ErrorIs(t, txn.Commit(nil), ErrTxnConflict)
+	txn = d.NewTxn()
+	require.Equal(t, "<not found>", get(txn, "e"))
+	require.NoError(t, txn.Set([]byte("d"), []byte("4")))
+	require.NoError(t, d.Set([]byte("e"), []byte("8"), nil))
+	require.ErrorIs(t, txn.Commit(nil), ErrTxnConflict)
+
+	// A range deletion covering a tracked key conflicts.
+	txn = d.NewTxn()
+	require.Equal(t, "8", get(txn, "e"))
+	require.NoError(t, txn.Set([]byte("d"), []byte("4")))
+	require.NoError(t, d.DeleteRange([]byte("e"), []byte("f"), nil))
+	require.ErrorIs(t, txn.Commit(nil), ErrTxnConflict)
+
+	// Writes to untracked keys don't conflict.
+	txn = d.NewTxn()
+	require.Equal(t, "7", get(txn, "a"))
+	require.NoError(t, txn.Set([]byte("d"), []byte("4")))
+	require.NoError(t, d.Set([]byte("z"), []byte("9"), nil))
+	require.NoError(t, txn.Commit(nil))
+
+	// A rolled back transaction writes nothing.
+	txn = d.NewTxn()
+	require.NoError(t, txn.Delete([]byte("d")))
+	txn.Rollback()
+	txn.Rollback()
+	require.ErrorIs(t, txn.Set([]byte("d"), nil), ErrTxnDone)
+	require.ErrorIs(t, txn.Commit(nil), ErrTxnDone)
+	v, closer, err = d.Get([]byte("d"))
+	require.NoError(t, err)
+	require.Equal(t, "4", string(v))
+	require.NoError(t, closer.Close())
+}
+
+func TestTxnRecentConflicts(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+
+	// checkRecentConflicts simulates the writes committed between the checks
+	// of Txn.Commit.
+	check := func(write func()) error {
+		txn := d.NewTxn()
+		defer txn.Rollback()
+		txn.track([]byte("a"))
+		require.NoError(t, txn.checkConflicts())
+		write()
+		return txn.checkRecentConflicts(d.mu.versions.visibleSeqNum.Load())
+	}
+	// Untracked writes don't conflict, even if flushed.
+	require.NoError(t, check(func() {}))
+	require.NoError(t, check(func() {
+		require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+		require.NoError(t, d.Flush())
+	}))
+	// Writes found in the memtables conflict.
+	require.ErrorIs(t, check(func() {
+		require.NoError(t, d.Set([]byte("a"), []byte("3"), nil))
+	}), ErrTxnConflict)
+	require.ErrorIs(t, check(func() {
+		require.NoError(t, d.DeleteRange([]byte("0"), []byte("b"), nil))
+	}), ErrTxnConflict)
+	// The keys overlapping sstables written since conservatively conflict.
+	require.ErrorIs(t, check(func() {
+		require.NoError(t, d.Set([]byte("0"), []byte("4"), nil))
+		require.NoError(t, d.Set([]byte("z"), []byte("4"), nil))
+		require.NoError(t, d.Flush())
+	}), ErrTxnConflict)
+}
+
+func TestTxnConcurrentIncrements(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const workers, increments = 8, 50
+	key := []byte("counter")
+	increment := func() error {
+		for {
+			txn := d.NewTxn()
+			var n uint64
+			v, closer, err := txn.Get(key)
+			if err == nil {
+				n = binary.BigEndian.Uint64(v)
+				closer.Close()
+			} else if !errors.Is(err, ErrNotFound) {
+				txn.Rollback()
+				return err
+			}
+			if err := txn.Set(key, binary.BigEndian.AppendUint64(nil, n+1)); err != nil {
+				txn.Rollback()
+				return err
+			}
+			if err := txn.Commit(nil); !errors.Is(err, ErrTxnConflict) {
+				return err
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				if err := increment(); err != nil {
+					errs <- err
+					return
+				}
+				// Interleave non-transactional writes, which must not
+				// prevent transactions from committing.
+				if err := d.Set([]byte("other"), nil, nil); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	v, closer, err := d.Get(key)
+	require.NoError(t, err)
+	require.Equal(t, uint64(workers*increments), binary.BigEndian.Uint64(v))
+	require.NoError(t, closer.Close())
+}
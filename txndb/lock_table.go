@@ -0,0 +1,292 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package txndb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+)
+
+var (
+	// ErrLockConflict is returned when a lock can't be acquired because a
+	// conflicting lock is held by another transaction, and the wait policy
+	// doesn't allow waiting for it.
+	ErrLockConflict = errors.New("txndb: lock held by another transaction")
+
+	// ErrLockTimeout is returned when a lock couldn't be acquired within the
+	// lock timeout.
+	ErrLockTimeout = errors.New("txndb: timed out waiting for lock")
+
+	// ErrDeadlock is returned when waiting for a lock would deadlock, ie, when
+	// a transaction holding a conflicting lock is itself waiting, directly or
+	// not, for a lock held by the waiting transaction. The transaction which
+	// detects the deadlock is the one failing, and should be rolled back.
+	ErrDeadlock = errors.New("txndb: deadlock detected")
+)
+
+// LockMode is the mode in which a lock is held.
+type LockMode int8
+
+const (
+	// LockShared locks may be held by several transactions at once.
+	LockShared LockMode = iota
+	// LockExclusive locks conflict with all locks held by other transactions.
+	LockExclusive
+)
+
+// String implements fmt.Stringer.
+func (m LockMode) String() string {
+	switch m {
+	case LockShared:
+		return "shared"
+	case LockExclusive:
+		return "exclusive"
+	default:
+		return "unknown"
+	}
+}
+
+// lock is a lock held on a single key, or on the span of keys [start, end).
+type lock struct {
+	owner *Txn
+	mode  LockMode
+	start []byte
+	// end is nil for the lock of a single key.
+	end []byte
+}
+
+// lockTable is an in-memory table of the locks held by transactions. Point
+// locks are indexed by key, while range locks are kept in a slice and
+// checked linearly, on the assumption that few are held at once.
+//
+// Transactions waiting for a lock wait for the table to change, ie, for any
+// lock to be released, and then check again. The table maintains the
+// waits-for graph of the waiting transactions to detect deadlocks.
+type lockTable struct {
+	cmp pebble.Compare
+
+	mu struct {
+		sync.Mutex
+		points map[string][]*lock
+		ranges []*lock
+		// changed is closed, and replaced, whenever locks are released.
+		changed chan struct{}
+		// waitsFor holds, for each waiting transaction, the transactions
+		// holding the locks it's waiting for.
+		waitsFor map[*Txn][]*Txn
+	}
+}
+
+func newLockTable(cmp pebble.Compare) *lockTable {
+	t := &lockTable{cmp: cmp}
+	t.mu.points = make(map[string][]*lock)
+	t.mu.changed = make(chan struct{})
+	t.mu.waitsFor = make(map[*Txn][]*Txn)
+	return t
+}
+
+// overlaps returns true if the two locks cover a common key.
+func (t *lockTable) overlaps(a, b *lock) bool {
+	switch {
+	case a.end == nil && b.end == nil:
+		return t.cmp(a.start, b.start) == 0
+	case a.end == nil:
+		return t.cmp(b.start, a.start) <= 0 && t.cmp(a.start, b.end) < 0
+	case b.end == nil:
+		return t.cmp(a.start, b.start) <= 0 && t.cmp(b.start, a.end) < 0
+	default:
+		return t.cmp(a.start, b.end) < 0 && t.cmp(b.start, a.end) < 0
+	}
+}
+
+// covers returns true if a covers all the keys of b.
+func (t *lockTable) covers(a, b *lock) bool {
+	if a.end == nil {
+		return b.end == nil && t.cmp(a.start, b.start) == 0
+	}
+	if b.end == nil {
+		return t.cmp(a.start, b.start) <= 0 && t.cmp(b.start, a.end) < 0
+	}
+	return t.cmp(a.start, b.start) <= 0 && t.cmp(b.end, a.end) <= 0
+}
+
+// forEachOverlapping calls fn for every held lock overlapping l. Iteration
+// stops if fn returns false.
+//
+// t.mu must be held.
+func (t *lockTable) forEachOverlapping(l *lock, fn func(*lock) bool) {
+	if l.end == nil {
+		for _, h := range t.mu.points[string(l.start)] {
+			if !fn(h) {
+				return
+			}
+		}
+	} else {
+		for _, hs := range t.mu.points {
+			if len(hs) > 0 && t.overlaps(l, hs[0]) {
+				for _, h := range hs {
+					if !fn(h) {
+						return
+					}
+				}
+			}
+		}
+	}
+	for _, h := range t.mu.ranges {
+		if t.overlaps(l, h) && !fn(h) {
+			return
+		}
+	}
+}
+
+// blockers returns the transactions other than l's owner holding locks
+// conflicting with l, and whether l's owner already holds a lock covering l
+// in a mode at least as strong.
+//
+// t.mu must be held.
+func (t *lockTable) blockers(l *lock) (blockers []*Txn, held bool) {
+	t.forEachOverlapping(l, func(h *lock) bool {
+		if h.owner == l.owner {
+			if h.mode >= l.mode && t.covers(h, l) {
+				held = true
+			}
+			return true
+		}
+		if h.mode == LockExclusive || l.mode == LockExclusive {
+			for _, b := range blockers {
+				if b == h.owner {
+					return true
+				}
+			}
+			blockers = append(blockers, h.owner)
+		}
+		return true
+	})
+	return blockers, held
+}
+
+// waitsForPath returns true if from waits, directly or not, for to.
+//
+// t.mu must be held.
+func (t *lockTable) waitsForPath(from, to *Txn, visited map[*Txn]bool) bool {
+	if from == to {
+		return true
+	}
+	if visited[from] {
+		return false
+	}
+	visited[from] = true
+	for _, next := range t.mu.waitsFor[from] {
+		if t.waitsForPath(next, to, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire acquires the lock l for its owner, waiting for conflicting locks
+// to be released according to the given policy.
+func (t *lockTable) acquire(l *lock, policy WaitPolicy, timeout time.Duration) error {
+	var timer <-chan time.Time
+	t.mu.Lock()
+	defer func() {
+		delete(t.mu.waitsFor, l.owner)
+		t.mu.Unlock()
+	}()
+	for {
+		blockers, held := t.blockers(l)
+		if len(blockers) == 0 {
+			if !held {
+				t.add(l)
+			}
+			return nil
+		}
+		if policy == WaitNever {
+			return ErrLockConflict
+		}
+		visited := make(map[*Txn]bool)
+		for _, b := range blockers {
+			if t.waitsForPath(b, l.owner, visited) {
+				return ErrDeadlock
+			}
+		}
+		t.mu.waitsFor[l.owner] = blockers
+
+		if timeout > 0 && timer == nil {
+			timer = time.After(timeout)
+		}
+		changed := t.mu.changed
+		t.mu.Unlock()
+		select {
+		case <-changed:
+			t.mu.Lock()
+		case <-timer:
+			t.mu.Lock()
+			return ErrLockTimeout
+		}
+	}
+}
+
+// add records the lock l as held.
+//
+// t.mu must be held.
+func (t *lockTable) add(l *lock) {
+	if l.end == nil {
+		t.mu.points[string(l.start)] = append(t.mu.points[string(l.start)], l)
+	} else {
+		t.mu.ranges = append(t.mu.ranges, l)
+	}
+	l.owner.locks = append(l.owner.locks, l)
+}
+
+// releaseAll releases all the locks held by the given transaction, and wakes
+// up the waiting transactions.
+func (t *lockTable) releaseAll(owner *Txn) {
+	if len(owner.locks) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, l := range owner.locks {
+		if l.end == nil {
+			hs := removeLock(t.mu.points[string(l.start)], l)
+			if len(hs) == 0 {
+				delete(t.mu.points, string(l.start))
+			} else {
+				t.mu.points[string(l.start)] = hs
+			}
+		} else {
+			t.mu.ranges = removeLock(t.mu.ranges, l)
+		}
+	}
+	owner.locks = nil
+	close(t.mu.changed)
+	t.mu.changed = make(chan struct{})
+}
+
+// numLocks returns the number of held locks.
+func (t *lockTable) numLocks() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.mu.ranges)
+	for _, hs := range t.mu.points {
+		n += len(hs)
+	}
+	return n
+}
+
+func removeLock(locks []*lock, l *lock) []*lock {
+	for i := range locks {
+		if locks[i] == l {
+			locks[i] = locks[len(locks)-1]
+			locks[len(locks)-1] = nil
+			return locks[:len(locks)-1]
+		}
+	}
+	return locks
+}
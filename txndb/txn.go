@@ -0,0 +1,224 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package txndb provides pessimistic transactions on top of a pebble.DB.
+// Transactions lock the keys and spans of keys they access in an in-memory
+// lock table, so that conflicting transactions wait for each other instead of
+// failing at commit like pebble.Txn does. Both implement
+// pebble.Transaction.
+package txndb
+
+import (
+	"io"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+)
+
+// WaitPolicy configures how a transaction behaves when a lock it requests is
+// held by another transaction.
+type WaitPolicy int8
+
+const (
+	// WaitBlock waits for the conflicting locks to be released, up to the lock
+	// timeout if one is set. Waiting fails with ErrDeadlock if it would
+	// deadlock.
+	WaitBlock WaitPolicy = iota
+	// WaitNever fails immediately with ErrLockConflict.
+	WaitNever
+)
+
+// Options configure a DB.
+type Options struct {
+	// Comparer is the comparer of the wrapped pebble.DB, used to order the
+	// keys of range locks. The default is pebble.DefaultComparer.
+	Comparer *pebble.Comparer
+
+	// WaitPolicy is the default wait policy of transactions.
+	WaitPolicy WaitPolicy
+
+	// LockTimeout is the default maximum time a transaction waits for a lock
+	// with the WaitBlock policy. Zero waits indefinitely.
+	LockTimeout time.Duration
+}
+
+// TxnOptions configure a transaction. The zero value uses the defaults of the
+// DB.
+type TxnOptions struct {
+	// WaitPolicy, if non-nil, overrides Options.WaitPolicy.
+	WaitPolicy *WaitPolicy
+
+	// LockTimeout, if non-zero, overrides Options.LockTimeout.
+	LockTimeout time.Duration
+}
+
+// DB wraps a pebble.DB to provide pessimistic transactions. Writes performed
+// directly on the wrapped DB, rather than through transactions, ignore
+// locks.
+type DB struct {
+	db    *pebble.DB
+	opts  Options
+	locks *lockTable
+}
+
+// New returns a DB providing transactions on top of db. Closing db remains
+// the responsibility of the caller, once all transactions have ended.
+func New(db *pebble.DB, opts Options) *DB {
+	if opts.Comparer == nil {
+		opts.Comparer = pebble.DefaultComparer
+	}
+	return &DB{
+		db:    db,
+		opts:  opts,
+		locks: newLockTable(opts.Comparer.Compare),
+	}
+}
+
+// Begin begins a transaction. The transaction must be ended by calling
+// either Commit or Rollback, which release its locks.
+func (d *DB) Begin(opts *TxnOptions) *Txn {
+	t := &Txn{
+		d:           d,
+		batch:       d.db.NewIndexedBatch(),
+		waitPolicy:  d.opts.WaitPolicy,
+		lockTimeout: d.opts.LockTimeout,
+	}
+	if opts != nil {
+		if opts.WaitPolicy != nil {
+			t.waitPolicy = *opts.WaitPolicy
+		}
+		if opts.LockTimeout != 0 {
+			t.lockTimeout = opts.LockTimeout
+		}
+	}
+	return t
+}
+
+// Txn is a pessimistic transaction. Its writes are buffered in an indexed
+// batch, which is applied to the DB at commit, and it holds exclusive locks
+// on the keys it writes until it ends. Reads observe the latest committed
+// state of the DB along with the transaction's own writes; reads of keys
+// locked by the transaction, eg through GetForUpdate, are thus repeatable.
+//
+// A Txn is not safe for concurrent use. Once it's ended, its methods return
+// pebble.ErrTxnDone.
+type Txn struct {
+	d           *DB
+	batch       *pebble.Batch
+	waitPolicy  WaitPolicy
+	lockTimeout time.Duration
+	// locks holds the locks held by the transaction. It's modified with the
+	// lock table's mutex held.
+	locks []*lock
+}
+
+var _ pebble.Transaction = (*Txn)(nil)
+
+func (t *Txn) acquire(mode LockMode, start, end []byte) error {
+	if t.batch == nil {
+		return pebble.ErrTxnDone
+	}
+	l := &lock{owner: t, mode: mode, start: append([]byte(nil), start...)}
+	if end != nil {
+		l.end = append([]byte(nil), end...)
+	}
+	return t.d.locks.acquire(l, t.waitPolicy, t.lockTimeout)
+}
+
+// LockKey locks the given key in the given mode until the transaction ends.
+func (t *Txn) LockKey(key []byte, mode LockMode) error {
+	return t.acquire(mode, key, nil)
+}
+
+// LockRange locks the span of keys [start, end) in the given mode until the
+// transaction ends. A range lock conflicts with the locks of the keys within
+// the span, whether they exist or not.
+func (t *Txn) LockRange(start, end []byte, mode LockMode) error {
+	if t.d.opts.Comparer.Compare(start, end) >= 0 {
+		return errors.Errorf("txndb: invalid lock range [%q, %q)", start, end)
+	}
+	return t.acquire(mode, start, end)
+}
+
+// Get gets the value for the given key without locking it. It returns
+// pebble.ErrNotFound if the key doesn't exist.
+//
+// The returned slice remains valid until the returned Closer is closed. On
+// success, the caller MUST call closer.Close() or a memory leak will occur.
+func (t *Txn) Get(key []byte) ([]byte, io.Closer, error) {
+	if t.batch == nil {
+		return nil, nil, pebble.ErrTxnDone
+	}
+	return t.batch.Get(key)
+}
+
+// GetForUpdate locks the given key exclusively, and then gets its value. It
+// returns pebble.ErrNotFound if the key doesn't exist, in which case the
+// lock is still held.
+func (t *Txn) GetForUpdate(key []byte) ([]byte, io.Closer, error) {
+	if err := t.LockKey(key, LockExclusive); err != nil {
+		return nil, nil, err
+	}
+	return t.batch.Get(key)
+}
+
+// Set locks the given key exclusively, and sets its value.
+func (t *Txn) Set(key, value []byte) error {
+	if err := t.LockKey(key, LockExclusive); err != nil {
+		return err
+	}
+	return t.batch.Set(key, value, nil)
+}
+
+// Delete locks the given key exclusively, and deletes it.
+func (t *Txn) Delete(key []byte) error {
+	if err := t.LockKey(key, LockExclusive); err != nil {
+		return err
+	}
+	return t.batch.Delete(key, nil)
+}
+
+// Merge locks the given key exclusively, and merges the value into it.
+func (t *Txn) Merge(key, value []byte) error {
+	if err := t.LockKey(key, LockExclusive); err != nil {
+		return err
+	}
+	return t.batch.Merge(key, value, nil)
+}
+
+// DeleteRange locks the span of keys [start, end) exclusively, and deletes
+// the keys within it.
+func (t *Txn) DeleteRange(start, end []byte) error {
+	if err := t.LockRange(start, end, LockExclusive); err != nil {
+		return err
+	}
+	return t.batch.DeleteRange(start, end, nil)
+}
+
+// Commit applies the writes of the transaction, and releases its locks.
+func (t *Txn) Commit(opts *pebble.WriteOptions) error {
+	if t.batch == nil {
+		return pebble.ErrTxnDone
+	}
+	defer t.end()
+	if t.batch.Empty() {
+		return nil
+	}
+	return t.d.db.Apply(t.batch, opts)
+}
+
+// Rollback discards the writes of the transaction, and releases its locks.
+// Rolling back an ended transaction is a no-op.
+func (t *Txn) Rollback() {
+	if t.batch != nil {
+		t.end()
+	}
+}
+
+func (t *Txn) end() {
+	t.d.locks.releaseAll(t)
+	_ = t.batch.Close()
+	t.batch = nil
+}
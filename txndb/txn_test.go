@@ -0,0 +1,193 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package txndb
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T, opts Options) *DB {
+	db, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, db.Close()) })
+	return New(db, opts)
+}
+
+func getValue(t *testing.T, get func([]byte) ([]byte, io.Closer, error), key string) string {
+	t.Helper()
+	v, closer, err := get([]byte(key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return "<not found>"
+	}
+	require.NoError(t, err)
+	defer closer.Close()
+	return string(v)
+}
+
+func TestTxn(t *testing.T) {
+	d := openTestDB(t, Options{})
+
+	t1 := d.Begin(nil)
+	require.NoError(t, t1.Set([]byte("a"), []byte("1")))
+	require.Equal(t, "1", getValue(t, t1.Get, "a"))
+	require.Equal(t, "<not found>", getValue(t, d.db.Get, "a"))
+	require.NoError(t, t1.Commit(nil))
+	require.Equal(t, "1", getValue(t, d.db.Get, "a"))
+	require.ErrorIs(t, t1.Set([]byte("b"), nil), pebble.ErrTxnDone)
+	require.ErrorIs(t, t1.Commit(nil), pebble.ErrTxnDone)
+
+	t2 := d.Begin(nil)
+	require.NoError(t, t2.Delete([]byte("a")))
+	require.NoError(t, t2.DeleteRange([]byte("c"), []byte("d")))
+	require.Equal(t, "<not found>", getValue(t, t2.Get, "a"))
+	t2.Rollback()
+	t2.Rollback()
+	require.Equal(t, "1", getValue(t, d.db.Get, "a"))
+	require.Equal(t, 0, d.locks.numLocks())
+}
+
+func TestTxnWaitNever(t *testing.T) {
+	d := openTestDB(t, Options{WaitPolicy: WaitNever})
+
+	t1 := d.Begin(nil)
+	t2 := d.Begin(nil)
+	require.NoError(t, t1.LockRange([]byte("b"), []byte("d"), LockExclusive))
+	require.ErrorIs(t, t2.Set([]byte("c"), nil), ErrLockConflict)
+	require.ErrorIs(t, t2.LockRange([]byte("a"), []byte("c"), LockShared), ErrLockConflict)
+	require.NoError(t, t2.Set([]byte("d"), nil))
+	require.NoError(t, t2.LockRange([]byte("a"), []byte("b"), LockExclusive))
+
+	// Shared locks are compatible with each other, but not with exclusive
+	// locks.
+	require.NoError(t, t1.LockKey([]byte("x"), LockShared))
+	require.NoError(t, t2.LockKey([]byte("x"), LockShared))
+	require.ErrorIs(t, t2.LockKey([]byte("x"), LockExclusive), ErrLockConflict)
+	require.ErrorIs(t, t1.LockRange([]byte("w"), []byte("y"), LockExclusive), ErrLockConflict)
+
+	// Locks are reentrant.
+	require.NoError(t, t1.LockKey([]byte("c"), LockExclusive))
+	require.NoError(t, t1.Set([]byte("b"), nil))
+
+	require.NoError(t, t1.Commit(nil))
+	require.NoError(t, t2.LockKey([]byte("x"), LockExclusive))
+	require.NoError(t, t2.Set([]byte("c"), nil))
+	require.NoError(t, t2.Commit(nil))
+	require.Equal(t, 0, d.locks.numLocks())
+}
+
+func TestTxnWaitBlock(t *testing.T) {
+	d := openTestDB(t, Options{})
+
+	t1 := d.Begin(nil)
+	require.NoError(t, t1.Set([]byte("a"), []byte("1")))
+	done := make(chan string)
+	go func() {
+		t2 := d.Begin(nil)
+		defer t2.Rollback()
+		v, closer, err := t2.GetForUpdate([]byte("a"))
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- string(v)
+		closer.Close()
+	}()
+	select {
+	case <-done:
+		t.Fatal("lock acquired while held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.NoError(t, t1.Commit(nil))
+	require.Equal(t, "1", <-done)
+
+	// The lock timeout bounds the wait.
+	never := WaitNever
+	t3 := d.Begin(&TxnOptions{WaitPolicy: &never})
+	t4 := d.Begin(&TxnOptions{LockTimeout: time.Millisecond})
+	require.NoError(t, t3.Set([]byte("a"), nil))
+	require.ErrorIs(t, t4.Set([]byte("a"), nil), ErrLockTimeout)
+	t3.Rollback()
+	require.NoError(t, t4.Set([]byte("a"), nil))
+	t4.Rollback()
+}
+
+func TestTxnDeadlock(t *testing.T) {
+	d := openTestDB(t, Options{})
+
+	t1 := d.Begin(nil)
+	t2 := d.Begin(nil)
+	require.NoError(t, t1.Set([]byte("a"), nil))
+	require.NoError(t, t2.LockRange([]byte("b"), []byte("c"), LockExclusive))
+
+	errC := make(chan error)
+	go func() { errC <- t1.Set([]byte("b"), nil) }()
+	// Wait for t1 to be waiting for t2.
+	require.Eventually(t, func() bool {
+		d.locks.mu.Lock()
+		defer d.locks.mu.Unlock()
+		return len(d.locks.mu.waitsFor[t1]) == 1
+	}, 10*time.Second, time.Millisecond)
+
+	require.ErrorIs(t, t2.Set([]byte("a"), nil), ErrDeadlock)
+	t2.Rollback()
+	require.NoError(t, <-errC)
+	require.NoError(t, t1.Commit(nil))
+}
+
+func TestTxnConcurrentIncrements(t *testing.T) {
+	d := openTestDB(t, Options{})
+
+	const workers, increments = 8, 50
+	key := []byte("counter")
+	increment := func() error {
+		txn := d.Begin(nil)
+		defer txn.Rollback()
+		var n uint64
+		v, closer, err := txn.GetForUpdate(key)
+		if err == nil {
+			n = binary.BigEndian.Uint64(v)
+			closer.Close()
+		} else if !errors.Is(err, pebble.ErrNotFound) {
+			return err
+		}
+		if err := txn.Set(key, binary.BigEndian.AppendUint64(nil, n+1)); err != nil {
+			return err
+		}
+		return txn.Commit(nil)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				if err := increment(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	v, closer, err := d.db.Get(key)
+	require.NoError(t, err)
+	require.Equal(t, uint64(workers*increments), binary.BigEndian.Uint64(v))
+	require.NoError(t, closer.Close())
+}
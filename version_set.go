@@ -11,9 +11,11 @@ import (
 	"math"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/failpoint"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/manifest"
@@ -70,6 +72,10 @@ type versionSet struct {
 	// Dynamic base level allows the dynamic base level computation to be
 	// disabled. Used by tests which want to create specific LSM structures.
 	dynamicBaseLevel bool
+	// fence, if set, is called with the manifest lock held before each version
+	// edit is logged, and fails the edit if it returns an error. See
+	// Options.Experimental.LockFencing.
+	fence func() error
 
 	// Mutable fields.
 	versions versionList
@@ -100,10 +106,20 @@ type versionSet struct {
 	// load.
 	fileBackingMap map[base.DiskFileNum]*fileBacking
 
+	// blobFiles holds the blob files referenced by the backing tables that are
+	// not obsolete. The blob files are added to obsoleteBlobFiles once no
+	// backing table references them.
+	blobFiles         map[base.DiskFileNum]*blobFileMetadata
+	obsoleteBlobFiles []fileInfo
+
 	// minUnflushedLogNum is the smallest WAL log file number corresponding to
 	// mutations that have not been flushed to an sstable.
 	minUnflushedLogNum FileNum
 
+	// applicationMetadata is the latest application metadata persisted in a
+	// version edit. See DB.FlushWithApplicationMetadata.
+	applicationMetadata []byte
+
 	// The next file number. A single counter is used to assign file numbers
 	// for the WAL, MANIFEST, sstable, and OPTIONS files.
 	nextFileNum FileNum
@@ -118,6 +134,11 @@ type versionSet struct {
 
 	writing    bool
 	writerCond sync.Cond
+	// frozen is true while version changes are frozen by FreezeLSMs, during
+	// which logLock waits but logLockForRead doesn't. frozenAt is the time the
+	// freeze began.
+	frozen   bool
+	frozenAt time.Time
 	// State for deciding when to write a snapshot. Protected by mu.
 	rotationHelper record.RotationHelper
 }
@@ -141,6 +162,7 @@ func (vs *versionSet) init(
 	vs.obsoleteFn = vs.addObsoleteLocked
 	vs.zombieTables = make(map[base.DiskFileNum]uint64)
 	vs.fileBackingMap = make(map[base.DiskFileNum]*fileBacking)
+	vs.blobFiles = make(map[base.DiskFileNum]*blobFileMetadata)
 	vs.nextFileNum = 1
 	vs.manifestMarker = marker
 	vs.setCurrent = setCurrent
@@ -168,7 +190,7 @@ func (vs *versionSet) create(
 	// Note that a "snapshot" version edit is written to the manifest when it is
 	// created.
 	vs.manifestFileNum = vs.getNextFileNum()
-	err = vs.createManifest(vs.dirname, vs.manifestFileNum, vs.minUnflushedLogNum, vs.nextFileNum)
+	err = vs.createManifest(vs.dirname, vs.manifestFileNum, vs.minUnflushedLogNum, vs.nextFileNum, nil /* applicationMetadata */)
 	if err == nil {
 		if err = vs.manifest.Flush(); err != nil {
 			vs.opts.Logger.Fatalf("MANIFEST flush failed: %v", err)
@@ -255,6 +277,9 @@ func (vs *versionSet) load(
 		if ve.MinUnflushedLogNum != 0 {
 			vs.minUnflushedLogNum = ve.MinUnflushedLogNum
 		}
+		if ve.ApplicationMetadata != nil {
+			vs.applicationMetadata = ve.ApplicationMetadata
+		}
 		if ve.NextFileNum != 0 {
 			vs.nextFileNum = ve.NextFileNum
 		}
@@ -313,6 +338,19 @@ func (vs *versionSet) load(
 	newVersion.L0Sublevels.InitCompactingFileInfo(nil /* in-progress compactions */)
 	vs.append(newVersion)
 
+	// Record the references of the backing tables of the version to the blob
+	// files.
+	backings := make(map[*fileBacking]struct{})
+	for _, l := range newVersion.Levels {
+		iter := l.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if _, ok := backings[f.FileBacking]; !ok {
+				backings[f.FileBacking] = struct{}{}
+				vs.addBlobReferencesLocked(f.FileBacking)
+			}
+		}
+	}
+
 	for i := range vs.metrics.Levels {
 		l := &vs.metrics.Levels[i]
 		l.NumFiles = int64(newVersion.Levels[i].Len())
@@ -344,8 +382,18 @@ func (vs *versionSet) close() error {
 // DB.mu must be held when calling this method, but the mutex may be dropped and
 // re-acquired during the course of this method.
 func (vs *versionSet) logLock() {
-	// Wait for any existing writing to the manifest to complete, then mark the
-	// manifest as busy.
+	// Wait for any existing writing to the manifest to complete, and for the
+	// LSM to be thawed, then mark the manifest as busy.
+	for vs.writing || vs.frozen {
+		vs.writerCond.Wait()
+	}
+	vs.writing = true
+}
+
+// logLockForRead is like logLock, but doesn't wait for the LSM to be thawed.
+// It must only be used to read the current version and the state of the
+// manifest consistently, and released by a call to logUnlock.
+func (vs *versionSet) logLockForRead() {
 	for vs.writing {
 		vs.writerCond.Wait()
 	}
@@ -360,7 +408,9 @@ func (vs *versionSet) logUnlock() {
 		vs.opts.Logger.Fatalf("MANIFEST not locked for writing")
 	}
 	vs.writing = false
-	vs.writerCond.Signal()
+	// Broadcast rather than signal, since the waiters of logLock,
+	// logLockForRead and freezeLSM wait for different conditions.
+	vs.writerCond.Broadcast()
 }
 
 // logAndApply logs the version edit to the manifest, applies the version edit
@@ -483,12 +533,24 @@ func (vs *versionSet) logAndApply(
 	// to be called.
 	minUnflushedLogNum := vs.minUnflushedLogNum
 	nextFileNum := vs.nextFileNum
+	applicationMetadata := vs.applicationMetadata
 
 	var zombies map[base.DiskFileNum]uint64
+	var fenceErr error
 	if err := func() error {
 		vs.mu.Unlock()
 		defer vs.mu.Lock()
 
+		// The fence is checked while holding the manifest lock, immediately
+		// before the edit is written, so that no other edit is written between
+		// the check and this one. A fenced edit isn't fatal, as nothing was
+		// written.
+		if vs.fence != nil {
+			if fenceErr = vs.fence(); fenceErr != nil {
+				return nil
+			}
+		}
+
 		var err error
 		newVersion, zombies, err = manifest.AccumulateIncompleteAndApplySingleVE(
 			ve, currentVersion, vs.cmp, vs.opts.Comparer.FormatKey,
@@ -500,7 +562,7 @@ func (vs *versionSet) logAndApply(
 		}
 
 		if newManifestFileNum != 0 {
-			if err := vs.createManifest(vs.dirname, newManifestFileNum, minUnflushedLogNum, nextFileNum); err != nil {
+			if err := vs.createManifest(vs.dirname, newManifestFileNum, minUnflushedLogNum, nextFileNum, applicationMetadata); err != nil {
 				vs.opts.EventListener.ManifestCreated(ManifestCreateInfo{
 					JobID:   jobID,
 					Path:    base.MakeFilepath(vs.fs, vs.dirname, fileTypeManifest, newManifestFileNum.DiskFileNum()),
@@ -527,6 +589,9 @@ func (vs *versionSet) logAndApply(
 		if err := vs.manifest.Flush(); err != nil {
 			return errors.Wrap(err, "MANIFEST flush failed")
 		}
+		if err := failpoint.Inject(failpoint.ManifestBeforeSync); err != nil {
+			return errors.Wrap(err, "MANIFEST sync failed")
+		}
 		if err := vs.manifestFile.Sync(); err != nil {
 			return errors.Wrap(err, "MANIFEST sync failed")
 		}
@@ -552,6 +617,9 @@ func (vs *versionSet) logAndApply(
 		vs.opts.Logger.Fatalf("%s", err)
 		return err
 	}
+	if fenceErr != nil {
+		return fenceErr
+	}
 
 	if requireRotation {
 		// Successfully rotated.
@@ -570,11 +638,18 @@ func (vs *versionSet) logAndApply(
 		vs.zombieTables[fileNum] = size
 	}
 
+	// Record the references of the new tables to the blob files before the
+	// installation of the new version releases those of the tables it deletes.
+	vs.addNewBlobReferencesLocked(ve)
+
 	// Install the new version.
 	vs.append(newVersion)
 	if ve.MinUnflushedLogNum != 0 {
 		vs.minUnflushedLogNum = ve.MinUnflushedLogNum
 	}
+	if ve.ApplicationMetadata != nil {
+		vs.applicationMetadata = ve.ApplicationMetadata
+	}
 	if newManifestFileNum != 0 {
 		if vs.manifestFileNum != 0 {
 			vs.obsoleteManifests = append(vs.obsoleteManifests, fileInfo{
@@ -606,15 +681,19 @@ func (vs *versionSet) logAndApply(
 	}
 	vs.metrics.Levels[0].Sublevels = int32(len(newVersion.L0SublevelFiles))
 
+	prevPicker := vs.picker
 	vs.picker = newCompactionPicker(newVersion, vs.opts, inProgress, vs.metrics.levelSizes(), vs.diskAvailBytes)
+	if prev, ok := prevPicker.(*compactionPickerByScore); ok && vs.opts.Experimental.L0ScoreSmoothing > 0 {
+		vs.picker.(*compactionPickerByScore).prevL0Score = prev.calculateL0Score(inProgress).score
+	}
 	if !vs.dynamicBaseLevel {
 		vs.picker.forceBaseLevel1()
 	}
 	return nil
 }
 
-func (vs *versionSet) incrementCompactions(kind compactionKind, extraLevels []*compactionLevel) {
-	switch kind {
+func (vs *versionSet) incrementCompactions(c *compaction) {
+	switch c.kind {
 	case compactionKindDefault:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.DefaultCount++
@@ -629,6 +708,11 @@ func (vs *versionSet) incrementCompactions(kind compactionKind, extraLevels []*c
 	case compactionKindDeleteOnly:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.DeleteOnlyCount++
+		vs.metrics.Compact.QueueDeletedBytes += c.queueDeletedBytes
+		if c.shortLivedExpiredBytes > 0 {
+			vs.metrics.Compact.ShortLivedExpiredCount++
+			vs.metrics.Compact.ShortLivedExpiredBytes += c.shortLivedExpiredBytes
+		}
 
 	case compactionKindElisionOnly:
 		vs.metrics.Compact.Count++
@@ -641,8 +725,13 @@ func (vs *versionSet) incrementCompactions(kind compactionKind, extraLevels []*c
 	case compactionKindRewrite:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.RewriteCount++
+
+	case compactionKindSmallFile:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.SmallFileCount++
+		vs.metrics.Compact.SmallFileInputCount += int64(c.startLevel.files.Len())
 	}
-	if len(extraLevels) > 0 {
+	if len(c.extraLevels) > 0 {
 		vs.metrics.Compact.MultiLevelCount++
 	}
 }
@@ -653,7 +742,7 @@ func (vs *versionSet) incrementCompactionBytes(numBytes int64) {
 
 // createManifest creates a manifest file that contains a snapshot of vs.
 func (vs *versionSet) createManifest(
-	dirname string, fileNum, minUnflushedLogNum, nextFileNum FileNum,
+	dirname string, fileNum, minUnflushedLogNum, nextFileNum FileNum, applicationMetadata []byte,
 ) (err error) {
 	var (
 		filename     = base.MakeFilepath(vs.fs, dirname, fileTypeManifest, fileNum.DiskFileNum())
@@ -702,11 +791,12 @@ func (vs *versionSet) createManifest(
 	// When creating a version snapshot for an existing DB, this snapshot VersionEdit will be
 	// immediately followed by another VersionEdit (being written in logAndApply()). That
 	// VersionEdit always contains a LastSeqNum, so we don't need to include that in the snapshot.
-	// But it does not necessarily include MinUnflushedLogNum, NextFileNum, so we initialize those
-	// using the corresponding fields in the versionSet (which came from the latest preceding
-	// VersionEdit that had those fields).
+	// But it does not necessarily include MinUnflushedLogNum, NextFileNum, ApplicationMetadata, so
+	// we initialize those using the corresponding fields in the versionSet (which came from the
+	// latest preceding VersionEdit that had those fields).
 	snapshot.MinUnflushedLogNum = minUnflushedLogNum
 	snapshot.NextFileNum = nextFileNum
+	snapshot.ApplicationMetadata = applicationMetadata
 
 	w, err1 := manifest.Next()
 	if err1 != nil {
@@ -790,6 +880,7 @@ func (vs *versionSet) addObsoleteLocked(obsolete []*fileBacking) {
 	for i, bs := range obsolete {
 		obsoleteFileInfo[i].fileNum = bs.DiskFileNum
 		obsoleteFileInfo[i].fileSize = bs.Size
+		vs.removeBlobReferencesLocked(bs)
 	}
 
 	if invariants.Enabled {
@@ -83,7 +83,7 @@ func TestLatestRefCounting(t *testing.T) {
 		FileBacking:    f.FileBacking,
 		FileNum:        f2,
 		CreationTime:   time.Now().Unix(),
-		Size:           f.Size / 2,
+		Size:           f.Size - f.Size/2,
 		SmallestSeqNum: f.SmallestSeqNum,
 		LargestSeqNum:  f.LargestSeqNum,
 		Smallest:       base.MakeInternalKey([]byte{'b'}, f.Largest.SeqNum(), InternalKeyKindSet),
@@ -0,0 +1,439 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package latencyfs provides a vfs.FS that injects latency into the operations
+// of another vfs.FS, according to configurable per-operation distributions, so
+// that performance tests can emulate slower disks, eg, cloud block storage.
+package latencyfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// Op is an enum describing the type of an operation.
+type Op uint8
+
+const (
+	// OpCreate describes a create file operation, including ReuseForWrite.
+	OpCreate Op = iota
+	// OpOpen describes a file or directory open operation.
+	OpOpen
+	// OpRemove describes a remove operation, including RemoveAll.
+	OpRemove
+	// OpRename describes a rename operation.
+	OpRename
+	// OpLink describes a hardlink operation.
+	OpLink
+	// OpMkdirAll describes a make directory including parents operation.
+	OpMkdirAll
+	// OpList describes a list directory operation.
+	OpList
+	// OpStat describes a path-based or file stat operation.
+	OpStat
+	// OpRead describes a file read operation.
+	OpRead
+	// OpWrite describes a file write operation.
+	OpWrite
+	// OpSync describes a file sync operation, including SyncData and SyncTo.
+	OpSync
+	// OpPreallocate describes a file preallocate operation.
+	OpPreallocate
+	numOps
+)
+
+var opNames = [numOps]string{
+	OpCreate:      "create",
+	OpOpen:        "open",
+	OpRemove:      "remove",
+	OpRename:      "rename",
+	OpLink:        "link",
+	OpMkdirAll:    "mkdirall",
+	OpList:        "list",
+	OpStat:        "stat",
+	OpRead:        "read",
+	OpWrite:       "write",
+	OpSync:        "sync",
+	OpPreallocate: "preallocate",
+}
+
+// String implements fmt.Stringer.
+func (o Op) String() string {
+	if o >= numOps {
+		return fmt.Sprintf("Op(%d)", o)
+	}
+	return opNames[o]
+}
+
+// ParseOp parses the name of an Op, as returned by Op.String.
+func ParseOp(s string) (Op, error) {
+	for o := Op(0); o < numOps; o++ {
+		if opNames[o] == s {
+			return o, nil
+		}
+	}
+	return 0, errors.Errorf("latencyfs: unknown op %q", s)
+}
+
+// Distribution is a distribution of latencies.
+type Distribution interface {
+	// Sample returns a latency sampled from the distribution using the given
+	// source of randomness.
+	Sample(rng *rand.Rand) time.Duration
+}
+
+// Constant returns a Distribution always returning the latency d.
+func Constant(d time.Duration) Distribution {
+	return constant(d)
+}
+
+type constant time.Duration
+
+func (c constant) Sample(*rand.Rand) time.Duration { return time.Duration(c) }
+
+// Uniform returns a Distribution returning latencies uniformly distributed
+// within [min, max].
+func Uniform(min, max time.Duration) Distribution {
+	if max < min {
+		min, max = max, min
+	}
+	return uniform{min: min, max: max}
+}
+
+type uniform struct {
+	min, max time.Duration
+}
+
+func (u uniform) Sample(rng *rand.Rand) time.Duration {
+	return u.min + time.Duration(rng.Int63n(int64(u.max-u.min)+1))
+}
+
+// Normal returns a Distribution returning latencies normally distributed with
+// the given mean and standard deviation, ie, with a jitter of stddev around
+// the mean. Negative latencies are returned as zero.
+func Normal(mean, stddev time.Duration) Distribution {
+	return normal{mean: mean, stddev: stddev}
+}
+
+type normal struct {
+	mean, stddev time.Duration
+}
+
+func (n normal) Sample(rng *rand.Rand) time.Duration {
+	d := time.Duration(math.Round(rng.NormFloat64()*float64(n.stddev))) + n.mean
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Empirical returns a Distribution returning latencies drawn uniformly from
+// the given samples, eg, latencies observed in production. It panics if there
+// are no samples.
+func Empirical(samples []time.Duration) Distribution {
+	if len(samples) == 0 {
+		panic("latencyfs: empirical distribution without samples")
+	}
+	return empirical(append([]time.Duration(nil), samples...))
+}
+
+type empirical []time.Duration
+
+func (e empirical) Sample(rng *rand.Rand) time.Duration {
+	return e[rng.Intn(len(e))]
+}
+
+// Profile maps operation types to the distributions of the latencies injected
+// into them. Operations without a distribution aren't delayed.
+type Profile map[Op]Distribution
+
+// ReadProfile reads a Profile from a trace of operation latencies, eg,
+// captured from a production disk. Each line of the trace holds the name of
+// an Op (see Op.String) followed by a latency in the format of
+// time.ParseDuration, eg:
+//
+//	sync 1.2ms
+//	read 250us
+//
+// Blank lines, and lines starting with #, are ignored. The latencies of each
+// operation type are injected following their Empirical distribution.
+func ReadProfile(r io.Reader) (Profile, error) {
+	samples := make(map[Op][]time.Duration)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("latencyfs: line %d: expected <op> <latency>: %q", line, text)
+		}
+		op, err := ParseOp(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "latencyfs: line %d", line)
+		}
+		if d < 0 {
+			return nil, errors.Errorf("latencyfs: line %d: negative latency %s", line, d)
+		}
+		samples[op] = append(samples[op], d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	p := make(Profile, len(samples))
+	for op, s := range samples {
+		p[op] = empirical(s)
+	}
+	return p, nil
+}
+
+// LoadProfile reads a Profile from the trace file at the given path. See
+// ReadProfile for the format of the file.
+func LoadProfile(fs vfs.FS, path string) (Profile, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadProfile(f)
+}
+
+// FS implements vfs.FS, injecting latency into its operations.
+type FS struct {
+	fs vfs.FS
+	// dists and rngs are indexed by Op. Each operation type has its own source
+	// of randomness, so that the latencies of an operation type are
+	// deterministic regardless of how other operations interleave with it.
+	dists [numOps]Distribution
+	rngs  [numOps]struct {
+		sync.Mutex
+		*rand.Rand
+	}
+	injected [numOps]atomic.Int64
+	// sleep is time.Sleep, except in tests.
+	sleep func(time.Duration)
+}
+
+var _ vfs.FS = (*FS)(nil)
+
+// Wrap wraps an existing vfs.FS implementation, returning a new vfs.FS
+// implementation that shadows operations to the provided FS after delaying
+// them by latencies sampled from the profile. The latencies are sampled
+// deterministically from the seed for a given sequence of operations of each
+// type.
+func Wrap(fs vfs.FS, profile Profile, seed int64) *FS {
+	l := &FS{fs: fs, sleep: time.Sleep}
+	for op, d := range profile {
+		if op < numOps {
+			l.dists[op] = d
+		}
+	}
+	for i := range l.rngs {
+		l.rngs[i].Rand = rand.New(rand.NewSource(seed + int64(i)))
+	}
+	return l
+}
+
+// Unwrap returns the FS implementation underlying fs.
+// See pebble/vfs.Root.
+func (fs *FS) Unwrap() vfs.FS {
+	return fs.fs
+}
+
+// InjectedLatency returns the total latency injected into operations of the
+// given type.
+func (fs *FS) InjectedLatency(op Op) time.Duration {
+	return time.Duration(fs.injected[op].Load())
+}
+
+// delay delays an operation of the given type.
+func (fs *FS) delay(op Op) {
+	dist := fs.dists[op]
+	if dist == nil {
+		return
+	}
+	rng := &fs.rngs[op]
+	rng.Lock()
+	d := dist.Sample(rng.Rand)
+	rng.Unlock()
+	if d > 0 {
+		fs.injected[op].Add(int64(d))
+		fs.sleep(d)
+	}
+}
+
+// wrapFile wraps a file opened by the underlying FS.
+func (fs *FS) wrapFile(f vfs.File, err error) (vfs.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &latencyFile{File: f, fs: fs}, nil
+}
+
+// Create implements FS.Create.
+func (fs *FS) Create(name string) (vfs.File, error) {
+	fs.delay(OpCreate)
+	return fs.wrapFile(fs.fs.Create(name))
+}
+
+// Link implements FS.Link.
+func (fs *FS) Link(oldname, newname string) error {
+	fs.delay(OpLink)
+	return fs.fs.Link(oldname, newname)
+}
+
+// Open implements FS.Open.
+func (fs *FS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	fs.delay(OpOpen)
+	return fs.wrapFile(fs.fs.Open(name, opts...))
+}
+
+// OpenReadWrite implements FS.OpenReadWrite.
+func (fs *FS) OpenReadWrite(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	fs.delay(OpOpen)
+	return fs.wrapFile(fs.fs.OpenReadWrite(name, opts...))
+}
+
+// OpenDir implements FS.OpenDir.
+func (fs *FS) OpenDir(name string) (vfs.File, error) {
+	fs.delay(OpOpen)
+	return fs.wrapFile(fs.fs.OpenDir(name))
+}
+
+// Remove implements FS.Remove.
+func (fs *FS) Remove(name string) error {
+	fs.delay(OpRemove)
+	return fs.fs.Remove(name)
+}
+
+// RemoveAll implements FS.RemoveAll.
+func (fs *FS) RemoveAll(name string) error {
+	fs.delay(OpRemove)
+	return fs.fs.RemoveAll(name)
+}
+
+// Rename implements FS.Rename.
+func (fs *FS) Rename(oldname, newname string) error {
+	fs.delay(OpRename)
+	return fs.fs.Rename(oldname, newname)
+}
+
+// ReuseForWrite implements FS.ReuseForWrite.
+func (fs *FS) ReuseForWrite(oldname, newname string) (vfs.File, error) {
+	fs.delay(OpCreate)
+	return fs.wrapFile(fs.fs.ReuseForWrite(oldname, newname))
+}
+
+// MkdirAll implements FS.MkdirAll.
+func (fs *FS) MkdirAll(dir string, perm os.FileMode) error {
+	fs.delay(OpMkdirAll)
+	return fs.fs.MkdirAll(dir, perm)
+}
+
+// Lock implements FS.Lock.
+func (fs *FS) Lock(name string) (io.Closer, error) {
+	return fs.fs.Lock(name)
+}
+
+// List implements FS.List.
+func (fs *FS) List(dir string) ([]string, error) {
+	fs.delay(OpList)
+	return fs.fs.List(dir)
+}
+
+// Stat implements FS.Stat.
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	fs.delay(OpStat)
+	return fs.fs.Stat(name)
+}
+
+// PathBase implements FS.PathBase.
+func (fs *FS) PathBase(path string) string {
+	return fs.fs.PathBase(path)
+}
+
+// PathJoin implements FS.PathJoin.
+func (fs *FS) PathJoin(elem ...string) string {
+	return fs.fs.PathJoin(elem...)
+}
+
+// PathDir implements FS.PathDir.
+func (fs *FS) PathDir(path string) string {
+	return fs.fs.PathDir(path)
+}
+
+// GetDiskUsage implements FS.GetDiskUsage.
+func (fs *FS) GetDiskUsage(path string) (vfs.DiskUsage, error) {
+	return fs.fs.GetDiskUsage(path)
+}
+
+// latencyFile implements vfs.File, injecting latency into its operations.
+// Close, Prefetch and Fd aren't delayed.
+type latencyFile struct {
+	vfs.File
+	fs *FS
+}
+
+var _ vfs.File = (*latencyFile)(nil)
+
+func (f *latencyFile) Read(p []byte) (int, error) {
+	f.fs.delay(OpRead)
+	return f.File.Read(p)
+}
+
+func (f *latencyFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.delay(OpRead)
+	return f.File.ReadAt(p, off)
+}
+
+func (f *latencyFile) Write(p []byte) (int, error) {
+	f.fs.delay(OpWrite)
+	return f.File.Write(p)
+}
+
+func (f *latencyFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.delay(OpWrite)
+	return f.File.WriteAt(p, off)
+}
+
+func (f *latencyFile) Preallocate(offset, length int64) error {
+	f.fs.delay(OpPreallocate)
+	return f.File.Preallocate(offset, length)
+}
+
+func (f *latencyFile) Stat() (os.FileInfo, error) {
+	f.fs.delay(OpStat)
+	return f.File.Stat()
+}
+
+func (f *latencyFile) Sync() error {
+	f.fs.delay(OpSync)
+	return f.File.Sync()
+}
+
+func (f *latencyFile) SyncData() error {
+	f.fs.delay(OpSync)
+	return f.File.SyncData()
+}
+
+func (f *latencyFile) SyncTo(length int64) (fullSync bool, err error) {
+	f.fs.delay(OpSync)
+	return f.File.SyncTo(length)
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package latencyfs
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyFS(t *testing.T) {
+	profile := Profile{
+		OpCreate: Constant(time.Millisecond),
+		OpWrite:  Uniform(time.Microsecond, 10*time.Microsecond),
+		OpSync:   Normal(2*time.Millisecond, 500*time.Microsecond),
+	}
+	run := func(seed int64) []time.Duration {
+		fs := Wrap(vfs.NewMem(), profile, seed)
+		var slept []time.Duration
+		fs.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+		f, err := fs.Create("foo")
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			_, err = f.Write([]byte("bar"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, f.Sync())
+		require.NoError(t, f.Close())
+		f, err = fs.Open("foo")
+		require.NoError(t, err)
+		buf := make([]byte, 30)
+		_, err = f.ReadAt(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, strings.Repeat("bar", 10), string(buf))
+		require.NoError(t, f.Close())
+
+		require.Equal(t, time.Millisecond, fs.InjectedLatency(OpCreate))
+		require.Zero(t, fs.InjectedLatency(OpOpen))
+		require.Zero(t, fs.InjectedLatency(OpRead))
+		var writes time.Duration
+		for _, d := range slept[1:11] {
+			require.GreaterOrEqual(t, d, time.Microsecond)
+			require.LessOrEqual(t, d, 10*time.Microsecond)
+			writes += d
+		}
+		require.Equal(t, writes, fs.InjectedLatency(OpWrite))
+		require.Len(t, slept, 12)
+		return slept
+	}
+	// The same seed yields the same latencies.
+	require.Equal(t, run(1), run(1))
+	require.NotEqual(t, run(1), run(2))
+}
+
+func TestDistributions(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	require.Equal(t, time.Second, Constant(time.Second).Sample(rng))
+	require.Equal(t, time.Second, Uniform(time.Second, time.Second).Sample(rng))
+	for i := 0; i < 100; i++ {
+		d := Uniform(2*time.Second, time.Second).Sample(rng)
+		require.True(t, d >= time.Second && d <= 2*time.Second, "%s", d)
+		require.GreaterOrEqual(t, Normal(0, time.Second).Sample(rng), time.Duration(0))
+		d = Empirical([]time.Duration{time.Second, time.Minute}).Sample(rng)
+		require.True(t, d == time.Second || d == time.Minute, "%s", d)
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("trace")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(`# Latencies captured from a production disk.
+sync 1ms
+sync 1ms
+
+read 250us
+`))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	p, err := LoadProfile(mem, "trace")
+	require.NoError(t, err)
+	require.Len(t, p, 2)
+	rng := rand.New(rand.NewSource(1))
+	require.Equal(t, time.Millisecond, p[OpSync].Sample(rng))
+	require.Equal(t, 250*time.Microsecond, p[OpRead].Sample(rng))
+
+	for _, trace := range []string{"sync", "sync 1ms 2ms", "fsync 1ms", "sync 1", "sync -1ms"} {
+		_, err := ReadProfile(strings.NewReader(trace))
+		require.Error(t, err, "%q", trace)
+	}
+	for o := Op(0); o < numOps; o++ {
+		parsed, err := ParseOp(o.String())
+		require.NoError(t, err)
+		require.Equal(t, o, parsed)
+	}
+}
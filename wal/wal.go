@@ -0,0 +1,207 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package wal reads and writes logs in the format of Pebble's write-ahead
+// logs, for applications keeping logs of their own alongside a DB. The logs
+// are sequences of records, which are checksummed and tagged with the number
+// of the log, so that a log file may be recycled for a log with a different
+// number without its previous records being mistaken for the new log's.
+//
+// A Writer batches the syncs requested concurrently into a single sync of the
+// log file, as the DB does for the batches committed concurrently.
+package wal
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LogNum identifies a log. The records of a log are tagged with its number, so
+// a recycled log file must be given a number differing from that of the log
+// previously in the file.
+type LogNum uint64
+
+// Options configure a Writer.
+type Options struct {
+	// BytesPerSync, if positive, makes the log file sync its data in the
+	// background every BytesPerSync bytes written, to smooth out the cost of
+	// the syncs requested by WriteSync.
+	BytesPerSync int
+	// PreallocateSize, if positive, preallocates the log file in chunks of
+	// PreallocateSize bytes as it grows.
+	PreallocateSize int
+	// MinSyncInterval, if non-nil, returns the minimum duration between syncs
+	// of the log file. Syncs requested more frequently are delayed and
+	// batched together, trading latency for fewer syncs.
+	MinSyncInterval func() time.Duration
+	// FsyncLatency, if non-nil, observes the latency of each sync of the log
+	// file.
+	FsyncLatency prometheus.Histogram
+}
+
+// Writer appends records to a log file. It's safe for concurrent use.
+type Writer struct {
+	mu sync.Mutex
+	w  *record.LogWriter
+	// syncQSem bounds the number of syncs waiting for completion, to prevent
+	// the sync queue of the record.LogWriter from overflowing.
+	syncQSem chan struct{}
+}
+
+// Create creates the log file at path for the log with the given number.
+func Create(fs vfs.FS, path string, logNum LogNum, opts Options) (*Writer, error) {
+	f, err := fs.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return newWriter(fs, path, f, logNum, opts)
+}
+
+// Recycle reuses the log file at oldPath, which must no longer be needed, as
+// the log file at path for the log with the given number. Syncing a recycled
+// file is typically faster than syncing a new one, as the file's metadata
+// doesn't change while it's overwritten. The log number must differ from that
+// of the log previously in the file.
+func Recycle(
+	fs vfs.FS, oldPath, path string, logNum LogNum, opts Options,
+) (*Writer, error) {
+	f, err := fs.ReuseForWrite(oldPath, path)
+	if err != nil {
+		return nil, err
+	}
+	return newWriter(fs, path, f, logNum, opts)
+}
+
+func newWriter(
+	fs vfs.FS, path string, f vfs.File, logNum LogNum, opts Options,
+) (*Writer, error) {
+	// Sync the directory, so that the log file is found after a crash once
+	// its records are synced.
+	dir, err := fs.OpenDir(fs.PathDir(path))
+	if err == nil {
+		err = dir.Sync()
+		err = errors.CombineErrors(err, dir.Close())
+	}
+	if err != nil {
+		return nil, errors.CombineErrors(err, f.Close())
+	}
+	f = vfs.NewSyncingFile(f, vfs.SyncingFileOptions{
+		BytesPerSync:    opts.BytesPerSync,
+		PreallocateSize: opts.PreallocateSize,
+	})
+	w := &Writer{
+		syncQSem: make(chan struct{}, record.SyncConcurrency-1),
+	}
+	w.w = record.NewLogWriter(f, base.FileNum(logNum), record.LogWriterConfig{
+		WALMinSyncInterval: opts.MinSyncInterval,
+		WALFsyncLatency:    opts.FsyncLatency,
+		QueueSemChan:       w.syncQSem,
+	})
+	return w, nil
+}
+
+// Write appends a record to the log, returning the offset just past the end
+// of the record. The record isn't synced, and may not even be written to the
+// log file, until the next call to WriteSync or Close.
+func (w *Writer) Write(p []byte) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.WriteRecord(p)
+}
+
+// WriteSync appends a record to the log and waits for the log file to be
+// synced, returning the offset just past the end of the record. The records
+// appended concurrently are synced together.
+func (w *Writer) WriteSync(p []byte) (int64, error) {
+	var wg sync.WaitGroup
+	var syncErr error
+	wg.Add(1)
+	w.syncQSem <- struct{}{}
+	w.mu.Lock()
+	offset, _, err := w.w.SyncRecord(p, &wg, &syncErr)
+	w.mu.Unlock()
+	if err != nil {
+		<-w.syncQSem
+		return -1, err
+	}
+	wg.Wait()
+	return offset, syncErr
+}
+
+// Size returns the size of the log.
+func (w *Writer) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Size()
+}
+
+// Close writes and syncs the records appended to the log, marks the end of
+// the log, and closes the log file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Close()
+}
+
+// Reader reads the records of a log file.
+type Reader struct {
+	f   vfs.File
+	r   *record.Reader
+	buf []byte
+}
+
+// Open opens the log file at path for reading the records of the log with the
+// given number.
+func Open(fs vfs.FS, path string, logNum LogNum) (*Reader, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: f, r: record.NewReader(f, base.FileNum(logNum))}, nil
+}
+
+// Next returns the next record of the log, which is valid until the next call
+// to Next. It returns io.EOF after the last record, which includes reaching
+// the records of the log previously in a recycled file. An error for which
+// record.IsInvalidRecord returns true is a corrupt or torn record, as left at
+// the tail of a log whose writer crashed; Recover skips past it.
+func (r *Reader) Next() ([]byte, error) {
+	rec, err := r.r.Next()
+	if err != nil {
+		return nil, err
+	}
+	r.buf = r.buf[:0]
+	for {
+		if len(r.buf) == cap(r.buf) {
+			r.buf = append(r.buf, 0)[:len(r.buf)]
+		}
+		n, err := rec.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if err == io.EOF {
+			return r.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Recover clears the error returned by Next, so that the next call to Next
+// returns the next intact record, if any. The records between the error and
+// that record are lost.
+func (r *Reader) Recover() {
+	r.r.Recover()
+}
+
+// Close closes the log file.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
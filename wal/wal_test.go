@@ -0,0 +1,86 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package wal
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterReader(t *testing.T) {
+	fs := vfs.NewStrictMem()
+	require.NoError(t, fs.MkdirAll("logs", 0755))
+	root, err := fs.OpenDir("")
+	require.NoError(t, err)
+	require.NoError(t, root.Sync())
+	require.NoError(t, root.Close())
+
+	read := func(path string, logNum LogNum) []string {
+		r, err := Open(fs, path, logNum)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, r.Close()) }()
+		var recs []string
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				return recs
+			}
+			require.NoError(t, err)
+			recs = append(recs, string(rec))
+		}
+	}
+
+	// Records written concurrently with WriteSync are synced before it
+	// returns. Records written with Write aren't.
+	w, err := Create(fs, "logs/1.log", 1, Options{})
+	require.NoError(t, err)
+	var expected []string
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		var recs []string
+		for j := 0; j < 10; j++ {
+			recs = append(recs, fmt.Sprintf("record-%d-%d", i, j))
+		}
+		expected = append(expected, recs...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, rec := range recs {
+				_, err := w.WriteSync([]byte(rec))
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	fs.SetIgnoreSyncs(true)
+	_, err = w.Write([]byte("unsynced"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	fs.ResetToSyncedState()
+	fs.SetIgnoreSyncs(false)
+
+	recs := read("logs/1.log", 1)
+	sort.Strings(expected)
+	sort.Strings(recs)
+	require.Equal(t, expected, recs)
+
+	// The records of a recycled log file's previous log aren't read.
+	w, err = Recycle(fs, "logs/1.log", "logs/2.log", 2, Options{})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	_, err = w.WriteSync([]byte("b"))
+	require.NoError(t, err)
+	// Each record is written in a chunk with an 11-byte header.
+	require.Equal(t, int64(2*(11+1)), w.Size())
+	require.NoError(t, w.Close())
+	require.Equal(t, []string{"a", "b"}, read("logs/2.log", 2))
+}
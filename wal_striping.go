@@ -0,0 +1,357 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// WAL striping spreads the byte stream of a WAL across multiple files, one
+// per stripe, so that syncing the WAL syncs the files in parallel (see
+// Options.Experimental.WALStripeDirs). The stream is split into units of
+// walStripeUnit bytes, assigned to the stripes round-robin: the i-th unit of
+// the stream is the (i/n)-th unit of stripe i%n. Stripe 0 is the usual log
+// file in the WAL directory, and stripe i is the file with the same name in
+// the (i-1)-th WAL stripe directory.
+//
+// The LogWriter is oblivious to the striping, so the records of the WAL, and
+// thus the commits, are in the same order as in an unstriped WAL: reading the
+// WAL reassembles the stream from the stripes, up to the first unit that is
+// missing because its stripe wasn't synced before a crash. Since a sync of the
+// WAL syncs all its stripes, no synced record is lost.
+//
+// The first record of a striped WAL is a header recording the number of
+// stripes and the size of the units. A batch can't be mistaken for the
+// header, as the header is shorter than a batch header, and starts with a
+// sequence number larger than InternalKeySeqNumMax.
+const (
+	walStripeUnitShift = 15
+	walStripeUnit      = 1 << walStripeUnitShift
+	// walStripeHeaderMagic is the prefix of the header record of a striped WAL.
+	walStripeHeaderMagic = "PBLWSTR\xff"
+	walStripeHeaderLen   = len(walStripeHeaderMagic) + 2
+	// maxWALStripes is the maximum number of stripes of a WAL, including the
+	// stripe in the WAL directory.
+	maxWALStripes = 255
+)
+
+// encodeWALStripeHeader encodes the header record of a WAL striped across the
+// given number of stripes.
+func encodeWALStripeHeader(stripes int) []byte {
+	buf := make([]byte, 0, walStripeHeaderLen)
+	buf = append(buf, walStripeHeaderMagic...)
+	return append(buf, byte(stripes), walStripeUnitShift)
+}
+
+// decodeWALStripeHeader decodes the header record of a striped WAL. It returns
+// ok=false if the record isn't a header.
+func decodeWALStripeHeader(rec []byte) (stripes int, unit int64, ok bool) {
+	if len(rec) != walStripeHeaderLen || string(rec[:len(walStripeHeaderMagic)]) != walStripeHeaderMagic {
+		return 0, 0, false
+	}
+	return int(rec[walStripeHeaderLen-2]), 1 << rec[walStripeHeaderLen-1], true
+}
+
+// walStripedFile writes the byte stream of a striped WAL to its stripes. It's
+// written and synced by the flush loop of a single LogWriter, so it doesn't
+// need synchronization.
+type walStripedFile struct {
+	stripes []vfs.File
+	// dirty records which stripes were written since they were last synced.
+	dirty  []bool
+	offset int64
+}
+
+// Write implements io.Writer.
+func (f *walStripedFile) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		unit := f.offset / walStripeUnit
+		stripe := int(unit % int64(len(f.stripes)))
+		m := int(walStripeUnit - f.offset%walStripeUnit)
+		if m > len(p) {
+			m = len(p)
+		}
+		written, err := f.stripes[stripe].Write(p[:m])
+		n += written
+		f.offset += int64(written)
+		f.dirty[stripe] = true
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Sync syncs the stripes written since the last sync, in parallel.
+func (f *walStripedFile) Sync() error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(f.stripes))
+	for i := range f.stripes {
+		if !f.dirty[i] {
+			continue
+		}
+		f.dirty[i] = false
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f.stripes[i].Sync()
+		}(i)
+	}
+	wg.Wait()
+	var err error
+	for i := range errs {
+		err = firstError(err, errs[i])
+	}
+	return err
+}
+
+// Close implements io.Closer.
+func (f *walStripedFile) Close() error {
+	var err error
+	for _, s := range f.stripes {
+		err = firstError(err, s.Close())
+	}
+	return err
+}
+
+// walStripedReader reads the byte stream of a striped WAL from its stripes.
+// A nil stripe is one whose file doesn't exist, which may happen if a crash
+// occurred while the WAL was being created.
+type walStripedReader struct {
+	stripes []vfs.File
+	unit    int64
+	offset  int64
+}
+
+// Read implements io.Reader.
+func (r *walStripedReader) Read(p []byte) (int, error) {
+	unit := r.offset / r.unit
+	stripe := r.stripes[unit%int64(len(r.stripes))]
+	if stripe == nil {
+		return 0, io.EOF
+	}
+	n := r.unit - r.offset%r.unit
+	if n > int64(len(p)) {
+		n = int64(len(p))
+	}
+	stripeOffset := (unit/int64(len(r.stripes)))*r.unit + r.offset%r.unit
+	m, err := stripe.ReadAt(p[:n], stripeOffset)
+	r.offset += int64(m)
+	if err == io.EOF && m > 0 {
+		err = nil
+	}
+	return m, err
+}
+
+// Close implements io.Closer.
+func (r *walStripedReader) Close() error {
+	var err error
+	for _, s := range r.stripes {
+		if s != nil {
+			err = firstError(err, s.Close())
+		}
+	}
+	return err
+}
+
+// openWAL opens the WAL with the given filename and number for reading,
+// reassembling its byte stream from its stripes if it's striped.
+func openWAL(
+	fs vfs.FS, filename string, stripeDirs []string, logNum FileNum,
+) (io.ReadCloser, error) {
+	file, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	// The header of a striped WAL is in the first unit of the stream, which is
+	// the first unit of the stripe in the WAL directory.
+	var peeked, buf bytes.Buffer
+	r, err := record.NewReader(io.TeeReader(file, &peeked), logNum).Next()
+	if err == nil {
+		_, err = io.Copy(&buf, r)
+	}
+	stripes, unit, ok := decodeWALStripeHeader(buf.Bytes())
+	if err != nil || !ok {
+		// The WAL isn't striped, or is empty or corrupt, which is up to the
+		// caller to handle. It's read from the start, the bytes already read
+		// followed by the rest of the file.
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&peeked, file), file}, nil
+	}
+	if stripes-1 > len(stripeDirs) {
+		file.Close()
+		return nil, errors.Errorf("pebble: WAL %s is striped across %d files, but only %d WAL stripe directories are configured",
+			errors.Safe(logNum), errors.Safe(stripes), errors.Safe(len(stripeDirs)))
+	}
+	sr := &walStripedReader{stripes: make([]vfs.File, stripes), unit: unit}
+	sr.stripes[0] = file
+	for i := 1; i < stripes; i++ {
+		sr.stripes[i], err = fs.Open(fs.PathJoin(stripeDirs[i-1], fs.PathBase(filename)))
+		if oserror.IsNotExist(err) {
+			// A missing stripe ends the stream at its first unit.
+			sr.stripes[i], err = nil, nil
+		}
+		if err != nil {
+			sr.Close()
+			return nil, err
+		}
+	}
+	return sr, nil
+}
+
+// createWALStripes creates the stripes of the WAL with the given number in
+// the WAL stripe directories, returning a writer striping the stream of the
+// WAL across them and stripe0, the stripe in the WAL directory. If the WAL
+// isn't striped, stripe0 is returned. The stripes are synced according to
+// walOpts, the options stripe0 was created with, with its preallocation
+// spread across them.
+func (d *DB) createWALStripes(
+	stripe0 vfs.File, logNum base.DiskFileNum, walOpts vfs.SyncingFileOptions,
+) (io.Writer, error) {
+	stripeDirs := d.opts.Experimental.WALStripeDirs
+	if len(stripeDirs) == 0 {
+		return stripe0, nil
+	}
+	f := &walStripedFile{
+		stripes: make([]vfs.File, 1, len(stripeDirs)+1),
+		dirty:   make([]bool, len(stripeDirs)+1),
+	}
+	f.stripes[0] = stripe0
+	syncingOpts := walOpts
+	syncingOpts.PreallocateSize /= len(stripeDirs) + 1
+	for i, dirname := range stripeDirs {
+		file, err := d.opts.FS.Create(base.MakeFilepath(d.opts.FS, dirname, fileTypeLog, logNum))
+		if err == nil {
+			err = d.walStripeDirs[i].Sync()
+		}
+		if err != nil {
+			if file != nil {
+				file.Close()
+			}
+			// Only close the stripes created here.
+			f.stripes[0] = nil
+			for _, s := range f.stripes[1:] {
+				s.Close()
+			}
+			return nil, err
+		}
+		f.stripes = append(f.stripes, vfs.NewSyncingFile(file, syncingOpts))
+	}
+	return f, nil
+}
+
+// writeWALStripeHeader writes the header record of a striped WAL. It must be
+// the first record written to the WAL.
+func (d *DB) writeWALStripeHeader() error {
+	if len(d.opts.Experimental.WALStripeDirs) == 0 {
+		return nil
+	}
+	_, err := d.mu.log.WriteRecord(encodeWALStripeHeader(len(d.opts.Experimental.WALStripeDirs) + 1))
+	return err
+}
+
+// openWALStripeDirs creates and opens the WAL stripe directories.
+func (d *DB) openWALStripeDirs() error {
+	for _, dirname := range d.opts.Experimental.WALStripeDirs {
+		if err := d.opts.FS.MkdirAll(dirname, 0755); err != nil {
+			return err
+		}
+		dir, err := d.opts.FS.OpenDir(dirname)
+		if err != nil {
+			return err
+		}
+		d.walStripeDirs = append(d.walStripeDirs, dir)
+	}
+	return nil
+}
+
+// removeObsoleteWALStripes removes the stripes of the WALs older than
+// minUnflushedLogNum from the WAL stripe directories. The stripes of obsolete
+// WALs are normally removed along with the WALs, but may be left behind by a
+// crash.
+func (d *DB) removeObsoleteWALStripes(minUnflushedLogNum FileNum) error {
+	for _, dirname := range d.opts.Experimental.WALStripeDirs {
+		ls, err := d.opts.FS.List(dirname)
+		if err != nil {
+			return err
+		}
+		for _, filename := range ls {
+			fileType, fileNum, ok := base.ParseFilename(d.opts.FS, filename)
+			if !ok || fileType != fileTypeLog || fileNum.FileNum() >= minUnflushedLogNum {
+				continue
+			}
+			if err := d.opts.FS.Remove(d.opts.FS.PathJoin(dirname, filename)); err != nil && !oserror.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyWAL copies the WAL with the given number to destPath, for a checkpoint.
+// A striped WAL is copied as an unstriped WAL, without its header, so that
+// the checkpoint doesn't depend on the WAL stripe directories.
+func (d *DB) copyWAL(fs vfs.FS, srcPath string, logNum FileNum, destPath string) (err error) {
+	// The WALs of a DB without WAL stripe directories aren't striped.
+	if len(d.opts.Experimental.WALStripeDirs) == 0 {
+		return vfs.Copy(fs, srcPath, destPath)
+	}
+	src, err := openWAL(fs, srcPath, d.opts.Experimental.WALStripeDirs, logNum)
+	if err != nil {
+		return err
+	}
+	defer func() { err = firstError(err, src.Close()) }()
+	if _, ok := src.(*walStripedReader); !ok {
+		return vfs.Copy(fs, srcPath, destPath)
+	}
+
+	dest, err := fs.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer func() { err = firstError(err, dest.Close()) }()
+	w := record.NewWriter(dest)
+	rr := record.NewReader(src, logNum)
+	var buf bytes.Buffer
+	for {
+		r, err := rr.Next()
+		if err == nil {
+			buf.Reset()
+			_, err = io.Copy(&buf, r)
+		}
+		if err != nil {
+			// The WAL may be written concurrently, so it ends at its first
+			// invalid record.
+			if err != io.EOF && !record.IsInvalidRecord(err) {
+				w.Close()
+				return err
+			}
+			break
+		}
+		if _, _, ok := decodeWALStripeHeader(buf.Bytes()); ok {
+			continue
+		}
+		if _, err := w.WriteRecord(buf.Bytes()); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return dest.Sync()
+}
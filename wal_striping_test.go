@@ -0,0 +1,138 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALStripedFile(t *testing.T) {
+	mem := vfs.NewMem()
+	var stripes []vfs.File
+	for i := 0; i < 3; i++ {
+		f, err := mem.Create(fmt.Sprintf("stripe%d", i))
+		require.NoError(t, err)
+		stripes = append(stripes, f)
+	}
+	w := &walStripedFile{stripes: stripes, dirty: make([]bool, len(stripes))}
+
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 5*walStripeUnit+123)
+	rng.Read(data)
+	for p := data; len(p) > 0; {
+		n := rng.Intn(walStripeUnit / 2)
+		if n > len(p) {
+			n = len(p)
+		}
+		// MemFS may scribble over the written buffer in invariants builds.
+		_, err := w.Write(append([]byte(nil), p[:n]...))
+		require.NoError(t, err)
+		p = p[n:]
+	}
+	require.Equal(t, []bool{true, true, true}, w.dirty)
+	require.NoError(t, w.Sync())
+	require.Equal(t, []bool{false, false, false}, w.dirty)
+	require.NoError(t, w.Close())
+
+	read := func(stripes ...string) []byte {
+		r := &walStripedReader{unit: walStripeUnit}
+		for _, name := range stripes {
+			f, err := mem.Open(name)
+			if err != nil {
+				f = nil
+			}
+			r.stripes = append(r.stripes, f)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return b
+	}
+	require.Equal(t, data, read("stripe0", "stripe1", "stripe2"))
+	// The stream ends at the first unit of a missing stripe.
+	require.Equal(t, data[:walStripeUnit], read("stripe0", "missing", "stripe2"))
+}
+
+func TestWALStriping(t *testing.T) {
+	mem := vfs.NewStrictMem()
+	stripeDirs := []string{"stripe1", "stripe2"}
+	opts := &Options{
+		FS:     mem,
+		Logger: panicLogger{},
+	}
+	opts.Experimental.WALStripeDirs = stripeDirs
+	// Striping requires FormatStripedWAL.
+	_, err := Open("", opts)
+	require.Error(t, err)
+	opts.FormatMajorVersion = FormatStripedWAL
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte("v"), 1000)
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%04d", i)) }
+	// The writes fit in the initial memtable, and thus in a single WAL.
+	for i := 0; i < 150; i++ {
+		require.NoError(t, d.Set(key(i), value, Sync))
+	}
+	// All the stripes of the WAL were written.
+	logNum := d.mu.log.queue[len(d.mu.log.queue)-1].fileNum
+	for _, dir := range append([]string{""}, stripeDirs...) {
+		info, err := mem.Stat(base.MakeFilepath(mem, dir, fileTypeLog, logNum))
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, info.Size(), int64(walStripeUnit))
+	}
+	// Writes that aren't synced are lost when crashing.
+	mem.SetIgnoreSyncs(true)
+	for i := 150; i < 200; i++ {
+		require.NoError(t, d.Set(key(i), value, NoSync))
+	}
+	require.NoError(t, d.Close())
+	mem.ResetToSyncedState()
+	mem.SetIgnoreSyncs(false)
+
+	check := func(d *DB, n int) {
+		iter := d.NewIter(nil)
+		var i int
+		for valid := iter.First(); valid; valid = iter.Next() {
+			require.Equal(t, key(i), iter.Key())
+			require.Equal(t, value, iter.Value())
+			i++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, n, i)
+	}
+
+	// A striped WAL can't be replayed without its stripes.
+	_, err = Open("", &Options{FS: mem})
+	require.Error(t, err)
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	check(d, 150)
+	// The stripes of the replayed WAL were removed.
+	for _, dir := range stripeDirs {
+		_, err := mem.Stat(base.MakeFilepath(mem, dir, fileTypeLog, logNum))
+		require.Error(t, err)
+	}
+
+	// A checkpoint copies the WAL unstriped.
+	for i := 150; i < 200; i++ {
+		require.NoError(t, d.Set(key(i), value, Sync))
+	}
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	require.NoError(t, d.Close())
+	d, err = Open("checkpoint", &Options{FS: mem})
+	require.NoError(t, err)
+	check(d, 200)
+	require.NoError(t, d.Close())
+}